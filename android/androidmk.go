@@ -52,6 +52,92 @@ type AndroidMkData struct {
 
 type AndroidMkExtraFunc func(w io.Writer, outputFile Path)
 
+// AndroidMkEntriesProvider is implemented by modules that describe themselves to Make with a list
+// of variables instead of writing the Android.mk fragment.
+type AndroidMkEntriesProvider interface {
+	AndroidMkEntries() AndroidMkEntries
+	BaseModuleName() string
+}
+
+// AndroidMkEntries describes a module to Make as LOCAL_ variables, which are written in the order
+// they were first set.  The variables that are common to all modules, like the name, class,
+// architecture, partition and required modules, are added by the androidmk singleton.
+type AndroidMkEntries struct {
+	Class      string
+	SubName    string
+	OutputFile OptionalPath
+	Disabled   bool
+
+	// Make file that builds the module, defaults to $(BUILD_PREBUILT)
+	Include string
+
+	// functions that add the variables that depend on the module, called after the common
+	// variables have been added
+	ExtraEntries []AndroidMkExtraEntriesFunc
+
+	EntryMap   map[string][]string
+	entryOrder []string
+}
+
+type AndroidMkExtraEntriesFunc func(entries *AndroidMkEntries)
+
+func (a *AndroidMkEntries) SetString(name, value string) {
+	if a.EntryMap == nil {
+		a.EntryMap = make(map[string][]string)
+	}
+	if _, ok := a.EntryMap[name]; !ok {
+		a.entryOrder = append(a.entryOrder, name)
+	}
+	a.EntryMap[name] = []string{value}
+}
+
+func (a *AndroidMkEntries) SetPath(name string, path Path) {
+	a.SetString(name, path.String())
+}
+
+func (a *AndroidMkEntries) SetBoolIfTrue(name string, flag bool) {
+	if flag {
+		a.SetString(name, "true")
+	}
+}
+
+// AddStrings appends the values to the variable, and does nothing if there are no values.
+func (a *AndroidMkEntries) AddStrings(name string, value ...string) {
+	if len(value) == 0 {
+		return
+	}
+	if a.EntryMap == nil {
+		a.EntryMap = make(map[string][]string)
+	}
+	if _, ok := a.EntryMap[name]; !ok {
+		a.entryOrder = append(a.entryOrder, name)
+	}
+	a.EntryMap[name] = append(a.EntryMap[name], value...)
+}
+
+// SetInstalledPath tells Make where Soong installs the output file of the module.
+func (a *AndroidMkEntries) SetInstalledPath(installPath OutputPath) {
+	a.SetString("LOCAL_MODULE_PATH", "$(OUT_DIR)/"+filepath.Dir(installPath.RelPathString()))
+	a.SetString("LOCAL_INSTALLED_MODULE_STEM", installPath.Base())
+}
+
+func (a *AndroidMkEntries) writeEntries(w io.Writer) {
+	for _, name := range a.entryOrder {
+		fmt.Fprintln(w, name+" :=", strings.Join(a.EntryMap[name], " "))
+	}
+}
+
+func (a *AndroidMkEntries) write(w io.Writer) {
+	fmt.Fprintln(w, "\ninclude $(CLEAR_VARS)")
+	a.writeEntries(w)
+
+	include := a.Include
+	if include == "" {
+		include = "$(BUILD_PREBUILT)"
+	}
+	fmt.Fprintln(w, "include", include)
+}
+
 func AndroidMkSingleton() blueprint.Singleton {
 	return &androidMkSingleton{}
 }
@@ -92,11 +178,24 @@ func (c *androidMkSingleton) GenerateBuildActions(ctx blueprint.SingletonContext
 	})
 }
 
+// writeBuildVersionAndroidMk adds the build version properties of the product to build.prop.
+func writeBuildVersionAndroidMk(w io.Writer, config Config) {
+	props := config.BuildVersionProperties()
+	if len(props) == 0 {
+		return
+	}
+
+	fmt.Fprintln(w, "\nADDITIONAL_BUILD_PROPERTIES +=", strings.Join(props, " "))
+}
+
 func translateAndroidMk(ctx blueprint.SingletonContext, mkFile string, mods []Module) error {
 	buf := &bytes.Buffer{}
 
 	fmt.Fprintln(buf, "LOCAL_MODULE_MAKEFILE := $(lastword $(MAKEFILE_LIST))")
 
+	writeBuildPresetAndroidMk(buf, ctx.Config().(Config))
+	writeBuildVersionAndroidMk(buf, ctx.Config().(Config))
+
 	type_stats := make(map[string]int)
 	for _, mod := range mods {
 		err := translateAndroidMkModule(ctx, buf, mod)
@@ -145,29 +244,49 @@ func translateAndroidMk(ctx blueprint.SingletonContext, mkFile string, mods []Mo
 }
 
 func translateAndroidMkModule(ctx blueprint.SingletonContext, w io.Writer, mod blueprint.Module) error {
-	provider, ok := mod.(AndroidMkDataProvider)
-	if !ok {
-		return nil
+	switch provider := mod.(type) {
+	case AndroidMkEntriesProvider:
+		translateAndroidMkEntriesModule(ctx, w, mod, provider)
+	case AndroidMkDataProvider:
+		translateAndroidMkDataModule(ctx, w, mod, provider)
 	}
+	return nil
+}
 
-	name := provider.BaseModuleName()
-	amod := mod.(Module).base()
+// ExportedToMake returns true if the module is exported to Make, which installs it if the
+// product lists it.
+func (a *ModuleBase) ExportedToMake() bool {
+	return shouldExportToMake(a)
+}
 
+// shouldExportToMake returns false for the modules that Make must not know about.
+func shouldExportToMake(amod *ModuleBase) bool {
 	if !amod.Enabled() {
-		return nil
+		return false
 	}
 
 	if amod.commonProperties.SkipInstall {
-		return nil
+		return false
 	}
 
-	data := provider.AndroidMk()
+	if !amod.commonProperties.NamespaceExportedToMake {
+		return false
+	}
 
 	// Make does not understand LinuxBionic
 	if amod.Os() == LinuxBionic {
-		return nil
+		return false
 	}
 
+	return true
+}
+
+// fillInEntries adds the variables that are common to all modules to the entries, followed by the
+// variables of the ExtraEntries functions, and returns the prefix of the Make variables for the
+// architecture of the module.
+func (a *AndroidMkEntries) fillInEntries(config Config, bpPath string, name string, mod blueprint.Module) string {
+	amod := mod.(Module).base()
+
 	prefix := ""
 	if amod.ArchSpecific() {
 		switch amod.Os().Class {
@@ -180,21 +299,24 @@ func translateAndroidMkModule(ctx blueprint.SingletonContext, w io.Writer, mod b
 
 		}
 
-		config := ctx.Config().(Config)
-		if amod.Arch().ArchType != config.Targets[amod.Os().Class][0].Arch.ArchType {
+		if amod.Target().NativeBridge {
+			// Make doesn't know the translated architectures, so the native bridge variants
+			// are separate modules named with a .native_bridge suffix, and a _32 or _64 suffix
+			// for the secondary native bridge architecture
+			a.SubName += ".native_bridge"
+			if amod.Arch().ArchType != config.NativeBridgeTargets[0].Arch.ArchType {
+				a.SubName += "_" + strings.TrimPrefix(amod.Arch().ArchType.Multilib, "lib")
+			}
+		} else if amod.Arch().ArchType != config.Targets[amod.Os().Class][0].Arch.ArchType {
 			prefix = "2ND_" + prefix
 		}
 	}
 
-	fmt.Fprintln(&data.preamble, "\ninclude $(CLEAR_VARS)")
-	fmt.Fprintln(&data.preamble, "LOCAL_PATH :=", filepath.Dir(ctx.BlueprintFile(mod)))
-	fmt.Fprintln(&data.preamble, "LOCAL_MODULE :=", name+data.SubName)
-	fmt.Fprintln(&data.preamble, "LOCAL_MODULE_CLASS :=", data.Class)
-	fmt.Fprintln(&data.preamble, "LOCAL_PREBUILT_MODULE_FILE :=", data.OutputFile.String())
-
-	if len(amod.commonProperties.Required) > 0 {
-		fmt.Fprintln(&data.preamble, "LOCAL_REQUIRED_MODULES := "+strings.Join(amod.commonProperties.Required, " "))
-	}
+	a.SetString("LOCAL_PATH", filepath.Dir(bpPath))
+	a.SetString("LOCAL_MODULE", name+a.SubName)
+	a.SetString("LOCAL_MODULE_CLASS", a.Class)
+	a.SetString("LOCAL_PREBUILT_MODULE_FILE", a.OutputFile.String())
+	a.AddStrings("LOCAL_REQUIRED_MODULES", amod.commonProperties.Required...)
 
 	archStr := amod.Arch().ArchType.String()
 	host := false
@@ -202,43 +324,68 @@ func translateAndroidMkModule(ctx blueprint.SingletonContext, w io.Writer, mod b
 	case Host:
 		// Make cannot identify LOCAL_MODULE_HOST_ARCH:= common.
 		if archStr != "common" {
-			fmt.Fprintln(&data.preamble, "LOCAL_MODULE_HOST_ARCH :=", archStr)
+			a.SetString("LOCAL_MODULE_HOST_ARCH", archStr)
 		}
 		host = true
 	case HostCross:
 		// Make cannot identify LOCAL_MODULE_HOST_CROSS_ARCH:= common.
 		if archStr != "common" {
-			fmt.Fprintln(&data.preamble, "LOCAL_MODULE_HOST_CROSS_ARCH :=", archStr)
+			a.SetString("LOCAL_MODULE_HOST_CROSS_ARCH", archStr)
 		}
 		host = true
 	case Device:
-		// Make cannot identify LOCAL_MODULE_TARGET_ARCH:= common.
-		if archStr != "common" {
-			fmt.Fprintln(&data.preamble, "LOCAL_MODULE_TARGET_ARCH :=", archStr)
+		// Make cannot identify LOCAL_MODULE_TARGET_ARCH:= common, or the architectures of the
+		// native bridge.
+		if archStr != "common" && !amod.Target().NativeBridge {
+			a.SetString("LOCAL_MODULE_TARGET_ARCH", archStr)
 		}
 
-		if len(amod.commonProperties.Logtags) > 0 {
-			fmt.Fprintln(&data.preamble, "LOCAL_LOGTAGS_FILES := ", strings.Join(amod.commonProperties.Logtags, " "))
-		}
-		if len(amod.commonProperties.Init_rc) > 0 {
-			fmt.Fprintln(&data.preamble, "LOCAL_INIT_RC := ", strings.Join(amod.commonProperties.Init_rc, " "))
-		}
-		if amod.commonProperties.Proprietary {
-			fmt.Fprintln(&data.preamble, "LOCAL_PROPRIETARY_MODULE := true")
-		}
-		if amod.commonProperties.Vendor {
-			fmt.Fprintln(&data.preamble, "LOCAL_VENDOR_MODULE := true")
-		}
+		a.AddStrings("LOCAL_LOGTAGS_FILES", amod.commonProperties.Logtags...)
+		a.AddStrings("LOCAL_INIT_RC", amod.commonProperties.Init_rc...)
+		a.AddStrings("LOCAL_VINTF_FRAGMENTS", amod.commonProperties.Vintf_fragments...)
+		a.SetBoolIfTrue("LOCAL_PROPRIETARY_MODULE", amod.commonProperties.Proprietary)
+		a.SetBoolIfTrue("LOCAL_VENDOR_MODULE", amod.commonProperties.Vendor)
+		a.SetBoolIfTrue("LOCAL_SYSTEM_EXT_MODULE", amod.commonProperties.System_ext_specific)
+		a.SetBoolIfTrue("LOCAL_UNINSTALLABLE_MODULE", amod.commonProperties.Uninstallable)
 		if amod.commonProperties.Owner != nil {
-			fmt.Fprintln(&data.preamble, "LOCAL_MODULE_OWNER :=", *amod.commonProperties.Owner)
+			a.SetString("LOCAL_MODULE_OWNER", *amod.commonProperties.Owner)
 		}
 	}
 
 	if host {
-		fmt.Fprintln(&data.preamble, "LOCAL_MODULE_HOST_OS :=", amod.Os().String())
-		fmt.Fprintln(&data.preamble, "LOCAL_IS_HOST_MODULE := true")
+		a.SetString("LOCAL_MODULE_HOST_OS", amod.Os().String())
+		a.SetString("LOCAL_IS_HOST_MODULE", "true")
+	}
+
+	for _, extra := range a.ExtraEntries {
+		extra(a)
+	}
+
+	return prefix
+}
+
+func translateAndroidMkDataModule(ctx blueprint.SingletonContext, w io.Writer, mod blueprint.Module,
+	provider AndroidMkDataProvider) {
+
+	name := provider.BaseModuleName()
+	amod := mod.(Module).base()
+	if !shouldExportToMake(amod) {
+		return
 	}
 
+	data := provider.AndroidMk()
+
+	entries := AndroidMkEntries{
+		Class:      data.Class,
+		SubName:    data.SubName,
+		OutputFile: data.OutputFile,
+	}
+	prefix := entries.fillInEntries(ctx.Config().(Config), ctx.BlueprintFile(mod), name, mod)
+	data.SubName = entries.SubName
+
+	fmt.Fprintln(&data.preamble, "\ninclude $(CLEAR_VARS)")
+	entries.writeEntries(&data.preamble)
+
 	blueprintDir := filepath.Dir(ctx.BlueprintFile(mod))
 
 	if data.Custom != nil {
@@ -247,7 +394,44 @@ func translateAndroidMkModule(ctx blueprint.SingletonContext, w io.Writer, mod b
 		WriteAndroidMkData(w, data)
 	}
 
-	return nil
+	writeAliasesAndDist(w, amod, name+data.SubName, data.Disabled, data.Custom != nil, data.OutputFile)
+}
+
+func translateAndroidMkEntriesModule(ctx blueprint.SingletonContext, w io.Writer, mod blueprint.Module,
+	provider AndroidMkEntriesProvider) {
+
+	name := provider.BaseModuleName()
+	amod := mod.(Module).base()
+	if !shouldExportToMake(amod) {
+		return
+	}
+
+	entries := provider.AndroidMkEntries()
+	if entries.Disabled || !entries.OutputFile.Valid() {
+		return
+	}
+	entries.fillInEntries(ctx.Config().(Config), ctx.BlueprintFile(mod), name, mod)
+	entries.write(w)
+
+	writeAliasesAndDist(w, amod, name+entries.SubName, false, false, entries.OutputFile)
+}
+
+// writeAliasesAndDist writes the rules for the aliases and the dist property of a module that was
+// written to Make as makeName.
+func writeAliasesAndDist(w io.Writer, amod *ModuleBase, makeName string, disabled, custom bool,
+	outputFile OptionalPath) {
+
+	// Make builds the modules that declare an alias when the alias is built
+	if !disabled && (custom || outputFile.Valid()) {
+		for _, alias := range amod.commonProperties.Aliases {
+			fmt.Fprintf(w, ".PHONY: %s\n%s: %s\n", alias, alias, makeName)
+		}
+	}
+
+	// The other variants would be copied to the same file
+	if !disabled && outputFile.Valid() && amod.distVariant() {
+		writeDistForGoals(w, amod.commonProperties.Dist, outputFile.Path())
+	}
 }
 
 func WriteAndroidMkData(w io.Writer, data AndroidMkData) {
@@ -0,0 +1,41 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestAndroidMkEntries(t *testing.T) {
+	entries := AndroidMkEntries{}
+	entries.SetString("LOCAL_MODULE", "foo")
+	entries.AddStrings("LOCAL_REQUIRED_MODULES")
+	entries.AddStrings("LOCAL_OVERRIDES_PACKAGES", "bar")
+	entries.SetBoolIfTrue("LOCAL_PRIVILEGED_MODULE", false)
+	entries.SetBoolIfTrue("LOCAL_DEX_PREOPT", true)
+	entries.AddStrings("LOCAL_OVERRIDES_PACKAGES", "baz", "qux")
+	entries.SetString("LOCAL_MODULE", "foo2")
+
+	buf := &bytes.Buffer{}
+	entries.writeEntries(buf)
+
+	expected := "LOCAL_MODULE := foo2\n" +
+		"LOCAL_OVERRIDES_PACKAGES := bar baz qux\n" +
+		"LOCAL_DEX_PREOPT := true\n"
+	if buf.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+}
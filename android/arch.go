@@ -274,9 +274,20 @@ func osByName(name string) OsType {
 type Target struct {
 	Os   OsType
 	Arch Arch
+
+	// NativeBridge is true for the targets of a translated architecture, whose code is run
+	// through the native bridge of the device, for example arm on an x86 device
+	NativeBridge bool
+
+	// NativeBridgeRelativePath is the subdirectory of lib, lib64 or bin that native bridge
+	// modules are installed to, usually the name of the architecture
+	NativeBridgeRelativePath string
 }
 
 func (target Target) String() string {
+	if target.NativeBridge {
+		return target.Os.String() + "_" + target.Arch.String() + "_native_bridge"
+	}
 	return target.Os.String() + "_" + target.Arch.String()
 }
 
@@ -330,6 +341,14 @@ func archMutator(mctx BottomUpMutatorContext) {
 			primaryModules[len(moduleTargets)] = true
 			moduleTargets = append(moduleTargets, targets...)
 		}
+
+		// Modules that support the native bridge are also built for the translated
+		// architectures, with the same multilib setting as for the device architectures
+		if class == Device && module.base().NativeBridgeSupported() && multilib != "common" {
+			nativeBridgeTargets, _ := decodeMultilib(multilib,
+				mctx.AConfig().NativeBridgeTargets, prefer32)
+			moduleTargets = append(moduleTargets, nativeBridgeTargets...)
+		}
 	}
 
 	if len(moduleTargets) == 0 {
@@ -462,6 +481,7 @@ func createArchType(props reflect.Type) reflect.Type {
 		"Not_windows",
 		"Arm_on_x86",
 		"Arm_on_x86_64",
+		"Native_bridge",
 	}
 	for _, os := range osTypeList {
 		targets = append(targets, os.Field)
@@ -527,6 +547,20 @@ func InitArchModule(m Module) {
 		}
 	}
 
+	for _, properties := range base.generalProperties {
+		t := reflect.TypeOf(properties)
+		soongConfigPropType := soongConfigPropTypeMap.Once(t, func() interface{} {
+			return createSoongConfigType(t)
+		})
+
+		var scp interface{}
+		if soongConfigPropType != nil {
+			scp = reflect.New(soongConfigPropType.(reflect.Type)).Interface()
+			m.AddProperties(scp)
+		}
+		base.soongConfigProperties = append(base.soongConfigProperties, scp)
+	}
+
 	base.customizableProperties = m.GetProperties()
 }
 
@@ -571,32 +605,55 @@ func (a *ModuleBase) appendProperties(ctx BottomUpMutatorContext,
 
 // Rewrite the module's properties structs to contain arch-specific values.
 func (a *ModuleBase) setArchProperties(ctx BottomUpMutatorContext) {
-	arch := a.Arch()
-	os := a.Os()
-
-	if arch.ArchType == Common {
-		return
-	}
-
 	for i := range a.generalProperties {
-		genProps := a.generalProperties[i]
 		if a.archProperties[i] == nil {
 			continue
 		}
 		archProps := reflect.ValueOf(a.archProperties[i]).Elem()
+		a.appendArchVariantProperties(ctx, a.generalProperties[i], archProps, a.Target(), false)
+	}
+}
+
+// ArchSpecificProperties returns copies of the properties structs of a module built for the
+// common architecture with the arch specific properties for target appended, so that modules that
+// are built once for all architectures, like apps, can use per-arch properties for the
+// dependencies that they have on each architecture.
+func (a *ModuleBase) ArchSpecificProperties(ctx BottomUpMutatorContext, target Target) []interface{} {
+	var props []interface{}
+	for i := range a.generalProperties {
+		clone := proptools.CloneProperties(reflect.ValueOf(a.generalProperties[i]).Elem()).Interface()
+		if a.archProperties[i] != nil {
+			archProps := reflect.ValueOf(a.archProperties[i]).Elem()
+			a.appendArchVariantProperties(ctx, clone, archProps, target, true)
+		}
+		props = append(props, clone)
+	}
+	return props
+}
+
+// appendArchVariantProperties appends the arch and os specific properties for target from
+// archProps to genProps.  Modules built for the common architecture only get the os specific
+// properties, and archOnly skips the os specific properties for the per-arch copies of the
+// properties of those modules, which already have them.
+func (a *ModuleBase) appendArchVariantProperties(ctx BottomUpMutatorContext, genProps interface{},
+	archProps reflect.Value, target Target, archOnly bool) {
 
-		archProp := archProps.FieldByName("Arch")
-		multilibProp := archProps.FieldByName("Multilib")
-		targetProp := archProps.FieldByName("Target")
+	arch := target.Arch
+	os := target.Os
+	t := arch.ArchType
+	hasArch := t != Common
 
+	archProp := archProps.FieldByName("Arch")
+	multilibProp := archProps.FieldByName("Multilib")
+	targetProp := archProps.FieldByName("Target")
+
+	if hasArch {
 		// Handle arch-specific properties in the form:
 		// arch: {
 		//     arm64: {
 		//         key: value,
 		//     },
 		// },
-		t := arch.ArchType
-
 		field := proptools.FieldNameForProperty(t.Name)
 		prefix := "arch." + t.Name
 		archStruct := a.appendProperties(ctx, genProps, archProp, field, prefix)
@@ -650,72 +707,78 @@ func (a *ModuleBase) setArchProperties(ctx BottomUpMutatorContext) {
 		field = proptools.FieldNameForProperty(t.Multilib)
 		prefix = "multilib." + t.Multilib
 		a.appendProperties(ctx, genProps, multilibProp, field, prefix)
+	}
 
-		// Handle host-specific properties in the form:
-		// target: {
-		//     host: {
-		//         key: value,
-		//     },
-		// },
-		if os.Class == Host || os.Class == HostCross {
-			field = "Host"
-			prefix = "target.host"
-			a.appendProperties(ctx, genProps, targetProp, field, prefix)
-		}
+	// Handle host-specific properties in the form:
+	// target: {
+	//     host: {
+	//         key: value,
+	//     },
+	// },
+	if !archOnly && (os.Class == Host || os.Class == HostCross) {
+		field := "Host"
+		prefix := "target.host"
+		a.appendProperties(ctx, genProps, targetProp, field, prefix)
+	}
 
-		// Handle target OS properties in the form:
-		// target: {
-		//     linux: {
-		//         key: value,
-		//     },
-		//     not_windows: {
-		//         key: value,
-		//     },
-		//     linux_x86: {
-		//         key: value,
-		//     },
-		//     linux_arm: {
-		//         key: value,
-		//     },
-		//     android {
-		//         key: value,
-		//     },
-		//     android_arm {
-		//         key: value,
-		//     },
-		//     android_x86 {
-		//         key: value,
-		//     },
-		// },
-		// },
-		field = os.Field
-		prefix = "target." + os.Name
+	// Handle target OS properties in the form:
+	// target: {
+	//     linux: {
+	//         key: value,
+	//     },
+	//     not_windows: {
+	//         key: value,
+	//     },
+	//     linux_x86: {
+	//         key: value,
+	//     },
+	//     linux_arm: {
+	//         key: value,
+	//     },
+	//     android {
+	//         key: value,
+	//     },
+	//     android_arm {
+	//         key: value,
+	//     },
+	//     android_x86 {
+	//         key: value,
+	//     },
+	// },
+	// },
+	if !archOnly {
+		field := os.Field
+		prefix := "target." + os.Name
 		a.appendProperties(ctx, genProps, targetProp, field, prefix)
+	}
 
-		field = os.Field + "_" + t.Name
-		prefix = "target." + os.Name + "_" + t.Name
+	if hasArch {
+		field := os.Field + "_" + t.Name
+		prefix := "target." + os.Name + "_" + t.Name
 		a.appendProperties(ctx, genProps, targetProp, field, prefix)
+	}
 
-		if (os.Class == Host || os.Class == HostCross) && os != Windows {
-			field := "Not_windows"
-			prefix := "target.not_windows"
-			a.appendProperties(ctx, genProps, targetProp, field, prefix)
-		}
+	if !archOnly && (os.Class == Host || os.Class == HostCross) && os != Windows {
+		field := "Not_windows"
+		prefix := "target.not_windows"
+		a.appendProperties(ctx, genProps, targetProp, field, prefix)
+	}
 
-		// Handle 64-bit device properties in the form:
-		// target {
-		//     android64 {
-		//         key: value,
-		//     },
-		//     android32 {
-		//         key: value,
-		//     },
-		// },
-		// WARNING: this is probably not what you want to use in your blueprints file, it selects
-		// options for all targets on a device that supports 64-bit binaries, not just the targets
-		// that are being compiled for 64-bit.  Its expected use case is binaries like linker and
-		// debuggerd that need to know when they are a 32-bit process running on a 64-bit device
-		if os.Class == Device {
+	// Handle 64-bit device properties in the form:
+	// target {
+	//     android64 {
+	//         key: value,
+	//     },
+	//     android32 {
+	//         key: value,
+	//     },
+	// },
+	// WARNING: this is probably not what you want to use in your blueprints file, it selects
+	// options for all targets on a device that supports 64-bit binaries, not just the targets
+	// that are being compiled for 64-bit.  Its expected use case is binaries like linker and
+	// debuggerd that need to know when they are a 32-bit process running on a 64-bit device
+	if os.Class == Device {
+		if !archOnly {
 			if ctx.AConfig().Android64() {
 				field := "Android64"
 				prefix := "target.android64"
@@ -725,19 +788,31 @@ func (a *ModuleBase) setArchProperties(ctx BottomUpMutatorContext) {
 				prefix := "target.android32"
 				a.appendProperties(ctx, genProps, targetProp, field, prefix)
 			}
+		}
 
-			if arch.ArchType == X86 && (hasArmAbi(arch) ||
-				hasArmAndroidArch(ctx.AConfig().Targets[Device])) {
-				field := "Arm_on_x86"
-				prefix := "target.arm_on_x86"
-				a.appendProperties(ctx, genProps, targetProp, field, prefix)
-			}
-			if arch.ArchType == X86_64 && (hasArmAbi(arch) ||
-				hasArmAndroidArch(ctx.AConfig().Targets[Device])) {
-				field := "Arm_on_x86_64"
-				prefix := "target.arm_on_x86_64"
-				a.appendProperties(ctx, genProps, targetProp, field, prefix)
-			}
+		if arch.ArchType == X86 && (hasArmAbi(arch) ||
+			hasArmAndroidArch(ctx.AConfig().Targets[Device])) {
+			field := "Arm_on_x86"
+			prefix := "target.arm_on_x86"
+			a.appendProperties(ctx, genProps, targetProp, field, prefix)
+		}
+		if arch.ArchType == X86_64 && (hasArmAbi(arch) ||
+			hasArmAndroidArch(ctx.AConfig().Targets[Device])) {
+			field := "Arm_on_x86_64"
+			prefix := "target.arm_on_x86_64"
+			a.appendProperties(ctx, genProps, targetProp, field, prefix)
+		}
+
+		// Handle native bridge properties in the form:
+		// target: {
+		//     native_bridge: {
+		//         key: value,
+		//     },
+		// },
+		if target.NativeBridge {
+			field := "Native_bridge"
+			prefix := "target.native_bridge"
+			a.appendProperties(ctx, genProps, targetProp, field, prefix)
 		}
 	}
 }
@@ -836,6 +911,69 @@ func decodeTargetProductVariables(config *config) (map[OsClass][]Target, error)
 	return targets, nil
 }
 
+// Convert the native bridge product variables into the list of translated device targets
+func decodeNativeBridgeTargets(config *config) ([]Target, error) {
+	variables := config.ProductVariables
+
+	var targets []Target
+	addTarget := func(archName string, archVariant, cpuVariant *string, abi *[]string,
+		relativePath *string) error {
+
+		arch, err := decodeArch(archName, archVariant, cpuVariant, abi)
+		if err != nil {
+			return err
+		}
+		// Translated code is installed to a subdirectory of lib, lib64 or bin
+		arch.Native = false
+
+		target := Target{
+			Os:                       Android,
+			Arch:                     arch,
+			NativeBridge:             true,
+			NativeBridgeRelativePath: arch.ArchType.String(),
+		}
+		if relativePath != nil && *relativePath != "" {
+			target.NativeBridgeRelativePath = *relativePath
+		}
+		targets = append(targets, target)
+		return nil
+	}
+
+	if variables.NativeBridgeArch == nil || *variables.NativeBridgeArch == "" {
+		return nil, nil
+	}
+	if variables.DeviceArch == nil || *variables.DeviceArch == "" {
+		return nil, fmt.Errorf("Native bridge architecture set without a device architecture")
+	}
+
+	err := addTarget(*variables.NativeBridgeArch, variables.NativeBridgeArchVariant,
+		variables.NativeBridgeCpuVariant, variables.NativeBridgeAbi,
+		variables.NativeBridgeRelativePath)
+	if err != nil {
+		return nil, err
+	}
+
+	if variables.NativeBridgeSecondaryArch != nil && *variables.NativeBridgeSecondaryArch != "" {
+		err := addTarget(*variables.NativeBridgeSecondaryArch,
+			variables.NativeBridgeSecondaryArchVariant, variables.NativeBridgeSecondaryCpuVariant,
+			variables.NativeBridgeSecondaryAbi, variables.NativeBridgeSecondaryRelativePath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, target := range targets {
+		for _, deviceTarget := range config.Targets[Device] {
+			if target.Arch.ArchType == deviceTarget.Arch.ArchType {
+				return nil, fmt.Errorf("Native bridge architecture %q is also a device architecture",
+					target.Arch.ArchType.String())
+			}
+		}
+	}
+
+	return targets, nil
+}
+
 // hasArmAbi returns true if arch has at least one arm ABI
 func hasArmAbi(arch Arch) bool {
 	for _, abi := range arch.Abi {
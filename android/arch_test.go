@@ -0,0 +1,113 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+type archPropsModule struct {
+	ModuleBase
+	properties struct {
+		Srcs []string `android:"arch_variant"`
+	}
+
+	// The srcs of the per-arch copies of the properties, by target
+	archSrcs map[string][]string
+}
+
+func newArchPropsModule() Module {
+	m := &archPropsModule{}
+	m.AddProperties(&m.properties)
+	InitAndroidArchModule(m, HostAndDeviceSupported, MultilibCommon)
+	return m
+}
+
+func (m *archPropsModule) DepsMutator(ctx BottomUpMutatorContext) {
+	m.archSrcs = make(map[string][]string)
+	for _, target := range ctx.AConfig().Targets[Device] {
+		for _, props := range m.ArchSpecificProperties(ctx, target) {
+			if p, ok := props.(*struct {
+				Srcs []string `android:"arch_variant"`
+			}); ok {
+				m.archSrcs[target.String()] = p.Srcs
+			}
+		}
+	}
+}
+
+func (m *archPropsModule) GenerateAndroidBuildActions(ctx ModuleContext) {
+}
+
+func TestArchSpecificProperties(t *testing.T) {
+	buildDir, err := ioutil.TempDir("", "soong_arch_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(buildDir)
+
+	f := NewTestArchFixture(buildDir)
+	f.RegisterModuleType("arch_props", ModuleFactoryAdaptor(newArchPropsModule))
+	f.AddBlueprint(`
+		arch_props {
+			name: "foo",
+			host_supported: true,
+			srcs: ["a.java"],
+			arch: {
+				arm64: {
+					srcs: ["arm64.java"],
+				},
+				arm: {
+					srcs: ["arm.java"],
+				},
+			},
+			target: {
+				android: {
+					srcs: ["android.java"],
+				},
+				host: {
+					srcs: ["host.java"],
+				},
+			},
+		}
+	`)
+	f.Prepare(t)
+
+	device := f.ModuleForTests("foo", "android_common").Module().(*archPropsModule)
+	if g, w := device.properties.Srcs, []string{"a.java", "android.java"}; !reflect.DeepEqual(g, w) {
+		t.Errorf("expected device srcs %q, got %q", w, g)
+	}
+
+	testCases := []struct {
+		target string
+		srcs   []string
+	}{
+		{"android_arm64", []string{"a.java", "android.java", "arm64.java"}},
+		{"android_arm", []string{"a.java", "android.java", "arm.java"}},
+	}
+	for _, testCase := range testCases {
+		if g, w := device.archSrcs[testCase.target], testCase.srcs; !reflect.DeepEqual(g, w) {
+			t.Errorf("expected %s srcs %q, got %q", testCase.target, w, g)
+		}
+	}
+
+	host := f.ModuleForTests("foo", BuildOs.String()+"_common").Module().(*archPropsModule)
+	if g, w := host.properties.Srcs, []string{"a.java", "host.java"}; !reflect.DeepEqual(g, w) {
+		t.Errorf("expected host srcs %q, got %q", w, g)
+	}
+}
@@ -0,0 +1,190 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+// This file contains the build presets, which a product selects with Build_preset to get the
+// security and debugging defaults of a build variant instead of checking TARGET_BUILD_VARIANT
+// in its device makefiles.  A preset sets the Debuggable and Eng product variables, so that the
+// product_variables.debuggable and product_variables.eng properties of modules follow it,
+// adds the default properties for adb and debugging to default.prop, and decides whether the
+// modules that set debug_only are installed.
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+func init() {
+	PreDepsMutators(func(ctx RegisterMutatorsContext) {
+		ctx.BottomUp("build_preset", buildPresetMutator).Parallel()
+	})
+
+	RegisterMakeVarsProvider(pctx, buildPresetMakeVarsProvider)
+}
+
+// BuildPreset is a set of security and debugging defaults for a build variant.
+type BuildPreset struct {
+	Name string
+
+	// Debuggable sets ro.debuggable=1, which allows debugging any app and restarting adbd as root
+	Debuggable bool
+
+	// Eng turns on the heavyweight debugging features of eng builds and sets ro.secure=0, which
+	// runs adbd as root
+	Eng bool
+
+	// AdbSecure sets ro.adb.secure=1, which requires the user to authorize a host before it can
+	// use adb
+	AdbSecure bool
+
+	// AdbEnabled enables adb over USB by default
+	AdbEnabled bool
+
+	// DebugApps installs the modules that set debug_only
+	DebugApps bool
+}
+
+var buildPresets = map[string]BuildPreset{
+	"user": {
+		AdbSecure: true,
+	},
+	"userdebug": {
+		Debuggable: true,
+		AdbEnabled: true,
+		DebugApps:  true,
+	},
+	// userdebug_hardened keeps the debugging features of userdebug builds for dogfooding devices,
+	// but requires authorizing adb and doesn't install the debugging tools
+	"userdebug_hardened": {
+		Debuggable: true,
+		AdbSecure:  true,
+	},
+	"eng": {
+		Debuggable: true,
+		Eng:        true,
+		AdbEnabled: true,
+		DebugApps:  true,
+	},
+}
+
+// DefaultProperties returns the properties that the preset adds to the default.prop file.
+func (p *BuildPreset) DefaultProperties() []string {
+	boolProp := func(name string, value bool) string {
+		if value {
+			return name + "=1"
+		}
+		return name + "=0"
+	}
+
+	usbConfig := "none"
+	if p.AdbEnabled {
+		usbConfig = "adb"
+	}
+
+	return []string{
+		boolProp("ro.secure", !p.Eng),
+		boolProp("ro.adb.secure", p.AdbSecure),
+		boolProp("ro.debuggable", p.Debuggable),
+		"persist.sys.usb.config=" + usbConfig,
+	}
+}
+
+// applyBuildPreset sets the Debuggable and Eng product variables from the build preset selected
+// by the product, and checks that the product doesn't set them to different values.
+func applyBuildPreset(v *productVariables) error {
+	if v.Build_preset == nil {
+		return nil
+	}
+
+	preset, ok := buildPresets[*v.Build_preset]
+	if !ok {
+		var names []string
+		for name := range buildPresets {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("invalid Build_preset %q, must be one of %s",
+			*v.Build_preset, strings.Join(names, ", "))
+	}
+
+	if v.Debuggable != nil && *v.Debuggable != preset.Debuggable {
+		return fmt.Errorf("Debuggable is %t, but Build_preset %q sets it to %t",
+			*v.Debuggable, *v.Build_preset, preset.Debuggable)
+	}
+	if v.Eng != nil && *v.Eng != preset.Eng {
+		return fmt.Errorf("Eng is %t, but Build_preset %q sets it to %t",
+			*v.Eng, *v.Build_preset, preset.Eng)
+	}
+
+	v.Debuggable = boolPtr(preset.Debuggable)
+	v.Eng = boolPtr(preset.Eng)
+	return nil
+}
+
+// BuildPreset returns the build preset selected by the product, or nil if it doesn't select one.
+func (c *config) BuildPreset() *BuildPreset {
+	if c.ProductVariables.Build_preset == nil {
+		return nil
+	}
+	if preset, ok := buildPresets[*c.ProductVariables.Build_preset]; ok {
+		preset.Name = *c.ProductVariables.Build_preset
+		return &preset
+	}
+	return nil
+}
+
+// DebugAppsInstalled returns true if the modules that set debug_only are installed, which
+// defaults to debuggable builds if the product doesn't select a build preset.
+func (c *config) DebugAppsInstalled() bool {
+	if preset := c.BuildPreset(); preset != nil {
+		return preset.DebugApps
+	}
+	return Bool(c.ProductVariables.Debuggable)
+}
+
+// buildPresetMutator marks the device modules that set debug_only as uninstallable if the product
+// doesn't install them.  Unlike SkipInstall, the modules are still exported to Make, so that other
+// modules can depend on them and they are built by checkbuild.
+func buildPresetMutator(ctx BottomUpMutatorContext) {
+	if m, ok := ctx.Module().(Module); ok && Bool(m.base().commonProperties.Debug_only) {
+		if ctx.Device() && !ctx.AConfig().DebugAppsInstalled() {
+			m.base().commonProperties.Uninstallable = true
+		}
+	}
+}
+
+func buildPresetMakeVarsProvider(ctx MakeVarsContext) {
+	preset := ctx.Config().BuildPreset()
+	if preset == nil {
+		return
+	}
+
+	ctx.Strict("SOONG_BUILD_PRESET", preset.Name)
+}
+
+// writeBuildPresetAndroidMk adds the default properties of the build preset to default.prop.
+// They are put before the properties that Make sets from TARGET_BUILD_VARIANT, so that they take
+// precedence, because the first definition of a property in default.prop is kept.
+func writeBuildPresetAndroidMk(w io.Writer, config Config) {
+	preset := config.BuildPreset()
+	if preset == nil {
+		return
+	}
+
+	fmt.Fprintln(w, "\nADDITIONAL_DEFAULT_PROPERTIES :=",
+		strings.Join(preset.DefaultProperties(), " "), "$(ADDITIONAL_DEFAULT_PROPERTIES)")
+}
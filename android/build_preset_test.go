@@ -0,0 +1,118 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+type debugOnlyModule struct {
+	ModuleBase
+}
+
+func newDebugOnlyModule() Module {
+	m := &debugOnlyModule{}
+	InitAndroidArchModule(m, DeviceSupported, MultilibFirst)
+	return m
+}
+
+func (m *debugOnlyModule) DepsMutator(ctx BottomUpMutatorContext) {
+}
+
+func (m *debugOnlyModule) GenerateAndroidBuildActions(ctx ModuleContext) {
+	ctx.InstallFile(PathForModuleInstall(ctx, "bin"), PathForModuleSrc(ctx, ctx.ModuleName()))
+}
+
+func TestDebugOnly(t *testing.T) {
+	buildDir, err := ioutil.TempDir("", "soong_build_preset_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(buildDir)
+
+	testCases := []struct {
+		name       string
+		preset     *string
+		debuggable bool
+		installed  bool
+	}{
+		{name: "user", preset: stringPtr("user")},
+		{name: "userdebug", preset: stringPtr("userdebug"), installed: true},
+		{name: "userdebug_hardened", preset: stringPtr("userdebug_hardened")},
+		{name: "no preset", debuggable: true, installed: true},
+		{name: "no preset user"},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			f := NewTestArchFixture(buildDir)
+			f.RegisterModuleType("debug_only_module", ModuleFactoryAdaptor(newDebugOnlyModule))
+			f.PreDepsMutators(func(ctx RegisterMutatorsContext) {
+				ctx.BottomUp("build_preset", buildPresetMutator).Parallel()
+			})
+			f.AddBlueprint(`
+				debug_only_module {
+					name: "tool",
+					debug_only: true,
+				}`)
+			f.AddFiles("tool")
+			f.Config.ProductVariables.Build_preset = test.preset
+			f.Config.ProductVariables.Debuggable = boolPtr(test.debuggable)
+			f.Prepare(t)
+
+			m := f.ModuleForTests("tool", "android_arm64").Module()
+			installed := false
+			for _, params := range m.BuildParamsForTests() {
+				if params.Rule == Cp {
+					installed = true
+				}
+			}
+			if installed != test.installed {
+				t.Errorf("tool is installed: %t, want %t", installed, test.installed)
+			}
+
+			// The module is always exported to Make, which must not install it either
+			if !m.ExportedToMake() {
+				t.Errorf("tool is not exported to Make")
+			}
+			entries := AndroidMkEntries{}
+			entries.fillInEntries(f.Config, "Android.bp", "tool", m)
+			uninstallable := entries.EntryMap["LOCAL_UNINSTALLABLE_MODULE"]
+			if (len(uninstallable) > 0) == test.installed {
+				t.Errorf("LOCAL_UNINSTALLABLE_MODULE is %q, want installed %t", uninstallable, test.installed)
+			}
+		})
+	}
+}
+
+func TestBuildPresetAndroidMk(t *testing.T) {
+	config := TestConfig("out")
+	buf := &bytes.Buffer{}
+	writeBuildPresetAndroidMk(buf, config)
+	if buf.Len() != 0 {
+		t.Errorf("wrote %q without a build preset", buf.String())
+	}
+
+	config.ProductVariables.Build_preset = stringPtr("userdebug_hardened")
+	writeBuildPresetAndroidMk(buf, config)
+	expected := "\nADDITIONAL_DEFAULT_PROPERTIES := ro.secure=1 ro.adb.secure=1 ro.debuggable=1 " +
+		"persist.sys.usb.config=none $(ADDITIONAL_DEFAULT_PROPERTIES)\n"
+	if buf.String() != expected {
+		t.Errorf("expected %q, got %q", expected, buf.String())
+	}
+}
@@ -20,10 +20,12 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"regexp"
 	"runtime"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/google/blueprint/proptools"
 )
@@ -70,8 +72,10 @@ type config struct {
 	ConfigFileName           string
 	ProductVariablesFileName string
 
-	Targets        map[OsClass][]Target
-	BuildOsVariant string
+	Targets              map[OsClass][]Target
+	NativeBridgeTargets  []Target
+	BuildOsVariant       string
+	BuildOsCommonVariant string
 
 	deviceConfig *deviceConfig
 
@@ -84,7 +88,7 @@ type config struct {
 
 	inMake bool
 
-	captureBuild bool // true for tests, saves build parameters for each module
+	captureBuild bool // true for tests and module summaries, saves build parameters for each module
 
 	OncePer
 }
@@ -170,7 +174,8 @@ func saveToConfigFile(config jsonConfigurable, filename string) error {
 func TestConfig(buildDir string) Config {
 	config := &config{
 		ProductVariables: productVariables{
-			DeviceName: stringPtr("test_device"),
+			DeviceName:           stringPtr("test_device"),
+			Platform_sdk_version: intPtr(26),
 		},
 
 		buildDir:     buildDir,
@@ -183,6 +188,26 @@ func TestConfig(buildDir string) Config {
 	return Config{config}
 }
 
+// TestArchConfig returns a TestConfig with an arm64 and arm device and an x86_64 and x86 host.
+func TestArchConfig(buildDir string) Config {
+	testConfig := TestConfig(buildDir)
+	config := testConfig.config
+
+	config.Targets = map[OsClass][]Target{
+		Device: []Target{
+			{Os: Android, Arch: Arch{ArchType: Arm64, Native: true}},
+			{Os: Android, Arch: Arch{ArchType: Arm, Native: true}},
+		},
+		Host: []Target{
+			{Os: BuildOs, Arch: Arch{ArchType: X86_64}},
+			{Os: BuildOs, Arch: Arch{ArchType: X86}},
+		},
+	}
+	config.BuildOsCommonVariant = getCommonTargets(config.Targets[Host])[0].String()
+
+	return testConfig
+}
+
 // New creates a new Config object.  The srcDir argument specifies the path to
 // the root source directory. It also loads the config file, if found.
 func NewConfig(srcDir, buildDir string) (Config, error) {
@@ -221,6 +246,18 @@ func NewConfig(srcDir, buildDir string) (Config, error) {
 		return Config{}, err
 	}
 
+	err = validateBuildVersionVariables(&config.ProductVariables)
+	if err != nil {
+		return Config{}, err
+	}
+
+	err = applyBuildPreset(&config.ProductVariables)
+	if err != nil {
+		return Config{}, err
+	}
+
+	config.captureBuild = config.ExportModuleSummaries()
+
 	inMakeFile := filepath.Join(buildDir, ".soong.in_make")
 	if _, err := os.Stat(inMakeFile); err == nil {
 		config.inMake = true
@@ -248,6 +285,12 @@ func NewConfig(srcDir, buildDir string) (Config, error) {
 
 	config.Targets = targets
 	config.BuildOsVariant = targets[Host][0].String()
+	config.BuildOsCommonVariant = getCommonTargets(targets[Host])[0].String()
+
+	config.NativeBridgeTargets, err = decodeNativeBridgeTargets(config)
+	if err != nil {
+		return Config{}, err
+	}
 
 	return Config{config}, nil
 }
@@ -349,6 +392,24 @@ func (c *config) EmbeddedInMake() bool {
 	return c.inMake
 }
 
+// ExportModuleSummaries returns true if a summary of every module should be written to
+// module_summaries.pb for external analysis tools, see module_summary.proto.
+func (c *config) ExportModuleSummaries() bool {
+	return c.IsEnvTrue("SOONG_EXPORT_MODULE_SUMMARIES")
+}
+
+// UseD8 returns true if java bytecode should be converted to dex with d8 instead of dx.  Setting
+// USE_DX=true falls back to dx, for example to bisect regressions in the dex output.
+func (c *config) UseD8() bool {
+	return !c.IsEnvTrue("USE_DX")
+}
+
+// UseOpenJDK9 returns true if java code should be compiled with the OpenJDK 9 toolchain instead
+// of OpenJDK 8.
+func (c *config) UseOpenJDK9() bool {
+	return c.IsEnvTrue("EXPERIMENTAL_USE_OPENJDK9")
+}
+
 // DeviceName returns the name of the current device target
 // TODO: take an AndroidModuleContext to select the device name for multi-device builds
 func (c *config) DeviceName() string {
@@ -362,11 +423,35 @@ func (c *config) DeviceUsesClang() bool {
 	return true
 }
 
-func (c *config) ResourceOverlays() []SourcePath {
-	return nil
+// ResourceOverlays returns the product and device resource overlay directories, in order of
+// precedence.  Overlay directories that don't exist are skipped.
+func (c *config) ResourceOverlays(ctx PathContext) []SourcePath {
+	if c.ProductVariables.ResourceOverlays == nil {
+		return nil
+	}
+	var overlays []SourcePath
+	for _, dir := range *c.ProductVariables.ResourceOverlays {
+		if overlay := ExistentPathForSource(ctx, "", dir); overlay.Valid() {
+			overlays = append(overlays, overlay.Path().(SourcePath))
+		}
+	}
+	return overlays
+}
+
+// AppIconPack returns the directory of the product's icon pack, which contains a res directory
+// of launcher icons for each app that it customizes, or an invalid path if the product doesn't
+// have an icon pack.
+func (c *config) AppIconPack(ctx PathContext) OptionalPath {
+	if c.ProductVariables.AppIconPack == nil {
+		return OptionalPath{}
+	}
+	return ExistentPathForSource(ctx, "", *c.ProductVariables.AppIconPack)
 }
 
 func (c *config) PlatformVersion() string {
+	if c.ProductVariables.Platform_version_name != nil {
+		return *c.ProductVariables.Platform_version_name
+	}
 	return "M"
 }
 
@@ -399,30 +484,244 @@ func (c *config) PlatformVersionCombinedCodenames() []string {
 	return combined
 }
 
+// BuildId returns the value of BUILD_ID, used in the build fingerprint and as ro.build.id.
+func (c *config) BuildId() string {
+	return String(c.ProductVariables.BuildId)
+}
+
 func (c *config) BuildNumber() string {
+	if c.ProductVariables.BuildNumber != nil {
+		return *c.ProductVariables.BuildNumber
+	}
 	return "000000"
 }
 
+// PlatformSecurityPatch returns the value of ro.build.version.security_patch in YYYY-MM-DD
+// format, or an empty string if the product does not set one.
+func (c *config) PlatformSecurityPatch() string {
+	return String(c.ProductVariables.Platform_security_patch)
+}
+
+// LineageVersion returns the full Lineage version string, for example
+// "15.1-20171019-NIGHTLY-device".
+func (c *config) LineageVersion() string {
+	return String(c.ProductVariables.Lineage_version)
+}
+
+// LineageDisplayVersion returns the user visible Lineage version string.  It defaults to the
+// full Lineage version if the product does not set one.
+func (c *config) LineageDisplayVersion() string {
+	if c.ProductVariables.Lineage_display_version != nil {
+		return *c.ProductVariables.Lineage_display_version
+	}
+	return c.LineageVersion()
+}
+
+// BuildVersionProperties returns the build.prop properties describing the build version that the
+// product sets.
+func (c *config) BuildVersionProperties() []string {
+	var props []string
+	if id := c.BuildId(); id != "" {
+		props = append(props, "ro.build.id="+id)
+	}
+	if patch := c.PlatformSecurityPatch(); patch != "" {
+		props = append(props, "ro.build.version.security_patch="+patch)
+	}
+	if version := c.LineageDisplayVersion(); version != "" {
+		props = append(props, "ro.lineage.display.version="+version)
+	}
+	return props
+}
+
+// AppVersionName returns the version name of the apps that don't set one in aaptflags: the
+// Lineage display version, or PLATFORM_VERSION-BUILD_NUMBER for builds without a Lineage version.
+func (c *config) AppVersionName() string {
+	if version := c.LineageDisplayVersion(); version != "" {
+		return version
+	}
+	return c.PlatformVersion() + "-" + c.BuildNumber()
+}
+
+// LineageBuildType returns the Lineage release channel, one of lineageBuildTypes.
+func (c *config) LineageBuildType() string {
+	if c.ProductVariables.Lineage_build_type != nil {
+		return *c.ProductVariables.Lineage_build_type
+	}
+	return "UNOFFICIAL"
+}
+
+// LineageDistUrl returns the URL of the download server that mirrors the dist layout, which
+// the updater metadata links the builds to, or an empty string to link them relative to the
+// metadata.
+func (c *config) LineageDistUrl() string {
+	return strings.TrimSuffix(String(c.ProductVariables.Lineage_dist_url), "/")
+}
+
+// LineageDistRetention returns how many builds of each release channel are listed in the updater
+// metadata of a device, or 0 to list all of them.
+func (c *config) LineageDistRetention() int {
+	if c.ProductVariables.Lineage_dist_retention != nil {
+		return *c.ProductVariables.Lineage_dist_retention
+	}
+	return 0
+}
+
+// LineageDistPrune returns whether the builds that are no longer listed in the updater metadata
+// are deleted from the dist layout.
+func (c *config) LineageDistPrune() bool {
+	return Bool(c.ProductVariables.Lineage_dist_prune)
+}
+
+var (
+	buildIdRegexp        = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+	buildNumberRegexp    = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+	lineageVersionRegexp = regexp.MustCompile(`^[0-9]+(\.[0-9]+)*-[0-9]{8}-[A-Z]+(-[A-Za-z0-9._-]+)?$`)
+
+	lineageBuildTypes = []string{"RELEASE", "NIGHTLY", "SNAPSHOT", "EXPERIMENTAL", "UNOFFICIAL"}
+)
+
+// validateBuildVersionVariables checks the formats of the version related product variables so
+// that a typo in the product configuration is reported once here instead of producing
+// malformed properties or app versions.
+func validateBuildVersionVariables(v *productVariables) error {
+	if v.BuildId != nil && !buildIdRegexp.MatchString(*v.BuildId) {
+		return fmt.Errorf("invalid BuildId %q, must match %s", *v.BuildId, buildIdRegexp)
+	}
+
+	if v.BuildNumber != nil && !buildNumberRegexp.MatchString(*v.BuildNumber) {
+		return fmt.Errorf("invalid BuildNumber %q, must match %s", *v.BuildNumber, buildNumberRegexp)
+	}
+
+	if v.Platform_security_patch != nil {
+		if _, err := time.Parse("2006-01-02", *v.Platform_security_patch); err != nil {
+			return fmt.Errorf("invalid Platform_security_patch %q, must be in YYYY-MM-DD format",
+				*v.Platform_security_patch)
+		}
+	}
+
+	if v.Lineage_version != nil && !lineageVersionRegexp.MatchString(*v.Lineage_version) {
+		return fmt.Errorf("invalid Lineage_version %q, must be in <version>-<YYYYMMDD>-<TYPE>[-<device>] format",
+			*v.Lineage_version)
+	}
+
+	if v.Lineage_build_type != nil && !inList(*v.Lineage_build_type, lineageBuildTypes) {
+		return fmt.Errorf("invalid Lineage_build_type %q, must be one of %s",
+			*v.Lineage_build_type, strings.Join(lineageBuildTypes, ", "))
+	}
+
+	if v.Lineage_dist_retention != nil && *v.Lineage_dist_retention < 0 {
+		return fmt.Errorf("invalid Lineage_dist_retention %d, must not be negative",
+			*v.Lineage_dist_retention)
+	}
+
+	return nil
+}
+
+// ProductAaptConfig returns the resource configurations that are kept in the apps of the product,
+// the PRODUCT_AAPT_CONFIG of Make.  The pseudo-locales are added on eng builds.
 func (c *config) ProductAaptConfig() []string {
-	return []string{"normal", "large", "xlarge", "hdpi", "xhdpi", "xxhdpi"}
+	aaptConfig := []string{"normal", "large", "xlarge", "hdpi", "xhdpi", "xxhdpi"}
+	if c.ProductVariables.AAPTConfig != nil {
+		aaptConfig = *c.ProductVariables.AAPTConfig
+	}
+	if c.ProductAaptPseudoLocales() {
+		aaptConfig = append(append([]string(nil), aaptConfig...), "en_XA", "ar_XB")
+	}
+	return aaptConfig
 }
 
 func (c *config) ProductAaptPreferredConfig() string {
+	if c.ProductVariables.AAPTPreferredConfig != nil {
+		return *c.ProductVariables.AAPTPreferredConfig
+	}
 	return "xhdpi"
 }
 
 func (c *config) ProductAaptCharacteristics() string {
+	if c.ProductVariables.AAPTCharacteristics != nil {
+		return *c.ProductVariables.AAPTCharacteristics
+	}
 	return "nosdcard"
 }
 
+// ProductAaptPseudoLocales returns true if the apps are built with the en_XA and ar_XB
+// pseudo-locales, to test translations and right-to-left layouts.  They are built by default on
+// eng and userdebug builds.
+func (c *config) ProductAaptPseudoLocales() bool {
+	if c.ProductVariables.AAPTPseudoLocales != nil {
+		return *c.ProductVariables.AAPTPseudoLocales
+	}
+	return Bool(c.ProductVariables.Debuggable)
+}
+
+// ProductAaptUtf16 returns true if the string pools of the apps are encoded in UTF-16 instead of
+// UTF-8, for devices with legacy resource parsers.
+func (c *config) ProductAaptUtf16() bool {
+	return Bool(c.ProductVariables.AAPTUtf16)
+}
+
 func (c *config) DefaultAppCertificateDir(ctx PathContext) SourcePath {
+	if defaultCert := String(c.ProductVariables.DefaultAppCertificate); defaultCert != "" {
+		return PathForSource(ctx, filepath.Dir(defaultCert))
+	}
 	return PathForSource(ctx, "build/target/product/security")
 }
 
+// DefaultAppCertificate returns the path to the default certificate used to sign apps and OTA
+// packages, without the .x509.pem or .pk8 suffix.
 func (c *config) DefaultAppCertificate(ctx PathContext) SourcePath {
+	if defaultCert := String(c.ProductVariables.DefaultAppCertificate); defaultCert != "" {
+		return c.DefaultAppCertificateDir(ctx).Join(ctx, filepath.Base(defaultCert))
+	}
 	return c.DefaultAppCertificateDir(ctx).Join(ctx, "testkey")
 }
 
+// ExtraOtaKeys returns the paths to additional certificates, without the .x509.pem suffix, that
+// should be accepted when verifying OTA packages.
+func (c *config) ExtraOtaKeys(ctx PathContext) Paths {
+	if c.ProductVariables.ExtraOtaKeys == nil {
+		return nil
+	}
+	var keys Paths
+	for _, key := range *c.ProductVariables.ExtraOtaKeys {
+		// The keys are named without their suffix, so only the directory can be checked for
+		// existence here.
+		keys = append(keys, PathForSource(ctx, filepath.Dir(key)).Join(ctx, filepath.Base(key)))
+	}
+	return keys
+}
+
+// InstalledAppPackages returns the package names of the apps installed by the product that are
+// not built by Soong, for example apps built by Make or prebuilt apks.
+func (c *config) InstalledAppPackages() []string {
+	if c.ProductVariables.InstalledAppPackages == nil {
+		return nil
+	}
+	return *c.ProductVariables.InstalledAppPackages
+}
+
+// EnforcePrivappPermissions returns true if the build should fail when privileged apps request
+// privileged permissions that are not allowlisted with privapp-permissions.
+func (c *config) EnforcePrivappPermissions() bool {
+	return Bool(c.ProductVariables.EnforcePrivappPermissions)
+}
+
+// AppJniMultilib returns which ABIs of their jni_libs apps package by default, "first" for the
+// primary ABI of the device or "both" for fat apks that work with the 32-bit and 64-bit zygotes.
+func (c *config) AppJniMultilib() string {
+	if c.ProductVariables.AppJniMultilib == nil {
+		return "first"
+	}
+	return *c.ProductVariables.AppJniMultilib
+}
+
+// StringFreeze returns how the strings of apps are checked against their translation freeze
+// snapshots: "error" fails the build when they changed, "warning" only reports the changes, and
+// an empty string disables the check.
+func (c *config) StringFreeze() string {
+	return String(c.ProductVariables.StringFreeze)
+}
+
 func (c *config) AllowMissingDependencies() bool {
 	return Bool(c.ProductVariables.Allow_missing_dependencies)
 }
@@ -464,6 +763,12 @@ func (c *config) EnableCFI() bool {
 	}
 }
 
+// GlobalThinLTO returns true if the native modules that don't set lto properties are built with
+// ThinLTO.
+func (c *config) GlobalThinLTO() bool {
+	return Bool(c.ProductVariables.GlobalThinLTO)
+}
+
 func (c *config) Android64() bool {
 	for _, t := range c.Targets[Device] {
 		if t.Arch.ArchType.Multilib == "lib64" {
@@ -529,6 +834,22 @@ func (c *deviceConfig) VendorPath() string {
 	return "vendor"
 }
 
+func (c *deviceConfig) SystemExtPath() string {
+	if c.config.ProductVariables.SystemExtPath != nil {
+		return *c.config.ProductVariables.SystemExtPath
+	}
+	return "system_ext"
+}
+
+// PgoAdditionalProfileDirs returns the directories set by the product that contain the profiles of
+// the native modules built with PGO.
+func (c *deviceConfig) PgoAdditionalProfileDirs() []string {
+	if c.config.ProductVariables.PgoAdditionalProfileDirs == nil {
+		return nil
+	}
+	return *c.config.ProductVariables.PgoAdditionalProfileDirs
+}
+
 func (c *deviceConfig) CompileVndk() bool {
 	if c.config.ProductVariables.DeviceVndkVersion == nil {
 		return false
@@ -548,6 +869,12 @@ func (c *deviceConfig) NativeCoverageEnabled() bool {
 	return Bool(c.config.ProductVariables.NativeCoverage)
 }
 
+// ClangCoverageEnabled returns true if native coverage uses clang's source based coverage instead
+// of gcov for the modules compiled with clang.
+func (c *deviceConfig) ClangCoverageEnabled() bool {
+	return Bool(c.config.ProductVariables.ClangCoverage)
+}
+
 func (c *deviceConfig) CoverageEnabledForPath(path string) bool {
 	coverage := false
 	if c.config.ProductVariables.CoveragePaths != nil {
@@ -563,9 +890,83 @@ func (c *deviceConfig) CoverageEnabledForPath(path string) bool {
 	return coverage
 }
 
+// BootJars returns the names of the java libraries on the boot classpath of the device.
+func (c *config) BootJars() []string {
+	if c.ProductVariables.BootJars == nil {
+		return nil
+	}
+	return *c.ProductVariables.BootJars
+}
+
+// SystemServerJars returns the names of the java libraries on the system server classpath of the
+// device.
+func (c *config) SystemServerJars() []string {
+	if c.ProductVariables.SystemServerJars == nil {
+		return nil
+	}
+	return *c.ProductVariables.SystemServerJars
+}
+
+// AbOtaUpdater returns true if the device is updated by update_engine with A/B (seamless)
+// updates instead of by recovery.
+func (c *deviceConfig) AbOtaUpdater() bool {
+	return Bool(c.config.ProductVariables.AbOtaUpdater)
+}
+
+// AbOtaPartitions returns the partitions that have an A and a B slot on an A/B device.
+func (c *deviceConfig) AbOtaPartitions() []string {
+	if c.config.ProductVariables.AbOtaPartitions == nil {
+		return nil
+	}
+	return *c.config.ProductVariables.AbOtaPartitions
+}
+
+// VirtualAbOta returns true if the device uses virtual A/B, where the B slot of the partitions
+// is a snapshot instead of a physical partition.
+func (c *deviceConfig) VirtualAbOta() bool {
+	return Bool(c.config.ProductVariables.VirtualAbOta)
+}
+
+// JavaCoverageEnabled returns true if java modules should be instrumented with jacoco.
+func (c *deviceConfig) JavaCoverageEnabled() bool {
+	return c.config.IsEnvTrue("EMMA_INSTRUMENT")
+}
+
+// JavaCoverageEnabledForPath returns true if java modules in the given directory should be
+// instrumented when java coverage is enabled.  All directories are instrumented unless
+// JavaCoveragePaths is set.
+func (c *deviceConfig) JavaCoverageEnabledForPath(path string) bool {
+	coverage := true
+	if c.config.ProductVariables.JavaCoveragePaths != nil {
+		coverage = prefixInList(path, *c.config.ProductVariables.JavaCoveragePaths)
+	}
+	if coverage && c.config.ProductVariables.JavaCoverageExcludePaths != nil {
+		if prefixInList(path, *c.config.ProductVariables.JavaCoverageExcludePaths) {
+			coverage = false
+		}
+	}
+	return coverage
+}
+
 func (c *config) IntegerOverflowDisabledForPath(path string) bool {
 	if c.ProductVariables.IntegerOverflowExcludePaths == nil {
 		return false
 	}
 	return prefixInList(path, *c.ProductVariables.IntegerOverflowExcludePaths)
 }
+
+// CFIEnabledForPath returns true if the product enables CFI for the modules in the path, and the
+// path is not excluded.
+func (c *config) CFIEnabledForPath(path string) bool {
+	if c.ProductVariables.CFIIncludePaths == nil {
+		return false
+	}
+	return prefixInList(path, *c.ProductVariables.CFIIncludePaths) && !c.CFIDisabledForPath(path)
+}
+
+func (c *config) CFIDisabledForPath(path string) bool {
+	if c.ProductVariables.CFIExcludePaths == nil {
+		return false
+	}
+	return prefixInList(path, *c.ProductVariables.CFIExcludePaths)
+}
@@ -0,0 +1,356 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+var validateBuildVersionTestCases = []struct {
+	name      string
+	variables productVariables
+	valid     bool
+}{
+	{
+		name:      "empty",
+		variables: productVariables{},
+		valid:     true,
+	},
+	{
+		name: "all set",
+		variables: productVariables{
+			BuildId:                 stringPtr("OPM1.171019.011"),
+			BuildNumber:             stringPtr("eng.builder.20171019.123456"),
+			Platform_security_patch: stringPtr("2017-10-05"),
+			Lineage_version:         stringPtr("15.1-20171019-NIGHTLY-bacon"),
+			Lineage_build_type:      stringPtr("NIGHTLY"),
+		},
+		valid: true,
+	},
+	{
+		name: "bad build id",
+		variables: productVariables{
+			BuildId: stringPtr("OPM1 171019"),
+		},
+	},
+	{
+		name: "bad security patch",
+		variables: productVariables{
+			Platform_security_patch: stringPtr("2017-13-05"),
+		},
+	},
+	{
+		name: "short security patch",
+		variables: productVariables{
+			Platform_security_patch: stringPtr("2017-10"),
+		},
+	},
+	{
+		name: "bad lineage version",
+		variables: productVariables{
+			Lineage_version: stringPtr("15.1-NIGHTLY"),
+		},
+	},
+	{
+		name: "bad lineage build type",
+		variables: productVariables{
+			Lineage_build_type: stringPtr("nightly"),
+		},
+	},
+	{
+		name: "negative dist retention",
+		variables: productVariables{
+			Lineage_dist_retention: intPtr(-1),
+		},
+	},
+}
+
+func TestValidateBuildVersionVariables(t *testing.T) {
+	for _, testCase := range validateBuildVersionTestCases {
+		err := validateBuildVersionVariables(&testCase.variables)
+		if testCase.valid && err != nil {
+			t.Errorf("test case %s: unexpected error %s", testCase.name, err)
+		} else if !testCase.valid && err == nil {
+			t.Errorf("test case %s: expected error", testCase.name)
+		}
+	}
+}
+
+func TestBuildVersionProperties(t *testing.T) {
+	config := TestConfig("out")
+	if props := config.BuildVersionProperties(); len(props) != 0 {
+		t.Errorf("expected no build version properties, got %q", props)
+	}
+	if g, w := config.AppVersionName(), config.PlatformVersion()+"-"+config.BuildNumber(); g != w {
+		t.Errorf("expected app version name %q, got %q", w, g)
+	}
+
+	config.ProductVariables.BuildId = stringPtr("OPM1.171019.011")
+	config.ProductVariables.Platform_security_patch = stringPtr("2017-10-05")
+	config.ProductVariables.Lineage_version = stringPtr("15.1-20171019-NIGHTLY-bacon")
+	expected := []string{
+		"ro.build.id=OPM1.171019.011",
+		"ro.build.version.security_patch=2017-10-05",
+		"ro.lineage.display.version=15.1-20171019-NIGHTLY-bacon",
+	}
+	if g := config.BuildVersionProperties(); !reflect.DeepEqual(g, expected) {
+		t.Errorf("expected build version properties %q, got %q", expected, g)
+	}
+
+	config.ProductVariables.Lineage_display_version = stringPtr("15.1-bacon")
+	if g, w := config.AppVersionName(), "15.1-bacon"; g != w {
+		t.Errorf("expected app version name %q, got %q", w, g)
+	}
+
+	buf := &bytes.Buffer{}
+	writeBuildVersionAndroidMk(buf, config)
+	w := "\nADDITIONAL_BUILD_PROPERTIES += ro.build.id=OPM1.171019.011 " +
+		"ro.build.version.security_patch=2017-10-05 ro.lineage.display.version=15.1-bacon\n"
+	if buf.String() != w {
+		t.Errorf("expected %q, got %q", w, buf.String())
+	}
+}
+
+func TestCFIPaths(t *testing.T) {
+	c := &config{
+		ProductVariables: productVariables{
+			CFIIncludePaths: &[]string{"frameworks/av", "system/bt"},
+			CFIExcludePaths: &[]string{"frameworks/av/media/libstagefright/codecs"},
+		},
+	}
+
+	testCases := []struct {
+		path              string
+		enabled, disabled bool
+	}{
+		{path: "frameworks/av/media/libmedia", enabled: true},
+		{path: "system/bt/stack", enabled: true},
+		{path: "frameworks/av/media/libstagefright/codecs/aacdec", disabled: true},
+		{path: "frameworks/base"},
+	}
+
+	for _, test := range testCases {
+		if enabled := c.CFIEnabledForPath(test.path); enabled != test.enabled {
+			t.Errorf("CFIEnabledForPath(%q) = %t, expected %t", test.path, enabled, test.enabled)
+		}
+		if disabled := c.CFIDisabledForPath(test.path); disabled != test.disabled {
+			t.Errorf("CFIDisabledForPath(%q) = %t, expected %t", test.path, disabled, test.disabled)
+		}
+	}
+}
+
+func TestProductAaptPseudoLocales(t *testing.T) {
+	testCases := []struct {
+		name                      string
+		debuggable, pseudoLocales *bool
+		expected                  bool
+	}{
+		{name: "user"},
+		{name: "userdebug", debuggable: boolPtr(true), expected: true},
+		{name: "userdebug disabled", debuggable: boolPtr(true), pseudoLocales: boolPtr(false)},
+		{name: "user enabled", pseudoLocales: boolPtr(true), expected: true},
+	}
+
+	for _, test := range testCases {
+		c := &config{
+			ProductVariables: productVariables{
+				Debuggable:        test.debuggable,
+				AAPTPseudoLocales: test.pseudoLocales,
+			},
+		}
+		if pseudoLocales := c.ProductAaptPseudoLocales(); pseudoLocales != test.expected {
+			t.Errorf("%s: ProductAaptPseudoLocales() = %t, expected %t", test.name, pseudoLocales,
+				test.expected)
+		}
+		aaptConfig := c.ProductAaptConfig()
+		if hasPseudoLocales := inList("en_XA", aaptConfig) && inList("ar_XB", aaptConfig); hasPseudoLocales != test.expected {
+			t.Errorf("%s: ProductAaptConfig() = %q, expected pseudo-locales %t", test.name, aaptConfig,
+				test.expected)
+		}
+	}
+}
+
+func TestDecodeNativeBridgeTargets(t *testing.T) {
+	deviceTargets := map[OsClass][]Target{
+		Device: []Target{
+			{Os: Android, Arch: Arch{ArchType: X86_64}},
+			{Os: Android, Arch: Arch{ArchType: X86}},
+		},
+	}
+
+	testCases := []struct {
+		name      string
+		variables productVariables
+		expected  []string
+		paths     []string
+		err       bool
+	}{
+		{
+			name:      "none",
+			variables: productVariables{DeviceArch: stringPtr("x86_64")},
+		},
+		{
+			name: "arm64 and arm",
+			variables: productVariables{
+				DeviceArch:                        stringPtr("x86_64"),
+				NativeBridgeArch:                  stringPtr("arm64"),
+				NativeBridgeArchVariant:           stringPtr("armv8-a"),
+				NativeBridgeSecondaryArch:         stringPtr("arm"),
+				NativeBridgeSecondaryArchVariant:  stringPtr("armv7-a-neon"),
+				NativeBridgeSecondaryRelativePath: stringPtr("arm/nb"),
+			},
+			expected: []string{"android_arm64_armv8-a_native_bridge", "android_arm_armv7-a-neon_native_bridge"},
+			paths:    []string{"arm64", "arm/nb"},
+		},
+		{
+			name: "device arch",
+			variables: productVariables{
+				DeviceArch:       stringPtr("x86_64"),
+				NativeBridgeArch: stringPtr("x86"),
+			},
+			err: true,
+		},
+		{
+			name: "no device arch",
+			variables: productVariables{
+				NativeBridgeArch: stringPtr("arm"),
+			},
+			err: true,
+		},
+	}
+
+	for _, test := range testCases {
+		c := &config{
+			ProductVariables: test.variables,
+			Targets:          deviceTargets,
+		}
+		targets, err := decodeNativeBridgeTargets(c)
+		if test.err {
+			if err == nil {
+				t.Errorf("test case %s: expected error", test.name)
+			}
+			continue
+		} else if err != nil {
+			t.Errorf("test case %s: unexpected error %s", test.name, err)
+			continue
+		}
+
+		var names, paths []string
+		for _, target := range targets {
+			if !target.NativeBridge || target.Arch.Native {
+				t.Errorf("test case %s: %s is not a native bridge target", test.name, target)
+			}
+			names = append(names, target.String())
+			paths = append(paths, target.NativeBridgeRelativePath)
+		}
+		if !reflect.DeepEqual(names, test.expected) {
+			t.Errorf("test case %s: expected targets %q, got %q", test.name, test.expected, names)
+		}
+		if !reflect.DeepEqual(paths, test.paths) {
+			t.Errorf("test case %s: expected paths %q, got %q", test.name, test.paths, paths)
+		}
+	}
+}
+
+func TestApplyBuildPreset(t *testing.T) {
+	testCases := []struct {
+		name       string
+		variables  productVariables
+		debuggable bool
+		eng        bool
+		err        bool
+	}{
+		{
+			name:      "none",
+			variables: productVariables{},
+		},
+		{
+			name:       "userdebug_hardened",
+			variables:  productVariables{Build_preset: stringPtr("userdebug_hardened")},
+			debuggable: true,
+		},
+		{
+			name: "eng",
+			variables: productVariables{
+				Build_preset: stringPtr("eng"),
+				Debuggable:   boolPtr(true),
+			},
+			debuggable: true,
+			eng:        true,
+		},
+		{
+			name: "conflicting debuggable",
+			variables: productVariables{
+				Build_preset: stringPtr("user"),
+				Debuggable:   boolPtr(true),
+			},
+			err: true,
+		},
+		{
+			name:      "unknown preset",
+			variables: productVariables{Build_preset: stringPtr("hardened")},
+			err:       true,
+		},
+	}
+
+	for _, test := range testCases {
+		err := applyBuildPreset(&test.variables)
+		if test.err {
+			if err == nil {
+				t.Errorf("test case %s: expected error", test.name)
+			}
+			continue
+		} else if err != nil {
+			t.Errorf("test case %s: unexpected error %s", test.name, err)
+			continue
+		}
+
+		if Bool(test.variables.Debuggable) != test.debuggable || Bool(test.variables.Eng) != test.eng {
+			t.Errorf("test case %s: expected debuggable %t and eng %t, got %t and %t", test.name,
+				test.debuggable, test.eng, Bool(test.variables.Debuggable), Bool(test.variables.Eng))
+		}
+	}
+}
+
+func TestVendorConfig(t *testing.T) {
+	c := &config{}
+	c.ProductVariables.VendorVars = map[string]map[string]string{
+		"lineage": {
+			"board":        "msm8998",
+			"uses_camera1": "true",
+			"uses_nfc":     "no",
+			"uses_hwc2":    "yes",
+			"empty":        "",
+		},
+	}
+
+	lineage := c.VendorConfig("lineage")
+	if got := lineage.String("board"); got != "msm8998" {
+		t.Errorf("expected board msm8998, got %q", got)
+	}
+	if !lineage.Bool("uses_camera1") || lineage.Bool("uses_nfc") || lineage.Bool("uses_hwc2") ||
+		lineage.Bool("missing") {
+		t.Errorf("expected only uses_camera1 to be true")
+	}
+	if !lineage.IsSet("empty") || lineage.IsSet("missing") {
+		t.Errorf("expected empty to be set and missing to be unset")
+	}
+
+	if other := c.VendorConfig("other"); other.IsSet("board") || other.String("board") != "" {
+		t.Errorf("expected the variables of an undeclared namespace to be unset")
+	}
+}
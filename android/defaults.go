@@ -15,6 +15,8 @@
 package android
 
 import (
+	"reflect"
+
 	"github.com/google/blueprint"
 	"github.com/google/blueprint/proptools"
 )
@@ -61,15 +63,27 @@ func InitDefaultableModule(module DefaultableModule) {
 	module.AddProperties(module.defaults())
 }
 
+type defaultsModuleProperties struct {
+	// name of a soong_config_bool_variable or soong_config_string_variable module.  The
+	// properties of these defaults are only used when the bool variable is "true" or the string
+	// variable is set, with %s replaced by its value, and override the properties of the module
+	// like soong_config_variables.
+	Soong_config_variable *string
+}
+
 type DefaultsModuleBase struct {
 	DefaultableModuleBase
 	defaultProperties []interface{}
+
+	defaultsModuleProperties defaultsModuleProperties
 }
 
 type Defaults interface {
 	Defaultable
 	isDefaults() bool
 	properties() []interface{}
+	soongConfigVariable() string
+	defaultsProperties() *defaultsModuleProperties
 }
 
 func (d *DefaultsModuleBase) isDefaults() bool {
@@ -80,6 +94,14 @@ func (d *DefaultsModuleBase) properties() []interface{} {
 	return d.defaultableProperties
 }
 
+func (d *DefaultsModuleBase) soongConfigVariable() string {
+	return String(d.defaultsModuleProperties.Soong_config_variable)
+}
+
+func (d *DefaultsModuleBase) defaultsProperties() *defaultsModuleProperties {
+	return &d.defaultsModuleProperties
+}
+
 func InitDefaultsModule(module DefaultableModule) {
 	module.AddProperties(
 		&hostAndDeviceProperties{},
@@ -89,34 +111,75 @@ func InitDefaultsModule(module DefaultableModule) {
 	InitArchModule(module)
 	InitDefaultableModule(module)
 
-	module.AddProperties(&module.base().nameProperties)
+	module.AddProperties(&module.base().nameProperties,
+		module.(Defaults).defaultsProperties())
 
 	module.base().module = module
 }
 
 var _ Defaults = (*DefaultsModuleBase)(nil)
 
+// applyDefaults merges the properties of the defaults modules into the module.  The defaults are
+// listed from the lowest to the highest priority: the lists of all the defaults are prepended to
+// the lists of the module in the order of the defaults, and a value set by the module overrides
+// the values of the defaults, of which a later one overrides an earlier one.  The defaults with a
+// soong_config_variable are then appended to the module if the variable is true or set.
 func (defaultable *DefaultableModuleBase) applyDefaults(ctx TopDownMutatorContext,
 	defaultsList []Defaults) {
 
+	var conditionalDefaults []Defaults
+	var values []interface{}
 	for _, defaults := range defaultsList {
-		for _, prop := range defaultable.defaultableProperties {
+		if name := defaults.soongConfigVariable(); name != "" {
+			if value, ok := soongConfigVariableValue(ctx.AConfig(), name); ok {
+				conditionalDefaults = append(conditionalDefaults, defaults)
+				values = append(values, value)
+			}
+		}
+	}
+
+	for _, prop := range defaultable.defaultableProperties {
+		merged := reflect.New(reflect.TypeOf(prop).Elem()).Interface()
+		found := false
+		for _, defaults := range defaultsList {
+			if defaults.soongConfigVariable() != "" {
+				continue
+			}
+			for _, def := range defaults.properties() {
+				if proptools.TypeEqual(prop, def) {
+					reportExtendError(ctx, proptools.AppendProperties(merged, def, nil))
+					found = true
+				}
+			}
+		}
+		if found {
+			reportExtendError(ctx, proptools.PrependProperties(prop, merged, nil))
+		}
+
+		for i, defaults := range conditionalDefaults {
 			for _, def := range defaults.properties() {
 				if proptools.TypeEqual(prop, def) {
-					err := proptools.PrependProperties(prop, def, nil)
-					if err != nil {
-						if propertyErr, ok := err.(*proptools.ExtendPropertyError); ok {
-							ctx.PropertyErrorf(propertyErr.Property, "%s", propertyErr.Err.Error())
-						} else {
-							panic(err)
-						}
-					}
+					// The defaults are shared by all the modules that use them, the value of the
+					// variable is printed into a copy
+					clone := proptools.CloneProperties(reflect.ValueOf(def).Elem())
+					printfIntoProperties(ctx, "defaults", clone.Elem(), values[i])
+					reportExtendError(ctx, proptools.AppendProperties(prop, clone.Interface(), nil))
 				}
 			}
 		}
 	}
 }
 
+func reportExtendError(ctx TopDownMutatorContext, err error) {
+	if err != nil {
+		if propertyErr, ok := err.(*proptools.ExtendPropertyError); ok {
+			ctx.PropertyErrorf(propertyErr.Property, "%s", propertyErr.Err.Error())
+		} else {
+			panic(err)
+		}
+	}
+}
+
 func RegisterDefaultsPreArchMutators(ctx RegisterMutatorsContext) {
 	ctx.BottomUp("defaults_deps", defaultsDepsMutator).Parallel()
 	ctx.TopDown("defaults", defaultsMutator).Parallel()
@@ -129,12 +192,22 @@ func defaultsDepsMutator(ctx BottomUpMutatorContext) {
 }
 
 func defaultsMutator(ctx TopDownMutatorContext) {
+	if defaults, ok := ctx.Module().(Defaults); ok {
+		if name := defaults.soongConfigVariable(); name != "" {
+			if _, declared := soongConfigVariableDeclarations(ctx.AConfig()).get(name); !declared {
+				ctx.PropertyErrorf("soong_config_variable", "unknown soong config variable %q", name)
+			}
+		}
+	}
+
 	if defaultable, ok := ctx.Module().(Defaultable); ok && len(defaultable.defaults().Defaults) > 0 {
-		var defaultsList []Defaults
+		// The defaults of a defaults module have a lower priority than the defaults module
+		// itself, so they are listed before it.
+		children := make(map[blueprint.Module][]Defaults)
 		ctx.WalkDeps(func(module, parent blueprint.Module) bool {
 			if ctx.OtherModuleDependencyTag(module) == DefaultsDepTag {
 				if defaults, ok := module.(Defaults); ok {
-					defaultsList = append(defaultsList, defaults)
+					children[parent] = append(children[parent], defaults)
 					return len(defaults.defaults().Defaults) > 0
 				} else {
 					ctx.PropertyErrorf("defaults", "module %s is not an defaults module",
@@ -143,6 +216,17 @@ func defaultsMutator(ctx TopDownMutatorContext) {
 			}
 			return false
 		})
+
+		var defaultsList []Defaults
+		var addDefaults func(parent blueprint.Module)
+		addDefaults = func(parent blueprint.Module) {
+			for _, defaults := range children[parent] {
+				addDefaults(defaults.(blueprint.Module))
+				defaultsList = append(defaultsList, defaults)
+			}
+		}
+		addDefaults(ctx.Module())
+
 		defaultable.applyDefaults(ctx, defaultsList)
 	}
 }
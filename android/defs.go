@@ -15,6 +15,8 @@
 package android
 
 import (
+	"encoding/base64"
+
 	"github.com/google/blueprint"
 	_ "github.com/google/blueprint/bootstrap"
 )
@@ -90,6 +92,18 @@ var (
 		},
 		"content")
 
+	// writeEncodedFile decodes the base64 encoded $content into $out.  The content is passed
+	// through the response file of the rule, which has no length limit, and Ninja reruns the rule
+	// when it changes.
+	writeEncodedFile = pctx.AndroidStaticRule("writeEncodedFile",
+		blueprint.RuleParams{
+			Command:        "base64 -d $out.rsp > $out",
+			Description:    "writing file $out",
+			Rspfile:        "$out.rsp",
+			RspfileContent: "$content",
+		},
+		"content")
+
 	// Used only when USE_GOMA=true is set, to restrict non-goma jobs to the local parallelism value
 	localPool = blueprint.NewBuiltinPool("local_pool")
 )
@@ -97,3 +111,16 @@ var (
 func init() {
 	pctx.Import("github.com/google/blueprint/bootstrap")
 }
+
+// WriteFileRule writes data to file with a build rule, for singletons that generate files too
+// large or not printable enough for the command line of WriteFile.  The file is only rewritten
+// when the data changes.
+func WriteFileRule(ctx blueprint.SingletonContext, file WritablePath, data []byte) {
+	ctx.Build(pctx, blueprint.BuildParams{
+		Rule:    writeEncodedFile,
+		Outputs: []string{file.String()},
+		Args: map[string]string{
+			"content": base64.StdEncoding.EncodeToString(data),
+		},
+	})
+}
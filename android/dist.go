@@ -0,0 +1,100 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// This file implements the dist property, which copies the output file of a module into the
+// DIST_DIR when one of the named goals is built with "m dist <goals>", through the
+// dist-for-goals function of Make.
+
+type Dist struct {
+	// list of goals that copy the output file of the primary device variant of the module, or of
+	// the primary host variant of host-only modules, into the DIST_DIR when they are built together with the dist goal
+	Targets []string
+
+	// name of the file in the DIST_DIR.  Defaults to the name of the output file of the module.
+	Dest *string
+
+	// directory in the DIST_DIR that the file is copied into.  Defaults to the DIST_DIR itself.
+	Dir *string
+
+	// suffix added to the name of the file before its extension
+	Suffix *string
+}
+
+// validateDist checks the dist property of a module.
+func (a *ModuleBase) validateDist(ctx ModuleContext) {
+	dist := &a.commonProperties.Dist
+	if dist.Dest != nil && (*dist.Dest == "" || strings.Contains(*dist.Dest, "/")) {
+		ctx.PropertyErrorf("dist.dest", "%q must be a file name", *dist.Dest)
+	}
+	if dist.Dir != nil {
+		dir := *dist.Dir
+		if dir != filepath.Clean(dir) || filepath.IsAbs(dir) || dir == ".." ||
+			strings.HasPrefix(dir, "../") {
+			ctx.PropertyErrorf("dist.dir", "%q must be a clean relative path", dir)
+		}
+	}
+	if (dist.Dest != nil || dist.Dir != nil || dist.Suffix != nil) && len(dist.Targets) == 0 {
+		ctx.PropertyErrorf("dist.targets", "missing the goals that the file is copied for")
+	}
+}
+
+// distVariant returns whether the module is the variant that the dist property copies into the
+// DIST_DIR: the primary device variant, or the primary host variant of modules that are only
+// built for the host.
+func (a *ModuleBase) distVariant() bool {
+	if !a.ArchSpecific() {
+		return true
+	}
+	if !a.TargetPrimary() {
+		return false
+	}
+	if a.DeviceSupported() {
+		return a.Os().Class == Device
+	}
+	return a.Os().Class == Host
+}
+
+// distDest returns the path in the DIST_DIR that the dist property copies outputFile to.
+func distDest(dist Dist, outputFile Path) string {
+	dest := outputFile.Base()
+	if dist.Dest != nil {
+		dest = *dist.Dest
+	}
+	if dist.Suffix != nil {
+		ext := filepath.Ext(dest)
+		dest = strings.TrimSuffix(dest, ext) + *dist.Suffix + ext
+	}
+	if dist.Dir != nil {
+		dest = filepath.Join(*dist.Dir, dest)
+	}
+	return dest
+}
+
+// writeDistForGoals writes the dist-for-goals call for the dist property of a module.
+func writeDistForGoals(w io.Writer, dist Dist, outputFile Path) {
+	if len(dist.Targets) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "$(call dist-for-goals,%s,%s:%s)\n", strings.Join(dist.Targets, " "),
+		outputFile.String(), distDest(dist, outputFile))
+}
@@ -0,0 +1,128 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+var distForGoalsTestCases = []struct {
+	name string
+	dist Dist
+	out  string
+}{
+	{
+		name: "no targets",
+		dist: Dist{},
+		out:  "",
+	},
+	{
+		name: "default",
+		dist: Dist{Targets: []string{"droidcore", "sdk"}},
+		out:  "$(call dist-for-goals,droidcore sdk,out/Updater.apk:Updater.apk)\n",
+	},
+	{
+		name: "dest and dir",
+		dist: Dist{Targets: []string{"droidcore"}, Dest: stringPtr("LineageUpdater.apk"), Dir: stringPtr("apps")},
+		out:  "$(call dist-for-goals,droidcore,out/Updater.apk:apps/LineageUpdater.apk)\n",
+	},
+	{
+		name: "suffix",
+		dist: Dist{Targets: []string{"droidcore"}, Suffix: stringPtr("-unsigned")},
+		out:  "$(call dist-for-goals,droidcore,out/Updater.apk:Updater-unsigned.apk)\n",
+	},
+}
+
+func TestDistForGoals(t *testing.T) {
+	for _, testCase := range distForGoalsTestCases {
+		buf := &bytes.Buffer{}
+		writeDistForGoals(buf, testCase.dist, PathForTesting("out/Updater.apk"))
+		if buf.String() != testCase.out {
+			t.Errorf("%s: expected %q, got %q", testCase.name, testCase.out, buf.String())
+		}
+	}
+}
+
+type distTestModule struct {
+	ModuleBase
+}
+
+func newDistTestModule(hod HostOrDeviceSupported) func() Module {
+	return func() Module {
+		m := &distTestModule{}
+		InitAndroidArchModule(m, hod, MultilibBoth)
+		return m
+	}
+}
+
+func (m *distTestModule) DepsMutator(ctx BottomUpMutatorContext) {
+}
+
+func (m *distTestModule) GenerateAndroidBuildActions(ctx ModuleContext) {
+}
+
+func TestDistVariant(t *testing.T) {
+	buildDir, err := ioutil.TempDir("", "soong_dist_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(buildDir)
+
+	f := NewTestArchFixture(buildDir)
+	f.RegisterModuleType("host_and_device_module",
+		ModuleFactoryAdaptor(newDistTestModule(HostAndDeviceSupported)))
+	f.RegisterModuleType("host_module", ModuleFactoryAdaptor(newDistTestModule(HostSupported)))
+	f.AddBlueprint(`
+		host_and_device_module {
+			name: "foo",
+			host_supported: true,
+			dist: {
+				targets: ["droidcore"],
+			},
+		}
+
+		host_module {
+			name: "bar",
+			dist: {
+				targets: ["droidcore"],
+			},
+		}
+	`)
+	f.Prepare(t)
+
+	// Only one variant of each module is copied into the DIST_DIR, as all of them would be copied
+	// to the same file
+	testCases := []struct {
+		name, variant string
+		dist          bool
+	}{
+		{"foo", "android_arm64", true},
+		{"foo", "android_arm", false},
+		{"foo", BuildOs.String() + "_x86_64", false},
+		{"foo", BuildOs.String() + "_x86", false},
+		{"bar", BuildOs.String() + "_x86_64", true},
+		{"bar", BuildOs.String() + "_x86", false},
+	}
+	for _, testCase := range testCases {
+		m := f.ModuleForTests(testCase.name, testCase.variant).Module().base()
+		if m.distVariant() != testCase.dist {
+			t.Errorf("%s variant %s: expected dist %t, got %t", testCase.name, testCase.variant,
+				testCase.dist, m.distVariant())
+		}
+	}
+}
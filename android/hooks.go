@@ -31,6 +31,13 @@ type LoadHookContext interface {
 	BaseContext
 	AppendProperties(...interface{})
 	PrependProperties(...interface{})
+
+	// CreateModule creates a new module in the directory of the module with the properties
+	// structs, which must include the name of the new module.  The new module inherits the
+	// enabled, visibility and partition properties of the module, and is split into variants and
+	// gets its defaults applied like modules from blueprint files, but its own load hooks are not
+	// run.
+	CreateModule(factory ModuleFactory, props ...interface{})
 }
 
 // Arch hooks are run after the module has been split into architecture variants, and can be used
@@ -83,11 +90,45 @@ func (ctx *propertyHookContext) PrependProperties(props ...interface{}) {
 	}
 }
 
-func (x *hooks) runLoadHooks(ctx BaseContext, m *ModuleBase) {
+type loadHookContext struct {
+	propertyHookContext
+
+	mctx TopDownMutatorContext
+}
+
+// inheritedProperties are the properties that the modules created by load hooks inherit from the
+// module that created them, so that they are disabled, restricted and installed with it.
+type inheritedProperties struct {
+	Enabled             *bool
+	Visibility          []string
+	Proprietary         bool
+	Owner               *string
+	Vendor              bool
+	System_ext_specific bool
+}
+
+func (ctx *loadHookContext) CreateModule(factory ModuleFactory, props ...interface{}) {
+	common := &ctx.module.commonProperties
+	inherited := &inheritedProperties{
+		Enabled:             common.Enabled,
+		Visibility:          common.Visibility,
+		Proprietary:         common.Proprietary,
+		Owner:               common.Owner,
+		Vendor:              common.Vendor,
+		System_ext_specific: common.System_ext_specific,
+	}
+
+	ctx.mctx.CreateModule(ModuleFactoryAdaptor(factory), append([]interface{}{inherited}, props...)...)
+}
+
+func (x *hooks) runLoadHooks(ctx TopDownMutatorContext, m *ModuleBase) {
 	if len(x.load) > 0 {
-		mctx := &propertyHookContext{
-			BaseContext: ctx,
-			module:      m,
+		mctx := &loadHookContext{
+			propertyHookContext: propertyHookContext{
+				BaseContext: ctx,
+				module:      m,
+			},
+			mctx: ctx,
 		}
 		for _, x := range x.load {
 			x(mctx)
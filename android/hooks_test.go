@@ -0,0 +1,111 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/google/blueprint"
+	"github.com/google/blueprint/proptools"
+)
+
+type loadHookTestModule struct {
+	ModuleBase
+	properties struct {
+		Create_module *bool
+	}
+}
+
+// newLoadHookTestModule returns a module whose load hook creates a module named after it with a
+// "_created" suffix when create_module is set.
+func newLoadHookTestModule() Module {
+	m := &loadHookTestModule{}
+	m.AddProperties(&m.properties)
+	InitAndroidArchModule(m, DeviceSupported, MultilibFirst)
+	AddLoadHook(m, func(ctx LoadHookContext) {
+		if !Bool(m.properties.Create_module) {
+			return
+		}
+		props := struct {
+			Name          string
+			Create_module *bool
+		}{
+			Name:          ctx.ModuleName() + "_created",
+			Create_module: proptools.BoolPtr(true),
+		}
+		ctx.CreateModule(newLoadHookTestModule, &props)
+	})
+	return m
+}
+
+func (m *loadHookTestModule) DepsMutator(ctx BottomUpMutatorContext) {
+}
+
+func (m *loadHookTestModule) GenerateAndroidBuildActions(ctx ModuleContext) {
+}
+
+func TestLoadHookCreateModule(t *testing.T) {
+	buildDir, err := ioutil.TempDir("", "soong_hooks_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(buildDir)
+
+	f := NewTestArchFixture(buildDir)
+	f.RegisterModuleType("load_hook_test_module", ModuleFactoryAdaptor(newLoadHookTestModule))
+	f.PreArchMutators(func(ctx RegisterMutatorsContext) {
+		ctx.TopDown("load_hooks", loadHookMutator).Parallel()
+	})
+	f.AddBlueprint(`
+		load_hook_test_module {
+			name: "foo",
+			create_module: true,
+			vendor: true,
+			owner: "lineage",
+		}
+
+		load_hook_test_module {
+			name: "bar",
+			create_module: true,
+			enabled: false,
+		}
+	`)
+	f.Prepare(t)
+
+	// The created modules are split into variants like the modules from blueprint files, and
+	// inherit the properties that decide whether and where they are built
+	foo := f.ModuleForTests("foo_created", "android_arm64").Module().base()
+	if !foo.commonProperties.Vendor {
+		t.Errorf("foo_created is not a vendor module")
+	}
+	if owner := String(foo.commonProperties.Owner); owner != "lineage" {
+		t.Errorf("expected foo_created to be owned by %q, got %q", "lineage", owner)
+	}
+	if !foo.Enabled() {
+		t.Errorf("foo_created is disabled")
+	}
+	if bar := f.ModuleForTests("bar_created", "android_arm64").Module(); bar.Enabled() {
+		t.Errorf("bar_created is enabled")
+	}
+
+	// The load hooks of the created modules are not run
+	f.VisitAllModules(func(m blueprint.Module) {
+		if name := f.ModuleName(m); name == "foo_created_created" || name == "bar_created_created" {
+			t.Errorf("unexpected module %q", name)
+		}
+	})
+}
@@ -0,0 +1,49 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+// This file installs the init_rc files of device modules into the etc/init directory of their
+// partition, where init reads them on boot.  Make installs the files itself from LOCAL_INIT_RC
+// when Soong is embedded in it, the installation here makes them part of the images built by
+// Soong.
+
+func (a *ModuleBase) installInitRc(ctx *androidModuleContext) {
+	if len(a.commonProperties.Init_rc) == 0 {
+		return
+	}
+	if !ctx.Device() {
+		ctx.PropertyErrorf("init_rc", "only supported by device modules")
+		return
+	}
+	// The services of multilib modules are declared once, from the first variant, which is also
+	// the only one of modules that are only built for the secondary architecture
+	if !ctx.TargetPrimary() {
+		return
+	}
+
+	dir := PathForModuleInstall(ctx, "etc", "init")
+	for _, rc := range PathsForModuleSrc(ctx, a.commonProperties.Init_rc) {
+		if rc.Ext() != ".rc" {
+			ctx.PropertyErrorf("init_rc", "%q is not an .rc file", rc.Rel())
+			continue
+		}
+		ctx.InstallFile(dir, rc)
+	}
+}
+
+// RequiredModuleNames returns the names of the modules that are installed with the module.
+func (a *ModuleBase) RequiredModuleNames() []string {
+	return a.commonProperties.Required
+}
@@ -0,0 +1,82 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+type initRcModule struct {
+	ModuleBase
+}
+
+func newInitRcModule() Module {
+	m := &initRcModule{}
+	InitAndroidArchModule(m, DeviceSupported, MultilibBoth)
+	return m
+}
+
+func (m *initRcModule) DepsMutator(ctx BottomUpMutatorContext) {
+}
+
+func (m *initRcModule) GenerateAndroidBuildActions(ctx ModuleContext) {
+}
+
+func TestInitRc(t *testing.T) {
+	buildDir, err := ioutil.TempDir("", "soong_init_rc_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(buildDir)
+
+	f := NewTestArchFixture(buildDir)
+	f.RegisterModuleType("init_rc_module", ModuleFactoryAdaptor(newInitRcModule))
+	f.AddBlueprint(`
+		init_rc_module {
+			name: "both",
+			init_rc: ["both.rc"],
+		}
+
+		init_rc_module {
+			name: "only32",
+			compile_multilib: "32",
+			init_rc: ["only32.rc"],
+		}
+	`)
+	f.AddFiles("both.rc", "only32.rc")
+	f.Prepare(t)
+
+	// The files are installed once per module, from its first variant
+	testCases := []struct {
+		name, variant string
+		installed     []string
+	}{
+		{"both", "android_arm64", []string{"target/product/test_device/system/etc/init/both.rc"}},
+		{"both", "android_arm", nil},
+		{"only32", "android_arm", []string{"target/product/test_device/system/etc/init/only32.rc"}},
+	}
+	for _, test := range testCases {
+		var installed []string
+		for _, spec := range f.ModuleForTests(test.name, test.variant).Module().PackagingSpecs() {
+			installed = append(installed, spec.InstallPath.Rel())
+		}
+		if len(installed) != len(test.installed) ||
+			(len(installed) > 0 && installed[0] != test.installed[0]) {
+			t.Errorf("%s %s installs %q, want %q", test.name, test.variant, installed, test.installed)
+		}
+	}
+}
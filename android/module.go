@@ -62,8 +62,10 @@ type androidBaseContext interface {
 	Debug() bool
 	PrimaryArch() bool
 	Vendor() bool
+	SystemExtSpecific() bool
 	AConfig() Config
 	DeviceConfig() DeviceConfig
+	Tracef(format string, args ...interface{})
 }
 
 type BaseContext interface {
@@ -92,6 +94,8 @@ type ModuleContext interface {
 
 	InstallInData() bool
 	InstallInSanitizerDir() bool
+	InstallInRecovery() bool
+	InstallInRamdisk() bool
 
 	RequiredModuleNames() []string
 }
@@ -107,7 +111,12 @@ type Module interface {
 	Target() Target
 	InstallInData() bool
 	InstallInSanitizerDir() bool
+	InstallInRecovery() bool
+	InstallInRamdisk() bool
 	SkipInstall()
+	ExportedToMake() bool
+	PackagingSpecs() []PackagingSpec
+	RequiredModuleNames() []string
 
 	AddProperties(props ...interface{})
 	GetProperties() []interface{}
@@ -126,6 +135,11 @@ type commonProperties struct {
 	// emit build rules for this module
 	Enabled *bool `android:"arch_variant"`
 
+	// list of the directories whose modules can depend on this module, in the format
+	// "//some/dir:__pkg__" or "//some/dir:__subpackages__", or "//visibility:public" or
+	// "//visibility:private".  Defaults to "//visibility:public".
+	Visibility []string
+
 	// control whether this module compiles for 32-bit, 64-bit, or both.  Possible values
 	// are "32" (compile for 32-bit only), "64" (compile for 64-bit only), "both" (compile for both
 	// architectures), or "first" (compile for 64-bit on a 64-bit platform, and 32-bit on a 32-bit
@@ -152,6 +166,10 @@ type commonProperties struct {
 	// whether this module is device specific and should be installed into /vendor
 	Vendor bool
 
+	// whether this module extends system modules, but is not needed to boot the generic system
+	// image, and should be installed into /system_ext
+	System_ext_specific bool
+
 	// *.logtags files, to combine together in order to generate the /system/etc/event-log-tags
 	// file
 	Logtags []string
@@ -159,9 +177,29 @@ type commonProperties struct {
 	// init.rc files to be installed if this module is installed
 	Init_rc []string
 
+	// VINTF manifest fragments to be installed if this module is installed, which declare the
+	// HALs that the module serves
+	Vintf_fragments []string
+
 	// names of other modules to install if this module is installed
 	Required []string `android:"arch_variant"`
 
+	// whether this module is also built for the translated architectures of a device with a
+	// native bridge, for example for arm on an x86 device
+	Native_bridge_supported *bool
+
+	// whether this module is a debugging tool that is only installed if the build preset of the
+	// product installs debug apps, or on debuggable builds if the product doesn't select one
+	Debug_only *bool
+
+	// configuration to copy the output file of this module into the DIST_DIR when some goals
+	// are built with the dist goal
+	Dist Dist
+
+	// list of alternate names of build targets that build this module.  Modules can share an
+	// alias to group them under a single convenience target like "lineage-tests".
+	Aliases []string
+
 	// Set by TargetMutator
 	CompileTarget  Target `blueprint:"mutated"`
 	CompilePrimary bool   `blueprint:"mutated"`
@@ -171,6 +209,16 @@ type commonProperties struct {
 	ArchSpecific          bool                  `blueprint:"mutated"`
 
 	SkipInstall bool `blueprint:"mutated"`
+
+	// Set by the build preset mutator for the debug_only modules that the product doesn't
+	// install, which are still built and exported to Make
+	Uninstallable bool `blueprint:"mutated"`
+
+	// Set by the NameResolver when the module is added to its namespace
+	NamespaceExportedToMake bool `blueprint:"mutated"`
+
+	// Set by the visibility_rules mutator from Visibility, nil if the module is public
+	Visibility_rules []string `blueprint:"mutated"`
 }
 
 type hostAndDeviceProperties struct {
@@ -278,11 +326,14 @@ type ModuleBase struct {
 	hostAndDeviceProperties hostAndDeviceProperties
 	generalProperties       []interface{}
 	archProperties          []interface{}
+	soongConfigProperties   []interface{}
 	customizableProperties  []interface{}
 
 	noAddressSanitizer bool
 	installFiles       Paths
 	checkbuildFiles    Paths
+	packagingSpecs     []PackagingSpec
+	vintfFragments     []PackagingSpec
 
 	// Used by buildTargetSingleton to create checkbuild and per-directory build targets
 	// Only set on the final variant of each module
@@ -294,8 +345,12 @@ type ModuleBase struct {
 
 	registerProps []interface{}
 
-	// For tests
+	// For tests and module summaries
 	buildParams []ModuleBuildParams
+
+	// For module-info.json and module summaries
+	directDeps []string
+	srcs       Paths
 }
 
 func (a *ModuleBase) AddProperties(props ...interface{}) {
@@ -384,6 +439,10 @@ func (a *ModuleBase) DeviceSupported() bool {
 				*a.hostAndDeviceProperties.Device_supported)
 }
 
+func (a *ModuleBase) NativeBridgeSupported() bool {
+	return Bool(a.commonProperties.Native_bridge_supported)
+}
+
 func (a *ModuleBase) Enabled() bool {
 	if a.commonProperties.Enabled == nil {
 		return !a.Os().DefaultDisabled
@@ -425,6 +484,14 @@ func (p *ModuleBase) InstallInSanitizerDir() bool {
 	return false
 }
 
+func (p *ModuleBase) InstallInRecovery() bool {
+	return false
+}
+
+func (p *ModuleBase) InstallInRamdisk() bool {
+	return false
+}
+
 func (a *ModuleBase) generateModuleTarget(ctx blueprint.ModuleContext) {
 	allInstalledFiles := Paths{}
 	allCheckbuildFiles := Paths{}
@@ -482,7 +549,9 @@ func (a *ModuleBase) androidBaseContextFactory(ctx blueprint.BaseModuleContext)
 		target:        a.commonProperties.CompileTarget,
 		targetPrimary: a.commonProperties.CompilePrimary,
 		vendor:        a.commonProperties.Proprietary || a.commonProperties.Vendor,
+		systemExt:     a.commonProperties.System_ext_specific,
 		config:        ctx.Config().(Config),
+		traceName:     traceName(ctx),
 	}
 }
 
@@ -514,13 +583,25 @@ func (a *ModuleBase) GenerateBuildActions(ctx blueprint.ModuleContext) {
 	ctx.Variable(pctx, "moduleDescSuffix", s)
 
 	if a.Enabled() {
+		androidCtx.Tracef("generating build actions")
+		validateProperties(androidCtx, a.module)
+		if ctx.Failed() {
+			return
+		}
+
 		a.module.GenerateAndroidBuildActions(androidCtx)
 		if ctx.Failed() {
 			return
 		}
 
+		a.installInitRc(androidCtx)
+		a.installVintfFragments(androidCtx)
+		a.validateDist(androidCtx)
+
 		a.installFiles = append(a.installFiles, androidCtx.installFiles...)
 		a.checkbuildFiles = append(a.checkbuildFiles, androidCtx.checkbuildFiles...)
+		a.packagingSpecs = append(a.packagingSpecs, androidCtx.packagingSpecs...)
+		a.srcs = androidCtx.expandedSrcs
 	}
 
 	if a == ctx.FinalModule().(Module).base() {
@@ -531,6 +612,9 @@ func (a *ModuleBase) GenerateBuildActions(ctx blueprint.ModuleContext) {
 	}
 
 	a.buildParams = androidCtx.buildParams
+	ctx.VisitDirectDeps(func(m blueprint.Module) {
+		a.directDeps = append(a.directDeps, ctx.OtherModuleName(m))
+	})
 }
 
 type androidBaseContextImpl struct {
@@ -538,7 +622,9 @@ type androidBaseContextImpl struct {
 	targetPrimary bool
 	debug         bool
 	vendor        bool
+	systemExt     bool
 	config        Config
+	traceName     string
 }
 
 type androidModuleContext struct {
@@ -547,9 +633,13 @@ type androidModuleContext struct {
 	installDeps     Paths
 	installFiles    Paths
 	checkbuildFiles Paths
+	packagingSpecs  []PackagingSpec
 	missingDeps     []string
 	module          Module
 
+	// sources returned by ExpandSources, for module-info.json
+	expandedSrcs Paths
+
 	// For tests
 	buildParams []ModuleBuildParams
 }
@@ -575,11 +665,45 @@ func (a *androidModuleContext) Build(pctx blueprint.PackageContext, params bluep
 		return
 	}
 
+	if tmpDirRules[params.Rule] {
+		var output string
+		if len(params.Outputs) > 0 {
+			output = params.Outputs[0]
+		}
+		params.Args = tmpDirArgs(params.Rule, output, params.Args)
+	}
+
 	params.Optional = true
 	a.ModuleContext.Build(pctx, params)
 }
 
+// tmpDirArgs returns the args of a build statement that uses a rule defined with
+// AndroidTmpDirStaticRule, with $tmpDir set to a directory next to the first output, which is
+// unique to the build statement.
+func tmpDirArgs(rule blueprint.Rule, output string, args map[string]string) map[string]string {
+	if output == "" {
+		panic(fmt.Errorf("build statement for rule %s has no outputs", rule))
+	}
+
+	ret := make(map[string]string, len(args)+1)
+	for k, v := range args {
+		ret[k] = v
+	}
+	ret["tmpDir"] = output + ".tmpdir"
+	return ret
+}
+
 func (a *androidModuleContext) ModuleBuild(pctx blueprint.PackageContext, params ModuleBuildParams) {
+	if tmpDirRules[params.Rule] {
+		var output string
+		if params.Output != nil {
+			output = params.Output.String()
+		} else if len(params.Outputs) > 0 {
+			output = params.Outputs[0].String()
+		}
+		params.Args = tmpDirArgs(params.Rule, output, params.Args)
+	}
+
 	if a.config.captureBuild {
 		a.buildParams = append(a.buildParams, params)
 	}
@@ -623,6 +747,9 @@ func (a *androidModuleContext) ModuleBuild(pctx blueprint.PackageContext, params
 		return
 	}
 
+	a.Tracef("build %s: %s <- %s", params.Rule, strings.Join(bparams.Outputs, " "),
+		strings.Join(bparams.Inputs, " "))
+
 	a.ModuleContext.Build(pctx, bparams)
 }
 
@@ -691,6 +818,10 @@ func (a *androidBaseContextImpl) Vendor() bool {
 	return a.vendor
 }
 
+func (a *androidBaseContextImpl) SystemExtSpecific() bool {
+	return a.systemExt
+}
+
 func (a *androidModuleContext) InstallInData() bool {
 	return a.module.InstallInData()
 }
@@ -699,8 +830,22 @@ func (a *androidModuleContext) InstallInSanitizerDir() bool {
 	return a.module.InstallInSanitizerDir()
 }
 
+func (a *androidModuleContext) InstallInRecovery() bool {
+	return a.module.InstallInRecovery()
+}
+
+func (a *androidModuleContext) InstallInRamdisk() bool {
+	return a.module.InstallInRamdisk()
+}
+
 func (a *androidModuleContext) skipInstall(fullInstallPath OutputPath) bool {
-	if a.module.base().commonProperties.SkipInstall {
+	if a.module.base().commonProperties.SkipInstall || a.module.base().commonProperties.Uninstallable {
+		return true
+	}
+
+	// Modules in namespaces that are not selected by the product would install over the modules
+	// with the same names in the selected namespaces
+	if !a.module.base().commonProperties.NamespaceExportedToMake {
 		return true
 	}
 
@@ -722,6 +867,7 @@ func (a *androidModuleContext) InstallFileName(installPath OutputPath, name stri
 
 	fullInstallPath := installPath.Join(a, name)
 	a.module.base().hooks.runInstallHooks(a, fullInstallPath, false)
+	a.packagingSpecs = append(a.packagingSpecs, PackagingSpec{InstallPath: fullInstallPath, SrcPath: srcPath})
 
 	if !a.skipInstall(fullInstallPath) {
 
@@ -760,6 +906,7 @@ func (a *androidModuleContext) InstallFile(installPath OutputPath, srcPath Path,
 func (a *androidModuleContext) InstallSymlink(installPath OutputPath, name string, srcPath OutputPath) OutputPath {
 	fullInstallPath := installPath.Join(a, name)
 	a.module.base().hooks.runInstallHooks(a, fullInstallPath, true)
+	a.packagingSpecs = append(a.packagingSpecs, newPackagingSpecSymlink(fullInstallPath, srcPath))
 
 	if !a.skipInstall(fullInstallPath) {
 
@@ -798,20 +945,25 @@ func isAndroidModule(m blueprint.Module) bool {
 	return ok
 }
 
-func findStringInSlice(str string, slice []string) int {
-	for i, s := range slice {
-		if s == str {
-			return i
-		}
-	}
-	return -1
+// SrcIsModule returns the name of the module that a ":module" or ":module{.tag}" reference
+// refers to, or "" if s is not a reference to a module.
+func SrcIsModule(s string) string {
+	module, _ := SrcIsModuleWithTag(s)
+	return module
 }
 
-func SrcIsModule(s string) string {
-	if len(s) > 1 && s[0] == ':' {
-		return s[1:]
+// SrcIsModuleWithTag splits a ":module{.tag}" reference into the name of the module and the tag
+// that selects one of its outputs.  The tag is "" for ":module" references, and the module is ""
+// if s is not a reference to a module.
+func SrcIsModuleWithTag(s string) (module, tag string) {
+	if len(s) <= 1 || s[0] != ':' {
+		return "", ""
+	}
+	module = s[1:]
+	if i := strings.IndexByte(module, '{'); i >= 0 && strings.HasSuffix(module, "}") {
+		module, tag = module[:i], module[i+1:len(module)-1]
 	}
-	return ""
+	return module, tag
 }
 
 type sourceDependencyTag struct {
@@ -825,13 +977,18 @@ var SourceDepTag sourceDependencyTag
 func ExtractSourcesDeps(ctx BottomUpMutatorContext, srcFiles []string) {
 	var deps []string
 	set := make(map[string]bool)
+	modules := make(map[string]bool)
 
 	for _, s := range srcFiles {
 		if m := SrcIsModule(s); m != "" {
-			if _, found := set[m]; found {
-				ctx.ModuleErrorf("found source dependency duplicate: %q!", m)
-			} else {
-				set[m] = true
+			if _, found := set[s]; found {
+				ctx.ModuleErrorf("found source dependency duplicate: %q!", s)
+				continue
+			}
+			set[s] = true
+			// Different outputs of a module are referenced with different tags
+			if !modules[m] {
+				modules[m] = true
 				deps = append(deps, m)
 			}
 		}
@@ -844,7 +1001,35 @@ type SourceFileProducer interface {
 	Srcs() Paths
 }
 
-// Returns a list of paths expanded from globs and modules referenced using ":module" syntax.
+// SourceExtensionProducer is implemented by modules that can be referenced with ":module" in srcs
+// properties and can tell, before their build actions are generated, whether they provide files
+// with an extension, so that the modules referencing them can add the dependencies the files need.
+type SourceExtensionProducer interface {
+	HasSrcExt(ext string) bool
+}
+
+// OutputFileProducer is implemented by modules whose outputs can be referenced with ":module" or
+// ":module{.tag}" in the properties of other modules.  The tag "" selects the default outputs.
+type OutputFileProducer interface {
+	OutputFiles(tag string) (Paths, error)
+}
+
+// sourcesFromModule returns the files that a ":module" or ":module{.tag}" reference refers to.
+func sourcesFromModule(module blueprint.Module, tag string) (Paths, error) {
+	if srcProducer, ok := module.(SourceFileProducer); ok && tag == "" {
+		return srcProducer.Srcs(), nil
+	}
+	if outProducer, ok := module.(OutputFileProducer); ok {
+		return outProducer.OutputFiles(tag)
+	}
+	if tag != "" {
+		return nil, fmt.Errorf("module does not have tagged outputs")
+	}
+	return nil, fmt.Errorf("not a source file producing module")
+}
+
+// Returns a list of paths expanded from globs and modules referenced using ":module" syntax, minus
+// the paths that match one of the excludes, which may also be globs.
 // ExtractSourcesDeps must have already been called during the dependency resolution phase.
 func (ctx *androidModuleContext) ExpandSources(srcFiles, excludes []string) Paths {
 	return ctx.ExpandSourcesSubDir(srcFiles, excludes, "")
@@ -853,36 +1038,51 @@ func (ctx *androidModuleContext) ExpandSources(srcFiles, excludes []string) Path
 func (ctx *androidModuleContext) ExpandSourcesSubDir(srcFiles, excludes []string, subDir string) Paths {
 	prefix := PathForModuleSrc(ctx).String()
 
+	// The excludes are relative to the module directory, make a copy relative to the top
+	// of the tree instead of modifying the property they came from.
+	fullExcludes := make([]string, len(excludes))
 	for i, e := range excludes {
-		j := findStringInSlice(e, srcFiles)
-		if j != -1 {
-			srcFiles = append(srcFiles[:j], srcFiles[j+1:]...)
-		}
+		fullExcludes[i] = filepath.Join(prefix, e)
+	}
 
-		excludes[i] = filepath.Join(prefix, e)
+	excluded := func(path Path) bool {
+		for _, e := range fullExcludes {
+			if matchGlob(e, path.String()) {
+				return true
+			}
+		}
+		return false
 	}
 
 	expandedSrcFiles := make(Paths, 0, len(srcFiles))
 	for _, s := range srcFiles {
-		if m := SrcIsModule(s); m != "" {
+		if m, tag := SrcIsModuleWithTag(s); m != "" {
 			module := ctx.GetDirectDepWithTag(m, SourceDepTag)
-			if srcProducer, ok := module.(SourceFileProducer); ok {
-				expandedSrcFiles = append(expandedSrcFiles, srcProducer.Srcs()...)
-			} else {
-				ctx.ModuleErrorf("srcs dependency %q is not a source file producing module", m)
+			srcs, err := sourcesFromModule(module, tag)
+			if err != nil {
+				ctx.ModuleErrorf("srcs dependency %q: %s", s, err)
+				continue
+			}
+			for _, src := range srcs {
+				if !excluded(src) {
+					expandedSrcFiles = append(expandedSrcFiles, src)
+				}
 			}
 		} else if pathtools.IsGlob(s) {
-			globbedSrcFiles := ctx.Glob(filepath.Join(prefix, s), excludes)
-			expandedSrcFiles = append(expandedSrcFiles, globbedSrcFiles...)
-			for i, s := range expandedSrcFiles {
-				expandedSrcFiles[i] = s.(ModuleSrcPath).WithSubDir(ctx, subDir)
+			// Glob records the directories it reads as dependencies of the build.ninja file, so
+			// adding or removing a matching file reruns Soong.
+			for _, src := range ctx.Glob(filepath.Join(prefix, s), fullExcludes) {
+				expandedSrcFiles = append(expandedSrcFiles, src.(ModuleSrcPath).WithSubDir(ctx, subDir))
 			}
 		} else {
-			s := PathForModuleSrc(ctx, s).WithSubDir(ctx, subDir)
-			expandedSrcFiles = append(expandedSrcFiles, s)
+			src := PathForModuleSrc(ctx, s).WithSubDir(ctx, subDir)
+			if !excluded(src) {
+				expandedSrcFiles = append(expandedSrcFiles, src)
+			}
 		}
 	}
 
+	ctx.expandedSrcs = append(ctx.expandedSrcs, expandedSrcFiles...)
 	return expandedSrcFiles
 }
 
@@ -921,6 +1121,8 @@ func (c *buildTargetSingleton) GenerateBuildActions(ctx blueprint.SingletonConte
 	}
 
 	modulesInDir := make(map[string][]string)
+	aliases := make(map[string][]string)
+	moduleNames := make(map[string]bool)
 
 	ctx.VisitAllModules(func(module blueprint.Module) {
 		if a, ok := module.(Module); ok {
@@ -928,6 +1130,8 @@ func (c *buildTargetSingleton) GenerateBuildActions(ctx blueprint.SingletonConte
 			installTarget := a.base().installTarget
 			checkbuildTarget := a.base().checkbuildTarget
 
+			moduleNames[ctx.ModuleName(module)] = true
+
 			if checkbuildTarget != "" {
 				checkbuildDeps = append(checkbuildDeps, checkbuildTarget)
 				modulesInDir[blueprintDir] = append(modulesInDir[blueprintDir], checkbuildTarget)
@@ -936,6 +1140,15 @@ func (c *buildTargetSingleton) GenerateBuildActions(ctx blueprint.SingletonConte
 			if installTarget != "" {
 				modulesInDir[blueprintDir] = append(modulesInDir[blueprintDir], installTarget)
 			}
+
+			for _, alias := range a.base().commonProperties.Aliases {
+				if checkbuildTarget != "" {
+					aliases[alias] = append(aliases[alias], checkbuildTarget)
+				}
+				if installTarget != "" {
+					aliases[alias] = append(aliases[alias], installTarget)
+				}
+			}
 		}
 	})
 
@@ -944,6 +1157,20 @@ func (c *buildTargetSingleton) GenerateBuildActions(ctx blueprint.SingletonConte
 		suffix = "-soong"
 	}
 
+	// Create a target for each alias that depends on all the modules that declare it
+	for _, alias := range sortedKeys(aliases) {
+		if moduleNames[alias] {
+			ctx.Errorf("alias %q is also the name of a module", alias)
+			continue
+		}
+		ctx.Build(pctx, blueprint.BuildParams{
+			Rule:      blueprint.Phony,
+			Outputs:   []string{alias + suffix},
+			Implicits: aliases[alias],
+			Optional:  true,
+		})
+	}
+
 	// Create a top-level checkbuild target that depends on all modules
 	ctx.Build(pctx, blueprint.BuildParams{
 		Rule:      blueprint.Phony,
@@ -0,0 +1,142 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+// This file writes out/soong/module-info.json, which describes the module graph that Soong
+// analyzed for IDEs, test runners and tools that find the modules affected by a change.  It lists
+// every module by name with the variants, dependencies, sources and installed files of all of its
+// variants:
+//
+//   {
+//     "libfoo": {
+//       "module_type": "cc_library",
+//       "path": ["external/foo"],
+//       "variants": ["android_arm64_armv8-a_core_shared", ...],
+//       "dependencies": ["libbase", ...],
+//       "srcs": ["external/foo/foo.cpp", ...],
+//       "installed": ["out/target/product/generic/system/lib64/libfoo.so", ...]
+//     }
+//   }
+//
+// The file is written by a build rule, which only rewrites it when the module graph changed, so
+// that tools can use its timestamp.
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/google/blueprint"
+)
+
+func init() {
+	RegisterSingletonType("module_info_json", ModuleInfoJSONSingleton)
+}
+
+func ModuleInfoJSONSingleton() blueprint.Singleton {
+	return &moduleInfoJSONSingleton{}
+}
+
+type moduleInfoJSONSingleton struct{}
+
+type moduleInfoJSON struct {
+	ModuleType   string   `json:"module_type"`
+	Path         []string `json:"path"`
+	Variants     []string `json:"variants"`
+	Dependencies []string `json:"dependencies"`
+	Srcs         []string `json:"srcs"`
+	Installed    []string `json:"installed"`
+}
+
+func (s *moduleInfoJSONSingleton) GenerateBuildActions(ctx blueprint.SingletonContext) {
+	infos := make(map[string]*moduleInfoJSON)
+
+	ctx.VisitAllModules(func(module blueprint.Module) {
+		m, ok := module.(Module)
+		if !ok || !m.Enabled() {
+			return
+		}
+		// The modules of namespaces that are not exported may have the same names as the
+		// exported modules
+		if !m.base().commonProperties.NamespaceExportedToMake {
+			return
+		}
+
+		name := ctx.ModuleName(module)
+		info := infos[name]
+		if info == nil {
+			info = &moduleInfoJSON{ModuleType: ctx.ModuleType(module)}
+			infos[name] = info
+		}
+		addModuleInfo(info, ctx.ModuleDir(module), ctx.ModuleSubDir(module), m.base())
+	})
+
+	for _, info := range infos {
+		info.sortAndUniq()
+	}
+
+	data, err := json.MarshalIndent(infos, "", "  ")
+	if err != nil {
+		ctx.Errorf("failed to encode module-info.json: %s", err)
+		return
+	}
+	data = append(data, '\n')
+
+	moduleInfo := PathForOutput(ctx, "module-info.json")
+	if ctx.Failed() {
+		return
+	}
+	WriteFileRule(ctx, moduleInfo, data)
+}
+
+// addModuleInfo adds a variant of a module in dir to the module info.
+func addModuleInfo(info *moduleInfoJSON, dir, variant string, base *ModuleBase) {
+	info.Path = append(info.Path, dir)
+	if variant != "" {
+		info.Variants = append(info.Variants, variant)
+	}
+	info.Dependencies = append(info.Dependencies, base.directDeps...)
+	info.Srcs = append(info.Srcs, base.srcs.Strings()...)
+	// The packaging specs are also recorded when Make installs the module
+	for _, spec := range base.packagingSpecs {
+		info.Installed = append(info.Installed, spec.InstallPath.String())
+	}
+}
+
+// sortAndUniq sorts the lists of the module info and removes the duplicates, so that the file only
+// changes when the module graph does.  Empty lists are written as [].
+func (info *moduleInfoJSON) sortAndUniq() {
+	for _, list := range []*[]string{&info.Path, &info.Variants, &info.Dependencies, &info.Srcs,
+		&info.Installed} {
+
+		sort.Strings(*list)
+		*list = uniqSorted(*list)
+		if *list == nil {
+			*list = []string{}
+		}
+	}
+}
+
+func uniqSorted(list []string) []string {
+	if len(list) == 0 {
+		return list
+	}
+	ret := list[:1]
+	for _, s := range list[1:] {
+		if s != ret[len(ret)-1] {
+			ret = append(ret, s)
+		}
+	}
+	return ret
+}
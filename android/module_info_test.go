@@ -0,0 +1,56 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestModuleInfoJSON(t *testing.T) {
+	config := TestConfig("out")
+	installPath := func(path string) OutputPath {
+		return OutputPath{basePath{path, config, ""}}
+	}
+
+	// The files that Make installs are only recorded in the packaging specs of the module
+	info := &moduleInfoJSON{ModuleType: "cc_library"}
+	addModuleInfo(info, "external/foo", "android_arm64_armv8-a_core_shared", &ModuleBase{
+		directDeps:     []string{"libc", "libbase", "libc"},
+		srcs:           Paths{PathForTesting("external/foo/foo.cpp")},
+		packagingSpecs: []PackagingSpec{{InstallPath: installPath("target/product/test_device/system/lib64/libfoo.so")}},
+	})
+	addModuleInfo(info, "external/foo", "android_arm_armv7-a-neon_core_shared", &ModuleBase{
+		directDeps:     []string{"libc"},
+		srcs:           Paths{PathForTesting("external/foo/foo.cpp")},
+		packagingSpecs: []PackagingSpec{{InstallPath: installPath("target/product/test_device/system/lib/libfoo.so")}},
+	})
+	info.sortAndUniq()
+
+	expected := &moduleInfoJSON{
+		ModuleType:   "cc_library",
+		Path:         []string{"external/foo"},
+		Variants:     []string{"android_arm64_armv8-a_core_shared", "android_arm_armv7-a-neon_core_shared"},
+		Dependencies: []string{"libbase", "libc"},
+		Srcs:         []string{"external/foo/foo.cpp"},
+		Installed: []string{
+			"out/target/product/test_device/system/lib/libfoo.so",
+			"out/target/product/test_device/system/lib64/libfoo.so",
+		},
+	}
+	if !reflect.DeepEqual(info, expected) {
+		t.Errorf("expected %+v, got %+v", expected, info)
+	}
+}
@@ -0,0 +1,200 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+// This file exports a summary of every module as a stream of ModuleSummary protobuf messages, see
+// module_summary.proto, so that dashboards and dead code detection can analyze the modules of the
+// build without parsing the ninja file.  The summaries are only written when
+// SOONG_EXPORT_MODULE_SUMMARIES is set, as they require saving the build parameters of every
+// module.
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/google/blueprint"
+)
+
+func init() {
+	RegisterSingletonType("module_summary", ModuleSummarySingleton)
+}
+
+func ModuleSummarySingleton() blueprint.Singleton {
+	return &moduleSummarySingleton{}
+}
+
+type moduleSummarySingleton struct{}
+
+func (s *moduleSummarySingleton) GenerateBuildActions(ctx blueprint.SingletonContext) {
+	config := ctx.Config().(Config)
+	if !config.ExportModuleSummaries() {
+		return
+	}
+
+	var modules []Module
+	ctx.VisitAllModules(func(module blueprint.Module) {
+		if m, ok := module.(Module); ok && m.Enabled() {
+			modules = append(modules, m)
+		}
+	})
+	sort.Sort(AndroidModulesByName{modules, ctx})
+
+	var buf []byte
+	for _, m := range modules {
+		summary := summarizeModule(m)
+		summary.name = ctx.ModuleName(m)
+		summary.moduleType = ctx.ModuleType(m)
+		summary.variant = ctx.ModuleSubDir(m)
+		summary.dir = ctx.ModuleDir(m)
+		buf = appendDelimited(buf, summary.marshal())
+	}
+
+	WriteFileRule(ctx, PathForOutput(ctx, "module_summaries.pb"), buf)
+}
+
+type moduleFlag struct {
+	name, value string
+}
+
+// moduleSummary mirrors the ModuleSummary message in module_summary.proto.
+type moduleSummary struct {
+	name, moduleType, variant, dir string
+
+	srcsCount    int
+	deps         []string
+	flags        []moduleFlag
+	outputs      []string
+	installPaths []string
+}
+
+// summarizeModule collects the sources, flags and outputs of the build rules of a module, which
+// are only saved when Config.captureBuild is set, and its dependencies and installed files.
+func summarizeModule(m Module) moduleSummary {
+	base := m.base()
+	summary := moduleSummary{
+		deps:         base.directDeps,
+		installPaths: base.filesToInstall().Strings(),
+	}
+
+	srcs := make(map[string]bool)
+	flags := make(map[moduleFlag]bool)
+	for _, params := range base.buildParams {
+		inputs := append(append(Paths(nil), params.Inputs...), params.Implicits...)
+		if params.Input != nil {
+			inputs = append(inputs, params.Input)
+		}
+		if params.Implicit != nil {
+			inputs = append(inputs, params.Implicit)
+		}
+		for _, input := range inputs {
+			switch input.(type) {
+			case SourcePath, ModuleSrcPath:
+				srcs[input.String()] = true
+			}
+		}
+
+		for name, value := range params.Args {
+			if value != "" && strings.HasSuffix(strings.ToLower(name), "flags") {
+				flags[moduleFlag{name, value}] = true
+			}
+		}
+
+		summary.outputs = append(summary.outputs, params.Outputs.Strings()...)
+		summary.outputs = append(summary.outputs, params.ImplicitOutputs.Strings()...)
+		if params.Output != nil {
+			summary.outputs = append(summary.outputs, params.Output.String())
+		}
+		if params.ImplicitOutput != nil {
+			summary.outputs = append(summary.outputs, params.ImplicitOutput.String())
+		}
+	}
+
+	summary.srcsCount = len(srcs)
+	for flag := range flags {
+		summary.flags = append(summary.flags, flag)
+	}
+	sort.Slice(summary.flags, func(i, j int) bool {
+		if summary.flags[i].name != summary.flags[j].name {
+			return summary.flags[i].name < summary.flags[j].name
+		}
+		return summary.flags[i].value < summary.flags[j].value
+	})
+	sort.Strings(summary.outputs)
+
+	return summary
+}
+
+// marshal encodes the summary in the protobuf wire format.  Soong doesn't depend on the protobuf
+// runtime, so the few field types used by ModuleSummary are encoded by hand.
+func (s moduleSummary) marshal() []byte {
+	var buf []byte
+	buf = appendStringField(buf, 1, s.name)
+	buf = appendStringField(buf, 2, s.moduleType)
+	buf = appendStringField(buf, 3, s.variant)
+	buf = appendStringField(buf, 4, s.dir)
+	if s.srcsCount != 0 {
+		buf = appendVarint(buf, 5<<3|protoVarint)
+		buf = appendVarint(buf, uint64(s.srcsCount))
+	}
+	for _, dep := range s.deps {
+		buf = appendStringField(buf, 6, dep)
+	}
+	for _, flag := range s.flags {
+		var flagBuf []byte
+		flagBuf = appendStringField(flagBuf, 1, flag.name)
+		flagBuf = appendStringField(flagBuf, 2, flag.value)
+		buf = appendBytesField(buf, 7, flagBuf)
+	}
+	for _, output := range s.outputs {
+		buf = appendStringField(buf, 8, output)
+	}
+	for _, installPath := range s.installPaths {
+		buf = appendStringField(buf, 9, installPath)
+	}
+	return buf
+}
+
+// Protobuf wire types
+const (
+	protoVarint          = 0
+	protoLengthDelimited = 2
+)
+
+func appendVarint(buf []byte, v uint64) []byte {
+	for v >= 0x80 {
+		buf = append(buf, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(buf, byte(v))
+}
+
+func appendBytesField(buf []byte, field int, b []byte) []byte {
+	buf = appendVarint(buf, uint64(field)<<3|protoLengthDelimited)
+	return appendDelimited(buf, b)
+}
+
+// appendStringField appends a string field, omitting it if it is empty like proto3 does.
+func appendStringField(buf []byte, field int, s string) []byte {
+	if s == "" {
+		return buf
+	}
+	return appendBytesField(buf, field, []byte(s))
+}
+
+// appendDelimited appends b preceded by its length.
+func appendDelimited(buf []byte, b []byte) []byte {
+	buf = appendVarint(buf, uint64(len(b)))
+	return append(buf, b...)
+}
@@ -0,0 +1,76 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestModuleSummaryMarshal(t *testing.T) {
+	summary := moduleSummary{
+		name:       "foo",
+		moduleType: "copy",
+		srcsCount:  300,
+		deps:       []string{"bar"},
+		flags:      []moduleFlag{{"cpFlags", "-p"}},
+		outputs:    []string{"out/foo.txt"},
+	}
+
+	expected := "\x0a\x03foo" +
+		"\x12\x04copy" +
+		"\x28\xac\x02" +
+		"\x32\x03bar" +
+		"\x3a\x0d\x0a\x07cpFlags\x12\x02-p" +
+		"\x42\x0bout/foo.txt"
+
+	if g := string(summary.marshal()); g != expected {
+		t.Errorf("expected %q, got %q", expected, g)
+	}
+}
+
+func TestSummarizeModule(t *testing.T) {
+	buildDir, err := ioutil.TempDir("", "soong_module_summary_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(buildDir)
+
+	f := NewTestFixture(buildDir)
+	f.RegisterModuleType("copy", ModuleFactoryAdaptor(newCopyModule))
+	f.AddBlueprint(`
+		copy {
+			name: "foo",
+			src: "foo.txt",
+			flags: "-p",
+		}
+	`)
+	f.AddFiles("foo.txt")
+	f.Prepare(t)
+
+	summary := summarizeModule(f.ModuleForTests("foo", "").Module())
+	if summary.srcsCount != 1 {
+		t.Errorf("expected 1 source file, got %d", summary.srcsCount)
+	}
+	if g, w := summary.flags, []moduleFlag{{"cpFlags", "-p"}}; !reflect.DeepEqual(g, w) {
+		t.Errorf("expected flags %q, got %q", w, g)
+	}
+	if g, w := summary.outputs, []string{filepath.Join(buildDir, ".intermediates/foo/foo.txt")}; !reflect.DeepEqual(g, w) {
+		t.Errorf("expected outputs %q, got %q", w, g)
+	}
+}
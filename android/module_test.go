@@ -0,0 +1,357 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/google/blueprint"
+)
+
+func TestExpandSources(t *testing.T) {
+	buildDir, err := ioutil.TempDir("", "soong_expand_sources_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(buildDir)
+
+	f := NewTestFixture(buildDir)
+	f.RegisterModuleType("srcs", ModuleFactoryAdaptor(newSrcsModule))
+	f.AddBlueprint(`
+		srcs {
+			name: "foo",
+			srcs: ["src/**/*.java", "b/B.java"],
+			exclude_srcs: ["src/a/A2.java", "src/**/test/*.java"],
+		}
+
+		srcs {
+			name: "bar",
+			srcs: [":foo", "b/B.java", "b/B2.java"],
+			exclude_srcs: ["src/a/*.java", "b/B2.java"],
+		}
+	`)
+	f.AddFiles(
+		"src/a/A.java",
+		"src/a/A2.java",
+		"src/b/c/C.java",
+		"src/b/test/CTest.java",
+		"b/B.java",
+		"b/B2.java",
+	)
+	f.Prepare(t)
+
+	testCases := []struct {
+		name string
+		srcs []string
+	}{
+		{
+			name: "foo",
+			srcs: []string{"src/a/A.java", "src/b/c/C.java", "b/B.java"},
+		},
+		{
+			name: "bar",
+			srcs: []string{"src/b/c/C.java", "b/B.java", "b/B.java"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		m := f.ModuleForTests(testCase.name, "").Module().(*srcsModule)
+		if g, w := m.srcs.Strings(), testCase.srcs; !reflect.DeepEqual(g, w) {
+			t.Errorf("%s: expected srcs %q, got %q", testCase.name, w, g)
+		}
+		if g, w := m.properties.Exclude_srcs[0], m.excludes[0]; g != w {
+			t.Errorf("%s: exclude_srcs modified, expected %q, got %q", testCase.name, w, g)
+		}
+	}
+}
+
+func TestMatchGlob(t *testing.T) {
+	testCases := []struct {
+		pattern, name string
+		match         bool
+	}{
+		{"a/b.java", "a/b.java", true},
+		{"a/*.java", "a/b.java", true},
+		{"a/*.java", "a/b/c.java", false},
+		{"a/**/*.java", "a/b.java", true},
+		{"a/**/*.java", "a/b/c/d.java", true},
+		{"a/**/test/*.java", "a/test/b.java", true},
+		{"a/**/test/*.java", "a/b/test/c.java", true},
+		{"a/**/test/*.java", "a/b/c.java", false},
+		{"a/**", "a/b/c.java", true},
+		{"a/**", "b/c.java", false},
+	}
+
+	for _, testCase := range testCases {
+		if g := matchGlob(testCase.pattern, testCase.name); g != testCase.match {
+			t.Errorf("matchGlob(%q, %q): expected %v, got %v", testCase.pattern, testCase.name,
+				testCase.match, g)
+		}
+	}
+}
+
+func TestSrcIsModuleWithTag(t *testing.T) {
+	testCases := []struct {
+		s, module, tag string
+	}{
+		{"foo.java", "", ""},
+		{":", "", ""},
+		{":foo", "foo", ""},
+		{":foo{.jar}", "foo", ".jar"},
+		{":foo{.export-package.apk}", "foo", ".export-package.apk"},
+		{":foo{}", "foo", ""},
+		{":foo{.jar", "foo{.jar", ""},
+	}
+
+	for _, testCase := range testCases {
+		module, tag := SrcIsModuleWithTag(testCase.s)
+		if module != testCase.module || tag != testCase.tag {
+			t.Errorf("SrcIsModuleWithTag(%q): expected %q, %q, got %q, %q", testCase.s,
+				testCase.module, testCase.tag, module, tag)
+		}
+		if g := SrcIsModule(testCase.s); g != testCase.module {
+			t.Errorf("SrcIsModule(%q): expected %q, got %q", testCase.s, testCase.module, g)
+		}
+	}
+}
+
+type srcsModule struct {
+	ModuleBase
+	properties struct {
+		Srcs         []string
+		Exclude_srcs []string
+	}
+
+	excludes []string
+	srcs     Paths
+}
+
+func newSrcsModule() Module {
+	m := &srcsModule{}
+	m.AddProperties(&m.properties)
+	InitAndroidModule(m)
+	return m
+}
+
+func (m *srcsModule) Srcs() Paths {
+	return m.srcs
+}
+
+func (m *srcsModule) DepsMutator(ctx BottomUpMutatorContext) {
+	ExtractSourcesDeps(ctx, m.properties.Srcs)
+}
+
+func (m *srcsModule) GenerateAndroidBuildActions(ctx ModuleContext) {
+	m.excludes = append([]string(nil), m.properties.Exclude_srcs...)
+	m.srcs = ctx.ExpandSources(m.properties.Srcs, m.properties.Exclude_srcs)
+}
+
+var testTmpDirRule = pctx.AndroidTmpDirStaticRule("testTmpDir",
+	blueprint.RuleParams{
+		Command: `tool --scratch $tmpDir $flags $in $out`,
+	},
+	"flags")
+
+type tmpDirModule struct {
+	ModuleBase
+}
+
+func newTmpDirModule() Module {
+	m := &tmpDirModule{}
+	InitAndroidModule(m)
+	return m
+}
+
+func (m *tmpDirModule) DepsMutator(ctx BottomUpMutatorContext) {
+}
+
+func (m *tmpDirModule) GenerateAndroidBuildActions(ctx ModuleContext) {
+	ctx.ModuleBuild(pctx, ModuleBuildParams{
+		Rule:   testTmpDirRule,
+		Output: PathForModuleOut(ctx, "out"),
+		Args: map[string]string{
+			"flags": "-v",
+		},
+	})
+	ctx.ModuleBuild(pctx, ModuleBuildParams{
+		Rule:    testTmpDirRule,
+		Outputs: WritablePaths{PathForModuleOut(ctx, "outs1"), PathForModuleOut(ctx, "outs2")},
+	})
+	ctx.ModuleBuild(pctx, ModuleBuildParams{
+		Rule:   Touch,
+		Output: PathForModuleOut(ctx, "touch"),
+	})
+}
+
+func TestTmpDirRules(t *testing.T) {
+	buildDir, err := ioutil.TempDir("", "soong_tmp_dir_rules_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(buildDir)
+
+	f := NewTestFixture(buildDir)
+	f.RegisterModuleType("tmp_dir", ModuleFactoryAdaptor(newTmpDirModule))
+	f.AddBlueprint(`
+		tmp_dir {
+			name: "foo",
+		}
+	`)
+	f.Prepare(t)
+
+	m := f.ModuleForTests("foo", "")
+
+	// Every build statement gets a directory next to its first output, and keeps its own args
+	out := m.Output("out")
+	if g, w := out.Args, map[string]string{"flags": "-v", "tmpDir": out.Output.String() + ".tmpdir"}; !reflect.DeepEqual(g, w) {
+		t.Errorf("expected out args %q, got %q", w, g)
+	}
+	outs := m.Output("outs1")
+	if g, w := outs.Args["tmpDir"], outs.Outputs[0].String()+".tmpdir"; g != w {
+		t.Errorf("expected outs tmpDir %q, got %q", w, g)
+	}
+
+	if _, exists := m.Output("touch").Args["tmpDir"]; exists {
+		t.Errorf("touch has a tmpDir, but its rule doesn't use one")
+	}
+}
+
+// buildRecorder records the build statements of a module context.
+type buildRecorder struct {
+	blueprint.ModuleContext
+	params []blueprint.BuildParams
+}
+
+func (r *buildRecorder) Build(pctx blueprint.PackageContext, params blueprint.BuildParams) {
+	r.params = append(r.params, params)
+}
+
+func TestTmpDirRulesBuild(t *testing.T) {
+	recorder := &buildRecorder{}
+	ctx := &androidModuleContext{ModuleContext: recorder}
+
+	// Build statements that don't go through ModuleBuild get a directory too
+	ctx.Build(pctx, blueprint.BuildParams{
+		Rule:    testTmpDirRule,
+		Outputs: []string{"out/foo", "out/bar"},
+		Args: map[string]string{
+			"flags": "-v",
+		},
+	})
+	ctx.Build(pctx, blueprint.BuildParams{
+		Rule:    Touch,
+		Outputs: []string{"out/touch"},
+	})
+
+	if g, w := recorder.params[0].Args, map[string]string{"flags": "-v", "tmpDir": "out/foo.tmpdir"}; !reflect.DeepEqual(g, w) {
+		t.Errorf("expected foo args %q, got %q", w, g)
+	}
+	if _, exists := recorder.params[1].Args["tmpDir"]; exists {
+		t.Errorf("touch has a tmpDir, but its rule doesn't use one")
+	}
+}
+
+func TestAliasesAndroidMk(t *testing.T) {
+	m := &ModuleBase{}
+	m.commonProperties.Aliases = []string{"lineage-tests", "lineage-tools"}
+
+	testCases := []struct {
+		name             string
+		disabled, custom bool
+		outputFile       OptionalPath
+		out              string
+	}{
+		{
+			name:       "output file",
+			outputFile: OptionalPathForPath(PathForTesting("out/foo")),
+			out: ".PHONY: lineage-tests\nlineage-tests: foo\n" +
+				".PHONY: lineage-tools\nlineage-tools: foo\n",
+		},
+		{
+			name:   "custom",
+			custom: true,
+			out: ".PHONY: lineage-tests\nlineage-tests: foo\n" +
+				".PHONY: lineage-tools\nlineage-tools: foo\n",
+		},
+		{
+			// Make has nothing to build for the aliases
+			name: "no output file",
+		},
+		{
+			name:       "disabled",
+			disabled:   true,
+			outputFile: OptionalPathForPath(PathForTesting("out/foo")),
+		},
+	}
+	for _, testCase := range testCases {
+		buf := &bytes.Buffer{}
+		writeAliasesAndDist(buf, m, "foo", testCase.disabled, testCase.custom, testCase.outputFile)
+		if buf.String() != testCase.out {
+			t.Errorf("%s: expected %q, got %q", testCase.name, testCase.out, buf.String())
+		}
+	}
+}
+
+type aliasTestModule struct {
+	ModuleBase
+}
+
+// newAliasTestModule returns a module with an output file, which gives it a checkbuild target
+// that its aliases build.
+func newAliasTestModule() Module {
+	m := &aliasTestModule{}
+	InitAndroidModule(m)
+	return m
+}
+
+func (m *aliasTestModule) DepsMutator(ctx BottomUpMutatorContext) {
+}
+
+func (m *aliasTestModule) GenerateAndroidBuildActions(ctx ModuleContext) {
+	out := PathForModuleOut(ctx, "out")
+	ctx.ModuleBuild(pctx, ModuleBuildParams{
+		Rule:   Touch,
+		Output: out,
+	})
+	ctx.CheckbuildFile(out)
+}
+
+func TestAliasErrors(t *testing.T) {
+	buildDir, err := ioutil.TempDir("", "soong_alias_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(buildDir)
+
+	f := NewTestFixture(buildDir)
+	f.RegisterModuleType("alias_test_module", ModuleFactoryAdaptor(newAliasTestModule))
+	f.RegisterModuleType("srcs", ModuleFactoryAdaptor(newSrcsModule))
+	f.RegisterSingletonType("buildtarget", BuildTargetSingleton)
+	f.AddBlueprint(`
+		alias_test_module {
+			name: "foo",
+			aliases: ["bar"],
+		}
+
+		srcs {
+			name: "bar",
+		}
+	`)
+	FailIfNoMatchingErrors(t, `alias "bar" is also the name of a module`, f.PrepareWithErrors())
+}
@@ -74,19 +74,25 @@ var preArch = []RegisterMutatorFunc{
 	func(ctx RegisterMutatorsContext) {
 		ctx.TopDown("load_hooks", loadHookMutator).Parallel()
 	},
+	RegisterNamespaceMutator,
 	RegisterPrebuiltsPreArchMutators,
 	RegisterDefaultsPreArchMutators,
+	RegisterVisibilityPreArchMutators,
+}
+
+func registerArchMutator(ctx RegisterMutatorsContext) {
+	ctx.BottomUp("arch", archMutator).Parallel()
+	ctx.TopDown("arch_hooks", archHookMutator).Parallel()
 }
 
 var preDeps = []RegisterMutatorFunc{
-	func(ctx RegisterMutatorsContext) {
-		ctx.BottomUp("arch", archMutator).Parallel()
-		ctx.TopDown("arch_hooks", archHookMutator).Parallel()
-	},
+	registerArchMutator,
 }
 
 var postDeps = []RegisterMutatorFunc{
 	RegisterPrebuiltsPostDepsMutators,
+	RegisterPartitionPostDepsMutators,
+	RegisterVisibilityPostDepsMutators,
 }
 
 func PreArchMutators(f RegisterMutatorFunc) {
@@ -132,6 +138,7 @@ func (x *registerMutatorsContext) BottomUp(name string, m AndroidBottomUpMutator
 				BottomUpMutatorContext: ctx,
 				androidBaseContextImpl: a.base().androidBaseContextFactory(ctx),
 			}
+			actx.Tracef("running bottom up mutator %s", name)
 			m(actx)
 		}
 	}
@@ -147,6 +154,7 @@ func (x *registerMutatorsContext) TopDown(name string, m AndroidTopDownMutator)
 				TopDownMutatorContext:  ctx,
 				androidBaseContextImpl: a.base().androidBaseContextFactory(ctx),
 			}
+			actx.Tracef("running top down mutator %s", name)
 			m(actx)
 		}
 	}
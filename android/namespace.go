@@ -0,0 +1,374 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/google/blueprint"
+)
+
+// This file implements Soong namespaces.  A soong_namespace module at the top of an Android.bp
+// file puts all the modules in that directory and its subdirectories into a namespace, so that
+// projects like device trees can define modules with the same names as each other.  A module
+// can depend on the modules in its own namespace, in the namespaces it imports and in the root
+// namespace, or on any module with a fully qualified "//namespace/path:module" name.  Only the
+// modules in the namespaces listed in PRODUCT_SOONG_NAMESPACES are exported to Make.
+
+const (
+	namespacePrefix = "//"
+	modulePrefix    = ":"
+)
+
+func init() {
+	RegisterModuleType("soong_namespace", NamespaceFactory)
+}
+
+// sortedNamespaces is a list of namespaces that is safe to add to from parallel blueprint file
+// parsing, and that is sorted by path once it is first read.
+type sortedNamespaces struct {
+	lock   sync.Mutex
+	items  []*Namespace
+	sorted bool
+}
+
+func (s *sortedNamespaces) add(namespace *Namespace) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.sorted {
+		panic("namespaces can't be added after they have been sorted")
+	}
+	s.items = append(s.items, namespace)
+}
+
+func (s *sortedNamespaces) sortedItems() []*Namespace {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !s.sorted {
+		sort.Slice(s.items, func(i, j int) bool {
+			return s.items[i].Path < s.items[j].Path
+		})
+		s.sorted = true
+	}
+	return s.items
+}
+
+func (s *sortedNamespaces) index(namespace *Namespace) int {
+	for i, candidate := range s.sortedItems() {
+		if namespace == candidate {
+			return i
+		}
+	}
+	return -1
+}
+
+// NameResolver implements blueprint.NameInterface, finding the module that a dependency refers
+// to from the namespaces that are visible to the depending module.
+type NameResolver struct {
+	rootNamespace *Namespace
+
+	// all the namespaces, without duplicates
+	sortedNamespaces sortedNamespaces
+
+	// map from a directory to the namespace that it is in, filled in lazily for the
+	// subdirectories of namespaces
+	namespacesByDir sync.Map
+
+	// returns whether the modules of a namespace are exported to Make
+	namespaceExportFilter func(*Namespace) bool
+}
+
+func NewNameResolver(namespaceExportFilter func(*Namespace) bool) *NameResolver {
+	r := &NameResolver{
+		namespaceExportFilter: namespaceExportFilter,
+	}
+	r.rootNamespace = r.newNamespace(".")
+	r.rootNamespace.visibleNamespaces = []*Namespace{r.rootNamespace}
+	r.addNamespace(r.rootNamespace)
+
+	return r
+}
+
+func (r *NameResolver) newNamespace(path string) *Namespace {
+	namespace := NewNamespace(path)
+	namespace.exportToMake = r.namespaceExportFilter(namespace)
+	return namespace
+}
+
+func (r *NameResolver) addNewNamespaceForModule(module *NamespaceModule, path string) error {
+	if filepath.Base(path) != "Android.bp" {
+		return errors.New("a namespace may only be declared in a file named Android.bp")
+	}
+
+	namespace := r.newNamespace(filepath.Dir(path))
+	module.namespace = namespace
+	module.resolver = r
+	namespace.importedNamespaceNames = module.properties.Imports
+	return r.addNamespace(namespace)
+}
+
+func (r *NameResolver) addNamespace(namespace *Namespace) error {
+	if existing, exists := r.namespaceAt(namespace.Path); exists {
+		if existing.Path == namespace.Path {
+			return fmt.Errorf("namespace %v already exists", namespace.Path)
+		}
+		// The directory was already looked up by a module in the same file, and the namespace
+		// would only apply to the modules after it.
+		return errors.New("a namespace must be the first module in the file")
+	}
+	r.sortedNamespaces.add(namespace)
+	r.namespacesByDir.Store(namespace.Path, namespace)
+	return nil
+}
+
+// namespaceAt returns the namespace of a directory if it has already been looked up.
+func (r *NameResolver) namespaceAt(path string) (*Namespace, bool) {
+	namespace, found := r.namespacesByDir.Load(path)
+	if !found {
+		return nil, false
+	}
+	return namespace.(*Namespace), true
+}
+
+// findNamespace returns the namespace that a directory is in, searching the parent directories
+// for the closest namespace.
+func (r *NameResolver) findNamespace(path string) *Namespace {
+	if namespace, found := r.namespaceAt(path); found {
+		return namespace
+	}
+	parent := filepath.Dir(path)
+	if parent == path {
+		return r.rootNamespace
+	}
+	namespace := r.findNamespace(parent)
+	r.namespacesByDir.Store(path, namespace)
+	return namespace
+}
+
+func (r *NameResolver) findNamespaceFromCtx(ctx blueprint.NamespaceContext) *Namespace {
+	return r.findNamespace(filepath.Dir(ctx.ModulePath()))
+}
+
+func (r *NameResolver) NewModule(ctx blueprint.NamespaceContext, moduleGroup blueprint.ModuleGroup,
+	module blueprint.Module) (blueprint.Namespace, []error) {
+
+	// A soong_namespace module declares a new namespace instead of being added to one
+	if n, ok := module.(*NamespaceModule); ok {
+		if err := r.addNewNamespaceForModule(n, ctx.ModulePath()); err != nil {
+			return nil, []error{err}
+		}
+		return nil, nil
+	}
+
+	ns := r.findNamespaceFromCtx(ctx)
+	if _, errs := ns.moduleContainer.NewModule(ctx, moduleGroup, module); len(errs) > 0 {
+		return nil, errs
+	}
+
+	if m, ok := module.(Module); ok {
+		m.base().commonProperties.NamespaceExportedToMake = ns.exportToMake
+	}
+
+	return ns, nil
+}
+
+func (r *NameResolver) AllModules() []blueprint.ModuleGroup {
+	var allModules []blueprint.ModuleGroup
+	for _, namespace := range r.sortedNamespaces.sortedItems() {
+		allModules = append(allModules, namespace.moduleContainer.AllModules()...)
+	}
+	return allModules
+}
+
+// parseFullyQualifiedName splits a "//namespace/path:module" name into the path of the namespace
+// and the name of the module.
+func (r *NameResolver) parseFullyQualifiedName(name string) (namespaceName, moduleName string, ok bool) {
+	if !strings.HasPrefix(name, namespacePrefix) {
+		return "", "", false
+	}
+	components := strings.Split(strings.TrimPrefix(name, namespacePrefix), modulePrefix)
+	if len(components) != 2 {
+		return "", "", false
+	}
+	return components[0], components[1], true
+}
+
+func (r *NameResolver) ModuleFromName(name string, namespace blueprint.Namespace) (blueprint.ModuleGroup, bool) {
+	if nsName, moduleName, ok := r.parseFullyQualifiedName(name); ok {
+		ns, found := r.namespaceAt(nsName)
+		if !found {
+			return blueprint.ModuleGroup{}, false
+		}
+		return ns.moduleContainer.ModuleFromName(moduleName, nil)
+	}
+
+	for _, candidate := range namespace.(*Namespace).visibleNamespaces {
+		if group, found := candidate.moduleContainer.ModuleFromName(name, nil); found {
+			return group, true
+		}
+	}
+	return blueprint.ModuleGroup{}, false
+}
+
+func (r *NameResolver) Rename(oldName string, newName string, namespace blueprint.Namespace) []error {
+	return namespace.(*Namespace).moduleContainer.Rename(oldName, newName, namespace)
+}
+
+// findNamespaceImports resolves the imports of a namespace into the list of namespaces that its
+// modules can depend on, in search order.
+func (r *NameResolver) findNamespaceImports(namespace *Namespace) error {
+	namespace.visibleNamespaces = make([]*Namespace, 0, 2+len(namespace.importedNamespaceNames))
+	namespace.visibleNamespaces = append(namespace.visibleNamespaces, namespace)
+	for _, name := range namespace.importedNamespaceNames {
+		imported, ok := r.namespaceAt(name)
+		if !ok || imported.Path != name {
+			return fmt.Errorf("namespace %v does not exist", name)
+		}
+		namespace.visibleNamespaces = append(namespace.visibleNamespaces, imported)
+	}
+	namespace.visibleNamespaces = append(namespace.visibleNamespaces, r.rootNamespace)
+	return nil
+}
+
+// chooseId gives the namespace a short id that is used to make the names of its modules unique
+// in the ninja file.
+func (r *NameResolver) chooseId(namespace *Namespace) {
+	id := r.sortedNamespaces.index(namespace)
+	if id < 0 {
+		panic(fmt.Errorf("namespace %v was not added to the resolver", namespace.Path))
+	}
+	namespace.id = strconv.Itoa(id)
+}
+
+func (r *NameResolver) MissingDependencyError(depender string, dependerNamespace blueprint.Namespace,
+	depName string) error {
+
+	text := fmt.Sprintf("%q depends on undefined module %q", depender, depName)
+
+	// A fully qualified name can only refer to one module
+	if _, _, ok := r.parseFullyQualifiedName(depName); ok {
+		return errors.New(text)
+	}
+
+	var foundInNamespaces []string
+	for _, namespace := range r.sortedNamespaces.sortedItems() {
+		if _, found := namespace.moduleContainer.ModuleFromName(depName, nil); found {
+			foundInNamespaces = append(foundInNamespaces, namespace.Path)
+		}
+	}
+	if len(foundInNamespaces) > 0 {
+		ns := dependerNamespace.(*Namespace)
+		var visible []string
+		for _, v := range ns.visibleNamespaces {
+			visible = append(visible, v.Path)
+		}
+		text += fmt.Sprintf("\nModule %q is defined in namespace %q which can read these namespaces: %q",
+			depender, ns.Path, visible)
+		text += fmt.Sprintf("\nModule %q can be found in these namespaces: %q", depName, foundInNamespaces)
+	}
+
+	return errors.New(text)
+}
+
+func (r *NameResolver) GetNamespace(ctx blueprint.NamespaceContext) blueprint.Namespace {
+	return r.findNamespaceFromCtx(ctx)
+}
+
+func (r *NameResolver) UniqueName(ctx blueprint.NamespaceContext, name string) string {
+	if id := r.findNamespaceFromCtx(ctx).id; id != "" {
+		return id + "-" + name
+	}
+	return name
+}
+
+var _ blueprint.NameInterface = (*NameResolver)(nil)
+
+// Namespace is a set of modules with unique names, declared by a soong_namespace module.
+type Namespace struct {
+	blueprint.NamespaceMarker
+	Path string
+
+	// the paths of the namespaces listed in the imports property of the soong_namespace module
+	importedNamespaceNames []string
+
+	// the namespaces that are searched for the dependencies of the modules in this namespace
+	visibleNamespaces []*Namespace
+
+	id string
+
+	exportToMake bool
+
+	moduleContainer blueprint.NameInterface
+}
+
+func NewNamespace(path string) *Namespace {
+	return &Namespace{Path: path, moduleContainer: blueprint.NewSimpleNameInterface()}
+}
+
+var _ blueprint.Namespace = (*Namespace)(nil)
+
+type namespaceProperties struct {
+	// list of paths of the namespaces whose modules can be depended on by the modules in this
+	// namespace
+	Imports []string
+}
+
+type NamespaceModule struct {
+	ModuleBase
+
+	namespace *Namespace
+	resolver  *NameResolver
+
+	properties namespaceProperties
+}
+
+func NamespaceFactory() Module {
+	module := &NamespaceModule{}
+
+	module.nameProperties.Name = "soong_namespace"
+
+	module.AddProperties(&module.properties)
+	return module
+}
+
+func (n *NamespaceModule) DepsMutator(ctx BottomUpMutatorContext) {
+}
+
+func (n *NamespaceModule) GenerateAndroidBuildActions(ctx ModuleContext) {
+}
+
+func (n *NamespaceModule) GenerateBuildActions(ctx blueprint.ModuleContext) {
+}
+
+func RegisterNamespaceMutator(ctx RegisterMutatorsContext) {
+	ctx.BottomUp("namespace_deps", namespaceMutator).Parallel()
+}
+
+// namespaceMutator resolves the imports of each namespace once all the blueprint files have been
+// parsed.
+func namespaceMutator(ctx BottomUpMutatorContext) {
+	if module, ok := ctx.Module().(*NamespaceModule); ok {
+		if err := module.resolver.findNamespaceImports(module.namespace); err != nil {
+			ctx.PropertyErrorf("imports", "%s", err.Error())
+		}
+		module.resolver.chooseId(module.namespace)
+	}
+}
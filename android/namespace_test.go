@@ -0,0 +1,100 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"testing"
+)
+
+var parseFullyQualifiedNameTestCases = []struct {
+	name      string
+	namespace string
+	module    string
+	ok        bool
+}{
+	{name: "libfoo", ok: false},
+	{name: ":libfoo", ok: false},
+	{name: "//device/foo:libfoo", namespace: "device/foo", module: "libfoo", ok: true},
+	{name: "//device/foo", ok: false},
+	{name: "//device/foo:bar:libfoo", ok: false},
+}
+
+func TestParseFullyQualifiedName(t *testing.T) {
+	r := NewNameResolver(func(*Namespace) bool { return true })
+	for _, testCase := range parseFullyQualifiedNameTestCases {
+		namespace, module, ok := r.parseFullyQualifiedName(testCase.name)
+		if ok != testCase.ok || namespace != testCase.namespace || module != testCase.module {
+			t.Errorf("%q: expected %q, %q, %v, got %q, %q, %v", testCase.name,
+				testCase.namespace, testCase.module, testCase.ok, namespace, module, ok)
+		}
+	}
+}
+
+func TestFindNamespace(t *testing.T) {
+	exported := map[string]bool{".": true, "device/foo": true}
+	r := NewNameResolver(func(n *Namespace) bool { return exported[n.Path] })
+
+	foo := r.newNamespace("device/foo")
+	bar := r.newNamespace("device/bar")
+	for _, ns := range []*Namespace{foo, bar} {
+		if err := r.addNamespace(ns); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	testCases := []struct {
+		dir       string
+		namespace *Namespace
+	}{
+		{"device/foo", foo},
+		{"device/foo/init", foo},
+		{"device/bar/sepolicy/vendor", bar},
+		{"device/baz", r.rootNamespace},
+		{"frameworks/base", r.rootNamespace},
+	}
+	for _, testCase := range testCases {
+		if ns := r.findNamespace(testCase.dir); ns != testCase.namespace {
+			t.Errorf("%s: expected namespace %q, got %q", testCase.dir, testCase.namespace.Path, ns.Path)
+		}
+	}
+
+	if !foo.exportToMake || bar.exportToMake || !r.rootNamespace.exportToMake {
+		t.Errorf("only the root namespace and device/foo should be exported to Make")
+	}
+
+	if err := r.addNamespace(r.newNamespace("device/foo/init")); err == nil {
+		t.Errorf("expected an error declaring a namespace in a directory that was already searched")
+	}
+
+	bar.importedNamespaceNames = []string{"device/foo"}
+	if err := r.findNamespaceImports(bar); err != nil {
+		t.Fatal(err)
+	}
+	expected := []*Namespace{bar, foo, r.rootNamespace}
+	if len(bar.visibleNamespaces) != len(expected) {
+		t.Fatalf("expected %d visible namespaces, got %d", len(expected), len(bar.visibleNamespaces))
+	}
+	for i := range expected {
+		if bar.visibleNamespaces[i] != expected[i] {
+			t.Errorf("visible namespace %d: expected %q, got %q", i, expected[i].Path,
+				bar.visibleNamespaces[i].Path)
+		}
+	}
+
+	bar.importedNamespaceNames = []string{"device/foo/init"}
+	if err := r.findNamespaceImports(bar); err == nil {
+		t.Errorf("expected an error importing a directory that is not a namespace")
+	}
+}
@@ -158,18 +158,46 @@ type RuleParams struct {
 	GomaSupported bool
 }
 
+// ruleCommandsForTests maps the static rules to their commands, so that tests can include the
+// commands in rule snapshots.  blueprint doesn't expose the parameters of a rule once it is
+// defined.
+var ruleCommandsForTests = make(map[blueprint.Rule]string)
+
 // AndroidStaticRule wraps blueprint.StaticRule and provides a default Pool if none is specified
 func (p AndroidPackageContext) AndroidStaticRule(name string, params blueprint.RuleParams,
 	argNames ...string) blueprint.Rule {
-	return p.AndroidRuleFunc(name, func(interface{}) (blueprint.RuleParams, error) {
+	rule := p.AndroidRuleFunc(name, func(interface{}) (blueprint.RuleParams, error) {
 		return params, nil
 	}, argNames...)
+	ruleCommandsForTests[rule] = params.Command
+	return rule
+}
+
+// tmpDirRules is the set of rules defined with AndroidTmpDirStaticRule.
+var tmpDirRules = make(map[blueprint.Rule]bool)
+
+// AndroidTmpDirStaticRule is like AndroidStaticRule, but every build statement that uses the rule
+// gets its own empty temporary directory in $tmpDir, which is also set as TMPDIR for the command,
+// so that tools that write scratch files don't collide when run in parallel.  ModuleBuild and
+// Build set $tmpDir for the build statements of modules, singletons have to set it themselves to a
+// directory that is unique to the build statement.  The directory is removed when the command succeeds, and left in place for debugging
+// when it fails.
+func (p AndroidPackageContext) AndroidTmpDirStaticRule(name string, params blueprint.RuleParams,
+	argNames ...string) blueprint.Rule {
+
+	params.Command = `rm -rf $tmpDir && mkdir -p $tmpDir && ` +
+		`( export TMPDIR=$tmpDir && ` + params.Command + ` ) && rm -rf $tmpDir`
+	rule := p.AndroidStaticRule(name, params, append(argNames, "tmpDir")...)
+	tmpDirRules[rule] = true
+	return rule
 }
 
 // AndroidGomaStaticRule wraps blueprint.StaticRule but uses goma's parallelism if goma is enabled
 func (p AndroidPackageContext) AndroidGomaStaticRule(name string, params blueprint.RuleParams,
 	argNames ...string) blueprint.Rule {
-	return p.StaticRule(name, params, argNames...)
+	rule := p.StaticRule(name, params, argNames...)
+	ruleCommandsForTests[rule] = params.Command
+	return rule
 }
 
 func (p AndroidPackageContext) AndroidRuleFunc(name string,
@@ -0,0 +1,60 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// This file records the files that modules install, so that modules that package them into an
+// image can copy them from the build outputs.  Device files are installed by Make when Soong is
+// embedded in it, so the installed files themselves can't be used.
+
+// PackagingSpec describes a file or a symlink that a module installs.
+type PackagingSpec struct {
+	// the path that the file is installed to
+	InstallPath OutputPath
+
+	// the file that is copied to the install path, nil for symlinks
+	SrcPath Path
+
+	// the target of a symlink, relative to the directory of the symlink
+	SymlinkTarget string
+}
+
+// RelPathIn returns the path of the installed file relative to dir, which must be a directory of
+// the install path, and false if the file is not installed under dir.
+func (p PackagingSpec) RelPathIn(dir OutputPath) (string, bool) {
+	rel, err := filepath.Rel(dir.String(), p.InstallPath.String())
+	if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", false
+	}
+	return rel, true
+}
+
+func newPackagingSpecSymlink(installPath, target OutputPath) PackagingSpec {
+	rel, err := filepath.Rel(filepath.Dir(installPath.String()), target.String())
+	if err != nil {
+		rel = target.String()
+	}
+	return PackagingSpec{InstallPath: installPath, SymlinkTarget: rel}
+}
+
+// PackagingSpecs returns the files and symlinks installed by the module, whether or not Soong
+// installs them.
+func (a *ModuleBase) PackagingSpecs() []PackagingSpec {
+	return a.packagingSpecs
+}
@@ -0,0 +1,101 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+type packagingModule struct {
+	ModuleBase
+	properties struct {
+		Src *string
+	}
+}
+
+func newPackagingModule() Module {
+	m := &packagingModule{}
+	m.AddProperties(&m.properties)
+	InitAndroidArchModule(m, DeviceSupported, MultilibFirst)
+	return m
+}
+
+func (m *packagingModule) DepsMutator(ctx BottomUpMutatorContext) {
+}
+
+func (m *packagingModule) GenerateAndroidBuildActions(ctx ModuleContext) {
+	dir := PathForModuleInstall(ctx, "bin")
+	installed := ctx.InstallFile(dir, PathForModuleSrc(ctx, *m.properties.Src))
+	ctx.InstallSymlink(PathForModuleInstall(ctx, "xbin"), "link", installed)
+}
+
+func TestPackagingSpecs(t *testing.T) {
+	for _, inMake := range []bool{false, true} {
+		buildDir, err := ioutil.TempDir("", "soong_packaging_test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(buildDir)
+
+		f := NewTestArchFixture(buildDir)
+		f.Config.inMake = inMake
+		f.RegisterModuleType("packaging_module", ModuleFactoryAdaptor(newPackagingModule))
+		f.AddBlueprint(`
+			packaging_module {
+				name: "foo",
+				src: "foo.sh",
+			}
+		`)
+		f.AddFiles("foo.sh")
+		f.Prepare(t)
+
+		// The installed files are recorded whether or not Soong installs them
+		specs := f.ModuleForTests("foo", "android_arm64").Module().PackagingSpecs()
+		if len(specs) != 2 {
+			t.Fatalf("inMake=%v: foo has packaging specs %v, want 2", inMake, specs)
+		}
+
+		productOut := func(dir string) OutputPath {
+			return OutputPath{basePath{"target/product/test_device/" + dir, f.Config, ""}}
+		}
+		system := productOut("system")
+		var rels []string
+		for _, spec := range specs {
+			rel, ok := spec.RelPathIn(system)
+			if !ok {
+				t.Errorf("inMake=%v: %s is not installed into %s", inMake, spec.InstallPath, system)
+			}
+			rels = append(rels, rel)
+		}
+		if want := []string{"bin/foo.sh", "xbin/link"}; !reflect.DeepEqual(rels, want) {
+			t.Errorf("inMake=%v: foo installs %q, want %q", inMake, rels, want)
+		}
+
+		if specs[0].SrcPath == nil || specs[0].SrcPath.String() != "foo.sh" {
+			t.Errorf("inMake=%v: foo.sh is copied from %v, want foo.sh", inMake, specs[0].SrcPath)
+		}
+		if specs[1].SrcPath != nil || specs[1].SymlinkTarget != "../bin/foo.sh" {
+			t.Errorf("inMake=%v: link points to %q, want ../bin/foo.sh", inMake, specs[1].SymlinkTarget)
+		}
+
+		// A file is only under the directories that contain it
+		if _, ok := specs[0].RelPathIn(productOut("sys")); ok {
+			t.Errorf("inMake=%v: foo.sh is installed into sys", inMake)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"github.com/google/blueprint"
+)
+
+// This file enforces the dependency rules between the partitions that device modules can be
+// installed to.  Modules in /system_ext extend the system image, so they may depend on /system
+// modules, but the generic system image must be bootable without /system_ext, so /system
+// modules may not depend on /system_ext modules.
+
+func init() {
+	RegisterMakeVarsProvider(pctx, partitionMakeVarsProvider)
+}
+
+// PartitionImage is implemented by the modules that build the image of a partition from the
+// modules they depend on.  The images are not installed into a partition themselves, so the
+// dependency rules don't apply to them.
+type PartitionImage interface {
+	Module
+	PartitionImage()
+}
+
+func RegisterPartitionPostDepsMutators(ctx RegisterMutatorsContext) {
+	ctx.TopDown("partition_deps", partitionDepsMutator).Parallel()
+}
+
+func partitionDepsMutator(ctx TopDownMutatorContext) {
+	m, ok := ctx.Module().(Module)
+	if !ok || !m.Enabled() || !ctx.Device() {
+		return
+	}
+
+	props := &m.base().commonProperties
+	if props.System_ext_specific && (props.Proprietary || props.Vendor) {
+		ctx.PropertyErrorf("system_ext_specific",
+			"a module cannot be both system_ext_specific and installed into /vendor")
+		return
+	}
+
+	if _, ok := m.(PartitionImage); ok || ctx.Vendor() || ctx.SystemExtSpecific() {
+		return
+	}
+
+	ctx.VisitDirectDeps(func(dep blueprint.Module) {
+		depModule, ok := dep.(Module)
+		if !ok || !depModule.Enabled() || depModule.Target().Os.Class != Device {
+			return
+		}
+		if depModule.base().commonProperties.System_ext_specific {
+			ctx.ModuleErrorf("system module depends on system_ext_specific module %q",
+				ctx.OtherModuleName(dep))
+		}
+	})
+}
+
+func partitionMakeVarsProvider(ctx MakeVarsContext) {
+	ctx.Strict("TARGET_COPY_OUT_SYSTEM_EXT", DeviceConfig{ctx.Config().deviceConfig}.SystemExtPath())
+}
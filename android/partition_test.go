@@ -0,0 +1,164 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+type partitionModule struct {
+	ModuleBase
+	properties struct {
+		Deps []string
+	}
+}
+
+func newPartitionModule() Module {
+	m := &partitionModule{}
+	m.AddProperties(&m.properties)
+	InitAndroidArchModule(m, DeviceSupported, MultilibFirst)
+	return m
+}
+
+func (m *partitionModule) DepsMutator(ctx BottomUpMutatorContext) {
+	ctx.AddDependency(ctx.Module(), nil, m.properties.Deps...)
+}
+
+func (m *partitionModule) GenerateAndroidBuildActions(ctx ModuleContext) {
+}
+
+type partitionImageModule struct {
+	partitionModule
+}
+
+func newPartitionImageModule() Module {
+	m := &partitionImageModule{}
+	m.AddProperties(&m.properties)
+	InitAndroidArchModule(m, DeviceSupported, MultilibFirst)
+	return m
+}
+
+func (m *partitionImageModule) PartitionImage() {}
+
+func newPartitionFixture(buildDir, bp string) *TestFixture {
+	f := NewTestArchFixture(buildDir)
+	f.RegisterModuleType("partition_module", ModuleFactoryAdaptor(newPartitionModule))
+	f.RegisterModuleType("partition_image", ModuleFactoryAdaptor(newPartitionImageModule))
+	f.PostDepsMutators(RegisterPartitionPostDepsMutators)
+	f.AddBlueprint(bp)
+	return f
+}
+
+func TestPartitionDeps(t *testing.T) {
+	buildDir, err := ioutil.TempDir("", "soong_partition_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(buildDir)
+
+	// system_ext and vendor modules, and the images, may depend on system_ext modules
+	f := newPartitionFixture(buildDir, `
+		partition_module {
+			name: "system_ext_lib",
+			system_ext_specific: true,
+			deps: ["system_lib"],
+		}
+
+		partition_module {
+			name: "system_lib",
+		}
+
+		partition_module {
+			name: "system_ext_bin",
+			system_ext_specific: true,
+			deps: ["system_ext_lib"],
+		}
+
+		partition_module {
+			name: "vendor_bin",
+			vendor: true,
+			deps: ["system_ext_lib"],
+		}
+
+		partition_image {
+			name: "system_ext_image",
+			deps: ["system_ext_bin"],
+		}
+	`)
+	f.Prepare(t)
+
+	m := f.ModuleForTests("system_ext_lib", "android_arm64").Module()
+	entries := AndroidMkEntries{}
+	entries.fillInEntries(f.Config, "Android.bp", "system_ext_lib", m)
+	if g := entries.EntryMap["LOCAL_SYSTEM_EXT_MODULE"]; len(g) != 1 || g[0] != "true" {
+		t.Errorf("expected LOCAL_SYSTEM_EXT_MODULE true, got %q", g)
+	}
+}
+
+func TestPartitionDepsErrors(t *testing.T) {
+	buildDir, err := ioutil.TempDir("", "soong_partition_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(buildDir)
+
+	testCases := []struct {
+		name, bp, err string
+	}{
+		{
+			name: "system depends on system_ext",
+			bp: `
+				partition_module {
+					name: "system_bin",
+					deps: ["system_ext_lib"],
+				}
+
+				partition_module {
+					name: "system_ext_lib",
+					system_ext_specific: true,
+				}`,
+			err: `system module depends on system_ext_specific module "system_ext_lib"`,
+		},
+		{
+			name: "system_ext and vendor",
+			bp: `
+				partition_module {
+					name: "lib",
+					system_ext_specific: true,
+					vendor: true,
+				}`,
+			err: "a module cannot be both system_ext_specific and installed into /vendor",
+		},
+		{
+			name: "system_ext and proprietary",
+			bp: `
+				partition_module {
+					name: "lib",
+					system_ext_specific: true,
+					proprietary: true,
+				}`,
+			err: "a module cannot be both system_ext_specific and installed into /vendor",
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			f := newPartitionFixture(buildDir, test.bp)
+			FailIfNoMatchingErrors(t, test.err, f.PrepareWithErrors())
+		})
+	}
+}
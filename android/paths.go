@@ -46,6 +46,8 @@ type ModuleInstallPathContext interface {
 
 	InstallInData() bool
 	InstallInSanitizerDir() bool
+	InstallInRecovery() bool
+	InstallInRamdisk() bool
 }
 
 var _ ModuleInstallPathContext = ModuleContext(nil)
@@ -247,6 +249,9 @@ func PathsForModuleSrc(ctx ModuleContext, paths []string) Paths {
 // each string.
 func pathsForModuleSrcFromFullPath(ctx ModuleContext, paths []string) Paths {
 	prefix := filepath.Join(ctx.AConfig().srcDir, ctx.ModuleDir()) + "/"
+	if prefix == "./" {
+		prefix = ""
+	}
 	ret := make(Paths, 0, len(paths))
 	for _, p := range paths {
 		path := filepath.Clean(p)
@@ -283,6 +288,19 @@ func (p Paths) Strings() []string {
 	return ret
 }
 
+// ShardPaths splits the Paths into shards of at most shardSize paths each, preserving their order.
+func ShardPaths(paths Paths, shardSize int) []Paths {
+	if len(paths) == 0 {
+		return nil
+	}
+	ret := make([]Paths, 0, (len(paths)+shardSize-1)/shardSize)
+	for len(paths) > shardSize {
+		ret = append(ret, paths[0:shardSize])
+		paths = paths[shardSize:]
+	}
+	return append(ret, paths)
+}
+
 // WritablePaths is a slice of WritablePaths, used for multiple outputs.
 type WritablePaths []WritablePath
 
@@ -686,8 +704,15 @@ func PathForModuleInstall(ctx ModuleInstallPathContext, pathComponents ...string
 		var partition string
 		if ctx.InstallInData() {
 			partition = "data"
+		} else if ctx.InstallInRecovery() {
+			// The recovery ramdisk is staged as the root directory of the recovery image
+			partition = "recovery/root/system"
+		} else if ctx.InstallInRamdisk() {
+			partition = "ramdisk"
 		} else if ctx.Vendor() {
 			partition = ctx.DeviceConfig().VendorPath()
+		} else if ctx.SystemExtSpecific() {
+			partition = ctx.DeviceConfig().SystemExtPath()
 		} else {
 			partition = "system"
 		}
@@ -188,6 +188,8 @@ type moduleInstallPathContextImpl struct {
 
 	inData         bool
 	inSanitizerDir bool
+	inRecovery     bool
+	inRamdisk      bool
 }
 
 func (moduleInstallPathContextImpl) Fs() pathtools.FileSystem {
@@ -208,6 +210,14 @@ func (m moduleInstallPathContextImpl) InstallInSanitizerDir() bool {
 	return m.inSanitizerDir
 }
 
+func (m moduleInstallPathContextImpl) InstallInRecovery() bool {
+	return m.inRecovery
+}
+
+func (m moduleInstallPathContextImpl) InstallInRamdisk() bool {
+	return m.inRamdisk
+}
+
 func TestPathForModuleInstall(t *testing.T) {
 	testConfig := TestConfig("")
 
@@ -252,6 +262,39 @@ func TestPathForModuleInstall(t *testing.T) {
 			in:  []string{"bin", "my_test"},
 			out: "target/product/test_device/vendor/bin/my_test",
 		},
+		{
+			name: "system_ext binary",
+			ctx: &moduleInstallPathContextImpl{
+				androidBaseContextImpl: androidBaseContextImpl{
+					target:    deviceTarget,
+					systemExt: true,
+				},
+			},
+			in:  []string{"bin", "my_test"},
+			out: "target/product/test_device/system_ext/bin/my_test",
+		},
+		{
+			name: "recovery binary",
+			ctx: &moduleInstallPathContextImpl{
+				androidBaseContextImpl: androidBaseContextImpl{
+					target: deviceTarget,
+				},
+				inRecovery: true,
+			},
+			in:  []string{"bin", "my_test"},
+			out: "target/product/test_device/recovery/root/system/bin/my_test",
+		},
+		{
+			name: "ramdisk binary",
+			ctx: &moduleInstallPathContextImpl{
+				androidBaseContextImpl: androidBaseContextImpl{
+					target: deviceTarget,
+				},
+				inRamdisk: true,
+			},
+			in:  []string{"bin", "my_test"},
+			out: "target/product/test_device/ramdisk/bin/my_test",
+		},
 
 		{
 			name: "system native test binary",
@@ -340,3 +383,43 @@ func TestPathForModuleInstall(t *testing.T) {
 		})
 	}
 }
+
+func TestShardPaths(t *testing.T) {
+	paths := func(names ...string) Paths {
+		return PathsForTesting(names)
+	}
+
+	testCases := []struct {
+		in        Paths
+		shardSize int
+		out       []Paths
+	}{
+		{
+			in:        nil,
+			shardSize: 2,
+			out:       nil,
+		},
+		{
+			in:        paths("a", "b"),
+			shardSize: 2,
+			out:       []Paths{paths("a", "b")},
+		},
+		{
+			in:        paths("a", "b", "c"),
+			shardSize: 2,
+			out:       []Paths{paths("a", "b"), paths("c")},
+		},
+		{
+			in:        paths("a", "b", "c", "d"),
+			shardSize: 1,
+			out:       []Paths{paths("a"), paths("b"), paths("c"), paths("d")},
+		},
+	}
+
+	for _, tc := range testCases {
+		out := ShardPaths(tc.in, tc.shardSize)
+		if !reflect.DeepEqual(out, tc.out) {
+			t.Errorf("ShardPaths(%v, %d) = %v, want %v", tc.in, tc.shardSize, out, tc.out)
+		}
+	}
+}
@@ -0,0 +1,326 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+// This file gives modules access to the board and product specific variables that the product
+// configuration declares with SOONG_CONFIG_NAMESPACES, SOONG_CONFIG_<namespace> and
+// SOONG_CONFIG_<namespace>_<variable>, and that Make passes in the VendorVars product variable.
+//
+// Android.bp files declare the variables with the soong_config_bool_variable and
+// soong_config_string_variable module types, and defaults modules whose properties are only used
+// for a value of a variable:
+//
+//     soong_config_bool_variable {
+//         name: "lineage_uses_camera1",
+//         config_namespace: "lineage",
+//         variable: "uses_camera1",
+//     }
+//
+//     cc_defaults {
+//         name: "camera1_defaults",
+//         soong_config_variable: "lineage_uses_camera1",
+//         cflags: ["-DUSES_CAMERA1"],
+//         srcs: ["camera1.cpp"],
+//     }
+//
+// The properties of the defaults are appended to the modules that use them when the bool variable
+// is set to "true", or when the string variable is set, with %s replaced by its value.  Unlike the
+// properties of other defaults they override the values set by the module, so that a module
+// that sets enabled: false can be enabled by them.  Blueprint can't register the module types of
+// soong_config_module_type modules while it parses the Android.bp files, so the declarations are
+// modules that the load hooks, which run before the defaults are applied, register by name.
+//
+// Soong plugins can also declare variables from the init functions of their packages:
+//
+//     android.RegisterSoongConfigBoolVariable("lineage", "uses_camera1")
+//     android.RegisterSoongConfigStringVariable("lineage", "board")
+//
+// Android.bp files then set the arch_variant properties of any module for the values of these
+// variables in soong_config_variables, which is evaluated like product_variables:
+//
+//     cc_library {
+//         name: "libcamera",
+//         soong_config_variables: {
+//             lineage_uses_camera1: {
+//                 cflags: ["-DUSES_CAMERA1"],
+//             },
+//             lineage_board: {
+//                 cflags: ["-DBOARD=\"%s\""],
+//             },
+//         },
+//     }
+//
+// Go module types and load hooks can also read the variables with Config.VendorConfig.
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sync"
+
+	"github.com/google/blueprint/proptools"
+)
+
+// SoongConfig is the set of variables of one SOONG_CONFIG namespace.
+type SoongConfig interface {
+	// Bool returns true if the variable is set to "true".
+	Bool(name string) bool
+
+	// String returns the value of the variable, or "" if it is not set.
+	String(name string) string
+
+	// IsSet returns true if the variable is set, even to an empty value.
+	IsSet(name string) bool
+}
+
+type soongConfig map[string]string
+
+func (c soongConfig) Bool(name string) bool {
+	return c[name] == "true"
+}
+
+func (c soongConfig) String(name string) string {
+	return c[name]
+}
+
+func (c soongConfig) IsSet(name string) bool {
+	_, ok := c[name]
+	return ok
+}
+
+// VendorConfig returns the variables of the SOONG_CONFIG namespace, which are all unset if the
+// product doesn't declare the namespace.
+func (c *config) VendorConfig(namespace string) SoongConfig {
+	return soongConfig(c.ProductVariables.VendorVars[namespace])
+}
+
+// soongConfigVariable is a variable that soong_config_variables can set properties for.
+type soongConfigVariable struct {
+	namespace, name string
+	isString        bool
+}
+
+// propertyName returns the name of the property of the variable in soong_config_variables.
+func (v soongConfigVariable) propertyName() string {
+	return v.namespace + "_" + v.name
+}
+
+var soongConfigVariables []soongConfigVariable
+
+var soongConfigNameRegexp = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+func registerSoongConfigVariable(namespace, name string, isString bool) {
+	if !soongConfigNameRegexp.MatchString(namespace) || !soongConfigNameRegexp.MatchString(name) {
+		panic(fmt.Errorf("invalid soong config variable %s_%s, the namespace and name must be "+
+			"lower case identifiers", namespace, name))
+	}
+	v := soongConfigVariable{namespace, name, isString}
+	for _, existing := range soongConfigVariables {
+		if existing.propertyName() == v.propertyName() {
+			panic(fmt.Errorf("soong config variable %s is already registered", v.propertyName()))
+		}
+	}
+	soongConfigVariables = append(soongConfigVariables, v)
+}
+
+// soongConfigVariableValue returns the value of the variable declared by the soong config variable
+// module name: true for a bool variable set to "true", or the value of a string variable that is
+// set.  It returns false if the variable is not declared, or if the bool variable is not true or
+// the string variable is not set.
+func soongConfigVariableValue(config Config, name string) (interface{}, bool) {
+	v, ok := soongConfigVariableDeclarations(config).get(name)
+	if !ok {
+		return nil, false
+	}
+	vendorConfig := config.VendorConfig(v.namespace)
+	if v.isString {
+		return vendorConfig.String(v.name), vendorConfig.IsSet(v.name)
+	}
+	return true, vendorConfig.Bool(v.name)
+}
+
+func init() {
+	RegisterModuleType("soong_config_bool_variable", SoongConfigBoolVariableFactory)
+	RegisterModuleType("soong_config_string_variable", SoongConfigStringVariableFactory)
+}
+
+// soongConfigDeclarations are the variables declared by the soong config variable modules of the
+// Android.bp files, by module name.
+type soongConfigDeclarations struct {
+	sync.Mutex
+	variables map[string]soongConfigVariable
+}
+
+func (d *soongConfigDeclarations) get(name string) (soongConfigVariable, bool) {
+	d.Lock()
+	defer d.Unlock()
+	v, ok := d.variables[name]
+	return v, ok
+}
+
+func (d *soongConfigDeclarations) add(name string, v soongConfigVariable) {
+	d.Lock()
+	defer d.Unlock()
+	d.variables[name] = v
+}
+
+const soongConfigDeclarationsOnceKey = "soongConfigDeclarations"
+
+func soongConfigVariableDeclarations(config Config) *soongConfigDeclarations {
+	return config.Once(soongConfigDeclarationsOnceKey, func() interface{} {
+		return &soongConfigDeclarations{variables: make(map[string]soongConfigVariable)}
+	}).(*soongConfigDeclarations)
+}
+
+type soongConfigVariableProperties struct {
+	// the SOONG_CONFIG namespace of the variable
+	Config_namespace *string
+
+	// the name of the variable in the namespace
+	Variable *string
+}
+
+type soongConfigVariableModule struct {
+	ModuleBase
+
+	properties soongConfigVariableProperties
+	isString   bool
+}
+
+// soong_config_bool_variable declares a bool variable of a SOONG_CONFIG namespace, which is true
+// when it is set to "true", for the soong_config_variable of defaults modules.
+func SoongConfigBoolVariableFactory() Module {
+	return newSoongConfigVariableModule(false)
+}
+
+// soong_config_string_variable declares a string variable of a SOONG_CONFIG namespace for the
+// soong_config_variable of defaults modules.
+func SoongConfigStringVariableFactory() Module {
+	return newSoongConfigVariableModule(true)
+}
+
+func newSoongConfigVariableModule(isString bool) Module {
+	m := &soongConfigVariableModule{isString: isString}
+	m.AddProperties(&m.properties)
+	InitAndroidModule(m)
+	AddLoadHook(m, func(ctx LoadHookContext) {
+		namespace := String(m.properties.Config_namespace)
+		name := String(m.properties.Variable)
+		if !soongConfigNameRegexp.MatchString(namespace) {
+			ctx.PropertyErrorf("config_namespace", "%q is not a lower case identifier", namespace)
+			return
+		}
+		if !soongConfigNameRegexp.MatchString(name) {
+			ctx.PropertyErrorf("variable", "%q is not a lower case identifier", name)
+			return
+		}
+		soongConfigVariableDeclarations(ctx.AConfig()).add(ctx.ModuleName(),
+			soongConfigVariable{namespace, name, isString})
+	})
+	return m
+}
+
+func (m *soongConfigVariableModule) DepsMutator(ctx BottomUpMutatorContext) {
+}
+
+func (m *soongConfigVariableModule) GenerateAndroidBuildActions(ctx ModuleContext) {
+}
+
+// RegisterSoongConfigBoolVariable declares a bool variable of a SOONG_CONFIG namespace, so that
+// modules can set properties for it in soong_config_variables.  It must be called from an init
+// function, before any module is created.
+func RegisterSoongConfigBoolVariable(namespace, name string) {
+	registerSoongConfigVariable(namespace, name, false)
+}
+
+// RegisterSoongConfigStringVariable declares a string variable of a SOONG_CONFIG namespace, so
+// that modules can set properties for it in soong_config_variables.  It must be called from an
+// init function, before any module is created.
+func RegisterSoongConfigStringVariable(namespace, name string) {
+	registerSoongConfigVariable(namespace, name, true)
+}
+
+// createSoongConfigType returns the type of the soong_config_variables property for a properties
+// struct, which has the arch_variant properties of the struct for each variable, or nil if there
+// are no variables or no arch_variant properties.
+func createSoongConfigType(props reflect.Type) reflect.Type {
+	if len(soongConfigVariables) == 0 {
+		return nil
+	}
+	props, ok := filterArchStruct(props)
+	if !ok {
+		return nil
+	}
+
+	fields := make([]reflect.StructField, len(soongConfigVariables))
+	for i, v := range soongConfigVariables {
+		fields[i] = reflect.StructField{
+			Name: proptools.FieldNameForProperty(v.propertyName()),
+			Type: props,
+		}
+	}
+	return reflect.StructOf([]reflect.StructField{
+		reflect.StructField{
+			Name: "Soong_config_variables",
+			Type: reflect.StructOf(fields),
+		},
+	})
+}
+
+var soongConfigPropTypeMap OncePer
+
+// setSoongConfigProperties appends the properties that soong_config_variables sets for the
+// values of the variables to the properties of the module.
+func (a *ModuleBase) setSoongConfigProperties(ctx BottomUpMutatorContext) {
+	config := ctx.Config().(Config)
+	for i := range a.generalProperties {
+		if a.soongConfigProperties[i] == nil {
+			continue
+		}
+		variables := reflect.ValueOf(a.soongConfigProperties[i]).Elem().FieldByName("Soong_config_variables")
+		for _, v := range soongConfigVariables {
+			propertyValue := variables.FieldByName(proptools.FieldNameForProperty(v.propertyName()))
+			if propertyValue.IsNil() {
+				continue
+			}
+
+			vendorConfig := config.VendorConfig(v.namespace)
+			var value interface{}
+			if v.isString {
+				if !vendorConfig.IsSet(v.name) {
+					continue
+				}
+				value = vendorConfig.String(v.name)
+			} else {
+				if !vendorConfig.Bool(v.name) {
+					continue
+				}
+				value = true
+			}
+
+			property := "soong_config_variables." + v.propertyName()
+			printfIntoProperties(ctx, property, propertyValue.Elem(), value)
+			err := proptools.AppendMatchingProperties([]interface{}{a.generalProperties[i]},
+				propertyValue.Interface(), nil)
+			if err != nil {
+				if propertyErr, ok := err.(*proptools.ExtendPropertyError); ok {
+					ctx.PropertyErrorf(propertyErr.Property, "%s", propertyErr.Err.Error())
+				} else {
+					panic(err)
+				}
+			}
+		}
+	}
+}
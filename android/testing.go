@@ -15,21 +15,44 @@
 package android
 
 import (
+	"bytes"
 	"fmt"
+	"io/ioutil"
+	"sort"
 	"strings"
+	"testing"
 
 	"github.com/google/blueprint"
 )
 
 func NewTestContext() *TestContext {
-	return &TestContext{
-		Context: blueprint.NewContext(),
+	namespaceExportFilter := func(namespace *Namespace) bool {
+		return true
 	}
+
+	nameResolver := NewNameResolver(namespaceExportFilter)
+	ctx := &TestContext{
+		Context:      blueprint.NewContext(),
+		NameResolver: nameResolver,
+	}
+
+	ctx.SetNameInterface(nameResolver)
+
+	return ctx
+}
+
+// NewTestArchContext returns a TestContext that also runs the arch mutator, which splits modules
+// into variants for the targets of the config, usually a TestArchConfig.
+func NewTestArchContext() *TestContext {
+	ctx := NewTestContext()
+	ctx.preDeps = append(ctx.preDeps, registerArchMutator)
+	return ctx
 }
 
 type TestContext struct {
 	*blueprint.Context
 	preArch, preDeps, postDeps []RegisterMutatorFunc
+	NameResolver               *NameResolver
 }
 
 func (ctx *TestContext) PreArchMutators(f RegisterMutatorFunc) {
@@ -96,3 +119,214 @@ func (m TestingModule) Output(file string) ModuleBuildParams {
 	}
 	panic(fmt.Errorf("couldn't find output %q", file))
 }
+
+// TestFixture sets up a test of one or more module types: register the module types, mutators
+// and singletons under test on the embedded TestContext, add the Android.bp and source files to
+// the mock filesystem, adjust the product variables in Config, and then call Prepare to parse the
+// Android.bp files and generate the build actions that the test inspects with ModuleForTests.
+type TestFixture struct {
+	*TestContext
+	Config Config
+
+	fs map[string][]byte
+}
+
+func NewTestFixture(buildDir string) *TestFixture {
+	return &TestFixture{
+		TestContext: NewTestContext(),
+		Config:      TestConfig(buildDir),
+		fs:          make(map[string][]byte),
+	}
+}
+
+// NewTestArchFixture returns a TestFixture whose modules are built for the device and host targets
+// of a TestArchConfig.
+func NewTestArchFixture(buildDir string) *TestFixture {
+	return &TestFixture{
+		TestContext: NewTestArchContext(),
+		Config:      TestArchConfig(buildDir),
+		fs:          make(map[string][]byte),
+	}
+}
+
+// AddBlueprint appends module definitions to the top level Android.bp file.
+func (f *TestFixture) AddBlueprint(bp string) {
+	f.fs["Android.bp"] = append(f.fs["Android.bp"], bp...)
+}
+
+// AddFiles adds files to the mock filesystem.  Source files only need to exist, so the contents
+// are usually nil.
+func (f *TestFixture) AddFiles(files ...string) {
+	for _, file := range files {
+		f.fs[file] = nil
+	}
+}
+
+// AddFileContents adds files with contents to the mock filesystem.
+func (f *TestFixture) AddFileContents(files map[string][]byte) {
+	for file, contents := range files {
+		f.fs[file] = contents
+	}
+}
+
+// SetEnv sets an environment variable as seen by the modules, which otherwise see the environment
+// of the test.
+func (f *TestFixture) SetEnv(key, value string) {
+	if f.Config.envDeps == nil {
+		f.Config.envDeps = make(map[string]string)
+	}
+	f.Config.envDeps[key] = value
+}
+
+// PrepareWithErrors registers the mutators, parses the Android.bp files and generates the build
+// actions, returning any errors.
+func (f *TestFixture) PrepareWithErrors() []error {
+	f.Register()
+	f.MockFileSystem(f.fs)
+
+	if _, errs := f.ParseBlueprintsFiles("Android.bp"); len(errs) > 0 {
+		return errs
+	}
+	_, errs := f.PrepareBuildActions(f.Config)
+	return errs
+}
+
+// Prepare is like PrepareWithErrors, but fails the test if there are any errors.
+func (f *TestFixture) Prepare(t *testing.T) {
+	FailIfErrored(t, f.PrepareWithErrors())
+}
+
+// FailIfErrored fails the test with the given errors, if there are any.
+func FailIfErrored(t *testing.T, errs []error) {
+	if len(errs) > 0 {
+		for _, err := range errs {
+			t.Error(err)
+		}
+		t.FailNow()
+	}
+}
+
+// FailIfNoMatchingErrors fails the test if none of the errors match pattern.
+func FailIfNoMatchingErrors(t *testing.T, pattern string, errs []error) {
+	for _, err := range errs {
+		if strings.Contains(err.Error(), pattern) {
+			return
+		}
+	}
+	t.Errorf("missing expected error %q (%d errors)", pattern, len(errs))
+	for i, err := range errs {
+		t.Errorf("errs[%d] = %s", i, err)
+	}
+}
+
+// RuleSnapshot returns a normalized description of the build statements of the named modules in
+// all of their variants, including the commands of the rules, for comparing with a golden file
+// with CheckGoldenFile.  Paths in the build directory are written relative to $OUT_DIR so that
+// the snapshot doesn't depend on where the test runs.
+func (f *TestFixture) RuleSnapshot(names ...string) string {
+	var modules []Module
+	f.VisitAllModules(func(m blueprint.Module) {
+		if inList(f.ModuleName(m), names) {
+			modules = append(modules, m.(Module))
+		}
+	})
+	sort.Slice(modules, func(i, j int) bool {
+		if f.ModuleName(modules[i]) != f.ModuleName(modules[j]) {
+			return f.ModuleName(modules[i]) < f.ModuleName(modules[j])
+		}
+		return f.ModuleSubDir(modules[i]) < f.ModuleSubDir(modules[j])
+	})
+
+	buf := &bytes.Buffer{}
+	for _, m := range modules {
+		fmt.Fprintf(buf, "%s (variant %q):\n", f.ModuleName(m), f.ModuleSubDir(m))
+		for _, p := range m.BuildParamsForTests() {
+			writeBuildParamsSnapshot(buf, p)
+		}
+	}
+
+	return strings.Replace(buf.String(), f.Config.BuildDir(), "$OUT_DIR", -1)
+}
+
+func writeBuildParamsSnapshot(buf *bytes.Buffer, p ModuleBuildParams) {
+	// Only the name of the rule, without the package path
+	rule := p.Rule.String()
+	if i := strings.LastIndex(rule, "."); i >= 0 {
+		rule = rule[i+1:]
+	}
+	fmt.Fprintf(buf, "  build %s\n", rule)
+
+	field := func(name, value string) {
+		if value != "" {
+			fmt.Fprintf(buf, "    %s: %s\n", name, value)
+		}
+	}
+	paths := func(name string, path Path, paths Paths) {
+		if path != nil {
+			paths = append(Paths{path}, paths...)
+		}
+		field(name, strings.Join(paths.Strings(), " "))
+	}
+	writablePaths := func(name string, path WritablePath, paths WritablePaths) {
+		if path != nil {
+			paths = append(WritablePaths{path}, paths...)
+		}
+		field(name, strings.Join(paths.Strings(), " "))
+	}
+
+	field("command", ruleCommandsForTests[p.Rule])
+	field("description", p.Description)
+	writablePaths("outputs", p.Output, p.Outputs)
+	writablePaths("implicit outputs", p.ImplicitOutput, p.ImplicitOutputs)
+	paths("inputs", p.Input, p.Inputs)
+	paths("implicits", p.Implicit, p.Implicits)
+	paths("order only", nil, p.OrderOnly)
+
+	var args []string
+	for arg := range p.Args {
+		args = append(args, arg)
+	}
+	sort.Strings(args)
+	for _, arg := range args {
+		field("arg "+arg, p.Args[arg])
+	}
+}
+
+// CheckGoldenFile fails the test if snapshot doesn't match the golden file at path, relative to
+// the directory of the test.  If the UPDATE_GOLDEN_FILES environment variable is set to true the
+// golden file is rewritten instead, so that intended changes can be reviewed in the diff of the
+// golden file.
+func CheckGoldenFile(t *testing.T, path, snapshot string) {
+	// The environment is cleared when the package is initialized, read the variable from the
+	// saved copy
+	if originalEnv["UPDATE_GOLDEN_FILES"] == "true" {
+		if err := ioutil.WriteFile(path, []byte(snapshot), 0666); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	golden, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%s, run the test with UPDATE_GOLDEN_FILES=true to create it", err)
+	}
+
+	goldenLines := strings.Split(string(golden), "\n")
+	snapshotLines := strings.Split(snapshot, "\n")
+	for i := 0; i < len(goldenLines) || i < len(snapshotLines); i++ {
+		var goldenLine, snapshotLine string
+		if i < len(goldenLines) {
+			goldenLine = goldenLines[i]
+		}
+		if i < len(snapshotLines) {
+			snapshotLine = snapshotLines[i]
+		}
+		if goldenLine != snapshotLine {
+			t.Errorf("%s:%d differs from the generated build statements:\n"+
+				"  want: %s\n   got: %s\n"+
+				"run the test with UPDATE_GOLDEN_FILES=true to update the golden file if the change is intended",
+				path, i+1, goldenLine, snapshotLine)
+			return
+		}
+	}
+}
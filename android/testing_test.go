@@ -0,0 +1,85 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestRuleSnapshot(t *testing.T) {
+	buildDir, err := ioutil.TempDir("", "soong_rule_snapshot_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(buildDir)
+
+	f := NewTestFixture(buildDir)
+	f.RegisterModuleType("copy", ModuleFactoryAdaptor(newCopyModule))
+	f.AddBlueprint(`
+		copy {
+			name: "foo",
+			src: "foo.txt",
+		}
+
+		copy {
+			name: "bar",
+			src: "bar.txt",
+			flags: "-p",
+		}
+
+		copy {
+			name: "baz",
+			src: "baz.txt",
+		}
+	`)
+	f.AddFiles("foo.txt", "bar.txt", "baz.txt")
+	f.Prepare(t)
+
+	CheckGoldenFile(t, "testdata/rule_snapshot.txt", f.RuleSnapshot("foo", "bar"))
+}
+
+type copyModule struct {
+	ModuleBase
+	properties struct {
+		Src   string
+		Flags string
+	}
+}
+
+func newCopyModule() Module {
+	m := &copyModule{}
+	m.AddProperties(&m.properties)
+	InitAndroidModule(m)
+	return m
+}
+
+func (c *copyModule) DepsMutator(ctx BottomUpMutatorContext) {
+}
+
+func (c *copyModule) GenerateAndroidBuildActions(ctx ModuleContext) {
+	src := PathForModuleSrc(ctx, c.properties.Src)
+	out := PathForModuleOut(ctx, src.Base())
+	ctx.ModuleBuild(pctx, ModuleBuildParams{
+		Rule:        Cp,
+		Description: "copy " + src.Base(),
+		Output:      out,
+		Input:       src,
+		Args: map[string]string{
+			"cpFlags": c.properties.Flags,
+		},
+	})
+}
@@ -0,0 +1,120 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+// This file implements tracing of modules.  SOONG_DEBUG_MODULES is a comma separated list of
+// module names; for the listed modules every mutator that runs, every variation and dependency
+// that is added, and every build statement is logged to stderr, along with anything module types
+// log with Tracef, for example the flags they compute.
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/google/blueprint"
+)
+
+const traceModulesOnceKey = "traceModules"
+
+var traceLock sync.Mutex
+
+// traceWriter is where the trace messages are logged, replaced by tests.
+var traceWriter io.Writer = os.Stderr
+
+// TraceModule returns true if the module is listed in SOONG_DEBUG_MODULES.
+func (c *config) TraceModule(name string) bool {
+	modules := c.OnceStringSlice(traceModulesOnceKey, func() []string {
+		var modules []string
+		for _, m := range strings.Split(c.Getenv("SOONG_DEBUG_MODULES"), ",") {
+			if m = strings.TrimSpace(m); m != "" {
+				modules = append(modules, m)
+			}
+		}
+		return modules
+	})
+	return inList(name, modules)
+}
+
+// traceName returns the name that trace messages of a module are prefixed with, or "" if the
+// module isn't traced.
+func traceName(ctx blueprint.BaseModuleContext) string {
+	if !ctx.Config().(Config).TraceModule(ctx.ModuleName()) {
+		return ""
+	}
+	// The variant is only known once the mutators have finished
+	if m, ok := ctx.(interface {
+		ModuleSubDir() string
+	}); ok && m.ModuleSubDir() != "" {
+		return ctx.ModuleName() + " [" + m.ModuleSubDir() + "]"
+	}
+	return ctx.ModuleName()
+}
+
+// Tracef logs a message for the module if it is listed in SOONG_DEBUG_MODULES.
+func (a *androidBaseContextImpl) Tracef(format string, args ...interface{}) {
+	if a.traceName == "" {
+		return
+	}
+	traceLock.Lock()
+	defer traceLock.Unlock()
+	fmt.Fprintf(traceWriter, "soong debug: %s: %s\n", a.traceName, fmt.Sprintf(format, args...))
+}
+
+func (a *androidBottomUpMutatorContext) AddDependency(module blueprint.Module,
+	tag blueprint.DependencyTag, names ...string) {
+
+	if len(names) > 0 {
+		a.Tracef("adding dependencies %q with tag %+v", names, tag)
+	}
+	a.BottomUpMutatorContext.AddDependency(module, tag, names...)
+}
+
+func (a *androidBottomUpMutatorContext) AddReverseDependency(module blueprint.Module,
+	tag blueprint.DependencyTag, name string) {
+
+	a.Tracef("adding reverse dependency from %q with tag %+v", name, tag)
+	a.BottomUpMutatorContext.AddReverseDependency(module, tag, name)
+}
+
+func (a *androidBottomUpMutatorContext) AddVariationDependencies(variations []blueprint.Variation,
+	tag blueprint.DependencyTag, names ...string) {
+
+	if len(names) > 0 {
+		a.Tracef("adding dependencies %q on variations %+v with tag %+v", names, variations, tag)
+	}
+	a.BottomUpMutatorContext.AddVariationDependencies(variations, tag, names...)
+}
+
+func (a *androidBottomUpMutatorContext) AddFarVariationDependencies(variations []blueprint.Variation,
+	tag blueprint.DependencyTag, names ...string) {
+
+	if len(names) > 0 {
+		a.Tracef("adding dependencies %q on far variations %+v with tag %+v", names, variations, tag)
+	}
+	a.BottomUpMutatorContext.AddFarVariationDependencies(variations, tag, names...)
+}
+
+func (a *androidBottomUpMutatorContext) CreateVariations(names ...string) []blueprint.Module {
+	a.Tracef("creating variations %q", names)
+	return a.BottomUpMutatorContext.CreateVariations(names...)
+}
+
+func (a *androidBottomUpMutatorContext) CreateLocalVariations(names ...string) []blueprint.Module {
+	a.Tracef("creating local variations %q", names)
+	return a.BottomUpMutatorContext.CreateLocalVariations(names...)
+}
@@ -0,0 +1,87 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestTraceModule(t *testing.T) {
+	config := TestConfig("")
+	config.envDeps = map[string]string{"SOONG_DEBUG_MODULES": " foo,,bar "}
+
+	for _, test := range []struct {
+		name  string
+		trace bool
+	}{
+		{"foo", true},
+		{"bar", true},
+		{"baz", false},
+		{"", false},
+	} {
+		if g := config.TraceModule(test.name); g != test.trace {
+			t.Errorf("TraceModule(%q): expected %v, got %v", test.name, test.trace, g)
+		}
+	}
+}
+
+func TestTracef(t *testing.T) {
+	buildDir, err := ioutil.TempDir("", "soong_trace_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(buildDir)
+
+	var trace bytes.Buffer
+	traceWriter = &trace
+	defer func() { traceWriter = os.Stderr }()
+
+	f := NewTestFixture(buildDir)
+	f.RegisterModuleType("srcs", ModuleFactoryAdaptor(newSrcsModule))
+	f.AddBlueprint(`
+		srcs {
+			name: "foo",
+			srcs: ["a.java"],
+		}
+
+		srcs {
+			name: "bar",
+			srcs: [":foo"],
+		}
+	`)
+	f.AddFiles("a.java")
+	f.SetEnv("SOONG_DEBUG_MODULES", "bar")
+	f.Prepare(t)
+
+	log := trace.String()
+	for _, line := range []string{
+		"soong debug: bar: running bottom up mutator deps\n",
+		`soong debug: bar: adding dependencies ["foo"] with tag`,
+		"soong debug: bar: generating build actions\n",
+	} {
+		if !strings.Contains(log, line) {
+			t.Errorf("trace doesn't contain %q:\n%s", line, log)
+		}
+	}
+
+	// Only the listed modules are traced
+	if strings.Contains(log, "soong debug: foo") {
+		t.Errorf("foo is traced without being listed:\n%s", log)
+	}
+}
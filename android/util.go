@@ -15,6 +15,7 @@
 package android
 
 import (
+	"path/filepath"
 	"runtime"
 	"sort"
 	"strings"
@@ -68,6 +69,33 @@ func inList(s string, list []string) bool {
 	return indexList(s, list) != -1
 }
 
+// matchGlob returns true if name matches pattern, a glob in which "**" matches any number of
+// directories as in pathtools.Glob.
+func matchGlob(pattern, name string) bool {
+	return matchGlobParts(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchGlobParts(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchGlobParts(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if match, err := filepath.Match(pattern[0], name[0]); err != nil || !match {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
 func prefixInList(s string, list []string) bool {
 	for _, prefix := range list {
 		if strings.HasPrefix(s, prefix) {
@@ -0,0 +1,168 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+// This file contains a declarative layer for validating the properties of modules.  Module types
+// implement PropertyValidator to list the constraints on their properties, which are checked
+// before GenerateAndroidBuildActions is called.  Violations are reported with PropertyErrorf, so
+// the errors point to the property in the Blueprints file.
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/google/blueprint/proptools"
+)
+
+// PropertyValidator is implemented by module types that declare constraints on their
+// properties.
+type PropertyValidator interface {
+	PropertyConstraints() []PropertyConstraint
+}
+
+// PropertyConstraint is a constraint on the properties of a module.  Properties are named as in
+// Blueprints files, with nested properties separated by dots, for example
+// "check_api.current.api_file".
+type PropertyConstraint interface {
+	check(ctx ModuleContext, props []interface{})
+}
+
+// MutuallyExclusive returns a constraint that at most one of the properties is set.
+func MutuallyExclusive(properties ...string) PropertyConstraint {
+	return mutuallyExclusive(properties)
+}
+
+type mutuallyExclusive []string
+
+func (c mutuallyExclusive) check(ctx ModuleContext, props []interface{}) {
+	var set []string
+	for _, property := range c {
+		if isPropertySet(propertyValue(ctx, props, property)) {
+			set = append(set, property)
+		}
+	}
+	if len(set) > 1 {
+		ctx.PropertyErrorf(set[1], "cannot be set together with %s", set[0])
+	}
+}
+
+// RequiredIf returns a constraint that property is set if condition is set.
+func RequiredIf(property, condition string) PropertyConstraint {
+	return requiredIf{property, condition}
+}
+
+type requiredIf struct {
+	property, condition string
+}
+
+func (c requiredIf) check(ctx ModuleContext, props []interface{}) {
+	if isPropertySet(propertyValue(ctx, props, c.condition)) &&
+		!isPropertySet(propertyValue(ctx, props, c.property)) {
+		ctx.PropertyErrorf(c.condition, "requires %s to be set", c.property)
+	}
+}
+
+// MatchesRegexp returns a constraint that the value of a string property, or every value of a
+// list of strings property, matches pattern.  description describes the values that match, for
+// use in the error message.
+func MatchesRegexp(property, pattern, description string) PropertyConstraint {
+	return matchesRegexp{property, regexp.MustCompile(pattern), description}
+}
+
+type matchesRegexp struct {
+	property    string
+	regexp      *regexp.Regexp
+	description string
+}
+
+func (c matchesRegexp) check(ctx ModuleContext, props []interface{}) {
+	v := propertyValue(ctx, props, c.property)
+	var values []string
+	switch {
+	case v.Kind() == reflect.String:
+		values = []string{v.String()}
+	case v.Kind() == reflect.Ptr && v.Type().Elem().Kind() == reflect.String:
+		if !v.IsNil() {
+			values = []string{v.Elem().String()}
+		}
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.String:
+		for i := 0; i < v.Len(); i++ {
+			values = append(values, v.Index(i).String())
+		}
+	default:
+		panic(fmt.Errorf("property %q of %s is not a string or list of strings",
+			c.property, ctx.ModuleName()))
+	}
+
+	for _, value := range values {
+		if !c.regexp.MatchString(value) {
+			ctx.PropertyErrorf(c.property, "%q is not %s", value, c.description)
+		}
+	}
+}
+
+// propertyValue returns the value of the named property from the property structs of the
+// module.
+func propertyValue(ctx ModuleContext, props []interface{}, property string) reflect.Value {
+	for _, p := range props {
+		v := reflect.ValueOf(p).Elem()
+		for _, name := range strings.Split(property, ".") {
+			if v.Kind() == reflect.Ptr && v.Type().Elem().Kind() == reflect.Struct {
+				if v.IsNil() {
+					// None of the nested properties are set
+					v = reflect.Zero(v.Type().Elem())
+				} else {
+					v = v.Elem()
+				}
+			}
+			if v.Kind() != reflect.Struct {
+				v = reflect.Value{}
+				break
+			}
+			v = v.FieldByName(proptools.FieldNameForProperty(name))
+			if !v.IsValid() {
+				break
+			}
+		}
+		if v.IsValid() {
+			return v
+		}
+	}
+	panic(fmt.Errorf("module %s has no property %q", ctx.ModuleName(), property))
+}
+
+func isPropertySet(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Ptr:
+		return !v.IsNil()
+	case reflect.String, reflect.Slice:
+		return v.Len() > 0
+	case reflect.Bool:
+		return v.Bool()
+	default:
+		panic(fmt.Errorf("unsupported property type %s", v.Type()))
+	}
+}
+
+// validateProperties checks the constraints declared by the module on its properties.
+func validateProperties(ctx ModuleContext, m Module) {
+	if v, ok := m.(PropertyValidator); ok {
+		for _, c := range v.PropertyConstraints() {
+			c.check(ctx, m.GetProperties())
+		}
+	}
+}
@@ -0,0 +1,141 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+var validationTests = []struct {
+	name string
+	bp   string
+	err  string
+}{
+	{
+		name: "valid",
+		bp: `
+			validated {
+				name: "foo",
+				src: "foo.txt",
+				nested: {
+					version: "12",
+					names: ["a", "b"],
+				},
+			}`,
+	},
+	{
+		name: "mutually exclusive",
+		bp: `
+			validated {
+				name: "foo",
+				src: "foo.txt",
+				prebuilt: true,
+			}`,
+		err: `cannot be set together with src`,
+	},
+	{
+		name: "required if",
+		bp: `
+			validated {
+				name: "foo",
+				nested: {
+					version: "12",
+				},
+			}`,
+		err: `requires src to be set`,
+	},
+	{
+		name: "regexp",
+		bp: `
+			validated {
+				name: "foo",
+				src: "foo.txt",
+				nested: {
+					version: "12a",
+				},
+			}`,
+		err: `"12a" is not a version code`,
+	},
+	{
+		name: "regexp list",
+		bp: `
+			validated {
+				name: "foo",
+				nested: {
+					names: ["a", "b c"],
+				},
+			}`,
+		err: `"b c" is not a name`,
+	},
+}
+
+func TestPropertyValidation(t *testing.T) {
+	buildDir, err := ioutil.TempDir("", "soong_validation_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(buildDir)
+
+	for _, test := range validationTests {
+		t.Run(test.name, func(t *testing.T) {
+			f := NewTestFixture(buildDir)
+			f.RegisterModuleType("validated", ModuleFactoryAdaptor(newValidatedModule))
+			f.AddBlueprint(test.bp)
+
+			errs := f.PrepareWithErrors()
+			if test.err == "" {
+				FailIfErrored(t, errs)
+			} else {
+				FailIfNoMatchingErrors(t, test.err, errs)
+			}
+		})
+	}
+}
+
+type validatedModule struct {
+	ModuleBase
+	properties struct {
+		Src      *string
+		Prebuilt *bool
+		Nested   struct {
+			Version *string
+			Names   []string
+		}
+	}
+}
+
+func newValidatedModule() Module {
+	m := &validatedModule{}
+	m.AddProperties(&m.properties)
+	InitAndroidModule(m)
+	return m
+}
+
+func (m *validatedModule) PropertyConstraints() []PropertyConstraint {
+	return []PropertyConstraint{
+		MutuallyExclusive("src", "prebuilt"),
+		RequiredIf("src", "nested.version"),
+		MatchesRegexp("nested.version", `^[0-9]+$`, "a version code"),
+		MatchesRegexp("nested.names", `^[a-z]+$`, "a name"),
+	}
+}
+
+func (m *validatedModule) DepsMutator(ctx BottomUpMutatorContext) {
+}
+
+func (m *validatedModule) GenerateAndroidBuildActions(ctx ModuleContext) {
+}
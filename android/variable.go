@@ -93,6 +93,8 @@ type variableProperties struct {
 		Debuggable struct {
 			Cflags   []string
 			Cppflags []string
+			Init_rc  []string
+			Required []string
 		}
 
 		// eng is true for -eng builds, and can be used to turn on additionaly heavyweight debugging
@@ -105,6 +107,18 @@ type variableProperties struct {
 		Pdk struct {
 			Enabled *bool
 		}
+
+		// arc is true for builds of Android for Chrome OS devices, which use the libraries and
+		// services of Chrome OS instead of some of their Android implementations.
+		Arc struct {
+			Cflags            []string `android:"arch_variant"`
+			Exclude_srcs      []string `android:"arch_variant"`
+			Include_dirs      []string `android:"arch_variant"`
+			Shared_libs       []string `android:"arch_variant"`
+			Static_libs       []string `android:"arch_variant"`
+			Srcs              []string `android:"arch_variant"`
+			Whole_static_libs []string `android:"arch_variant"`
+		} `android:"arch_variant"`
 	} `android:"arch_variant"`
 }
 
@@ -115,8 +129,22 @@ type productVariables struct {
 	Make_suffix *string `json:",omitempty"`
 
 	Platform_sdk_version              *int     `json:",omitempty"`
+	Platform_version_name             *string  `json:",omitempty"`
 	Platform_version_active_codenames []string `json:",omitempty"`
 	Platform_version_future_codenames []string `json:",omitempty"`
+	Platform_security_patch           *string  `json:",omitempty"`
+
+	BuildId     *string `json:",omitempty"`
+	BuildNumber *string `json:",omitempty"`
+
+	Lineage_version         *string `json:",omitempty"`
+	Lineage_display_version *string `json:",omitempty"`
+	Lineage_build_type      *string `json:",omitempty"`
+	Lineage_dist_url        *string `json:",omitempty"`
+	Lineage_dist_retention  *int    `json:",omitempty"`
+	Lineage_dist_prune      *bool   `json:",omitempty"`
+
+	Build_preset *string `json:",omitempty"`
 
 	DeviceName        *string   `json:",omitempty"`
 	DeviceArch        *string   `json:",omitempty"`
@@ -131,6 +159,18 @@ type productVariables struct {
 	DeviceSecondaryCpuVariant  *string   `json:",omitempty"`
 	DeviceSecondaryAbi         *[]string `json:",omitempty"`
 
+	NativeBridgeArch         *string   `json:",omitempty"`
+	NativeBridgeArchVariant  *string   `json:",omitempty"`
+	NativeBridgeCpuVariant   *string   `json:",omitempty"`
+	NativeBridgeAbi          *[]string `json:",omitempty"`
+	NativeBridgeRelativePath *string   `json:",omitempty"`
+
+	NativeBridgeSecondaryArch         *string   `json:",omitempty"`
+	NativeBridgeSecondaryArchVariant  *string   `json:",omitempty"`
+	NativeBridgeSecondaryCpuVariant   *string   `json:",omitempty"`
+	NativeBridgeSecondaryAbi          *[]string `json:",omitempty"`
+	NativeBridgeSecondaryRelativePath *string   `json:",omitempty"`
+
 	HostArch          *string `json:",omitempty"`
 	HostSecondaryArch *string `json:",omitempty"`
 
@@ -155,19 +195,59 @@ type productVariables struct {
 	Treble                     *bool `json:",omitempty"`
 	Uses_media_extensions      *bool `json:",omitempty"`
 	Pdk                        *bool `json:",omitempty"`
+	Arc                        *bool `json:",omitempty"`
 	Libart_img_base            *string `json:",omitempty"`
 
+	DefaultAppCertificate *string   `json:",omitempty"`
+	ExtraOtaKeys          *[]string `json:",omitempty"`
+
+	InstalledAppPackages *[]string `json:",omitempty"`
+
+	EnforcePrivappPermissions *bool `json:",omitempty"`
+
+	AppJniMultilib *string `json:",omitempty"`
+
+	StringFreeze *string `json:",omitempty"`
+
+	ResourceOverlays *[]string `json:",omitempty"`
+	AppIconPack      *string   `json:",omitempty"`
+
+	AAPTConfig          *[]string `json:",omitempty"`
+	AAPTPreferredConfig *string   `json:",omitempty"`
+	AAPTCharacteristics *string   `json:",omitempty"`
+	AAPTUtf16           *bool     `json:",omitempty"`
+	AAPTPseudoLocales   *bool     `json:",omitempty"`
+
+	BootJars         *[]string `json:",omitempty"`
+	SystemServerJars *[]string `json:",omitempty"`
+
+	AbOtaUpdater    *bool     `json:",omitempty"`
+	AbOtaPartitions *[]string `json:",omitempty"`
+	VirtualAbOta    *bool     `json:",omitempty"`
+
 	IntegerOverflowExcludePaths *[]string `json:",omitempty"`
 
-	VendorPath *string `json:",omitempty"`
+	GlobalThinLTO *bool `json:",omitempty"`
+
+	PgoAdditionalProfileDirs *[]string `json:",omitempty"`
+
+	CFIIncludePaths *[]string `json:",omitempty"`
+	CFIExcludePaths *[]string `json:",omitempty"`
+
+	VendorPath    *string `json:",omitempty"`
+	SystemExtPath *string `json:",omitempty"`
 
 	ClangTidy  *bool   `json:",omitempty"`
 	TidyChecks *string `json:",omitempty"`
 
 	NativeCoverage       *bool     `json:",omitempty"`
+	ClangCoverage        *bool     `json:",omitempty"`
 	CoveragePaths        *[]string `json:",omitempty"`
 	CoverageExcludePaths *[]string `json:",omitempty"`
 
+	JavaCoveragePaths        *[]string `json:",omitempty"`
+	JavaCoverageExcludePaths *[]string `json:",omitempty"`
+
 	DevicePrefer32BitExecutables *bool `json:",omitempty"`
 	HostPrefer32BitExecutables   *bool `json:",omitempty"`
 
@@ -183,6 +263,10 @@ type productVariables struct {
 	Override_rs_driver *string `json:",omitempty"`
 
 	DeviceKernelHeaders []string `json:",omitempty"`
+
+	NamespacesToExport []string `json:",omitempty"`
+
+	VendorVars map[string]map[string]string `json:",omitempty"`
 }
 
 func boolPtr(v bool) *bool {
@@ -261,6 +345,8 @@ func variableMutator(mctx BottomUpMutatorContext) {
 
 		a.setVariableProperties(mctx, property, variableValue, val.Interface())
 	}
+
+	a.setSoongConfigProperties(mctx)
 }
 
 func (a *ModuleBase) setVariableProperties(ctx BottomUpMutatorContext,
@@ -279,7 +365,13 @@ func (a *ModuleBase) setVariableProperties(ctx BottomUpMutatorContext,
 	}
 }
 
-func printfIntoPropertiesError(ctx BottomUpMutatorContext, prefix string,
+// propertyErrorfContext is the context that printfIntoProperties reports errors in the properties
+// of a module to.
+type propertyErrorfContext interface {
+	PropertyErrorf(property, format string, args ...interface{})
+}
+
+func printfIntoPropertiesError(ctx propertyErrorfContext, prefix string,
 	productVariablePropertyValue reflect.Value, i int, err error) {
 
 	field := productVariablePropertyValue.Type().Field(i).Name
@@ -287,7 +379,7 @@ func printfIntoPropertiesError(ctx BottomUpMutatorContext, prefix string,
 	ctx.PropertyErrorf(property, "%s", err)
 }
 
-func printfIntoProperties(ctx BottomUpMutatorContext, prefix string,
+func printfIntoProperties(ctx propertyErrorfContext, prefix string,
 	productVariablePropertyValue reflect.Value, variableValue interface{}) {
 
 	for i := 0; i < productVariablePropertyValue.NumField(); i++ {
@@ -312,7 +404,7 @@ func printfIntoProperties(ctx BottomUpMutatorContext, prefix string,
 					printfIntoPropertiesError(ctx, prefix, productVariablePropertyValue, i, err)
 				}
 			}
-		case reflect.Bool:
+		case reflect.Bool, reflect.Int, reflect.Int64:
 			// Nothing
 		case reflect.Struct:
 			printfIntoProperties(ctx, prefix, propertyValue, variableValue)
@@ -15,10 +15,17 @@
 package android
 
 import (
+	"io/ioutil"
+	"os"
 	"reflect"
 	"testing"
 )
 
+func init() {
+	RegisterSoongConfigBoolVariable("acme", "feature")
+	RegisterSoongConfigStringVariable("acme", "board")
+}
+
 type printfIntoPropertyTestCase struct {
 	in  string
 	val interface{}
@@ -122,3 +129,362 @@ func TestPrintfIntoProperty(t *testing.T) {
 		}
 	}
 }
+
+// TestProductVariablesProperties checks that each product_variables property has a product
+// variable that the variable mutator can read, otherwise the property is silently ignored.
+func TestProductVariablesProperties(t *testing.T) {
+	properties := reflect.TypeOf(zeroProductVariables.Product_variables)
+	variables := reflect.TypeOf(productVariables{})
+	for i := 0; i < properties.NumField(); i++ {
+		name := properties.Field(i).Name
+		variable, ok := variables.FieldByName(name)
+		if !ok {
+			t.Errorf("product_variables.%s has no product variable", name)
+		} else if variable.Type.Kind() != reflect.Ptr {
+			t.Errorf("product variable %s must be a pointer", name)
+		}
+	}
+}
+
+type soongConfigTestModule struct {
+	ModuleBase
+	properties struct {
+		Cflags []string `android:"arch_variant"`
+	}
+}
+
+func newSoongConfigTestModule() Module {
+	m := &soongConfigTestModule{}
+	m.AddProperties(&m.properties)
+	InitAndroidArchModule(m, DeviceSupported, MultilibFirst)
+	return m
+}
+
+func (m *soongConfigTestModule) DepsMutator(ctx BottomUpMutatorContext) {
+}
+
+func (m *soongConfigTestModule) GenerateAndroidBuildActions(ctx ModuleContext) {
+}
+
+func TestSoongConfigVariables(t *testing.T) {
+	buildDir, err := ioutil.TempDir("", "soong_config_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(buildDir)
+
+	bp := `
+		soong_config_test {
+			name: "foo",
+			cflags: ["-DFOO"],
+			soong_config_variables: {
+				acme_feature: {
+					cflags: ["-DFEATURE"],
+				},
+				acme_board: {
+					cflags: ["-DBOARD=%s"],
+				},
+			},
+		}
+	`
+
+	testCases := []struct {
+		name   string
+		vars   map[string]string
+		cflags []string
+	}{
+		{
+			name:   "unset",
+			cflags: []string{"-DFOO"},
+		},
+		{
+			name:   "set",
+			vars:   map[string]string{"feature": "true", "board": "msm8998"},
+			cflags: []string{"-DFOO", "-DFEATURE", "-DBOARD=msm8998"},
+		},
+		{
+			name:   "only true is true",
+			vars:   map[string]string{"feature": "yes", "board": ""},
+			cflags: []string{"-DFOO", "-DBOARD="},
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			f := NewTestArchFixture(buildDir)
+			f.Config.ProductVariables.VendorVars = map[string]map[string]string{"acme": test.vars}
+			f.RegisterModuleType("soong_config_test", ModuleFactoryAdaptor(newSoongConfigTestModule))
+			f.PreDepsMutators(func(ctx RegisterMutatorsContext) {
+				ctx.BottomUp("variable", variableMutator).Parallel()
+			})
+			f.AddBlueprint(bp)
+			f.Prepare(t)
+
+			m := f.ModuleForTests("foo", "android_arm64").Module().(*soongConfigTestModule)
+			if !reflect.DeepEqual(m.properties.Cflags, test.cflags) {
+				t.Errorf("expected cflags %q, got %q", test.cflags, m.properties.Cflags)
+			}
+		})
+	}
+}
+
+func TestSoongConfigVariablesErrors(t *testing.T) {
+	buildDir, err := ioutil.TempDir("", "soong_config_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(buildDir)
+
+	f := NewTestArchFixture(buildDir)
+	f.Config.ProductVariables.VendorVars = map[string]map[string]string{"acme": {"feature": "true"}}
+	f.RegisterModuleType("soong_config_test", ModuleFactoryAdaptor(newSoongConfigTestModule))
+	f.PreDepsMutators(func(ctx RegisterMutatorsContext) {
+		ctx.BottomUp("variable", variableMutator).Parallel()
+	})
+	f.AddBlueprint(`
+		soong_config_test {
+			name: "foo",
+			soong_config_variables: {
+				acme_feature: {
+					cflags: ["-DFEATURE=%s"],
+				},
+			},
+		}
+	`)
+	FailIfNoMatchingErrors(t, "unsupported type bool for %s", f.PrepareWithErrors())
+}
+
+type soongConfigDefaultableModule struct {
+	ModuleBase
+	DefaultableModuleBase
+	properties soongConfigDefaultableProperties
+}
+
+type soongConfigDefaultableProperties struct {
+	Cflags []string
+	Srcs   []string
+}
+
+func newSoongConfigDefaultableModule() Module {
+	m := &soongConfigDefaultableModule{}
+	m.AddProperties(&m.properties)
+	InitAndroidArchModule(m, DeviceSupported, MultilibFirst)
+	InitDefaultableModule(m)
+	return m
+}
+
+func (m *soongConfigDefaultableModule) DepsMutator(ctx BottomUpMutatorContext) {
+}
+
+func (m *soongConfigDefaultableModule) GenerateAndroidBuildActions(ctx ModuleContext) {
+}
+
+type soongConfigTestDefaults struct {
+	ModuleBase
+	DefaultsModuleBase
+}
+
+func newSoongConfigTestDefaults() Module {
+	m := &soongConfigTestDefaults{}
+	m.AddProperties(&soongConfigDefaultableProperties{})
+	InitDefaultsModule(m)
+	return m
+}
+
+func (m *soongConfigTestDefaults) DepsMutator(ctx BottomUpMutatorContext) {
+}
+
+func (m *soongConfigTestDefaults) GenerateAndroidBuildActions(ctx ModuleContext) {
+}
+
+// newSoongConfigDefaultsFixture returns a test fixture with the soong config variable module types
+// and a defaultable module type registered.
+func newSoongConfigDefaultsFixture(buildDir, bp string) *TestFixture {
+	f := NewTestArchFixture(buildDir)
+	f.RegisterModuleType("soong_config_bool_variable", ModuleFactoryAdaptor(SoongConfigBoolVariableFactory))
+	f.RegisterModuleType("soong_config_string_variable", ModuleFactoryAdaptor(SoongConfigStringVariableFactory))
+	f.RegisterModuleType("test_defaults", ModuleFactoryAdaptor(newSoongConfigTestDefaults))
+	f.RegisterModuleType("test_module", ModuleFactoryAdaptor(newSoongConfigDefaultableModule))
+	f.PreArchMutators(func(ctx RegisterMutatorsContext) {
+		ctx.TopDown("load_hooks", loadHookMutator).Parallel()
+	})
+	f.PreArchMutators(RegisterDefaultsPreArchMutators)
+	f.AddBlueprint(bp)
+	f.AddFiles("foo.c", "feature.c")
+	return f
+}
+
+func TestSoongConfigDefaults(t *testing.T) {
+	buildDir, err := ioutil.TempDir("", "soong_config_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(buildDir)
+
+	bp := `
+		soong_config_bool_variable {
+			name: "acme_feature",
+			config_namespace: "acme",
+			variable: "feature",
+		}
+
+		soong_config_string_variable {
+			name: "acme_board",
+			config_namespace: "acme",
+			variable: "board",
+		}
+
+		test_defaults {
+			name: "feature_defaults",
+			soong_config_variable: "acme_feature",
+			cflags: ["-DFEATURE"],
+			srcs: ["feature.c"],
+			enabled: true,
+		}
+
+		test_defaults {
+			name: "board_defaults",
+			soong_config_variable: "acme_board",
+			cflags: ["-DBOARD=%s"],
+		}
+
+		test_defaults {
+			name: "defaults",
+			cflags: ["-DDEFAULTS"],
+		}
+
+		test_module {
+			name: "foo",
+			defaults: ["feature_defaults", "board_defaults", "defaults"],
+			cflags: ["-DFOO"],
+			srcs: ["foo.c"],
+			enabled: false,
+		}
+	`
+
+	testCases := []struct {
+		name    string
+		vars    map[string]string
+		cflags  []string
+		srcs    []string
+		enabled bool
+	}{
+		{
+			name:   "unset",
+			cflags: []string{"-DDEFAULTS", "-DFOO"},
+			srcs:   []string{"foo.c"},
+		},
+		{
+			name:    "set",
+			vars:    map[string]string{"feature": "true", "board": "msm8998"},
+			cflags:  []string{"-DDEFAULTS", "-DFOO", "-DFEATURE", "-DBOARD=msm8998"},
+			srcs:    []string{"foo.c", "feature.c"},
+			enabled: true,
+		},
+		{
+			name:   "only true is true",
+			vars:   map[string]string{"feature": "yes", "board": ""},
+			cflags: []string{"-DDEFAULTS", "-DFOO", "-DBOARD="},
+			srcs:   []string{"foo.c"},
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			f := newSoongConfigDefaultsFixture(buildDir, bp)
+			f.Config.ProductVariables.VendorVars = map[string]map[string]string{"acme": test.vars}
+			f.Prepare(t)
+
+			m := f.ModuleForTests("foo", "android_arm64").Module().(*soongConfigDefaultableModule)
+			if !reflect.DeepEqual(m.properties.Cflags, test.cflags) {
+				t.Errorf("expected cflags %q, got %q", test.cflags, m.properties.Cflags)
+			}
+			if !reflect.DeepEqual(m.properties.Srcs, test.srcs) {
+				t.Errorf("expected srcs %q, got %q", test.srcs, m.properties.Srcs)
+			}
+			if m.Enabled() != test.enabled {
+				t.Errorf("expected enabled %t, got %t", test.enabled, m.Enabled())
+			}
+
+			// The defaults shared by the modules keep the unprinted properties
+			d := f.ModuleForTests("board_defaults", "").Module().(*soongConfigTestDefaults)
+			for _, props := range d.properties() {
+				if p, ok := props.(*soongConfigDefaultableProperties); ok {
+					if !reflect.DeepEqual(p.Cflags, []string{"-DBOARD=%s"}) {
+						t.Errorf("board_defaults cflags were modified: %q", p.Cflags)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestSoongConfigDefaultsErrors(t *testing.T) {
+	buildDir, err := ioutil.TempDir("", "soong_config_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(buildDir)
+
+	testCases := []struct {
+		name, bp, err string
+	}{
+		{
+			name: "unknown variable",
+			bp: `
+				test_defaults {
+					name: "defaults",
+					soong_config_variable: "acme_feature",
+				}`,
+			err: `unknown soong config variable "acme_feature"`,
+		},
+		{
+			name: "invalid namespace",
+			bp: `
+				soong_config_bool_variable {
+					name: "acme_feature",
+					config_namespace: "Acme",
+					variable: "feature",
+				}`,
+			err: `"Acme" is not a lower case identifier`,
+		},
+		{
+			name: "missing variable",
+			bp: `
+				soong_config_bool_variable {
+					name: "acme_feature",
+					config_namespace: "acme",
+				}`,
+			err: `"" is not a lower case identifier`,
+		},
+		{
+			name: "bool printed as a string",
+			bp: `
+				soong_config_bool_variable {
+					name: "acme_feature",
+					config_namespace: "acme",
+					variable: "feature",
+				}
+
+				test_defaults {
+					name: "defaults",
+					soong_config_variable: "acme_feature",
+					cflags: ["-DFEATURE=%s"],
+				}
+
+				test_module {
+					name: "foo",
+					defaults: ["defaults"],
+				}`,
+			err: "unsupported type bool for %s",
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			f := newSoongConfigDefaultsFixture(buildDir, test.bp)
+			f.Config.ProductVariables.VendorVars = map[string]map[string]string{"acme": {"feature": "true"}}
+			FailIfNoMatchingErrors(t, test.err, f.PrepareWithErrors())
+		})
+	}
+}
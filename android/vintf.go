@@ -0,0 +1,50 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+// This file installs the VINTF manifest fragments of device modules into the etc/vintf/manifest
+// directory of their partition, where the vintf_manifest module of the partition assembles them
+// into the manifest of the partition.  Make installs the fragments itself from
+// LOCAL_VINTF_FRAGMENTS when Soong is embedded in it.
+
+func (a *ModuleBase) installVintfFragments(ctx *androidModuleContext) {
+	if len(a.commonProperties.Vintf_fragments) == 0 {
+		return
+	}
+	if !ctx.Device() {
+		ctx.PropertyErrorf("vintf_fragments", "only supported by device modules")
+		return
+	}
+	// The fragments don't depend on the architecture, install them once from the first variant,
+	// which is also the only one of modules that are only built for the secondary architecture
+	if !ctx.TargetPrimary() {
+		return
+	}
+
+	dir := PathForModuleInstall(ctx, "etc", "vintf", "manifest")
+	for _, fragment := range PathsForModuleSrc(ctx, a.commonProperties.Vintf_fragments) {
+		if fragment.Ext() != ".xml" {
+			ctx.PropertyErrorf("vintf_fragments", "%q is not an XML file", fragment.Rel())
+			continue
+		}
+		installPath := ctx.InstallFile(dir, fragment)
+		a.vintfFragments = append(a.vintfFragments, PackagingSpec{InstallPath: installPath, SrcPath: fragment})
+	}
+}
+
+// VintfFragments returns the VINTF manifest fragments installed by the module.
+func (a *ModuleBase) VintfFragments() []PackagingSpec {
+	return a.vintfFragments
+}
@@ -0,0 +1,175 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/blueprint"
+)
+
+// This file implements the visibility property, which restricts the directories that the modules
+// depending on a module can be in.  The rules are in the format of Bazel visibility labels:
+//   "//visibility:public"           any module can depend on the module, the default
+//   "//visibility:private"          only modules in the same directory can depend on the module
+//   "//some/dir:__pkg__"            modules in some/dir can depend on the module
+//   "//some/dir:__subpackages__"    modules in some/dir and its subdirectories can depend on it
+//   ":__subpackages__"              modules in the directory of the module and its subdirectories
+// The modules in the directory of the module can always depend on it.  The rules of a defaults
+// module are applied to the modules that use it, and relative rules like ":__subpackages__" or
+// "//visibility:private" in a defaults module are resolved against the directory of the module that
+// uses the defaults, not the directory of the defaults module as Bazel would resolve them.
+
+const (
+	visibilityPublic      = "//visibility:public"
+	visibilityPrivate     = "//visibility:private"
+	visibilityPackage     = "__pkg__"
+	visibilitySubpackages = "__subpackages__"
+)
+
+func RegisterVisibilityPreArchMutators(ctx RegisterMutatorsContext) {
+	ctx.BottomUp("visibility_rules", visibilityRulesMutator).Parallel()
+}
+
+func RegisterVisibilityPostDepsMutators(ctx RegisterMutatorsContext) {
+	ctx.BottomUp("visibility", visibilityMutator).Parallel()
+}
+
+// parseVisibility checks the visibility rules of a module in dir, and returns them with the
+// relative and private rules replaced by rules for the directories, or nil if the module is
+// visible to all modules.
+func parseVisibility(dir string, rules []string) ([]string, []error) {
+	var parsed []string
+	var errs []error
+	public := false
+	for _, rule := range rules {
+		switch rule {
+		case visibilityPublic, visibilityPrivate:
+			if len(rules) > 1 {
+				errs = append(errs, fmt.Errorf("%q may not be combined with other visibility rules", rule))
+				continue
+			}
+			public = rule == visibilityPublic
+			continue
+		}
+
+		if strings.HasPrefix(rule, modulePrefix) {
+			rule = namespacePrefix + dir + rule
+		}
+		if !strings.HasPrefix(rule, namespacePrefix) {
+			errs = append(errs, fmt.Errorf("invalid visibility rule %q, must start with // or :", rule))
+			continue
+		}
+		pkg, name, ok := splitVisibilityRule(rule)
+		if !ok || pkg == "visibility" {
+			errs = append(errs, fmt.Errorf("invalid visibility rule %q, must be //visibility:public, "+
+				"//visibility:private, //<dir>:__pkg__ or //<dir>:__subpackages__", rule))
+			continue
+		}
+		if pkg != filepath.Clean(pkg) || pkg == ".." || strings.HasPrefix(pkg, "../") ||
+			filepath.IsAbs(pkg) {
+			errs = append(errs, fmt.Errorf("invalid visibility rule %q, %q is not a clean relative path",
+				rule, pkg))
+			continue
+		}
+		parsed = append(parsed, namespacePrefix+pkg+modulePrefix+name)
+	}
+
+	if len(errs) > 0 || len(rules) == 0 || public {
+		return nil, errs
+	}
+
+	// The modules in the directory of the module can always depend on it
+	if parsed == nil || !visibleTo(parsed, dir) {
+		parsed = append(parsed, namespacePrefix+dir+modulePrefix+visibilityPackage)
+	}
+	return parsed, nil
+}
+
+// splitVisibilityRule splits a "//dir:name" rule into the directory and the name, which must be
+// __pkg__ or __subpackages__.
+func splitVisibilityRule(rule string) (pkg, name string, ok bool) {
+	components := strings.Split(strings.TrimPrefix(rule, namespacePrefix), modulePrefix)
+	if len(components) != 2 || components[0] == "" {
+		return "", "", false
+	}
+	pkg, name = components[0], components[1]
+	if name != visibilityPackage && name != visibilitySubpackages {
+		return "", "", false
+	}
+	return pkg, name, true
+}
+
+// visibleTo returns true if the parsed visibility rules allow modules in dir to depend on the
+// module.
+func visibleTo(rules []string, dir string) bool {
+	if rules == nil {
+		return true
+	}
+	for _, rule := range rules {
+		pkg, name, _ := splitVisibilityRule(rule)
+		switch name {
+		case visibilityPackage:
+			if dir == pkg {
+				return true
+			}
+		case visibilitySubpackages:
+			if dir == pkg || pkg == "." || strings.HasPrefix(dir, pkg+"/") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// visibilityRulesMutator checks the visibility property once the defaults have been applied, and
+// stores the parsed rules so that they are copied into the variants of the module.
+func visibilityRulesMutator(ctx BottomUpMutatorContext) {
+	m, ok := ctx.Module().(Module)
+	if !ok {
+		return
+	}
+	props := &m.base().commonProperties
+	rules, errs := parseVisibility(ctx.ModuleDir(), props.Visibility)
+	for _, err := range errs {
+		ctx.PropertyErrorf("visibility", "%s", err.Error())
+	}
+	props.Visibility_rules = rules
+}
+
+// visibilityMutator reports the dependencies of a module on modules that are not visible to it.
+func visibilityMutator(ctx BottomUpMutatorContext) {
+	if _, ok := ctx.Module().(Module); !ok {
+		return
+	}
+	dir := ctx.ModuleDir()
+	ctx.VisitDirectDeps(func(dep blueprint.Module) {
+		// The visibility of a defaults module is applied to the modules that use it instead
+		if ctx.OtherModuleDependencyTag(dep) == DefaultsDepTag {
+			return
+		}
+		m, ok := dep.(Module)
+		if !ok {
+			return
+		}
+		if !visibleTo(m.base().commonProperties.Visibility_rules, dir) {
+			ctx.ModuleErrorf("depends on %q which is not visible to this module\n"+
+				"You may need to add %q to its visibility", ctx.OtherModuleName(dep),
+				namespacePrefix+dir+modulePrefix+visibilityPackage)
+		}
+	})
+}
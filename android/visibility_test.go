@@ -0,0 +1,343 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+var parseVisibilityTestCases = []struct {
+	name   string
+	rules  []string
+	parsed []string
+	errors int
+}{
+	{
+		name:   "default",
+		rules:  nil,
+		parsed: nil,
+	},
+	{
+		name:   "public",
+		rules:  []string{"//visibility:public"},
+		parsed: nil,
+	},
+	{
+		name:   "private",
+		rules:  []string{"//visibility:private"},
+		parsed: []string{"//vendor/lineage/libfoo:__pkg__"},
+	},
+	{
+		name:   "subpackages",
+		rules:  []string{":__subpackages__", "//device/lineage:__pkg__"},
+		parsed: []string{"//vendor/lineage/libfoo:__subpackages__", "//device/lineage:__pkg__"},
+	},
+	{
+		name:   "other package",
+		rules:  []string{"//hardware/lineage:__subpackages__"},
+		parsed: []string{"//hardware/lineage:__subpackages__", "//vendor/lineage/libfoo:__pkg__"},
+	},
+	{
+		name:   "public and private",
+		rules:  []string{"//visibility:public", "//device/lineage:__pkg__"},
+		errors: 1,
+	},
+	{
+		name:   "unknown visibility",
+		rules:  []string{"//visibility:vendor"},
+		errors: 1,
+	},
+	{
+		name:   "module name",
+		rules:  []string{"//device/lineage:libbar"},
+		errors: 1,
+	},
+	{
+		name:   "not absolute",
+		rules:  []string{"device/lineage:__pkg__", "//../lineage:__pkg__", "//device/lineage/:__pkg__"},
+		errors: 3,
+	},
+}
+
+func TestParseVisibility(t *testing.T) {
+	for _, testCase := range parseVisibilityTestCases {
+		parsed, errs := parseVisibility("vendor/lineage/libfoo", testCase.rules)
+		if len(errs) != testCase.errors {
+			t.Errorf("%s: expected %d errors, got %q", testCase.name, testCase.errors, errs)
+			continue
+		}
+		if !reflect.DeepEqual(parsed, testCase.parsed) {
+			t.Errorf("%s: expected %q, got %q", testCase.name, testCase.parsed, parsed)
+		}
+	}
+}
+
+var visibleToTestCases = []struct {
+	rules   []string
+	dir     string
+	visible bool
+}{
+	{nil, "frameworks/base", true},
+	{[]string{"//vendor/lineage:__pkg__"}, "vendor/lineage", true},
+	{[]string{"//vendor/lineage:__pkg__"}, "vendor/lineage/libfoo", false},
+	{[]string{"//vendor/lineage:__subpackages__"}, "vendor/lineage", true},
+	{[]string{"//vendor/lineage:__subpackages__"}, "vendor/lineage/libfoo", true},
+	{[]string{"//vendor/lineage:__subpackages__"}, "vendor/lineage-priv", false},
+	{[]string{"//vendor/lineage:__pkg__", "//device/lineage:__subpackages__"}, "device/lineage/foo", true},
+}
+
+func TestVisibleTo(t *testing.T) {
+	for _, testCase := range visibleToTestCases {
+		if visible := visibleTo(testCase.rules, testCase.dir); visible != testCase.visible {
+			t.Errorf("%q visible to %s: expected %v, got %v", testCase.rules, testCase.dir,
+				testCase.visible, visible)
+		}
+	}
+}
+
+type visibilityTestModule struct {
+	ModuleBase
+	DefaultableModuleBase
+	properties struct {
+		Deps []string
+	}
+}
+
+func newVisibilityTestModule() Module {
+	m := &visibilityTestModule{}
+	m.AddProperties(&m.properties)
+	InitAndroidArchModule(m, DeviceSupported, MultilibFirst)
+	InitDefaultableModule(m)
+	return m
+}
+
+func (m *visibilityTestModule) DepsMutator(ctx BottomUpMutatorContext) {
+	ctx.AddDependency(ctx.Module(), nil, m.properties.Deps...)
+}
+
+func (m *visibilityTestModule) GenerateAndroidBuildActions(ctx ModuleContext) {
+}
+
+type visibilityTestDefaults struct {
+	ModuleBase
+	DefaultsModuleBase
+}
+
+func newVisibilityTestDefaults() Module {
+	m := &visibilityTestDefaults{}
+	InitDefaultsModule(m)
+	return m
+}
+
+func (m *visibilityTestDefaults) DepsMutator(ctx BottomUpMutatorContext) {
+}
+
+func (m *visibilityTestDefaults) GenerateAndroidBuildActions(ctx ModuleContext) {
+}
+
+// newVisibilityFixture returns a test fixture with the Android.bp files in bps, which are listed
+// in the subdirs of the top level Android.bp file.
+func newVisibilityFixture(buildDir string, bps map[string]string) *TestFixture {
+	f := NewTestArchFixture(buildDir)
+	f.RegisterModuleType("test_module", ModuleFactoryAdaptor(newVisibilityTestModule))
+	f.RegisterModuleType("test_defaults", ModuleFactoryAdaptor(newVisibilityTestDefaults))
+	f.PreArchMutators(RegisterDefaultsPreArchMutators)
+	f.PreArchMutators(RegisterVisibilityPreArchMutators)
+	f.PostDepsMutators(RegisterVisibilityPostDepsMutators)
+
+	var dirs []string
+	files := make(map[string][]byte)
+	for dir, bp := range bps {
+		dirs = append(dirs, fmt.Sprintf("%q", dir))
+		files[filepath.Join(dir, "Android.bp")] = []byte(bp)
+	}
+	sort.Strings(dirs)
+	f.AddBlueprint("subdirs = [" + strings.Join(dirs, ", ") + "]\n")
+	f.AddFileContents(files)
+	return f
+}
+
+var visibilityFixtureTestCases = []struct {
+	name string
+	bps  map[string]string
+	err  string
+}{
+	{
+		name: "private",
+		bps: map[string]string{
+			"vendor/lineage/libfoo": `
+				test_module {
+					name: "libfoo",
+					visibility: ["//visibility:private"],
+				}
+
+				test_module {
+					name: "libfoo_test",
+					deps: ["libfoo"],
+				}`,
+			"device/lineage/bar": `
+				test_module {
+					name: "bar",
+					deps: ["libfoo"],
+				}`,
+		},
+		err: `depends on "libfoo" which is not visible to this module`,
+	},
+	{
+		name: "package",
+		bps: map[string]string{
+			"vendor/lineage/libfoo": `
+				test_module {
+					name: "libfoo",
+					visibility: ["//device/lineage/bar:__pkg__"],
+				}`,
+			"device/lineage/bar": `
+				test_module {
+					name: "bar",
+					deps: ["libfoo"],
+				}`,
+		},
+	},
+	{
+		name: "subpackages",
+		bps: map[string]string{
+			"vendor/lineage/libfoo": `
+				test_module {
+					name: "libfoo",
+					visibility: [":__subpackages__", "//device/lineage:__subpackages__"],
+				}`,
+			"vendor/lineage/libfoo/tests": `
+				test_module {
+					name: "libfoo_test",
+					deps: ["libfoo"],
+				}`,
+			"device/lineage/bar/common": `
+				test_module {
+					name: "bar",
+					deps: ["libfoo"],
+				}`,
+		},
+	},
+	{
+		name: "subpackages sibling",
+		bps: map[string]string{
+			"vendor/lineage/libfoo": `
+				test_module {
+					name: "libfoo",
+					visibility: [":__subpackages__"],
+				}`,
+			"vendor/lineage/libfoo_ext": `
+				test_module {
+					name: "libfoo_ext",
+					deps: ["libfoo"],
+				}`,
+		},
+		err: `depends on "libfoo" which is not visible to this module`,
+	},
+	{
+		name: "defaults",
+		bps: map[string]string{
+			"build/defaults": `
+				test_defaults {
+					name: "private_defaults",
+					visibility: [":__subpackages__"],
+				}`,
+			"vendor/lineage/libfoo": `
+				test_module {
+					name: "libfoo",
+					defaults: ["private_defaults"],
+				}`,
+			"vendor/lineage/libfoo/tests": `
+				test_module {
+					name: "libfoo_test",
+					deps: ["libfoo"],
+				}`,
+		},
+	},
+	{
+		name: "defaults resolved against the module",
+		bps: map[string]string{
+			"build/defaults": `
+				test_defaults {
+					name: "private_defaults",
+					visibility: [":__subpackages__"],
+				}`,
+			"build/defaults/tests": `
+				test_module {
+					name: "defaults_test",
+					deps: ["libfoo"],
+				}`,
+			"vendor/lineage/libfoo": `
+				test_module {
+					name: "libfoo",
+					defaults: ["private_defaults"],
+				}`,
+		},
+		err: `depends on "libfoo" which is not visible to this module`,
+	},
+	{
+		name: "defaults combined",
+		bps: map[string]string{
+			"build/defaults": `
+				test_defaults {
+					name: "bar_defaults",
+					visibility: ["//device/lineage/bar:__pkg__"],
+				}`,
+			"vendor/lineage/libfoo": `
+				test_module {
+					name: "libfoo",
+					defaults: ["bar_defaults"],
+					visibility: [":__subpackages__"],
+				}`,
+			"vendor/lineage/libfoo/tests": `
+				test_module {
+					name: "libfoo_test",
+					deps: ["libfoo"],
+				}`,
+			"device/lineage/bar": `
+				test_module {
+					name: "bar",
+					deps: ["libfoo"],
+				}`,
+		},
+	},
+}
+
+func TestVisibilityMutator(t *testing.T) {
+	buildDir, err := ioutil.TempDir("", "soong_visibility_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(buildDir)
+
+	for _, testCase := range visibilityFixtureTestCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			f := newVisibilityFixture(buildDir, testCase.bps)
+			errs := f.PrepareWithErrors()
+			if testCase.err == "" {
+				FailIfErrored(t, errs)
+			} else {
+				FailIfNoMatchingErrors(t, testCase.err, errs)
+			}
+		})
+	}
+}
@@ -30,6 +30,7 @@ var rewriteProperties = map[string](func(variableAssignmentContext) error){
 	"LOCAL_EXPORT_C_INCLUDE_DIRS": exportIncludeDirs,
 	"LOCAL_LDFLAGS":               ldflags,
 	"LOCAL_MODULE_CLASS":          prebuiltClass,
+	"LOCAL_DEX_PREOPT":            dexpreopt,
 	"LOCAL_MODULE_STEM":           stem,
 	"LOCAL_MODULE_HOST_OS":        hostOs,
 	"LOCAL_SRC_FILES":             srcFiles,
@@ -72,6 +73,7 @@ func init() {
 			"LOCAL_PROTOC_OPTIMIZE_TYPE":    "proto.type",
 			"LOCAL_MODULE_OWNER":            "owner",
 			"LOCAL_RENDERSCRIPT_TARGET_API": "renderscript.target_api",
+			"LOCAL_SANITIZE_BLACKLIST":      "sanitize.blocklist",
 		})
 	addStandardProperties(bpparser.ListType,
 		map[string]string{
@@ -97,6 +99,7 @@ func init() {
 			"LOCAL_EXPORT_STATIC_LIBRARY_HEADERS": "export_static_lib_headers",
 			"LOCAL_INIT_RC":                       "init_rc",
 			"LOCAL_TIDY_FLAGS":                    "tidy_flags",
+			"LOCAL_OVERRIDES_PACKAGES":            "overrides",
 			// TODO: This is comma-separated, not space-separated
 			"LOCAL_TIDY_CHECKS":           "tidy_checks",
 			"LOCAL_RENDERSCRIPT_INCLUDES": "renderscript.include_dirs",
@@ -110,7 +113,11 @@ func init() {
 			"LOCAL_AIDL_INCLUDES":         "aidl.include_dirs",
 			"LOCAL_AAPT_FLAGS":            "aaptflags",
 			"LOCAL_PACKAGE_SPLITS":        "package_splits",
+			"LOCAL_JNI_SHARED_LIBRARIES":  "jni_libs",
 			"LOCAL_COMPATIBILITY_SUITE":   "test_suites",
+
+			"LOCAL_ADDITIONAL_CERTIFICATES": "additional_certificates",
+			"LOCAL_VINTF_FRAGMENTS":         "vintf_fragments",
 		})
 	addStandardProperties(bpparser.BoolType,
 		map[string]string{
@@ -127,8 +134,11 @@ func init() {
 			"LOCAL_TIDY":                    "tidy",
 			"LOCAL_PROPRIETARY_MODULE":      "proprietary",
 			"LOCAL_VENDOR_MODULE":           "vendor",
+			"LOCAL_SYSTEM_EXT_MODULE":       "system_ext_specific",
 
-			"LOCAL_EXPORT_PACKAGE_RESOURCES": "export_package_resources",
+			"LOCAL_EXPORT_PACKAGE_RESOURCES":   "export_package_resources",
+			"LOCAL_AAPT_INCLUDE_ALL_RESOURCES": "aapt_include_all_resources",
+			"LOCAL_PRIVILEGED_MODULE":          "privileged",
 		})
 }
 
@@ -433,7 +443,7 @@ func sanitize(sub string) func(ctx variableAssignmentContext) error {
 				ctx.file.errorf(ctx.mkvalue, "unsupported sanitize expression")
 			case *bpparser.String:
 				switch v.Value {
-				case "never", "address", "coverage", "thread", "undefined", "cfi":
+				case "never", "address", "coverage", "thread", "undefined", "cfi", "scs":
 					bpTrue := &bpparser.Bool{
 						Value: true,
 					}
@@ -471,6 +481,23 @@ func prebuiltClass(ctx variableAssignmentContext) error {
 	return nil
 }
 
+// dexpreopt translates LOCAL_DEX_PREOPT := false to dex_preopt.enabled: false, which Soong passes
+// back to Make as LOCAL_DEX_PREOPT := false for the apps it exports.  Make dexpreopts the other
+// apps, and Soong never strips the dex files from the apk, so true and nostripping are the default.
+func dexpreopt(ctx variableAssignmentContext) error {
+	switch value := strings.TrimSpace(ctx.mkvalue.Value(nil)); value {
+	case "false":
+		falseValue := &bpparser.Bool{
+			Value: false,
+		}
+		return setVariable(ctx.file, false, ctx.prefix, "dex_preopt.enabled", falseValue, true)
+	case "true", "nostripping":
+		return nil
+	default:
+		return fmt.Errorf("unsupported LOCAL_DEX_PREOPT value %q", value)
+	}
+}
+
 func ldflags(ctx variableAssignmentContext) error {
 	val, err := makeVariableToBlueprint(ctx.file, ctx.mkvalue, bpparser.ListType)
 	if err != nil {
@@ -681,6 +708,36 @@ var prebuiltTypes = map[string]string{
 
 var soongModuleTypes = map[string]bool{}
 
+// moduleTypeProperties lists the properties that are only supported by some module types, because
+// Make only reads the variables they are translated from for those module types.
+var moduleTypeProperties = map[string][]string{
+	// LOCAL_OVERRIDES_PACKAGES
+	"overrides": {"android_app"},
+}
+
+// removeUnsupportedProperties removes the properties that the module type doesn't support from a
+// module, as Make ignores the variables they were translated from.
+func removeUnsupportedProperties(module *bpparser.Module, t string) {
+	writeIndex := 0
+	for _, prop := range module.Properties {
+		if types, ok := moduleTypeProperties[prop.Name]; ok && !inList(t, types) {
+			continue
+		}
+		module.Properties[writeIndex] = prop
+		writeIndex++
+	}
+	module.Properties = module.Properties[:writeIndex]
+}
+
+func inList(s string, list []string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
+
 func androidScope() mkparser.Scope {
 	globalScope := mkparser.NewScope(nil)
 	globalScope.Set("CLEAR_VARS", clear_vars)
@@ -293,6 +293,7 @@ func handleModuleConditionals(file *bpFile, directive *mkparser.Directive, conds
 }
 
 func makeModule(file *bpFile, t string) {
+	removeUnsupportedProperties(file.module, t)
 	file.module.Type = t
 	file.module.TypePos = file.module.LBracePos
 	file.module.RBracePos = file.bpPos
@@ -317,6 +317,23 @@ cc_library_shared {
 	recover: ["shift-exponent"],
     },
 }
+`,
+	},
+	{
+		desc: "LOCAL_SANITIZE_BLACKLIST",
+		in: `
+include $(CLEAR_VARS)
+LOCAL_SANITIZE := cfi
+LOCAL_SANITIZE_BLACKLIST := blocklist.txt
+include $(BUILD_SHARED_LIBRARY)
+`,
+		expected: `
+cc_library_shared {
+    sanitize: {
+	cfi: true,
+	blocklist: "blocklist.txt",
+    },
+}
 `,
 	},
 	{
@@ -372,6 +389,79 @@ include $(BUILD_SHARED_LIBRARY)
 cc_library_shared {
 	tags: ["debug"],
 }
+`,
+	},
+	{
+		desc: "android_app",
+		in: `
+include $(CLEAR_VARS)
+LOCAL_PACKAGE_NAME := Foo
+LOCAL_CERTIFICATE := platform
+LOCAL_ADDITIONAL_CERTIFICATES := vendor/lineage/certs/foo
+LOCAL_PRIVILEGED_MODULE := true
+LOCAL_OVERRIDES_PACKAGES := Bar Baz
+LOCAL_DEX_PREOPT := false
+include $(BUILD_PACKAGE)
+`,
+		expected: `
+android_app {
+	name: "Foo",
+	certificate: "platform",
+	additional_certificates: ["vendor/lineage/certs/foo"],
+	privileged: true,
+	overrides: [
+		"Bar",
+		"Baz",
+	],
+	dex_preopt: {
+		enabled: false,
+	},
+}
+`,
+	},
+	{
+		desc: "LOCAL_OVERRIDES_PACKAGES outside of apps",
+		in: `
+include $(CLEAR_VARS)
+LOCAL_MODULE := foo
+LOCAL_OVERRIDES_PACKAGES := bar
+include $(BUILD_EXECUTABLE)
+`,
+		expected: `
+cc_binary {
+	name: "foo",
+}
+`,
+	},
+	{
+		desc: "LOCAL_DEX_PREOPT := nostripping",
+		in: `
+include $(CLEAR_VARS)
+LOCAL_PACKAGE_NAME := Foo
+LOCAL_DEX_PREOPT := nostripping
+include $(BUILD_PACKAGE)
+`,
+		expected: `
+android_app {
+	name: "Foo",
+}
+`,
+	},
+	{
+		desc: "vendor cc_library_shared",
+		in: `
+include $(CLEAR_VARS)
+LOCAL_MODULE := libfoo
+LOCAL_SYSTEM_EXT_MODULE := true
+LOCAL_VINTF_FRAGMENTS := foo.xml
+include $(BUILD_SHARED_LIBRARY)
+`,
+		expected: `
+cc_library_shared {
+	name: "libfoo",
+	system_ext_specific: true,
+	vintf_fragments: ["foo.xml"],
+}
 `,
 	},
 }
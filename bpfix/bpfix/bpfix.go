@@ -19,6 +19,9 @@ package bpfix
 import (
 	"bytes"
 	"fmt"
+	"path"
+	"strings"
+
 	"github.com/google/blueprint/parser"
 )
 
@@ -27,6 +30,8 @@ import (
 type FixRequest struct {
 	simplifyKnownRedundantVariables bool
 	removeEmptyLists                bool
+	renameDeprecatedProperties      bool
+	rewriteCertificatePaths         bool
 }
 
 func NewFixRequest() FixRequest {
@@ -37,6 +42,8 @@ func (r FixRequest) AddAll() (result FixRequest) {
 	result = r
 	result.simplifyKnownRedundantVariables = true
 	result.removeEmptyLists = true
+	result.renameDeprecatedProperties = true
+	result.rewriteCertificatePaths = true
 	return result
 }
 
@@ -95,6 +102,18 @@ func fixTreeOnce(tree *parser.File, config FixRequest) (fixed *parser.File, err
 			return nil, err
 		}
 	}
+	if config.renameDeprecatedProperties {
+		tree, err = renameDeprecatedProperties(tree)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if config.rewriteCertificatePaths {
+		tree, err = rewriteCertificatePaths(tree)
+		if err != nil {
+			return nil, err
+		}
+	}
 	return tree, err
 }
 
@@ -183,3 +202,79 @@ func removePropertiesHavingTheirDefaultValues(tree *parser.File) (fixed *parser.
 	}
 	return tree, nil
 }
+
+// deprecatedProperties lists the properties that were renamed, by the name of the property that
+// contains them.  They are also renamed inside arch, target and multilib properties.
+var deprecatedProperties = []struct{ parent, oldName, newName string }{
+	{"sanitize", "blacklist", "blocklist"},
+}
+
+// renameDeprecatedProperties renames the deprecated properties of each module to their
+// replacements, unless the replacement is already set.  The values and comments are kept.
+func renameDeprecatedProperties(tree *parser.File) (fixed *parser.File, err error) {
+	for _, def := range tree.Defs {
+		mod, ok := def.(*parser.Module)
+		if !ok {
+			continue
+		}
+		renameDeprecatedPropertiesInMap(mod.Properties)
+	}
+	return tree, nil
+}
+
+func renameDeprecatedPropertiesInMap(properties []*parser.Property) {
+	for _, prop := range properties {
+		m, ok := prop.Value.(*parser.Map)
+		if !ok {
+			continue
+		}
+		for _, deprecated := range deprecatedProperties {
+			if prop.Name != deprecated.parent {
+				continue
+			}
+			old := findProperty(m.Properties, deprecated.oldName)
+			if old != nil && findProperty(m.Properties, deprecated.newName) == nil {
+				old.Name = deprecated.newName
+			}
+		}
+		renameDeprecatedPropertiesInMap(m.Properties)
+	}
+}
+
+func findProperty(properties []*parser.Property, name string) *parser.Property {
+	for _, prop := range properties {
+		if prop.Name == name {
+			return prop
+		}
+	}
+	return nil
+}
+
+// defaultCertificateDir is the directory that certificates named without a directory are found
+// in, unless the product sets a different default certificate.
+const defaultCertificateDir = "build/target/product/security"
+
+// rewriteCertificatePaths replaces the paths to certificates in the default certificate directory
+// with the names of the certificates, so that the certificates of the product are used when it
+// signs with release keys.
+func rewriteCertificatePaths(tree *parser.File) (fixed *parser.File, err error) {
+	for _, def := range tree.Defs {
+		mod, ok := def.(*parser.Module)
+		if !ok {
+			continue
+		}
+		prop := findProperty(mod.Properties, "certificate")
+		if prop == nil {
+			continue
+		}
+		certificate, ok := prop.Value.(*parser.String)
+		if !ok {
+			continue
+		}
+		dir, name := path.Split(strings.TrimSuffix(certificate.Value, ".x509.pem"))
+		if path.Clean(dir) == defaultCertificateDir && name != "" {
+			certificate.Value = name
+		}
+	}
+	return tree, nil
+}
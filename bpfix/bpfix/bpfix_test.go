@@ -112,3 +112,115 @@ func TestSimplifyKnownVariablesDuplicatingEachOther(t *testing.T) {
 	implFilterListTest(t, []string{}, []string{"include"}, []string{})
 	implFilterListTest(t, []string{}, []string{}, []string{})
 }
+
+func runPass(t *testing.T, in, out string, innerTest func(*parser.File) (*parser.File, error)) {
+	expected, errs := parser.Parse("<testcase>", strings.NewReader(out), parser.NewScope(nil))
+	if len(errs) > 0 {
+		t.Fatalf("failed to parse expected output: %q", errs)
+	}
+	expectedText, err := parser.Print(expected)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tree, errs := parser.Parse("<testcase>", strings.NewReader(in), parser.NewScope(nil))
+	if len(errs) > 0 {
+		t.Fatalf("failed to parse input: %q", errs)
+	}
+	fixed, err := innerTest(tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fixedText, err := parser.Print(fixed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(fixedText) != string(expectedText) {
+		t.Errorf("output didn't match:\ninput:\n%s\n\nexpected:\n%s\ngot:\n%s", in, expectedText, fixedText)
+	}
+}
+
+func TestRenameDeprecatedProperties(t *testing.T) {
+	runPass(t, `
+		cc_library {
+			name: "libfoo",
+			sanitize: {
+				// functions that are not instrumented
+				blacklist: "blacklist.txt",
+			},
+			arch: {
+				arm: {
+					sanitize: {
+						blacklist: "blacklist_arm.txt",
+					},
+				},
+			},
+		}
+
+		cc_library {
+			name: "libbar",
+			sanitize: {
+				blocklist: "blocklist.txt",
+				blacklist: "blacklist.txt",
+			},
+		}
+	`, `
+		cc_library {
+			name: "libfoo",
+			sanitize: {
+				// functions that are not instrumented
+				blocklist: "blacklist.txt",
+			},
+			arch: {
+				arm: {
+					sanitize: {
+						blocklist: "blacklist_arm.txt",
+					},
+				},
+			},
+		}
+
+		cc_library {
+			name: "libbar",
+			sanitize: {
+				blocklist: "blocklist.txt",
+				blacklist: "blacklist.txt",
+			},
+		}
+	`, renameDeprecatedProperties)
+}
+
+func TestRewriteCertificatePaths(t *testing.T) {
+	runPass(t, `
+		android_app {
+			name: "Foo",
+			certificate: "build/target/product/security/platform",
+		}
+
+		android_app {
+			name: "Bar",
+			certificate: "build/target/product/security/shared.x509.pem",
+		}
+
+		android_app {
+			name: "Baz",
+			certificate: "vendor/lineage/certs/baz",
+		}
+	`, `
+		android_app {
+			name: "Foo",
+			certificate: "platform",
+		}
+
+		android_app {
+			name: "Bar",
+			certificate: "shared",
+		}
+
+		android_app {
+			name: "Baz",
+			certificate: "vendor/lineage/certs/baz",
+		}
+	`, rewriteCertificatePaths)
+}
@@ -24,7 +24,10 @@ import (
 )
 
 var (
-	vendorSuffix = ".vendor"
+	vendorSuffix   = ".vendor"
+	sdkSuffix      = ".sdk"
+	recoverySuffix = ".recovery"
+	ramdiskSuffix  = ".ramdisk"
 )
 
 type AndroidMkContext interface {
@@ -95,6 +98,27 @@ func (c *Module) AndroidMk() android.AndroidMkData {
 		ret.SubName += vendorSuffix
 	}
 
+	if c.Properties.InRecovery {
+		ret.SubName += recoverySuffix
+	} else if c.Properties.InRamdisk {
+		ret.SubName += ramdiskSuffix
+	}
+
+	if c.Properties.SdkVariant {
+		// The platform variant is installed, the variant built against the NDK is only packaged
+		// into apps
+		ret.SubName += sdkSuffix
+		ret.Extra = append(ret.Extra, func(w io.Writer, outputFile android.Path) {
+			fmt.Fprintln(w, "LOCAL_UNINSTALLABLE_MODULE := true")
+		})
+	}
+
+	if c.Target().NativeBridge && ret.Custom == nil {
+		// The native bridge variants are not linked against by Make modules, they are only
+		// installed to the directory of the translated architecture
+		ret.Class = "ETC"
+	}
+
 	return ret
 }
 
@@ -231,6 +255,9 @@ func (benchmark *benchmarkDecorator) AndroidMk(ctx AndroidMkContext, ret *androi
 			fmt.Fprintln(w, "LOCAL_COMPATIBILITY_SUITE :=",
 				strings.Join(benchmark.Properties.Test_suites, " "))
 		}
+		if benchmark.testConfig != nil {
+			fmt.Fprintln(w, "LOCAL_FULL_TEST_CONFIG :=", benchmark.testConfig.String())
+		}
 	})
 
 	androidMkWriteTestData(benchmark.data, ctx, ret)
@@ -248,11 +275,41 @@ func (test *testBinary) AndroidMk(ctx AndroidMkContext, ret *android.AndroidMkDa
 			fmt.Fprintln(w, "LOCAL_COMPATIBILITY_SUITE :=",
 				strings.Join(test.Properties.Test_suites, " "))
 		}
+		if test.testConfig != nil {
+			fmt.Fprintln(w, "LOCAL_FULL_TEST_CONFIG :=", test.testConfig.String())
+		}
 	})
 
 	androidMkWriteTestData(test.data, ctx, ret)
 }
 
+func (fuzz *fuzzBinary) AndroidMk(ctx AndroidMkContext, ret *android.AndroidMkData) {
+	ctx.subAndroidMk(ret, fuzz.binaryDecorator)
+
+	var deps []string
+	var commands []string
+	installPath := fuzz.binaryDecorator.baseInstaller.path.RelPathString()
+	installDir := "$(OUT_DIR)/" + filepath.Dir(installPath)
+	if fuzz.corpus != nil {
+		deps = append(deps, fuzz.corpus.String())
+		commands = append(commands, "cp -f "+fuzz.corpus.String()+" "+installDir+"/")
+	}
+	if fuzz.dictionary != nil {
+		deps = append(deps, fuzz.dictionary.String())
+		commands = append(commands, "cp -f "+fuzz.dictionary.String()+" "+
+			installDir+"/"+filepath.Base(installPath)+".dict")
+	}
+
+	if len(commands) > 0 {
+		// The seed corpus and the dictionary are installed next to the fuzz target, where
+		// libFuzzer and the fuzzing infrastructure look for them.
+		ret.Extra = append(ret.Extra, func(w io.Writer, outputFile android.Path) {
+			fmt.Fprintln(w, "LOCAL_ADDITIONAL_DEPENDENCIES :=", strings.Join(deps, " "))
+			fmt.Fprintln(w, "LOCAL_POST_INSTALL_CMD :=", strings.Join(commands, " && "))
+		})
+	}
+}
+
 func (test *testLibrary) AndroidMk(ctx AndroidMkContext, ret *android.AndroidMkData) {
 	ctx.subAndroidMk(ret, test.libraryDecorator)
 }
@@ -274,7 +331,11 @@ func (stripper *stripper) AndroidMk(ctx AndroidMkContext, ret *android.AndroidMk
 	ret.Extra = append(ret.Extra, func(w io.Writer, outputFile android.Path) {
 		if stripper.StripProperties.Strip.None {
 			fmt.Fprintln(w, "LOCAL_STRIP_MODULE := false")
-		} else if stripper.StripProperties.Strip.Keep_symbols {
+		} else if stripper.StripProperties.Strip.All {
+			fmt.Fprintln(w, "LOCAL_STRIP_MODULE := true")
+		} else if stripper.StripProperties.Strip.Keep_symbols ||
+			len(stripper.StripProperties.Strip.Keep_symbols_list) > 0 {
+			// Make can't keep only some symbols, so it keeps all of them
 			fmt.Fprintln(w, "LOCAL_STRIP_MODULE := keep_symbols")
 		} else {
 			fmt.Fprintln(w, "LOCAL_STRIP_MODULE := mini-debug-info")
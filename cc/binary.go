@@ -83,6 +83,9 @@ type binaryDecorator struct {
 
 	// Output archive of gcno coverage information
 	coverageOutputFile android.OptionalPath
+
+	// The linked output file, before it is stripped
+	unstrippedFile android.Path
 }
 
 var _ linker = (*binaryDecorator)(nil)
@@ -187,6 +190,11 @@ func (binary *binaryDecorator) linkerInit(ctx BaseModuleContext) {
 			binary.Properties.Static_executable = nil
 		}
 	}
+
+	if (ctx.inRecovery() || ctx.inRamdisk()) && binary.Properties.Static_executable == nil {
+		// The recovery image and the ramdisk have few shared libraries, if any
+		binary.Properties.Static_executable = proptools.BoolPtr(true)
+	}
 }
 
 func (binary *binaryDecorator) static() bool {
@@ -282,6 +290,8 @@ func (binary *binaryDecorator) linkerFlags(ctx ModuleContext, flags Flags) Flags
 func (binary *binaryDecorator) link(ctx ModuleContext,
 	flags Flags, deps PathDeps, objs Objects) android.Path {
 
+	objs = objs.Append(deps.Objs)
+
 	fileName := binary.getStem(ctx) + flags.Toolchain.ExecutableSuffix()
 	outputFile := android.PathForModuleOut(ctx, fileName)
 	ret := outputFile
@@ -314,6 +324,8 @@ func (binary *binaryDecorator) link(ctx ModuleContext,
 	linkerDeps = append(linkerDeps, deps.LateSharedLibsDeps...)
 	linkerDeps = append(linkerDeps, objs.tidyFiles...)
 
+	binary.unstrippedFile = outputFile
+
 	TransformObjToDynamicBinary(ctx, objs.objFiles, sharedLibs, deps.StaticLibs,
 		deps.LateStaticLibs, deps.WholeStaticLibs, linkerDeps, deps.CrtBegin, deps.CrtEnd, true,
 		builderFlags, outputFile)
@@ -179,13 +179,19 @@ var (
 
 	_ = pctx.SourcePathVariable("sAbiDiffer", "prebuilts/build-tools/${config.HostPrebuiltTag}/bin/header-abi-diff")
 
-	// Abidiff check turned on in advice-only mode. Builds will not fail on abi incompatibilties / extensions.
+	// The build fails on ABI incompatibilities unless $diffFlags contains -advice-only, ABI
+	// extensions are allowed.  The error message explains how to update the reference dump if
+	// the change is intended.
 	sAbiDiff = pctx.AndroidStaticRule("sAbiDiff",
 		blueprint.RuleParams{
-			Command:     "$sAbiDiffer -lib $libName -arch $arch -advice-only -o ${out} -new $in -old $referenceDump",
+			Command: "($sAbiDiffer $diffFlags -lib $libName -arch $arch -o ${out} -new $in -old $referenceDump) || " +
+				"(echo \"error: the ABI of $libName is incompatible with $zippedReferenceDump, see ${out}\" && " +
+				"echo \"If the change is intended, update the reference dump with:\" && " +
+				"echo \"  gzip -c $in > $zippedReferenceDump\" && " +
+				"echo \"or set SKIP_ABI_CHECKS=true to only report ABI incompatibilities\" && exit 1)",
 			CommandDeps: []string{"$sAbiDiffer"},
 		},
-		"referenceDump", "libName", "arch")
+		"diffFlags", "referenceDump", "zippedReferenceDump", "libName", "arch")
 
 	unzipRefSAbiDump = pctx.AndroidStaticRule("unzipRefSAbiDump",
 		blueprint.RuleParams{
@@ -216,7 +222,9 @@ type builderFlags struct {
 	ldFlags       string
 	libFlags      string
 	yaccFlags     string
+	ragelFlags    string
 	protoFlags    string
+	flatcFlags    string
 	tidyFlags     string
 	sAbiFlags     string
 	yasmFlags     string
@@ -233,6 +241,7 @@ type builderFlags struct {
 	groupStaticLibs bool
 
 	stripKeepSymbols       bool
+	stripKeepSymbolsList   string
 	stripKeepMiniDebugInfo bool
 	stripAddGnuDebuglink   bool
 }
@@ -673,8 +682,17 @@ func UnzipRefDump(ctx android.ModuleContext, zippedRefDump android.Path, baseNam
 	return outputFile
 }
 
+// SourceAbiDiff compares the linked ABI dump of a library with its unzipped reference dump,
+// and fails the build on incompatible changes unless SKIP_ABI_CHECKS is set.  zippedReferenceDump
+// is the checked-in reference dump, which the error message asks to update.
 func SourceAbiDiff(ctx android.ModuleContext, inputDump android.Path, referenceDump android.Path,
-	baseName string) android.OptionalPath {
+	zippedReferenceDump android.Path, baseName string) android.OptionalPath {
+
+	diffFlags := []string{"-allow-extensions"}
+	if ctx.AConfig().IsEnvTrue("SKIP_ABI_CHECKS") {
+		diffFlags = append(diffFlags, "-advice-only")
+	}
+
 	outputFile := android.PathForModuleOut(ctx, baseName+".abidiff")
 	ctx.ModuleBuild(pctx, android.ModuleBuildParams{
 		Rule:        sAbiDiff,
@@ -683,9 +701,11 @@ func SourceAbiDiff(ctx android.ModuleContext, inputDump android.Path, referenceD
 		Input:       inputDump,
 		Implicit:    referenceDump,
 		Args: map[string]string{
-			"referenceDump": referenceDump.String(),
-			"libName":       baseName,
-			"arch":          ctx.Arch().ArchType.Name,
+			"diffFlags":           strings.Join(diffFlags, " "),
+			"referenceDump":       referenceDump.String(),
+			"zippedReferenceDump": zippedReferenceDump.String(),
+			"libName":             baseName,
+			"arch":                ctx.Arch().ArchType.Name,
 		},
 	})
 	return android.OptionalPathForPath(outputFile)
@@ -763,6 +783,9 @@ func TransformStrip(ctx android.ModuleContext, inputFile android.Path,
 	if flags.stripKeepSymbols {
 		args += " --keep-symbols"
 	}
+	if flags.stripKeepSymbolsList != "" {
+		args += " --keep-symbols-list=" + flags.stripKeepSymbolsList
+	}
 
 	ctx.ModuleBuild(pctx, android.ModuleBuildParams{
 		Rule:        strip,
@@ -19,8 +19,10 @@ package cc
 // is handled in builder.go
 
 import (
+	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/google/blueprint"
 	"github.com/google/blueprint/proptools"
@@ -37,6 +39,7 @@ func init() {
 		ctx.BottomUp("link", linkageMutator).Parallel()
 		ctx.BottomUp("vndk", vndkMutator).Parallel()
 		ctx.BottomUp("image", vendorMutator).Parallel()
+		ctx.BottomUp("sdk", sdkMutator).Parallel()
 		ctx.BottomUp("ndk_api", ndkApiMutator).Parallel()
 		ctx.BottomUp("test_per_src", testPerSrcMutator).Parallel()
 		ctx.BottomUp("begin", beginMutator).Parallel()
@@ -50,6 +53,10 @@ func init() {
 		ctx.BottomUp("tsan", sanitizerMutator(tsan)).Parallel()
 
 		ctx.BottomUp("coverage", coverageLinkingMutator).Parallel()
+
+		ctx.TopDown("lto_deps", ltoDepsMutator)
+		ctx.BottomUp("lto", ltoMutator).Parallel()
+
 		ctx.TopDown("vndk_deps", sabiDepsMutator)
 	})
 
@@ -107,7 +114,9 @@ type Flags struct {
 	CppFlags        []string // Flags that apply to C++ source files
 	ToolingCppFlags []string // Flags that apply to C++ source files parsed by clang LibTooling tools
 	YaccFlags       []string // Flags that apply to Yacc source files
+	RagelFlags      []string // Flags that apply to Ragel source files
 	protoFlags      []string // Flags that apply to proto source files
+	flatcFlags      []string // Flags that apply to flatbuffers schema files
 	aidlFlags       []string // Flags that apply to aidl source files
 	rsFlags         []string // Flags that apply to renderscript source files
 	LdFlags         []string // Flags that apply to linker command lines
@@ -144,7 +153,9 @@ type BaseProperties struct {
 	// compile module with clang instead of gcc
 	Clang *bool `android:"arch_variant"`
 
-	// Minimum sdk version supported when compiling against the ndk
+	// Minimum sdk version supported when compiling against the ndk.  Device modules that set it
+	// are also built for the platform, ignoring sdk_version, and only the platform variant is
+	// installed.  Apps package the variant built against the ndk as a JNI library.
 	Sdk_version string
 
 	// don't insert default compiler flags into asflags, cflags,
@@ -164,11 +175,29 @@ type BaseProperties struct {
 	// Nothing happens if BOARD_VNDK_VERSION isn't set in the BoardConfig.mk
 	Vendor_available *bool
 
+	// whether this module should also be built for the recovery image.  The recovery
+	// variant is installed into /system of the recovery ramdisk, and binaries are linked
+	// statically unless they set `static_executable: false`.  All the libraries that the
+	// recovery variant depends on must also set recovery_available.
+	Recovery_available *bool
+
+	// whether this module should also be built for the ramdisk that the first stage of init
+	// runs from.  It is installed into the root of the ramdisk, and otherwise works like
+	// recovery_available.
+	Ramdisk_available *bool
+
 	AndroidMkSharedLibs []string `blueprint:"mutated"`
 	HideFromMake        bool     `blueprint:"mutated"`
 	PreventInstall      bool     `blueprint:"mutated"`
 
 	UseVndk bool `blueprint:"mutated"`
+
+	// Set on the variants of a module that are installed into the recovery image or the ramdisk
+	InRecovery bool `blueprint:"mutated"`
+	InRamdisk  bool `blueprint:"mutated"`
+
+	// Set on the variant of a module with sdk_version that is built against the ndk
+	SdkVariant bool `blueprint:"mutated"`
 }
 
 type UnusedProperties struct {
@@ -186,6 +215,8 @@ type ModuleContextIntf interface {
 	vndk() bool
 	isVndk() bool
 	isVndkSp() bool
+	inRecovery() bool
+	inRamdisk() bool
 	createVndkSourceAbiDump() bool
 	selectedStl() string
 	baseModuleName() string
@@ -294,10 +325,16 @@ type Module struct {
 	sanitize  *sanitize
 	coverage  *coverage
 	sabi      *sabi
+	lto       *lto
+	pgo       *pgo
 	vndkdep   *vndkdep
 
 	androidMkSharedLibDeps []string
 
+	// API levels of the stub libraries to link against instead of the shared libraries, by
+	// library name, from libfoo#<api level> entries in shared_libs
+	sharedLibStubsVersions map[string]string
+
 	outputFile android.OptionalPath
 
 	cachedToolchain config.Toolchain
@@ -331,6 +368,12 @@ func (c *Module) Init() android.Module {
 	if c.sabi != nil {
 		c.AddProperties(c.sabi.props()...)
 	}
+	if c.lto != nil {
+		c.AddProperties(c.lto.props()...)
+	}
+	if c.pgo != nil {
+		c.AddProperties(c.pgo.props()...)
+	}
 	if c.vndkdep != nil {
 		c.AddProperties(c.vndkdep.props()...)
 	}
@@ -423,6 +466,14 @@ func (ctx *moduleContextImpl) noDefaultCompilerFlags() bool {
 	return Bool(ctx.mod.Properties.No_default_compiler_flags)
 }
 
+func (ctx *moduleContextImpl) inRecovery() bool {
+	return ctx.mod.Properties.InRecovery
+}
+
+func (ctx *moduleContextImpl) inRamdisk() bool {
+	return ctx.mod.Properties.InRamdisk
+}
+
 func (ctx *moduleContextImpl) sdk() bool {
 	if ctx.ctx.Device() && !ctx.vndk() {
 		return ctx.mod.Properties.Sdk_version != ""
@@ -488,6 +539,8 @@ func newModule(hod android.HostOrDeviceSupported, multilib android.Multilib) *Mo
 	module.sanitize = &sanitize{}
 	module.coverage = &coverage{}
 	module.sabi = &sabi{}
+	module.lto = &lto{}
+	module.pgo = &pgo{}
 	module.vndkdep = &vndkdep{}
 	return module
 }
@@ -537,6 +590,12 @@ func (c *Module) GenerateAndroidBuildActions(actx android.ModuleContext) {
 	if c.coverage != nil {
 		flags = c.coverage.flags(ctx, flags)
 	}
+	if c.lto != nil {
+		flags = c.lto.flags(ctx, flags)
+	}
+	if c.pgo != nil {
+		flags = c.pgo.flags(ctx, flags)
+	}
 	for _, feature := range c.features {
 		flags = feature.flags(ctx, flags)
 	}
@@ -558,6 +617,7 @@ func (c *Module) GenerateAndroidBuildActions(actx android.ModuleContext) {
 	if c.sabi != nil {
 		flags = c.sabi.flags(ctx, flags)
 	}
+	ctx.Tracef("flags: %+v", flags)
 	// Optimization to reduce size of build.ninja
 	// Replace the long list of flags for each file with a module-local variable
 	ctx.Variable(pctx, "cflags", strings.Join(flags.CFlags, " "))
@@ -588,7 +648,33 @@ func (c *Module) GenerateAndroidBuildActions(actx android.ModuleContext) {
 		if ctx.Failed() {
 			return
 		}
+		c.installSymbols(ctx)
+	}
+}
+
+// installSymbols installs the unstripped output of a device module to the symbols directory of
+// the product, at the same path as on the device, for the stack tool and crash symbolization.
+func (c *Module) installSymbols(ctx ModuleContext) {
+	unstripped, ok := c.linker.(interface {
+		unstrippedOutputFile() android.Path
+	})
+	if !ok || unstripped.unstrippedOutputFile() == nil || !ctx.Device() {
+		return
+	}
+	installed, ok := c.installer.(interface {
+		installedPath() android.OutputPath
+	})
+	if !ok {
+		return
+	}
+
+	productOut := filepath.Join("target", "product", ctx.AConfig().DeviceName())
+	rel, err := filepath.Rel(productOut, installed.installedPath().RelPathString())
+	if err != nil || strings.HasPrefix(rel, "../") {
+		return
 	}
+	symbolsDir := android.PathForOutput(ctx, productOut, "symbols", filepath.Dir(rel))
+	ctx.InstallFile(symbolsDir, unstripped.unstrippedOutputFile())
 }
 
 func (c *Module) toolchain(ctx BaseModuleContext) config.Toolchain {
@@ -617,6 +703,12 @@ func (c *Module) begin(ctx BaseModuleContext) {
 	if c.sabi != nil {
 		c.sabi.begin(ctx)
 	}
+	if c.lto != nil {
+		c.lto.begin(ctx)
+	}
+	if c.pgo != nil {
+		c.pgo.begin(ctx)
+	}
 	if c.vndkdep != nil {
 		c.vndkdep.begin(ctx)
 	}
@@ -653,6 +745,12 @@ func (c *Module) deps(ctx DepsContext) Deps {
 	if c.sabi != nil {
 		deps = c.sabi.deps(ctx, deps)
 	}
+	if c.lto != nil {
+		deps = c.lto.deps(ctx, deps)
+	}
+	if c.pgo != nil {
+		deps = c.pgo.deps(ctx, deps)
+	}
 	if c.vndkdep != nil {
 		deps = c.vndkdep.deps(ctx, deps)
 	}
@@ -760,6 +858,9 @@ func (c *Module) DepsMutator(actx android.BottomUpMutatorContext) {
 		deps.ReexportSharedLibHeaders, _ = rewriteNdkLibs(deps.ReexportSharedLibHeaders)
 	}
 
+	deps.SharedLibs = c.splitStubsVersions(ctx, deps.SharedLibs)
+	deps.ReexportSharedLibHeaders, _ = splitStubsVersionsList(deps.ReexportSharedLibHeaders)
+
 	for _, lib := range deps.HeaderLibs {
 		depTag := headerDepTag
 		if inList(lib, deps.ReexportHeaderLibHeaders) {
@@ -819,6 +920,32 @@ func (c *Module) DepsMutator(actx android.BottomUpMutatorContext) {
 		{"ndk_api", version}, {"link", "shared"}}, ndkLateStubDepTag, variantLateNdkLibs...)
 }
 
+// splitStubsVersions removes the API levels from the libfoo#<api level> entries in a list of
+// shared libraries, and records them to link against the stub libraries of those API levels.
+func (c *Module) splitStubsVersions(ctx DepsContext, libs []string) []string {
+	names, versions := splitStubsVersionsList(libs)
+	c.sharedLibStubsVersions = nil
+	for i, name := range names {
+		if versions[i] == "" {
+			continue
+		}
+		if c.sharedLibStubsVersions == nil {
+			c.sharedLibStubsVersions = make(map[string]string)
+		}
+		if other, exists := c.sharedLibStubsVersions[name]; exists && other != versions[i] {
+			ctx.PropertyErrorf("shared_libs", "links against API levels %q and %q of %q",
+				other, versions[i], name)
+		}
+		c.sharedLibStubsVersions[name] = versions[i]
+	}
+	for i, name := range names {
+		if versions[i] == "" && c.sharedLibStubsVersions[name] != "" {
+			ctx.PropertyErrorf("shared_libs", "links against both %q and its stubs", name)
+		}
+	}
+	return lastUniqueElements(names)
+}
+
 func beginMutator(ctx android.BottomUpMutatorContext) {
 	if c, ok := ctx.Module().(*Module); ok && c.Enabled() {
 		c.beginMutator(ctx)
@@ -937,6 +1064,11 @@ func (c *Module) depsToPaths(ctx android.ModuleContext) PathDeps {
 
 		cc, _ := m.(*Module)
 		if cc == nil {
+			if l, ok := linkableLibrary(m); ok {
+				c.linkableDepToPaths(ctx, name, tag, l, &depPaths)
+				return
+			}
+
 			switch tag {
 			case android.DefaultsDepTag, android.SourceDepTag:
 			case genSourceDepTag:
@@ -1029,7 +1161,25 @@ func (c *Module) depsToPaths(ctx android.ModuleContext) PathDeps {
 		depFile := android.OptionalPath{}
 
 		switch tag {
-		case ndkStubDepTag, sharedDepTag, sharedExportDepTag:
+		case sharedDepTag, sharedExportDepTag:
+			ptr = &depPaths.SharedLibs
+			depPtr = &depPaths.SharedLibsDeps
+			depFile = cc.linker.(libraryInterface).toc()
+			if version, ok := c.sharedLibStubsVersions[name]; ok {
+				stubs, ok := cc.linker.(stubsProducer)
+				if !ok {
+					ctx.ModuleErrorf("module %q has no stub libraries", name)
+					return
+				}
+				stub, ok := stubs.stubsForVersion(version)
+				if !ok {
+					ctx.ModuleErrorf("module %q has no stub library for API level %q", name, version)
+					return
+				}
+				linkFile = android.OptionalPathForPath(stub.outputFile)
+				depFile = stub.tocFile
+			}
+		case ndkStubDepTag:
 			ptr = &depPaths.SharedLibs
 			depPtr = &depPaths.SharedLibsDeps
 			depFile = cc.linker.(libraryInterface).toc()
@@ -1060,6 +1210,12 @@ func (c *Module) depsToPaths(ctx android.ModuleContext) PathDeps {
 		case headerDepTag:
 			// Nothing
 		case objDepTag:
+			switch cc.linker.(type) {
+			case *objectLinker, *ndkPrebuiltObjectLinker:
+			default:
+				ctx.ModuleErrorf("module %q is not a cc_object", name)
+				return
+			}
 			depPaths.Objs.objFiles = append(depPaths.Objs.objFiles, linkFile.Path())
 		case crtBeginDepTag:
 			depPaths.CrtBegin = linkFile
@@ -1113,6 +1269,12 @@ func (c *Module) depsToPaths(ctx android.ModuleContext) PathDeps {
 			isLLndk := inList(libName, llndkLibraries)
 			if c.vndk() && (Bool(cc.Properties.Vendor_available) || isLLndk) {
 				libName += vendorSuffix
+			} else if cc.Properties.SdkVariant {
+				libName += sdkSuffix
+			} else if cc.Properties.InRecovery {
+				libName += recoverySuffix
+			} else if cc.Properties.InRamdisk {
+				libName += ramdiskSuffix
 			}
 			// Note: the order of libs in this list is not important because
 			// they merely serve as dependencies in the make world and do not
@@ -1144,6 +1306,18 @@ func (c *Module) InstallInSanitizerDir() bool {
 	return c.installer.inSanitizerDir()
 }
 
+func (c *Module) InstallInRecovery() bool {
+	return c.Properties.InRecovery
+}
+
+func (c *Module) InstallInRamdisk() bool {
+	return c.Properties.InRamdisk
+}
+
+func (c *Module) OutputFile() android.OptionalPath {
+	return c.outputFile
+}
+
 func (c *Module) HostToolPath() android.OptionalPath {
 	if c.installer == nil {
 		return android.OptionalPath{}
@@ -1191,6 +1365,8 @@ func DefaultsFactory(props ...interface{}) android.Module {
 		&CoverageProperties{},
 		&SAbiProperties{},
 		&VndkProperties{},
+		&LTOProperties{},
+		&PgoProperties{},
 	)
 
 	android.InitDefaultsModule(module)
@@ -1206,6 +1382,11 @@ const (
 	// vendorMode is the variant used for /vendor code that compiles
 	// against the VNDK.
 	vendorMode = "vendor"
+
+	// recoveryMode and ramdiskMode are the variants installed into the
+	// recovery image and the ramdisk.
+	recoveryMode = "recovery"
+	ramdiskMode  = "ramdisk"
 )
 
 func vendorMutator(mctx android.BottomUpMutatorContext) {
@@ -1213,6 +1394,12 @@ func vendorMutator(mctx android.BottomUpMutatorContext) {
 		return
 	}
 
+	if _, ok := linkableLibrary(mctx.Module()); ok {
+		// Libraries built by other languages are only installed on the system image
+		mctx.CreateVariations(coreMode)
+		return
+	}
+
 	m, ok := mctx.Module().(*Module)
 	if !ok {
 		return
@@ -1224,6 +1411,16 @@ func vendorMutator(mctx android.BottomUpMutatorContext) {
 			"doesn't make sense at the same time as `vendor: true` or `proprietary: true`")
 		return
 	}
+	if Bool(m.Properties.Recovery_available) && mctx.Vendor() {
+		mctx.PropertyErrorf("recovery_available",
+			"doesn't make sense at the same time as `vendor: true` or `proprietary: true`")
+		return
+	}
+	if Bool(m.Properties.Ramdisk_available) && mctx.Vendor() {
+		mctx.PropertyErrorf("ramdisk_available",
+			"doesn't make sense at the same time as `vendor: true` or `proprietary: true`")
+		return
+	}
 	if vndk := m.vndkdep; vndk != nil {
 		if vndk.isVndk() && !Bool(m.Properties.Vendor_available) {
 			mctx.PropertyErrorf("vndk",
@@ -1237,28 +1434,135 @@ func vendorMutator(mctx android.BottomUpMutatorContext) {
 		}
 	}
 
+	var variations []string
 	if !mctx.DeviceConfig().CompileVndk() {
 		// If the device isn't compiling against the VNDK, we always
 		// use the core mode.
-		mctx.CreateVariations(coreMode)
+		variations = append(variations, coreMode)
 	} else if _, ok := m.linker.(*llndkStubDecorator); ok {
 		// LL-NDK stubs only exist in the vendor variant, since the
 		// real libraries will be used in the core variant.
-		mctx.CreateVariations(vendorMode)
+		variations = append(variations, vendorMode)
 	} else if Bool(m.Properties.Vendor_available) {
 		// This will be available in both /system and /vendor
 		// or a /system directory that is available to vendor.
-		mod := mctx.CreateVariations(coreMode, vendorMode)
-		mod[1].(*Module).Properties.UseVndk = true
+		variations = append(variations, coreMode, vendorMode)
 	} else if mctx.Vendor() && m.Properties.Sdk_version == "" {
 		// This will be available in /vendor only
-		mod := mctx.CreateVariations(vendorMode)
-		mod[0].(*Module).Properties.UseVndk = true
+		variations = append(variations, vendorMode)
 	} else {
 		// This is either in /system (or similar: /data), or is a
 		// modules built with the NDK. Modules built with the NDK
 		// will be restricted using the existing link type checks.
-		mctx.CreateVariations(coreMode)
+		variations = append(variations, coreMode)
+	}
+
+	// Toolchain libraries are linked into every image
+	_, toolchainLibrary := m.linker.(*toolchainLibraryDecorator)
+	if Bool(m.Properties.Recovery_available) || toolchainLibrary {
+		variations = append(variations, recoveryMode)
+	}
+	if Bool(m.Properties.Ramdisk_available) || toolchainLibrary {
+		variations = append(variations, ramdiskMode)
+	}
+
+	mods := mctx.CreateVariations(variations...)
+	for i, variation := range variations {
+		mod := mods[i].(*Module)
+		switch variation {
+		case vendorMode:
+			mod.Properties.UseVndk = true
+		case recoveryMode, ramdiskMode:
+			// The recovery image and the ramdisk are built against the platform, they
+			// don't have the NDK or the VNDK
+			mod.Properties.InRecovery = variation == recoveryMode
+			mod.Properties.InRamdisk = variation == ramdiskMode
+			mod.Properties.Sdk_version = ""
+		}
+	}
+}
+
+const (
+	// platformSdkMode is the variant used by platform code, which ignores sdk_version
+	platformSdkMode = ""
+
+	// sdkMode is the variant of a module with sdk_version that is built against the NDK, and
+	// of the NDK prebuilts and stubs that it links against.
+	sdkMode = "sdk"
+)
+
+// ndkProvided returns true for the NDK prebuilts and the stubs of the NDK libraries, which
+// only the modules built against the NDK link against.
+func (c *Module) ndkProvided() bool {
+	switch c.linker.(type) {
+	case *ndkPrebuiltObjectLinker, *ndkPrebuiltLibraryLinker, *ndkPrebuiltStlLinker:
+		return true
+	}
+	_, ok := c.compiler.(*stubDecorator)
+	return ok
+}
+
+// sdkVariantModules records the names of the modules that have a variant built against the NDK,
+// so that the apps built against the SDK can package the platform variant of the jni_libs that
+// don't.
+type sdkVariantModules struct {
+	sync.Mutex
+	names map[string]bool
+}
+
+const sdkVariantModulesOnceKey = "ccSdkVariantModules"
+
+func getSdkVariantModules(config android.Config) *sdkVariantModules {
+	return config.Once(sdkVariantModulesOnceKey, func() interface{} {
+		return &sdkVariantModules{names: make(map[string]bool)}
+	}).(*sdkVariantModules)
+}
+
+// HasSdkVariant returns true if the cc module has a variant built against the NDK, because it sets
+// sdk_version.  It can only be called after the sdk mutator, for example from a DepsMutator.
+func HasSdkVariant(config android.Config, name string) bool {
+	s := getSdkVariantModules(config)
+	s.Lock()
+	defer s.Unlock()
+	return s.names[name]
+}
+
+func sdkMutator(mctx android.BottomUpMutatorContext) {
+	if mctx.Os() != android.Android {
+		return
+	}
+
+	if _, ok := linkableLibrary(mctx.Module()); ok {
+		mctx.CreateVariations(platformSdkMode)
+		return
+	}
+
+	m, ok := mctx.Module().(*Module)
+	if !ok {
+		return
+	}
+
+	if m.Properties.UseVndk || m.Properties.InRecovery || m.Properties.InRamdisk {
+		// Vendor modules are built against the VNDK, and the recovery image and the ramdisk
+		// against the platform, not the NDK
+		mctx.CreateVariations(platformSdkMode)
+	} else if m.ndkProvided() {
+		mctx.CreateVariations(sdkMode)
+	} else if _, ok := m.linker.(*toolchainLibraryDecorator); ok {
+		// Toolchain libraries are linked into both platform and NDK code
+		mctx.CreateVariations(platformSdkMode, sdkMode)
+	} else if m.Properties.Sdk_version != "" {
+		mod := mctx.CreateVariations(platformSdkMode, sdkMode)
+		mod[0].(*Module).Properties.Sdk_version = ""
+		mod[1].(*Module).Properties.SdkVariant = true
+		mod[1].(*Module).Properties.PreventInstall = true
+
+		s := getSdkVariantModules(mctx.AConfig())
+		s.Lock()
+		defer s.Unlock()
+		s.names[mctx.ModuleName()] = true
+	} else {
+		mctx.CreateVariations(platformSdkMode)
 	}
 }
 
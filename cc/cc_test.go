@@ -2,10 +2,201 @@ package cc
 
 import (
 	"android/soong/android"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"reflect"
+	"sort"
+	"strings"
 	"testing"
 )
 
+var buildDir string
+
+func setUp() {
+	var err error
+	buildDir, err = ioutil.TempDir("", "soong_cc_test")
+	if err != nil {
+		panic(err)
+	}
+}
+
+func tearDown() {
+	os.RemoveAll(buildDir)
+}
+
+func TestMain(m *testing.M) {
+	run := func() int {
+		setUp()
+		defer tearDown()
+
+		return m.Run()
+	}
+
+	os.Exit(run())
+}
+
+// newCcFixture returns a cc test fixture with the modules in bp and the source files of the tests.
+func newCcFixture(bp string) *android.TestFixture {
+	f := NewTestFixture(buildDir)
+	f.AddBlueprint(bp)
+	f.AddFiles(
+		"foo.c",
+		"bar.c",
+	)
+
+	return f
+}
+
+func testCc(t *testing.T, bp string) *android.TestContext {
+	f := newCcFixture(bp)
+	f.Prepare(t)
+	return f.TestContext
+}
+
+func TestInstallSymbols(t *testing.T) {
+	f := newCcFixture(`
+		cc_binary {
+			name: "foo",
+			srcs: ["foo.c"],
+			shared_libs: ["libbar"],
+		}
+
+		cc_library_shared {
+			name: "libbar",
+			srcs: ["bar.c"],
+		}
+	`)
+	f.Prepare(t)
+
+	symbolsDir := filepath.Join(buildDir, "target", "product", "test_device", "symbols")
+
+	for _, test := range []struct {
+		name, variant, symbols string
+	}{
+		{"foo", "android_arm64_armv8-a_core", "system/bin/foo"},
+		{"libbar", "android_arm64_armv8-a_shared_core", "system/lib64/libbar.so"},
+		{"libbar", "android_arm_armv7-a-neon_shared_core", "system/lib/libbar.so"},
+	} {
+		m := f.ModuleForTests(test.name, test.variant)
+		symbols := filepath.Join(symbolsDir, test.symbols)
+		found := false
+		for _, p := range m.Module().BuildParamsForTests() {
+			if p.Output != nil && p.Output.String() == symbols {
+				found = true
+				unstripped := m.Module().(*Module).linker.(interface {
+					unstrippedOutputFile() android.Path
+				}).unstrippedOutputFile()
+				if p.Input != unstripped {
+					t.Errorf("%s %s symbols are installed from %q, not %q", test.name, test.variant,
+						p.Input, unstripped)
+				}
+			}
+		}
+		if !found {
+			t.Errorf("%s %s symbols are not installed to %q", test.name, test.variant, symbols)
+		}
+	}
+}
+
+func TestStrip(t *testing.T) {
+	testCases := []struct {
+		name, strip, args string
+	}{
+		{
+			// The mini debug info is kept by default to symbolize crashes on the device
+			name: "default",
+			args: " --keep-mini-debug-info",
+		},
+		{
+			name:  "all",
+			strip: "all: true,",
+			args:  " --add-gnu-debuglink",
+		},
+		{
+			name:  "keep_symbols",
+			strip: "keep_symbols: true,",
+			args:  " --add-gnu-debuglink --keep-symbols",
+		},
+		{
+			name:  "keep_symbols_list",
+			strip: `keep_symbols_list: ["main", "foo"],`,
+			args:  " --add-gnu-debuglink --keep-symbols-list=main,foo",
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			f := newCcFixture(`
+				cc_binary {
+					name: "foo",
+					srcs: ["foo.c"],
+					stl: "none",
+					strip: {
+						` + test.strip + `
+					},
+				}
+			`)
+			f.Prepare(t)
+
+			m := f.ModuleForTests("foo", "android_arm64_armv8-a_core")
+			strip := m.Rule("strip")
+			if strip.Args["args"] != test.args {
+				t.Errorf("expected strip args %q, got %q", test.args, strip.Args["args"])
+			}
+			unstripped := m.Module().(*Module).linker.(interface {
+				unstrippedOutputFile() android.Path
+			}).unstrippedOutputFile()
+			if strip.Input != unstripped {
+				t.Errorf("expected %q to be stripped, got %q", unstripped, strip.Input)
+			}
+		})
+	}
+}
+
+func TestStripNone(t *testing.T) {
+	f := newCcFixture(`
+		cc_binary {
+			name: "foo",
+			srcs: ["foo.c"],
+			stl: "none",
+			strip: {
+				none: true,
+			},
+		}
+	`)
+	f.Prepare(t)
+
+	m := f.ModuleForTests("foo", "android_arm64_armv8-a_core")
+	for _, p := range m.Module().BuildParamsForTests() {
+		if p.Rule == strip {
+			t.Errorf("foo is stripped with strip.none: %q", p.Output)
+		}
+	}
+	unstripped := m.Module().(*Module).linker.(interface {
+		unstrippedOutputFile() android.Path
+	}).unstrippedOutputFile()
+	if unstripped != nil {
+		t.Errorf("foo has an unstripped output %q with strip.none", unstripped)
+	}
+}
+
+func TestStripErrors(t *testing.T) {
+	f := newCcFixture(`
+		cc_binary {
+			name: "foo",
+			srcs: ["foo.c"],
+			stl: "none",
+			strip: {
+				all: true,
+				keep_symbols_list: ["main"],
+			},
+		}
+	`)
+	android.FailIfNoMatchingErrors(t, "only one of none, all, keep_symbols and keep_symbols_list may be set",
+		f.PrepareWithErrors())
+}
+
 var firstUniqueElementsTestCases = []struct {
 	in  []string
 	out []string
@@ -213,3 +404,813 @@ func TestSplitListForSize(t *testing.T) {
 		}
 	}
 }
+
+var splitStubsVersionsListTestCases = []struct {
+	in       []string
+	names    []string
+	versions []string
+}{
+	{
+		in:       []string{"libfoo"},
+		names:    []string{"libfoo"},
+		versions: []string{""},
+	},
+	{
+		in:       []string{"libfoo#28", "libbar", "libbaz#current"},
+		names:    []string{"libfoo", "libbar", "libbaz"},
+		versions: []string{"28", "", "current"},
+	},
+}
+
+func TestSplitStubsVersionsList(t *testing.T) {
+	for _, testCase := range splitStubsVersionsListTestCases {
+		names, versions := splitStubsVersionsList(testCase.in)
+		if !reflect.DeepEqual(names, testCase.names) || !reflect.DeepEqual(versions, testCase.versions) {
+			t.Errorf("incorrect output:")
+			t.Errorf("     input: %#v", testCase.in)
+			t.Errorf("  expected: %#v %#v", testCase.names, testCase.versions)
+			t.Errorf("       got: %#v %#v", names, versions)
+		}
+	}
+}
+
+func TestSanitizeBlocklist(t *testing.T) {
+	for _, property := range []string{"blocklist", "blacklist"} {
+		t.Run(property, func(t *testing.T) {
+			f := newCcFixture(`
+				cc_library_shared {
+					name: "libfoo",
+					srcs: ["foo.c"],
+					sanitize: {
+						misc_undefined: ["integer"],
+						` + property + `: "blocklist.txt",
+					},
+				}
+			`)
+			f.AddFiles("blocklist.txt")
+			f.Prepare(t)
+
+			m := f.ModuleForTests("libfoo", "android_arm64_armv8-a_shared_core")
+			cFlags := m.Module().(*Module).flags.CFlags
+			if !inList("-fsanitize-blacklist=blocklist.txt", cFlags) {
+				t.Errorf("libfoo is not compiled with the %s: %q", property, cFlags)
+			}
+			if cc := m.Rule("cc"); !inList("blocklist.txt", cc.OrderOnly.Strings()) {
+				t.Errorf("libfoo compiles do not depend on the %s: %q", property, cc.OrderOnly)
+			}
+		})
+	}
+}
+
+func TestSanitizeBlocklistConflict(t *testing.T) {
+	f := newCcFixture(`
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			sanitize: {
+				misc_undefined: ["integer"],
+				blocklist: "blocklist.txt",
+				blacklist: "blacklist.txt",
+			},
+		}
+	`)
+	f.AddFiles("blocklist.txt", "blacklist.txt")
+	android.FailIfNoMatchingErrors(t, `cannot be set together with sanitize.blocklist`, f.PrepareWithErrors())
+}
+
+func TestLto(t *testing.T) {
+	testCases := []struct {
+		name      string
+		globalLto bool
+		variants  map[string]string
+	}{
+		{
+			// Only the binaries with lto properties need LTO variants of their static
+			// dependencies
+			name: "lto properties",
+			variants: map[string]string{
+				"thin": "android_arm64_armv8-a_static_core_lto-thin",
+				"full": "android_arm64_armv8-a_static_core_lto-full",
+				"none": "android_arm64_armv8-a_static_core",
+			},
+		},
+		{
+			// With GLOBAL_THINLTO the default variant of libfoo is already built with
+			// ThinLTO, the binaries that use ThinLTO must not need another variant
+			name:      "GLOBAL_THINLTO",
+			globalLto: true,
+			variants: map[string]string{
+				"thin": "android_arm64_armv8-a_static_core_lto-thin",
+				"full": "android_arm64_armv8-a_static_core_lto-full",
+				"none": "android_arm64_armv8-a_static_core_lto-thin",
+			},
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			f := newCcFixture(`
+				cc_binary {
+					name: "thin",
+					srcs: ["foo.c"],
+					static_libs: ["libfoo"],
+					stl: "none",
+					lto: {
+						thin: true,
+					},
+				}
+
+				cc_binary {
+					name: "full",
+					srcs: ["foo.c"],
+					static_libs: ["libfoo"],
+					stl: "none",
+					lto: {
+						full: true,
+					},
+				}
+
+				cc_binary {
+					name: "none",
+					srcs: ["foo.c"],
+					static_libs: ["libfoo"],
+					stl: "none",
+				}
+
+				cc_library_static {
+					name: "libfoo",
+					srcs: ["bar.c"],
+					stl: "none",
+				}
+			`)
+			if test.globalLto {
+				f.Config.ProductVariables.GlobalThinLTO = boolPtr(true)
+			}
+			f.Prepare(t)
+
+			for binary, variant := range test.variants {
+				lib := f.ModuleForTests("libfoo", variant).Module().(*Module).outputFile.Path()
+				ld := f.ModuleForTests(binary, "android_arm64_armv8-a_core").Rule("ld")
+				if !inList(lib.String(), append(ld.Inputs.Strings(), ld.Implicits.Strings()...)) {
+					t.Errorf("%s is not linked against libfoo %s: %q %q", binary, variant,
+						ld.Inputs, ld.Implicits)
+				}
+			}
+		})
+	}
+}
+
+func TestAutogenTestConfig(t *testing.T) {
+	f := newCcFixture(`
+		cc_test {
+			name: "footest",
+			srcs: ["foo.c"],
+		}
+
+		cc_test {
+			name: "pertest",
+			srcs: ["foo.c", "bar.c"],
+			test_per_src: true,
+		}
+
+		cc_benchmark {
+			name: "foobench",
+			srcs: ["foo.c"],
+		}
+
+		cc_test {
+			name: "nogtest",
+			srcs: ["foo.c"],
+			gtest: false,
+		}
+
+		cc_library_static {
+			name: "libgtest_main",
+			stl: "none",
+		}
+
+		cc_library_static {
+			name: "libgtest",
+			stl: "none",
+		}
+
+		cc_library_static {
+			name: "libgoogle-benchmark",
+			stl: "none",
+		}
+	`)
+	f.RegisterModuleType("cc_test", android.ModuleFactoryAdaptor(testFactory))
+	f.RegisterModuleType("cc_benchmark", android.ModuleFactoryAdaptor(benchmarkFactory))
+	f.Prepare(t)
+
+	for _, test := range []struct {
+		name, variant, config, template, module, testDir string
+	}{
+		{"footest", "android_arm64_armv8-a_core", "footest.config",
+			"${nativeTestConfigTemplate}", "footest", "/data/nativetest64/footest"},
+		{"footest", "android_arm_armv7-a-neon_core", "footest.config",
+			"${nativeTestConfigTemplate}", "footest", "/data/nativetest/footest"},
+		{"pertest", "android_arm64_armv8-a_core_foo", "foo.config",
+			"${nativeTestConfigTemplate}", "foo", "/data/nativetest64/pertest"},
+		{"pertest", "android_arm64_armv8-a_core_bar", "bar.config",
+			"${nativeTestConfigTemplate}", "bar", "/data/nativetest64/pertest"},
+		{"foobench", "android_arm64_armv8-a_core", "foobench.config",
+			"${nativeBenchmarkConfigTemplate}", "foobench", "/data/benchmarktest64/foobench"},
+	} {
+		config := f.ModuleForTests(test.name, test.variant).Output(test.config)
+		if config.Rule != autogenTestConfig {
+			t.Errorf("%s %s: expected a generated test config, got %v", test.name, test.variant,
+				config.Rule)
+			continue
+		}
+		for arg, expected := range map[string]string{
+			"testConfigTemplate": test.template,
+			"module":             test.module,
+			"testDir":            test.testDir,
+		} {
+			if config.Args[arg] != expected {
+				t.Errorf("%s %s: expected %s %q, got %q", test.name, test.variant, arg, expected,
+					config.Args[arg])
+			}
+		}
+	}
+
+	// Only gtests can be run by the generated configuration
+	if config := f.ModuleForTests("nogtest", "android_arm64_armv8-a_core").Module().(*Module).
+		linker.(*testBinary).testConfig; config != nil {
+		t.Errorf("nogtest has a generated test config %q", config)
+	}
+}
+
+func TestLibraryHeaders(t *testing.T) {
+	f := newCcFixture(`
+		cc_library_headers {
+			name: "libfoo_headers",
+			export_include_dirs: ["foo/include"],
+			header_libs: ["libbar_headers", "libbaz_headers"],
+			export_header_lib_headers: ["libbar_headers"],
+		}
+
+		cc_library_headers {
+			name: "libbar_headers",
+			export_include_dirs: ["bar/include"],
+		}
+
+		cc_library_headers {
+			name: "libbaz_headers",
+			export_include_dirs: ["baz/include"],
+		}
+
+		cc_binary {
+			name: "bin",
+			srcs: ["foo.c"],
+			header_libs: ["libfoo_headers"],
+			stl: "none",
+		}
+	`)
+	f.RegisterModuleType("cc_library_headers", android.ModuleFactoryAdaptor(libraryHeaderFactory))
+	f.AddFiles("foo/include/foo.h", "bar/include/bar.h", "baz/include/baz.h")
+	f.Prepare(t)
+
+	// Header libraries are not linked
+	headers := f.ModuleForTests("libfoo_headers", "android_arm64_armv8-a_core")
+	if params := headers.Module().BuildParamsForTests(); len(params) > 0 {
+		t.Errorf("libfoo_headers has build rules: %v", params)
+	}
+
+	// The include dirs of the header libraries are exported, along with the ones they reexport
+	flags := f.ModuleForTests("bin", "android_arm64_armv8-a_core").Module().(*Module).flags.GlobalFlags
+	for _, dir := range []string{"-Ifoo/include", "-Ibar/include"} {
+		if !inList(dir, flags) {
+			t.Errorf("bin flags don't contain %q: %q", dir, flags)
+		}
+	}
+	if inList("-Ibaz/include", flags) {
+		t.Errorf("bin flags contain the include dirs of libbaz_headers, which are not reexported: %q", flags)
+	}
+}
+
+func TestLibraryHeadersErrors(t *testing.T) {
+	for _, property := range []string{"whole_static_libs", "static_libs", "shared_libs"} {
+		t.Run(property, func(t *testing.T) {
+			f := newCcFixture(`
+				cc_library_headers {
+					name: "libfoo_headers",
+					` + property + `: ["libfoo"],
+				}
+
+				cc_library {
+					name: "libfoo",
+					srcs: ["foo.c"],
+					stl: "none",
+				}
+			`)
+			f.RegisterModuleType("cc_library_headers", android.ModuleFactoryAdaptor(libraryHeaderFactory))
+			android.FailIfNoMatchingErrors(t, "cc_library_headers must not have any "+property,
+				f.PrepareWithErrors())
+		})
+	}
+}
+
+func TestExcludeSymbols(t *testing.T) {
+	f := newCcFixture(`
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			exclude_symbols: ["foo_internal", "bar_internal"],
+			stl: "none",
+		}
+	`)
+	f.Prepare(t)
+
+	m := f.ModuleForTests("libfoo", "android_arm64_armv8-a_shared_core")
+
+	script := m.Output("exclude_symbols.map")
+	expected := `{\n  global:\n    *;\n  local:\n    foo_internal;\n    bar_internal;\n};`
+	if script.Args["content"] != expected {
+		t.Errorf("expected exclude_symbols.map %q, got %q", expected, script.Args["content"])
+	}
+
+	ld := m.Rule("ld")
+	if flag := "-Wl,--version-script," + script.Output.String(); !strings.Contains(ld.Args["ldFlags"], flag) {
+		t.Errorf("libfoo ldflags don't contain %q: %q", flag, ld.Args["ldFlags"])
+	}
+	if !inList(script.Output.String(), ld.Implicits.Strings()) {
+		t.Errorf("libfoo isn't relinked when exclude_symbols.map changes: %q", ld.Implicits)
+	}
+}
+
+func TestStubs(t *testing.T) {
+	f := newCcFixture(`
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			stubs: {
+				symbol_file: "libfoo.map.txt",
+				versions: ["28", "current"],
+			},
+			stl: "none",
+		}
+
+		cc_binary {
+			name: "bin",
+			srcs: ["bar.c"],
+			shared_libs: ["libfoo#28"],
+			stl: "none",
+		}
+	`)
+	f.AddFiles("libfoo.map.txt")
+	f.Prepare(t)
+
+	libfoo := f.ModuleForTests("libfoo", "android_arm64_armv8-a_shared_core")
+
+	// A stub library is generated from the symbol map for each API level
+	for _, version := range []string{"28", "current"} {
+		stub, ok := libfoo.Module().(*Module).linker.(stubsProducer).stubsForVersion(version)
+		if !ok {
+			t.Errorf("libfoo has no stub library for API level %q", version)
+			continue
+		}
+		if want := filepath.Join("stubs", version, "libfoo.so"); !strings.HasSuffix(stub.outputFile.String(), want) {
+			t.Errorf("expected the stub library of API level %q in %q, got %q", version, want, stub.outputFile)
+		}
+	}
+
+	var apiLevels []string
+	for _, p := range libfoo.Module().BuildParamsForTests() {
+		if p.Rule == genStubSrc {
+			apiLevels = append(apiLevels, p.Args["apiLevel"])
+			if p.Input.String() != "libfoo.map.txt" {
+				t.Errorf("expected the stubs to be generated from libfoo.map.txt, got %q", p.Input)
+			}
+		}
+	}
+	if !reflect.DeepEqual(apiLevels, []string{"28", "current"}) {
+		t.Errorf("expected stubs generated for API levels [28 current], got %q", apiLevels)
+	}
+
+	// libfoo#28 links against the stub library instead of libfoo
+	stub, _ := libfoo.Module().(*Module).linker.(stubsProducer).stubsForVersion("28")
+	ld := f.ModuleForTests("bin", "android_arm64_armv8-a_core").Rule("ld")
+	linked := append(ld.Inputs.Strings(), ld.Implicits.Strings()...)
+	if !strings.Contains(ld.Args["libFlags"], stub.outputFile.String()) {
+		t.Errorf("bin isn't linked against %q: %q", stub.outputFile, ld.Args["libFlags"])
+	}
+	if !inList(stub.tocFile.String(), linked) {
+		t.Errorf("bin isn't relinked when the .toc of the stub library changes: %q", linked)
+	}
+	if lib := libfoo.Module().(*Module).outputFile.String(); strings.Contains(ld.Args["libFlags"], lib) {
+		t.Errorf("bin is linked against %q instead of the stub library: %q", lib, ld.Args["libFlags"])
+	}
+}
+
+func TestStubsErrors(t *testing.T) {
+	testCases := []struct {
+		name, bp, err string
+	}{
+		{
+			name: "exclude_symbols with version_script",
+			bp: `
+				cc_library_shared {
+					name: "libfoo",
+					stl: "none",
+					version_script: "libfoo.map.txt",
+					exclude_symbols: ["foo"],
+				}`,
+			err: "cannot be used with version_script",
+		},
+		{
+			name: "invalid symbol",
+			bp: `
+				cc_library_shared {
+					name: "libfoo",
+					stl: "none",
+					exclude_symbols: ["foo*"],
+				}`,
+			err: `invalid symbol name "foo*"`,
+		},
+		{
+			name: "versions without symbol file",
+			bp: `
+				cc_library_shared {
+					name: "libfoo",
+					stl: "none",
+					stubs: {
+						versions: ["28"],
+					},
+				}`,
+			err: "requires stubs.symbol_file",
+		},
+		{
+			name: "symbol file",
+			bp: `
+				cc_library_shared {
+					name: "libfoo",
+					stl: "none",
+					stubs: {
+						symbol_file: "libfoo.txt",
+						versions: ["28"],
+					},
+				}`,
+			err: "must end with .map.txt",
+		},
+		{
+			name: "no versions",
+			bp: `
+				cc_library_shared {
+					name: "libfoo",
+					stl: "none",
+					stubs: {
+						symbol_file: "libfoo.map.txt",
+					},
+				}`,
+			err: "missing API levels to generate stub libraries for",
+		},
+		{
+			name: "invalid version",
+			bp: `
+				cc_library_shared {
+					name: "libfoo",
+					stl: "none",
+					stubs: {
+						symbol_file: "libfoo.map.txt",
+						versions: ["P"],
+					},
+				}`,
+			err: `API level must be an integer or current, found "P"`,
+		},
+		{
+			name: "duplicate version",
+			bp: `
+				cc_library_shared {
+					name: "libfoo",
+					stl: "none",
+					stubs: {
+						symbol_file: "libfoo.map.txt",
+						versions: ["28", "28"],
+					},
+				}`,
+			err: `duplicate API level "28"`,
+		},
+		{
+			name: "missing stub library",
+			bp: `
+				cc_library_shared {
+					name: "libfoo",
+					stl: "none",
+					stubs: {
+						symbol_file: "libfoo.map.txt",
+						versions: ["28"],
+					},
+				}
+
+				cc_binary {
+					name: "bin",
+					stl: "none",
+					shared_libs: ["libfoo#29"],
+				}`,
+			err: `module "libfoo" has no stub library for API level "29"`,
+		},
+		{
+			name: "library without stubs",
+			bp: `
+				cc_library_shared {
+					name: "libfoo",
+					stl: "none",
+				}
+
+				cc_binary {
+					name: "bin",
+					stl: "none",
+					shared_libs: ["libfoo#28"],
+				}`,
+			err: `module "libfoo" has no stub library for API level "28"`,
+		},
+		{
+			name: "library and stubs",
+			bp: `
+				cc_library_shared {
+					name: "libfoo",
+					stl: "none",
+					stubs: {
+						symbol_file: "libfoo.map.txt",
+						versions: ["28"],
+					},
+				}
+
+				cc_binary {
+					name: "bin",
+					stl: "none",
+					shared_libs: ["libfoo#28", "libfoo"],
+				}`,
+			err: `links against both "libfoo" and its stubs`,
+		},
+		{
+			name: "two versions",
+			bp: `
+				cc_library_shared {
+					name: "libfoo",
+					stl: "none",
+					stubs: {
+						symbol_file: "libfoo.map.txt",
+						versions: ["28", "29"],
+					},
+				}
+
+				cc_binary {
+					name: "bin",
+					stl: "none",
+					shared_libs: ["libfoo#28", "libfoo#29"],
+				}`,
+			err: `links against API levels "28" and "29" of "libfoo"`,
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			f := newCcFixture(test.bp)
+			f.AddFiles("libfoo.map.txt", "libfoo.txt")
+			android.FailIfNoMatchingErrors(t, test.err, f.PrepareWithErrors())
+		})
+	}
+}
+
+func TestFlatbuffers(t *testing.T) {
+	f := newCcFixture(`
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.c", "schema/foo.fbs"],
+			flatbuffers: {
+				local_include_dirs: ["schema"],
+				export_flatbuffers_headers: true,
+			},
+			stl: "none",
+		}
+
+		cc_library_headers {
+			name: "flatbuffer_headers",
+			export_include_dirs: ["flatbuffers/include"],
+		}
+
+		cc_binary {
+			name: "bin",
+			srcs: ["bar.c"],
+			shared_libs: ["libfoo"],
+			stl: "none",
+		}
+	`)
+	f.RegisterModuleType("cc_library_headers", android.ModuleFactoryAdaptor(libraryHeaderFactory))
+	f.AddFiles("schema/foo.fbs", "flatbuffers/include/flatbuffers.h")
+	f.Prepare(t)
+
+	libfoo := f.ModuleForTests("libfoo", "android_arm64_armv8-a_shared_core")
+	genDir := filepath.Join(buildDir, ".intermediates", "libfoo", "android_arm64_armv8-a_shared_core", "gen", "flatbuffers")
+
+	flatc := libfoo.Rule("flatc")
+	if flatc.Input.String() != "schema/foo.fbs" {
+		t.Errorf("expected the header to be generated from schema/foo.fbs, got %q", flatc.Input)
+	}
+	if header := filepath.Join(genDir, "schema", "foo_generated.h"); flatc.Output.String() != header {
+		t.Errorf("expected the generated header %q, got %q", header, flatc.Output)
+	}
+	if flatc.Args["flatcFlags"] != "-I schema -I ." {
+		t.Errorf("expected flatc flags %q, got %q", "-I schema -I .", flatc.Args["flatcFlags"])
+	}
+
+	// The schema is not compiled, the sources are compiled after the header is generated
+	var compiled []string
+	for _, p := range libfoo.Module().BuildParamsForTests() {
+		if p.Rule == cc {
+			compiled = append(compiled, p.Input.String())
+			if !inList(flatc.Output.String(), append(p.Implicits.Strings(), p.OrderOnly.Strings()...)) {
+				t.Errorf("%s is compiled without waiting for %q", p.Input, flatc.Output)
+			}
+		}
+	}
+	if !reflect.DeepEqual(compiled, []string{"foo.c"}) {
+		t.Errorf("expected only foo.c to be compiled, got %q", compiled)
+	}
+
+	// The generated headers and the flatbuffers runtime headers are exported to the dependents
+	flags := f.ModuleForTests("bin", "android_arm64_armv8-a_core").Module().(*Module).flags.GlobalFlags
+	for _, dir := range []string{"-I" + genDir, "-Iflatbuffers/include"} {
+		if !inList(dir, flags) {
+			t.Errorf("bin flags don't contain %q: %q", dir, flags)
+		}
+	}
+}
+
+func TestGeneratedSources(t *testing.T) {
+	f := newCcFixture(`
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["parser.yy", "lexer.ll", "scanner.cpp.rl", "keywords.hh.rl"],
+			ragelflags: ["-G2"],
+			yacc: {
+				export_yacc_headers: true,
+			},
+			ragel: {
+				export_ragel_headers: true,
+			},
+			stl: "none",
+		}
+
+		cc_binary {
+			name: "bin",
+			srcs: ["bar.c"],
+			shared_libs: ["libfoo"],
+			stl: "none",
+		}
+	`)
+	f.AddFiles("parser.yy", "lexer.ll", "scanner.cpp.rl", "keywords.hh.rl")
+	f.Prepare(t)
+
+	libfoo := f.ModuleForTests("libfoo", "android_arm64_armv8-a_shared_core")
+	genDir := filepath.Join(buildDir, ".intermediates", "libfoo", "android_arm64_armv8-a_shared_core", "gen")
+
+	// The ragel sources are named after the file they generate
+	generated := make(map[string]string)
+	var compiled []string
+	for _, p := range libfoo.Module().BuildParamsForTests() {
+		switch p.Rule {
+		case ragel:
+			generated[p.Input.String()] = p.Output.String()
+			if p.Args["ragelFlags"] != "-G2" {
+				t.Errorf("expected %s to be generated with ragel flags -G2, got %q", p.Input, p.Args["ragelFlags"])
+			}
+		case cc:
+			compiled = append(compiled, filepath.Base(p.Input.String()))
+		}
+	}
+	expected := map[string]string{
+		"scanner.cpp.rl": filepath.Join(genDir, "ragel", "scanner.cpp"),
+		"keywords.hh.rl": filepath.Join(genDir, "ragel", "keywords.hh"),
+	}
+	if !reflect.DeepEqual(generated, expected) {
+		t.Errorf("expected the ragel outputs %q, got %q", expected, generated)
+	}
+
+	// The generated headers are not compiled
+	sort.Strings(compiled)
+	if want := []string{"lexer.cpp", "parser.cpp", "scanner.cpp"}; !reflect.DeepEqual(compiled, want) {
+		t.Errorf("expected %q to be compiled, got %q", want, compiled)
+	}
+
+	// The generated yacc and ragel headers are exported to the dependents
+	flags := f.ModuleForTests("bin", "android_arm64_armv8-a_core").Module().(*Module).flags.GlobalFlags
+	for _, dir := range []string{"-I" + filepath.Join(genDir, "yacc"), "-I" + filepath.Join(genDir, "ragel")} {
+		if !inList(dir, flags) {
+			t.Errorf("bin flags don't contain %q: %q", dir, flags)
+		}
+	}
+}
+
+func TestGeneratedSourcesErrors(t *testing.T) {
+	f := newCcFixture(`
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["scanner.rl"],
+			stl: "none",
+		}
+	`)
+	f.AddFiles("scanner.rl")
+	android.FailIfNoMatchingErrors(t, `ragel source "scanner.rl" must be named after the C or C++ file it generates`,
+		f.PrepareWithErrors())
+}
+
+func TestObjs(t *testing.T) {
+	f := newCcFixture(`
+		cc_object {
+			name: "objfoo",
+			srcs: ["foo.c"],
+		}
+
+		cc_binary {
+			name: "bin",
+			srcs: ["bar.c"],
+			objs: ["objfoo"],
+			stl: "none",
+		}
+
+		cc_library_shared {
+			name: "libbar",
+			srcs: ["bar.c"],
+			objs: ["objfoo"],
+			stl: "none",
+		}
+	`)
+	f.Prepare(t)
+
+	obj := f.ModuleForTests("objfoo", "android_arm64_armv8-a_core").Module().(*Module).outputFile.Path()
+	for _, test := range []struct {
+		name, variant string
+	}{
+		{"bin", "android_arm64_armv8-a_core"},
+		{"libbar", "android_arm64_armv8-a_shared_core"},
+	} {
+		ld := f.ModuleForTests(test.name, test.variant).Rule("ld")
+		if !inList(obj.String(), ld.Inputs.Strings()) {
+			t.Errorf("%s doesn't link in %q: %q", test.name, obj, ld.Inputs)
+		}
+	}
+}
+
+func TestObjsErrors(t *testing.T) {
+	f := newCcFixture(`
+		cc_binary {
+			name: "foo",
+			srcs: ["foo.c"],
+			stl: "none",
+		}
+
+		cc_binary {
+			name: "bin",
+			srcs: ["bar.c"],
+			objs: ["foo"],
+			stl: "none",
+		}
+	`)
+	android.FailIfNoMatchingErrors(t, `module "foo" is not a cc_object`, f.PrepareWithErrors())
+}
+
+func TestAbiDiff(t *testing.T) {
+	bp := `
+		cc_library_shared {
+			name: "libvndk",
+			srcs: ["foo.c"],
+			vendor_available: true,
+			vndk: {
+				enabled: true,
+			},
+			stl: "none",
+		}
+	`
+	refDump := "prebuilts/abi-dumps/vndk/current/arm64/source-based/libvndk.so.lsdump.gz"
+
+	for _, test := range []struct {
+		name      string
+		skip      bool
+		diffFlags string
+	}{
+		{"check", false, "-allow-extensions"},
+		{"skip", true, "-allow-extensions -advice-only"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			f := newCcFixture(bp)
+			f.AddFiles(refDump)
+			if test.skip {
+				f.SetEnv("SKIP_ABI_CHECKS", "true")
+			}
+			f.Prepare(t)
+
+			diff := f.ModuleForTests("libvndk", "android_arm64_armv8-a_shared_core").Rule("sAbiDiff")
+			if diff.Args["diffFlags"] != test.diffFlags {
+				t.Errorf("expected diff flags %q, got %q", test.diffFlags, diff.Args["diffFlags"])
+			}
+			// The error message asks to update the checked-in reference dump
+			if diff.Args["zippedReferenceDump"] != refDump {
+				t.Errorf("expected reference dump %q, got %q", refDump, diff.Args["zippedReferenceDump"])
+			}
+		})
+	}
+}
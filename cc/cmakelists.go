@@ -342,14 +342,21 @@ func cleanupParameter(p string) string {
 		return p
 	}
 
+	return doubleEscape(unescapeParameter(p))
+}
+
+// unescapeParameter returns the parameter that was passed to the compiler.
+func unescapeParameter(p string) string {
+	if !strings.HasPrefix(p, "'") || !strings.HasSuffix(p, "'") || len(p) < 3 {
+		return p
+	}
+
 	// Reverse wrapper quotes and escaping that may have happened in NinjaAndShellEscape
 	// TODO:  It is ok to reverse here for now but if NinjaAndShellEscape becomes more complex,
 	// we should create a method NinjaAndShellUnescape in escape.go and use that instead.
 	p = p[1 : len(p)-1]
 	p = strings.Replace(p, `'\''`, `'`, -1)
 	p = strings.Replace(p, `$$`, `$`, -1)
-
-	p = doubleEscape(p)
 	return p
 }
 
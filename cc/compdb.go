@@ -0,0 +1,202 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cc
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/google/blueprint"
+
+	"android/soong/android"
+)
+
+// This singleton generates a compile_commands.json compilation database with the compile command
+// of every source file of the cc modules, for clangd, CLion and the other tools that understand the
+// format.  It is only generated when SOONG_GEN_COMPDB=true is set, into
+// out/soong/development/ide/compdb/compile_commands.json.  A source file that is compiled by
+// several variants uses the command of the first variant.
+
+func init() {
+	android.RegisterSingletonType("compdb_generator", compDBGeneratorSingleton)
+}
+
+func compDBGeneratorSingleton() blueprint.Singleton {
+	return &compdbGeneratorSingleton{}
+}
+
+type compdbGeneratorSingleton struct {
+	// the generated compilation database, for tests
+	data []byte
+}
+
+const (
+	compdbFilename                = "compile_commands.json"
+	compdbOutputProjectsDirectory = "development/ide/compdb"
+
+	// Environment variable used to enable this singleton.
+	envVariableGenerateCompdb = "SOONG_GEN_COMPDB"
+)
+
+// compDbEntry is an entry of the compilation database, see
+// https://clang.llvm.org/docs/JSONCompilationDatabase.html
+type compDbEntry struct {
+	Directory string   `json:"directory"`
+	Arguments []string `json:"arguments"`
+	File      string   `json:"file"`
+}
+
+func (c *compdbGeneratorSingleton) GenerateBuildActions(ctx blueprint.SingletonContext) {
+	if !ctx.Config().(android.Config).IsEnvTrue(envVariableGenerateCompdb) {
+		return
+	}
+
+	rootDir := getAndroidSrcRootDirectory(ctx)
+
+	entries := make(map[string]compDbEntry)
+	ctx.VisitAllModules(func(module blueprint.Module) {
+		ccModule, ok := module.(*Module)
+		if !ok || !ccModule.Enabled() {
+			return
+		}
+		compiledModule, ok := ccModule.compiler.(CompiledInterface)
+		if !ok {
+			return
+		}
+		for _, src := range compiledModule.Srcs() {
+			if _, exists := entries[src.String()]; exists {
+				continue
+			}
+			if args := getCompdbArguments(ctx, ccModule, src); args != nil {
+				entries[src.String()] = compDbEntry{
+					Directory: rootDir,
+					Arguments: args,
+					File:      src.String(),
+				}
+			}
+		}
+	})
+
+	var files []string
+	for file := range entries {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+
+	db := make([]compDbEntry, 0, len(files))
+	for _, file := range files {
+		db = append(db, entries[file])
+	}
+
+	data, err := json.MarshalIndent(db, "", "  ")
+	if err != nil {
+		ctx.Errorf("failed to encode %s: %s", compdbFilename, err)
+		return
+	}
+
+	compdb := android.PathForOutput(ctx, compdbOutputProjectsDirectory, compdbFilename)
+	if ctx.Failed() {
+		return
+	}
+	c.data = data
+	android.WriteFileRule(ctx, compdb, data)
+}
+
+// getCompdbArguments returns the command line that compiles src in the same way as
+// TransformSourceToObj, or nil if src is not a C, C++ or assembly file.
+func getCompdbArguments(ctx blueprint.SingletonContext, ccModule *Module, src android.Path) []string {
+	flags := ccModule.flags
+
+	var ccCmd string
+	var moduleFlags [][]string
+	asm := false
+	switch src.Ext() {
+	case ".S", ".s":
+		ccCmd = "gcc"
+		moduleFlags = [][]string{flags.AsFlags}
+		asm = true
+	case ".c":
+		ccCmd = "gcc"
+		moduleFlags = [][]string{flags.CFlags, flags.ConlyFlags}
+	case ".cpp", ".cc", ".mm":
+		ccCmd = "g++"
+		moduleFlags = [][]string{flags.CFlags, flags.CppFlags}
+	default:
+		return nil
+	}
+
+	if flags.Clang {
+		if !asm {
+			moduleFlags = append(moduleFlags, []string{"${config.NoOverrideClangGlobalCflags}"})
+		}
+		switch ccCmd {
+		case "gcc":
+			ccCmd = "clang"
+		case "g++":
+			ccCmd = "clang++"
+		}
+		ccCmd = "${config.ClangBin}/" + ccCmd
+	} else {
+		ccCmd = gccCmd(flags.Toolchain, ccCmd)
+	}
+
+	args := expandCompdbParameters(ctx, []string{ccCmd})
+	args = append(args, expandCompdbParameters(ctx, flags.GlobalFlags)...)
+	args = append(args, expandCompdbParameters(ctx, flags.SystemIncludeFlags)...)
+	for _, f := range moduleFlags {
+		args = append(args, expandCompdbParameters(ctx, f)...)
+	}
+	return append(args, "-c", src.String())
+}
+
+// expandCompdbParameters evaluates the ninja variables in the parameters, and splits them into
+// separate parameters like the shell does.  A parameter that was quoted by
+// proptools.NinjaAndShellEscape is a single parameter.
+func expandCompdbParameters(ctx blueprint.SingletonContext, params []string) []string {
+	var ret []string
+	for _, param := range params {
+		if param == "" {
+			continue
+		}
+		evaluated, err := evalVariable(ctx, param)
+		if err != nil {
+			ctx.Errorf("failed to evaluate %q for %s: %s", param, compdbFilename, err)
+			continue
+		}
+		if isQuotedParameter(param) {
+			ret = append(ret, unquoteParameter(evaluated))
+			continue
+		}
+		for _, p := range strings.Fields(evaluated) {
+			ret = append(ret, unquoteParameter(p))
+		}
+	}
+	return ret
+}
+
+// unquoteParameter reverses the shell quoting of proptools.NinjaAndShellEscape.  Unlike
+// unescapeParameter it leaves the $ signs alone, as evaluating the parameter has already reversed
+// the ninja escaping.
+func unquoteParameter(p string) string {
+	if !isQuotedParameter(p) {
+		return p
+	}
+	return strings.Replace(p[1:len(p)-1], `'\''`, `'`, -1)
+}
+
+func isQuotedParameter(p string) bool {
+	return strings.HasPrefix(p, "'") && strings.HasSuffix(p, "'") && len(p) >= 3
+}
@@ -0,0 +1,119 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cc
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/google/blueprint"
+)
+
+// testCompdb generates the compilation database of the modules in bp with SOONG_GEN_COMPDB set to
+// env, and returns its entries by file, or nil if it wasn't generated.
+func testCompdb(t *testing.T, env, bp string) map[string]compDbEntry {
+	singleton := &compdbGeneratorSingleton{}
+
+	f := newCcFixture(bp)
+	f.RegisterSingletonType("compdb_generator", func() blueprint.Singleton { return singleton })
+	f.SetEnv(envVariableGenerateCompdb, env)
+	f.AddFiles("foo.cpp")
+	f.Prepare(t)
+
+	if singleton.data == nil {
+		return nil
+	}
+	var entries []compDbEntry
+	if err := json.Unmarshal(singleton.data, &entries); err != nil {
+		t.Fatal(err)
+	}
+	ret := make(map[string]compDbEntry)
+	for _, entry := range entries {
+		ret[entry.File] = entry
+	}
+	return ret
+}
+
+func TestCompdb(t *testing.T) {
+	entries := testCompdb(t, "true", `
+		cc_library_static {
+			name: "libfoo",
+			stl: "none",
+			srcs: ["foo.c", "foo.cpp"],
+			cflags: ["-DNAME=\"a'b\"", "-DPRICE=$5", "-DDOLLARS=$$"],
+			conlyflags: ["-DCONLY"],
+			cppflags: ["-DCPP"],
+		}
+	`)
+
+	if len(entries) != 2 {
+		t.Fatalf("expected entries for foo.c and foo.cpp, got %v", entries)
+	}
+
+	for _, test := range []struct {
+		file        string
+		flag, other string
+	}{
+		{"foo.c", "-DCONLY", "-DCPP"},
+		{"foo.cpp", "-DCPP", "-DCONLY"},
+	} {
+		args := entries[test.file].Arguments
+		if len(args) < 2 || args[len(args)-2] != "-c" || args[len(args)-1] != test.file {
+			t.Errorf("%s isn't compiled by %q", test.file, args)
+			continue
+		}
+		// The escaped flags are passed to the compiler as they were written, without splitting them
+		// or dropping the escaped $ signs
+		for _, flag := range []string{`-DNAME="a'b"`, "-DPRICE=$5", "-DDOLLARS=$$", test.flag} {
+			if !inList(flag, args) {
+				t.Errorf("%s isn't compiled with %q: %q", test.file, flag, args)
+			}
+		}
+		if inList(test.other, args) {
+			t.Errorf("%s is compiled with %q: %q", test.file, test.other, args)
+		}
+		// The flags are split like the shell splits the command, and the ninja variables are
+		// evaluated
+		for _, arg := range args {
+			if strings.Contains(arg, " ") {
+				t.Errorf("%s is compiled with the unsplit %q", test.file, arg)
+			}
+			if strings.Contains(arg, "${") {
+				t.Errorf("%s is compiled with the unevaluated %q", test.file, arg)
+			}
+		}
+	}
+}
+
+func TestCompdbEnv(t *testing.T) {
+	bp := `
+		cc_library_static {
+			name: "libfoo",
+			stl: "none",
+			srcs: ["foo.c"],
+		}
+	`
+	for _, env := range []string{"1", "true"} {
+		if testCompdb(t, env, bp) == nil {
+			t.Errorf("SOONG_GEN_COMPDB=%s doesn't generate %s", env, compdbFilename)
+		}
+	}
+	for _, env := range []string{"", "false"} {
+		if testCompdb(t, env, bp) != nil {
+			t.Errorf("SOONG_GEN_COMPDB=%q generates %s", env, compdbFilename)
+		}
+	}
+}
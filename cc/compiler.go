@@ -60,6 +60,11 @@ type BaseCompilerProperties struct {
 	// list of module-specific flags that will be used for .y and .yy compiles
 	Yaccflags []string
 
+	// list of module-specific flags that will be used for .rl compiles.  Ragel sources are
+	// named after the file they generate, for example foo.cpp.rl generates foo.cpp, and
+	// foo.hh.rl generates the header foo.hh.
+	Ragelflags []string
+
 	// the instruction set architecture to use to compile the C/C++
 	// module.
 	Instruction_set string `android:"arch_variant"`
@@ -150,11 +155,12 @@ func NewBaseCompiler() *baseCompiler {
 }
 
 type baseCompiler struct {
-	Properties BaseCompilerProperties
-	Proto      ProtoProperties
-	deps       android.Paths
-	srcs       android.Paths
-	flags      builderFlags
+	Properties  BaseCompilerProperties
+	Proto       ProtoProperties
+	Flatbuffers FlatbuffersProperties
+	deps        android.Paths
+	srcs        android.Paths
+	flags       builderFlags
 }
 
 var _ compiler = (*baseCompiler)(nil)
@@ -176,7 +182,7 @@ func (compiler *baseCompiler) appendAsflags(flags []string) {
 }
 
 func (compiler *baseCompiler) compilerProps() []interface{} {
-	return []interface{}{&compiler.Properties, &compiler.Proto}
+	return []interface{}{&compiler.Properties, &compiler.Proto, &compiler.Flatbuffers}
 }
 
 func (compiler *baseCompiler) compilerInit(ctx BaseModuleContext) {}
@@ -191,6 +197,10 @@ func (compiler *baseCompiler) compilerDeps(ctx DepsContext, deps Deps) Deps {
 		deps = protoDeps(ctx, deps, &compiler.Proto)
 	}
 
+	if compiler.hasSrcExt(".fbs") {
+		deps = flatbuffersDeps(deps)
+	}
+
 	return deps
 }
 
@@ -212,6 +222,7 @@ func (compiler *baseCompiler) compilerFlags(ctx ModuleContext, flags Flags) Flag
 	flags.AsFlags = append(flags.AsFlags, esc(compiler.Properties.Asflags)...)
 	flags.YasmFlags = append(flags.YasmFlags, esc(compiler.Properties.Asflags)...)
 	flags.YaccFlags = append(flags.YaccFlags, esc(compiler.Properties.Yaccflags)...)
+	flags.RagelFlags = append(flags.RagelFlags, esc(compiler.Properties.Ragelflags)...)
 
 	// Include dir cflags
 	localIncludeDirs := android.PathsForModuleSrc(ctx, compiler.Properties.Local_include_dirs)
@@ -424,11 +435,20 @@ func (compiler *baseCompiler) compilerFlags(ctx ModuleContext, flags Flags) Flag
 		flags = protoFlags(ctx, flags, &compiler.Proto)
 	}
 
+	if compiler.hasSrcExt(".fbs") {
+		flags = flatbuffersFlags(ctx, flags, &compiler.Flatbuffers)
+	}
+
 	if compiler.hasSrcExt(".y") || compiler.hasSrcExt(".yy") {
 		flags.GlobalFlags = append(flags.GlobalFlags,
 			"-I"+android.PathForModuleGen(ctx, "yacc", ctx.ModuleDir()).String())
 	}
 
+	if compiler.hasSrcExt(".rl") {
+		flags.GlobalFlags = append(flags.GlobalFlags,
+			"-I"+android.PathForModuleGen(ctx, "ragel", ctx.ModuleDir()).String())
+	}
+
 	if compiler.hasSrcExt(".aidl") {
 		if len(compiler.Properties.Aidl.Local_include_dirs) > 0 {
 			localAidlIncludeDirs := android.PathsForModuleSrc(ctx, compiler.Properties.Aidl.Local_include_dirs)
@@ -67,6 +67,7 @@ var ClangUnknownCflags = sorted([]string{
 
 var ClangLibToolingUnknownCflags = []string{
 	"-flto",
+	"-flto=thin",
 	"-fsanitize*",
 }
 
@@ -14,12 +14,37 @@
 
 package cc
 
+// This file contains native coverage.  Modules in the CoveragePaths of a product that sets
+// NativeCoverage, or with native_coverage: true, are compiled in a "cov" variant with gcov
+// instrumentation, or with clang's source based coverage if the product also sets ClangCoverage.
+// The binaries and shared libraries containing instrumented objects are linked with the profile
+// runtime.  The native_coverage singleton zips the files that the coverage reports are generated
+// from, the .gcno files of each binary and shared library for gcov, or the unstripped binaries
+// and shared libraries, which contain the coverage mapping, for source based coverage.
+
 import (
+	"sort"
+
 	"android/soong/android"
+	"android/soong/cc/config"
 
 	"github.com/google/blueprint"
 )
 
+func init() {
+	android.RegisterSingletonType("native_coverage", nativeCoverageSingletonFactory)
+}
+
+var nativeCoverageZip = pctx.AndroidStaticRule("nativeCoverageZip",
+	blueprint.RuleParams{
+		Command: `tr ' ' '\n' < $out.rsp > $out.list && ` +
+			`${SoongZipCmd} -o $out -C $outDir -l $out.list && rm $out.list`,
+		CommandDeps:    []string{"${SoongZipCmd}"},
+		Rspfile:        "$out.rsp",
+		RspfileContent: "$in",
+	},
+	"outDir")
+
 type CoverageProperties struct {
 	Native_coverage *bool
 
@@ -31,6 +56,9 @@ type coverage struct {
 
 	// Whether binaries containing this module need --coverage added to their ldflags
 	linkCoverage bool
+
+	// Whether the coverage mapping of clang's source based coverage may be linked into this module
+	clangCoverage bool
 }
 
 func (cov *coverage) props() []interface{} {
@@ -49,8 +77,14 @@ func (cov *coverage) flags(ctx ModuleContext, flags Flags) Flags {
 	}
 
 	if cov.Properties.CoverageEnabled {
-		flags.Coverage = true
-		flags.GlobalFlags = append(flags.GlobalFlags, "--coverage", "-O0")
+		// Source based coverage is only supported by clang, modules compiled with gcc fall back
+		// to gcov
+		if ctx.DeviceConfig().ClangCoverageEnabled() && flags.Clang {
+			flags.CFlags = append(flags.CFlags, "-fprofile-instr-generate", "-fcoverage-mapping")
+		} else {
+			flags.Coverage = true
+			flags.GlobalFlags = append(flags.GlobalFlags, "--coverage", "-O0")
+		}
 		cov.linkCoverage = true
 	}
 
@@ -94,11 +128,42 @@ func (cov *coverage) flags(ctx ModuleContext, flags Flags) Flags {
 
 	if cov.linkCoverage {
 		flags.LdFlags = append(flags.LdFlags, "--coverage")
+
+		// Host modules are linked with -nodefaultlibs, and the source based coverage runtime is
+		// not linked by --coverage, so link the profile runtime, which implements both, directly.
+		cov.clangCoverage = ctx.DeviceConfig().ClangCoverageEnabled()
+		if cov.clangCoverage {
+			flags.LdFlags = append(flags.LdFlags, "-u__llvm_profile_runtime")
+		}
+		if ctx.Host() || cov.clangCoverage {
+			if runtimeLibrary := profileRuntimeLibrary(ctx); runtimeLibrary != "" {
+				flags.LdFlags = append(flags.LdFlags, runtimeLibrary)
+			}
+		}
 	}
 
 	return flags
 }
 
+// profileRuntimeLibrary returns the path to the clang profile runtime for the target of the
+// module, or "" if there is none.
+func profileRuntimeLibrary(ctx ModuleContext) string {
+	if ctx.Host() {
+		switch ctx.Arch().ArchType {
+		case android.X86:
+			return "${config.ClangAsanLibDir}/libclang_rt.profile-i386.a"
+		case android.X86_64:
+			return "${config.ClangAsanLibDir}/libclang_rt.profile-x86_64.a"
+		}
+		return ""
+	}
+
+	if runtimeLibrary := config.ProfileRuntimeLibrary(ctx.toolchain()); runtimeLibrary != "" {
+		return "${config.ClangAsanLibDir}/" + runtimeLibrary + ".a"
+	}
+	return ""
+}
+
 func coverageLinkingMutator(mctx android.BottomUpMutatorContext) {
 	if c, ok := mctx.Module().(*Module); ok && c.coverage != nil {
 		var enabled bool
@@ -106,8 +171,9 @@ func coverageLinkingMutator(mctx android.BottomUpMutatorContext) {
 		if !mctx.DeviceConfig().NativeCoverageEnabled() {
 			// Coverage is disabled globally
 		} else if mctx.Host() {
-			// TODO(dwillemsen): because of -nodefaultlibs, we must depend on libclang_rt.profile-*.a
-			// Just turn off for now.
+			// The profile runtime is only available for linux hosts.  Host tools are run during
+			// the build, so they are only instrumented if they ask for it.
+			enabled = mctx.Os() == android.Linux && Bool(c.coverage.Properties.Native_coverage)
 		} else if c.coverage.Properties.Native_coverage != nil {
 			enabled = *c.coverage.Properties.Native_coverage
 		} else {
@@ -123,3 +189,87 @@ func coverageLinkingMutator(mctx android.BottomUpMutatorContext) {
 		}
 	}
 }
+
+// coverageFiles returns the files that the coverage reports of the module are generated from.
+func (c *Module) coverageFiles() android.Paths {
+	if c.coverage == nil || !c.coverage.linkCoverage {
+		return nil
+	}
+
+	var coverageOutputFile android.OptionalPath
+	var unstrippedOutputFile android.Path
+	switch linker := c.linker.(type) {
+	case *binaryDecorator:
+		coverageOutputFile = linker.coverageOutputFile
+		unstrippedOutputFile = linker.unstrippedFile
+	case *libraryDecorator:
+		if !linker.shared() {
+			return nil
+		}
+		coverageOutputFile = linker.coverageOutputFile
+		unstrippedOutputFile = linker.unstrippedFile
+	default:
+		return nil
+	}
+
+	var files android.Paths
+	if coverageOutputFile.Valid() {
+		files = append(files, coverageOutputFile.Path())
+	}
+	if c.coverage.clangCoverage && unstrippedOutputFile != nil {
+		files = append(files, unstrippedOutputFile)
+	}
+	return files
+}
+
+func nativeCoverageSingletonFactory() blueprint.Singleton {
+	return &nativeCoverageSingleton{}
+}
+
+type nativeCoverageSingleton struct{}
+
+// GenerateBuildActions zips the coverage files of all the binaries and shared libraries into
+// native_coverage.zip, keeping their paths relative to the output directory so that the files
+// of the variants of a module don't collide.
+func (s *nativeCoverageSingleton) GenerateBuildActions(ctx blueprint.SingletonContext) {
+	config := ctx.Config().(android.Config)
+	if !Bool(config.ProductVariables.NativeCoverage) {
+		return
+	}
+
+	var files []string
+	ctx.VisitAllModules(func(module blueprint.Module) {
+		if m, ok := module.(*Module); ok && m.Enabled() {
+			files = append(files, m.coverageFiles().Strings()...)
+		}
+	})
+	if len(files) == 0 {
+		return
+	}
+	sort.Strings(files)
+
+	outDir := android.PathForOutput(ctx)
+	zip := android.PathForOutput(ctx, "native_coverage.zip")
+	ctx.Build(pctx, blueprint.BuildParams{
+		Rule:        nativeCoverageZip,
+		Description: "native coverage zip",
+		Outputs:     []string{zip.String()},
+		Inputs:      files,
+		Args: map[string]string{
+			"outDir": outDir.String(),
+		},
+		Optional: true,
+	})
+
+	suffix := ""
+	if config.EmbeddedInMake() {
+		suffix = "-soong"
+	}
+
+	ctx.Build(pctx, blueprint.BuildParams{
+		Rule:      blueprint.Phony,
+		Outputs:   []string{"native-coverage" + suffix},
+		Implicits: []string{zip.String()},
+		Optional:  true,
+	})
+}
@@ -0,0 +1,111 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cc
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/google/blueprint"
+
+	"android/soong/android"
+)
+
+func init() {
+	pctx.HostBinToolVariable("flatcCmd", "flatc")
+}
+
+var (
+	flatc = pctx.AndroidStaticRule("flatc",
+		blueprint.RuleParams{
+			Command:     "$flatcCmd --cpp $flatcFlags -o $outDir $in",
+			CommandDeps: []string{"$flatcCmd"},
+		}, "flatcFlags", "outDir")
+)
+
+// genFlatbuffers generates the header for a flatbuffers schema.  The generated code is header
+// only, so unlike the other generated sources there is nothing to compile.
+func genFlatbuffers(ctx android.ModuleContext, fbsFile android.Path,
+	flatcFlags string) android.ModuleGenPath {
+
+	// flatc names the header after the schema with a _generated suffix, in the same layout as
+	// GenPathWithExt so that flatbuffersSubDir finds it
+	headerFile := android.PathForModuleGen(ctx, "flatbuffers", ctx.ModuleDir(),
+		strings.TrimSuffix(fbsFile.Rel(), fbsFile.Ext())+"_generated.h")
+	ctx.ModuleBuild(pctx, android.ModuleBuildParams{
+		Rule:        flatc,
+		Description: "flatc " + fbsFile.Rel(),
+		Output:      headerFile,
+		Input:       fbsFile,
+		Args: map[string]string{
+			"outDir":     filepath.Dir(headerFile.String()),
+			"flatcFlags": flatcFlags,
+		},
+	})
+
+	return headerFile
+}
+
+// flatbuffersDir returns the module's "gen/flatbuffers" directory
+func flatbuffersDir(ctx android.ModuleContext) android.ModuleGenPath {
+	return android.PathForModuleGen(ctx, "flatbuffers")
+}
+
+// flatbuffersSubDir returns the module's "gen/flatbuffers/path/to/module" directory
+func flatbuffersSubDir(ctx android.ModuleContext) android.ModuleGenPath {
+	return android.PathForModuleGen(ctx, "flatbuffers", ctx.ModuleDir())
+}
+
+type FlatbuffersProperties struct {
+	Flatbuffers struct {
+		// list of directories that will be added to the flatc include paths.
+		Include_dirs []string
+
+		// list of directories relative to the Android.bp file that will
+		// be added to the flatc include paths.
+		Local_include_dirs []string
+	}
+}
+
+func flatbuffersDeps(deps Deps) Deps {
+	// The generated headers include the header only flatbuffers runtime
+	deps.HeaderLibs = append(deps.HeaderLibs, "flatbuffer_headers")
+	deps.ReexportHeaderLibHeaders = append(deps.ReexportHeaderLibHeaders, "flatbuffer_headers")
+
+	return deps
+}
+
+func flatbuffersFlags(ctx ModuleContext, flags Flags, p *FlatbuffersProperties) Flags {
+	flags.GlobalFlags = append(flags.GlobalFlags,
+		"-I"+flatbuffersSubDir(ctx).String(),
+		"-I"+flatbuffersDir(ctx).String(),
+	)
+
+	// flatc doesn't accept the include directory in the same argument as -I
+	if len(p.Flatbuffers.Local_include_dirs) > 0 {
+		localFlatbuffersIncludeDirs := android.PathsForModuleSrc(ctx, p.Flatbuffers.Local_include_dirs)
+		flags.flatcFlags = append(flags.flatcFlags,
+			android.JoinWithPrefix(localFlatbuffersIncludeDirs.Strings(), "-I "))
+	}
+	if len(p.Flatbuffers.Include_dirs) > 0 {
+		rootFlatbuffersIncludeDirs := android.PathsForSource(ctx, p.Flatbuffers.Include_dirs)
+		flags.flatcFlags = append(flags.flatcFlags,
+			android.JoinWithPrefix(rootFlatbuffersIncludeDirs.Strings(), "-I "))
+	}
+
+	flags.flatcFlags = append(flags.flatcFlags, "-I .")
+
+	return flags
+}
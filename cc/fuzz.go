@@ -0,0 +1,259 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cc
+
+// This file contains the cc_fuzz module type, which builds a libFuzzer fuzz target with
+// AddressSanitizer and coverage instrumentation, and installs it to the fuzz directory
+// together with its seed corpus, zipped as <name>_seed_corpus.zip, and its dictionary,
+// as <name>.dict.  The fuzz_packaging singleton zips the fuzz targets of each architecture
+// for the fuzzing infrastructure.
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/google/blueprint"
+	_ "github.com/google/blueprint/bootstrap"
+
+	"android/soong/android"
+)
+
+func init() {
+	android.RegisterModuleType("cc_fuzz", fuzzFactory)
+	android.RegisterSingletonType("fuzz_packaging", fuzzPackagingFactory)
+
+	pctx.Import("github.com/google/blueprint/bootstrap")
+	pctx.StaticVariable("SoongZipCmd", filepath.Join("${bootstrap.ToolDir}", "soong_zip"))
+}
+
+var (
+	fuzzCorpus = pctx.AndroidStaticRule("fuzzCorpus",
+		blueprint.RuleParams{
+			Command:     `${SoongZipCmd} -o $out $zipArgs`,
+			CommandDeps: []string{"${SoongZipCmd}"},
+		},
+		"zipArgs")
+
+	// fuzzPackage stages the fuzz targets of an architecture in a directory per target and
+	// zips them.
+	fuzzPackage = pctx.AndroidStaticRule("fuzzPackage",
+		blueprint.RuleParams{
+			Command: `rm -rf $stagingDir && mkdir -p $stagingDir && $copyCommands && ` +
+				`find $stagingDir -type f > $out.list && ` +
+				`${SoongZipCmd} -o $out -C $stagingDir -l $out.list && ` +
+				`rm -rf $stagingDir $out.list`,
+			CommandDeps: []string{"${SoongZipCmd}"},
+		},
+		"stagingDir", "copyCommands")
+)
+
+type FuzzProperties struct {
+	// list of files or filegroup modules that provide the seed corpus of the fuzz target
+	Corpus []string
+
+	// the libFuzzer dictionary of the fuzz target
+	Dictionary *string
+}
+
+// Module factory for fuzz targets
+func fuzzFactory() android.Module {
+	module := NewFuzz(android.HostAndDeviceSupported)
+	return module.Init()
+}
+
+type fuzzBinary struct {
+	*binaryDecorator
+	Properties FuzzProperties
+
+	corpus     android.Path
+	dictionary android.Path
+}
+
+func (fuzz *fuzzBinary) linkerProps() []interface{} {
+	props := fuzz.binaryDecorator.linkerProps()
+	props = append(props, &fuzz.Properties)
+	return props
+}
+
+func (fuzz *fuzzBinary) linkerInit(ctx BaseModuleContext) {
+	runpath := "../../lib"
+	if ctx.toolchain().Is64Bit() {
+		runpath += "64"
+	}
+	fuzz.baseLinker.dynamicProperties.RunPaths = append(fuzz.baseLinker.dynamicProperties.RunPaths, runpath)
+	fuzz.binaryDecorator.linkerInit(ctx)
+}
+
+func (fuzz *fuzzBinary) linkerDeps(ctx DepsContext, deps Deps) Deps {
+	android.ExtractSourcesDeps(ctx, fuzz.Properties.Corpus)
+	if fuzz.Properties.Dictionary != nil {
+		android.ExtractSourcesDeps(ctx, []string{*fuzz.Properties.Dictionary})
+	}
+
+	deps = fuzz.binaryDecorator.linkerDeps(ctx, deps)
+	deps.StaticLibs = append(deps.StaticLibs, "libFuzzer")
+	return deps
+}
+
+func (fuzz *fuzzBinary) linkerFlags(ctx ModuleContext, flags Flags) Flags {
+	flags = fuzz.binaryDecorator.linkerFlags(ctx, flags)
+	// libFuzzer uses the comparisons and indirect calls of the target in addition to the
+	// coverage enabled by the sanitizer
+	flags.CFlags = append(flags.CFlags, "-fsanitize-coverage=indirect-calls,trace-cmp")
+	return flags
+}
+
+func (fuzz *fuzzBinary) install(ctx ModuleContext, file android.Path) {
+	fuzz.binaryDecorator.baseInstaller.dir = "fuzz"
+	fuzz.binaryDecorator.baseInstaller.dir64 = "fuzz64"
+	fuzz.binaryDecorator.baseInstaller.relative = ctx.ModuleName()
+	fuzz.binaryDecorator.baseInstaller.install(ctx, file)
+
+	fuzz.corpus = nil
+	if corpus := ctx.ExpandSources(fuzz.Properties.Corpus, nil); len(corpus) > 0 {
+		var zipArgs []string
+		for _, f := range corpus {
+			zipArgs = append(zipArgs, "-C "+filepath.Dir(f.String()), "-f "+f.String())
+		}
+
+		zip := android.PathForModuleOut(ctx, ctx.ModuleName()+"_seed_corpus.zip")
+		ctx.ModuleBuild(pctx, android.ModuleBuildParams{
+			Rule:        fuzzCorpus,
+			Description: "fuzz corpus " + ctx.ModuleName(),
+			Output:      zip,
+			Implicits:   corpus,
+			Args: map[string]string{
+				"zipArgs": strings.Join(zipArgs, " "),
+			},
+		})
+		fuzz.corpus = zip
+	}
+
+	fuzz.dictionary = nil
+	if fuzz.Properties.Dictionary != nil {
+		dictionary := android.PathForModuleSrc(ctx, *fuzz.Properties.Dictionary)
+		if dictionary.Ext() != ".dict" {
+			ctx.PropertyErrorf("dictionary", "%q must end in .dict", *fuzz.Properties.Dictionary)
+			return
+		}
+		fuzz.dictionary = dictionary
+	}
+}
+
+func NewFuzz(hod android.HostOrDeviceSupported) *Module {
+	module, binary := NewBinary(hod)
+	binary.baseInstaller = NewFuzzInstaller()
+
+	// libFuzzer finds the inputs that crash the target with AddressSanitizer, guided by the
+	// coverage of the target.  Both can still be disabled for the module.
+	module.sanitize.Properties.Sanitize.Address = boolPtr(true)
+	module.sanitize.Properties.Sanitize.Coverage = boolPtr(true)
+
+	fuzz := &fuzzBinary{
+		binaryDecorator: binary,
+	}
+	module.linker = fuzz
+	module.installer = fuzz
+	return module
+}
+
+func NewFuzzInstaller() *baseInstaller {
+	return NewBaseInstaller("fuzz", "fuzz64", InstallInData)
+}
+
+func fuzzPackagingFactory() blueprint.Singleton {
+	return &fuzzPackagingSingleton{}
+}
+
+type fuzzPackagingSingleton struct{}
+
+// GenerateBuildActions zips the fuzz targets of each architecture, each in a directory named
+// after the module with its seed corpus and dictionary, into fuzz-<os>-<arch>.zip.
+func (s *fuzzPackagingSingleton) GenerateBuildActions(ctx blueprint.SingletonContext) {
+	copyCommands := make(map[string][]string)
+	inputs := make(map[string][]string)
+
+	ctx.VisitAllModules(func(module blueprint.Module) {
+		m, ok := module.(*Module)
+		if !ok || !m.Enabled() || !m.outputFile.Valid() {
+			return
+		}
+		fuzz, ok := m.linker.(*fuzzBinary)
+		if !ok {
+			return
+		}
+
+		target := m.Target().Os.String() + "-" + m.Target().Arch.ArchType.String()
+		dir := fuzzStagingDir(ctx, target).Join(ctx, ctx.ModuleName(module)).String()
+
+		files := []string{m.outputFile.Path().String()}
+		if fuzz.corpus != nil {
+			files = append(files, fuzz.corpus.String())
+		}
+		commands := []string{"mkdir -p " + dir}
+		for _, f := range files {
+			commands = append(commands, "cp -f "+f+" "+dir+"/")
+		}
+		if fuzz.dictionary != nil {
+			files = append(files, fuzz.dictionary.String())
+			commands = append(commands, "cp -f "+fuzz.dictionary.String()+" "+
+				filepath.Join(dir, ctx.ModuleName(module)+".dict"))
+		}
+
+		copyCommands[target] = append(copyCommands[target], commands...)
+		inputs[target] = append(inputs[target], files...)
+	})
+
+	var targets []string
+	for target := range copyCommands {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+
+	var packages []string
+	for _, target := range targets {
+		out := android.PathForOutput(ctx, "fuzz", "fuzz-"+target+".zip")
+		ctx.Build(pctx, blueprint.BuildParams{
+			Rule:        fuzzPackage,
+			Description: "fuzz package " + target,
+			Outputs:     []string{out.String()},
+			Inputs:      inputs[target],
+			Args: map[string]string{
+				"stagingDir":   fuzzStagingDir(ctx, target).String(),
+				"copyCommands": strings.Join(copyCommands[target], " && "),
+			},
+			Optional: true,
+		})
+		packages = append(packages, out.String())
+	}
+
+	suffix := ""
+	if ctx.Config().(android.Config).EmbeddedInMake() {
+		suffix = "-soong"
+	}
+
+	// Create a top-level fuzz target that builds the packages of all architectures
+	ctx.Build(pctx, blueprint.BuildParams{
+		Rule:      blueprint.Phony,
+		Outputs:   []string{"fuzz" + suffix},
+		Implicits: packages,
+		Optional:  true,
+	})
+}
+
+func fuzzStagingDir(ctx android.PathContext, target string) android.OutputPath {
+	return android.PathForOutput(ctx, "fuzz", target)
+}
@@ -0,0 +1,111 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cc
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"android/soong/android"
+	"android/soong/genrule"
+)
+
+func newFuzzFixture(bp string) *android.TestFixture {
+	f := newCcFixture(bp + `
+		cc_library_static {
+			name: "libFuzzer",
+			srcs: ["bar.c"],
+			stl: "none",
+		}
+
+		cc_library_static {
+			name: "libasan",
+			srcs: ["bar.c"],
+			stl: "none",
+		}
+	`)
+	f.RegisterModuleType("cc_fuzz", android.ModuleFactoryAdaptor(fuzzFactory))
+	f.RegisterModuleType("filegroup", android.ModuleFactoryAdaptor(genrule.FileGroupFactory))
+	return f
+}
+
+func TestFuzz(t *testing.T) {
+	f := newFuzzFixture(`
+		cc_fuzz {
+			name: "foo_fuzzer",
+			srcs: ["foo.c"],
+			stl: "none",
+			corpus: ["corpus/a", ":extra_corpus"],
+			dictionary: "foo.dict",
+		}
+
+		filegroup {
+			name: "extra_corpus",
+			srcs: ["extra/b"],
+		}
+	`)
+	f.AddFiles("corpus/a", "extra/b", "foo.dict")
+	f.Prepare(t)
+
+	m := f.ModuleForTests("foo_fuzzer", "android_arm64_armv8-a_core")
+	fuzz := m.Module().(*Module)
+
+	libFuzzer := f.ModuleForTests("libFuzzer", "android_arm64_armv8-a_static_core").Module().(*Module)
+	if ld := m.Rule("ld"); !inList(libFuzzer.outputFile.Path().String(), ld.Implicits.Strings()) {
+		t.Errorf("foo_fuzzer is not linked against libFuzzer: %q", ld.Implicits)
+	}
+	if flag := "-fsanitize-coverage=indirect-calls,trace-cmp"; !inList(flag, fuzz.flags.CFlags) {
+		t.Errorf("foo_fuzzer cflags don't contain %q: %q", flag, fuzz.flags.CFlags)
+	}
+
+	// The fuzz target is installed into a directory named after it, where its shared libraries
+	// are found in ../../lib64
+	installer := fuzz.installer.(*fuzzBinary).baseInstaller
+	if p := installer.installedPath().RelPathString(); !strings.HasSuffix(p, "/data/fuzz64/foo_fuzzer/foo_fuzzer") {
+		t.Errorf("foo_fuzzer is installed to %q", p)
+	}
+	if runPaths := fuzz.linker.(*fuzzBinary).dynamicProperties.RunPaths; !inList("../../lib64", runPaths) {
+		t.Errorf("foo_fuzzer runpaths %q don't contain ../../lib64", runPaths)
+	}
+
+	corpus := m.Rule("fuzzCorpus")
+	if corpus.Output.Base() != "foo_fuzzer_seed_corpus.zip" {
+		t.Errorf("expected the corpus to be zipped into foo_fuzzer_seed_corpus.zip, got %q", corpus.Output.Base())
+	}
+	if g, w := corpus.Implicits.Strings(), []string{"corpus/a", "extra/b"}; !reflect.DeepEqual(g, w) {
+		t.Errorf("expected the corpus %q, got %q", w, g)
+	}
+	if zipArgs := corpus.Args["zipArgs"]; zipArgs != "-C corpus -f corpus/a -C extra -f extra/b" {
+		t.Errorf("unexpected corpus zip args %q", zipArgs)
+	}
+
+	if d := fuzz.linker.(*fuzzBinary).dictionary; d == nil || d.String() != "foo.dict" {
+		t.Errorf("expected the dictionary foo.dict, got %v", d)
+	}
+}
+
+func TestFuzzErrors(t *testing.T) {
+	f := newFuzzFixture(`
+		cc_fuzz {
+			name: "foo_fuzzer",
+			srcs: ["foo.c"],
+			stl: "none",
+			dictionary: "foo.txt",
+		}
+	`)
+	f.AddFiles("foo.txt")
+	android.FailIfNoMatchingErrors(t, `"foo.txt" must end in .dict`, f.PrepareWithErrors())
+}
@@ -19,6 +19,9 @@ package cc
 // functions.
 
 import (
+	"path/filepath"
+	"strings"
+
 	"github.com/google/blueprint"
 
 	"android/soong/android"
@@ -30,6 +33,7 @@ func init() {
 	pctx.SourcePathVariable("yaccDataDir", "external/bison/data")
 
 	pctx.HostBinToolVariable("aidlCmd", "aidl-cpp")
+	pctx.HostBinToolVariable("ragelCmd", "ragel")
 }
 
 var (
@@ -54,6 +58,13 @@ var (
 			Deps:        blueprint.DepsGCC,
 		},
 		"aidlFlags", "outDir")
+
+	ragel = pctx.AndroidStaticRule("ragel",
+		blueprint.RuleParams{
+			Command:     "$ragelCmd $ragelFlags -o $out $in",
+			CommandDeps: []string{"$ragelCmd"},
+		},
+		"ragelFlags")
 )
 
 func genYacc(ctx android.ModuleContext, yaccFile android.Path, outFile android.ModuleGenPath, yaccFlags string) (headerFile android.ModuleGenPath) {
@@ -100,6 +111,25 @@ func genLex(ctx android.ModuleContext, lexFile android.Path, outFile android.Mod
 	})
 }
 
+// ragelOutput returns the file generated from a ragel source, which is named after it, for
+// example foo.cpp.rl generates foo.cpp.
+func ragelOutput(ctx android.ModuleContext, ragelFile android.Path) android.ModuleGenPath {
+	return android.PathForModuleGen(ctx, "ragel", ctx.ModuleDir(),
+		strings.TrimSuffix(ragelFile.Rel(), ragelFile.Ext()))
+}
+
+func genRagel(ctx android.ModuleContext, ragelFile android.Path, outFile android.ModuleGenPath, ragelFlags string) {
+	ctx.ModuleBuild(pctx, android.ModuleBuildParams{
+		Rule:        ragel,
+		Description: "ragel " + ragelFile.Rel(),
+		Output:      outFile,
+		Input:       ragelFile,
+		Args: map[string]string{
+			"ragelFlags": ragelFlags,
+		},
+	})
+}
+
 func genSources(ctx android.ModuleContext, srcFiles android.Paths,
 	buildFlags builderFlags) (android.Paths, android.Paths) {
 
@@ -125,10 +155,25 @@ func genSources(ctx android.ModuleContext, srcFiles android.Paths,
 			cppFile := android.GenPathWithExt(ctx, "lex", srcFile, "cpp")
 			srcFiles[i] = cppFile
 			genLex(ctx, srcFile, cppFile)
+		case ".rl":
+			outFile := ragelOutput(ctx, srcFile)
+			genRagel(ctx, srcFile, outFile, buildFlags.ragelFlags)
+			switch filepath.Ext(outFile.String()) {
+			case ".c", ".cc", ".cpp":
+				srcFiles[i] = outFile
+			case ".h", ".hh", ".hpp":
+				// The .rl file is removed from the sources below
+				deps = append(deps, outFile)
+			default:
+				ctx.PropertyErrorf("srcs", "ragel source %q must be named after the C or C++ "+
+					"file it generates, for example foo.cpp.rl", srcFile.Rel())
+			}
 		case ".proto":
 			cppFile, headerFile := genProto(ctx, srcFile, buildFlags.protoFlags)
 			srcFiles[i] = cppFile
 			deps = append(deps, headerFile)
+		case ".fbs":
+			deps = append(deps, genFlatbuffers(ctx, srcFile, buildFlags.flatcFlags))
 		case ".aidl":
 			cppFile := android.GenPathWithExt(ctx, "aidl", srcFile, "cpp")
 			srcFiles[i] = cppFile
@@ -144,5 +189,14 @@ func genSources(ctx android.ModuleContext, srcFiles android.Paths,
 		deps = append(deps, rsGenerateCpp(ctx, rsFiles, buildFlags.rsFlags)...)
 	}
 
-	return srcFiles, deps
+	// Flatbuffers schemas and ragel sources of headers only generate headers, so they are not
+	// compiled
+	var outFiles android.Paths
+	for _, srcFile := range srcFiles {
+		if srcFile.Ext() != ".fbs" && srcFile.Ext() != ".rl" {
+			outFiles = append(outFiles, srcFile)
+		}
+	}
+
+	return outFiles, deps
 }
@@ -66,7 +66,9 @@ func (installer *baseInstaller) installDir(ctx ModuleContext) android.OutputPath
 	if ctx.toolchain().Is64Bit() && installer.dir64 != "" {
 		dir = installer.dir64
 	}
-	if !ctx.Host() && !ctx.Arch().Native {
+	if ctx.Target().NativeBridge {
+		dir = filepath.Join(dir, ctx.Target().NativeBridgeRelativePath)
+	} else if !ctx.Host() && !ctx.Arch().Native {
 		dir = filepath.Join(dir, ctx.Arch().ArchType.String())
 	}
 	if installer.location == InstallInData && ctx.vndk() {
@@ -79,6 +81,11 @@ func (installer *baseInstaller) install(ctx ModuleContext, file android.Path) {
 	installer.path = ctx.InstallFile(installer.installDir(ctx), file)
 }
 
+// installedPath returns the path the module was installed to.
+func (installer *baseInstaller) installedPath() android.OutputPath {
+	return installer.path
+}
+
 func (installer *baseInstaller) inData() bool {
 	return installer.location == InstallInData
 }
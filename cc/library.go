@@ -15,6 +15,9 @@
 package cc
 
 import (
+	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/google/blueprint"
@@ -23,6 +26,8 @@ import (
 	"android/soong/android"
 )
 
+var symbolNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
 type LibraryProperties struct {
 	Static struct {
 		Srcs   []string `android:"arch_variant"`
@@ -52,6 +57,20 @@ type LibraryProperties struct {
 	// local file name to pass to the linker as -force_symbols_weak_list
 	Force_symbols_weak_list *string `android:"arch_variant"`
 
+	// list of symbols that the shared library doesn't export, hidden with a generated version
+	// script.  Cannot be used together with version_script.
+	Exclude_symbols []string `android:"arch_variant"`
+
+	Stubs struct {
+		// the symbol map of the stable ABI of the shared library, for example libfoo.map.txt
+		Symbol_file *string
+
+		// the API levels to generate stub libraries for from the symbol map.  Other modules
+		// link against the stub library of an API level instead of the library itself with
+		// shared_libs: ["libfoo#<api level>"].
+		Versions []string
+	}
+
 	// rename host libraries to prevent overlap with system installed libraries
 	Unique_host_soname *bool
 
@@ -64,6 +83,21 @@ type LibraryProperties struct {
 		// export headers generated from .proto sources
 		Export_proto_headers bool
 	}
+
+	Flatbuffers struct {
+		// export headers generated from .fbs sources
+		Export_flatbuffers_headers bool
+	}
+
+	Yacc struct {
+		// export headers generated from .y and .yy sources
+		Export_yacc_headers bool
+	}
+
+	Ragel struct {
+		// export headers generated from .rl sources
+		Export_ragel_headers bool
+	}
 }
 
 type LibraryMutatedProperties struct {
@@ -185,6 +219,13 @@ func (f *flagExporter) exportedFlagsDeps() android.Paths {
 	return f.flagsDeps
 }
 
+// stubLibrary is a shared library that only contains the symbols of an API level of a library,
+// generated from its symbol map.
+type stubLibrary struct {
+	outputFile android.Path
+	tocFile    android.OptionalPath
+}
+
 type exportedFlagsProducer interface {
 	exportedFlags() []string
 	exportedFlagsDeps() android.Paths
@@ -206,6 +247,9 @@ type libraryDecorator struct {
 	// table-of-contents file to optimize out relinking when possible
 	tocFile android.OptionalPath
 
+	// stub libraries generated from stubs.symbol_file, by API level
+	stubs map[string]stubLibrary
+
 	flagExporter
 	stripper
 	relocationPacker
@@ -228,6 +272,9 @@ type libraryDecorator struct {
 	// Output archive of gcno coverage information files
 	coverageOutputFile android.OptionalPath
 
+	// The linked output file, before it is stripped
+	unstrippedFile android.Path
+
 	// linked Source Abi Dump
 	sAbiOutputFile android.OptionalPath
 
@@ -427,6 +474,25 @@ func (library *libraryDecorator) linkerInit(ctx BaseModuleContext) {
 }
 
 func (library *libraryDecorator) linkerDeps(ctx DepsContext, deps Deps) Deps {
+	if library.header() {
+		// Header libraries are never linked, they only export their include directories and
+		// those of the header libraries and generated headers they depend on
+		props := library.baseLinker.Properties
+		if len(props.Whole_static_libs) > 0 {
+			ctx.PropertyErrorf("whole_static_libs", "cc_library_headers must not have any whole_static_libs")
+		}
+		if len(props.Static_libs) > 0 {
+			ctx.PropertyErrorf("static_libs", "cc_library_headers must not have any static_libs")
+		}
+		if len(props.Shared_libs) > 0 {
+			ctx.PropertyErrorf("shared_libs", "cc_library_headers must not have any shared_libs")
+		}
+		deps.HeaderLibs = append(deps.HeaderLibs, props.Header_libs...)
+		deps.ReexportHeaderLibHeaders = append(deps.ReexportHeaderLibHeaders, props.Export_header_lib_headers...)
+		deps.ReexportGeneratedHeaders = append(deps.ReexportGeneratedHeaders, props.Export_generated_headers...)
+		return deps
+	}
+
 	deps = library.baseLinker.linkerDeps(ctx, deps)
 
 	if library.static() {
@@ -505,10 +571,23 @@ func (library *libraryDecorator) linkShared(ctx ModuleContext,
 		if forceWeakSymbols.Valid() {
 			ctx.PropertyErrorf("force_symbols_weak_list", "Only supported on Darwin")
 		}
+		if len(library.Properties.Exclude_symbols) > 0 {
+			if versionScript.Valid() {
+				ctx.PropertyErrorf("exclude_symbols",
+					"cannot be used with version_script, make the symbols local in the version script")
+			} else {
+				excludeScript := library.excludeSymbolsVersionScript(ctx)
+				flags.LdFlags = append(flags.LdFlags, "-Wl,--version-script,"+excludeScript.String())
+				linkerDeps = append(linkerDeps, excludeScript)
+			}
+		}
 	} else {
 		if versionScript.Valid() {
 			ctx.PropertyErrorf("version_script", "Not supported on Darwin")
 		}
+		if len(library.Properties.Exclude_symbols) > 0 {
+			ctx.PropertyErrorf("exclude_symbols", "Not supported on Darwin")
+		}
 		if unexportedSymbols.Valid() {
 			flags.LdFlags = append(flags.LdFlags, "-Wl,-unexported_symbols_list,"+unexportedSymbols.String())
 			linkerDeps = append(linkerDeps, unexportedSymbols.Path())
@@ -577,6 +656,8 @@ func (library *libraryDecorator) linkShared(ctx ModuleContext,
 	linkerDeps = append(linkerDeps, deps.LateSharedLibsDeps...)
 	linkerDeps = append(linkerDeps, objs.tidyFiles...)
 
+	library.unstrippedFile = outputFile
+
 	TransformObjToDynamicBinary(ctx, objs.objFiles, sharedLibs,
 		deps.StaticLibs, deps.LateStaticLibs, deps.WholeStaticLibs,
 		linkerDeps, deps.CrtBegin, deps.CrtEnd, false, builderFlags, outputFile)
@@ -614,9 +695,104 @@ func (library *libraryDecorator) linkSAbiDumpFiles(ctx ModuleContext, objs Objec
 		library.sAbiOutputFile = TransformDumpToLinkedDump(ctx, objs.sAbiDumpFiles, soFile, symbolFile, "current", fileName, exportedHeaderFlags)
 		if refSourceDumpFile.Valid() {
 			unzippedRefDump := UnzipRefDump(ctx, refSourceDumpFile.Path(), fileName)
-			library.sAbiDiff = SourceAbiDiff(ctx, library.sAbiOutputFile.Path(), unzippedRefDump, fileName)
+			library.sAbiDiff = SourceAbiDiff(ctx, library.sAbiOutputFile.Path(), unzippedRefDump,
+				refSourceDumpFile.Path(), fileName)
+		}
+	}
+}
+
+// excludeSymbolsVersionScript generates a version script that exports all symbols of the shared
+// library except for the ones in exclude_symbols.
+func (library *libraryDecorator) excludeSymbolsVersionScript(ctx ModuleContext) android.Path {
+	content := []string{"{", "  global:", "    *;", "  local:"}
+	for _, symbol := range library.Properties.Exclude_symbols {
+		if !symbolNameRegexp.MatchString(symbol) {
+			ctx.PropertyErrorf("exclude_symbols", "invalid symbol name %q", symbol)
+			continue
 		}
+		content = append(content, "    "+symbol+";")
 	}
+	content = append(content, "};")
+
+	versionScript := android.PathForModuleGen(ctx, "exclude_symbols.map")
+	ctx.ModuleBuild(pctx, android.ModuleBuildParams{
+		Rule:        android.WriteFile,
+		Description: "generate " + versionScript.Base(),
+		Output:      versionScript,
+		Args: map[string]string{
+			"content": strings.Join(content, `\n`),
+		},
+	})
+
+	return versionScript
+}
+
+// linkStubs links a stub library for each API level in stubs.versions from the symbol map, which
+// other modules link against to only use the stable ABI of the library.
+func (library *libraryDecorator) linkStubs(ctx ModuleContext, flags Flags) {
+	symbolFile := *library.Properties.Stubs.Symbol_file
+	if !strings.HasSuffix(symbolFile, ".map.txt") {
+		ctx.PropertyErrorf("stubs.symbol_file", "must end with .map.txt")
+		return
+	}
+	if len(library.Properties.Stubs.Versions) == 0 {
+		ctx.PropertyErrorf("stubs.versions", "missing API levels to generate stub libraries for")
+		return
+	}
+
+	// The stubs only contain empty functions and variables
+	flags = addStubLibraryCompilerFlags(flags)
+	flags.Tidy = false
+	flags.Coverage = false
+	flags.SAbiDump = false
+
+	library.stubs = make(map[string]stubLibrary)
+	for _, version := range library.Properties.Stubs.Versions {
+		if _, err := strconv.Atoi(version); err != nil && version != "current" {
+			ctx.PropertyErrorf("stubs.versions", "API level must be an integer or current, found %q", version)
+			continue
+		}
+		if _, exists := library.stubs[version]; exists {
+			ctx.PropertyErrorf("stubs.versions", "duplicate API level %q", version)
+			continue
+		}
+
+		subdir := filepath.Join("stubs", version)
+		objs, versionScript := compileStubLibrary(ctx, flags, symbolFile, version, "", subdir)
+
+		stubFlags := flags
+		stubFlags.LdFlags = append(stubFlags.LdFlags, "-Wl,--version-script,"+versionScript.String())
+		builderFlags := flagsToBuilderFlags(stubFlags)
+
+		outputFile := android.PathForModuleOut(ctx, subdir,
+			library.getLibName(ctx)+flags.Toolchain.ShlibSuffix())
+		TransformObjToDynamicBinary(ctx, objs.objFiles, nil, nil, nil, nil,
+			android.Paths{versionScript}, android.OptionalPath{}, android.OptionalPath{}, false,
+			builderFlags, outputFile)
+
+		stub := stubLibrary{outputFile: outputFile}
+		if !ctx.Darwin() && !ctx.Windows() {
+			tocPath := pathtools.ReplaceExtension(outputFile.RelPathString(),
+				flags.Toolchain.ShlibSuffix()[1:]+".toc")
+			tocFile := android.PathForOutput(ctx, tocPath)
+			stub.tocFile = android.OptionalPathForPath(tocFile)
+			TransformSharedObjectToToc(ctx, outputFile, tocFile, builderFlags)
+		}
+		library.stubs[version] = stub
+	}
+}
+
+// stubsProducer is implemented by shared libraries that can have stub libraries.
+type stubsProducer interface {
+	stubsForVersion(version string) (stubLibrary, bool)
+}
+
+var _ stubsProducer = (*libraryDecorator)(nil)
+
+// stubsForVersion returns the stub library of an API level, if the library has one.
+func (library *libraryDecorator) stubsForVersion(version string) (stubLibrary, bool) {
+	stub, ok := library.stubs[version]
+	return stub, ok
 }
 
 func vndkVsNdk(ctx ModuleContext) bool {
@@ -632,10 +808,17 @@ func (library *libraryDecorator) link(ctx ModuleContext,
 	objs = objs.Append(deps.Objs)
 
 	var out android.Path
-	if library.static() || library.header() {
+	if library.header() {
+		// Nothing to link, only the include directories are exported
+	} else if library.static() {
 		out = library.linkStatic(ctx, flags, deps, objs)
 	} else {
 		out = library.linkShared(ctx, flags, deps, objs)
+		if library.Properties.Stubs.Symbol_file != nil {
+			library.linkStubs(ctx, flags)
+		} else if len(library.Properties.Stubs.Versions) > 0 {
+			ctx.PropertyErrorf("stubs.versions", "requires stubs.symbol_file")
+		}
 	}
 
 	library.exportIncludes(ctx, "-I")
@@ -667,6 +850,43 @@ func (library *libraryDecorator) link(ctx ModuleContext,
 		}
 	}
 
+	if library.Properties.Flatbuffers.Export_flatbuffers_headers {
+		if library.baseCompiler.hasSrcExt(".fbs") {
+			flags := []string{
+				"-I" + flatbuffersSubDir(ctx).String(),
+				"-I" + flatbuffersDir(ctx).String(),
+			}
+			library.reexportFlags(flags)
+			library.reuseExportedFlags = append(library.reuseExportedFlags, flags...)
+			library.reexportDeps(library.baseCompiler.deps) // TODO: restrict to flatbuffers deps
+			library.reuseExportedDeps = append(library.reuseExportedDeps, library.baseCompiler.deps...)
+		}
+	}
+
+	if library.Properties.Yacc.Export_yacc_headers {
+		if library.baseCompiler.hasSrcExt(".y") || library.baseCompiler.hasSrcExt(".yy") {
+			flags := []string{
+				"-I" + android.PathForModuleGen(ctx, "yacc", ctx.ModuleDir()).String(),
+			}
+			library.reexportFlags(flags)
+			library.reuseExportedFlags = append(library.reuseExportedFlags, flags...)
+			library.reexportDeps(library.baseCompiler.deps) // TODO: restrict to yacc deps
+			library.reuseExportedDeps = append(library.reuseExportedDeps, library.baseCompiler.deps...)
+		}
+	}
+
+	if library.Properties.Ragel.Export_ragel_headers {
+		if library.baseCompiler.hasSrcExt(".rl") {
+			flags := []string{
+				"-I" + android.PathForModuleGen(ctx, "ragel", ctx.ModuleDir()).String(),
+			}
+			library.reexportFlags(flags)
+			library.reuseExportedFlags = append(library.reuseExportedFlags, flags...)
+			library.reexportDeps(library.baseCompiler.deps) // TODO: restrict to ragel deps
+			library.reuseExportedDeps = append(library.reuseExportedDeps, library.baseCompiler.deps...)
+		}
+	}
+
 	return out
 }
 
@@ -807,5 +1027,10 @@ func linkageMutator(mctx android.BottomUpMutatorContext) {
 				modules[0].(*Module).linker.(libraryInterface).setShared()
 			}
 		}
+	} else if _, ok := linkableLibrary(mctx.Module()); ok {
+		// Libraries built by other languages are always built both static and shared
+		modules := mctx.CreateLocalVariations("static", "shared")
+		modules[0].(LinkableInterface).SetStatic()
+		modules[1].(LinkableInterface).SetShared()
 	}
 }
@@ -0,0 +1,117 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cc
+
+import (
+	"github.com/google/blueprint"
+
+	"android/soong/android"
+)
+
+// LinkableInterface is implemented by the modules of other languages that build libraries with a
+// C interface, like the FFI libraries of rust.  They are split into the same link, image and sdk
+// variants as cc libraries, so that cc modules can list them in static_libs and shared_libs.
+type LinkableInterface interface {
+	android.Module
+
+	// CcLibrary returns true if the module is built as a static and a shared library for cc
+	// modules to link against.
+	CcLibrary() bool
+
+	// SetStatic and SetShared are called on the static and shared variants of the library
+	// created by the link mutator.
+	SetStatic()
+	SetShared()
+	Static() bool
+	Shared() bool
+
+	// OutputFile returns the library that cc modules link against.
+	OutputFile() android.OptionalPath
+
+	// ExportedFlags returns the flags, usually include directories, passed to the compiler of
+	// the cc modules that link against the library, and ExportedFlagsDeps the files that those
+	// compiles depend on.
+	ExportedFlags() []string
+	ExportedFlagsDeps() android.Paths
+}
+
+// linkableLibrary returns the module as a LinkableInterface if it is a library built by another
+// language that cc modules can link against.
+func linkableLibrary(m blueprint.Module) (LinkableInterface, bool) {
+	if _, ok := m.(*Module); ok {
+		return nil, false
+	}
+	l, ok := m.(LinkableInterface)
+	if !ok || !l.CcLibrary() {
+		return nil, false
+	}
+	return l, true
+}
+
+// linkableDepToPaths adds a library built by another language, which a cc module depends on
+// with the dependency tag tag, to the paths of the dependencies of the cc module.
+func (c *Module) linkableDepToPaths(ctx android.ModuleContext, name string, tag blueprint.DependencyTag,
+	l LinkableInterface, depPaths *PathDeps) {
+
+	if t, ok := tag.(dependencyTag); !ok || !t.library {
+		ctx.ModuleErrorf("depends on non-cc module %q", name)
+		return
+	}
+
+	flags := l.ExportedFlags()
+	deps := l.ExportedFlagsDeps()
+	depPaths.Flags = append(depPaths.Flags, flags...)
+	depPaths.GeneratedHeaders = append(depPaths.GeneratedHeaders, deps...)
+	if tag.(dependencyTag).reexportFlags {
+		depPaths.ReexportedFlags = append(depPaths.ReexportedFlags, flags...)
+		depPaths.ReexportedFlagsDeps = append(depPaths.ReexportedFlagsDeps, deps...)
+	}
+
+	outputFile := l.OutputFile()
+	if !outputFile.Valid() {
+		ctx.ModuleErrorf("module %q missing output file", name)
+		return
+	}
+
+	switch tag {
+	case sharedDepTag, sharedExportDepTag, lateSharedDepTag:
+		if !l.Shared() {
+			ctx.ModuleErrorf("module %q not a shared library", name)
+			return
+		}
+		if tag == lateSharedDepTag {
+			depPaths.LateSharedLibs = append(depPaths.LateSharedLibs, outputFile.Path())
+			depPaths.LateSharedLibsDeps = append(depPaths.LateSharedLibsDeps, outputFile.Path())
+		} else {
+			depPaths.SharedLibs = append(depPaths.SharedLibs, outputFile.Path())
+			depPaths.SharedLibsDeps = append(depPaths.SharedLibsDeps, outputFile.Path())
+		}
+		c.Properties.AndroidMkSharedLibs = append(c.Properties.AndroidMkSharedLibs, name)
+	case staticDepTag, staticExportDepTag, lateStaticDepTag:
+		if !l.Static() {
+			ctx.ModuleErrorf("module %q not a static library", name)
+			return
+		}
+		if tag == lateStaticDepTag {
+			depPaths.LateStaticLibs = append(depPaths.LateStaticLibs, outputFile.Path())
+		} else {
+			depPaths.StaticLibs = append(depPaths.StaticLibs, outputFile.Path())
+		}
+	default:
+		// The objects of libraries built by other languages can't be linked in whole, and they
+		// are not header libraries
+		ctx.ModuleErrorf("module %q is not a cc library", name)
+	}
+}
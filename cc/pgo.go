@@ -0,0 +1,229 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cc
+
+import (
+	"fmt"
+	"strings"
+
+	"android/soong/android"
+	"android/soong/cc/config"
+)
+
+// PGO (profile-guided optimization) uses a profile of the module, collected by running benchmarks
+// on an instrumented build (or from sampling with AFDO), to optimize the hot code paths of the
+// module.
+//
+// Modules list the benchmarks that exercise them.  When ANDROID_PGO_INSTRUMENT is set to a comma
+// separated list of benchmarks, or to "all", the modules exercised by them are built with
+// instrumentation to collect a profile.  Otherwise the profile of the module, if it is found in one
+// of the profile directories, is used to optimize the module.
+
+var (
+	// Add flags to ignore warnings that profiles are old or missing for
+	// some functions
+	profileUseOtherFlags = []string{"-Wno-backend-plugin"}
+
+	// Directories that contain the profiles of the modules, in addition to the ones set by the
+	// product
+	globalPgoProfileProjects = []string{
+		"toolchain/pgo-profiles",
+	}
+)
+
+const profileInstrumentFlag = "-fprofile-generate=/data/local/tmp"
+const profileSamplingFlag = "-gline-tables-only"
+const profileUseInstrumentFormat = "-fprofile-use=%s"
+const profileUseSamplingFormat = "-fprofile-sample-use=%s"
+
+type PgoProperties struct {
+	Pgo struct {
+		// profile the module by instrumenting it
+		Instrumentation *bool
+
+		// profile the module by sampling, for AFDO
+		Sampling *bool
+
+		// name of the profile of the module, relative to the profile directories
+		Profile_file *string `android:"arch_variant"`
+
+		// names of the benchmarks that exercise the module, used to select the modules to
+		// instrument with ANDROID_PGO_INSTRUMENT
+		Benchmarks []string
+
+		// set to false to build the module without its profile
+		Enable_profile_use *bool `android:"arch_variant"`
+	} `android:"arch_variant"`
+
+	PgoPresent          bool `blueprint:"mutated"`
+	ShouldProfileModule bool `blueprint:"mutated"`
+}
+
+type pgo struct {
+	Properties PgoProperties
+}
+
+func (props *PgoProperties) isInstrumentation() bool {
+	return Bool(props.Pgo.Instrumentation)
+}
+
+func (props *PgoProperties) isSampling() bool {
+	return Bool(props.Pgo.Sampling)
+}
+
+func (pgo *pgo) props() []interface{} {
+	return []interface{}{&pgo.Properties}
+}
+
+func (props *PgoProperties) addProfileGatherFlags(ctx ModuleContext, flags Flags) Flags {
+	if props.isInstrumentation() {
+		flags.CFlags = append(flags.CFlags, profileInstrumentFlag)
+		// The profile runtime is linked below.  Add the below flag, which is the only other
+		// link-time action performed by the Clang driver during link.
+		flags.LdFlags = append(flags.LdFlags, "-u__llvm_profile_runtime")
+		if runtimeLibrary := config.ProfileRuntimeLibrary(ctx.toolchain()); runtimeLibrary != "" {
+			flags.LdFlags = append(flags.LdFlags, "${config.ClangAsanLibDir}/"+runtimeLibrary+".a")
+		}
+	}
+	if props.isSampling() {
+		flags.CFlags = append(flags.CFlags, profileSamplingFlag)
+		flags.LdFlags = append(flags.LdFlags, profileSamplingFlag)
+	}
+	return flags
+}
+
+func (props *PgoProperties) getPgoProfileFile(ctx ModuleContext) android.OptionalPath {
+	for _, profileProject := range append(globalPgoProfileProjects, ctx.DeviceConfig().PgoAdditionalProfileDirs()...) {
+		path := android.ExistentPathForSource(ctx, "", profileProject, *props.Pgo.Profile_file)
+		if path.Valid() {
+			return path
+		}
+	}
+
+	return android.OptionalPath{}
+}
+
+func (props *PgoProperties) profileUseFlags(file string) []string {
+	var flags []string
+	if props.isInstrumentation() {
+		flags = append(flags, fmt.Sprintf(profileUseInstrumentFormat, file))
+	}
+	if props.isSampling() {
+		flags = append(flags, fmt.Sprintf(profileUseSamplingFormat, file))
+	}
+	return append(flags, profileUseOtherFlags...)
+}
+
+func (props *PgoProperties) addProfileUseFlags(ctx ModuleContext, flags Flags) Flags {
+	// Skip -fprofile-use if 'enable_profile_use' property is set
+	if props.Pgo.Enable_profile_use != nil && !*props.Pgo.Enable_profile_use {
+		return flags
+	}
+
+	// If the profile file is found, add flags to use the profile
+	if profileFile := props.getPgoProfileFile(ctx); profileFile.Valid() {
+		profileUseFlags := props.profileUseFlags(profileFile.String())
+		flags.CFlags = append(flags.CFlags, profileUseFlags...)
+		flags.LdFlags = append(flags.LdFlags, profileUseFlags...)
+
+		// Update CFlagsDeps so the module is rebuilt if the profile gets updated
+		flags.CFlagsDeps = append(flags.CFlagsDeps, profileFile.Path())
+	}
+
+	return flags
+}
+
+func (props *PgoProperties) isPGO(ctx BaseModuleContext) bool {
+	isInstrumentation := props.isInstrumentation()
+	isSampling := props.isSampling()
+
+	profileKindPresent := isInstrumentation || isSampling
+	filePresent := props.Pgo.Profile_file != nil
+	benchmarksPresent := len(props.Pgo.Benchmarks) > 0
+
+	// If all three properties are absent, PGO is OFF for this module
+	if !profileKindPresent && !filePresent && !benchmarksPresent {
+		return false
+	}
+
+	// If at least one property exists, validate that all properties exist
+	if !profileKindPresent || !filePresent || !benchmarksPresent {
+		var missing []string
+		if !profileKindPresent {
+			missing = append(missing, `profile kind (either "instrumentation" or "sampling" property)`)
+		}
+		if !filePresent {
+			missing = append(missing, "profile_file property")
+		}
+		if !benchmarksPresent {
+			missing = append(missing, "non-empty benchmarks property")
+		}
+		ctx.ModuleErrorf("PGO specification is missing properties: %s", strings.Join(missing, ", "))
+		return false
+	}
+
+	if isSampling && isInstrumentation {
+		ctx.PropertyErrorf("pgo", "Exactly one of \"instrumentation\" and \"sampling\" properties must be set")
+		return false
+	}
+
+	return true
+}
+
+func (pgo *pgo) begin(ctx BaseModuleContext) {
+	// TODO Evaluate if we need to support PGO for host modules
+	if ctx.Host() {
+		return
+	}
+
+	// Check if PGO is needed for this module
+	pgo.Properties.PgoPresent = pgo.Properties.isPGO(ctx)
+	if !pgo.Properties.PgoPresent {
+		return
+	}
+
+	// This module should be instrumented if ANDROID_PGO_INSTRUMENT is set and includes "all",
+	// "ALL" or a benchmark listed for this module
+	pgoBenchmarks := ctx.AConfig().Getenv("ANDROID_PGO_INSTRUMENT")
+	for _, b := range strings.Split(pgoBenchmarks, ",") {
+		if b == "all" || b == "ALL" || inList(b, pgo.Properties.Pgo.Benchmarks) {
+			pgo.Properties.ShouldProfileModule = true
+			break
+		}
+	}
+}
+
+func (pgo *pgo) deps(ctx BaseModuleContext, deps Deps) Deps {
+	return deps
+}
+
+func (pgo *pgo) flags(ctx ModuleContext, flags Flags) Flags {
+	if ctx.Host() || !pgo.Properties.PgoPresent {
+		return flags
+	}
+
+	props := pgo.Properties
+
+	// Add flags to profile this module based on its profile kind
+	if props.ShouldProfileModule {
+		return props.addProfileGatherFlags(ctx, flags)
+	}
+
+	if !ctx.AConfig().IsEnvTrue("ANDROID_PGO_NO_PROFILE_USE") {
+		return props.addProfileUseFlags(ctx, flags)
+	}
+
+	return flags
+}
@@ -0,0 +1,189 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cc
+
+import (
+	"testing"
+
+	"android/soong/android"
+)
+
+func TestPgo(t *testing.T) {
+	testCases := []struct {
+		name              string
+		pgo               string
+		env               map[string]string
+		profileDirs       []string
+		cflags, notCflags []string
+	}{
+		{
+			name:      "profile use",
+			pgo:       `instrumentation: true,`,
+			cflags:    []string{"-fprofile-use=toolchain/pgo-profiles/libfoo.profdata", "-Wno-backend-plugin"},
+			notCflags: []string{"-fprofile-generate=/data/local/tmp"},
+		},
+		{
+			name:        "product profile directory",
+			pgo:         `sampling: true,`,
+			profileDirs: []string{"vendor/pgo-profiles"},
+			// The global profile directories are searched first
+			cflags: []string{"-fprofile-sample-use=toolchain/pgo-profiles/libfoo.profdata"},
+		},
+		{
+			name:      "instrumented benchmark",
+			pgo:       `instrumentation: true,`,
+			env:       map[string]string{"ANDROID_PGO_INSTRUMENT": "other,bench"},
+			cflags:    []string{"-fprofile-generate=/data/local/tmp"},
+			notCflags: []string{"-fprofile-use=toolchain/pgo-profiles/libfoo.profdata"},
+		},
+		{
+			name:   "all benchmarks instrumented",
+			pgo:    `sampling: true,`,
+			env:    map[string]string{"ANDROID_PGO_INSTRUMENT": "all"},
+			cflags: []string{"-gline-tables-only"},
+		},
+		{
+			name:      "other benchmark instrumented",
+			pgo:       `instrumentation: true,`,
+			env:       map[string]string{"ANDROID_PGO_INSTRUMENT": "other"},
+			cflags:    []string{"-fprofile-use=toolchain/pgo-profiles/libfoo.profdata"},
+			notCflags: []string{"-fprofile-generate=/data/local/tmp"},
+		},
+		{
+			name:      "profile use disabled",
+			pgo:       `instrumentation: true, enable_profile_use: false,`,
+			notCflags: []string{"-fprofile-use=toolchain/pgo-profiles/libfoo.profdata"},
+		},
+		{
+			name:      "ANDROID_PGO_NO_PROFILE_USE",
+			pgo:       `instrumentation: true,`,
+			env:       map[string]string{"ANDROID_PGO_NO_PROFILE_USE": "true"},
+			notCflags: []string{"-fprofile-use=toolchain/pgo-profiles/libfoo.profdata"},
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			f := newCcFixture(`
+				cc_library_shared {
+					name: "libfoo",
+					srcs: ["foo.c"],
+					stl: "none",
+					pgo: {
+						` + test.pgo + `
+						profile_file: "libfoo.profdata",
+						benchmarks: ["bench"],
+					},
+				}
+			`)
+			f.AddFiles("toolchain/pgo-profiles/libfoo.profdata", "vendor/pgo-profiles/libfoo.profdata")
+			if test.profileDirs != nil {
+				f.Config.ProductVariables.PgoAdditionalProfileDirs = &test.profileDirs
+			}
+			for k, v := range test.env {
+				f.SetEnv(k, v)
+			}
+			f.Prepare(t)
+
+			cflags := f.ModuleForTests("libfoo", "android_arm64_armv8-a_shared_core").Module().(*Module).flags.CFlags
+			for _, flag := range test.cflags {
+				if !inList(flag, cflags) {
+					t.Errorf("libfoo cflags don't contain %q: %q", flag, cflags)
+				}
+			}
+			for _, flag := range test.notCflags {
+				if inList(flag, cflags) {
+					t.Errorf("libfoo cflags contain %q: %q", flag, cflags)
+				}
+			}
+		})
+	}
+}
+
+func TestPgoProfileDeps(t *testing.T) {
+	f := newCcFixture(`
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			stl: "none",
+			pgo: {
+				instrumentation: true,
+				profile_file: "libfoo.profdata",
+				benchmarks: ["bench"],
+			},
+		}
+	`)
+	f.AddFiles("vendor/pgo-profiles/libfoo.profdata")
+	f.Config.ProductVariables.PgoAdditionalProfileDirs = &[]string{"vendor/pgo-profiles"}
+	f.Prepare(t)
+
+	m := f.ModuleForTests("libfoo", "android_arm64_armv8-a_shared_core")
+	flags := m.Module().(*Module).flags
+	profile := "vendor/pgo-profiles/libfoo.profdata"
+	for _, test := range []struct {
+		name  string
+		flags []string
+	}{
+		{"cflags", flags.CFlags},
+		{"ldflags", flags.LdFlags},
+	} {
+		if !inList("-fprofile-use="+profile, test.flags) {
+			t.Errorf("libfoo %s don't use %q: %q", test.name, profile, test.flags)
+		}
+	}
+
+	// The objects are compiled after the profile is built
+	if cc := m.Rule("cc"); !inList(profile, cc.OrderOnly.Strings()) {
+		t.Errorf("libfoo objects don't depend on %q: %q", profile, cc.OrderOnly)
+	}
+}
+
+func TestPgoErrors(t *testing.T) {
+	testCases := []struct {
+		name, pgo, err string
+	}{
+		{
+			name: "missing properties",
+			pgo:  `benchmarks: ["bench"],`,
+			err: `PGO specification is missing properties: profile kind (either "instrumentation" or ` +
+				`"sampling" property), profile_file property`,
+		},
+		{
+			name: "both profile kinds",
+			pgo: `
+				instrumentation: true,
+				sampling: true,
+				profile_file: "libfoo.profdata",
+				benchmarks: ["bench"],`,
+			err: `Exactly one of "instrumentation" and "sampling" properties must be set`,
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			f := newCcFixture(`
+				cc_library_shared {
+					name: "libfoo",
+					srcs: ["foo.c"],
+					stl: "none",
+					pgo: {
+						` + test.pgo + `
+					},
+				}
+			`)
+			android.FailIfNoMatchingErrors(t, test.err, f.PrepareWithErrors())
+		})
+	}
+}
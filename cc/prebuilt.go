@@ -15,6 +15,8 @@
 package cc
 
 import (
+	"github.com/google/blueprint/pathtools"
+
 	"android/soong/android"
 )
 
@@ -58,8 +60,45 @@ func (p *prebuiltLibraryLinker) link(ctx ModuleContext,
 		p.libraryDecorator.exportIncludes(ctx, "-I")
 		p.libraryDecorator.reexportFlags(deps.ReexportedFlags)
 		p.libraryDecorator.reexportDeps(deps.ReexportedFlagsDeps)
-		// TODO(ccross): .toc optimization, stripping, packing
-		return p.Prebuilt.SingleSourcePath(ctx)
+
+		in := p.Prebuilt.SingleSourcePath(ctx)
+
+		if p.static() {
+			if in.Ext() != staticLibraryExtension {
+				ctx.PropertyErrorf("srcs", "%q is not a static library", in)
+				return nil
+			}
+			return in
+		}
+
+		// TODO(ccross): stripping, packing
+		suffix := flags.Toolchain.ShlibSuffix()
+		if in.Ext() != suffix {
+			ctx.PropertyErrorf("srcs", "%q is not a shared library", in)
+			return nil
+		}
+
+		// Copy shared libraries to a name matching the final installed name
+		fileName := p.getLibName(ctx) + suffix
+		outputFile := android.PathForModuleOut(ctx, fileName)
+
+		ctx.ModuleBuild(pctx, android.ModuleBuildParams{
+			Rule:        android.Cp,
+			Description: "prebuilt",
+			Output:      outputFile,
+			Input:       in,
+		})
+
+		if !ctx.Darwin() && !ctx.Windows() {
+			// Dependents relink only when the interface of the prebuilt changes, as they do
+			// for libraries built from source
+			tocPath := pathtools.ReplaceExtension(outputFile.RelPathString(), suffix[1:]+".toc")
+			tocFile := android.PathForOutput(ctx, tocPath)
+			p.tocFile = android.OptionalPathForPath(tocFile)
+			TransformSharedObjectToToc(ctx, outputFile, tocFile, flagsToBuilderFlags(flags))
+		}
+
+		return outputFile
 	}
 
 	return nil
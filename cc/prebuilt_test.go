@@ -0,0 +1,168 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cc
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/google/blueprint"
+
+	"android/soong/android"
+)
+
+func newPrebuiltFixture(bp string) *android.TestFixture {
+	f := newCcFixture(bp)
+	f.RegisterModuleType("cc_prebuilt_library_shared", android.ModuleFactoryAdaptor(prebuiltSharedLibraryFactory))
+	f.RegisterModuleType("cc_prebuilt_library_static", android.ModuleFactoryAdaptor(prebuiltStaticLibraryFactory))
+	f.PreArchMutators(android.RegisterPrebuiltsPreArchMutators)
+	f.PostDepsMutators(android.RegisterPrebuiltsPostDepsMutators)
+	return f
+}
+
+func TestPrebuiltLibrary(t *testing.T) {
+	f := newPrebuiltFixture(`
+		cc_prebuilt_library_shared {
+			name: "libfoo",
+			srcs: ["blobs/libfoo_v2.so"],
+			export_include_dirs: ["include"],
+			stl: "none",
+		}
+
+		cc_prebuilt_library_static {
+			name: "libbar",
+			srcs: ["blobs/libbar.a"],
+			stl: "none",
+		}
+
+		cc_binary {
+			name: "bin",
+			srcs: ["foo.c"],
+			shared_libs: ["libfoo"],
+			static_libs: ["libbar"],
+			stl: "none",
+		}
+	`)
+	f.AddFiles("blobs/libfoo_v2.so", "blobs/libbar.a", "include/foo.h")
+	f.Prepare(t)
+
+	libfoo := f.ModuleForTests("libfoo", "android_arm64_armv8-a_shared_core")
+
+	// The shared library is copied to the name it is installed as, and dependents link against
+	// its .toc
+	cp := libfoo.Rule("Cp")
+	if cp.Input.String() != "blobs/libfoo_v2.so" || cp.Output.Base() != "libfoo.so" {
+		t.Errorf("expected blobs/libfoo_v2.so to be copied to libfoo.so, got %q -> %q", cp.Input, cp.Output)
+	}
+	toc := libfoo.Rule("toc")
+	if toc.Input.String() != cp.Output.String() || toc.Output.Base() != "libfoo.so.toc" {
+		t.Errorf("expected the .toc of %q in libfoo.so.toc, got %q -> %q", cp.Output, toc.Input, toc.Output)
+	}
+
+	bin := f.ModuleForTests("bin", "android_arm64_armv8-a_core")
+	ld := bin.Rule("ld")
+	if !inList(toc.Output.String(), ld.Implicits.Strings()) {
+		t.Errorf("bin doesn't relink when the .toc of libfoo changes: %q", ld.Implicits)
+	}
+	if !inList("blobs/libbar.a", append(ld.Inputs.Strings(), ld.Implicits.Strings()...)) {
+		t.Errorf("bin is not linked against blobs/libbar.a: %q %q", ld.Inputs, ld.Implicits)
+	}
+
+	// The include dirs of the prebuilt are exported to its dependents
+	if !inList("-Iinclude", bin.Module().(*Module).flags.GlobalFlags) {
+		t.Errorf("bin doesn't use the include dirs of libfoo: %q", bin.Module().(*Module).flags.GlobalFlags)
+	}
+}
+
+func TestPrebuiltLibraryPrefer(t *testing.T) {
+	for _, prefer := range []bool{false, true} {
+		f := newPrebuiltFixture(fmt.Sprintf(`
+			cc_library_shared {
+				name: "libfoo",
+				srcs: ["foo.c"],
+				stl: "none",
+			}
+
+			cc_prebuilt_library_shared {
+				name: "libfoo",
+				srcs: ["libfoo.so"],
+				stl: "none",
+				prefer: %v,
+			}
+
+			cc_binary {
+				name: "bin",
+				srcs: ["bar.c"],
+				shared_libs: ["libfoo"],
+				stl: "none",
+			}
+		`, prefer))
+		f.AddFiles("libfoo.so")
+		f.Prepare(t)
+
+		source := f.ModuleForTests("libfoo", "android_arm64_armv8-a_shared_core").Module()
+		prebuilt := f.ModuleForTests("prebuilt_libfoo", "android_arm64_armv8-a_shared_core").Module()
+		expected := source
+		if prefer {
+			expected = prebuilt
+		}
+
+		// Dependents use the prebuilt instead of the source module if it is preferred
+		var linked []android.Module
+		f.VisitDirectDeps(f.ModuleForTests("bin", "android_arm64_armv8-a_core").Module(), func(m blueprint.Module) {
+			if m == source || m == prebuilt {
+				linked = append(linked, m.(android.Module))
+			}
+		})
+		if len(linked) != 1 || linked[0] != expected {
+			t.Errorf("prefer %v: bin depends on %v, want %v", prefer, linked, expected)
+		}
+	}
+}
+
+func TestPrebuiltLibraryErrors(t *testing.T) {
+	testCases := []struct {
+		name, bp, err string
+	}{
+		{
+			name: "shared library",
+			bp: `
+				cc_prebuilt_library_shared {
+					name: "libfoo",
+					srcs: ["libfoo.a"],
+					stl: "none",
+				}`,
+			err: `"libfoo.a" is not a shared library`,
+		},
+		{
+			name: "static library",
+			bp: `
+				cc_prebuilt_library_static {
+					name: "libfoo",
+					srcs: ["libfoo.so"],
+					stl: "none",
+				}`,
+			err: `"libfoo.so" is not a static library`,
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			f := newPrebuiltFixture(test.bp)
+			f.AddFiles("libfoo.a", "libfoo.so")
+			android.FailIfNoMatchingErrors(t, test.err, f.PrepareWithErrors())
+		})
+	}
+}
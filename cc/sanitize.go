@@ -41,6 +41,8 @@ var (
 		"-Wl,-plugin-opt,O1 -Wl,-export-dynamic-symbol=__cfi_check"}
 	cfiArflags = []string{"--plugin ${config.ClangBin}/../lib64/LLVMgold.so"}
 
+	scsCflags = []string{"-ffixed-x18"}
+
 	intOverflowCflags = []string{"-fsanitize-blacklist=build/soong/cc/config/integer_overflow_blacklist.txt"}
 )
 
@@ -91,6 +93,10 @@ type SanitizeProperties struct {
 		Cfi              *bool    `android:"arch_variant"`
 		Integer_overflow *bool    `android:"arch_variant"`
 
+		// ShadowCallStack, which keeps the return addresses on a separate stack.  Only supported
+		// on arm64, ignored on the other architectures.
+		Scs *bool `android:"arch_variant"`
+
 		// Sanitizers to run in the diagnostic mode (as opposed to the release mode).
 		// Replaces abort() on error with a human-readable error message.
 		// Address and Thread sanitizers always run in diagnostic mode.
@@ -104,7 +110,11 @@ type SanitizeProperties struct {
 		// value to pass to -fsanitize-recover=
 		Recover []string
 
-		// value to pass to -fsanitize-blacklist
+		// file listing the functions and source files that are not instrumented by the sanitizers,
+		// passed to -fsanitize-blacklist
+		Blocklist *string
+
+		// deprecated alias of blocklist
 		Blacklist *string
 	} `android:"arch_variant"`
 
@@ -188,6 +198,10 @@ func (sanitize *sanitize) begin(ctx BaseModuleContext) {
 			s.Cfi = boolPtr(true)
 		}
 
+		if found, globalSanitizers = removeFromList("scs", globalSanitizers); found && s.Scs == nil {
+			s.Scs = boolPtr(true)
+		}
+
 		if found, globalSanitizers = removeFromList("integer_overflow", globalSanitizers); found && s.Integer_overflow == nil {
 			if !ctx.AConfig().IntegerOverflowDisabledForPath(ctx.ModuleDir()) {
 				s.Integer_overflow = boolPtr(true)
@@ -203,11 +217,28 @@ func (sanitize *sanitize) begin(ctx BaseModuleContext) {
 			s.Diag.Integer_overflow = boolPtr(true)
 		}
 
+		if found, globalSanitizersDiag = removeFromList("cfi", globalSanitizersDiag); found &&
+			s.Diag.Cfi == nil && Bool(s.Cfi) {
+			s.Diag.Cfi = boolPtr(true)
+		}
+
 		if len(globalSanitizersDiag) > 0 {
 			ctx.ModuleErrorf("unknown global sanitizer diagnostics option %s", globalSanitizersDiag[0])
 		}
 	}
 
+	// The product may enable CFI for the modules in some paths, for example the media and
+	// bluetooth stacks, and disable it in others regardless of the module properties.
+	if ctx.Device() && ctx.clang() {
+		if s.Cfi == nil && ctx.AConfig().CFIEnabledForPath(ctx.ModuleDir()) {
+			s.Cfi = boolPtr(true)
+		}
+		if ctx.AConfig().CFIDisabledForPath(ctx.ModuleDir()) {
+			s.Cfi = nil
+			s.Diag.Cfi = nil
+		}
+	}
+
 	// CFI needs gold linker, and mips toolchain does not have one.
 	if !ctx.AConfig().EnableCFI() || ctx.Arch().ArchType == android.Mips || ctx.Arch().ArchType == android.Mips64 {
 		s.Cfi = nil
@@ -226,6 +257,11 @@ func (sanitize *sanitize) begin(ctx BaseModuleContext) {
 		s.Diag.Cfi = nil
 	}
 
+	// ShadowCallStack needs the x18 register, which is only reserved on arm64.
+	if ctx.Arch().ArchType != android.Arm64 {
+		s.Scs = nil
+	}
+
 	if ctx.staticBinary() {
 		s.Address = nil
 		s.Coverage = nil
@@ -244,7 +280,7 @@ func (sanitize *sanitize) begin(ctx BaseModuleContext) {
 	}
 
 	if ctx.Os() != android.Windows && (Bool(s.All_undefined) || Bool(s.Undefined) || Bool(s.Address) || Bool(s.Thread) ||
-		Bool(s.Coverage) || Bool(s.Safestack) || Bool(s.Cfi) || Bool(s.Integer_overflow) || len(s.Misc_undefined) > 0) {
+		Bool(s.Coverage) || Bool(s.Safestack) || Bool(s.Cfi) || Bool(s.Integer_overflow) || Bool(s.Scs) || len(s.Misc_undefined) > 0) {
 		sanitize.Properties.SanitizerEnabled = true
 	}
 
@@ -372,6 +408,11 @@ func (sanitize *sanitize) flags(ctx ModuleContext, flags Flags) Flags {
 		}
 	}
 
+	if Bool(sanitize.Properties.Sanitize.Scs) {
+		sanitizers = append(sanitizers, "shadow-call-stack")
+		flags.CFlags = append(flags.CFlags, scsCflags...)
+	}
+
 	if Bool(sanitize.Properties.Sanitize.Integer_overflow) {
 		if !ctx.static() {
 			sanitizers = append(sanitizers, "unsigned-integer-overflow")
@@ -434,10 +475,17 @@ func (sanitize *sanitize) flags(ctx ModuleContext, flags Flags) Flags {
 		}
 	}
 
-	blacklist := android.OptionalPathForModuleSrc(ctx, sanitize.Properties.Sanitize.Blacklist)
-	if blacklist.Valid() {
-		flags.CFlags = append(flags.CFlags, "-fsanitize-blacklist="+blacklist.String())
-		flags.CFlagsDeps = append(flags.CFlagsDeps, blacklist.Path())
+	blocklistFile := sanitize.Properties.Sanitize.Blocklist
+	if sanitize.Properties.Sanitize.Blacklist != nil {
+		if blocklistFile != nil {
+			ctx.PropertyErrorf("sanitize.blacklist", "cannot be set together with sanitize.blocklist")
+		}
+		blocklistFile = sanitize.Properties.Sanitize.Blacklist
+	}
+	blocklist := android.OptionalPathForModuleSrc(ctx, blocklistFile)
+	if blocklist.Valid() {
+		flags.CFlags = append(flags.CFlags, "-fsanitize-blacklist="+blocklist.String())
+		flags.CFlagsDeps = append(flags.CFlagsDeps, blocklist.Path())
 	}
 
 	return flags
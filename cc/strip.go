@@ -14,31 +14,73 @@
 
 package cc
 
-import "android/soong/android"
+import (
+	"strings"
 
+	"android/soong/android"
+)
+
+// By default, the symbols and debug information are stripped from the output, except for the
+// compressed mini debug info that is used to symbolize the stack traces of crashes on the
+// device.  The unstripped output is installed to the symbols directory for the stack tool.
 type StripProperties struct {
 	Strip struct {
-		None         bool
+		// if true, the output is not stripped
+		None bool
+
+		// if true, all symbols and debug information are stripped, including the mini debug info
+		All bool
+
+		// if true, only the debug information is stripped and the symbol table is kept
 		Keep_symbols bool
+
+		// list of symbols to keep in the symbol table, all other symbols and the debug
+		// information are stripped
+		Keep_symbols_list []string
 	}
 }
 
 type stripper struct {
 	StripProperties StripProperties
+
+	// the output of the linker, before it was stripped
+	unstripped android.Path
 }
 
 func (stripper *stripper) needsStrip(ctx ModuleContext) bool {
-	return !ctx.AConfig().EmbeddedInMake() && !stripper.StripProperties.Strip.None
+	strip := &stripper.StripProperties.Strip
+	set := 0
+	for _, b := range []bool{strip.None, strip.All, strip.Keep_symbols, len(strip.Keep_symbols_list) > 0} {
+		if b {
+			set++
+		}
+	}
+	if set > 1 {
+		ctx.PropertyErrorf("strip", "only one of none, all, keep_symbols and keep_symbols_list may be set")
+	}
+
+	return !ctx.AConfig().EmbeddedInMake() && !strip.None
 }
 
 func (stripper *stripper) strip(ctx ModuleContext, in, out android.ModuleOutPath,
 	flags builderFlags) {
+	stripper.unstripped = in
 	if ctx.Darwin() {
 		TransformDarwinStrip(ctx, in, out)
 	} else {
-		flags.stripKeepSymbols = stripper.StripProperties.Strip.Keep_symbols
-		// TODO(ccross): don't add gnu debuglink for user builds
-		flags.stripAddGnuDebuglink = true
+		strip := &stripper.StripProperties.Strip
+		flags.stripKeepSymbols = strip.Keep_symbols
+		flags.stripKeepSymbolsList = strings.Join(strip.Keep_symbols_list, ",")
+		flags.stripKeepMiniDebugInfo = !strip.All && !strip.Keep_symbols &&
+			len(strip.Keep_symbols_list) == 0
+		// The mini debug info replaces the link to the unstripped file
+		flags.stripAddGnuDebuglink = !flags.stripKeepMiniDebugInfo
 		TransformStrip(ctx, in, out, flags)
 	}
 }
+
+// unstrippedOutputFile returns the output of the linker before it was stripped, or nil if it
+// was not stripped.
+func (stripper *stripper) unstrippedOutputFile() android.Path {
+	return stripper.unstripped
+}
@@ -19,6 +19,8 @@ import (
 	"runtime"
 	"strings"
 
+	"github.com/google/blueprint"
+
 	"android/soong/android"
 )
 
@@ -44,9 +46,27 @@ type TestBinaryProperties struct {
 	// list of compatibility suites (for example "cts", "vts") that the module should be
 	// installed into.
 	Test_suites []string
+
+	// the Tradefed configuration of the test.  Defaults to AndroidTest.xml in the module
+	// directory, or to a generated configuration that runs the gtest binaries of the module if
+	// there is none.
+	Test_config *string
 }
 
+// autogenTestConfig generates the Tradefed configuration of a test or benchmark from
+// $testConfigTemplate.
+var autogenTestConfig = pctx.AndroidStaticRule("autogenTestConfig",
+	blueprint.RuleParams{
+		Command:     `sed -e 's&{MODULE}&$module&g' -e 's&{TEST_DIR}&$testDir&g' $testConfigTemplate > $out`,
+		CommandDeps: []string{"$nativeTestConfigTemplate", "$nativeBenchmarkConfigTemplate"},
+	},
+	"testConfigTemplate", "module", "testDir")
+
 func init() {
+	pctx.SourcePathVariable("nativeTestConfigTemplate", "build/soong/cc/native_test_config_template.xml")
+	pctx.SourcePathVariable("nativeBenchmarkConfigTemplate",
+		"build/soong/cc/native_benchmark_config_template.xml")
+
 	android.RegisterModuleType("cc_test", testFactory)
 	android.RegisterModuleType("cc_test_library", testLibraryFactory)
 	android.RegisterModuleType("cc_benchmark", benchmarkFactory)
@@ -198,6 +218,7 @@ type testBinary struct {
 	*baseCompiler
 	Properties TestBinaryProperties
 	data       android.Paths
+	testConfig android.Path
 }
 
 func (test *testBinary) linkerProps() []interface{} {
@@ -238,6 +259,51 @@ func (test *testBinary) install(ctx ModuleContext, file android.Path) {
 	}
 
 	test.binaryDecorator.baseInstaller.install(ctx, file)
+
+	// Only gtests can be run by the generated configuration
+	test.testConfig = testConfig(ctx, test.Properties.Test_config,
+		"${nativeTestConfigTemplate}", test.binaryDecorator.baseInstaller.path, test.gtest())
+}
+
+// testConfig returns the Tradefed configuration of a test or benchmark: the test_config
+// property if it is set, the AndroidTest.xml in the module directory if there is one, or else a
+// configuration generated from template for device modules if autogen is true.  The generated
+// configuration runs the binary at installed, which is not named after the module for
+// test_per_src tests.
+func testConfig(ctx ModuleContext, property *string, template string, installed android.OutputPath,
+	autogen bool) android.Path {
+
+	if property != nil {
+		return android.PathForModuleSrc(ctx, *property)
+	}
+	if config := android.ExistentPathForSource(ctx, "", ctx.ModuleDir(), "AndroidTest.xml"); config.Valid() {
+		return config.Path()
+	}
+	if !ctx.Device() || !autogen {
+		return nil
+	}
+
+	// The install path is relative to the product out directory, which is the root of the
+	// device.
+	productOut := filepath.Join("target", "product", ctx.AConfig().DeviceName())
+	testDir, err := filepath.Rel(productOut, filepath.Dir(installed.RelPathString()))
+	if err != nil {
+		panic(err)
+	}
+	module := installed.Base()
+
+	config := android.PathForModuleOut(ctx, module+".config")
+	ctx.ModuleBuild(pctx, android.ModuleBuildParams{
+		Rule:        autogenTestConfig,
+		Description: "test config " + module,
+		Output:      config,
+		Args: map[string]string{
+			"testConfigTemplate": template,
+			"module":             module,
+			"testDir":            "/" + testDir,
+		},
+	})
+	return config
 }
 
 func NewTest(hod android.HostOrDeviceSupported) *Module {
@@ -305,12 +371,17 @@ type BenchmarkProperties struct {
 	// list of compatibility suites (for example "cts", "vts") that the module should be
 	// installed into.
 	Test_suites []string
+
+	// the Tradefed configuration of the benchmark.  Defaults to AndroidTest.xml in the module
+	// directory, or to a generated configuration that runs the benchmark if there is none.
+	Test_config *string
 }
 
 type benchmarkDecorator struct {
 	*binaryDecorator
 	Properties BenchmarkProperties
 	data       android.Paths
+	testConfig android.Path
 }
 
 func (benchmark *benchmarkDecorator) linkerInit(ctx BaseModuleContext) {
@@ -337,9 +408,12 @@ func (benchmark *benchmarkDecorator) linkerDeps(ctx DepsContext, deps Deps) Deps
 
 func (benchmark *benchmarkDecorator) install(ctx ModuleContext, file android.Path) {
 	benchmark.data = ctx.ExpandSources(benchmark.Properties.Data, nil)
-	benchmark.binaryDecorator.baseInstaller.dir = filepath.Join("nativetest", ctx.ModuleName())
-	benchmark.binaryDecorator.baseInstaller.dir64 = filepath.Join("nativetest64", ctx.ModuleName())
+	benchmark.binaryDecorator.baseInstaller.dir = filepath.Join("benchmarktest", ctx.ModuleName())
+	benchmark.binaryDecorator.baseInstaller.dir64 = filepath.Join("benchmarktest64", ctx.ModuleName())
 	benchmark.binaryDecorator.baseInstaller.install(ctx, file)
+
+	benchmark.testConfig = testConfig(ctx, benchmark.Properties.Test_config,
+		"${nativeBenchmarkConfigTemplate}", benchmark.binaryDecorator.baseInstaller.path, true)
 }
 
 func NewBenchmark(hod android.HostOrDeviceSupported) *Module {
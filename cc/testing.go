@@ -0,0 +1,130 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cc
+
+import (
+	"android/soong/android"
+)
+
+// NewTestFixture returns a test fixture for the device and host targets with the cc module types
+// and mutators registered, and the libraries and objects that cc modules depend on by default
+// defined.  It is also used by the tests of the module types that link against cc libraries.
+func NewTestFixture(buildDir string) *android.TestFixture {
+	f := android.NewTestArchFixture(buildDir)
+	// The cc toolchains are selected by the architecture variant
+	f.Config.Targets[android.Device][0].Arch.ArchVariant = "armv8-a"
+	f.Config.Targets[android.Device][1].Arch.ArchVariant = "armv7-a-neon"
+
+	f.RegisterModuleType("cc_binary", android.ModuleFactoryAdaptor(binaryFactory))
+	f.RegisterModuleType("cc_library", android.ModuleFactoryAdaptor(libraryFactory))
+	f.RegisterModuleType("cc_library_shared", android.ModuleFactoryAdaptor(librarySharedFactory))
+	f.RegisterModuleType("cc_library_static", android.ModuleFactoryAdaptor(libraryStaticFactory))
+	f.RegisterModuleType("cc_object", android.ModuleFactoryAdaptor(objectFactory))
+	f.RegisterModuleType("ndk_prebuilt_library", android.ModuleFactoryAdaptor(ndkPrebuiltLibraryFactory))
+	f.RegisterModuleType("toolchain_library", android.ModuleFactoryAdaptor(toolchainLibraryFactory))
+	f.PreDepsMutators(func(ctx android.RegisterMutatorsContext) {
+		ctx.BottomUp("link", linkageMutator).Parallel()
+		ctx.BottomUp("vndk", vndkMutator).Parallel()
+		ctx.BottomUp("image", vendorMutator).Parallel()
+		ctx.BottomUp("sdk", sdkMutator).Parallel()
+		ctx.BottomUp("ndk_api", ndkApiMutator).Parallel()
+		ctx.BottomUp("test_per_src", testPerSrcMutator).Parallel()
+		ctx.BottomUp("begin", beginMutator).Parallel()
+	})
+	f.PostDepsMutators(func(ctx android.RegisterMutatorsContext) {
+		ctx.BottomUp("coverage", coverageLinkingMutator).Parallel()
+		ctx.TopDown("lto_deps", ltoDepsMutator)
+		ctx.BottomUp("lto", ltoMutator).Parallel()
+	})
+
+	f.AddBlueprint(`
+		toolchain_library {
+			name: "libatomic",
+			vendor_available: true,
+		}
+
+		toolchain_library {
+			name: "libcompiler_rt-extras",
+			vendor_available: true,
+		}
+
+		toolchain_library {
+			name: "libgcc",
+			vendor_available: true,
+		}
+
+		cc_library {
+			name: "libc",
+			no_libgcc: true,
+			nocrt: true,
+			system_shared_libs: [],
+			stl: "none",
+		}
+
+		cc_library {
+			name: "libm",
+			no_libgcc: true,
+			nocrt: true,
+			system_shared_libs: [],
+			stl: "none",
+		}
+
+		cc_library {
+			name: "libdl",
+			no_libgcc: true,
+			nocrt: true,
+			system_shared_libs: [],
+			stl: "none",
+		}
+
+		cc_library {
+			name: "libc++",
+			no_libgcc: true,
+			nocrt: true,
+			system_shared_libs: [],
+			stl: "none",
+		}
+
+		cc_library_static {
+			name: "libunwind_llvm",
+			no_libgcc: true,
+			nocrt: true,
+			system_shared_libs: [],
+			stl: "none",
+		}
+
+		cc_object {
+			name: "crtbegin_so",
+		}
+
+		cc_object {
+			name: "crtend_so",
+		}
+
+		cc_object {
+			name: "crtbegin_dynamic",
+		}
+
+		cc_object {
+			name: "crtbegin_static",
+		}
+
+		cc_object {
+			name: "crtend_android",
+		}
+	`)
+
+	return f
+}
@@ -48,6 +48,8 @@ func toolchainLibraryFactory() android.Module {
 	module.Properties.Clang = proptools.BoolPtr(false)
 	module.stl = nil
 	module.sanitize = nil
+	// Toolchain libraries are prebuilt, they have no bitcode for LTO
+	module.lto = nil
 	module.installer = nil
 	return module.Init()
 }
@@ -95,6 +95,20 @@ func moduleToLibName(module string) (string, error) {
 	return matches[1], nil
 }
 
+// splitStubsVersionsList splits libfoo#<api level> entries in a list of shared libraries into the
+// library names and their API levels, which are empty for the entries without one.
+func splitStubsVersionsList(libs []string) (names, versions []string) {
+	for _, lib := range libs {
+		name, version := lib, ""
+		if i := strings.IndexByte(lib, '#'); i >= 0 {
+			name, version = lib[:i], lib[i+1:]
+		}
+		names = append(names, name)
+		versions = append(versions, version)
+	}
+	return names, versions
+}
+
 func flagsToBuilderFlags(in Flags) builderFlags {
 	return builderFlags{
 		globalFlags:   strings.Join(in.GlobalFlags, " "),
@@ -105,7 +119,9 @@ func flagsToBuilderFlags(in Flags) builderFlags {
 		conlyFlags:    strings.Join(in.ConlyFlags, " "),
 		cppFlags:      strings.Join(in.CppFlags, " "),
 		yaccFlags:     strings.Join(in.YaccFlags, " "),
+		ragelFlags:    strings.Join(in.RagelFlags, " "),
 		protoFlags:    strings.Join(in.protoFlags, " "),
+		flatcFlags:    strings.Join(in.flatcFlags, " "),
 		aidlFlags:     strings.Join(in.aidlFlags, " "),
 		rsFlags:       strings.Join(in.rsFlags, " "),
 		ldFlags:       strings.Join(in.LdFlags, " "),
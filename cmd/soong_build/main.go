@@ -25,6 +25,22 @@ import (
 	"android/soong/android"
 )
 
+// newNameResolver returns the NameResolver that exports the modules in the root namespace and in
+// the namespaces listed in PRODUCT_SOONG_NAMESPACES to Make.
+func newNameResolver(config android.Config) *android.NameResolver {
+	namespacePathsToExport := make(map[string]bool)
+	for _, namespaceName := range config.ProductVariables.NamespacesToExport {
+		namespacePathsToExport[namespaceName] = true
+	}
+	namespacePathsToExport["."] = true
+
+	exportFilter := func(namespace *android.Namespace) bool {
+		return namespacePathsToExport[namespace.Path]
+	}
+
+	return android.NewNameResolver(exportFilter)
+}
+
 func main() {
 	flag.Parse()
 
@@ -43,6 +59,8 @@ func main() {
 	// Temporary hack
 	//ctx.SetIgnoreUnknownModuleTypes(true)
 
+	ctx.SetNameInterface(newNameResolver(configuration))
+
 	ctx.SetAllowMissingDependencies(configuration.AllowMissingDependencies())
 
 	bootstrap.Main(ctx.Context, configuration, configuration.ConfigFileName, configuration.ProductVariablesFileName)
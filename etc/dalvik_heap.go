@@ -0,0 +1,322 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etc
+
+// This file contains the dalvik_heap_config module type, which generates the dalvik.vm heap and
+// dex2oat memory properties of a device from the amount of RAM it has, instead of each device
+// tree copying one of the dalvik-heap makefile fragments and editing it.  Individual values can
+// be overridden, and the resulting configuration is checked for consistency, for example that
+// the growth limit is not larger than the heap size.  The properties are added to build.prop
+// when the module is in PRODUCT_PACKAGES.
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"android/soong/android"
+)
+
+func init() {
+	android.RegisterModuleType("dalvik_heap_config", DalvikHeapConfigFactory)
+}
+
+// dalvikHeapDefaults contains the properties for each RAM class, based on the
+// frameworks/native/build/phone-*-dalvik-heap.mk fragments.
+var dalvikHeapDefaults = map[string]dalvikHeapValues{
+	"512m": {
+		Heapstartsize:         "5m",
+		Heapgrowthlimit:       "48m",
+		Heapsize:              "128m",
+		Heaptargetutilization: "0.75",
+		Heapminfree:           "512k",
+		Heapmaxfree:           "2m",
+		Dex2oat_xms:           "64m",
+		Dex2oat_xmx:           "256m",
+		Image_dex2oat_xms:     "64m",
+		Image_dex2oat_xmx:     "64m",
+	},
+	"1g": {
+		Heapstartsize:         "8m",
+		Heapgrowthlimit:       "96m",
+		Heapsize:              "256m",
+		Heaptargetutilization: "0.75",
+		Heapminfree:           "512k",
+		Heapmaxfree:           "8m",
+		Dex2oat_xms:           "64m",
+		Dex2oat_xmx:           "256m",
+		Image_dex2oat_xms:     "64m",
+		Image_dex2oat_xmx:     "64m",
+	},
+	"2g": {
+		Heapstartsize:         "16m",
+		Heapgrowthlimit:       "192m",
+		Heapsize:              "512m",
+		Heaptargetutilization: "0.75",
+		Heapminfree:           "2m",
+		Heapmaxfree:           "8m",
+		Dex2oat_xms:           "64m",
+		Dex2oat_xmx:           "512m",
+		Image_dex2oat_xms:     "64m",
+		Image_dex2oat_xmx:     "64m",
+	},
+	"3g": {
+		Heapstartsize:         "8m",
+		Heapgrowthlimit:       "288m",
+		Heapsize:              "768m",
+		Heaptargetutilization: "0.75",
+		Heapminfree:           "512k",
+		Heapmaxfree:           "8m",
+		Dex2oat_xms:           "64m",
+		Dex2oat_xmx:           "512m",
+		Image_dex2oat_xms:     "64m",
+		Image_dex2oat_xmx:     "64m",
+	},
+	"4g": {
+		Heapstartsize:         "8m",
+		Heapgrowthlimit:       "192m",
+		Heapsize:              "512m",
+		Heaptargetutilization: "0.6",
+		Heapminfree:           "8m",
+		Heapmaxfree:           "16m",
+		Dex2oat_xms:           "64m",
+		Dex2oat_xmx:           "512m",
+		Image_dex2oat_xms:     "64m",
+		Image_dex2oat_xmx:     "64m",
+	},
+	"6g": {
+		Heapstartsize:         "16m",
+		Heapgrowthlimit:       "256m",
+		Heapsize:              "512m",
+		Heaptargetutilization: "0.5",
+		Heapminfree:           "8m",
+		Heapmaxfree:           "32m",
+		Dex2oat_xms:           "64m",
+		Dex2oat_xmx:           "512m",
+		Image_dex2oat_xms:     "64m",
+		Image_dex2oat_xmx:     "64m",
+	},
+	"8g": {
+		Heapstartsize:         "24m",
+		Heapgrowthlimit:       "256m",
+		Heapsize:              "512m",
+		Heaptargetutilization: "0.46",
+		Heapminfree:           "8m",
+		Heapmaxfree:           "48m",
+		Dex2oat_xms:           "64m",
+		Dex2oat_xmx:           "512m",
+		Image_dex2oat_xms:     "64m",
+		Image_dex2oat_xmx:     "64m",
+	},
+}
+
+var (
+	dalvikSizeRegexp        = regexp.MustCompile(`^([0-9]+)([kmg])$`)
+	dalvikUtilizationRegexp = regexp.MustCompile(`^0?\.[0-9]+$`)
+)
+
+// dalvikHeapValues contains the values of the generated properties, as sizes with a k, m or g
+// suffix, except for the target utilization, which is a fraction.
+type dalvikHeapValues struct {
+	// dalvik.vm.heapstartsize, the initial size of the heap of an app
+	Heapstartsize string
+
+	// dalvik.vm.heapgrowthlimit, the maximum size of the heap of an app that doesn't set
+	// android:largeHeap
+	Heapgrowthlimit string
+
+	// dalvik.vm.heapsize, the maximum size of the heap of an app that sets android:largeHeap
+	Heapsize string
+
+	// dalvik.vm.heaptargetutilization, the fraction of the heap that is kept in use after a
+	// garbage collection
+	Heaptargetutilization string
+
+	// dalvik.vm.heapminfree, the minimum amount of free memory kept after a garbage collection
+	Heapminfree string
+
+	// dalvik.vm.heapmaxfree, the maximum amount of free memory kept after a garbage collection
+	Heapmaxfree string
+
+	// dalvik.vm.dex2oat-Xms and dalvik.vm.dex2oat-Xmx, the initial and maximum heap size of
+	// dex2oat when compiling apps
+	Dex2oat_xms string
+	Dex2oat_xmx string
+
+	// dalvik.vm.image-dex2oat-Xms and dalvik.vm.image-dex2oat-Xmx, the initial and maximum heap
+	// size of dex2oat when compiling the boot image
+	Image_dex2oat_xms string
+	Image_dex2oat_xmx string
+}
+
+type dalvikHeapConfigProperties struct {
+	// the amount of RAM of the device, one of 512m, 1g, 2g, 3g, 4g, 6g or 8g, which selects the
+	// default values of the properties
+	Ram_class *string
+
+	// values that replace the defaults of the RAM class
+	Overrides dalvikHeapValues
+}
+
+type dalvikHeapConfig struct {
+	android.ModuleBase
+
+	properties dalvikHeapConfigProperties
+
+	buildProperties []string
+}
+
+func DalvikHeapConfigFactory() android.Module {
+	module := &dalvikHeapConfig{}
+	module.AddProperties(&module.properties)
+	android.InitAndroidArchModule(module, android.DeviceSupported, android.MultilibCommon)
+	return module
+}
+
+func (m *dalvikHeapConfig) DepsMutator(ctx android.BottomUpMutatorContext) {
+}
+
+func (m *dalvikHeapConfig) GenerateAndroidBuildActions(ctx android.ModuleContext) {
+	if m.properties.Ram_class == nil {
+		ctx.PropertyErrorf("ram_class", "missing RAM class")
+		return
+	}
+	values, ok := dalvikHeapDefaults[*m.properties.Ram_class]
+	if !ok {
+		var classes []string
+		for class := range dalvikHeapDefaults {
+			classes = append(classes, class)
+		}
+		sort.Slice(classes, func(i, j int) bool {
+			return parseDalvikSize(classes[i]) < parseDalvikSize(classes[j])
+		})
+		ctx.PropertyErrorf("ram_class", "unknown RAM class %q, must be one of %s",
+			*m.properties.Ram_class, strings.Join(classes, ", "))
+		return
+	}
+
+	overrides := m.properties.Overrides
+	override := func(value *string, override string) {
+		if override != "" {
+			*value = override
+		}
+	}
+	override(&values.Heapstartsize, overrides.Heapstartsize)
+	override(&values.Heapgrowthlimit, overrides.Heapgrowthlimit)
+	override(&values.Heapsize, overrides.Heapsize)
+	override(&values.Heaptargetutilization, overrides.Heaptargetutilization)
+	override(&values.Heapminfree, overrides.Heapminfree)
+	override(&values.Heapmaxfree, overrides.Heapmaxfree)
+	override(&values.Dex2oat_xms, overrides.Dex2oat_xms)
+	override(&values.Dex2oat_xmx, overrides.Dex2oat_xmx)
+	override(&values.Image_dex2oat_xms, overrides.Image_dex2oat_xms)
+	override(&values.Image_dex2oat_xmx, overrides.Image_dex2oat_xmx)
+
+	sizes := []struct {
+		property, value string
+	}{
+		{"overrides.heapstartsize", values.Heapstartsize},
+		{"overrides.heapgrowthlimit", values.Heapgrowthlimit},
+		{"overrides.heapsize", values.Heapsize},
+		{"overrides.heapminfree", values.Heapminfree},
+		{"overrides.heapmaxfree", values.Heapmaxfree},
+		{"overrides.dex2oat_xms", values.Dex2oat_xms},
+		{"overrides.dex2oat_xmx", values.Dex2oat_xmx},
+		{"overrides.image_dex2oat_xms", values.Image_dex2oat_xms},
+		{"overrides.image_dex2oat_xmx", values.Image_dex2oat_xmx},
+	}
+	for _, size := range sizes {
+		if !dalvikSizeRegexp.MatchString(size.value) {
+			ctx.PropertyErrorf(size.property, "%q is not a size such as 512k, 16m or 1g", size.value)
+		}
+	}
+	if !dalvikUtilizationRegexp.MatchString(values.Heaptargetutilization) {
+		ctx.PropertyErrorf("overrides.heaptargetutilization", "%q is not a fraction between 0 and 1",
+			values.Heaptargetutilization)
+	}
+	if ctx.Failed() {
+		return
+	}
+
+	notLarger := func(property, small, large, largeName string) {
+		if parseDalvikSize(small) > parseDalvikSize(large) {
+			ctx.PropertyErrorf(property, "%s is larger than %s %s", small, largeName, large)
+		}
+	}
+	notLarger("overrides.heapstartsize", values.Heapstartsize, values.Heapgrowthlimit, "heapgrowthlimit")
+	notLarger("overrides.heapgrowthlimit", values.Heapgrowthlimit, values.Heapsize, "heapsize")
+	notLarger("overrides.heapminfree", values.Heapminfree, values.Heapmaxfree, "heapmaxfree")
+	notLarger("overrides.heapmaxfree", values.Heapmaxfree, values.Heapsize, "heapsize")
+	notLarger("overrides.dex2oat_xms", values.Dex2oat_xms, values.Dex2oat_xmx, "dex2oat_xmx")
+	notLarger("overrides.image_dex2oat_xms", values.Image_dex2oat_xms, values.Image_dex2oat_xmx,
+		"image_dex2oat_xmx")
+	if ctx.Failed() {
+		return
+	}
+
+	m.buildProperties = []string{
+		"dalvik.vm.heapstartsize=" + values.Heapstartsize,
+		"dalvik.vm.heapgrowthlimit=" + values.Heapgrowthlimit,
+		"dalvik.vm.heapsize=" + values.Heapsize,
+		"dalvik.vm.heaptargetutilization=" + values.Heaptargetutilization,
+		"dalvik.vm.heapminfree=" + values.Heapminfree,
+		"dalvik.vm.heapmaxfree=" + values.Heapmaxfree,
+		"dalvik.vm.dex2oat-Xms=" + values.Dex2oat_xms,
+		"dalvik.vm.dex2oat-Xmx=" + values.Dex2oat_xmx,
+		"dalvik.vm.image-dex2oat-Xms=" + values.Image_dex2oat_xms,
+		"dalvik.vm.image-dex2oat-Xmx=" + values.Image_dex2oat_xmx,
+	}
+}
+
+// parseDalvikSize returns the number of bytes of a size that matches dalvikSizeRegexp.
+func parseDalvikSize(size string) int64 {
+	match := dalvikSizeRegexp.FindStringSubmatch(size)
+	if match == nil {
+		return 0
+	}
+	n, _ := strconv.ParseInt(match[1], 10, 64)
+	switch match[2] {
+	case "g":
+		n *= 1024
+		fallthrough
+	case "m":
+		n *= 1024
+		fallthrough
+	case "k":
+		n *= 1024
+	}
+	return n
+}
+
+func (m *dalvikHeapConfig) AndroidMk() android.AndroidMkData {
+	return android.AndroidMkData{
+		Custom: func(w io.Writer, name, prefix, moduleDir string, data android.AndroidMkData) {
+			// Only the configuration that the product installs is added to build.prop.
+			// Properties that the product already sets in PRODUCT_PROPERTY_OVERRIDES take
+			// precedence, because the first definition of a property in build.prop is kept
+			fmt.Fprintf(w, "\nifneq (,$(filter %s,$(PRODUCTS.$(INTERNAL_PRODUCT).PRODUCT_PACKAGES)))\n", name)
+			fmt.Fprintln(w, "ADDITIONAL_BUILD_PROPERTIES +=", strings.Join(m.buildProperties, " "))
+			fmt.Fprintln(w, "endif")
+
+			fmt.Fprintln(w, "\ninclude $(CLEAR_VARS)")
+			fmt.Fprintln(w, "LOCAL_PATH :=", moduleDir)
+			fmt.Fprintln(w, "LOCAL_MODULE :=", name)
+			fmt.Fprintln(w, "include $(BUILD_PHONY_PACKAGE)")
+		},
+	}
+}
@@ -0,0 +1,165 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etc
+
+// This file contains the input_keylayout, input_keychars, sensor_config and media_config module
+// types, which install the key layout and key character map files of input devices and the
+// configuration files of the sensor HAL and the media framework that device trees otherwise
+// copy with PRODUCT_COPY_FILES.  Each file is checked by scripts/check_device_config.py before
+// it is installed, because the framework and the HALs skip files they fail to parse.  The files
+// are installed to the vendor partition if the module sets vendor: true.
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/google/blueprint"
+
+	"android/soong/android"
+)
+
+func init() {
+	android.RegisterModuleType("input_keylayout", InputKeylayoutFactory)
+	android.RegisterModuleType("input_keychars", InputKeycharsFactory)
+	android.RegisterModuleType("sensor_config", SensorConfigFactory)
+	android.RegisterModuleType("media_config", MediaConfigFactory)
+
+	pctx.SourcePathVariable("checkDeviceConfigCmd", "build/soong/scripts/check_device_config.py")
+}
+
+// checkDeviceConfig validates a configuration file and copies it to $out.
+var checkDeviceConfig = pctx.AndroidStaticRule("checkDeviceConfig",
+	blueprint.RuleParams{
+		Command:     `$checkDeviceConfigCmd --type $type $in $out`,
+		CommandDeps: []string{"$checkDeviceConfigCmd"},
+	},
+	"type")
+
+type deviceConfigProperties struct {
+	// list of configuration files to validate and install
+	Srcs []string
+}
+
+type deviceConfig struct {
+	android.ModuleBase
+
+	properties deviceConfigProperties
+
+	// the type of file passed to check_device_config.py
+	configType string
+	// the directory the files are installed to, relative to the partition
+	installDir string
+	// the extension that the files must have, if any
+	extension string
+
+	outputFiles    android.Paths
+	installDirPath android.OutputPath
+}
+
+func newDeviceConfig(configType, installDir, extension string) android.Module {
+	module := &deviceConfig{
+		configType: configType,
+		installDir: installDir,
+		extension:  extension,
+	}
+	module.AddProperties(&module.properties)
+	android.InitAndroidArchModule(module, android.DeviceSupported, android.MultilibCommon)
+	return module
+}
+
+func InputKeylayoutFactory() android.Module {
+	return newDeviceConfig("keylayout", "usr/keylayout", ".kl")
+}
+
+func InputKeycharsFactory() android.Module {
+	return newDeviceConfig("keychars", "usr/keychars", ".kcm")
+}
+
+func SensorConfigFactory() android.Module {
+	return newDeviceConfig("sensors", "etc/sensors", "")
+}
+
+func MediaConfigFactory() android.Module {
+	return newDeviceConfig("media", "etc", ".xml")
+}
+
+func (m *deviceConfig) DepsMutator(ctx android.BottomUpMutatorContext) {
+	android.ExtractSourcesDeps(ctx, m.properties.Srcs)
+}
+
+func (m *deviceConfig) GenerateAndroidBuildActions(ctx android.ModuleContext) {
+	srcs := ctx.ExpandSources(m.properties.Srcs, nil)
+	if len(srcs) == 0 {
+		ctx.PropertyErrorf("srcs", "missing %s files", m.configType)
+		return
+	}
+
+	m.installDirPath = android.PathForModuleInstall(ctx, strings.Split(m.installDir, "/")...)
+
+	seen := make(map[string]android.Path)
+	for _, src := range srcs {
+		if m.extension != "" && src.Ext() != m.extension {
+			ctx.PropertyErrorf("srcs", "%q must end in %s", src, m.extension)
+			continue
+		}
+		if other, exists := seen[src.Base()]; exists {
+			ctx.PropertyErrorf("srcs", "multiple files named %q: %q and %q", src.Base(), other, src)
+			continue
+		}
+		seen[src.Base()] = src
+
+		outputFile := android.PathForModuleOut(ctx, m.configType, src.Base())
+		ctx.ModuleBuild(pctx, android.ModuleBuildParams{
+			Rule:        checkDeviceConfig,
+			Description: "check " + m.configType + " " + src.Base(),
+			Output:      outputFile,
+			Input:       src,
+			Args: map[string]string{
+				"type": m.configType,
+			},
+		})
+
+		m.outputFiles = append(m.outputFiles, outputFile)
+		ctx.InstallFile(m.installDirPath, outputFile)
+	}
+}
+
+func (m *deviceConfig) AndroidMk() android.AndroidMkData {
+	return android.AndroidMkData{
+		Custom: func(w io.Writer, name, prefix, moduleDir string, data android.AndroidMkData) {
+			var required []string
+			for _, outputFile := range m.outputFiles {
+				fileModule := name + "_" + outputFile.Base()
+				required = append(required, fileModule)
+
+				fmt.Fprintln(w, "\ninclude $(CLEAR_VARS)")
+				fmt.Fprintln(w, "LOCAL_PATH :=", moduleDir)
+				fmt.Fprintln(w, "LOCAL_MODULE :=", fileModule)
+				fmt.Fprintln(w, "LOCAL_MODULE_CLASS := ETC")
+				fmt.Fprintln(w, "LOCAL_MODULE_PATH := $(OUT_DIR)/"+m.installDirPath.RelPathString())
+				fmt.Fprintln(w, "LOCAL_INSTALLED_MODULE_STEM :=", outputFile.Base())
+				fmt.Fprintln(w, "LOCAL_PREBUILT_MODULE_FILE :=", outputFile.String())
+				fmt.Fprintln(w, "include $(BUILD_PREBUILT)")
+			}
+
+			fmt.Fprintln(w, "\ninclude $(CLEAR_VARS)")
+			fmt.Fprintln(w, "LOCAL_PATH :=", moduleDir)
+			fmt.Fprintln(w, "LOCAL_MODULE :=", name)
+			fmt.Fprintln(w, "LOCAL_REQUIRED_MODULES :=", strings.Join(required, " "))
+			fmt.Fprintln(w, "include $(BUILD_PHONY_PACKAGE)")
+		},
+	}
+}
@@ -0,0 +1,942 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etc
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"android/soong/android"
+)
+
+var buildDir string
+
+func setUp() {
+	var err error
+	buildDir, err = ioutil.TempDir("", "soong_etc_test")
+	if err != nil {
+		panic(err)
+	}
+}
+
+func tearDown() {
+	os.RemoveAll(buildDir)
+}
+
+func TestMain(m *testing.M) {
+	run := func() int {
+		setUp()
+		defer tearDown()
+
+		return m.Run()
+	}
+
+	os.Exit(run())
+}
+
+// newEtcFixture returns a test fixture for the device and host targets with the etc module types
+// registered.
+func newEtcFixture(bp string) *android.TestFixture {
+	f := android.NewTestArchFixture(buildDir)
+	f.RegisterModuleType("dalvik_heap_config", android.ModuleFactoryAdaptor(DalvikHeapConfigFactory))
+	f.RegisterModuleType("default_permissions_xml", android.ModuleFactoryAdaptor(DefaultPermissionsXmlFactory))
+	f.RegisterModuleType("font_update", android.ModuleFactoryAdaptor(FontUpdateFactory))
+	f.RegisterModuleType("hardware_features", android.ModuleFactoryAdaptor(HardwareFeaturesFactory))
+	f.RegisterModuleType("icu_data", android.ModuleFactoryAdaptor(IcuDataFactory))
+	f.RegisterModuleType("input_keychars", android.ModuleFactoryAdaptor(InputKeycharsFactory))
+	f.RegisterModuleType("input_keylayout", android.ModuleFactoryAdaptor(InputKeylayoutFactory))
+	f.RegisterModuleType("media_config", android.ModuleFactoryAdaptor(MediaConfigFactory))
+	f.RegisterModuleType("prebuilt_etc", android.ModuleFactoryAdaptor(PrebuiltEtcFactory))
+	f.RegisterModuleType("prebuilt_firmware", android.ModuleFactoryAdaptor(PrebuiltFirmwareFactory))
+	f.RegisterModuleType("prebuilt_usr_share", android.ModuleFactoryAdaptor(PrebuiltUsrShareFactory))
+	f.RegisterModuleType("sensor_config", android.ModuleFactoryAdaptor(SensorConfigFactory))
+	f.RegisterModuleType("source_test_module", android.ModuleFactoryAdaptor(newSourceTestModule))
+	f.RegisterModuleType("sysconfig_xml", android.ModuleFactoryAdaptor(SysconfigXmlFactory))
+	f.RegisterModuleType("tzdata", android.ModuleFactoryAdaptor(TzdataFactory))
+	f.RegisterModuleType("vintf_manifest", android.ModuleFactoryAdaptor(VintfManifestFactory))
+	f.RegisterModuleType("vintf_test_module", android.ModuleFactoryAdaptor(newVintfTestModule))
+	f.AddBlueprint(bp)
+	return f
+}
+
+func testEtc(t *testing.T, bp string) *android.TestContext {
+	f := newEtcFixture(bp)
+	f.Prepare(t)
+	return f.TestContext
+}
+
+// customAndroidMk returns the Android.mk fragment written by a module with a Custom AndroidMk
+// function.
+func customAndroidMk(data android.AndroidMkData, name, moduleDir string) string {
+	buf := &bytes.Buffer{}
+	data.Custom(buf, name, "TARGET_", moduleDir, data)
+	return buf.String()
+}
+
+type sourceTestModule struct {
+	android.ModuleBase
+	properties struct {
+		Srcs []string
+	}
+	srcs android.Paths
+}
+
+// newSourceTestModule returns a module whose srcs can be referenced with ":name" in the srcs of
+// other modules.
+func newSourceTestModule() android.Module {
+	m := &sourceTestModule{}
+	m.AddProperties(&m.properties)
+	android.InitAndroidModule(m)
+	return m
+}
+
+func (m *sourceTestModule) DepsMutator(ctx android.BottomUpMutatorContext) {
+}
+
+func (m *sourceTestModule) GenerateAndroidBuildActions(ctx android.ModuleContext) {
+	m.srcs = android.PathsForModuleSrc(ctx, m.properties.Srcs)
+}
+
+func (m *sourceTestModule) Srcs() android.Paths {
+	return m.srcs
+}
+
+func TestDalvikHeapConfig(t *testing.T) {
+	ctx := testEtc(t, `
+		dalvik_heap_config {
+			name: "dalvik_heap",
+			ram_class: "2g",
+			overrides: {
+				heapgrowthlimit: "256m",
+			},
+		}
+	`)
+
+	m := ctx.ModuleForTests("dalvik_heap", "android_common").Module().(*dalvikHeapConfig)
+	mk := customAndroidMk(m.AndroidMk(), "dalvik_heap", "device/test")
+
+	// The properties are only added to build.prop if the product installs the module
+	lines := strings.Split(strings.TrimSpace(mk), "\n")
+	if lines[0] != "ifneq (,$(filter dalvik_heap,$(PRODUCTS.$(INTERNAL_PRODUCT).PRODUCT_PACKAGES)))" ||
+		lines[2] != "endif" {
+		t.Errorf("dalvik_heap build properties are not conditional on PRODUCT_PACKAGES:\n%s", mk)
+	}
+	for _, property := range []string{
+		"dalvik.vm.heapstartsize=16m",
+		"dalvik.vm.heapgrowthlimit=256m",
+		"dalvik.vm.heapsize=512m",
+		"dalvik.vm.dex2oat-Xmx=512m",
+	} {
+		if !strings.Contains(lines[1], " "+property) {
+			t.Errorf("dalvik_heap build properties %q do not contain %q", lines[1], property)
+		}
+	}
+}
+
+func TestDalvikHeapConfigErrors(t *testing.T) {
+	testCases := []struct {
+		name, bp, err string
+	}{
+		{
+			name: "unknown RAM class",
+			bp: `
+				dalvik_heap_config {
+					name: "dalvik_heap",
+					ram_class: "5g",
+				}`,
+			err: `unknown RAM class "5g", must be one of 512m, 1g, 2g, 3g, 4g, 6g, 8g`,
+		},
+		{
+			name: "growth limit larger than heap size",
+			bp: `
+				dalvik_heap_config {
+					name: "dalvik_heap",
+					ram_class: "2g",
+					overrides: {
+						heapgrowthlimit: "1g",
+					},
+				}`,
+			err: `1g is larger than heapsize 512m`,
+		},
+		{
+			name: "invalid size",
+			bp: `
+				dalvik_heap_config {
+					name: "dalvik_heap",
+					ram_class: "2g",
+					overrides: {
+						heapminfree: "2M",
+					},
+				}`,
+			err: `"2M" is not a size such as 512k, 16m or 1g`,
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			f := newEtcFixture(test.bp)
+			android.FailIfNoMatchingErrors(t, test.err, f.PrepareWithErrors())
+		})
+	}
+}
+
+type vintfTestModule struct {
+	android.ModuleBase
+	properties struct {
+		Deps []string
+	}
+}
+
+// newVintfTestModule returns a device module that depends on the modules in its deps in the same
+// architecture, for the vintf_fragments of its dependencies.
+func newVintfTestModule() android.Module {
+	m := &vintfTestModule{}
+	m.AddProperties(&m.properties)
+	android.InitAndroidArchModule(m, android.DeviceSupported, android.MultilibBoth)
+	return m
+}
+
+func (m *vintfTestModule) DepsMutator(ctx android.BottomUpMutatorContext) {
+	ctx.AddDependency(ctx.Module(), nil, m.properties.Deps...)
+}
+
+func (m *vintfTestModule) GenerateAndroidBuildActions(ctx android.ModuleContext) {
+}
+
+func TestVintfManifest(t *testing.T) {
+	f := newEtcFixture(`
+		vintf_manifest {
+			name: "system_manifest",
+			src: "manifest.xml",
+			compatibility_matrix: "matrix.xml",
+			deps: ["hal", "hal_32", "vendor_hal"],
+		}
+
+		vintf_test_module {
+			name: "hal",
+			deps: ["hal_lib"],
+			vintf_fragments: ["hal.xml"],
+		}
+
+		vintf_test_module {
+			name: "hal_lib",
+			vintf_fragments: ["hal_lib.xml"],
+		}
+
+		vintf_test_module {
+			name: "hal_32",
+			compile_multilib: "32",
+			vintf_fragments: ["hal_32.xml"],
+		}
+
+		vintf_test_module {
+			name: "vendor_hal",
+			vendor: true,
+			vintf_fragments: ["vendor_hal.xml"],
+		}
+
+		vintf_test_module {
+			name: "not_installed",
+			vintf_fragments: ["not_installed.xml"],
+		}
+	`)
+	f.AddFiles("manifest.xml", "matrix.xml", "hal.xml", "hal_lib.xml", "hal_32.xml", "vendor_hal.xml",
+		"not_installed.xml")
+	f.Prepare(t)
+
+	// The fragments are installed once, from the first variant of the module
+	for _, variant := range []struct{ name, variant string }{
+		{"hal", "android_arm64"},
+		{"hal_32", "android_arm"},
+	} {
+		m := f.ModuleForTests(variant.name, variant.variant).Module().(*vintfTestModule)
+		if len(m.VintfFragments()) != 1 {
+			t.Errorf("%s %s installs fragments %v, want 1", variant.name, variant.variant, m.VintfFragments())
+		}
+	}
+	if m := f.ModuleForTests("hal", "android_arm").Module().(*vintfTestModule); len(m.VintfFragments()) != 0 {
+		t.Errorf("secondary variant of hal installs fragments %v", m.VintfFragments())
+	}
+
+	manifests, fragments := vintfManifestFragments(f.Context)
+	if len(manifests) != 1 {
+		t.Fatalf("found %d manifests, want 1", len(manifests))
+	}
+	var got []string
+	for _, fragment := range fragments[manifests[0]] {
+		got = append(got, strings.TrimPrefix(fragment, buildDir+"/"))
+	}
+	want := []string{"hal.xml", "hal_32.xml", "hal_lib.xml"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("system_manifest fragments %q, want %q", got, want)
+	}
+}
+
+func TestVintfManifestErrors(t *testing.T) {
+	f := newEtcFixture(`
+		vintf_manifest {
+			name: "system_manifest",
+			src: "manifest.xml",
+			deps: ["missing"],
+		}
+	`)
+	f.AddFiles("manifest.xml")
+	android.FailIfNoMatchingErrors(t, `unknown module "missing"`, f.PrepareWithErrors())
+}
+
+func TestFontUpdate(t *testing.T) {
+	f := newEtcFixture(`
+		font_update {
+			name: "emoji",
+			srcs: ["NotoColorEmoji.ttf", ":extra_fonts"],
+			version: "2",
+			certificate: "fonts",
+		}
+
+		source_test_module {
+			name: "extra_fonts",
+			srcs: ["extra/Extra.otf"],
+		}
+	`)
+	f.AddFiles("NotoColorEmoji.ttf", "extra/Extra.otf",
+		"build/target/product/security/fonts.x509.pem", "build/target/product/security/fonts.pk8")
+	f.Prepare(t)
+
+	m := f.ModuleForTests("emoji", "android_common")
+	zip := m.Output("emoji.zip")
+	for _, font := range []string{"NotoColorEmoji.ttf", "extra/Extra.otf"} {
+		if !inList(font, zip.Inputs.Strings()) {
+			t.Errorf("emoji.zip does not contain %q: %q", font, zip.Inputs)
+		}
+	}
+
+	// The package is signed again when the certificate changes
+	certificate := "build/target/product/security/fonts"
+	if zip.Args["certificate"] != certificate {
+		t.Errorf("emoji.zip is signed with %q, want %q", zip.Args["certificate"], certificate)
+	}
+	for _, file := range []string{certificate + ".x509.pem", certificate + ".pk8"} {
+		if !inList(file, zip.Implicits.Strings()) {
+			t.Errorf("emoji.zip does not depend on %q: %q", file, zip.Implicits)
+		}
+	}
+}
+
+func TestFontUpdateErrors(t *testing.T) {
+	testCases := []struct {
+		name, bp, err string
+	}{
+		{
+			name: "missing certificate",
+			bp: `
+				font_update {
+					name: "emoji",
+					srcs: ["NotoColorEmoji.ttf"],
+					version: "2",
+					certificate: "device/missing",
+				}`,
+			err: `source path device/missing.x509.pem does not exist`,
+		},
+		{
+			name: "not a font",
+			bp: `
+				font_update {
+					name: "emoji",
+					srcs: ["README.md"],
+					version: "2",
+				}`,
+			err: `"README.md" is not a .ttf, .otf or .ttc font`,
+		},
+		{
+			name: "invalid version",
+			bp: `
+				font_update {
+					name: "emoji",
+					srcs: ["NotoColorEmoji.ttf"],
+					version: "0",
+				}`,
+			err: `"0" must be a positive integer`,
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			f := newEtcFixture(test.bp)
+			f.AddFiles("NotoColorEmoji.ttf", "README.md")
+			android.FailIfNoMatchingErrors(t, test.err, f.PrepareWithErrors())
+		})
+	}
+}
+
+func TestTzdata(t *testing.T) {
+	f := newEtcFixture(`
+		tzdata {
+			name: "tzdata",
+			src: "tzdata2017c",
+			version: "2017c",
+		}
+
+		tzdata {
+			name: "vendor_tzdata",
+			src: ":vendor_tzdata_src",
+			version: "2017c",
+			vendor: true,
+		}
+
+		source_test_module {
+			name: "vendor_tzdata_src",
+			srcs: ["vendor/tzdata2017c"],
+		}
+
+		icu_data {
+			name: "icu_data",
+			src: "icudt58l.dat",
+			version: "58",
+			system_ext_specific: true,
+		}
+	`)
+	f.AddFiles("tzdata2017c", "vendor/tzdata2017c", "icudt58l.dat")
+	f.Prepare(t)
+
+	testCases := []struct {
+		name, input, output, install string
+	}{
+		{
+			name:    "tzdata",
+			input:   "tzdata2017c",
+			output:  "tzdata",
+			install: "target/product/test_device/system/usr/share/zoneinfo/tzdata",
+		},
+		{
+			name:    "vendor_tzdata",
+			input:   "vendor/tzdata2017c",
+			output:  "tzdata",
+			install: "target/product/test_device/vendor/usr/share/zoneinfo/tzdata",
+		},
+		{
+			name:    "icu_data",
+			input:   "icudt58l.dat",
+			output:  "icudt58l.dat",
+			install: "target/product/test_device/system_ext/usr/icu/icudt58l.dat",
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			m := f.ModuleForTests(test.name, "android_common")
+			check := m.Output(test.output)
+			if check.Input.String() != test.input {
+				t.Errorf("%s checks %q, want %q", test.output, check.Input, test.input)
+			}
+			install := m.Module().(*tzdata).installPath.Rel()
+			if install != test.install {
+				t.Errorf("%s is installed to %q, want %q", test.name, install, test.install)
+			}
+		})
+	}
+}
+
+func TestTzdataErrors(t *testing.T) {
+	testCases := []struct {
+		name, bp, err string
+	}{
+		{
+			name: "tzdata version",
+			bp: `
+				tzdata {
+					name: "tzdata",
+					src: "tzdata2017c",
+					version: "17c",
+				}`,
+			err: `"17c" is not a time zone rules version`,
+		},
+		{
+			name: "icu version",
+			bp: `
+				icu_data {
+					name: "icu_data",
+					src: "icudt58l.dat",
+					version: "59",
+				}`,
+			err: `"icudt58l.dat" does not match ICU version 59, expected icudt59l.dat`,
+		},
+		{
+			name: "missing src",
+			bp: `
+				tzdata {
+					name: "tzdata",
+					version: "2017c",
+				}`,
+			err: `missing tzdata file`,
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			f := newEtcFixture(test.bp)
+			f.AddFiles("tzdata2017c", "icudt58l.dat")
+			android.FailIfNoMatchingErrors(t, test.err, f.PrepareWithErrors())
+		})
+	}
+}
+
+func TestHardwareFeatures(t *testing.T) {
+	f := newEtcFixture(`
+		hardware_features {
+			name: "device_features",
+			srcs: ["android.hardware.wifi.xml", ":extra_features"],
+			features: ["android.hardware.nfc", "android.software.leanback"],
+		}
+
+		source_test_module {
+			name: "extra_features",
+			srcs: ["extra/android.hardware.camera.xml"],
+		}
+	`)
+	f.AddFiles("android.hardware.wifi.xml", "extra/android.hardware.camera.xml")
+	f.Prepare(t)
+
+	m := f.ModuleForTests("device_features", "android_common")
+
+	// The features are declared in a generated file, which is checked like the srcs
+	xml := m.Output("device_features.xml")
+	for _, feature := range []string{"android.hardware.nfc", "android.software.leanback"} {
+		if !strings.Contains(xml.Args["content"], `<feature name="`+feature+`" />`) {
+			t.Errorf("device_features.xml doesn't declare %q: %q", feature, xml.Args["content"])
+		}
+	}
+
+	checked := make(map[string]string)
+	for _, p := range m.Module().BuildParamsForTests() {
+		if p.Rule == checkFeatureXml {
+			checked[p.Output.Base()] = p.Input.String()
+		}
+	}
+	expected := map[string]string{
+		"android.hardware.wifi.xml":   "android.hardware.wifi.xml",
+		"android.hardware.camera.xml": "extra/android.hardware.camera.xml",
+		"device_features.xml":         xml.Output.String(),
+	}
+	if !reflect.DeepEqual(checked, expected) {
+		t.Errorf("expected the checked feature files %q, got %q", expected, checked)
+	}
+
+	module := m.Module().(*hardwareFeatures)
+	if installDir := module.installDir.RelPathString(); !strings.HasSuffix(installDir, "/system/etc/permissions") {
+		t.Errorf("expected the features to be installed into system/etc/permissions, got %q", installDir)
+	}
+
+	mk := customAndroidMk(module.AndroidMk(), "device_features", "")
+	required := "LOCAL_REQUIRED_MODULES := device_features_android.hardware.wifi.xml " +
+		"device_features_android.hardware.camera.xml device_features_device_features.xml\n"
+	if !strings.Contains(mk, required) {
+		t.Errorf("device_features doesn't require its feature files:\n%s", mk)
+	}
+}
+
+func TestHardwareFeaturesErrors(t *testing.T) {
+	testCases := []struct {
+		name, bp, err string
+	}{
+		{
+			name: "no features",
+			bp: `
+				hardware_features {
+					name: "device_features",
+				}`,
+			err: "at least one of srcs or features is required",
+		},
+		{
+			name: "invalid feature name",
+			bp: `
+				hardware_features {
+					name: "device_features",
+					features: ["android.hardware.NFC"],
+				}`,
+			err: `invalid feature name "android.hardware.NFC"`,
+		},
+		{
+			name: "duplicate file name",
+			bp: `
+				hardware_features {
+					name: "device_features",
+					srcs: ["android.hardware.wifi.xml", "extra/android.hardware.wifi.xml"],
+				}`,
+			err: `multiple feature files named "android.hardware.wifi.xml"`,
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			f := newEtcFixture(test.bp)
+			f.AddFiles("android.hardware.wifi.xml", "extra/android.hardware.wifi.xml")
+			android.FailIfNoMatchingErrors(t, test.err, f.PrepareWithErrors())
+		})
+	}
+}
+
+func TestPermissionConfig(t *testing.T) {
+	f := newEtcFixture(`
+		default_permissions_xml {
+			name: "default_permissions",
+			src: "default-permissions-lineage.xml",
+		}
+
+		sysconfig_xml {
+			name: "sysconfig",
+			src: "config.xml",
+			filename: "lineage-sysconfig.xml",
+		}
+	`)
+	f.AddFiles("default-permissions-lineage.xml", "config.xml")
+	f.Prepare(t)
+
+	// Each file is validated as its type, and installed into the directory of its type
+	testCases := []struct {
+		name, configType, src, installed string
+	}{
+		{"default_permissions", "default-permissions", "default-permissions-lineage.xml",
+			"/system/etc/default-permissions/default-permissions-lineage.xml"},
+		{"sysconfig", "sysconfig", "config.xml", "/system/etc/sysconfig/lineage-sysconfig.xml"},
+	}
+	for _, test := range testCases {
+		m := f.ModuleForTests(test.name, "android_common")
+		check := m.Rule("checkPermissionConfig")
+		if check.Args["type"] != test.configType {
+			t.Errorf("%s is checked as %q, want %q", test.name, check.Args["type"], test.configType)
+		}
+		if check.Input.String() != test.src {
+			t.Errorf("%s checks %q, want %q", test.name, check.Input, test.src)
+		}
+		installPath := m.Module().(*permissionConfig).installPath.RelPathString()
+		if !strings.HasSuffix(installPath, test.installed) {
+			t.Errorf("%s is installed to %q, want %q", test.name, installPath, test.installed)
+		}
+	}
+}
+
+func TestPermissionConfigErrors(t *testing.T) {
+	testCases := []struct {
+		name, bp, err string
+	}{
+		{
+			name: "missing src",
+			bp: `
+				sysconfig_xml {
+					name: "sysconfig",
+				}`,
+			err: "missing sysconfig XML file",
+		},
+		{
+			name: "not an xml file",
+			bp: `
+				default_permissions_xml {
+					name: "default_permissions",
+					src: "config.xml",
+					filename: "default-permissions.txt",
+				}`,
+			err: `"default-permissions.txt" must end in .xml`,
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			f := newEtcFixture(test.bp)
+			f.AddFiles("config.xml")
+			android.FailIfNoMatchingErrors(t, test.err, f.PrepareWithErrors())
+		})
+	}
+}
+
+func inList(s string, list []string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
+
+func TestDeviceConfig(t *testing.T) {
+	f := newEtcFixture(`
+		input_keylayout {
+			name: "keylayout",
+			srcs: ["gpio-keys.kl", ":extra_keylayout"],
+		}
+
+		source_test_module {
+			name: "extra_keylayout",
+			srcs: ["extra/Vendor_0001.kl"],
+		}
+
+		input_keychars {
+			name: "keychars",
+			srcs: ["Generic.kcm"],
+		}
+
+		sensor_config {
+			name: "sensors",
+			srcs: ["hals.conf"],
+			vendor: true,
+		}
+
+		media_config {
+			name: "media",
+			srcs: ["media_profiles_V1_0.xml"],
+			vendor: true,
+		}
+	`)
+	f.AddFiles("gpio-keys.kl", "extra/Vendor_0001.kl", "Generic.kcm", "hals.conf", "media_profiles_V1_0.xml")
+	f.Prepare(t)
+
+	testCases := []struct {
+		name, configType, installDir string
+		checked                      map[string]string
+	}{
+		{
+			name:       "keylayout",
+			configType: "keylayout",
+			installDir: "/system/usr/keylayout",
+			checked: map[string]string{
+				"gpio-keys.kl":   "gpio-keys.kl",
+				"Vendor_0001.kl": "extra/Vendor_0001.kl",
+			},
+		},
+		{
+			name:       "keychars",
+			configType: "keychars",
+			installDir: "/system/usr/keychars",
+			checked:    map[string]string{"Generic.kcm": "Generic.kcm"},
+		},
+		{
+			name:       "sensors",
+			configType: "sensors",
+			installDir: "/vendor/etc/sensors",
+			checked:    map[string]string{"hals.conf": "hals.conf"},
+		},
+		{
+			name:       "media",
+			configType: "media",
+			installDir: "/vendor/etc",
+			checked:    map[string]string{"media_profiles_V1_0.xml": "media_profiles_V1_0.xml"},
+		},
+	}
+
+	for _, test := range testCases {
+		m := f.ModuleForTests(test.name, "android_common")
+
+		// Each file is checked as the type of the module before it is installed
+		checked := make(map[string]string)
+		for _, p := range m.Module().BuildParamsForTests() {
+			if p.Rule == checkDeviceConfig {
+				checked[p.Output.Base()] = p.Input.String()
+				if p.Args["type"] != test.configType {
+					t.Errorf("%s: %s is checked as %q, want %q", test.name, p.Input, p.Args["type"],
+						test.configType)
+				}
+			}
+		}
+		if !reflect.DeepEqual(checked, test.checked) {
+			t.Errorf("%s: expected the checked files %q, got %q", test.name, test.checked, checked)
+		}
+
+		module := m.Module().(*deviceConfig)
+		if installDir := module.installDirPath.RelPathString(); !strings.HasSuffix(installDir, test.installDir) {
+			t.Errorf("%s: expected the files to be installed into %s, got %q", test.name,
+				test.installDir[1:], installDir)
+		}
+	}
+
+	mk := customAndroidMk(f.ModuleForTests("keylayout", "android_common").Module().(*deviceConfig).AndroidMk(),
+		"keylayout", "")
+	if required := "LOCAL_REQUIRED_MODULES := keylayout_gpio-keys.kl keylayout_Vendor_0001.kl\n"; !strings.Contains(mk, required) {
+		t.Errorf("keylayout doesn't require its files:\n%s", mk)
+	}
+}
+
+func TestDeviceConfigErrors(t *testing.T) {
+	testCases := []struct {
+		name, bp, err string
+	}{
+		{
+			name: "no srcs",
+			bp: `
+				input_keylayout {
+					name: "keylayout",
+				}`,
+			err: "missing keylayout files",
+		},
+		{
+			name: "wrong extension",
+			bp: `
+				input_keychars {
+					name: "keychars",
+					srcs: ["gpio-keys.kl"],
+				}`,
+			err: `"gpio-keys.kl" must end in .kcm`,
+		},
+		{
+			name: "duplicate file name",
+			bp: `
+				input_keylayout {
+					name: "keylayout",
+					srcs: ["gpio-keys.kl", "extra/gpio-keys.kl"],
+				}`,
+			err: `multiple files named "gpio-keys.kl"`,
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			f := newEtcFixture(test.bp)
+			f.AddFiles("gpio-keys.kl", "extra/gpio-keys.kl")
+			android.FailIfNoMatchingErrors(t, test.err, f.PrepareWithErrors())
+		})
+	}
+}
+
+func TestPrebuiltEtc(t *testing.T) {
+	f := newEtcFixture(`
+		prebuilt_etc {
+			name: "foo",
+			src: "foo.conf",
+			sub_dir: "init",
+			filename: "bar.conf",
+			symlinks: ["baz.conf"],
+		}
+
+		prebuilt_usr_share {
+			name: "keyboard",
+			src: "keyboard.idc",
+			vendor: true,
+		}
+
+		prebuilt_firmware {
+			name: "firmware",
+			src: "fw.bin",
+			recovery: true,
+		}
+
+		prebuilt_etc {
+			name: "uninstallable",
+			src: "foo.conf",
+			installable: false,
+		}
+	`)
+	f.AddFiles("foo.conf", "keyboard.idc", "fw.bin")
+	f.Prepare(t)
+
+	// Each module type installs into its own directory of the partition of the module
+	testCases := []struct {
+		name, src, installed string
+	}{
+		{"foo", "foo.conf", "/system/etc/init/bar.conf"},
+		{"keyboard", "keyboard.idc", "/vendor/usr/share/keyboard.idc"},
+		{"firmware", "fw.bin", "/recovery/root/system/etc/firmware/fw.bin"},
+	}
+	for _, test := range testCases {
+		m := f.ModuleForTests(test.name, "android_arm64")
+		if cp := m.Output(filepath.Base(test.installed)); cp.Input.String() != test.src {
+			t.Errorf("%s copies %q, want %q", test.name, cp.Input, test.src)
+		}
+		installPath := m.Module().(*prebuiltEtc).installPath.RelPathString()
+		if !strings.HasSuffix(installPath, test.installed) {
+			t.Errorf("%s is installed to %q, want %q", test.name, installPath, test.installed)
+		}
+	}
+
+	for _, test := range []struct {
+		name string
+		mk   []string
+	}{
+		{"foo", []string{"LOCAL_INSTALLED_MODULE_STEM := bar.conf", "LOCAL_MODULE_SYMLINKS := baz.conf"}},
+		{"uninstallable", []string{"LOCAL_UNINSTALLABLE_MODULE := true"}},
+	} {
+		m := f.ModuleForTests(test.name, "android_arm64").Module().(*prebuiltEtc)
+		data := m.AndroidMk()
+		buf := &bytes.Buffer{}
+		for _, extra := range data.Extra {
+			extra(buf, data.OutputFile.Path())
+		}
+		for _, line := range test.mk {
+			if !strings.Contains(buf.String(), line+"\n") {
+				t.Errorf("%s Android.mk doesn't contain %q:\n%s", test.name, line, buf.String())
+			}
+		}
+	}
+}
+
+func TestPrebuiltEtcErrors(t *testing.T) {
+	testCases := []struct {
+		name, bp, err string
+	}{
+		{
+			name: "missing src",
+			bp: `
+				prebuilt_etc {
+					name: "foo",
+				}`,
+			err: "missing prebuilt file",
+		},
+		{
+			name: "recovery and ramdisk",
+			bp: `
+				prebuilt_firmware {
+					name: "foo",
+					src: "foo.conf",
+					recovery: true,
+					ramdisk: true,
+				}`,
+			err: "can't be set together with recovery",
+		},
+		{
+			name: "several files",
+			bp: `
+				source_test_module {
+					name: "confs",
+					srcs: ["foo.conf", "bar.conf"],
+				}
+
+				prebuilt_etc {
+					name: "foo",
+					src: ":confs",
+				}`,
+			err: `":confs" must be a single file`,
+		},
+		{
+			name: "filename is a path",
+			bp: `
+				prebuilt_etc {
+					name: "foo",
+					src: "foo.conf",
+					filename: "init/foo.conf",
+				}`,
+			err: `"init/foo.conf" is not a file name`,
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			f := newEtcFixture(test.bp)
+			f.AddFiles("foo.conf", "bar.conf")
+			android.FailIfNoMatchingErrors(t, test.err, f.PrepareWithErrors())
+		})
+	}
+}
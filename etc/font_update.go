@@ -0,0 +1,208 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etc
+
+// This file contains the font_update module type, which packages updated fonts, for example a
+// newer emoji font, into a signed and versioned zip that is installed to etc/fonts/updates along
+// with its metadata, so that fonts can be refreshed between OS releases.  See
+// scripts/font_update.py.
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/google/blueprint"
+	_ "github.com/google/blueprint/bootstrap"
+
+	"android/soong/android"
+)
+
+func init() {
+	android.RegisterModuleType("font_update", FontUpdateFactory)
+
+	pctx.Import("github.com/google/blueprint/bootstrap")
+
+	pctx.StaticVariable("SoongZipCmd", filepath.Join("${bootstrap.ToolDir}", "soong_zip"))
+	pctx.SourcePathVariable("fontUpdateCmd", "build/soong/scripts/font_update.py")
+	pctx.HostJavaToolVariable("signapkCmd", "signapk.jar")
+}
+
+var (
+	fontUpdateMetadata = pctx.AndroidStaticRule("fontUpdateMetadata",
+		blueprint.RuleParams{
+			Command:     `$fontUpdateCmd --name $name --version $version --output $out $in`,
+			CommandDeps: []string{"$fontUpdateCmd"},
+		},
+		"name", "version")
+
+	fontUpdatePackage = pctx.AndroidStaticRule("fontUpdatePackage",
+		blueprint.RuleParams{
+			Command: `${SoongZipCmd} -o $out.unsigned $zipArgs && ` +
+				`java -jar $signapkCmd $certificate.x509.pem $certificate.pk8 $out.unsigned $out && ` +
+				`rm -f $out.unsigned`,
+			CommandDeps: []string{"${SoongZipCmd}", "$signapkCmd"},
+		},
+		"zipArgs", "certificate")
+)
+
+var fontExtensions = []string{".ttf", ".otf", ".ttc"}
+
+func isFontFile(path android.Path) bool {
+	for _, ext := range fontExtensions {
+		if strings.ToLower(path.Ext()) == ext {
+			return true
+		}
+	}
+	return false
+}
+
+type fontUpdateProperties struct {
+	// list of the font files to package
+	Srcs []string
+
+	// version of the update, a positive integer that must be increased with every update of
+	// the fonts
+	Version *string
+
+	// name of the certificate in the default certificate directory, or path to the certificate
+	// relative to the top of the tree, without the .x509.pem or .pk8 suffix.  Defaults to the
+	// default certificate of the product.
+	Certificate *string
+}
+
+type fontUpdate struct {
+	android.ModuleBase
+
+	properties fontUpdateProperties
+
+	outputFile  android.Path
+	metadata    android.Path
+	installPath android.OutputPath
+}
+
+func FontUpdateFactory() android.Module {
+	module := &fontUpdate{}
+	module.AddProperties(&module.properties)
+	android.InitAndroidArchModule(module, android.DeviceSupported, android.MultilibCommon)
+	return module
+}
+
+func (m *fontUpdate) DepsMutator(ctx android.BottomUpMutatorContext) {
+	android.ExtractSourcesDeps(ctx, m.properties.Srcs)
+}
+
+func (m *fontUpdate) GenerateAndroidBuildActions(ctx android.ModuleContext) {
+	if m.properties.Version == nil {
+		ctx.PropertyErrorf("version", "missing version")
+		return
+	}
+	if version, err := strconv.Atoi(*m.properties.Version); err != nil || version <= 0 {
+		ctx.PropertyErrorf("version", "%q must be a positive integer", *m.properties.Version)
+		return
+	}
+
+	fonts := ctx.ExpandSources(m.properties.Srcs, nil)
+	if len(fonts) == 0 {
+		ctx.PropertyErrorf("srcs", "missing font files")
+		return
+	}
+
+	// The fonts are stored at the top level of the zip, next to the metadata.
+	zipArgs := make([]string, 0, len(fonts)+1)
+	seen := make(map[string]string)
+	for _, font := range fonts {
+		if !isFontFile(font) {
+			ctx.PropertyErrorf("srcs", "%q is not a .ttf, .otf or .ttc font", font.String())
+			continue
+		}
+		if other, exists := seen[font.Base()]; exists {
+			ctx.PropertyErrorf("srcs", "multiple fonts named %q: %q and %q", font.Base(), other,
+				font.String())
+			continue
+		}
+		seen[font.Base()] = font.String()
+		zipArgs = append(zipArgs, "-C "+filepath.Dir(font.String())+" -f "+font.String())
+	}
+	if ctx.Failed() {
+		return
+	}
+
+	name := ctx.ModuleName()
+	metadata := android.PathForModuleOut(ctx, name+".json")
+	ctx.ModuleBuild(pctx, android.ModuleBuildParams{
+		Rule:        fontUpdateMetadata,
+		Description: "font update metadata " + name,
+		Output:      metadata,
+		Inputs:      fonts,
+		Args: map[string]string{
+			"name":    name,
+			"version": *m.properties.Version,
+		},
+	})
+	zipArgs = append(zipArgs, "-C "+filepath.Dir(metadata.String())+" -f "+metadata.String())
+
+	var certificate string
+	if m.properties.Certificate == nil {
+		certificate = ctx.AConfig().DefaultAppCertificate(ctx).Rel()
+	} else if dir, _ := filepath.Split(*m.properties.Certificate); dir == "" {
+		certificate = filepath.Join(ctx.AConfig().DefaultAppCertificateDir(ctx).Rel(),
+			*m.properties.Certificate)
+	} else {
+		certificate = *m.properties.Certificate
+	}
+	// signapk is only given the certificate without its suffixes, the package is signed again
+	// when the key changes
+	certificatePem := android.PathForSource(ctx, certificate+".x509.pem")
+	certificateKey := android.PathForSource(ctx, certificate+".pk8")
+
+	outputFile := android.PathForModuleOut(ctx, name+".zip")
+	ctx.ModuleBuild(pctx, android.ModuleBuildParams{
+		Rule:        fontUpdatePackage,
+		Description: "font update " + name,
+		Output:      outputFile,
+		Inputs:      append(android.Paths{metadata}, fonts...),
+		Implicits:   android.Paths{certificatePem, certificateKey},
+		Args: map[string]string{
+			"zipArgs":     strings.Join(zipArgs, " "),
+			"certificate": strings.TrimSuffix(certificatePem.String(), ".x509.pem"),
+		},
+	})
+
+	installDir := android.PathForModuleInstall(ctx, "etc", "fonts", "updates")
+	m.outputFile = outputFile
+	m.metadata = metadata
+	m.installPath = ctx.InstallFile(installDir, outputFile)
+	ctx.InstallFile(installDir, metadata)
+}
+
+func (m *fontUpdate) AndroidMk() android.AndroidMkData {
+	return android.AndroidMkData{
+		Class:      "ETC",
+		OutputFile: android.OptionalPathForPath(m.outputFile),
+		Extra: []android.AndroidMkExtraFunc{
+			func(w io.Writer, outputFile android.Path) {
+				installDir := "$(OUT_DIR)/" + filepath.Dir(m.installPath.RelPathString())
+				fmt.Fprintln(w, "LOCAL_MODULE_PATH := "+installDir)
+				fmt.Fprintln(w, "LOCAL_INSTALLED_MODULE_STEM := "+outputFile.Base())
+				// The metadata is installed next to the package for the updater to read the
+				// version without opening the package.
+				fmt.Fprintln(w, "LOCAL_POST_INSTALL_CMD := cp -f "+m.metadata.String()+" "+installDir+"/")
+			},
+		},
+	}
+}
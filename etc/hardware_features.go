@@ -0,0 +1,172 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etc
+
+// This file contains the hardware_features module type, which installs the permissions XML
+// files that declare the hardware and software features of a device into etc/permissions of
+// the partition the module is installed to.
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/google/blueprint"
+
+	"android/soong/android"
+)
+
+func init() {
+	android.RegisterModuleType("hardware_features", HardwareFeaturesFactory)
+
+	pctx.SourcePathVariable("checkFeatureXmlCmd", "build/soong/scripts/check_feature_xml.py")
+}
+
+var (
+	// checkFeatureXml validates a feature permissions XML file against the format that the
+	// package manager accepts and copies it to $out.
+	checkFeatureXml = pctx.AndroidStaticRule("checkFeatureXml",
+		blueprint.RuleParams{
+			Command:     `$checkFeatureXmlCmd $in $out`,
+			CommandDeps: []string{"$checkFeatureXmlCmd"},
+		})
+)
+
+var featureNameRegexp = regexp.MustCompile(`^android\.(hardware|software)\.[a-z0-9_]+(\.[a-z0-9_]+)*$`)
+
+type hardwareFeaturesProperties struct {
+	// list of feature permissions XML files to validate and install, for example the
+	// android.hardware.*.xml files from frameworks/native/data/etc
+	Srcs []string
+
+	// list of android.hardware.* or android.software.* feature names to declare in a generated
+	// <module name>.xml file
+	Features []string
+}
+
+type hardwareFeatures struct {
+	android.ModuleBase
+
+	properties hardwareFeaturesProperties
+
+	outputFiles android.Paths
+	installDir  android.OutputPath
+}
+
+func HardwareFeaturesFactory() android.Module {
+	module := &hardwareFeatures{}
+	module.AddProperties(&module.properties)
+	android.InitAndroidArchModule(module, android.DeviceSupported, android.MultilibCommon)
+	return module
+}
+
+func (m *hardwareFeatures) DepsMutator(ctx android.BottomUpMutatorContext) {
+	android.ExtractSourcesDeps(ctx, m.properties.Srcs)
+}
+
+func (m *hardwareFeatures) GenerateAndroidBuildActions(ctx android.ModuleContext) {
+	if len(m.properties.Srcs) == 0 && len(m.properties.Features) == 0 {
+		ctx.ModuleErrorf("at least one of srcs or features is required")
+		return
+	}
+
+	for _, feature := range m.properties.Features {
+		if !featureNameRegexp.MatchString(feature) {
+			ctx.PropertyErrorf("features", "invalid feature name %q", feature)
+		}
+	}
+	if ctx.Failed() {
+		return
+	}
+
+	srcs := ctx.ExpandSources(m.properties.Srcs, nil)
+
+	if len(m.properties.Features) > 0 {
+		srcs = append(srcs, m.generateFeaturesXml(ctx))
+	}
+
+	m.installDir = android.PathForModuleInstall(ctx, "etc", "permissions")
+
+	seen := make(map[string]android.Path)
+	for _, src := range srcs {
+		if other, exists := seen[src.Base()]; exists {
+			ctx.ModuleErrorf("multiple feature files named %q: %q and %q", src.Base(), other, src)
+			continue
+		}
+		seen[src.Base()] = src
+
+		outputFile := android.PathForModuleOut(ctx, "permissions", src.Base())
+		ctx.ModuleBuild(pctx, android.ModuleBuildParams{
+			Rule:        checkFeatureXml,
+			Description: "check feature xml " + src.Base(),
+			Output:      outputFile,
+			Input:       src,
+		})
+
+		m.outputFiles = append(m.outputFiles, outputFile)
+		ctx.InstallFile(m.installDir, outputFile)
+	}
+}
+
+func (m *hardwareFeatures) generateFeaturesXml(ctx android.ModuleContext) android.Path {
+	content := []string{
+		`<?xml version="1.0" encoding="utf-8"?>`,
+		`<permissions>`,
+	}
+	for _, feature := range m.properties.Features {
+		content = append(content, `    <feature name="`+feature+`" />`)
+	}
+	content = append(content, `</permissions>`)
+
+	xml := android.PathForModuleGen(ctx, ctx.ModuleName()+".xml")
+	ctx.ModuleBuild(pctx, android.ModuleBuildParams{
+		Rule:        android.WriteFile,
+		Description: "generate " + xml.Base(),
+		Output:      xml,
+		Args: map[string]string{
+			"content": strings.Join(content, `\n`),
+		},
+	})
+
+	return xml
+}
+
+func (m *hardwareFeatures) AndroidMk() android.AndroidMkData {
+	return android.AndroidMkData{
+		Custom: func(w io.Writer, name, prefix, moduleDir string, data android.AndroidMkData) {
+			var required []string
+			for _, outputFile := range m.outputFiles {
+				fileModule := name + "_" + outputFile.Base()
+				required = append(required, fileModule)
+
+				fmt.Fprintln(w, "\ninclude $(CLEAR_VARS)")
+				fmt.Fprintln(w, "LOCAL_PATH :=", moduleDir)
+				fmt.Fprintln(w, "LOCAL_MODULE :=", fileModule)
+				fmt.Fprintln(w, "LOCAL_MODULE_CLASS := ETC")
+				fmt.Fprintln(w, "LOCAL_MODULE_PATH := $(OUT_DIR)/"+m.installDir.RelPathString())
+				fmt.Fprintln(w, "LOCAL_INSTALLED_MODULE_STEM :=", outputFile.Base())
+				fmt.Fprintln(w, "LOCAL_PREBUILT_MODULE_FILE :=", outputFile.String())
+				fmt.Fprintln(w, "include $(BUILD_PREBUILT)")
+			}
+
+			fmt.Fprintln(w, "\ninclude $(CLEAR_VARS)")
+			fmt.Fprintln(w, "LOCAL_PATH :=", moduleDir)
+			fmt.Fprintln(w, "LOCAL_MODULE :=", name)
+			fmt.Fprintln(w, "LOCAL_REQUIRED_MODULES :=", strings.Join(required, " "))
+			fmt.Fprintln(w, "include $(BUILD_PHONY_PACKAGE)")
+		},
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etc
+
+// This file contains the permission_audit singleton, which reports the permissions requested by
+// every app built by Soong and checks the privileged permissions requested by privileged apps
+// against the privapp-permissions allowlists in the sysconfig files, and the signature
+// permissions of the platform against the certificates the apps are signed with.  The report is
+// only built when Make asks for it, and fails the build if PRODUCT_ENFORCE_PRIVAPP_PERMISSIONS
+// is set.
+
+import (
+	"strings"
+
+	"github.com/google/blueprint"
+
+	"android/soong/android"
+)
+
+func init() {
+	android.RegisterSingletonType("permission_audit", PermissionAuditSingleton)
+
+	pctx.SourcePathVariable("permissionAuditCmd", "build/soong/scripts/permission_audit.py")
+	pctx.IntermediatesPathVariable("permissionAuditReport", "permission_audit.txt")
+
+	android.RegisterMakeVarsProvider(pctx, permissionAuditMakeVarsProvider)
+}
+
+var permissionAudit = pctx.AndroidStaticRule("permissionAudit",
+	blueprint.RuleParams{
+		Command: `$permissionAuditCmd --apps $out.rsp $platformManifest $platformCertificate ` +
+			`$enforce --output $out $in`,
+		CommandDeps:    []string{"$permissionAuditCmd"},
+		Rspfile:        "$out.rsp",
+		RspfileContent: "$apps",
+	},
+	"apps", "platformManifest", "platformCertificate", "enforce")
+
+// appBadgingProvider is implemented by modules that build apps, to provide the metadata of the
+// apk that lists the permissions it requests.
+type appBadgingProvider interface {
+	Badging() android.Path
+}
+
+// appCertificateProvider is implemented by modules that build apps, to provide the certificate,
+// without the .x509.pem suffix, that the app is signed with, or an empty string if the app is
+// presigned.
+type appCertificateProvider interface {
+	Certificate() string
+}
+
+func PermissionAuditSingleton() blueprint.Singleton {
+	return &permissionAuditSingleton{}
+}
+
+type permissionAuditSingleton struct{}
+
+func (s *permissionAuditSingleton) GenerateBuildActions(ctx blueprint.SingletonContext) {
+	var apps []string
+	var implicits []string
+	var sysconfigs []string
+	var platformManifest string
+	ctx.VisitAllModules(func(module blueprint.Module) {
+		if m, ok := module.(android.Module); !ok || !m.Enabled() {
+			return
+		}
+		if m, ok := module.(*permissionConfig); ok {
+			if m.configType == "sysconfig" && m.outputFile != nil {
+				sysconfigs = append(sysconfigs, m.outputFile.String())
+			}
+			return
+		}
+		if m, ok := module.(appBadgingProvider); ok && m.Badging() != nil {
+			badging := m.Badging().String()
+			implicits = append(implicits, badging)
+			if c, ok := module.(appCertificateProvider); ok && c.Certificate() != "" {
+				badging += "=" + c.Certificate()
+				implicits = append(implicits, c.Certificate()+".x509.pem")
+			}
+			apps = append(apps, badging)
+		}
+		if m, ok := module.(appManifestProvider); ok && ctx.ModuleName(module) == "framework-res" {
+			if manifest := m.Manifest(); manifest != nil {
+				platformManifest = manifest.String()
+			}
+		}
+	})
+
+	// The script compares the contents of the certificates
+	config := ctx.Config().(android.Config)
+	platformCertificate := config.DefaultAppCertificateDir(ctx).Join(ctx, "platform").String()
+	implicits = append(implicits, platformCertificate+".x509.pem")
+	args := map[string]string{
+		"apps":                strings.Join(apps, " "),
+		"platformCertificate": "--platform-certificate " + platformCertificate,
+	}
+	if platformManifest != "" {
+		args["platformManifest"] = "--platform-manifest " + platformManifest
+		implicits = append(implicits, platformManifest)
+	}
+	if config.EnforcePrivappPermissions() {
+		args["enforce"] = "--enforce"
+	}
+
+	ctx.Build(pctx, blueprint.BuildParams{
+		Rule:        permissionAudit,
+		Description: "permission audit",
+		Outputs:     []string{"$permissionAuditReport"},
+		Inputs:      sysconfigs,
+		Implicits:   implicits,
+		Args:        args,
+	})
+}
+
+func permissionAuditMakeVarsProvider(ctx android.MakeVarsContext) {
+	ctx.Strict("SOONG_PERMISSION_AUDIT_REPORT", "${permissionAuditReport}")
+}
@@ -0,0 +1,204 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etc
+
+// This file contains the default_permissions_xml and sysconfig_xml module types, which install
+// the XML files that grant runtime permissions to preinstalled apps by default and that
+// configure system behaviour for specific packages.  The package manager silently ignores
+// entries for packages that are not installed, so in addition to validating each file, the
+// permission_config singleton checks that every package named by the files is installed by the
+// build.
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/blueprint"
+
+	"android/soong/android"
+)
+
+func init() {
+	android.RegisterModuleType("default_permissions_xml", DefaultPermissionsXmlFactory)
+	android.RegisterModuleType("sysconfig_xml", SysconfigXmlFactory)
+	android.RegisterSingletonType("permission_config", PermissionConfigSingleton)
+
+	pctx.SourcePathVariable("checkPermissionConfigCmd", "build/soong/scripts/check_permission_config.py")
+	pctx.IntermediatesPathVariable("permissionConfigStamp", "permission_config.stamp")
+
+	android.RegisterMakeVarsProvider(pctx, permissionConfigMakeVarsProvider)
+}
+
+var (
+	// checkPermissionConfig validates a default-permissions or sysconfig XML file and copies it
+	// to $out.
+	checkPermissionConfig = pctx.AndroidStaticRule("checkPermissionConfig",
+		blueprint.RuleParams{
+			Command:     `$checkPermissionConfigCmd validate --type $type $in $out`,
+			CommandDeps: []string{"$checkPermissionConfigCmd"},
+		},
+		"type")
+
+	// checkPermissionConfigPackages checks that the packages named by the validated files are
+	// installed, using the package names from the manifests of the apps built by Soong and the
+	// list of packages installed by other means.
+	checkPermissionConfigPackages = pctx.AndroidStaticRule("checkPermissionConfigPackages",
+		blueprint.RuleParams{
+			Command: `$checkPermissionConfigCmd packages --manifests $out.rsp $knownPackages ` +
+				`--stamp $out $in`,
+			CommandDeps:    []string{"$checkPermissionConfigCmd"},
+			Rspfile:        "$out.rsp",
+			RspfileContent: "$manifests",
+		},
+		"manifests", "knownPackages")
+)
+
+// appManifestProvider is implemented by modules that build apps, to provide the manifest that
+// declares the package name of the app.
+type appManifestProvider interface {
+	Manifest() android.Path
+}
+
+type permissionConfigProperties struct {
+	// the XML file to validate and install
+	Src *string
+
+	// name of the installed file.  Defaults to the name of src.
+	Filename *string
+}
+
+type permissionConfig struct {
+	android.ModuleBase
+
+	properties permissionConfigProperties
+
+	// the type of file passed to check_permission_config.py, which is also the name of the
+	// directory under etc that the file is installed to
+	configType string
+
+	outputFile  android.Path
+	installPath android.OutputPath
+}
+
+func newPermissionConfig(configType string) android.Module {
+	module := &permissionConfig{configType: configType}
+	module.AddProperties(&module.properties)
+	android.InitAndroidArchModule(module, android.DeviceSupported, android.MultilibCommon)
+	return module
+}
+
+func DefaultPermissionsXmlFactory() android.Module {
+	return newPermissionConfig("default-permissions")
+}
+
+func SysconfigXmlFactory() android.Module {
+	return newPermissionConfig("sysconfig")
+}
+
+func (m *permissionConfig) DepsMutator(ctx android.BottomUpMutatorContext) {
+}
+
+func (m *permissionConfig) GenerateAndroidBuildActions(ctx android.ModuleContext) {
+	if m.properties.Src == nil {
+		ctx.PropertyErrorf("src", "missing %s XML file", m.configType)
+		return
+	}
+	src := android.PathForModuleSrc(ctx, *m.properties.Src)
+
+	filename := src.Base()
+	if m.properties.Filename != nil {
+		filename = *m.properties.Filename
+	}
+	if filepath.Ext(filename) != ".xml" {
+		ctx.PropertyErrorf("filename", "%q must end in .xml", filename)
+		return
+	}
+
+	outputFile := android.PathForModuleOut(ctx, m.configType, filename)
+	ctx.ModuleBuild(pctx, android.ModuleBuildParams{
+		Rule:        checkPermissionConfig,
+		Description: "check " + m.configType + " " + filename,
+		Output:      outputFile,
+		Input:       src,
+		Args: map[string]string{
+			"type": m.configType,
+		},
+	})
+
+	m.outputFile = outputFile
+	m.installPath = ctx.InstallFile(android.PathForModuleInstall(ctx, "etc", m.configType), outputFile)
+}
+
+func (m *permissionConfig) AndroidMk() android.AndroidMkData {
+	return android.AndroidMkData{
+		Class:      "ETC",
+		OutputFile: android.OptionalPathForPath(m.outputFile),
+		Extra: []android.AndroidMkExtraFunc{
+			func(w io.Writer, outputFile android.Path) {
+				fmt.Fprintln(w, "LOCAL_MODULE_PATH := $(OUT_DIR)/"+filepath.Dir(m.installPath.RelPathString()))
+				fmt.Fprintln(w, "LOCAL_INSTALLED_MODULE_STEM := "+outputFile.Base())
+			},
+		},
+	}
+}
+
+func PermissionConfigSingleton() blueprint.Singleton {
+	return &permissionConfigSingleton{}
+}
+
+type permissionConfigSingleton struct{}
+
+// GenerateBuildActions checks that every package named by the default-permissions and sysconfig
+// files is installed by the build.
+func (s *permissionConfigSingleton) GenerateBuildActions(ctx blueprint.SingletonContext) {
+	var configs []string
+	var manifests []string
+	ctx.VisitAllModules(func(module blueprint.Module) {
+		if m, ok := module.(android.Module); !ok || !m.Enabled() {
+			return
+		}
+		switch m := module.(type) {
+		case *permissionConfig:
+			if m.outputFile != nil {
+				configs = append(configs, m.outputFile.String())
+			}
+		case appManifestProvider:
+			if manifest := m.Manifest(); manifest != nil {
+				manifests = append(manifests, manifest.String())
+			}
+		}
+	})
+
+	config := ctx.Config().(android.Config)
+	knownPackages := android.JoinWithPrefix(config.InstalledAppPackages(), "--known-package ")
+
+	ctx.Build(pctx, blueprint.BuildParams{
+		Rule:        checkPermissionConfigPackages,
+		Description: "check permission config packages",
+		Outputs:     []string{"$permissionConfigStamp"},
+		Inputs:      configs,
+		Implicits:   manifests,
+		Args: map[string]string{
+			"manifests":     strings.Join(manifests, " "),
+			"knownPackages": knownPackages,
+		},
+	})
+}
+
+func permissionConfigMakeVarsProvider(ctx android.MakeVarsContext) {
+	ctx.Strict("SOONG_PERMISSION_CONFIG_CHECK", "${permissionConfigStamp}")
+}
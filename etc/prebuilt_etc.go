@@ -0,0 +1,178 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etc
+
+// This file contains the prebuilt_etc, prebuilt_usr_share and prebuilt_firmware module types,
+// which install a configuration or data file from the source tree as is, replacing the
+// PRODUCT_COPY_FILES entries of device makefiles.  The file is installed into the etc,
+// usr/share or etc/firmware directory of the partition selected by the common vendor,
+// proprietary and system_ext_specific properties, or by the recovery and ramdisk properties.
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"android/soong/android"
+)
+
+func init() {
+	android.RegisterModuleType("prebuilt_etc", PrebuiltEtcFactory)
+	android.RegisterModuleType("prebuilt_usr_share", PrebuiltUsrShareFactory)
+	android.RegisterModuleType("prebuilt_firmware", PrebuiltFirmwareFactory)
+}
+
+type prebuiltEtcProperties struct {
+	// the file to install
+	Src *string `android:"arch_variant"`
+
+	// name of the installed file.  Defaults to the name of src.
+	Filename *string `android:"arch_variant"`
+
+	// directory under the install directory of the module type to install the file into
+	Sub_dir *string `android:"arch_variant"`
+
+	// names of symlinks to the file to install next to it
+	Symlinks []string `android:"arch_variant"`
+
+	// whether the file is installed into the recovery image instead of the system image
+	Recovery *bool
+
+	// whether the file is installed into the ramdisk instead of the system image
+	Ramdisk *bool
+
+	// whether the file is installed.  Defaults to true.
+	Installable *bool
+}
+
+type prebuiltEtc struct {
+	android.ModuleBase
+
+	properties prebuiltEtcProperties
+
+	// the directory relative to the partition that the module type installs files into
+	installDirBase string
+
+	outputFile  android.Path
+	installPath android.OutputPath
+}
+
+func newPrebuiltEtc(installDirBase string) android.Module {
+	module := &prebuiltEtc{installDirBase: installDirBase}
+	module.AddProperties(&module.properties)
+	android.InitAndroidArchModule(module, android.DeviceSupported, android.MultilibFirst)
+	return module
+}
+
+// prebuilt_etc installs a file into the etc directory of a partition.
+func PrebuiltEtcFactory() android.Module {
+	return newPrebuiltEtc("etc")
+}
+
+// prebuilt_usr_share installs a file into the usr/share directory of a partition.
+func PrebuiltUsrShareFactory() android.Module {
+	return newPrebuiltEtc("usr/share")
+}
+
+// prebuilt_firmware installs a firmware file into the etc/firmware directory of a partition.
+func PrebuiltFirmwareFactory() android.Module {
+	return newPrebuiltEtc("etc/firmware")
+}
+
+func (m *prebuiltEtc) InstallInRecovery() bool {
+	return android.Bool(m.properties.Recovery)
+}
+
+func (m *prebuiltEtc) InstallInRamdisk() bool {
+	return android.Bool(m.properties.Ramdisk)
+}
+
+func (m *prebuiltEtc) installable() bool {
+	return m.properties.Installable == nil || *m.properties.Installable
+}
+
+func (m *prebuiltEtc) DepsMutator(ctx android.BottomUpMutatorContext) {
+	if m.properties.Src != nil {
+		android.ExtractSourcesDeps(ctx, []string{*m.properties.Src})
+	}
+}
+
+func (m *prebuiltEtc) GenerateAndroidBuildActions(ctx android.ModuleContext) {
+	if m.properties.Src == nil {
+		ctx.PropertyErrorf("src", "missing prebuilt file")
+		return
+	}
+	if android.Bool(m.properties.Recovery) && android.Bool(m.properties.Ramdisk) {
+		ctx.PropertyErrorf("ramdisk", "can't be set together with recovery")
+		return
+	}
+	srcs := ctx.ExpandSources([]string{*m.properties.Src}, nil)
+	if len(srcs) != 1 {
+		ctx.PropertyErrorf("src", "%q must be a single file", *m.properties.Src)
+		return
+	}
+	src := srcs[0]
+
+	filename := src.Base()
+	if m.properties.Filename != nil {
+		filename = *m.properties.Filename
+		if filename == "" || strings.Contains(filename, "/") {
+			ctx.PropertyErrorf("filename", "%q is not a file name", filename)
+			return
+		}
+	}
+
+	installDir := android.PathForModuleInstall(ctx, m.installDirBase)
+	if m.properties.Sub_dir != nil {
+		installDir = installDir.Join(ctx, *m.properties.Sub_dir)
+	}
+
+	outputFile := android.PathForModuleOut(ctx, filename)
+	ctx.ModuleBuild(pctx, android.ModuleBuildParams{
+		Rule:        android.Cp,
+		Description: "prebuilt " + filename,
+		Output:      outputFile,
+		Input:       src,
+	})
+	m.outputFile = outputFile
+
+	if m.installable() {
+		m.installPath = ctx.InstallFileName(installDir, filename, outputFile)
+		for _, symlink := range m.properties.Symlinks {
+			ctx.InstallSymlink(installDir, symlink, m.installPath)
+		}
+	}
+}
+
+func (m *prebuiltEtc) AndroidMk() android.AndroidMkData {
+	return android.AndroidMkData{
+		Class:      "ETC",
+		OutputFile: android.OptionalPathForPath(m.outputFile),
+		Extra: []android.AndroidMkExtraFunc{
+			func(w io.Writer, outputFile android.Path) {
+				if !m.installable() {
+					fmt.Fprintln(w, "LOCAL_UNINSTALLABLE_MODULE := true")
+					return
+				}
+				fmt.Fprintln(w, "LOCAL_MODULE_PATH := $(OUT_DIR)/"+filepath.Dir(m.installPath.RelPathString()))
+				fmt.Fprintln(w, "LOCAL_INSTALLED_MODULE_STEM := "+outputFile.Base())
+				if len(m.properties.Symlinks) > 0 {
+					fmt.Fprintln(w, "LOCAL_MODULE_SYMLINKS := "+strings.Join(m.properties.Symlinks, " "))
+				}
+			},
+		},
+	}
+}
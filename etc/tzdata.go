@@ -0,0 +1,183 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etc
+
+// This file contains the tzdata and icu_data module types, which validate and install the time
+// zone database and the ICU data file, so that the time zone rules can be refreshed between
+// releases.  The validated files are also available to other modules as ":name" sources, for
+// example to package them into a time zone update distro.  See scripts/check_tzdata.py.
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+
+	"github.com/google/blueprint"
+
+	"android/soong/android"
+)
+
+func init() {
+	android.RegisterModuleType("tzdata", TzdataFactory)
+	android.RegisterModuleType("icu_data", IcuDataFactory)
+
+	pctx.SourcePathVariable("checkTzdataCmd", "build/soong/scripts/check_tzdata.py")
+}
+
+var (
+	checkTzdata = pctx.AndroidStaticRule("checkTzdata",
+		blueprint.RuleParams{
+			Command:     `$checkTzdataCmd tzdata --version $version $in $out`,
+			CommandDeps: []string{"$checkTzdataCmd"},
+		},
+		"version")
+
+	checkIcuData = pctx.AndroidStaticRule("checkIcuData",
+		blueprint.RuleParams{
+			Command:     `$checkTzdataCmd icu $in $out`,
+			CommandDeps: []string{"$checkTzdataCmd"},
+		})
+)
+
+var (
+	tzdataVersionRegexp = regexp.MustCompile(`^[0-9]{4}[a-z]$`)
+	icuVersionRegexp    = regexp.MustCompile(`^[0-9]+$`)
+)
+
+type tzdataProperties struct {
+	// the data file to validate and install
+	Src *string
+
+	// for tzdata, the IANA rules version of the file, for example "2017c".  For icu_data, the
+	// major version of ICU, which must match the name of the file, for example "58" for
+	// icudt58l.dat.
+	Version *string
+}
+
+type tzdata struct {
+	android.ModuleBase
+
+	properties tzdataProperties
+
+	// "tzdata" or "icu"
+	dataType string
+
+	outputFile  android.Path
+	installPath android.OutputPath
+}
+
+var _ android.SourceFileProducer = (*tzdata)(nil)
+
+func newTzdata(dataType string) android.Module {
+	module := &tzdata{dataType: dataType}
+	module.AddProperties(&module.properties)
+	android.InitAndroidArchModule(module, android.DeviceSupported, android.MultilibCommon)
+	return module
+}
+
+func TzdataFactory() android.Module {
+	return newTzdata("tzdata")
+}
+
+func IcuDataFactory() android.Module {
+	return newTzdata("icu")
+}
+
+func (m *tzdata) DepsMutator(ctx android.BottomUpMutatorContext) {
+	if m.properties.Src != nil {
+		android.ExtractSourcesDeps(ctx, []string{*m.properties.Src})
+	}
+}
+
+func (m *tzdata) GenerateAndroidBuildActions(ctx android.ModuleContext) {
+	if m.properties.Src == nil {
+		ctx.PropertyErrorf("src", "missing %s file", m.dataType)
+		return
+	}
+	if m.properties.Version == nil {
+		ctx.PropertyErrorf("version", "missing %s version", m.dataType)
+		return
+	}
+	srcs := ctx.ExpandSources([]string{*m.properties.Src}, nil)
+	if len(srcs) != 1 {
+		ctx.PropertyErrorf("src", "%q must be a single file", *m.properties.Src)
+		return
+	}
+	src := srcs[0]
+	version := *m.properties.Version
+
+	var installDir android.OutputPath
+	var filename string
+	params := android.ModuleBuildParams{
+		Input: src,
+	}
+
+	switch m.dataType {
+	case "tzdata":
+		if !tzdataVersionRegexp.MatchString(version) {
+			ctx.PropertyErrorf("version", "%q is not a time zone rules version, for example 2017c",
+				version)
+			return
+		}
+		params.Rule = checkTzdata
+		params.Args = map[string]string{
+			"version": version,
+		}
+		filename = "tzdata"
+		installDir = android.PathForModuleInstall(ctx, "usr", "share", "zoneinfo")
+	case "icu":
+		if !icuVersionRegexp.MatchString(version) {
+			ctx.PropertyErrorf("version", "%q is not an ICU major version, for example 58", version)
+			return
+		}
+		filename = "icudt" + version + "l.dat"
+		if src.Base() != filename {
+			ctx.PropertyErrorf("src", "%q does not match ICU version %s, expected %s",
+				src.String(), version, filename)
+			return
+		}
+		params.Rule = checkIcuData
+		installDir = android.PathForModuleInstall(ctx, "usr", "icu")
+	default:
+		panic(fmt.Errorf("unknown data type %q", m.dataType))
+	}
+
+	outputFile := android.PathForModuleOut(ctx, filename)
+	params.Output = outputFile
+	params.Description = "check " + m.dataType + " " + version
+	ctx.ModuleBuild(pctx, params)
+
+	m.outputFile = outputFile
+	m.installPath = ctx.InstallFile(installDir, outputFile)
+}
+
+// Srcs returns the validated data file, for modules that package it.
+func (m *tzdata) Srcs() android.Paths {
+	return android.Paths{m.outputFile}
+}
+
+func (m *tzdata) AndroidMk() android.AndroidMkData {
+	return android.AndroidMkData{
+		Class:      "ETC",
+		OutputFile: android.OptionalPathForPath(m.outputFile),
+		Extra: []android.AndroidMkExtraFunc{
+			func(w io.Writer, outputFile android.Path) {
+				fmt.Fprintln(w, "LOCAL_MODULE_PATH := $(OUT_DIR)/"+filepath.Dir(m.installPath.RelPathString()))
+				fmt.Fprintln(w, "LOCAL_INSTALLED_MODULE_STEM := "+outputFile.Base())
+			},
+		},
+	}
+}
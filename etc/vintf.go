@@ -0,0 +1,253 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etc
+
+// This file contains the vintf_manifest module type, which installs the VINTF manifest of a
+// partition.  The manifest is assembled by the vintf_manifest singleton from the base manifest
+// of the module and the vintf_fragments that the modules listed in its deps, and the modules they
+// depend on, install into the same partition.  It is checked against the framework compatibility
+// matrix, so that a device that doesn't serve the HALs that the framework requires fails to build
+// instead of to boot.
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/google/blueprint"
+
+	"android/soong/android"
+)
+
+func init() {
+	android.RegisterModuleType("vintf_manifest", VintfManifestFactory)
+	android.RegisterSingletonType("vintf_manifest", VintfManifestSingleton)
+
+	pctx.HostBinToolVariable("assembleVintfCmd", "assemble_vintf")
+}
+
+var (
+	// assembleVintf merges the manifest fragments given as a colon separated list, and checks
+	// the result against the compatibility matrix if one is given.
+	assembleVintf = pctx.AndroidStaticRule("assembleVintf",
+		blueprint.RuleParams{
+			Command:     `$assembleVintfCmd -i $inputs -o $out $checkFlags`,
+			CommandDeps: []string{"$assembleVintfCmd"},
+		},
+		"inputs", "checkFlags")
+)
+
+type vintfManifestProperties struct {
+	// the manifest with the HALs that are not declared by the vintf_fragments of modules
+	Src *string
+
+	// the framework compatibility matrix that the assembled manifest is checked against
+	Compatibility_matrix *string
+
+	// modules installed on the device whose vintf_fragments are added to the manifest, together
+	// with the fragments of the modules they depend on.  The fragments are found in whichever
+	// variant of a module installs them, so 32-bit only modules and apps can be listed too.
+	Deps []string
+
+	// name of the installed file.  Defaults to "manifest.xml".
+	Filename *string
+}
+
+type vintfManifest struct {
+	android.ModuleBase
+
+	properties vintfManifestProperties
+
+	src                 android.Path
+	compatibilityMatrix android.Path
+
+	// the directory that the modules of the partition install their fragments into
+	fragmentDir android.OutputPath
+
+	outputFile  android.ModuleOutPath
+	installPath android.OutputPath
+}
+
+func VintfManifestFactory() android.Module {
+	module := &vintfManifest{}
+	module.AddProperties(&module.properties)
+	android.InitAndroidArchModule(module, android.DeviceSupported, android.MultilibFirst)
+	return module
+}
+
+func (m *vintfManifest) DepsMutator(ctx android.BottomUpMutatorContext) {
+	if m.properties.Src != nil {
+		android.ExtractSourcesDeps(ctx, []string{*m.properties.Src})
+	}
+	if m.properties.Compatibility_matrix != nil {
+		android.ExtractSourcesDeps(ctx, []string{*m.properties.Compatibility_matrix})
+	}
+
+	// The singleton finds the fragments of the deps by name, because the variant that installs
+	// them depends on the module type
+	for _, dep := range m.properties.Deps {
+		if !ctx.OtherModuleExists(dep) {
+			ctx.PropertyErrorf("deps", "unknown module %q", dep)
+		}
+	}
+}
+
+func (m *vintfManifest) GenerateAndroidBuildActions(ctx android.ModuleContext) {
+	if m.properties.Src == nil {
+		ctx.PropertyErrorf("src", "missing the base manifest")
+		return
+	}
+	filename := "manifest.xml"
+	if m.properties.Filename != nil {
+		filename = *m.properties.Filename
+	}
+	if filepath.Ext(filename) != ".xml" || strings.Contains(filename, "/") {
+		ctx.PropertyErrorf("filename", "%q must be the name of an .xml file", filename)
+		return
+	}
+
+	// The manifest is assembled by the singleton, which sees the fragments of the deps
+	m.src = m.singleSource(ctx, "src", *m.properties.Src)
+	if m.properties.Compatibility_matrix != nil {
+		m.compatibilityMatrix = m.singleSource(ctx, "compatibility_matrix", *m.properties.Compatibility_matrix)
+	}
+	if ctx.Failed() {
+		m.src = nil
+		return
+	}
+	m.fragmentDir = android.PathForModuleInstall(ctx, "etc", "vintf", "manifest")
+	m.outputFile = android.PathForModuleOut(ctx, filename)
+	m.installPath = ctx.InstallFile(android.PathForModuleInstall(ctx, "etc", "vintf"), m.outputFile)
+}
+
+// singleSource returns the file of a property that is a path or a reference to a module that
+// produces a single file.
+func (m *vintfManifest) singleSource(ctx android.ModuleContext, property, src string) android.Path {
+	srcs := ctx.ExpandSources([]string{src}, nil)
+	if len(srcs) != 1 {
+		ctx.PropertyErrorf(property, "%q must be a single file, found %d", src, len(srcs))
+		return nil
+	}
+	return srcs[0]
+}
+
+func (m *vintfManifest) AndroidMk() android.AndroidMkData {
+	return android.AndroidMkData{
+		Class:      "ETC",
+		OutputFile: android.OptionalPathForPath(m.outputFile),
+		Extra: []android.AndroidMkExtraFunc{
+			func(w io.Writer, outputFile android.Path) {
+				fmt.Fprintln(w, "LOCAL_MODULE_PATH := $(OUT_DIR)/"+filepath.Dir(m.installPath.RelPathString()))
+				fmt.Fprintln(w, "LOCAL_INSTALLED_MODULE_STEM := "+outputFile.Base())
+			},
+		},
+	}
+}
+
+func VintfManifestSingleton() blueprint.Singleton {
+	return &vintfManifestSingleton{}
+}
+
+type vintfManifestSingleton struct{}
+
+// vintfFragmentProvider is implemented by all modules through android.ModuleBase.
+type vintfFragmentProvider interface {
+	VintfFragments() []android.PackagingSpec
+}
+
+// vintfModuleVisitor is the part of blueprint.SingletonContext that is needed to find the
+// fragments of the manifests, which the blueprint.Context of tests also implements.
+type vintfModuleVisitor interface {
+	VisitAllModules(visit func(blueprint.Module))
+	VisitDepsDepthFirst(module blueprint.Module, visit func(blueprint.Module))
+	ModuleName(module blueprint.Module) string
+}
+
+// vintfManifestFragments returns the vintf_manifest modules, and for each of them the sorted
+// fragments that its deps and their dependencies install into its partition.
+func vintfManifestFragments(ctx vintfModuleVisitor) ([]*vintfManifest, map[*vintfManifest][]string) {
+	var manifests []*vintfManifest
+	modules := make(map[string][]blueprint.Module)
+	ctx.VisitAllModules(func(module blueprint.Module) {
+		if m, ok := module.(android.Module); !ok || !m.Enabled() {
+			return
+		}
+		if m, ok := module.(*vintfManifest); ok && m.src != nil {
+			manifests = append(manifests, m)
+		}
+		name := ctx.ModuleName(module)
+		modules[name] = append(modules[name], module)
+	})
+
+	fragments := make(map[*vintfManifest][]string)
+	for _, m := range manifests {
+		seen := make(map[string]bool)
+		var partitionFragments []string
+		addFragments := func(module blueprint.Module) {
+			p, ok := module.(vintfFragmentProvider)
+			if !ok {
+				return
+			}
+			for _, spec := range p.VintfFragments() {
+				if filepath.Dir(spec.InstallPath.String()) != m.fragmentDir.String() {
+					continue
+				}
+				if fragment := spec.SrcPath.String(); !seen[fragment] {
+					seen[fragment] = true
+					partitionFragments = append(partitionFragments, fragment)
+				}
+			}
+		}
+		for _, dep := range m.properties.Deps {
+			for _, module := range modules[dep] {
+				addFragments(module)
+				ctx.VisitDepsDepthFirst(module, addFragments)
+			}
+		}
+		sort.Strings(partitionFragments)
+		fragments[m] = partitionFragments
+	}
+	return manifests, fragments
+}
+
+// GenerateBuildActions assembles the manifest of each vintf_manifest module from the fragments
+// that its deps and their dependencies install into its partition.
+func (s *vintfManifestSingleton) GenerateBuildActions(ctx blueprint.SingletonContext) {
+	manifests, fragments := vintfManifestFragments(ctx)
+	for _, m := range manifests {
+		partitionFragments := fragments[m]
+		inputs := append([]string{m.src.String()}, partitionFragments...)
+		implicits := []string{}
+		checkFlags := ""
+		if m.compatibilityMatrix != nil {
+			checkFlags = "-c " + m.compatibilityMatrix.String()
+			implicits = append(implicits, m.compatibilityMatrix.String())
+		}
+
+		ctx.Build(pctx, blueprint.BuildParams{
+			Rule:        assembleVintf,
+			Description: "assemble vintf " + m.outputFile.Rel(),
+			Outputs:     []string{m.outputFile.String()},
+			Inputs:      inputs,
+			Implicits:   implicits,
+			Args: map[string]string{
+				"inputs":     strings.Join(inputs, ":"),
+				"checkFlags": checkFlags,
+			},
+		})
+	}
+}
@@ -0,0 +1,289 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filesystem
+
+// This file contains the bootimg module type, which assembles a kernel, a ramdisk built by an
+// android_filesystem module and a device tree blob into a boot, recovery or vendor_boot image
+// with mkbootimg, and optionally adds an AVB hash footer to it.
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/google/blueprint"
+
+	"android/soong/android"
+)
+
+func init() {
+	android.RegisterModuleType("bootimg", BootimgFactory)
+
+	pctx.HostBinToolVariable("mkbootimgCmd", "mkbootimg")
+}
+
+var (
+	mkbootimg = pctx.AndroidStaticRule("mkbootimg",
+		blueprint.RuleParams{
+			Command:     `$mkbootimgCmd $flags $outputFlag $out && $avbCommand`,
+			CommandDeps: []string{"$mkbootimgCmd", "$avbtoolCmd"},
+		},
+		"flags", "outputFlag", "avbCommand")
+)
+
+// The boot image header versions that mkbootimg can write.  A dtb is supported from version 2,
+// and vendor_boot images from version 3.
+var bootimgHeaderVersions = []string{"0", "1", "2", "3", "4"}
+
+type bootimgProperties struct {
+	// the kernel image
+	Kernel_prebuilt *string `android:"arch_variant"`
+
+	// the device tree blob, included in images with header version 2 or higher
+	Dtb_prebuilt *string `android:"arch_variant"`
+
+	// name of the android_filesystem module that builds the ramdisk, which must be of type
+	// "compressed_cpio" or "cpio"
+	Ramdisk_module *string
+
+	// the version of the boot image header.  Defaults to "0".
+	Header_version *string
+
+	// list of kernel command line arguments
+	Cmdline []string `android:"arch_variant"`
+
+	// whether a vendor_boot image is built instead of a boot image.  Requires header version 3
+	// or higher.
+	Vendor_boot *bool
+
+	// the name of the partition that the image is flashed to, used for the AVB footer.
+	// Defaults to "vendor_boot" for vendor_boot images and "boot" otherwise.
+	Partition_name *string
+
+	// whether the image is signed with a hash footer by avbtool
+	Use_avb *bool
+
+	// the key that the hash footer is signed with.  Defaults to the test key of avbtool.
+	Avb_private_key *string
+
+	// the signing algorithm of the hash footer.  Defaults to "SHA256_RSA4096".
+	Avb_algorithm *string
+}
+
+type bootimg struct {
+	android.ModuleBase
+
+	properties bootimgProperties
+
+	outputFile android.ModuleOutPath
+}
+
+type bootimgDependencyTag struct {
+	blueprint.BaseDependencyTag
+}
+
+var ramdiskTag bootimgDependencyTag
+
+func BootimgFactory() android.Module {
+	module := &bootimg{}
+	module.AddProperties(&module.properties)
+	android.InitAndroidArchModule(module, android.DeviceSupported, android.MultilibFirst)
+	return module
+}
+
+func (b *bootimg) vendorBoot() bool {
+	return android.Bool(b.properties.Vendor_boot)
+}
+
+func (b *bootimg) partitionName() string {
+	if b.properties.Partition_name != nil {
+		return *b.properties.Partition_name
+	}
+	if b.vendorBoot() {
+		return "vendor_boot"
+	}
+	return "boot"
+}
+
+func (b *bootimg) headerVersion() string {
+	if b.properties.Header_version != nil {
+		return *b.properties.Header_version
+	}
+	return "0"
+}
+
+func (b *bootimg) DepsMutator(ctx android.BottomUpMutatorContext) {
+	var srcs []string
+	for _, s := range []*string{b.properties.Kernel_prebuilt, b.properties.Dtb_prebuilt,
+		b.properties.Avb_private_key} {
+		if s != nil {
+			srcs = append(srcs, *s)
+		}
+	}
+	android.ExtractSourcesDeps(ctx, srcs)
+
+	if b.properties.Ramdisk_module != nil {
+		ctx.AddVariationDependencies(nil, ramdiskTag, *b.properties.Ramdisk_module)
+	}
+}
+
+func (b *bootimg) GenerateAndroidBuildActions(ctx android.ModuleContext) {
+	version := b.headerVersion()
+	if !inList(version, bootimgHeaderVersions) {
+		ctx.PropertyErrorf("header_version", "%q is not one of %s", version,
+			strings.Join(bootimgHeaderVersions, ", "))
+		return
+	}
+	v, _ := strconv.Atoi(version)
+	if b.vendorBoot() {
+		if v < 3 {
+			ctx.PropertyErrorf("vendor_boot", "requires header_version 3 or higher")
+		}
+		if b.properties.Kernel_prebuilt != nil {
+			ctx.PropertyErrorf("kernel_prebuilt", "not supported in vendor_boot images")
+		}
+	} else if b.properties.Kernel_prebuilt == nil {
+		ctx.PropertyErrorf("kernel_prebuilt", "missing the kernel")
+	}
+	if b.properties.Dtb_prebuilt != nil && v < 2 {
+		ctx.PropertyErrorf("dtb_prebuilt", "requires header_version 2 or higher")
+	}
+	if ctx.Failed() {
+		return
+	}
+
+	var implicits android.Paths
+	flags := []string{"--header_version " + version}
+
+	if b.properties.Kernel_prebuilt != nil {
+		kernel := android.PathForModuleSrc(ctx, *b.properties.Kernel_prebuilt)
+		flags = append(flags, "--kernel "+kernel.String())
+		implicits = append(implicits, kernel)
+	}
+	if b.properties.Dtb_prebuilt != nil {
+		dtb := android.PathForModuleSrc(ctx, *b.properties.Dtb_prebuilt)
+		flags = append(flags, "--dtb "+dtb.String())
+		implicits = append(implicits, dtb)
+	}
+	if b.properties.Ramdisk_module != nil {
+		ramdisk := b.ramdisk(ctx)
+		if ramdisk == nil {
+			return
+		}
+		if b.vendorBoot() {
+			flags = append(flags, "--vendor_ramdisk "+ramdisk.String())
+		} else {
+			flags = append(flags, "--ramdisk "+ramdisk.String())
+		}
+		implicits = append(implicits, ramdisk)
+	}
+	if len(b.properties.Cmdline) > 0 {
+		cmdline := "--cmdline"
+		if b.vendorBoot() {
+			cmdline = "--vendor_cmdline"
+		}
+		flags = append(flags, cmdline+` "`+strings.Join(b.properties.Cmdline, " ")+`"`)
+	}
+
+	b.outputFile = android.PathForModuleOut(ctx, b.partitionName()+".img")
+	outputFlag := "--output"
+	if b.vendorBoot() {
+		// mkbootimg writes the vendor_boot image to --vendor_boot and the boot image to --output
+		outputFlag = "--vendor_boot"
+	}
+
+	avbCommand := "true"
+	if android.Bool(b.properties.Use_avb) {
+		avbCommand = fmt.Sprintf("$avbtoolCmd add_hash_footer --image $out --partition_name %s "+
+			"--dynamic_partition_size %s", b.partitionName(),
+			avbSigningFlags(ctx, b.properties.Avb_private_key, b.properties.Avb_algorithm))
+		if b.properties.Avb_private_key != nil {
+			implicits = append(implicits, android.PathForModuleSrc(ctx, *b.properties.Avb_private_key))
+		}
+	}
+
+	ctx.ModuleBuild(pctx, android.ModuleBuildParams{
+		Rule:        mkbootimg,
+		Description: "boot image " + b.outputFile.Base(),
+		Output:      b.outputFile,
+		Implicits:   implicits,
+		Args: map[string]string{
+			"flags":      strings.Join(flags, " "),
+			"outputFlag": outputFlag,
+			"avbCommand": avbCommand,
+		},
+	})
+}
+
+// ramdisk returns the ramdisk image built by the ramdisk_module.
+func (b *bootimg) ramdisk(ctx android.ModuleContext) android.Path {
+	var ramdisk android.Path
+	ctx.VisitDirectDeps(func(module blueprint.Module) {
+		if ctx.OtherModuleDependencyTag(module) != ramdiskTag {
+			return
+		}
+		f, ok := module.(*filesystem)
+		if !ok {
+			ctx.PropertyErrorf("ramdisk_module", "%q is not an android_filesystem module",
+				ctx.OtherModuleName(module))
+			return
+		}
+		if f.fsType() == ext4Type {
+			ctx.PropertyErrorf("ramdisk_module", "%q is not a cpio image", ctx.OtherModuleName(module))
+			return
+		}
+		if p := f.OutputFile(); p.Valid() {
+			ramdisk = p.Path()
+		}
+	})
+	if ramdisk == nil && !ctx.Failed() {
+		ctx.PropertyErrorf("ramdisk_module", "%q doesn't build an image", *b.properties.Ramdisk_module)
+	}
+	return ramdisk
+}
+
+// OutputFile returns the boot image, so that its descriptors can be included in a vbmeta image.
+func (b *bootimg) OutputFile() android.OptionalPath {
+	return android.OptionalPathForPath(b.outputFile)
+}
+
+func (b *bootimg) AndroidMk() android.AndroidMkData {
+	return android.AndroidMkData{
+		Class:      "ETC",
+		OutputFile: android.OptionalPathForPath(b.outputFile),
+		Extra: []android.AndroidMkExtraFunc{
+			func(w io.Writer, outputFile android.Path) {
+				fmt.Fprintln(w, "LOCAL_UNINSTALLABLE_MODULE := true")
+			},
+		},
+	}
+}
+
+// avbSigningFlags returns the avbtool flags that sign an image with the key and algorithm, or
+// with the test key of avbtool if no key is given.
+func avbSigningFlags(ctx android.ModuleContext, key, algorithm *string) string {
+	alg := "SHA256_RSA4096"
+	if algorithm != nil {
+		alg = *algorithm
+	}
+	flags := "--algorithm " + alg
+	if key != nil {
+		flags += " --key " + android.PathForModuleSrc(ctx, *key).String()
+	} else {
+		flags += " --key external/avb/test/data/testkey_rsa4096.pem"
+	}
+	return flags
+}
@@ -0,0 +1,491 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filesystem
+
+// The filesystem package contains the android_filesystem module type, which builds a filesystem
+// image from the files installed by its deps and their dependencies, without the Make
+// packaging stage.  Only the files that the modules install into the partition of the image are
+// included, so that the shared libraries of a vendor binary that live in /system are left out
+// of a vendor image.
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/google/blueprint"
+
+	"android/soong/android"
+)
+
+var pctx = android.NewPackageContext("android/soong/filesystem")
+
+func init() {
+	android.RegisterModuleType("android_filesystem", FilesystemFactory)
+	android.PostDepsMutators(func(ctx android.RegisterMutatorsContext) {
+		ctx.BottomUp("filesystem_required_names", requiredNamesMutator).Parallel()
+		ctx.BottomUp("filesystem_required", requiredMutator).Parallel()
+	})
+
+	pctx.SourcePathVariable("buildImageCmd", "build/make/tools/releasetools/build_image.py")
+	pctx.HostBinToolVariable("mkbootfsCmd", "mkbootfs")
+	pctx.HostBinToolVariable("lz4Cmd", "lz4")
+	pctx.HostBinToolVariable("avbtoolCmd", "avbtool")
+	pctx.HostBinToolVariable("mkuserimgCmd", "mkuserimg_mke2fs")
+}
+
+var (
+	// buildExt4Image stages the files of the image and builds it with build_image.py, which
+	// finds mkuserimg_mke2fs and the e2fsprogs next to it on the PATH.  The commands that copy
+	// the files are run from a response file, as an image has too many files for them to fit in
+	// the argument of sh -c.
+	buildExt4Image = pctx.AndroidStaticRule("buildExt4Image",
+		blueprint.RuleParams{
+			Command: `rm -rf $stagingDir && mkdir -p $stagingDir && /bin/bash -e $out.rsp && ` +
+				`PATH=$$(dirname $mkuserimgCmd):$$PATH $buildImageCmd $stagingDir $propFile $out $stagingDir`,
+			CommandDeps:    []string{"$buildImageCmd", "$mkuserimgCmd", "$avbtoolCmd"},
+			Rspfile:        "$out.rsp",
+			RspfileContent: "$copyCommands",
+		},
+		"stagingDir", "copyCommands", "propFile")
+
+	buildCpioImage = pctx.AndroidStaticRule("buildCpioImage",
+		blueprint.RuleParams{
+			Command: `rm -rf $stagingDir && mkdir -p $stagingDir && /bin/bash -e $out.rsp && ` +
+				`$mkbootfsCmd $stagingDir $compress > $out`,
+			CommandDeps:    []string{"$mkbootfsCmd", "$lz4Cmd"},
+			Rspfile:        "$out.rsp",
+			RspfileContent: "$copyCommands",
+		},
+		"stagingDir", "copyCommands", "compress")
+)
+
+// The types of images that android_filesystem builds.
+const (
+	ext4Type           = "ext4"
+	cpioType           = "cpio"
+	compressedCpioType = "compressed_cpio"
+)
+
+var filesystemTypes = []string{ext4Type, cpioType, compressedCpioType}
+
+type filesystemProperties struct {
+	// list of modules whose installed files are included in the image, together with the
+	// files installed by the modules they depend on and the modules they require
+	Deps []string
+
+	// the partition that the image is built for: "system", "vendor", "system_ext", "ramdisk" or
+	// "recovery".  Only the files installed into that partition are included in the image.
+	// Defaults to "system".
+	Partition_name *string
+
+	// the type of the image: "ext4", "cpio" or "compressed_cpio".  Defaults to "ext4".
+	Type *string
+
+	// the file_contexts file that labels the files of an ext4 image
+	File_contexts *string
+
+	// whether the image is signed with a hashtree footer by avbtool
+	Use_avb *bool
+
+	// the key that the hashtree footer is signed with.  Defaults to the test key of avbtool.
+	Avb_private_key *string
+
+	// the signing algorithm of the hashtree footer.  Defaults to "SHA256_RSA4096".
+	Avb_algorithm *string
+}
+
+type filesystem struct {
+	android.ModuleBase
+
+	properties filesystemProperties
+
+	outputFile android.ModuleOutPath
+}
+
+type filesystemDependencyTag struct {
+	blueprint.BaseDependencyTag
+	name string
+}
+
+var (
+	depTag      = filesystemDependencyTag{name: "deps"}
+	requiredTag = filesystemDependencyTag{name: "required"}
+)
+
+func FilesystemFactory() android.Module {
+	module := &filesystem{}
+	module.AddProperties(&module.properties)
+	android.InitAndroidArchModule(module, android.DeviceSupported, android.MultilibFirst)
+	return module
+}
+
+func (f *filesystem) partitionName() string {
+	if f.properties.Partition_name != nil {
+		return *f.properties.Partition_name
+	}
+	return "system"
+}
+
+func (f *filesystem) fsType() string {
+	if f.properties.Type != nil {
+		return *f.properties.Type
+	}
+	return ext4Type
+}
+
+// partitionDir returns the directory of the product out directory that the files of the
+// partition are installed into.
+func (f *filesystem) partitionDir(ctx android.ModuleContext) (string, bool) {
+	switch f.partitionName() {
+	case "system":
+		return "system", true
+	case "vendor":
+		return ctx.DeviceConfig().VendorPath(), true
+	case "system_ext":
+		return ctx.DeviceConfig().SystemExtPath(), true
+	case "ramdisk":
+		return "ramdisk", true
+	case "recovery":
+		return "recovery/root", true
+	}
+	return "", false
+}
+
+func (f *filesystem) DepsMutator(ctx android.BottomUpMutatorContext) {
+	var srcs []string
+	for _, s := range []*string{f.properties.File_contexts, f.properties.Avb_private_key} {
+		if s != nil {
+			srcs = append(srcs, *s)
+		}
+	}
+	android.ExtractSourcesDeps(ctx, srcs)
+
+	ctx.AddFarVariationDependencies(installVariations(ctx), depTag, f.properties.Deps...)
+}
+
+// installVariations returns the variations that the dependencies of an image are requested
+// with.  They are a superset of the variations of the module types that install files, so that
+// the dependency matches binaries, shared libraries and prebuilts.
+func installVariations(ctx android.BottomUpMutatorContext) []blueprint.Variation {
+	image := "core"
+	if ctx.Vendor() {
+		image = "vendor"
+	}
+	return []blueprint.Variation{
+		{Mutator: "arch", Variation: ctx.Target().String()},
+		{Mutator: "image", Variation: image},
+		{Mutator: "sdk", Variation: ""},
+		{Mutator: "link", Variation: "shared"},
+	}
+}
+
+// requiredModules maps the names of device modules to the modules required by their variants and
+// to the modules that their variants depend on.
+type requiredModules struct {
+	sync.Mutex
+	required map[string][]string
+	deps     map[string][]string
+}
+
+const requiredModulesOnceKey = "filesystemRequiredModules"
+
+func getRequiredModules(config android.Config) *requiredModules {
+	return config.Once(requiredModulesOnceKey, func() interface{} {
+		return &requiredModules{
+			required: make(map[string][]string),
+			deps:     make(map[string][]string),
+		}
+	}).(*requiredModules)
+}
+
+// requiredNamesMutator records the modules required by each device module and its direct
+// dependencies, so that requiredMutator can follow required modules by name, as they aren't
+// dependencies.  Only the direct dependencies are recorded, so that the transitive dependencies
+// are only walked for the modules in images.
+func requiredNamesMutator(ctx android.BottomUpMutatorContext) {
+	if !ctx.Device() {
+		return
+	}
+	if m, ok := ctx.Module().(android.Module); !ok || !m.Enabled() {
+		return
+	}
+
+	required := ctx.Module().(android.Module).RequiredModuleNames()
+	var deps []string
+	ctx.VisitDirectDeps(func(module blueprint.Module) {
+		if m, ok := module.(android.Module); ok && m.Enabled() {
+			deps = append(deps, ctx.OtherModuleName(module))
+		}
+	})
+	if len(required) == 0 && len(deps) == 0 {
+		return
+	}
+
+	r := getRequiredModules(ctx.AConfig())
+	r.Lock()
+	defer r.Unlock()
+	name := ctx.ModuleName()
+	r.required[name] = append(r.required[name], required...)
+	r.deps[name] = append(r.deps[name], deps...)
+}
+
+// requiredMutator adds dependencies from images on the modules required by the modules in the
+// image, and on the modules that those and their dependencies require in turn, so that the runtime
+// dependencies that Make would install with LOCAL_REQUIRED_MODULES are also in the image.
+// Required modules that are only defined in Make are skipped, and like the deps, required modules
+// must be built for the architecture of the device.
+func requiredMutator(ctx android.BottomUpMutatorContext) {
+	if _, ok := ctx.Module().(*filesystem); !ok {
+		return
+	}
+
+	seen := make(map[string]bool)
+	var required []string
+	var queue []string
+	ctx.VisitDepsDepthFirst(func(module blueprint.Module) {
+		if m, ok := module.(android.Module); ok && m.Enabled() {
+			queue = append(queue, m.RequiredModuleNames()...)
+		}
+	})
+
+	r := getRequiredModules(ctx.AConfig())
+	r.Lock()
+	defer r.Unlock()
+
+	// visit queues the modules required by a required module and by its dependencies
+	visited := make(map[string]bool)
+	var visit func(name string)
+	visit = func(name string) {
+		if visited[name] {
+			return
+		}
+		visited[name] = true
+		queue = append(queue, r.required[name]...)
+		for _, dep := range r.deps[name] {
+			visit(dep)
+		}
+	}
+
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		if seen[name] || !ctx.OtherModuleExists(name) {
+			continue
+		}
+		seen[name] = true
+		required = append(required, name)
+		visit(name)
+	}
+
+	ctx.AddFarVariationDependencies(installVariations(ctx), requiredTag, required...)
+}
+
+func (f *filesystem) GenerateAndroidBuildActions(ctx android.ModuleContext) {
+	if !inList(f.fsType(), filesystemTypes) {
+		ctx.PropertyErrorf("type", "%q is not one of %s", f.fsType(), strings.Join(filesystemTypes, ", "))
+		return
+	}
+	dir, ok := f.partitionDir(ctx)
+	if !ok {
+		ctx.PropertyErrorf("partition_name", "unknown partition %q", f.partitionName())
+		return
+	}
+	if f.fsType() != ext4Type {
+		if f.properties.File_contexts != nil {
+			ctx.PropertyErrorf("file_contexts", "only supported by ext4 images")
+		}
+		if f.properties.Use_avb != nil {
+			ctx.PropertyErrorf("use_avb", "only supported by ext4 images")
+		}
+	}
+	if ctx.Failed() {
+		return
+	}
+
+	root := android.PathForOutput(ctx, "target", "product", ctx.AConfig().DeviceName(), dir)
+	stagingDir := android.PathForModuleOut(ctx, "root")
+	copyCommands, implicits := f.stageFiles(ctx, root, stagingDir)
+	if ctx.Failed() {
+		return
+	}
+
+	f.outputFile = android.PathForModuleOut(ctx, f.partitionName()+".img")
+	switch f.fsType() {
+	case ext4Type:
+		propFile := f.buildPropFile(ctx)
+		implicits = append(implicits, propFile)
+		if f.properties.File_contexts != nil {
+			implicits = append(implicits, android.PathForModuleSrc(ctx, *f.properties.File_contexts))
+		}
+		if f.properties.Avb_private_key != nil {
+			implicits = append(implicits, android.PathForModuleSrc(ctx, *f.properties.Avb_private_key))
+		}
+		ctx.ModuleBuild(pctx, android.ModuleBuildParams{
+			Rule:        buildExt4Image,
+			Description: "filesystem image " + f.outputFile.Base(),
+			Output:      f.outputFile,
+			Implicits:   implicits,
+			Args: map[string]string{
+				"stagingDir":   stagingDir.String(),
+				"copyCommands": strings.Join(copyCommands, " && "),
+				"propFile":     propFile.String(),
+			},
+		})
+	case cpioType, compressedCpioType:
+		compress := ""
+		if f.fsType() == compressedCpioType {
+			compress = "| $lz4Cmd -l -12 --favor-decSpeed"
+		}
+		ctx.ModuleBuild(pctx, android.ModuleBuildParams{
+			Rule:        buildCpioImage,
+			Description: "filesystem image " + f.outputFile.Base(),
+			Output:      f.outputFile,
+			Implicits:   implicits,
+			Args: map[string]string{
+				"stagingDir":   stagingDir.String(),
+				"copyCommands": strings.Join(copyCommands, " && "),
+				"compress":     compress,
+			},
+		})
+	}
+}
+
+// stageFiles returns the commands that copy the files installed into root by the deps and
+// their dependencies into the staging directory, and the files they copy.
+func (f *filesystem) stageFiles(ctx android.ModuleContext, root android.OutputPath,
+	stagingDir android.ModuleOutPath) ([]string, android.Paths) {
+	specs := make(map[string]android.PackagingSpec)
+	collect := func(module blueprint.Module) bool {
+		found := false
+		if m, ok := module.(android.Module); ok && m.Enabled() {
+			for _, spec := range m.PackagingSpecs() {
+				if rel, ok := spec.RelPathIn(root); ok {
+					specs[rel] = spec
+					found = true
+				}
+			}
+		}
+		return found
+	}
+
+	ctx.VisitDirectDeps(func(module blueprint.Module) {
+		if ctx.OtherModuleDependencyTag(module) == depTag && !collect(module) {
+			ctx.ModuleErrorf("%q doesn't install any files into %s", ctx.OtherModuleName(module),
+				f.partitionName())
+		}
+	})
+	ctx.VisitDepsDepthFirst(func(module blueprint.Module) {
+		collect(module)
+	})
+
+	var rels []string
+	for rel := range specs {
+		rels = append(rels, rel)
+	}
+	sort.Strings(rels)
+
+	var copyCommands []string
+	var implicits android.Paths
+	for _, rel := range rels {
+		spec := specs[rel]
+		dest := stagingDir.Join(ctx, rel)
+		if spec.SrcPath == nil {
+			copyCommands = append(copyCommands, fmt.Sprintf("mkdir -p $$(dirname %s) && ln -sf %s %s",
+				dest, spec.SymlinkTarget, dest))
+		} else {
+			copyCommands = append(copyCommands, fmt.Sprintf("mkdir -p $$(dirname %s) && cp -f %s %s",
+				dest, spec.SrcPath, dest))
+			implicits = append(implicits, spec.SrcPath)
+		}
+	}
+	if len(copyCommands) == 0 {
+		copyCommands = []string{"true"}
+	}
+	return copyCommands, implicits
+}
+
+// buildPropFile writes the image properties that build_image.py reads.
+func (f *filesystem) buildPropFile(ctx android.ModuleContext) android.ModuleOutPath {
+	props := []string{
+		"fs_type=" + ext4Type,
+		"mount_point=" + f.partitionName(),
+		"use_dynamic_partition_size=true",
+		"ext_mkuserimg=mkuserimg_mke2fs",
+	}
+	if f.properties.File_contexts != nil {
+		props = append(props, "selinux_fc="+android.PathForModuleSrc(ctx, *f.properties.File_contexts).String())
+	}
+	if android.Bool(f.properties.Use_avb) {
+		algorithm := "SHA256_RSA4096"
+		if f.properties.Avb_algorithm != nil {
+			algorithm = *f.properties.Avb_algorithm
+		}
+		props = append(props,
+			"avb_hashtree_enable=true",
+			"avb_avbtool=avbtool",
+			"avb_algorithm="+algorithm,
+			"partition_name="+f.partitionName())
+		if f.properties.Avb_private_key != nil {
+			props = append(props,
+				"avb_key_path="+android.PathForModuleSrc(ctx, *f.properties.Avb_private_key).String())
+		}
+	}
+
+	propFile := android.PathForModuleOut(ctx, "prop")
+	ctx.ModuleBuild(pctx, android.ModuleBuildParams{
+		Rule:        android.WriteFile,
+		Description: "filesystem image properties " + f.partitionName(),
+		Output:      propFile,
+		Args: map[string]string{
+			"content": strings.Join(props, `\n`),
+		},
+	})
+	return propFile
+}
+
+var _ android.PartitionImage = (*filesystem)(nil)
+
+// PartitionImage marks the filesystem as the image of a partition, which may package the modules
+// of any partition.
+func (f *filesystem) PartitionImage() {}
+
+// OutputFile returns the image, so that it can be packaged into other images.
+func (f *filesystem) OutputFile() android.OptionalPath {
+	return android.OptionalPathForPath(f.outputFile)
+}
+
+func (f *filesystem) AndroidMk() android.AndroidMkData {
+	return android.AndroidMkData{
+		Class:      "ETC",
+		OutputFile: android.OptionalPathForPath(f.outputFile),
+		Extra: []android.AndroidMkExtraFunc{
+			func(w io.Writer, outputFile android.Path) {
+				fmt.Fprintln(w, "LOCAL_UNINSTALLABLE_MODULE := true")
+			},
+		},
+	}
+}
+
+func inList(s string, list []string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
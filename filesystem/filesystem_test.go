@@ -0,0 +1,385 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filesystem
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"android/soong/android"
+)
+
+var buildDir string
+
+func setUp() {
+	var err error
+	buildDir, err = ioutil.TempDir("", "soong_filesystem_test")
+	if err != nil {
+		panic(err)
+	}
+}
+
+func tearDown() {
+	os.RemoveAll(buildDir)
+}
+
+func TestMain(m *testing.M) {
+	run := func() int {
+		setUp()
+		defer tearDown()
+
+		return m.Run()
+	}
+
+	os.Exit(run())
+}
+
+type installModule struct {
+	android.ModuleBase
+	properties struct {
+		Src  *string
+		Deps []string
+	}
+}
+
+// newInstallModule returns a device module that installs its src into the etc directory of its
+// partition, and depends on the modules in its deps.
+func newInstallModule() android.Module {
+	m := &installModule{}
+	m.AddProperties(&m.properties)
+	android.InitAndroidArchModule(m, android.DeviceSupported, android.MultilibFirst)
+	return m
+}
+
+func (m *installModule) DepsMutator(ctx android.BottomUpMutatorContext) {
+	ctx.AddDependency(ctx.Module(), nil, m.properties.Deps...)
+}
+
+func (m *installModule) GenerateAndroidBuildActions(ctx android.ModuleContext) {
+	if m.properties.Src != nil {
+		ctx.InstallFile(android.PathForModuleInstall(ctx, "etc"), android.PathForModuleSrc(ctx, *m.properties.Src))
+	}
+}
+
+// newFilesystemFixture returns a test fixture for the device targets with the filesystem module
+// types and a module type that installs a file registered.
+func newFilesystemFixture(bp string) *android.TestFixture {
+	f := android.NewTestArchFixture(buildDir)
+	f.RegisterModuleType("android_filesystem", android.ModuleFactoryAdaptor(FilesystemFactory))
+	f.RegisterModuleType("vbmeta", android.ModuleFactoryAdaptor(VbmetaFactory))
+	f.RegisterModuleType("install_module", android.ModuleFactoryAdaptor(newInstallModule))
+	f.PostDepsMutators(android.RegisterPartitionPostDepsMutators)
+	f.PostDepsMutators(func(ctx android.RegisterMutatorsContext) {
+		ctx.BottomUp("filesystem_required_names", requiredNamesMutator).Parallel()
+		ctx.BottomUp("filesystem_required", requiredMutator).Parallel()
+	})
+	f.AddBlueprint(bp)
+	return f
+}
+
+func TestDependencyTags(t *testing.T) {
+	// The dependencies of an image are told apart by their tag
+	if depTag == requiredTag {
+		t.Errorf("the deps and required dependency tags are equal")
+	}
+}
+
+func TestFilesystemRequired(t *testing.T) {
+	f := newFilesystemFixture(`
+		android_filesystem {
+			name: "fs",
+			type: "cpio",
+			deps: ["foo"],
+		}
+
+		install_module {
+			name: "foo",
+			src: "foo",
+			deps: ["foo_dep"],
+			required: ["bar"],
+		}
+
+		install_module {
+			name: "foo_dep",
+			src: "foo_dep",
+			required: ["baz"],
+		}
+
+		install_module {
+			name: "bar",
+			src: "bar",
+			deps: ["bar_dep"],
+			required: ["qux", "make_only"],
+		}
+
+		install_module {
+			name: "bar_dep",
+			src: "bar_dep",
+			required: ["quux"],
+		}
+
+		install_module {
+			name: "quux",
+			src: "quux",
+		}
+
+		install_module {
+			name: "baz",
+			src: "baz",
+		}
+
+		install_module {
+			name: "qux",
+			src: "qux",
+		}
+
+		install_module {
+			name: "not_required",
+			src: "not_required",
+		}
+	`)
+	f.AddFiles("foo", "foo_dep", "bar", "bar_dep", "baz", "qux", "quux", "not_required")
+	f.Prepare(t)
+
+	fs := f.ModuleForTests("fs", "android_arm64")
+
+	// The files installed by the modules required by the deps of the image and by the modules
+	// and their dependencies require are staged, the required modules that only exist in Make are
+	// skipped
+	var staged []string
+	for _, p := range fs.Rule("buildCpioImage").Implicits {
+		staged = append(staged, p.Rel())
+	}
+	if want := []string{"bar", "bar_dep", "baz", "foo", "foo_dep", "quux", "qux"}; !reflect.DeepEqual(staged, want) {
+		t.Errorf("fs stages %q, want %q", staged, want)
+	}
+}
+
+func TestFilesystemAvb(t *testing.T) {
+	f := newFilesystemFixture(`
+		android_filesystem {
+			name: "fs",
+			deps: ["foo"],
+			use_avb: true,
+			avb_private_key: "key.pem",
+		}
+
+		install_module {
+			name: "foo",
+			src: "foo",
+		}
+	`)
+	f.AddFiles("foo", "key.pem")
+	f.Prepare(t)
+
+	image := f.ModuleForTests("fs", "android_arm64").Rule("buildExt4Image")
+
+	// The image is rebuilt when the key changes
+	found := false
+	for _, p := range image.Implicits {
+		if p.String() == "key.pem" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("fs image doesn't depend on key.pem: %v", image.Implicits)
+	}
+
+	if copyCommands := image.Args["copyCommands"]; !strings.Contains(copyCommands, "cp -f foo ") {
+		t.Errorf("fs image doesn't copy foo: %q", copyCommands)
+	}
+}
+
+func TestVbmeta(t *testing.T) {
+	f := newFilesystemFixture(`
+		android_filesystem {
+			name: "fs",
+			deps: ["foo"],
+		}
+
+		install_module {
+			name: "foo",
+			src: "foo",
+		}
+
+		vbmeta {
+			name: "vbmeta",
+			partition_name: "vbmeta_system",
+			rollback_index: 4294967296,
+			partitions: ["fs"],
+			chained_partitions: ["boot:1:boot.avbpubkey"],
+		}
+	`)
+	f.AddFiles("foo", "boot.avbpubkey")
+	f.Prepare(t)
+
+	fs := f.ModuleForTests("fs", "android_arm64").Module().(*filesystem).OutputFile()
+	vbmeta := f.ModuleForTests("vbmeta", "android_arm64").Rule("makeVbmetaImage")
+
+	if vbmeta.Output.Base() != "vbmeta_system.img" {
+		t.Errorf("expected vbmeta_system.img, got %q", vbmeta.Output.Base())
+	}
+
+	flags := vbmeta.Args["flags"]
+	for _, flag := range []string{
+		"--rollback_index 4294967296",
+		"--include_descriptors_from_image " + fs.String(),
+		"--chain_partition boot:1:boot.avbpubkey",
+	} {
+		if !strings.Contains(flags, flag) {
+			t.Errorf("vbmeta flags %q don't contain %q", flags, flag)
+		}
+	}
+
+	// The image is rebuilt when the partitions or the keys of the chained partitions change
+	var implicits []string
+	for _, p := range vbmeta.Implicits {
+		implicits = append(implicits, p.String())
+	}
+	for _, p := range []string{fs.String(), "boot.avbpubkey"} {
+		if !inList(p, implicits) {
+			t.Errorf("vbmeta image doesn't depend on %q: %q", p, implicits)
+		}
+	}
+}
+
+func TestVbmetaErrors(t *testing.T) {
+	testCases := []struct {
+		name, bp, err string
+	}{
+		{
+			name: "negative rollback index",
+			bp: `
+				vbmeta {
+					name: "vbmeta",
+					rollback_index: -1,
+				}`,
+			err: "must not be negative",
+		},
+		{
+			name: "partition is not an image",
+			bp: `
+				install_module {
+					name: "foo",
+				}
+
+				vbmeta {
+					name: "vbmeta",
+					partitions: ["foo"],
+				}`,
+			err: `"foo" is not a bootimg or android_filesystem module`,
+		},
+		{
+			name: "partition is a vbmeta image",
+			bp: `
+				vbmeta {
+					name: "vbmeta_system",
+				}
+
+				vbmeta {
+					name: "vbmeta",
+					partitions: ["vbmeta_system"],
+				}`,
+			err: `"vbmeta_system" is not a bootimg or android_filesystem module`,
+		},
+		{
+			name: "malformed chained partition",
+			bp: `
+				vbmeta {
+					name: "vbmeta",
+					chained_partitions: ["boot:key"],
+				}`,
+			err: `"boot:key" is not in the format`,
+		},
+		{
+			name: "chained partition uses location 0",
+			bp: `
+				vbmeta {
+					name: "vbmeta",
+					chained_partitions: ["boot:0:key"],
+				}`,
+			err: "boot: rollback index location 0 is used by vbmeta",
+		},
+		{
+			name: "duplicate rollback index location",
+			bp: `
+				vbmeta {
+					name: "vbmeta",
+					chained_partitions: ["boot:1:key", "vendor_boot:1:key"],
+				}`,
+			err: "vendor_boot: rollback index location 1 is also used by boot",
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			f := newFilesystemFixture(test.bp)
+			f.AddFiles("key")
+			android.FailIfNoMatchingErrors(t, test.err, f.PrepareWithErrors())
+		})
+	}
+}
+
+func TestFilesystemSystemExt(t *testing.T) {
+	f := newFilesystemFixture(`
+		android_filesystem {
+			name: "system_ext_image",
+			partition_name: "system_ext",
+			type: "cpio",
+			deps: ["foo"],
+		}
+
+		android_filesystem {
+			name: "system_image",
+			type: "cpio",
+			deps: ["bar"],
+		}
+
+		install_module {
+			name: "foo",
+			src: "foo",
+			system_ext_specific: true,
+			deps: ["bar"],
+		}
+
+		install_module {
+			name: "bar",
+			src: "bar",
+		}
+	`)
+	f.AddFiles("foo", "bar")
+	f.Prepare(t)
+
+	// Only the files installed into system_ext are packaged, the system dependencies of the
+	// system_ext modules are in the system image
+	for _, test := range []struct {
+		image  string
+		staged []string
+	}{
+		{"system_ext_image", []string{"foo"}},
+		{"system_image", []string{"bar"}},
+	} {
+		var staged []string
+		for _, p := range f.ModuleForTests(test.image, "android_arm64").Rule("buildCpioImage").Implicits {
+			staged = append(staged, p.Rel())
+		}
+		if !reflect.DeepEqual(staged, test.staged) {
+			t.Errorf("%s stages %q, want %q", test.image, staged, test.staged)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filesystem
+
+// This file contains the vbmeta module type, which builds a vbmeta image with avbtool that
+// includes the AVB descriptors of the images built by bootimg and android_filesystem modules,
+// and chains to the partitions that are verified with their own keys.
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/google/blueprint"
+
+	"android/soong/android"
+)
+
+func init() {
+	android.RegisterModuleType("vbmeta", VbmetaFactory)
+}
+
+var (
+	makeVbmetaImage = pctx.AndroidStaticRule("makeVbmetaImage",
+		blueprint.RuleParams{
+			Command:     `$avbtoolCmd make_vbmeta_image $flags --output $out`,
+			CommandDeps: []string{"$avbtoolCmd"},
+		},
+		"flags")
+)
+
+// chainedPartitionRegexp matches the chained_partitions entries, in the format of the
+// --chain_partition argument of avbtool.
+var chainedPartitionRegexp = regexp.MustCompile(`^([a-z0-9_]+):([0-9]+):(.+)$`)
+
+type vbmetaProperties struct {
+	// the name of the partition that the image is flashed to.  Defaults to "vbmeta".
+	Partition_name *string
+
+	// the key that the image is signed with.  Defaults to the test key of avbtool.
+	Private_key *string
+
+	// the signing algorithm.  Defaults to "SHA256_RSA4096".
+	Algorithm *string
+
+	// the rollback index of the image.  Defaults to 0.
+	Rollback_index *int64
+
+	// list of bootimg and android_filesystem modules whose AVB descriptors are included in the
+	// image
+	Partitions []string
+
+	// list of partitions that are verified with their own vbmeta structs, in the format
+	// "<partition>:<rollback index location>:<public key>", where the public key is a file
+	// extracted with avbtool extract_public_key
+	Chained_partitions []string
+}
+
+type vbmeta struct {
+	android.ModuleBase
+
+	properties vbmetaProperties
+
+	outputFile android.ModuleOutPath
+}
+
+type vbmetaDependencyTag struct {
+	blueprint.BaseDependencyTag
+}
+
+var vbmetaPartitionTag vbmetaDependencyTag
+
+func VbmetaFactory() android.Module {
+	module := &vbmeta{}
+	module.AddProperties(&module.properties)
+	android.InitAndroidArchModule(module, android.DeviceSupported, android.MultilibFirst)
+	return module
+}
+
+func (v *vbmeta) partitionName() string {
+	if v.properties.Partition_name != nil {
+		return *v.properties.Partition_name
+	}
+	return "vbmeta"
+}
+
+func (v *vbmeta) DepsMutator(ctx android.BottomUpMutatorContext) {
+	var srcs []string
+	if v.properties.Private_key != nil {
+		srcs = append(srcs, *v.properties.Private_key)
+	}
+	for _, p := range v.properties.Chained_partitions {
+		if m := chainedPartitionRegexp.FindStringSubmatch(p); m != nil {
+			srcs = append(srcs, m[3])
+		}
+	}
+	android.ExtractSourcesDeps(ctx, srcs)
+
+	ctx.AddVariationDependencies(nil, vbmetaPartitionTag, v.properties.Partitions...)
+}
+
+func (v *vbmeta) GenerateAndroidBuildActions(ctx android.ModuleContext) {
+	var implicits android.Paths
+	flags := []string{avbSigningFlags(ctx, v.properties.Private_key, v.properties.Algorithm)}
+	if v.properties.Private_key != nil {
+		implicits = append(implicits, android.PathForModuleSrc(ctx, *v.properties.Private_key))
+	}
+
+	var rollbackIndex int64
+	if v.properties.Rollback_index != nil {
+		rollbackIndex = *v.properties.Rollback_index
+		if rollbackIndex < 0 {
+			ctx.PropertyErrorf("rollback_index", "must not be negative")
+		}
+	}
+	flags = append(flags, "--rollback_index "+strconv.FormatInt(rollbackIndex, 10))
+
+	ctx.VisitDirectDeps(func(module blueprint.Module) {
+		if ctx.OtherModuleDependencyTag(module) != vbmetaPartitionTag {
+			return
+		}
+		var image android.OptionalPath
+		switch m := module.(type) {
+		case *filesystem:
+			image = m.OutputFile()
+		case *bootimg:
+			image = m.OutputFile()
+		default:
+			ctx.PropertyErrorf("partitions", "%q is not a bootimg or android_filesystem module",
+				ctx.OtherModuleName(module))
+			return
+		}
+		if image.Valid() {
+			flags = append(flags, "--include_descriptors_from_image "+image.String())
+			implicits = append(implicits, image.Path())
+		}
+	})
+
+	// The rollback index locations of the chained partitions must be unique, and location 0 is
+	// used by the vbmeta image itself.
+	locations := make(map[string]string)
+	for _, p := range v.properties.Chained_partitions {
+		m := chainedPartitionRegexp.FindStringSubmatch(p)
+		if m == nil {
+			ctx.PropertyErrorf("chained_partitions",
+				"%q is not in the format <partition>:<rollback index location>:<public key>", p)
+			continue
+		}
+		name, location := m[1], m[2]
+		if location == "0" {
+			ctx.PropertyErrorf("chained_partitions", "%s: rollback index location 0 is used by %s",
+				name, v.partitionName())
+			continue
+		}
+		if other, found := locations[location]; found {
+			ctx.PropertyErrorf("chained_partitions", "%s: rollback index location %s is also used by %s",
+				name, location, other)
+			continue
+		}
+		locations[location] = name
+
+		key := android.PathForModuleSrc(ctx, m[3])
+		flags = append(flags, fmt.Sprintf("--chain_partition %s:%s:%s", name, location, key))
+		implicits = append(implicits, key)
+	}
+	if ctx.Failed() {
+		return
+	}
+
+	v.outputFile = android.PathForModuleOut(ctx, v.partitionName()+".img")
+	ctx.ModuleBuild(pctx, android.ModuleBuildParams{
+		Rule:        makeVbmetaImage,
+		Description: "vbmeta image " + v.outputFile.Base(),
+		Output:      v.outputFile,
+		Implicits:   implicits,
+		Args: map[string]string{
+			"flags": strings.Join(flags, " "),
+		},
+	})
+}
+
+// OutputFile returns the vbmeta image.
+func (v *vbmeta) OutputFile() android.OptionalPath {
+	return android.OptionalPathForPath(v.outputFile)
+}
+
+func (v *vbmeta) AndroidMk() android.AndroidMkData {
+	return android.AndroidMkData{
+		Class:      "ETC",
+		OutputFile: android.OptionalPathForPath(v.outputFile),
+		Extra: []android.AndroidMkExtraFunc{
+			func(w io.Writer, outputFile android.Path) {
+				fmt.Fprintln(w, "LOCAL_UNINSTALLABLE_MODULE := true")
+			},
+		},
+	}
+}
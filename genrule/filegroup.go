@@ -15,6 +15,8 @@
 package genrule
 
 import (
+	"strings"
+
 	"android/soong/android"
 )
 
@@ -31,7 +33,8 @@ type fileGroupProperties struct {
 	// The base path to the files.  May be used by other modules to determine which portion
 	// of the path to use.  For example, when a filegroup is used as data in a cc_test rule,
 	// the base path is stripped off the path and the remaining path is used as the
-	// installation directory.
+	// installation directory, and when a filegroup is used in java_resources the remaining
+	// path is the path of the resource in the jar.
 	Path string
 }
 
@@ -42,6 +45,7 @@ type fileGroup struct {
 }
 
 var _ android.SourceFileProducer = (*fileGroup)(nil)
+var _ android.SourceExtensionProducer = (*fileGroup)(nil)
 
 // filegroup modules contain a list of files, and can be used to export files across package
 // boundaries.  filegroups (and genrules) can be referenced from srcs properties of other modules
@@ -64,3 +68,14 @@ func (fg *fileGroup) GenerateAndroidBuildActions(ctx android.ModuleContext) {
 func (fg *fileGroup) Srcs() android.Paths {
 	return fg.srcs
 }
+
+// HasSrcExt returns true if the files or globs listed in srcs have the extension.  The contents of
+// the modules referenced from srcs are not known.
+func (fg *fileGroup) HasSrcExt(ext string) bool {
+	for _, src := range fg.properties.Srcs {
+		if strings.HasSuffix(src, ext) {
+			return true
+		}
+	}
+	return false
+}
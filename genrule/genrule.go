@@ -20,6 +20,7 @@ import (
 	"strings"
 
 	"github.com/google/blueprint"
+	"github.com/google/blueprint/bootstrap"
 
 	"android/soong/android"
 	"android/soong/shared"
@@ -56,8 +57,8 @@ type generatorProperties struct {
 	//
 	// $(location): the path to the first entry in tools or tool_files
 	// $(location <label>): the path to the tool or tool_file with name <label>
-	// $(in): one or more input files
-	// $(out): a single output file
+	// $(in): one or more input files, or a single input file for gensrcs
+	// $(out): the output files, or the output file of the input file for gensrcs
 	// $(depfile): a file to which dependencies will be written, if the depfile property is set to true
 	// $(genDir): the sandbox directory for this tool; contains $(out)
 	// $$: a literal $
@@ -70,10 +71,13 @@ type generatorProperties struct {
 	Depfile bool
 
 	// name of the modules (if any) that produces the host executable.   Leave empty for
-	// prebuilts or scripts that do not need a module to build them.
+	// prebuilts or scripts that do not need a module to build them.  Host tools written in Go
+	// can be blueprint_go_binary modules, and tools written in Python python_binary_host
+	// modules, which run with Python 3 if they are built for it.
 	Tools []string
 
-	// Local file that is used as the tool
+	// Local file that is used as the tool, or the output of another module referenced with
+	// ":module" or ":module{.tag}"
 	Tool_files []string
 
 	// List of directories to export generated headers from
@@ -83,13 +87,19 @@ type generatorProperties struct {
 	Srcs []string
 }
 
-type generator struct {
+// Module is the module type shared by genrule and gensrcs.  It is exported so that other packages
+// can build module types on top of it, for example java_genrule.
+type Module struct {
 	android.ModuleBase
 
 	properties generatorProperties
 
 	tasks taskFunc
 
+	// outputExts returns the extensions of the generated files, which are known from the
+	// properties before the tasks are created.
+	outputExts func() []string
+
 	deps android.Paths
 	rule blueprint.Rule
 
@@ -105,30 +115,69 @@ type generateTask struct {
 	out android.WritablePaths
 }
 
-func (g *generator) GeneratedSourceFiles() android.Paths {
+func (g *Module) GeneratedSourceFiles() android.Paths {
 	return g.outputFiles
 }
 
-func (g *generator) Srcs() android.Paths {
+func (g *Module) Srcs() android.Paths {
 	return g.outputFiles
 }
 
-func (g *generator) GeneratedHeaderDirs() android.Paths {
+// OutputFiles returns the generated files for ":module" references.
+func (g *Module) OutputFiles(tag string) (android.Paths, error) {
+	if tag != "" {
+		return nil, fmt.Errorf("unsupported module reference tag %q", tag)
+	}
+	return g.outputFiles, nil
+}
+
+var _ android.OutputFileProducer = (*Module)(nil)
+
+// HasSrcExt returns true if the module generates files with the extension.
+func (g *Module) HasSrcExt(ext string) bool {
+	if g.outputExts == nil {
+		return false
+	}
+	for _, outputExt := range g.outputExts() {
+		if outputExt == ext {
+			return true
+		}
+	}
+	return false
+}
+
+var _ android.SourceExtensionProducer = (*Module)(nil)
+
+func (g *Module) GeneratedHeaderDirs() android.Paths {
 	return g.exportedIncludeDirs
 }
 
-func (g *generator) DepsMutator(ctx android.BottomUpMutatorContext) {
+func (g *Module) DepsMutator(ctx android.BottomUpMutatorContext) {
 	android.ExtractSourcesDeps(ctx, g.properties.Srcs)
-	if g, ok := ctx.Module().(*generator); ok {
-		if len(g.properties.Tools) > 0 {
-			ctx.AddFarVariationDependencies([]blueprint.Variation{
-				{"arch", ctx.AConfig().BuildOsVariant},
-			}, nil, g.properties.Tools...)
-		}
+	android.ExtractSourcesDeps(ctx, g.properties.Tool_files)
+	if len(g.properties.Tools) > 0 {
+		// python_binary_host tools are also split by Python version, the first variant is
+		// used, which is the Python 3 one if the tool is built for Python 3
+		ctx.AddFarVariationDependencies([]blueprint.Variation{
+			{"arch", ctx.AConfig().BuildOsVariant},
+		}, nil, g.properties.Tools...)
+	}
+}
+
+// goBinaryToolPath returns the path of a Go binary from its install path.  Go binaries are built by
+// the bootstrap rules of the primary builder, which install them into the output directory.
+func goBinaryToolPath(ctx android.PathContext, installPath string) (android.Path, error) {
+	rel, err := filepath.Rel(android.PathForOutput(ctx).String(), installPath)
+	if err != nil {
+		return nil, err
+	}
+	if rel == ".." || strings.HasPrefix(rel, "../") {
+		return nil, fmt.Errorf("%q is not in the output directory", installPath)
 	}
+	return android.PathForOutput(ctx, rel), nil
 }
 
-func (g *generator) GenerateAndroidBuildActions(ctx android.ModuleContext) {
+func (g *Module) GenerateAndroidBuildActions(ctx android.ModuleContext) {
 	if len(g.properties.Tools) == 0 && len(g.properties.Tool_files) == 0 {
 		ctx.ModuleErrorf("at least one `tools` or `tool_files` is required")
 		return
@@ -147,25 +196,48 @@ func (g *generator) GenerateAndroidBuildActions(ctx android.ModuleContext) {
 
 	if len(g.properties.Tools) > 0 {
 		ctx.VisitDirectDeps(func(module blueprint.Module) {
-			if t, ok := module.(HostToolProvider); ok {
+			tool := ctx.OtherModuleName(module)
+			var path android.Path
+			switch t := module.(type) {
+			case HostToolProvider:
 				p := t.HostToolPath()
-				if p.Valid() {
-					g.deps = append(g.deps, p.Path())
-					tool := ctx.OtherModuleName(module)
-					if _, exists := tools[tool]; !exists {
-						tools[tool] = p.Path()
-					} else {
-						ctx.ModuleErrorf("multiple tools for %q, %q and %q", tool, tools[tool], p.Path().String())
-					}
-				} else {
-					ctx.ModuleErrorf("host tool %q missing output file", ctx.OtherModuleName(module))
+				if !p.Valid() {
+					ctx.ModuleErrorf("host tool %q missing output file", tool)
+					return
+				}
+				path = p.Path()
+			case bootstrap.GoBinaryTool:
+				p, err := goBinaryToolPath(ctx, t.InstallPath())
+				if err != nil {
+					ctx.ModuleErrorf("cannot find the path of go binary %q: %s", tool, err)
+					return
 				}
+				path = p
+			default:
+				return
+			}
+
+			g.deps = append(g.deps, path)
+			if _, exists := tools[tool]; !exists {
+				tools[tool] = path
+			} else {
+				ctx.ModuleErrorf("multiple tools for %q, %q and %q", tool, tools[tool], path.String())
 			}
 		})
 	}
 
 	for _, tool := range g.properties.Tool_files {
-		toolPath := android.PathForModuleSrc(ctx, tool)
+		var toolPath android.Path
+		if android.SrcIsModule(tool) != "" {
+			paths := ctx.ExpandSources([]string{tool}, nil)
+			if len(paths) != 1 {
+				ctx.PropertyErrorf("tool_files", "%q must produce exactly one file, got %d", tool, len(paths))
+				continue
+			}
+			toolPath = paths[0]
+		} else {
+			toolPath = android.PathForModuleSrc(ctx, tool)
+		}
 		g.deps = append(g.deps, toolPath)
 		if _, exists := tools[tool]; !exists {
 			tools[tool] = toolPath
@@ -244,7 +316,7 @@ func (g *generator) GenerateAndroidBuildActions(ctx android.ModuleContext) {
 	}
 }
 
-func (g *generator) generateSourceFile(ctx android.ModuleContext, task generateTask) {
+func (g *Module) generateSourceFile(ctx android.ModuleContext, task generateTask) {
 	desc := "generate"
 	if len(task.out) == 1 {
 		desc += " " + task.out[0].Base()
@@ -252,7 +324,7 @@ func (g *generator) generateSourceFile(ctx android.ModuleContext, task generateT
 
 	params := android.ModuleBuildParams{
 		Rule:        g.rule,
-		Description: "generate",
+		Description: desc,
 		Outputs:     task.out,
 		Inputs:      task.in,
 		Implicits:   g.deps,
@@ -268,42 +340,76 @@ func (g *generator) generateSourceFile(ctx android.ModuleContext, task generateT
 	}
 }
 
-func generatorFactory(tasks taskFunc, props ...interface{}) android.Module {
-	module := &generator{
+// newGenerator returns a Module that has not been initialized with InitAndroidModule, so that
+// callers can choose how the module is split into variants.
+func newGenerator(tasks taskFunc, props ...interface{}) *Module {
+	module := &Module{
 		tasks: tasks,
 	}
 
 	module.AddProperties(props...)
 	module.AddProperties(&module.properties)
 
-	android.InitAndroidModule(module)
-
 	return module
 }
 
 func GenSrcsFactory() android.Module {
+	module := NewGenSrcs()
+	android.InitAndroidModule(module)
+	return module
+}
+
+// NewGenSrcs returns an uninitialized gensrcs module.  gensrcs runs cmd once for each file in srcs,
+// with $(in) set to the input file and $(out) to the output file named after it, so that every file
+// is generated by a separate build statement.
+func NewGenSrcs() *Module {
 	properties := &genSrcsProperties{}
 
 	tasks := func(ctx android.ModuleContext, srcFiles android.Paths) []generateTask {
+		if properties.Output_extension == "" {
+			ctx.PropertyErrorf("output_extension", "missing output extension")
+			return nil
+		}
+		ext := strings.TrimPrefix(properties.Output_extension, ".")
+
 		tasks := make([]generateTask, 0, len(srcFiles))
+		inputs := make(map[string]android.Path, len(srcFiles))
 		for _, in := range srcFiles {
+			out := android.GenPathWithExt(ctx, "", in, ext)
+			if prev, exists := inputs[out.String()]; exists {
+				ctx.PropertyErrorf("srcs", "%q and %q both generate %q", prev, in, out)
+				continue
+			}
+			inputs[out.String()] = in
 			tasks = append(tasks, generateTask{
 				in:  android.Paths{in},
-				out: android.WritablePaths{android.GenPathWithExt(ctx, "", in, properties.Output_extension)},
+				out: android.WritablePaths{out},
 			})
 		}
 		return tasks
 	}
 
-	return generatorFactory(tasks, properties)
+	module := newGenerator(tasks, properties)
+	module.outputExts = func() []string {
+		return []string{"." + strings.TrimPrefix(properties.Output_extension, ".")}
+	}
+	return module
 }
 
 type genSrcsProperties struct {
-	// extension that will be substituted for each output file
+	// extension that will be substituted for the extension of each input file to name the
+	// output file, for example "java" to generate Foo.java from Foo.proto
 	Output_extension string
 }
 
 func GenRuleFactory() android.Module {
+	module := NewGenRule()
+	android.InitAndroidModule(module)
+	return module
+}
+
+// NewGenRule returns an uninitialized genrule module.
+func NewGenRule() *Module {
 	properties := &genRuleProperties{}
 
 	tasks := func(ctx android.ModuleContext, srcFiles android.Paths) []generateTask {
@@ -319,7 +425,15 @@ func GenRuleFactory() android.Module {
 		}
 	}
 
-	return generatorFactory(tasks, properties)
+	module := newGenerator(tasks, properties)
+	module.outputExts = func() []string {
+		exts := make([]string, len(properties.Out))
+		for i, out := range properties.Out {
+			exts[i] = filepath.Ext(out)
+		}
+		return exts
+	}
+	return module
 }
 
 type genRuleProperties struct {
@@ -0,0 +1,140 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package genrule
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/google/blueprint/pathtools"
+
+	"android/soong/android"
+)
+
+func newGenruleFixture(t *testing.T, bp string) (*android.TestFixture, func()) {
+	buildDir, err := ioutil.TempDir("", "soong_genrule_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := android.NewTestFixture(buildDir)
+	f.RegisterModuleType("gensrcs", android.ModuleFactoryAdaptor(GenSrcsFactory))
+	f.RegisterModuleType("filegroup", android.ModuleFactoryAdaptor(FileGroupFactory))
+	f.AddBlueprint(bp)
+	f.AddFiles("gen.sh", "a.proto", "a.xsd", "sub/b.proto")
+
+	return f, func() { os.RemoveAll(buildDir) }
+}
+
+func TestGenSrcs(t *testing.T) {
+	f, cleanup := newGenruleFixture(t, `
+		gensrcs {
+			name: "gen",
+			tool_files: ["gen.sh"],
+			cmd: "$(location) $(in) $(out)",
+			srcs: ["a.proto", ":protos"],
+			output_extension: "java",
+		}
+
+		filegroup {
+			name: "protos",
+			srcs: ["sub/b.proto"],
+		}
+	`)
+	defer cleanup()
+	f.Prepare(t)
+
+	gen := f.ModuleForTests("gen", "")
+	for _, test := range []struct{ in, out string }{
+		{"a.proto", "a.java"},
+		{"sub/b.proto", "b.java"},
+	} {
+		params := gen.Output(test.out)
+		if len(params.Inputs) != 1 || params.Inputs[0].String() != test.in {
+			t.Errorf("%s inputs %v != [%s]", test.out, params.Inputs, test.in)
+		}
+	}
+
+	genDir := filepath.Join(f.Config.BuildDir(), ".intermediates", "gen", "gen")
+	expected := []string{filepath.Join(genDir, "a.java"), filepath.Join(genDir, "sub/b.java")}
+	if g := gen.Module().(*Module).GeneratedSourceFiles().Strings(); !reflect.DeepEqual(g, expected) {
+		t.Errorf("expected outputs %q, got %q", expected, g)
+	}
+}
+
+func TestGenSrcsErrors(t *testing.T) {
+	f, cleanup := newGenruleFixture(t, `
+		gensrcs {
+			name: "gen",
+			tool_files: ["gen.sh"],
+			cmd: "$(location) $(in) $(out)",
+			srcs: ["a.proto", "a.xsd"],
+			output_extension: ".java",
+		}
+
+		gensrcs {
+			name: "no_extension",
+			tool_files: ["gen.sh"],
+			cmd: "$(location) $(in) $(out)",
+			srcs: ["sub/b.proto"],
+		}
+	`)
+	defer cleanup()
+
+	errs := f.PrepareWithErrors()
+	android.FailIfNoMatchingErrors(t, `"a.proto" and "a.xsd" both generate`, errs)
+	android.FailIfNoMatchingErrors(t, `output_extension: missing output extension`, errs)
+}
+
+type pathContextImpl struct {
+	config android.Config
+}
+
+func (pathContextImpl) Fs() pathtools.FileSystem {
+	return pathtools.MockFs(nil)
+}
+
+func (ctx pathContextImpl) Config() interface{} {
+	return ctx.config
+}
+
+func (pathContextImpl) AddNinjaFileDeps(deps ...string) {}
+
+func TestGoBinaryToolPath(t *testing.T) {
+	buildDir, err := ioutil.TempDir("", "soong_genrule_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(buildDir)
+
+	ctx := pathContextImpl{android.TestConfig(buildDir)}
+
+	installPath := filepath.Join(buildDir, "host", "linux-x86", "bin", "gotool")
+	path, err := goBinaryToolPath(ctx, installPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	// The tool is an output path, which genrules depend on like on other host tools
+	if _, ok := path.(android.OutputPath); !ok || path.String() != installPath {
+		t.Errorf("expected output path %q, got %#v", installPath, path)
+	}
+
+	if _, err := goBinaryToolPath(ctx, "/usr/bin/gotool"); err == nil {
+		t.Errorf("expected an error for a go binary outside of the output directory")
+	}
+}
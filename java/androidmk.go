@@ -19,28 +19,111 @@ import (
 	"io"
 	"strings"
 
+	"github.com/google/blueprint/proptools"
+
 	"android/soong/android"
 )
 
 func (library *Library) AndroidMk() android.AndroidMkData {
-	return android.AndroidMkData{
+	data := android.AndroidMkData{
 		Class:      "JAVA_LIBRARIES",
 		OutputFile: android.OptionalPathForPath(library.outputFile),
 		Extra: []android.AndroidMkExtraFunc{
 			func(w io.Writer, outputFile android.Path) {
 				fmt.Fprintln(w, "LOCAL_MODULE_SUFFIX := .jar")
 			},
+			library.logtagsAndroidMk,
+			library.jacocoAndroidMk,
 		},
 	}
+
+	if proptools.Bool(library.deviceProperties.Hostdex) && !library.Host() && library.dexJarFile != nil {
+		data.Custom = func(w io.Writer, name, prefix, moduleDir string, data android.AndroidMkData) {
+			android.WriteAndroidMkData(w, data)
+
+			fmt.Fprintln(w, "include $(CLEAR_VARS)")
+			fmt.Fprintln(w, "LOCAL_MODULE := "+name+"-hostdex")
+			fmt.Fprintln(w, "LOCAL_IS_HOST_MODULE := true")
+			fmt.Fprintln(w, "LOCAL_MODULE_CLASS := JAVA_LIBRARIES")
+			fmt.Fprintln(w, "LOCAL_MODULE_SUFFIX := .jar")
+			fmt.Fprintln(w, "LOCAL_PREBUILT_MODULE_FILE := "+library.dexJarFile.String())
+			fmt.Fprintln(w, "include $(BUILD_PREBUILT)")
+		}
+	}
+
+	return data
+}
+
+// logtagsAndroidMk passes the .logtags sources of the module to Make, so that they are merged
+// into /system/etc/event-log-tags along with the logtags of Make modules.
+func (j *Module) logtagsAndroidMk(w io.Writer, outputFile android.Path) {
+	if len(j.logtagsSrcs) > 0 {
+		var logtags []string
+		for _, l := range j.logtagsSrcs {
+			logtags = append(logtags, l.Rel())
+		}
+		fmt.Fprintln(w, "LOCAL_LOGTAGS_FILES :=", strings.Join(logtags, " "))
+	}
+}
+
+// jacocoAndroidMk passes the jar of the classes that were instrumented for code coverage to
+// Make, which packages it for generating coverage reports.
+func (j *Module) jacocoAndroidMk(w io.Writer, outputFile android.Path) {
+	if j.jacocoReportClassesJar != nil {
+		fmt.Fprintln(w, "LOCAL_SOONG_JACOCO_REPORT_CLASSES_JAR :=", j.jacocoReportClassesJar.String())
+	}
 }
 
 func (prebuilt *Import) AndroidMk() android.AndroidMkData {
 	return android.AndroidMkData{
 		Class:      "JAVA_LIBRARIES",
-		OutputFile: android.OptionalPathForPath(prebuilt.combinedClasspathFile),
+		OutputFile: android.OptionalPathForPath(prebuilt.outputFile),
 		Extra: []android.AndroidMkExtraFunc{
 			func(w io.Writer, outputFile android.Path) {
 				fmt.Fprintln(w, "LOCAL_MODULE_SUFFIX := .jar")
+				if !proptools.BoolDefault(prebuilt.properties.Installable, true) {
+					fmt.Fprintln(w, "LOCAL_UNINSTALLABLE_MODULE := true")
+				}
+			},
+		},
+	}
+}
+
+// AndroidMkEntries describes the app to Make, so that the product packaging and checks treat it
+// like an app built by Make.  Soong has already signed and installed the apk, Make dexpreopts it
+// unless dex_preopt.enabled is false.
+func (app *AndroidApp) AndroidMkEntries() android.AndroidMkEntries {
+	return android.AndroidMkEntries{
+		Class:      "APPS",
+		OutputFile: android.OptionalPathForPath(app.outputFile),
+		ExtraEntries: []android.AndroidMkExtraEntriesFunc{
+			func(entries *android.AndroidMkEntries) {
+				entries.SetString("LOCAL_MODULE_SUFFIX", ".apk")
+				entries.SetInstalledPath(app.installPath)
+				entries.SetString("LOCAL_CERTIFICATE", app.certificate)
+				entries.AddStrings("LOCAL_ADDITIONAL_CERTIFICATES", app.additionalCertificates...)
+				entries.AddStrings("LOCAL_OVERRIDES_PACKAGES", app.Overrides()...)
+				entries.SetBoolIfTrue("LOCAL_PRIVILEGED_MODULE", app.Privileged())
+				if !dexpreoptEnabled(&app.dexpreoptProperties) {
+					entries.SetString("LOCAL_DEX_PREOPT", "false")
+				}
+			},
+		},
+	}
+}
+
+// AndroidMkEntries describes the apk to Make like a prebuilt app.  Soong has already signed and
+// installed it, so Make must not sign it again.
+func (w *WebViewImport) AndroidMkEntries() android.AndroidMkEntries {
+	return android.AndroidMkEntries{
+		Class:      "APPS",
+		OutputFile: android.OptionalPathForPath(w.outputFile),
+		ExtraEntries: []android.AndroidMkExtraEntriesFunc{
+			func(entries *android.AndroidMkEntries) {
+				entries.SetString("LOCAL_MODULE_SUFFIX", ".apk")
+				entries.SetInstalledPath(w.installPath)
+				entries.SetString("LOCAL_CERTIFICATE", "PRESIGNED")
+				entries.AddStrings("LOCAL_OVERRIDES_PACKAGES", w.Overrides()...)
 			},
 		},
 	}
@@ -51,6 +134,10 @@ func (binary *Binary) AndroidMk() android.AndroidMkData {
 		Class:      "JAVA_LIBRARIES",
 		OutputFile: android.OptionalPathForPath(binary.outputFile),
 		SubName:    ".jar",
+		Extra: []android.AndroidMkExtraFunc{
+			binary.logtagsAndroidMk,
+			binary.jacocoAndroidMk,
+		},
 		Custom: func(w io.Writer, name, prefix, moduleDir string, data android.AndroidMkData) {
 			android.WriteAndroidMkData(w, data)
 
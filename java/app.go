@@ -17,12 +17,17 @@ package java
 // This file contains the module types for compiling Android apps.
 
 import (
+	"fmt"
 	"path/filepath"
+	"regexp"
 	"strings"
 
 	"github.com/google/blueprint"
+	"github.com/google/blueprint/proptools"
 
 	"android/soong/android"
+	"android/soong/cc"
+	"android/soong/java/config"
 )
 
 // AAR prebuilts
@@ -32,7 +37,7 @@ import (
 type androidAppProperties struct {
 	// path to a certificate, or the name of a certificate in the default
 	// certificate directory, or blank to use the default product certificate
-	Certificate string
+	Certificate *string
 
 	// paths to extra certificates to sign the apk with
 	Additional_certificates []string
@@ -44,6 +49,10 @@ type androidAppProperties struct {
 	// flags passed to aapt when creating the apk
 	Aaptflags []string
 
+	// if true, the resources of all configurations are kept in the apk instead of only those in
+	// the aapt configuration of the product
+	Aapt_include_all_resources *bool
+
 	// list of resource labels to generate individual resource packages
 	Package_splits []string
 
@@ -54,15 +63,57 @@ type androidAppProperties struct {
 	// list of directories relative to the Blueprints file containing
 	// Java resources
 	Android_resource_dirs []string
+
+	// if true, the package name, version, permissions and components of the apk are exported
+	// to the app metadata JSON of the build
+	Export_metadata *bool
+
+	// if true, the app is installed to priv-app and may be granted privileged permissions, which
+	// must be allowlisted by a privapp-permissions element in a sysconfig_xml module
+	Privileged *bool
+
+	// names of the apps that this app replaces, for example the app that a GMS stub stands in
+	// for.  The build fails if an app is installed together with an app it overrides.
+	Overrides []string
+
+	// name of the directory in the icon pack of the product whose launcher icon resources
+	// replace those of the app.  Defaults to the name of the module.
+	Icon_pack_name *string
+
+	// list of native shared libraries to package into the lib/<abi> directories of the apk.
+	// Libraries listed in arch: { <arch>: { jni_libs: [...] } } are only packaged for that
+	// architecture.
+	Jni_libs []string `android:"arch_variant"`
+
+	// the ABIs of the jni_libs to package: "first" for the primary ABI of the device, "both" for
+	// every ABI of the device, or "32" or "64" for only the 32-bit or 64-bit ABI.  Defaults to
+	// the PRODUCT_APP_JNI_MULTILIB of the product, or "first".
+	Jni_multilib *string
+
+	// name of the file in the module directory with the snapshot of the translatable
+	// default-locale strings taken at the translation freeze.  Defaults to string-freeze.xml if
+	// that file exists.  Products that enable StringFreeze fail or warn when the strings of the
+	// app no longer match the snapshot.
+	String_freeze_snapshot *string
 }
 
 type AndroidApp struct {
 	Module
 
+	linter              linter
+	dexpreoptProperties DexpreoptProperties
+
 	appProperties androidAppProperties
 
 	aaptJavaFileList android.Path
 	exportPackage    android.Path
+	manifestPath     android.Path
+	dexCrcsFile      android.Path
+	badgingFile      android.Path
+	certificate      string
+
+	additionalCertificates []string
+	installPath            android.OutputPath
 }
 
 func (a *AndroidApp) DepsMutator(ctx android.BottomUpMutatorContext) {
@@ -72,10 +123,194 @@ func (a *AndroidApp) DepsMutator(ctx android.BottomUpMutatorContext) {
 		switch a.deviceProperties.Sdk_version { // TODO: Res_sdk_version?
 		case "current", "system_current", "":
 			ctx.AddDependency(ctx.Module(), frameworkResTag, "framework-res")
+		case "lineage_current":
+			ctx.AddDependency(ctx.Module(), frameworkResTag, "framework-res")
+			ctx.AddDependency(ctx.Module(), lineageResTag, config.LineageSdkResPackage)
 		default:
 			// We'll already have a dependency on an sdk prebuilt android.jar
 		}
 	}
+
+	if jacocoInstrumented(ctx) {
+		// Apps have to include the jacoco runtime that records the coverage data of their
+		// instrumented classes
+		ctx.AddDependency(ctx.Module(), staticLibTag, jacocoAgentLibrary)
+	}
+
+	// Errors in jni_multilib are reported in GenerateAndroidBuildActions
+	targets, _ := jniTargets(a.jniMultilib(ctx), ctx.AConfig().Targets[android.Device])
+	for _, target := range targets {
+		for _, lib := range a.jniLibNames(ctx, target) {
+			// Apps built against the SDK package the variants of the jni_libs built against the
+			// NDK.  Only the libraries that set sdk_version have one, the platform variant of the
+			// others is packaged instead
+			sdkVariation := ""
+			if a.deviceProperties.Sdk_version != "" && cc.HasSdkVariant(ctx.AConfig(), lib) {
+				sdkVariation = "sdk"
+			}
+
+			ctx.AddFarVariationDependencies([]blueprint.Variation{
+				{Mutator: "arch", Variation: target.String()},
+				{Mutator: "link", Variation: "shared"},
+				{Mutator: "image", Variation: "core"},
+				{Mutator: "sdk", Variation: sdkVariation},
+			}, jniLibTag, lib)
+		}
+	}
+}
+
+// jniLibNames returns the jni_libs of the app for target, including the ones listed in the arch
+// specific properties for the architecture of target.
+func (a *AndroidApp) jniLibNames(ctx android.BottomUpMutatorContext, target android.Target) []string {
+	for _, props := range a.ArchSpecificProperties(ctx, target) {
+		if appProps, ok := props.(*androidAppProperties); ok {
+			return appProps.Jni_libs
+		}
+	}
+	return a.appProperties.Jni_libs
+}
+
+func (a *AndroidApp) jniMultilib(ctx android.BaseContext) string {
+	if a.appProperties.Jni_multilib != nil {
+		return *a.appProperties.Jni_multilib
+	}
+	return ctx.AConfig().AppJniMultilib()
+}
+
+// jniTargets returns the device targets whose variants of the jni_libs are packaged into the apk
+// for a jni_multilib value.
+func jniTargets(multilib string, deviceTargets []android.Target) ([]android.Target, error) {
+	switch multilib {
+	case "first":
+		if len(deviceTargets) == 0 {
+			return nil, nil
+		}
+		return deviceTargets[:1], nil
+	case "both":
+		return deviceTargets, nil
+	case "32", "64":
+		var targets []android.Target
+		for _, target := range deviceTargets {
+			if target.Arch.ArchType.Multilib == "lib"+multilib {
+				targets = append(targets, target)
+			}
+		}
+		if len(targets) == 0 && len(deviceTargets) > 0 {
+			return nil, fmt.Errorf("the device has no %s-bit ABI", multilib)
+		}
+		return targets, nil
+	default:
+		return nil, fmt.Errorf(`must be "first", "both", "32" or "64", found %q`, multilib)
+	}
+}
+
+// jniLibProducer is implemented by native shared libraries, which apps package when they are
+// listed in jni_libs.
+type jniLibProducer interface {
+	OutputFile() android.OptionalPath
+}
+
+// jniLibs returns the native libraries to package into the apk, checking that each of them is
+// built for an ABI that the device supports.
+func (a *AndroidApp) jniLibs(ctx android.ModuleContext) []jniLib {
+	deviceTargets := ctx.AConfig().Targets[android.Device]
+	if _, err := jniTargets(a.jniMultilib(ctx), deviceTargets); err != nil {
+		// An invalid product default is only reported to the apps that package jni_libs
+		if len(a.appProperties.Jni_libs) > 0 || a.appProperties.Jni_multilib != nil {
+			ctx.PropertyErrorf("jni_multilib", "%s", err)
+		}
+		return nil
+	}
+
+	var deviceAbis []string
+	for _, target := range deviceTargets {
+		deviceAbis = append(deviceAbis, target.Arch.Abi...)
+	}
+
+	var jniLibs []jniLib
+	ctx.VisitDirectDeps(func(module blueprint.Module) {
+		if ctx.OtherModuleDependencyTag(module) != jniLibTag {
+			return
+		}
+		name := ctx.OtherModuleName(module)
+		lib, ok := module.(jniLibProducer)
+		if !ok || !lib.OutputFile().Valid() {
+			ctx.PropertyErrorf("jni_libs", "%q is not a native shared library", name)
+			return
+		}
+		abi := module.(android.Module).Target().Arch.Abi
+		if len(abi) == 0 || !inList(abi[0], deviceAbis) {
+			ctx.PropertyErrorf("jni_libs", "%q is built for ABI %q, which the device doesn't support %q",
+				name, abi, deviceAbis)
+			return
+		}
+		jniLibs = append(jniLibs, jniLib{name: name, path: lib.OutputFile().Path(), abi: abi[0]})
+	})
+
+	return jniLibs
+}
+
+// OutputFiles returns the signed apk of the app for ":module" and ":module{.apk}" references,
+// and the package exported to other apps for ":module{.export-package.apk}" references.
+func (a *AndroidApp) OutputFiles(tag string) (android.Paths, error) {
+	switch tag {
+	case "", ".apk":
+		return android.Paths{a.outputFile}, nil
+	case ".export-package.apk":
+		if a.exportPackage == nil {
+			return nil, fmt.Errorf("app does not export its resources, set export_package_resources")
+		}
+		return android.Paths{a.exportPackage}, nil
+	default:
+		return nil, fmt.Errorf("unsupported module reference tag %q", tag)
+	}
+}
+
+// Manifest returns the AndroidManifest.xml of the app, which declares its package name.
+func (a *AndroidApp) Manifest() android.Path {
+	return a.manifestPath
+}
+
+func (a *AndroidApp) dexCrcs() android.Path {
+	return a.dexCrcsFile
+}
+
+func (a *AndroidApp) appMetadata() android.Path {
+	if !proptools.Bool(a.appProperties.Export_metadata) {
+		return nil
+	}
+	return a.badgingFile
+}
+
+// Badging returns the JSON metadata of the apk reported by aapt dump badging, including the
+// permissions it requests.
+func (a *AndroidApp) Badging() android.Path {
+	return a.badgingFile
+}
+
+// Overrides returns the names of the apps that must not be installed together with this app.
+func (a *AndroidApp) Overrides() []string {
+	return a.appProperties.Overrides
+}
+
+// Certificate returns the certificate the app is signed with, without the .x509.pem suffix.
+func (a *AndroidApp) Certificate() string {
+	return a.certificate
+}
+
+// Privileged returns true if the app is installed to priv-app.
+func (a *AndroidApp) Privileged() bool {
+	return proptools.Bool(a.appProperties.Privileged)
+}
+
+func (a *AndroidApp) lintReports() android.Paths {
+	return a.linter.lintReports()
+}
+
+func (a *AndroidApp) PropertyConstraints() []android.PropertyConstraint {
+	return []android.PropertyConstraint{
+		android.MatchesRegexp("certificate", `^[A-Za-z0-9_./-]*$`, "a certificate name or path"),
+	}
 }
 
 func (a *AndroidApp) GenerateAndroidBuildActions(ctx android.ModuleContext) {
@@ -91,19 +326,7 @@ func (a *AndroidApp) GenerateAndroidBuildActions(ctx android.ModuleContext) {
 		a.ExtraSrcLists = append(a.ExtraSrcLists, aaptJavaFileList)
 
 		if a.appProperties.Export_package_resources {
-			aaptPackageFlags := append([]string(nil), aaptFlags...)
-			var hasProduct bool
-			for _, f := range aaptPackageFlags {
-				if strings.HasPrefix(f, "--product") {
-					hasProduct = true
-					break
-				}
-			}
-
-			if !hasProduct {
-				aaptPackageFlags = append(aaptPackageFlags,
-					"--product "+ctx.AConfig().ProductAaptCharacteristics())
-			}
+			aaptPackageFlags := a.productAaptFlags(ctx, aaptFlags)
 			a.exportPackage = CreateExportPackage(ctx, aaptPackageFlags, aaptDeps)
 			ctx.CheckbuildFile(a.exportPackage)
 		}
@@ -121,21 +344,19 @@ func (a *AndroidApp) GenerateAndroidBuildActions(ctx android.ModuleContext) {
 
 	a.Module.compile(ctx)
 
-	aaptPackageFlags := append([]string(nil), aaptFlags...)
-	var hasProduct bool
-	for _, f := range aaptPackageFlags {
-		if strings.HasPrefix(f, "--product") {
-			hasProduct = true
-			break
-		}
+	a.linter.manifest = a.manifestPath
+	a.linter.srcs = a.compiledJavaSrcs
+	if a.annoSrcJar != nil {
+		a.linter.srcJars = android.Paths{a.annoSrcJar}
 	}
+	a.linter.classes = a.classpathFile
+	a.linter.classpath = a.compiledDeps
+	a.linter.sdkVersion = a.deviceProperties.Sdk_version
+	a.linter.lint(ctx)
 
-	if !hasProduct {
-		aaptPackageFlags = append(aaptPackageFlags,
-			"--product "+ctx.AConfig().ProductAaptCharacteristics())
-	}
+	aaptPackageFlags := a.productAaptFlags(ctx, aaptFlags)
 
-	certificate := a.appProperties.Certificate
+	certificate := proptools.String(a.appProperties.Certificate)
 	if certificate == "" {
 		certificate = ctx.AConfig().DefaultAppCertificate(ctx).String()
 	} else if dir, _ := filepath.Split(certificate); dir == "" {
@@ -144,13 +365,41 @@ func (a *AndroidApp) GenerateAndroidBuildActions(ctx android.ModuleContext) {
 		certificate = filepath.Join(android.PathForSource(ctx).String(), certificate)
 	}
 
+	a.certificate = certificate
 	certificates := []string{certificate}
 	for _, c := range a.appProperties.Additional_certificates {
 		certificates = append(certificates, filepath.Join(android.PathForSource(ctx).String(), c))
 	}
+	a.additionalCertificates = certificates[1:]
+
+	jniLibs := a.jniLibs(ctx)
+	if ctx.Failed() {
+		return
+	}
 
-	a.outputFile = CreateAppPackage(ctx, aaptPackageFlags, a.outputFile, certificates)
-	ctx.InstallFileName(android.PathForModuleInstall(ctx, "app"), ctx.ModuleName()+".apk", a.outputFile)
+	// The apk is only built after its strings have been checked
+	var checks android.Paths
+	if stamp := a.checkStringFreeze(ctx); stamp != nil {
+		checks = append(checks, stamp)
+		ctx.CheckbuildFile(stamp)
+	}
+
+	a.outputFile = CreateAppPackage(ctx, aaptPackageFlags, a.outputFile, jniLibs, certificates, checks...)
+	installDir := "app"
+	if a.Privileged() {
+		installDir = "priv-app"
+	}
+
+	installPath := ctx.InstallFileName(android.PathForModuleInstall(ctx, installDir), ctx.ModuleName()+".apk",
+		a.outputFile)
+	a.installPath = installPath
+
+	if dexpreoptEnabled(&a.dexpreoptProperties) {
+		a.dexCrcsFile = recordDexCrcsForApk(ctx, a.outputFile, installPath)
+	}
+
+	// The metadata is also used by the permission audit, which is only built on request
+	a.badgingFile = badgingMetadataForApk(ctx, a.outputFile, installPath)
 }
 
 var aaptIgnoreFilenames = []string{
@@ -165,6 +414,45 @@ var aaptIgnoreFilenames = []string{
 	"*~",
 }
 
+// launcherIconRegexp matches the launcher icon resources that an icon pack may replace: the icon,
+// the round icon, and the layers of an adaptive icon.
+var launcherIconRegexp = regexp.MustCompile(`^(mipmap|drawable)(-[a-z0-9-]+)?/ic_launcher(_round|_foreground|_background)?\.(png|webp|xml)$`)
+
+// iconPackDir returns the resource directory of the app in the icon pack of the product, after
+// checking that it only contains launcher icons.
+func (a *AndroidApp) iconPackDir(ctx android.ModuleContext) android.OptionalPath {
+	iconPack := ctx.AConfig().AppIconPack(ctx)
+	if !iconPack.Valid() {
+		return android.OptionalPath{}
+	}
+
+	name := ctx.ModuleName()
+	if a.appProperties.Icon_pack_name != nil {
+		name = *a.appProperties.Icon_pack_name
+	}
+	dir := android.ExistentPathForSource(ctx, "", iconPack.String(), name, "res")
+	if !dir.Valid() {
+		return dir
+	}
+
+	icons, err := ctx.GlobWithDeps(filepath.Join(dir.String(), "**/*"), aaptIgnoreFilenames)
+	if err != nil {
+		ctx.ModuleErrorf("glob: %s", err.Error())
+	}
+	for _, icon := range icons {
+		rel, err := filepath.Rel(dir.String(), icon)
+		if err == nil && (strings.HasSuffix(icon, "/") || !strings.Contains(rel, "/")) {
+			// The glob also matches the resource type directories
+			continue
+		}
+		if err != nil || !launcherIconRegexp.MatchString(rel) {
+			ctx.ModuleErrorf("icon pack file %q is not a launcher icon", icon)
+		}
+	}
+
+	return dir
+}
+
 func (a *AndroidApp) aaptFlags(ctx android.ModuleContext) ([]string, android.Paths, bool) {
 	aaptFlags := a.appProperties.Aaptflags
 	hasVersionCode := false
@@ -183,11 +471,12 @@ func (a *AndroidApp) aaptFlags(ctx android.ModuleContext) ([]string, android.Pat
 
 	assetDirs := android.PathsWithOptionalDefaultForModuleSrc(ctx, a.appProperties.Asset_dirs, "assets")
 	resourceDirs := android.PathsWithOptionalDefaultForModuleSrc(ctx, a.appProperties.Android_resource_dirs, "res")
+	a.linter.resources = resourceDirs
 
 	var overlayResourceDirs android.Paths
 	// For every resource directory, check if there is an overlay directory with the same path.
 	// If found, it will be prepended to the list of resource directories.
-	for _, overlayDir := range ctx.AConfig().ResourceOverlays() {
+	for _, overlayDir := range ctx.AConfig().ResourceOverlays(ctx) {
 		for _, resourceDir := range resourceDirs {
 			overlay := overlayDir.OverlayPath(ctx, resourceDir)
 			if overlay.Valid() {
@@ -200,6 +489,13 @@ func (a *AndroidApp) aaptFlags(ctx android.ModuleContext) ([]string, android.Pat
 		resourceDirs = append(overlayResourceDirs, resourceDirs...)
 	}
 
+	// The launcher icons from the icon pack take precedence over the app and its overlays.  They
+	// may add resources, for example the foreground of an adaptive icon.
+	if iconPackDir := a.iconPackDir(ctx); iconPackDir.Valid() {
+		resourceDirs = append(android.Paths{iconPackDir.Path()}, resourceDirs...)
+		aaptFlags = append(aaptFlags, "--auto-add-overlay")
+	}
+
 	// aapt needs to rerun if any files are added or modified in the assets or resource directories,
 	// use glob to create a filelist.
 	var aaptDeps android.Paths
@@ -207,6 +503,7 @@ func (a *AndroidApp) aaptFlags(ctx android.ModuleContext) ([]string, android.Pat
 	for _, d := range resourceDirs {
 		newDeps := ctx.Glob(filepath.Join(d.String(), "**/*"), aaptIgnoreFilenames)
 		aaptDeps = append(aaptDeps, newDeps...)
+		a.linter.resourceFiles = append(a.linter.resourceFiles, newDeps...)
 		if len(newDeps) > 0 {
 			hasResources = true
 		}
@@ -225,6 +522,7 @@ func (a *AndroidApp) aaptFlags(ctx android.ModuleContext) ([]string, android.Pat
 
 	manifestPath := android.PathForModuleSrc(ctx, manifestFile)
 	aaptDeps = append(aaptDeps, manifestPath)
+	a.manifestPath = manifestPath
 
 	aaptFlags = append(aaptFlags, "-M "+manifestPath.String())
 	aaptFlags = append(aaptFlags, android.JoinWithPrefix(assetDirs.Strings(), "-A "))
@@ -237,6 +535,13 @@ func (a *AndroidApp) aaptFlags(ctx android.ModuleContext) ([]string, android.Pat
 		} else if javaDep, ok := module.(Dependency); ok {
 			if ctx.OtherModuleName(module) == "framework-res" {
 				depFiles = android.Paths{javaDep.(*AndroidApp).exportPackage}
+			} else if ctx.OtherModuleDependencyTag(module) == lineageResTag {
+				if app, ok := module.(*AndroidApp); ok && app.exportPackage != nil {
+					depFiles = android.Paths{app.exportPackage}
+				} else {
+					ctx.ModuleErrorf("%q must be an android_app with export_package_resources",
+						ctx.OtherModuleName(module))
+				}
 			}
 		}
 
@@ -247,8 +552,11 @@ func (a *AndroidApp) aaptFlags(ctx android.ModuleContext) ([]string, android.Pat
 	})
 
 	sdkVersion := a.deviceProperties.Sdk_version
-	if sdkVersion == "" {
+	switch sdkVersion {
+	case "":
 		sdkVersion = ctx.AConfig().PlatformSdkVersion()
+	case "lineage_current":
+		sdkVersion = "current"
 	}
 
 	aaptFlags = append(aaptFlags, "--min-sdk-version "+sdkVersion)
@@ -259,8 +567,7 @@ func (a *AndroidApp) aaptFlags(ctx android.ModuleContext) ([]string, android.Pat
 	}
 
 	if !hasVersionName {
-		aaptFlags = append(aaptFlags,
-			"--version-name "+ctx.AConfig().PlatformVersion()+"-"+ctx.AConfig().BuildNumber())
+		aaptFlags = append(aaptFlags, "--version-name "+ctx.AConfig().AppVersionName())
 	}
 
 	// TODO: LOCAL_PACKAGE_OVERRIDES
@@ -272,6 +579,46 @@ func (a *AndroidApp) aaptFlags(ctx android.ModuleContext) ([]string, android.Pat
 	return aaptFlags, aaptDeps, hasResources
 }
 
+// productAaptFlags returns the flags used to package the resources of the app, with the aapt
+// configuration of the product added unless the app already sets the same flags in aaptflags.
+func (a *AndroidApp) productAaptFlags(ctx android.ModuleContext, aaptFlags []string) []string {
+	hasFlag := func(flag string) bool {
+		for _, f := range aaptFlags {
+			if f == flag || strings.HasPrefix(f, flag+" ") || strings.HasPrefix(f, flag+"=") {
+				return true
+			}
+		}
+		return false
+	}
+
+	config := ctx.AConfig()
+	aaptPackageFlags := append([]string(nil), aaptFlags...)
+
+	if !hasFlag("--product") {
+		aaptPackageFlags = append(aaptPackageFlags, "--product "+config.ProductAaptCharacteristics())
+	}
+
+	if !proptools.Bool(a.appProperties.Aapt_include_all_resources) {
+		if aaptConfig := config.ProductAaptConfig(); len(aaptConfig) > 0 && !hasFlag("-c") {
+			aaptPackageFlags = append(aaptPackageFlags, "-c "+strings.Join(aaptConfig, ","))
+		}
+		if preferred := config.ProductAaptPreferredConfig(); preferred != "" &&
+			!hasFlag("--preferred-density") {
+			aaptPackageFlags = append(aaptPackageFlags, "--preferred-density "+preferred)
+		}
+	}
+
+	if config.ProductAaptPseudoLocales() && !hasFlag("--pseudo-localize") {
+		aaptPackageFlags = append(aaptPackageFlags, "--pseudo-localize")
+	}
+
+	if config.ProductAaptUtf16() && !hasFlag("--utf16") {
+		aaptPackageFlags = append(aaptPackageFlags, "--utf16")
+	}
+
+	return aaptPackageFlags
+}
+
 func AndroidAppFactory() android.Module {
 	module := &AndroidApp{}
 
@@ -280,8 +627,16 @@ func AndroidAppFactory() android.Module {
 	module.AddProperties(
 		&module.Module.properties,
 		&module.Module.deviceProperties,
-		&module.appProperties)
+		&module.appProperties,
+		&module.linter.properties,
+		&module.dexpreoptProperties)
 
-	android.InitAndroidArchModule(module, android.DeviceSupported, android.MultilibCommon)
+	InitJavaModule(module, android.DeviceSupported)
 	return module
 }
+
+// android_app_defaults provides the properties of android_app modules, and of the java modules
+// that java_defaults provides, to the apps that list it in their defaults.
+func AppDefaultsFactory() android.Module {
+	return DefaultsFactory(&androidAppProperties{}, &LintProperties{}, &DexpreoptProperties{})
+}
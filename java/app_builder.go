@@ -19,6 +19,7 @@ package java
 // functions.
 
 import (
+	"path/filepath"
 	"strings"
 
 	"github.com/google/blueprint"
@@ -27,7 +28,7 @@ import (
 )
 
 var (
-	aaptCreateResourceJavaFile = pctx.AndroidStaticRule("aaptCreateResourceJavaFile",
+	aaptCreateResourceJavaFile = pctx.AndroidTmpDirStaticRule("aaptCreateResourceJavaFile",
 		blueprint.RuleParams{
 			Command: `rm -rf "$javaDir" && mkdir -p "$javaDir" && ` +
 				`$aaptCmd package -m $aaptFlags -P $publicResourcesFile -G $proguardOptionsFile ` +
@@ -37,24 +38,34 @@ var (
 		},
 		"aaptFlags", "publicResourcesFile", "proguardOptionsFile", "javaDir", "javaFileList")
 
-	aaptCreateAssetsPackage = pctx.AndroidStaticRule("aaptCreateAssetsPackage",
+	aaptCreateAssetsPackage = pctx.AndroidTmpDirStaticRule("aaptCreateAssetsPackage",
 		blueprint.RuleParams{
 			Command:     `rm -f $out && $aaptCmd package $aaptFlags -F $out`,
 			CommandDeps: []string{"$aaptCmd"},
 		},
 		"aaptFlags", "publicResourcesFile", "proguardOptionsFile", "javaDir", "javaFileList")
 
-	aaptAddResources = pctx.AndroidStaticRule("aaptAddResources",
+	aaptAddResources = pctx.AndroidTmpDirStaticRule("aaptAddResources",
 		blueprint.RuleParams{
-			// TODO: add-jni-shared-libs-to-package
 			Command:     `cp -f $in $out.tmp && $aaptCmd package -u $aaptFlags -F $out.tmp && mv $out.tmp $out`,
 			CommandDeps: []string{"$aaptCmd"},
 		},
 		"aaptFlags")
 
-	signapk = pctx.AndroidStaticRule("signapk",
+	// addJniLibs stages the native libraries in lib/<abi> directories and adds them to the apk
+	// uncompressed, without adding a jar manifest
+	addJniLibs = pctx.AndroidStaticRule("addJniLibs",
 		blueprint.RuleParams{
-			Command:     `java -jar $signapkCmd $certificates $in $out`,
+			Command: `rm -rf $jniDir && mkdir -p $jniDir && $copyCommands && ` +
+				`cp -f $in $out.tmp && ${config.JarCmd} u0Mf $out.tmp -C $jniDir lib && ` +
+				`mv $out.tmp $out && rm -rf $jniDir`,
+			CommandDeps: []string{"${config.JarCmd}"},
+		},
+		"jniDir", "copyCommands")
+
+	signapk = pctx.AndroidTmpDirStaticRule("signapk",
+		blueprint.RuleParams{
+			Command:     `java -Djava.io.tmpdir=$tmpDir -jar $signapkCmd $certificates $in $out`,
 			CommandDeps: []string{"$signapkCmd"},
 		},
 		"certificates")
@@ -115,8 +126,17 @@ func CreateExportPackage(ctx android.ModuleContext, flags []string, deps android
 	return outputFile
 }
 
+// jniLib is a native library packaged into an apk.
+type jniLib struct {
+	name string
+	path android.Path
+	abi  string
+}
+
+// CreateAppPackage builds and signs the apk.  The apk is only built after the checks in deps
+// have passed, so that they also run when Make installs the apk.
 func CreateAppPackage(ctx android.ModuleContext, flags []string, jarFile android.Path,
-	certificates []string) android.Path {
+	jniLibs []jniLib, certificates []string, deps ...android.Path) android.Path {
 
 	resourceApk := android.PathForModuleOut(ctx, "resources.apk")
 
@@ -130,6 +150,11 @@ func CreateAppPackage(ctx android.ModuleContext, flags []string, jarFile android
 		},
 	})
 
+	unsignedApk := android.Path(resourceApk)
+	if len(jniLibs) > 0 {
+		unsignedApk = addJniLibsToPackage(ctx, resourceApk, jniLibs)
+	}
+
 	outputFile := android.PathForModuleOut(ctx, "package.apk")
 
 	var certificateArgs []string
@@ -141,7 +166,8 @@ func CreateAppPackage(ctx android.ModuleContext, flags []string, jarFile android
 		Rule:        signapk,
 		Description: "signapk",
 		Output:      outputFile,
-		Input:       resourceApk,
+		Input:       unsignedApk,
+		Implicits:   deps,
 		Args: map[string]string{
 			"certificates": strings.Join(certificateArgs, " "),
 		},
@@ -149,3 +175,31 @@ func CreateAppPackage(ctx android.ModuleContext, flags []string, jarFile android
 
 	return outputFile
 }
+
+func addJniLibsToPackage(ctx android.ModuleContext, apk android.Path, jniLibs []jniLib) android.Path {
+	outputFile := android.PathForModuleOut(ctx, "jni.apk")
+	jniDir := android.PathForModuleOut(ctx, "jni")
+
+	var copyCommands []string
+	var libs android.Paths
+	for _, lib := range jniLibs {
+		abiDir := filepath.Join(jniDir.String(), "lib", lib.abi)
+		copyCommands = append(copyCommands,
+			"mkdir -p "+abiDir, "cp -f "+lib.path.String()+" "+abiDir+"/")
+		libs = append(libs, lib.path)
+	}
+
+	ctx.ModuleBuild(pctx, android.ModuleBuildParams{
+		Rule:        addJniLibs,
+		Description: "add jni libs",
+		Output:      outputFile,
+		Input:       apk,
+		Implicits:   libs,
+		Args: map[string]string{
+			"jniDir":       jniDir.String(),
+			"copyCommands": strings.Join(copyCommands, " && "),
+		},
+	})
+
+	return outputFile
+}
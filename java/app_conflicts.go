@@ -0,0 +1,102 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package java
+
+// This file contains the app_conflicts singleton, which checks that no two apps installed by the
+// build provide the same package, for example microG and the Google Play services, or a GMS stub
+// and the app it stands in for, and that no app is installed together with an app it overrides.
+// Only the apps that are exported to Make are checked, the apps in namespaces that the product
+// doesn't use can't be installed.  See scripts/app_conflicts.py.
+
+import (
+	"github.com/google/blueprint"
+
+	"android/soong/android"
+)
+
+func init() {
+	android.RegisterSingletonType("app_conflicts", AppConflictsSingleton)
+
+	pctx.SourcePathVariable("appConflictsCmd", "build/soong/scripts/app_conflicts.py")
+	pctx.IntermediatesPathVariable("appConflictsReport", "app_conflicts.txt")
+
+	android.RegisterMakeVarsProvider(pctx, appConflictsMakeVarsProvider)
+}
+
+var appConflicts = pctx.AndroidStaticRule("appConflicts",
+	blueprint.RuleParams{
+		Command:        `$appConflictsCmd --apps $out.rsp $overrides $knownPackages --output $out`,
+		CommandDeps:    []string{"$appConflictsCmd"},
+		Rspfile:        "$out.rsp",
+		RspfileContent: "$in",
+	},
+	"overrides", "knownPackages")
+
+// overridingApp is implemented by modules that install apps, to provide the package of the app
+// and the apps that it replaces.
+type overridingApp interface {
+	android.Module
+	Badging() android.Path
+	Overrides() []string
+}
+
+func AppConflictsSingleton() blueprint.Singleton {
+	return &appConflictsSingleton{}
+}
+
+type appConflictsSingleton struct{}
+
+// appConflictsVisitor is the part of blueprint.SingletonContext that is needed to find the apps,
+// which the blueprint.Context of tests also implements.
+type appConflictsVisitor interface {
+	VisitAllModules(visit func(blueprint.Module))
+	ModuleName(module blueprint.Module) string
+}
+
+// appConflictsInputs returns the badging metadata of the apps that are exported to Make, and the
+// apps that they override as "app:overridden" pairs.
+func appConflictsInputs(ctx appConflictsVisitor) (apps, overrides []string) {
+	ctx.VisitAllModules(func(module blueprint.Module) {
+		app, ok := module.(overridingApp)
+		if !ok || !app.ExportedToMake() || app.Badging() == nil {
+			return
+		}
+		apps = append(apps, app.Badging().String())
+		for _, overridden := range app.Overrides() {
+			overrides = append(overrides, ctx.ModuleName(module)+":"+overridden)
+		}
+	})
+	return apps, overrides
+}
+
+func (s *appConflictsSingleton) GenerateBuildActions(ctx blueprint.SingletonContext) {
+	apps, overrides := appConflictsInputs(ctx)
+
+	config := ctx.Config().(android.Config)
+	ctx.Build(pctx, blueprint.BuildParams{
+		Rule:        appConflicts,
+		Description: "app conflicts",
+		Outputs:     []string{"$appConflictsReport"},
+		Inputs:      apps,
+		Args: map[string]string{
+			"overrides":     android.JoinWithPrefix(overrides, "--override "),
+			"knownPackages": android.JoinWithPrefix(config.InstalledAppPackages(), "--known-package "),
+		},
+	})
+}
+
+func appConflictsMakeVarsProvider(ctx android.MakeVarsContext) {
+	ctx.Strict("SOONG_APP_CONFLICTS_REPORT", "${appConflictsReport}")
+}
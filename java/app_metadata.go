@@ -0,0 +1,106 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package java
+
+// This file exports the metadata of apps, the package name, version, permissions and components
+// reported by aapt dump badging, into a single JSON file for tools that audit the apps in the
+// build and for the updater backend.  See scripts/app_metadata.py.
+
+import (
+	"github.com/google/blueprint"
+
+	"android/soong/android"
+)
+
+func init() {
+	android.RegisterSingletonType("app_metadata", AppMetadataSingleton)
+
+	pctx.SourcePathVariable("appMetadataCmd", "build/soong/scripts/app_metadata.py")
+	pctx.IntermediatesPathVariable("appMetadata", "app_metadata.json")
+
+	android.RegisterMakeVarsProvider(pctx, appMetadataMakeVarsProvider)
+}
+
+var (
+	appBadging = pctx.AndroidStaticRule("appBadging",
+		blueprint.RuleParams{
+			Command: `$aaptCmd dump badging $in > $out.badging && ` +
+				`$appMetadataCmd badging --module $module --device-path $devicePath $out.badging $out && ` +
+				`rm -f $out.badging`,
+			CommandDeps: []string{"$aaptCmd", "$appMetadataCmd"},
+		},
+		"module", "devicePath")
+
+	mergeAppMetadata = pctx.AndroidStaticRule("mergeAppMetadata",
+		blueprint.RuleParams{
+			Command:        `$appMetadataCmd merge $out @$out.rsp`,
+			CommandDeps:    []string{"$appMetadataCmd"},
+			Rspfile:        "$out.rsp",
+			RspfileContent: "$in_newline",
+		})
+)
+
+// appMetadataProducer is implemented by modules that export the metadata of their apk.
+type appMetadataProducer interface {
+	appMetadata() android.Path
+}
+
+// badgingMetadataForApk writes the metadata of the installed apk as JSON.
+func badgingMetadataForApk(ctx android.ModuleContext, apk android.Path,
+	installPath android.OutputPath) android.Path {
+
+	metadata := android.PathForModuleOut(ctx, "app_metadata.json")
+	ctx.ModuleBuild(pctx, android.ModuleBuildParams{
+		Rule:        appBadging,
+		Description: "app metadata",
+		Output:      metadata,
+		Input:       apk,
+		Args: map[string]string{
+			"module":     ctx.ModuleName(),
+			"devicePath": devicePathForInstall(ctx, installPath),
+		},
+	})
+
+	return metadata
+}
+
+func AppMetadataSingleton() blueprint.Singleton {
+	return &appMetadataSingleton{}
+}
+
+type appMetadataSingleton struct{}
+
+// GenerateBuildActions merges the metadata of all apps that export it into a single JSON list.
+func (s *appMetadataSingleton) GenerateBuildActions(ctx blueprint.SingletonContext) {
+	var metadata []string
+	ctx.VisitAllModules(func(module blueprint.Module) {
+		if producer, ok := module.(appMetadataProducer); ok {
+			if m := producer.appMetadata(); m != nil {
+				metadata = append(metadata, m.String())
+			}
+		}
+	})
+
+	ctx.Build(pctx, blueprint.BuildParams{
+		Rule:        mergeAppMetadata,
+		Description: "merge app metadata",
+		Outputs:     []string{"$appMetadata"},
+		Inputs:      metadata,
+	})
+}
+
+func appMetadataMakeVarsProvider(ctx android.MakeVarsContext) {
+	ctx.Strict("SOONG_APP_METADATA", "${appMetadata}")
+}
@@ -0,0 +1,174 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package java
+
+// This file checks that the boot and system server jars configured for the product are built as
+// installable dex jars.  A jar that is on the classpath but missing from the device, or that
+// contains no classes.dex, makes the device boot loop, so the build fails with a diff of the
+// configured and built jars instead.
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/google/blueprint"
+
+	"android/soong/android"
+)
+
+func init() {
+	android.RegisterSingletonType("boot_jars_check", BootJarsCheckSingleton)
+
+	pctx.IntermediatesPathVariable("bootJarsCheckStamp", "boot_jars_check.stamp")
+
+	android.RegisterMakeVarsProvider(pctx, bootJarsMakeVarsProvider)
+}
+
+var (
+	checkBootJars = pctx.AndroidStaticRule("checkBootJars",
+		blueprint.RuleParams{
+			Command: `(diff -u --label configured --label built $configured $built >&2 || ` +
+				`(echo "The jars above are configured as boot or system server jars but are not ` +
+				`built as installable dex jars" >&2; exit 1)) && ` +
+				`for jar in $in; do ` +
+				`unzip -l $$jar classes.dex >/dev/null || { echo "$$jar: missing classes.dex" >&2; exit 1; }; ` +
+				`done && touch $out`,
+		},
+		"configured", "built")
+)
+
+// installedDexJarProducer is implemented by modules that install a dex jar into the framework
+// directory, which can be put on the boot or system server classpath.  installedDexJar returns
+// the dex jar that is installed, or nil if the module doesn't install one.
+type installedDexJarProducer interface {
+	installedDexJar() android.Path
+}
+
+func (j *Module) installedDexJar() android.Path {
+	if j.installFile == nil {
+		return nil
+	}
+	return j.dexJarFile
+}
+
+func (j *Import) installedDexJar() android.Path {
+	return j.installedDexJarFile
+}
+
+func BootJarsCheckSingleton() blueprint.Singleton {
+	return &bootJarsCheckSingleton{}
+}
+
+type bootJarsCheckSingleton struct{}
+
+func (s *bootJarsCheckSingleton) GenerateBuildActions(ctx blueprint.SingletonContext) {
+	config := ctx.Config().(android.Config)
+
+	seen := make(map[string]string)
+	var configured []string
+	for _, list := range []struct {
+		variable string
+		jars     []string
+	}{
+		{"PRODUCT_BOOT_JARS", config.BootJars()},
+		{"PRODUCT_SYSTEM_SERVER_JARS", config.SystemServerJars()},
+	} {
+		for _, jar := range list.jars {
+			if other, exists := seen[jar]; exists {
+				ctx.Errorf("%s: jar %q is already listed in %s", list.variable, jar, other)
+				continue
+			}
+			seen[jar] = list.variable
+			configured = append(configured, jar)
+		}
+	}
+
+	// Find the java modules defined in Soong, and the installed dex jars of their device variants
+	defined := make(map[string]bool)
+	installed := make(map[string]android.Path)
+	ctx.VisitAllModules(func(module blueprint.Module) {
+		m, ok := module.(android.Module)
+		if !ok || m.Target().Os.Class != android.Device {
+			return
+		}
+		if _, ok := module.(Dependency); !ok {
+			return
+		}
+		name := ctx.ModuleName(module)
+		defined[name] = true
+		if producer, ok := module.(installedDexJarProducer); ok && m.Enabled() {
+			if dexJar := producer.installedDexJar(); dexJar != nil {
+				installed[name] = dexJar
+			}
+		}
+	})
+
+	configuredInSoong, built, builtJars := bootJarsToCheck(configured, defined, installed)
+
+	configuredFile := android.PathForOutput(ctx, "boot_jars_check", "configured.txt")
+	builtFile := android.PathForOutput(ctx, "boot_jars_check", "built.txt")
+	for _, f := range []struct {
+		path android.WritablePath
+		jars []string
+	}{
+		{configuredFile, configuredInSoong},
+		{builtFile, built},
+	} {
+		ctx.Build(pctx, blueprint.BuildParams{
+			Rule:    android.WriteFile,
+			Outputs: []string{f.path.String()},
+			Args: map[string]string{
+				"content": strings.Join(f.jars, `\n`),
+			},
+		})
+	}
+
+	ctx.Build(pctx, blueprint.BuildParams{
+		Rule:        checkBootJars,
+		Description: "check boot jars",
+		Outputs:     []string{"$bootJarsCheckStamp"},
+		Inputs:      builtJars,
+		Implicits:   []string{configuredFile.String(), builtFile.String()},
+		Args: map[string]string{
+			"configured": configuredFile.String(),
+			"built":      builtFile.String(),
+		},
+	})
+}
+
+// bootJarsToCheck returns the sorted names of the configured jars that are defined in Soong, the
+// sorted names of the ones among them that are built as installed dex jars, and the paths of
+// those dex jars.  Jars that are not defined in Soong are built by Make, which checks them itself.
+func bootJarsToCheck(configured []string, defined map[string]bool,
+	installed map[string]android.Path) (configuredInSoong, built, builtJars []string) {
+
+	for _, jar := range configured {
+		if !defined[jar] {
+			continue
+		}
+		configuredInSoong = append(configuredInSoong, jar)
+		if dexJar, ok := installed[jar]; ok {
+			built = append(built, jar)
+			builtJars = append(builtJars, dexJar.String())
+		}
+	}
+	sort.Strings(configuredInSoong)
+	sort.Strings(built)
+	return configuredInSoong, built, builtJars
+}
+
+func bootJarsMakeVarsProvider(ctx android.MakeVarsContext) {
+	ctx.Strict("SOONG_BOOT_JARS_CHECK", "${bootJarsCheckStamp}")
+}
@@ -39,7 +39,7 @@ var (
 		blueprint.RuleParams{
 			Command: `rm -rf "$outDir" "$annoDir" && mkdir -p "$outDir" "$annoDir" && ` +
 				`${config.JavacWrapper}${config.JavacCmd} ${config.CommonJdkFlags} ` +
-				`$javacFlags $bootClasspath $classpath ` +
+				`$javacFlags $bootClasspath $classpath $processorPath ` +
 				`-source $javaVersion -target $javaVersion ` +
 				`-d $outDir -s $annoDir @$out.rsp && ` +
 				`find $outDir -type f | sort | ${config.JarArgsCmd} $outDir > $out`,
@@ -47,7 +47,91 @@ var (
 			Rspfile:        "$out.rsp",
 			RspfileContent: "$in",
 		},
-		"javacFlags", "bootClasspath", "classpath", "outDir", "annoDir", "javaVersion")
+		"javacFlags", "bootClasspath", "classpath", "processorPath", "outDir", "annoDir", "javaVersion")
+
+	// annoSrcJar collects the sources generated by annotation processors during the javac
+	// compile into a srcjar, so that tools that need to see the generated sources (lint, IDE
+	// project generation) don't have to know about the javac output directories.
+	annoSrcJar = pctx.AndroidStaticRule("annoSrcJar",
+		blueprint.RuleParams{
+			Command: `find $annoDir -type f | sort > $out.list && ` +
+				`${config.SoongZipCmd} -o $out -C $annoDir -l $out.list && rm $out.list`,
+			CommandDeps: []string{"${config.SoongZipCmd}"},
+		},
+		"annoDir")
+
+	// turbine generates header jars containing only the API of the sources, without compiling
+	// method bodies.  The output is only replaced when its contents change, so that rules that
+	// use it on their classpath are not rerun when only implementation details change.
+	turbine = pctx.AndroidStaticRule("turbine",
+		blueprint.RuleParams{
+			Command: `rm -rf "$outDir" && mkdir -p "$outDir" && ` +
+				`${config.JavaCmd} -jar ${config.TurbineJar} --output $out.tmp ` +
+				`--temp_dir "$outDir" --sources @$out.rsp $srcFileLists ` +
+				`--javacopts ${config.CommonJdkFlags} ` +
+				`$javacFlags -source $javaVersion -target $javaVersion -- ` +
+				`$bootClasspath $classpath && ` +
+				`(if cmp -s $out.tmp $out ; then rm $out.tmp ; else mv $out.tmp $out ; fi)`,
+			CommandDeps:    []string{"${config.JavaCmd}", "${config.TurbineJar}"},
+			Rspfile:        "$out.rsp",
+			RspfileContent: "$in",
+			Restat:         true,
+		},
+		"javacFlags", "srcFileLists", "bootClasspath", "classpath", "outDir", "javaVersion")
+
+	errorprone = pctx.AndroidStaticRule("errorprone",
+		blueprint.RuleParams{
+			Command: `rm -rf "$outDir" "$annoDir" && mkdir -p "$outDir" "$annoDir" && ` +
+				`${config.ErrorProneCmd} ` +
+				`$javacFlags $bootClasspath $classpath $processorPath ` +
+				`-source $javaVersion -target $javaVersion ` +
+				`-d $outDir -s $annoDir @$out.rsp && ` +
+				`find $outDir -type f | sort | ${config.JarArgsCmd} $outDir > $out`,
+			CommandDeps: []string{
+				"${config.JavaCmd}",
+				"${config.ErrorProneJavacJar}",
+				"${config.ErrorProneJar}",
+				"${config.JarArgsCmd}",
+			},
+			Rspfile:        "$out.rsp",
+			RspfileContent: "$in",
+		},
+		"javacFlags", "bootClasspath", "classpath", "processorPath", "outDir", "annoDir", "javaVersion")
+
+	// doclavaStubs runs the doclava doclet over the sources of a library to generate stub sources
+	// and the API description files.  The stub sources are listed in $out so that they can be
+	// passed to javac as a source file list.
+	doclavaStubs = pctx.AndroidStaticRule("doclavaStubs",
+		blueprint.RuleParams{
+			Command: `rm -rf "$outDir" "$stubsDir" && mkdir -p "$outDir" "$stubsDir" && ` +
+				`${config.JavadocCmd} -encoding UTF-8 -source $javaVersion -J-Xmx1600m ` +
+				`-XDignore.symbol.file $bootClasspath $classpath @$out.rsp $srcFileLists ` +
+				`-d $outDir -quiet -doclet com.google.doclava.Doclava ` +
+				`-docletpath ${config.DoclavaJars} -nodocs $doclavaFlags ` +
+				`-stubs $stubsDir -stubpackages $stubPackages ` +
+				`-api $apiFile -removedApi $removedApiFile && ` +
+				`find $stubsDir -name "*.java" | sort > $out`,
+			CommandDeps: []string{
+				"${config.JavadocCmd}",
+				"${config.DoclavaJar}",
+				"${config.JsilverJar}",
+			},
+			Rspfile:        "$out.rsp",
+			RspfileContent: "$in",
+		},
+		"bootClasspath", "classpath", "srcFileLists", "outDir", "stubsDir", "stubPackages",
+		"apiFile", "removedApiFile", "doclavaFlags", "javaVersion")
+
+	// apiCheck compares the API description files generated for a library against the checked
+	// in ones, in the order current api, generated api, current removed api, generated removed
+	// api.
+	apiCheck = pctx.AndroidStaticRule("apiCheck",
+		blueprint.RuleParams{
+			Command: `( ${config.ApiCheckCmd} -JXmx1024m ${config.ApiCheckCurrentFlags} $in && touch $out ) || ` +
+				`( echo -e "$msg" ; exit 38 )`,
+			CommandDeps: []string{"${config.ApiCheckCmd}"},
+		},
+		"msg")
 
 	jar = pctx.AndroidStaticRule("jar",
 		blueprint.RuleParams{
@@ -65,10 +149,21 @@ var (
 		},
 		"outDir", "dxFlags")
 
+	// d8 converts class files to dex like dx, and also desugars the java 8 language features
+	// that the runtime doesn't support, which needs the classes they are compiled against.
+	d8 = pctx.AndroidStaticRule("d8",
+		blueprint.RuleParams{
+			Command: `rm -rf "$outDir" && mkdir -p "$outDir" && ` +
+				`${config.D8Cmd} --output $outDir $d8Flags $in && ` +
+				`find "$outDir" -name "classes*.dex" | sort | ${config.JarArgsCmd} ${outDir} > $out`,
+			CommandDeps: []string{"${config.D8Cmd}", "${config.JarArgsCmd}"},
+		},
+		"outDir", "d8Flags")
+
 	jarjar = pctx.AndroidStaticRule("jarjar",
 		blueprint.RuleParams{
 			Command:     "${config.JavaCmd} -jar ${config.JarjarCmd} process $rulesFile $in $out",
-			CommandDeps: []string{"${config.JavaCmd}", "${config.JarjarCmd}", "$rulesFile"},
+			CommandDeps: []string{"${config.JavaCmd}", "${config.JarjarCmd}"},
 		},
 		"rulesFile")
 
@@ -99,7 +194,17 @@ type javaBuilderFlags struct {
 	bootClasspath string
 	classpath     string
 	aidlFlags     string
+	aidlDeps      android.Paths
 	javaVersion   string
+	processorPath string
+
+	protoFlags       string
+	protoOutTypeFlag string
+	protoDeps        android.Paths
+
+	// jars that d8 desugars against, passed as --lib and --classpath
+	dexBootClasspath android.Paths
+	dexClasspath     android.Paths
 }
 
 type jarSpec struct {
@@ -117,17 +222,28 @@ func (j jarSpec) path() android.Path {
 func TransformJavaToClasses(ctx android.ModuleContext, srcFiles android.Paths, srcFileLists android.Paths,
 	flags javaBuilderFlags, deps android.Paths) jarSpec {
 
-	classDir := android.PathForModuleOut(ctx, "classes")
-	annoDir := android.PathForModuleOut(ctx, "anno")
-	classFileList := android.PathForModuleOut(ctx, "classes.list")
+	return transformJavaToClasses(ctx, "", srcFiles, srcFileLists, flags, deps)
+}
 
-	javacFlags := flags.javacFlags + android.JoinWithPrefix(srcFileLists.Strings(), "@")
+// transformJavaToClasses compiles java sources into classes, using suffix to keep the outputs of
+// multiple compiles in the same module apart.
+func transformJavaToClasses(ctx android.ModuleContext, suffix string, srcFiles android.Paths,
+	srcFileLists android.Paths, flags javaBuilderFlags, deps android.Paths) jarSpec {
+
+	classDir := android.PathForModuleOut(ctx, "classes"+suffix)
+	annoDir := android.PathForModuleOut(ctx, "anno"+suffix)
+	classFileList := android.PathForModuleOut(ctx, "classes"+suffix+".list")
+
+	javacFlags := flags.javacFlags
+	if len(srcFileLists) > 0 {
+		javacFlags += " " + android.JoinWithPrefix(srcFileLists.Strings(), "@")
+	}
 
 	deps = append(deps, srcFileLists...)
 
 	ctx.ModuleBuild(pctx, android.ModuleBuildParams{
 		Rule:        javac,
-		Description: "javac",
+		Description: "javac" + suffix,
 		Output:      classFileList,
 		Inputs:      srcFiles,
 		Implicits:   deps,
@@ -135,6 +251,7 @@ func TransformJavaToClasses(ctx android.ModuleContext, srcFiles android.Paths, s
 			"javacFlags":    javacFlags,
 			"bootClasspath": flags.bootClasspath,
 			"classpath":     flags.classpath,
+			"processorPath": flags.processorPath,
 			"outDir":        classDir.String(),
 			"annoDir":       annoDir.String(),
 			"javaVersion":   flags.javaVersion,
@@ -144,10 +261,112 @@ func TransformJavaToClasses(ctx android.ModuleContext, srcFiles android.Paths, s
 	return jarSpec{classFileList}
 }
 
+// TransformAnnotationSourcesToSrcJar zips the sources generated by annotation processors while
+// compiling classes into a srcjar.
+func TransformAnnotationSourcesToSrcJar(ctx android.ModuleContext, classes jarSpec) android.Path {
+	annoDir := android.PathForModuleOut(ctx, "anno")
+	outputFile := android.PathForModuleOut(ctx, "anno.srcjar")
+
+	ctx.ModuleBuild(pctx, android.ModuleBuildParams{
+		Rule:        annoSrcJar,
+		Description: "anno srcjar",
+		Output:      outputFile,
+		Input:       classes.path(),
+		Args: map[string]string{
+			"annoDir": annoDir.String(),
+		},
+	})
+
+	return outputFile
+}
+
+// TransformJavaToHeaderClasses runs turbine over the sources to produce a header jar.  Turbine
+// takes classpaths as space separated lists, so they are passed separately from the javac
+// formatted flags.
+func TransformJavaToHeaderClasses(ctx android.ModuleContext, srcFiles android.Paths, srcFileLists android.Paths,
+	flags javaBuilderFlags, bootClasspath, classpath android.Paths) android.Path {
+
+	outDir := android.PathForModuleOut(ctx, "turbine")
+	outputFile := android.PathForModuleOut(ctx, "classes-header.jar")
+
+	var deps android.Paths
+	deps = append(deps, srcFileLists...)
+	deps = append(deps, bootClasspath...)
+	deps = append(deps, classpath...)
+
+	var bootClasspathFlag, classpathFlag string
+	if len(bootClasspath) > 0 {
+		bootClasspathFlag = "--bootclasspath " + strings.Join(bootClasspath.Strings(), " ")
+	}
+	if len(classpath) > 0 {
+		classpathFlag = "--classpath " + strings.Join(classpath.Strings(), " ")
+	}
+
+	ctx.ModuleBuild(pctx, android.ModuleBuildParams{
+		Rule:        turbine,
+		Description: "turbine",
+		Output:      outputFile,
+		Inputs:      srcFiles,
+		Implicits:   deps,
+		Args: map[string]string{
+			"javacFlags":    flags.javacFlags,
+			"srcFileLists":  android.JoinWithPrefix(srcFileLists.Strings(), "@"),
+			"bootClasspath": bootClasspathFlag,
+			"classpath":     classpathFlag,
+			"outDir":        outDir.String(),
+			"javaVersion":   flags.javaVersion,
+		},
+	})
+
+	return outputFile
+}
+
+// RunErrorProne compiles the sources a second time with the error-prone compiler into a
+// separate directory so that the normal class files are not affected by RUN_ERROR_PRONE.
+func RunErrorProne(ctx android.ModuleContext, srcFiles android.Paths, srcFileLists android.Paths,
+	flags javaBuilderFlags, deps android.Paths) android.Path {
+
+	classDir := android.PathForModuleOut(ctx, "classes-errorprone")
+	annoDir := android.PathForModuleOut(ctx, "anno-errorprone")
+	classFileList := android.PathForModuleOut(ctx, "classes-errorprone.list")
+
+	javacFlags := flags.javacFlags
+	if len(srcFileLists) > 0 {
+		javacFlags += " " + android.JoinWithPrefix(srcFileLists.Strings(), "@")
+	}
+
+	deps = append(deps, srcFileLists...)
+
+	ctx.ModuleBuild(pctx, android.ModuleBuildParams{
+		Rule:        errorprone,
+		Description: "errorprone",
+		Output:      classFileList,
+		Inputs:      srcFiles,
+		Implicits:   deps,
+		Args: map[string]string{
+			"javacFlags":    javacFlags,
+			"bootClasspath": flags.bootClasspath,
+			"classpath":     flags.classpath,
+			"processorPath": flags.processorPath,
+			"outDir":        classDir.String(),
+			"annoDir":       annoDir.String(),
+			"javaVersion":   flags.javaVersion,
+		},
+	})
+
+	return classFileList
+}
+
 func TransformClassesToJar(ctx android.ModuleContext, classes []jarSpec,
 	manifest android.OptionalPath) android.Path {
 
-	outputFile := android.PathForModuleOut(ctx, "classes-full-debug.jar")
+	return transformClassesToJar(ctx, "classes-full-debug.jar", classes, manifest)
+}
+
+func transformClassesToJar(ctx android.ModuleContext, jarName string, classes []jarSpec,
+	manifest android.OptionalPath) android.Path {
+
+	outputFile := android.PathForModuleOut(ctx, jarName)
 
 	deps := android.Paths{}
 	jarArgs := []string{}
@@ -178,19 +397,107 @@ func TransformClassesToJar(ctx android.ModuleContext, classes []jarSpec,
 	return outputFile
 }
 
+// TransformSourcesToStubs generates the stub sources and API files for a library with doclava.
+// It returns a file containing the list of generated stub sources.
+func TransformSourcesToStubs(ctx android.ModuleContext, scope string, srcFiles android.Paths,
+	srcFileLists android.Paths, flags javaBuilderFlags, deps android.Paths, stubPackages []string,
+	doclavaFlags []string, apiFile, removedApiFile android.WritablePath) android.Path {
+
+	outDir := android.PathForModuleOut(ctx, "doclava-"+scope)
+	stubsDir := android.PathForModuleOut(ctx, "stubs-"+scope)
+	stubsList := android.PathForModuleOut(ctx, "stubs-"+scope+".list")
+
+	deps = append(deps, srcFileLists...)
+
+	ctx.ModuleBuild(pctx, android.ModuleBuildParams{
+		Rule:            doclavaStubs,
+		Description:     "doclava " + scope + " stubs",
+		Output:          stubsList,
+		ImplicitOutputs: android.WritablePaths{apiFile, removedApiFile},
+		Inputs:          srcFiles,
+		Implicits:       deps,
+		Args: map[string]string{
+			"bootClasspath":  flags.bootClasspath,
+			"classpath":      flags.classpath,
+			"srcFileLists":   android.JoinWithPrefix(srcFileLists.Strings(), "@"),
+			"outDir":         outDir.String(),
+			"stubsDir":       stubsDir.String(),
+			"stubPackages":   strings.Join(stubPackages, ":"),
+			"apiFile":        apiFile.String(),
+			"removedApiFile": removedApiFile.String(),
+			"doclavaFlags":   strings.Join(doclavaFlags, " "),
+			"javaVersion":    flags.javaVersion,
+		},
+	})
+
+	return stubsList
+}
+
+// CheckApi verifies that the generated API files match the checked in API files, returning a
+// timestamp file that can be added to checkbuild.
+func CheckApi(ctx android.ModuleContext, scope string, currentApiFile, apiFile,
+	currentRemovedApiFile, removedApiFile android.Path) android.Path {
+
+	timestamp := android.PathForModuleOut(ctx, "check-"+scope+"-api.timestamp")
+
+	// The message is printed with echo -e, the newlines must be escaped to keep the ninja
+	// variable on a single line.
+	msg := `\n******************************\n` +
+		`You have tried to change the ` + scope + ` API of ` + ctx.ModuleName() + `.\n` +
+		`To make the change, copy ` + apiFile.String() + ` to ` + currentApiFile.String() +
+		` and ` + removedApiFile.String() + ` to ` + currentRemovedApiFile.String() + `.\n` +
+		`******************************\n`
+
+	ctx.ModuleBuild(pctx, android.ModuleBuildParams{
+		Rule:        apiCheck,
+		Description: "check " + scope + " api",
+		Output:      timestamp,
+		Inputs:      android.Paths{currentApiFile, apiFile, currentRemovedApiFile, removedApiFile},
+		Args: map[string]string{
+			"msg": msg,
+		},
+	})
+
+	return timestamp
+}
+
 func TransformClassesJarToDex(ctx android.ModuleContext, classesJar android.Path,
 	flags javaBuilderFlags) jarSpec {
 
 	outDir := android.PathForModuleOut(ctx, "dex")
 	outputFile := android.PathForModuleOut(ctx, "dex.filelist")
 
+	if !ctx.AConfig().UseD8() {
+		ctx.ModuleBuild(pctx, android.ModuleBuildParams{
+			Rule:        dx,
+			Description: "dx",
+			Output:      outputFile,
+			Input:       classesJar,
+			Args: map[string]string{
+				"dxFlags": flags.dxFlags,
+				"outDir":  outDir.String(),
+			},
+		})
+
+		return jarSpec{outputFile}
+	}
+
+	d8Flags := d8FlagsForDxFlags(strings.Fields(flags.dxFlags))
+	for _, lib := range flags.dexBootClasspath {
+		d8Flags = append(d8Flags, "--lib "+lib.String())
+	}
+	for _, lib := range flags.dexClasspath {
+		d8Flags = append(d8Flags, "--classpath "+lib.String())
+	}
+
 	ctx.ModuleBuild(pctx, android.ModuleBuildParams{
-		Rule:        dx,
-		Description: "dx",
+		Rule:        d8,
+		Description: "d8",
 		Output:      outputFile,
 		Input:       classesJar,
+		Implicits:   append(append(android.Paths(nil), flags.dexBootClasspath...), flags.dexClasspath...),
 		Args: map[string]string{
-			"dxFlags": flags.dxFlags,
+			"d8Flags": strings.Join(d8Flags, " "),
 			"outDir":  outDir.String(),
 		},
 	})
@@ -198,6 +505,27 @@ func TransformClassesJarToDex(ctx android.ModuleContext, classesJar android.Path
 	return jarSpec{outputFile}
 }
 
+// d8FlagsForDxFlags converts dx flags to the equivalent d8 flags.  d8 always generates multidex
+// output when the classes don't fit in a single dex file, and dx flags that d8 has no equivalent
+// for, like the dx debugging output, are dropped.
+func d8FlagsForDxFlags(dxFlags []string) []string {
+	var d8Flags []string
+	for _, flag := range dxFlags {
+		switch {
+		case flag == "--no-optimize":
+			d8Flags = append(d8Flags, "--debug")
+		case strings.HasPrefix(flag, "--min-sdk-version="):
+			d8Flags = append(d8Flags, "--min-api "+strings.TrimPrefix(flag, "--min-sdk-version="))
+		case flag == "--dex", flag == "--multi-dex", flag == "--core-library", flag == "--no-locals",
+			flag == "--verbose", strings.HasPrefix(flag, "--dump-to="),
+			strings.HasPrefix(flag, "--dump-width="):
+		default:
+			d8Flags = append(d8Flags, flag)
+		}
+	}
+	return d8Flags
+}
+
 func TransformDexToJavaLib(ctx android.ModuleContext, resources []jarSpec,
 	dexJarSpec jarSpec) android.Path {
 
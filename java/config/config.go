@@ -27,6 +27,16 @@ var (
 	pctx = android.NewPackageContext("android/soong/java/config")
 
 	DefaultLibraries = []string{"core-oj", "core-libart", "ext", "framework", "okhttp"}
+
+	// DefaultSystemModules is the java_system_modules module that device modules compiled for
+	// java 9 against the platform (without an sdk_version) are compiled against.
+	DefaultSystemModules = "core-system-modules"
+
+	// The stubs of the Lineage SDK and the resource package of the Lineage platform, which
+	// modules with sdk_version: "lineage_current" are compiled against in addition to the
+	// current Android SDK.
+	LineageSdkStubs      = "org.lineageos.platform.stubs"
+	LineageSdkResPackage = "org.lineageos.platform-res"
 )
 
 func init() {
@@ -44,19 +54,51 @@ func init() {
 
 	pctx.VariableConfigMethod("hostPrebuiltTag", android.Config.PrebuiltOS)
 
-	pctx.SourcePathVariableWithEnvOverride("JavaHome",
-		"prebuilts/jdk/jdk8/${hostPrebuiltTag}", "OVERRIDE_ANDROID_JAVA_HOME")
+	pctx.SourcePathVariable("Jdk8Home", "prebuilts/jdk/jdk8/${hostPrebuiltTag}")
+	pctx.SourcePathVariable("Jdk9Home", "prebuilts/jdk/jdk9/${hostPrebuiltTag}")
+	pctx.VariableFunc("JavaHome", func(config interface{}) (string, error) {
+		if override := config.(android.Config).Getenv("OVERRIDE_ANDROID_JAVA_HOME"); override != "" {
+			return override, nil
+		}
+		if config.(android.Config).UseOpenJDK9() {
+			return "${Jdk9Home}", nil
+		}
+		return "${Jdk8Home}", nil
+	})
 	pctx.SourcePathVariable("JavaToolchain", "${JavaHome}/bin")
 	pctx.SourcePathVariableWithEnvOverride("JavacCmd",
 		"${JavaToolchain}/javac", "ALTERNATE_JAVAC")
 	pctx.SourcePathVariable("JavaCmd", "${JavaToolchain}/java")
 	pctx.SourcePathVariable("JarCmd", "${JavaToolchain}/jar")
 	pctx.SourcePathVariable("JavadocCmd", "${JavaToolchain}/javadoc")
+	pctx.SourcePathVariable("JmodCmd", "${JavaToolchain}/jmod")
+	pctx.SourcePathVariable("JlinkCmd", "${JavaToolchain}/jlink")
+	pctx.SourcePathVariable("JrtFsJar", "${JavaHome}/lib/jrt-fs.jar")
 
 	pctx.StaticVariable("Zip2ZipCmd", filepath.Join("${bootstrap.ToolDir}", "zip2zip"))
+	pctx.StaticVariable("SoongZipCmd", filepath.Join("${bootstrap.ToolDir}", "soong_zip"))
 	pctx.SourcePathVariable("JarArgsCmd", "build/soong/scripts/jar-args.sh")
+	pctx.SourcePathVariable("JarsToModuleInfoCmd", "build/soong/scripts/jars-to-module-info-java.sh")
 	pctx.HostBinToolVariable("DxCmd", "dx")
+	pctx.HostBinToolVariable("D8Cmd", "d8")
 	pctx.HostJavaToolVariable("JarjarCmd", "jarjar.jar")
+	pctx.HostJavaToolVariable("TurbineJar", "turbine.jar")
+	pctx.HostJavaToolVariable("DoclavaJar", "doclava.jar")
+	pctx.HostJavaToolVariable("JsilverJar", "jsilver.jar")
+	pctx.StaticVariable("DoclavaJars", "${JsilverJar}:${DoclavaJar}")
+	pctx.HostBinToolVariable("ApiCheckCmd", "apicheck")
+	pctx.SourcePathVariable("LintCmd", "prebuilts/devtools/tools/lint")
+	pctx.HostJavaToolVariable("JacocoCLIJar", "jacoco-cli.jar")
+	pctx.HostJavaToolVariable("MetalavaJar", "metalava.jar")
+
+	// apicheck errors that are fatal when comparing against the current API, matching the
+	// checks done for the platform API
+	pctx.StaticVariable("ApiCheckCurrentFlags", strings.Join([]string{
+		"-error 2", "-error 3", "-error 4", "-error 5", "-error 6", "-error 7", "-error 8",
+		"-error 9", "-error 10", "-error 11", "-error 12", "-error 13", "-error 14", "-error 15",
+		"-error 16", "-error 17", "-error 18", "-error 19", "-error 20", "-error 21", "-error 23",
+		"-error 24", "-error 25", "-error 26", "-error 27",
+	}, " "))
 
 	pctx.VariableFunc("JavacWrapper", func(config interface{}) (string, error) {
 		if override := config.(android.Config).Getenv("JAVAC_WRAPPER"); override != "" {
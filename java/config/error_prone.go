@@ -0,0 +1,48 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"strings"
+)
+
+var (
+	// Flags passed to every error-prone compile, set RUN_ERROR_PRONE=true to enable
+	ErrorProneFlags = []string{
+		"-XDcompilePolicy=simple",
+		"-XepDisableWarningsInGeneratedCode",
+		"-Xep:MissingOverride:OFF",
+	}
+)
+
+func init() {
+	pctx.SourcePathVariable("ErrorProneJavacJar", "external/error_prone/javac/javac.jar")
+	pctx.SourcePathVariable("ErrorProneJar", "external/error_prone/error_prone/error_prone_core.jar")
+
+	pctx.StaticVariable("ErrorProneFlags", strings.Join(ErrorProneFlags, " "))
+
+	pctx.StaticVariable("ErrorProneCmd", strings.Join([]string{
+		"${JavaCmd}",
+		"-Xmx2048M",
+		"-Xbootclasspath/p:${ErrorProneJavacJar}",
+		"-cp ${ErrorProneJar}",
+		"com.google.errorprone.ErrorProneCompiler",
+		"-Xmaxerrs 9999999",
+		"-encoding UTF-8",
+		`-sourcepath ""`,
+		"-g",
+		"${ErrorProneFlags}",
+	}, " "))
+}
@@ -0,0 +1,129 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package java
+
+// This file records the CRCs of the dex files of apps, so that the dexpreopt files of the apps
+// can be verified, and regenerated if necessary, after the target files are re-signed with
+// release keys.  See scripts/dexpreopt_post_sign.py.
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/google/blueprint"
+	"github.com/google/blueprint/proptools"
+
+	"android/soong/android"
+)
+
+func init() {
+	android.RegisterSingletonType("dexpreopt_post_sign", DexpreoptPostSignSingleton)
+
+	pctx.SourcePathVariable("dexpreoptPostSignCmd", "build/soong/scripts/dexpreopt_post_sign.py")
+	pctx.IntermediatesPathVariable("dexpreoptDexCrcs", "dexpreopt_dex_crcs.txt")
+
+	android.RegisterMakeVarsProvider(pctx, dexpreoptMakeVarsProvider)
+}
+
+var (
+	recordDexCrcs = pctx.AndroidStaticRule("recordDexCrcs",
+		blueprint.RuleParams{
+			Command:     `$dexpreoptPostSignCmd record --device-path $devicePath $in $out`,
+			CommandDeps: []string{"$dexpreoptPostSignCmd"},
+		},
+		"devicePath")
+
+	mergeDexCrcs = pctx.AndroidStaticRule("mergeDexCrcs",
+		blueprint.RuleParams{
+			Command: `cat /dev/null $in | sort > $out`,
+		})
+)
+
+type DexpreoptProperties struct {
+	Dex_preopt struct {
+		// if set to false, the dexpreopt files of the module are not verified or regenerated
+		// when the target files are re-signed.  Defaults to true.
+		Enabled *bool
+	}
+}
+
+// dexCrcsProducer is implemented by modules that record the CRCs of the dex files of their
+// installed apk.
+type dexCrcsProducer interface {
+	dexCrcs() android.Path
+}
+
+// recordDexCrcsForApk writes the CRCs of the dex entries of the installed apk, which the odex files
+// generated for the apk refer to.
+func recordDexCrcsForApk(ctx android.ModuleContext, apk android.Path,
+	installPath android.OutputPath) android.Path {
+
+	devicePath := devicePathForInstall(ctx, installPath)
+
+	dexCrcs := android.PathForModuleOut(ctx, "dexpreopt", "dex_crcs.txt")
+	ctx.ModuleBuild(pctx, android.ModuleBuildParams{
+		Rule:        recordDexCrcs,
+		Description: "record dex crcs",
+		Output:      dexCrcs,
+		Input:       apk,
+		Args: map[string]string{
+			"devicePath": devicePath,
+		},
+	})
+
+	return dexCrcs
+}
+
+// devicePathForInstall returns the path of an installed file on the device, which is its path
+// relative to the product out directory.
+func devicePathForInstall(ctx android.ModuleContext, installPath android.OutputPath) string {
+	productOut := filepath.Join("target", "product", ctx.AConfig().DeviceName())
+	return strings.TrimPrefix(installPath.RelPathString(), productOut)
+}
+
+func dexpreoptEnabled(p *DexpreoptProperties) bool {
+	return proptools.BoolDefault(p.Dex_preopt.Enabled, true)
+}
+
+func DexpreoptPostSignSingleton() blueprint.Singleton {
+	return &dexpreoptPostSignSingleton{}
+}
+
+type dexpreoptPostSignSingleton struct{}
+
+// GenerateBuildActions merges the dex CRCs of all apps into a single file, which is packaged
+// into the target files for the signing tools.
+func (s *dexpreoptPostSignSingleton) GenerateBuildActions(ctx blueprint.SingletonContext) {
+	var dexCrcs []string
+	ctx.VisitAllModules(func(module blueprint.Module) {
+		if producer, ok := module.(dexCrcsProducer); ok {
+			if crcs := producer.dexCrcs(); crcs != nil {
+				dexCrcs = append(dexCrcs, crcs.String())
+			}
+		}
+	})
+
+	ctx.Build(pctx, blueprint.BuildParams{
+		Rule:        mergeDexCrcs,
+		Description: "merge dex crcs",
+		Outputs:     []string{"$dexpreoptDexCrcs"},
+		Inputs:      dexCrcs,
+	})
+}
+
+func dexpreoptMakeVarsProvider(ctx android.MakeVarsContext) {
+	ctx.Strict("SOONG_DEXPREOPT_DEX_CRCS", "${dexpreoptDexCrcs}")
+	ctx.Strict("SOONG_DEXPREOPT_POST_SIGN", "${dexpreoptPostSignCmd}")
+}
@@ -0,0 +1,317 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package java
+
+// This file contains the javadoc and droiddoc module types, which generate documentation for
+// java sources.  javadoc runs the standard doclet, droiddoc runs doclava, which can also generate
+// stub sources and the API file of the sources.
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/google/blueprint"
+	"github.com/google/blueprint/proptools"
+
+	"android/soong/android"
+	"android/soong/java/config"
+)
+
+func init() {
+	android.RegisterModuleType("javadoc", JavadocFactory)
+	android.RegisterModuleType("droiddoc", DroiddocFactory)
+}
+
+var (
+	javadoc = pctx.AndroidStaticRule("javadoc",
+		blueprint.RuleParams{
+			Command: `rm -rf "$outDir" && mkdir -p "$outDir" && ` +
+				`${config.JavadocCmd} -encoding UTF-8 -source $javaVersion -J-Xmx1600m ` +
+				`-XDignore.symbol.file -Xdoclint:none $bootClasspath $classpath @$out.rsp ` +
+				`-d $outDir -quiet $opts && ` +
+				`find $outDir -type f | sort > $out.list && ` +
+				`${config.SoongZipCmd} -o $out -C $outDir -l $out.list && rm $out.list`,
+			CommandDeps: []string{
+				"${config.JavadocCmd}",
+				"${config.SoongZipCmd}",
+			},
+			Rspfile:        "$out.rsp",
+			RspfileContent: "$in",
+		},
+		"bootClasspath", "classpath", "outDir", "opts", "javaVersion")
+
+	// droiddoc runs doclava over the sources, writing the docs to $out and the stub sources to
+	// $stubsSrcJar.
+	droiddoc = pctx.AndroidStaticRule("droiddoc",
+		blueprint.RuleParams{
+			Command: `rm -rf "$outDir" "$stubsDir" && mkdir -p "$outDir" "$stubsDir" && ` +
+				`${config.JavadocCmd} -encoding UTF-8 -source $javaVersion -J-Xmx1600m ` +
+				`-XDignore.symbol.file $bootClasspath $classpath @$out.rsp ` +
+				`-d $outDir -quiet -doclet com.google.doclava.Doclava ` +
+				`-docletpath ${config.DoclavaJars} $opts -stubs $stubsDir && ` +
+				`find $outDir -type f | sort > $out.list && ` +
+				`${config.SoongZipCmd} -o $out -C $outDir -l $out.list && ` +
+				`find $stubsDir -name "*.java" | sort > $out.list && ` +
+				`${config.SoongZipCmd} -o $stubsSrcJar -C $stubsDir -l $out.list && rm $out.list`,
+			CommandDeps: []string{
+				"${config.JavadocCmd}",
+				"${config.DoclavaJar}",
+				"${config.JsilverJar}",
+				"${config.SoongZipCmd}",
+			},
+			Rspfile:        "$out.rsp",
+			RspfileContent: "$in",
+		},
+		"bootClasspath", "classpath", "outDir", "stubsDir", "stubsSrcJar", "opts", "javaVersion")
+)
+
+type JavadocProperties struct {
+	// list of source files to document.  May be .java files or references to modules that
+	// generate .java files, for example ":framework-gen".
+	Srcs []string `android:"arch_variant"`
+
+	// list of source files that should not be documented
+	Exclude_srcs []string `android:"arch_variant"`
+
+	// list of java libraries that will be in the classpath
+	Libs []string `android:"arch_variant"`
+
+	// don't build against the default libraries (legacy-test, core-junit, ext, and framework)
+	No_standard_libraries *bool
+
+	// if not blank, set to the version of the sdk to document against
+	Sdk_version *string
+
+	// extra arguments passed to javadoc, or to doclava for droiddoc modules
+	Args *string
+}
+
+type Javadoc struct {
+	android.ModuleBase
+
+	properties JavadocProperties
+
+	srcFiles      android.Paths
+	bootClasspath android.Paths
+	classpath     android.Paths
+
+	docZip android.WritablePath
+}
+
+func (j *Javadoc) DepsMutator(ctx android.BottomUpMutatorContext) {
+	android.ExtractSourcesDeps(ctx, j.properties.Srcs)
+
+	if !proptools.Bool(j.properties.No_standard_libraries) {
+		sdkVersion := proptools.String(j.properties.Sdk_version)
+		switch sdkVersion {
+		case "":
+			ctx.AddDependency(ctx.Module(), bootClasspathTag, "core-libart")
+			ctx.AddDependency(ctx.Module(), libTag, config.DefaultLibraries...)
+		case "current":
+			ctx.AddDependency(ctx.Module(), bootClasspathTag, "android_stubs_current")
+		case "system_current":
+			ctx.AddDependency(ctx.Module(), bootClasspathTag, "android_system_stubs_current")
+		case "lineage_current":
+			ctx.AddDependency(ctx.Module(), bootClasspathTag, "android_stubs_current")
+			ctx.AddDependency(ctx.Module(), libTag, config.LineageSdkStubs)
+		default:
+			ctx.AddDependency(ctx.Module(), sdkDependencyTag, "sdk_v"+sdkVersion)
+		}
+	}
+	ctx.AddDependency(ctx.Module(), libTag, j.properties.Libs...)
+}
+
+// collectDeps sets the sources and the classpaths that are passed to javadoc.
+func (j *Javadoc) collectDeps(ctx android.ModuleContext) {
+	ctx.VisitDirectDeps(func(module blueprint.Module) {
+		otherName := ctx.OtherModuleName(module)
+		tag := ctx.OtherModuleDependencyTag(module)
+		if tag == android.SourceDepTag {
+			return
+		}
+
+		dep, ok := module.(Dependency)
+		if !ok {
+			ctx.ModuleErrorf("depends on non-java module %q", otherName)
+			return
+		}
+
+		switch tag {
+		case bootClasspathTag, sdkDependencyTag:
+			j.bootClasspath = append(j.bootClasspath, dep.HeaderJars()...)
+		case libTag:
+			if sdkLib, ok := module.(sdkLibraryDependency); ok && j.properties.Sdk_version != nil {
+				j.classpath = append(j.classpath, sdkLib.SdkHeaderJars(*j.properties.Sdk_version)...)
+			} else {
+				j.classpath = append(j.classpath, dep.HeaderJars()...)
+			}
+		default:
+			panic(fmt.Errorf("unknown dependency %q for %q", otherName, ctx.ModuleName()))
+		}
+	})
+
+	for _, src := range ctx.ExpandSources(j.properties.Srcs, j.properties.Exclude_srcs) {
+		if src.Ext() != ".java" {
+			ctx.PropertyErrorf("srcs", "%q is not a .java file", src.String())
+			continue
+		}
+		j.srcFiles = append(j.srcFiles, src)
+	}
+}
+
+func (j *Javadoc) args() map[string]string {
+	args := map[string]string{
+		"javaVersion": "${config.DefaultJavaVersion}",
+	}
+	if len(j.bootClasspath) > 0 {
+		args["bootClasspath"] = "-bootclasspath " + strings.Join(j.bootClasspath.Strings(), ":")
+	}
+	if len(j.classpath) > 0 {
+		args["classpath"] = "-classpath " + strings.Join(j.classpath.Strings(), ":")
+	}
+	return args
+}
+
+func (j *Javadoc) GenerateAndroidBuildActions(ctx android.ModuleContext) {
+	j.collectDeps(ctx)
+	if ctx.Failed() {
+		return
+	}
+
+	j.docZip = android.PathForModuleOut(ctx, ctx.ModuleName()+"-docs.zip")
+
+	args := j.args()
+	args["outDir"] = android.PathForModuleOut(ctx, "docs").String()
+	args["opts"] = proptools.String(j.properties.Args)
+
+	ctx.ModuleBuild(pctx, android.ModuleBuildParams{
+		Rule:        javadoc,
+		Description: "javadoc",
+		Output:      j.docZip,
+		Inputs:      j.srcFiles,
+		Implicits:   append(append(android.Paths(nil), j.bootClasspath...), j.classpath...),
+		Args:        args,
+	})
+}
+
+func (j *Javadoc) AndroidMk() android.AndroidMkData {
+	return android.AndroidMkData{
+		Class:      "JAVA_LIBRARIES",
+		OutputFile: android.OptionalPathForPath(j.docZip),
+		Extra: []android.AndroidMkExtraFunc{
+			func(w io.Writer, outputFile android.Path) {
+				fmt.Fprintln(w, "LOCAL_UNINSTALLABLE_MODULE := true")
+				fmt.Fprintln(w, "LOCAL_DROIDDOC_DOC_ZIP :=", j.docZip.String())
+			},
+		},
+	}
+}
+
+func JavadocFactory() android.Module {
+	module := &Javadoc{}
+
+	module.AddProperties(&module.properties)
+
+	android.InitAndroidArchModule(module, android.DeviceSupported, android.MultilibCommon)
+	return module
+}
+
+type DroiddocProperties struct {
+	// directory of doclava templates, relative to the module directory, that override the
+	// templates bundled with doclava
+	Custom_template *string
+
+	// if set, doclava writes the API of the sources to a file with this name, which is passed
+	// to Make as LOCAL_DROIDDOC_API_FILE
+	Api_filename *string
+}
+
+type Droiddoc struct {
+	Javadoc
+
+	droiddocProperties DroiddocProperties
+
+	stubsSrcJar android.WritablePath
+	apiFile     android.WritablePath
+}
+
+func (d *Droiddoc) GenerateAndroidBuildActions(ctx android.ModuleContext) {
+	d.collectDeps(ctx)
+	if ctx.Failed() {
+		return
+	}
+
+	d.docZip = android.PathForModuleOut(ctx, ctx.ModuleName()+"-docs.zip")
+	d.stubsSrcJar = android.PathForModuleOut(ctx, ctx.ModuleName()+"-stubs.srcjar")
+
+	implicits := append(append(android.Paths(nil), d.bootClasspath...), d.classpath...)
+	implicitOutputs := android.WritablePaths{d.stubsSrcJar}
+
+	var opts []string
+	if d.droiddocProperties.Custom_template != nil {
+		templateDir := android.PathForModuleSrc(ctx, *d.droiddocProperties.Custom_template)
+		opts = append(opts, "-templatedir "+templateDir.String())
+		implicits = append(implicits, ctx.Glob(templateDir.Join(ctx, "**/*").String(), nil)...)
+	}
+
+	if d.droiddocProperties.Api_filename != nil {
+		d.apiFile = android.PathForModuleOut(ctx, *d.droiddocProperties.Api_filename)
+		opts = append(opts, "-api "+d.apiFile.String())
+		implicitOutputs = append(implicitOutputs, d.apiFile)
+	}
+
+	if d.properties.Args != nil {
+		opts = append(opts, *d.properties.Args)
+	}
+
+	args := d.args()
+	args["outDir"] = android.PathForModuleOut(ctx, "docs").String()
+	args["stubsDir"] = android.PathForModuleOut(ctx, "stubs").String()
+	args["stubsSrcJar"] = d.stubsSrcJar.String()
+	args["opts"] = strings.Join(opts, " ")
+
+	ctx.ModuleBuild(pctx, android.ModuleBuildParams{
+		Rule:            droiddoc,
+		Description:     "droiddoc",
+		Output:          d.docZip,
+		ImplicitOutputs: implicitOutputs,
+		Inputs:          d.srcFiles,
+		Implicits:       implicits,
+		Args:            args,
+	})
+}
+
+func (d *Droiddoc) AndroidMk() android.AndroidMkData {
+	data := d.Javadoc.AndroidMk()
+	data.Extra = append(data.Extra, func(w io.Writer, outputFile android.Path) {
+		fmt.Fprintln(w, "LOCAL_DROIDDOC_STUBS_SRCJAR :=", d.stubsSrcJar.String())
+		if d.apiFile != nil {
+			fmt.Fprintln(w, "LOCAL_DROIDDOC_API_FILE :=", d.apiFile.String())
+		}
+	})
+	return data
+}
+
+func DroiddocFactory() android.Module {
+	module := &Droiddoc{}
+
+	module.AddProperties(
+		&module.properties,
+		&module.droiddocProperties)
+
+	android.InitAndroidArchModule(module, android.DeviceSupported, android.MultilibCommon)
+	return module
+}
@@ -0,0 +1,260 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package java
+
+// This file contains the droidstubs module type, which runs metalava over java sources to
+// generate stub sources and the API signature files of the sources.  The signature files are
+// checked against the checked in API files, and metalava checks the API for compatibility with
+// the checked in API and for API lint issues.
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/google/blueprint"
+	"github.com/google/blueprint/proptools"
+
+	"android/soong/android"
+)
+
+func init() {
+	android.RegisterModuleType("droidstubs", DroidstubsFactory)
+}
+
+var (
+	metalava = pctx.AndroidStaticRule("metalava",
+		blueprint.RuleParams{
+			Command: `rm -rf "$stubsDir" && mkdir -p "$stubsDir" && ` +
+				`${config.JavaCmd} -jar ${config.MetalavaJar} -encoding UTF-8 --java-source $javaVersion ` +
+				`--quiet @$out.rsp $classpath --api $apiFile --removed-api $removedApiFile ` +
+				`--stubs $stubsDir $opts && ` +
+				`find $stubsDir -name "*.java" | sort > $out.list && ` +
+				`${config.SoongZipCmd} -o $out -C $stubsDir -l $out.list && rm $out.list`,
+			CommandDeps: []string{
+				"${config.MetalavaJar}",
+				"${config.SoongZipCmd}",
+			},
+			Rspfile:        "$out.rsp",
+			RspfileContent: "$in",
+		},
+		"classpath", "stubsDir", "apiFile", "removedApiFile", "opts", "javaVersion")
+
+	// checkApiFiles compares the generated API files against the checked in ones, in the order
+	// current api, generated api, current removed api, generated removed api.
+	checkApiFiles = pctx.AndroidStaticRule("checkApiFiles",
+		blueprint.RuleParams{
+			Command: `( diff -u $currentApiFile $apiFile && ` +
+				`diff -u $currentRemovedApiFile $removedApiFile && touch $out ) || ` +
+				`( echo -e "$msg" ; exit 38 )`,
+		},
+		"currentApiFile", "apiFile", "currentRemovedApiFile", "removedApiFile", "msg")
+
+	// updateApiFiles copies the generated API files over the checked in ones.
+	updateApiFiles = pctx.AndroidStaticRule("updateApiFiles",
+		blueprint.RuleParams{
+			Command: `cp -f $apiFile $currentApiFile && ` +
+				`cp -f $removedApiFile $currentRemovedApiFile && touch $out`,
+		},
+		"currentApiFile", "apiFile", "currentRemovedApiFile", "removedApiFile")
+)
+
+type DroidstubsProperties struct {
+	Check_api struct {
+		// the checked in API files, relative to the module directory, that the generated API
+		// files must match and that the API must be compatible with
+		Current struct {
+			Api_file         *string
+			Removed_api_file *string
+		}
+
+		// file of known API lint and compatibility issues that metalava doesn't report,
+		// relative to the module directory
+		Baseline_file *string
+
+		// if true, metalava reports API lint issues in the API of the sources
+		Api_lint *bool
+	}
+}
+
+type Droidstubs struct {
+	Javadoc
+
+	droidstubsProperties DroidstubsProperties
+
+	stubsSrcJar    android.WritablePath
+	apiFile        android.WritablePath
+	removedApiFile android.WritablePath
+
+	checkCurrentApiTimestamp  android.WritablePath
+	updateCurrentApiTimestamp android.WritablePath
+}
+
+func (d *Droidstubs) PropertyConstraints() []android.PropertyConstraint {
+	return []android.PropertyConstraint{
+		android.RequiredIf("check_api.current.removed_api_file", "check_api.current.api_file"),
+		android.RequiredIf("check_api.current.api_file", "check_api.current.removed_api_file"),
+	}
+}
+
+func (d *Droidstubs) GenerateAndroidBuildActions(ctx android.ModuleContext) {
+	d.collectDeps(ctx)
+	if ctx.Failed() {
+		return
+	}
+
+	checkApi := &d.droidstubsProperties.Check_api
+
+	d.stubsSrcJar = android.PathForModuleOut(ctx, ctx.ModuleName()+"-stubs.srcjar")
+	d.apiFile = android.PathForModuleOut(ctx, ctx.ModuleName()+"_api.txt")
+	d.removedApiFile = android.PathForModuleOut(ctx, ctx.ModuleName()+"_removed.txt")
+
+	classpath := append(append(android.Paths(nil), d.bootClasspath...), d.classpath...)
+	implicits := append(android.Paths(nil), classpath...)
+
+	var opts []string
+	var currentApiFile, currentRemovedApiFile android.Path
+	if checkApi.Current.Api_file != nil {
+		currentApiFile = android.PathForModuleSrc(ctx, *checkApi.Current.Api_file)
+		currentRemovedApiFile = android.PathForModuleSrc(ctx, *checkApi.Current.Removed_api_file)
+		opts = append(opts,
+			"--check-compatibility:api:current "+currentApiFile.String(),
+			"--check-compatibility:removed:current "+currentRemovedApiFile.String())
+		implicits = append(implicits, currentApiFile, currentRemovedApiFile)
+	}
+	if proptools.Bool(checkApi.Api_lint) {
+		opts = append(opts, "--api-lint")
+	}
+	if checkApi.Baseline_file != nil {
+		baseline := android.PathForModuleSrc(ctx, *checkApi.Baseline_file)
+		opts = append(opts, "--baseline "+baseline.String())
+		implicits = append(implicits, baseline)
+	}
+	if d.properties.Args != nil {
+		opts = append(opts, *d.properties.Args)
+	}
+
+	var classpathFlag string
+	if len(classpath) > 0 {
+		classpathFlag = "--classpath " + strings.Join(classpath.Strings(), ":")
+	}
+
+	ctx.ModuleBuild(pctx, android.ModuleBuildParams{
+		Rule:            metalava,
+		Description:     "metalava",
+		Output:          d.stubsSrcJar,
+		ImplicitOutputs: android.WritablePaths{d.apiFile, d.removedApiFile},
+		Inputs:          d.srcFiles,
+		Implicits:       implicits,
+		Args: map[string]string{
+			"classpath":      classpathFlag,
+			"stubsDir":       android.PathForModuleOut(ctx, "stubs").String(),
+			"apiFile":        d.apiFile.String(),
+			"removedApiFile": d.removedApiFile.String(),
+			"opts":           strings.Join(opts, " "),
+			"javaVersion":    "${config.DefaultJavaVersion}",
+		},
+	})
+
+	if currentApiFile != nil {
+		d.checkCurrentApi(ctx, currentApiFile, currentRemovedApiFile)
+	}
+}
+
+// checkCurrentApi verifies that the generated API files match the checked in ones, and adds a
+// rule that updates the checked in API files.
+func (d *Droidstubs) checkCurrentApi(ctx android.ModuleContext,
+	currentApiFile, currentRemovedApiFile android.Path) {
+
+	args := map[string]string{
+		"currentApiFile":        currentApiFile.String(),
+		"apiFile":               d.apiFile.String(),
+		"currentRemovedApiFile": currentRemovedApiFile.String(),
+		"removedApiFile":        d.removedApiFile.String(),
+	}
+
+	d.updateCurrentApiTimestamp = android.PathForModuleOut(ctx, "update_current_api.timestamp")
+	ctx.ModuleBuild(pctx, android.ModuleBuildParams{
+		Rule:        updateApiFiles,
+		Description: "update current api",
+		Output:      d.updateCurrentApiTimestamp,
+		Implicits:   android.Paths{d.apiFile, d.removedApiFile},
+		Args:        args,
+	})
+
+	// The message is printed with echo -e, the newlines must be escaped to keep the ninja
+	// variable on a single line.
+	checkArgs := map[string]string{
+		"msg": `\n******************************\n` +
+			`You have tried to change the API of ` + ctx.ModuleName() + ` from what has been ` +
+			`previously approved.\n\n` +
+			`To make these errors go away, you have two choices:\n` +
+			`   1) You can add @hide javadoc comments to the methods, etc. listed in the ` +
+			`errors above.\n\n` +
+			`   2) You can update ` + currentApiFile.Base() + ` by executing the following command:\n` +
+			`         make ` + ctx.ModuleName() + `-update-current-api\n\n` +
+			`      To submit the revised ` + currentApiFile.Base() + ` to the main Android ` +
+			`repository, you will need approval.\n` +
+			`******************************\n`,
+	}
+	for k, v := range args {
+		checkArgs[k] = v
+	}
+
+	d.checkCurrentApiTimestamp = android.PathForModuleOut(ctx, "check_current_api.timestamp")
+	ctx.ModuleBuild(pctx, android.ModuleBuildParams{
+		Rule:        checkApiFiles,
+		Description: "check current api",
+		Output:      d.checkCurrentApiTimestamp,
+		Implicits:   android.Paths{currentApiFile, d.apiFile, currentRemovedApiFile, d.removedApiFile},
+		Args:        checkArgs,
+	})
+	ctx.CheckbuildFile(d.checkCurrentApiTimestamp)
+}
+
+func (d *Droidstubs) AndroidMk() android.AndroidMkData {
+	return android.AndroidMkData{
+		Class:      "JAVA_LIBRARIES",
+		OutputFile: android.OptionalPathForPath(d.stubsSrcJar),
+		Extra: []android.AndroidMkExtraFunc{
+			func(w io.Writer, outputFile android.Path) {
+				fmt.Fprintln(w, "LOCAL_UNINSTALLABLE_MODULE := true")
+				fmt.Fprintln(w, "LOCAL_DROIDDOC_STUBS_SRCJAR :=", d.stubsSrcJar.String())
+				fmt.Fprintln(w, "LOCAL_DROIDDOC_API_FILE :=", d.apiFile.String())
+				fmt.Fprintln(w, "LOCAL_DROIDDOC_REMOVED_API_FILE :=", d.removedApiFile.String())
+				if d.checkCurrentApiTimestamp != nil {
+					name := d.Name()
+					fmt.Fprintln(w, ".PHONY:", name+"-check-current-api")
+					fmt.Fprintln(w, name+"-check-current-api:", d.checkCurrentApiTimestamp.String())
+					fmt.Fprintln(w, "checkapi:", name+"-check-current-api")
+					fmt.Fprintln(w, ".PHONY:", name+"-update-current-api")
+					fmt.Fprintln(w, name+"-update-current-api:", d.updateCurrentApiTimestamp.String())
+					fmt.Fprintln(w, "update-api:", name+"-update-current-api")
+				}
+			},
+		},
+	}
+}
+
+func DroidstubsFactory() android.Module {
+	module := &Droidstubs{}
+
+	module.AddProperties(
+		&module.properties,
+		&module.droidstubsProperties)
+
+	android.InitAndroidArchModule(module, android.DeviceSupported, android.MultilibCommon)
+	return module
+}
@@ -29,14 +29,18 @@ func init() {
 	pctx.SourcePathVariable("logtagsCmd", "build/tools/java-event-log-tags.py")
 	pctx.SourcePathVariable("mergeLogtagsCmd", "build/tools/merge-event-log-tags.py")
 
-	pctx.IntermediatesPathVariable("allLogtagsFile", "all-event-log-tags.txt")
+	pctx.IntermediatesPathVariable("allLogtagsFile", allLogtagsFileName)
 }
 
+const allLogtagsFileName = "all-event-log-tags.txt"
+
 var (
 	aidl = pctx.AndroidStaticRule("aidl",
 		blueprint.RuleParams{
 			Command:     "$aidlCmd -d$depFile $aidlFlags $in $out",
 			CommandDeps: []string{"$aidlCmd"},
+			Depfile:     "$depFile",
+			Deps:        blueprint.DepsGCC,
 		},
 		"depFile", "aidlFlags")
 
@@ -53,7 +57,7 @@ var (
 		})
 )
 
-func genAidl(ctx android.ModuleContext, aidlFile android.Path, aidlFlags string) android.Path {
+func genAidl(ctx android.ModuleContext, aidlFile android.Path, aidlFlags string, deps android.Paths) android.Path {
 	javaFile := android.GenPathWithExt(ctx, "aidl", aidlFile, "java")
 	depFile := javaFile.String() + ".d"
 
@@ -62,6 +66,7 @@ func genAidl(ctx android.ModuleContext, aidlFile android.Path, aidlFlags string)
 		Description: "aidl " + aidlFile.Rel(),
 		Output:      javaFile,
 		Input:       aidlFile,
+		Implicits:   deps,
 		Args: map[string]string{
 			"depFile":   depFile,
 			"aidlFlags": aidlFlags,
@@ -74,32 +79,43 @@ func genAidl(ctx android.ModuleContext, aidlFile android.Path, aidlFlags string)
 func genLogtags(ctx android.ModuleContext, logtagsFile android.Path) android.Path {
 	javaFile := android.GenPathWithExt(ctx, "logtags", logtagsFile, "java")
 
+	// The tag numbers of tags declared without one are looked up in the merged file of all
+	// logtags, so the generated java has to be updated whenever it changes.
 	ctx.ModuleBuild(pctx, android.ModuleBuildParams{
 		Rule:        logtags,
 		Description: "logtags " + logtagsFile.Rel(),
 		Output:      javaFile,
 		Input:       logtagsFile,
+		Implicits:   android.Paths{android.PathForIntermediates(ctx, allLogtagsFileName)},
 	})
 
 	return javaFile
 }
 
 func (j *Module) genSources(ctx android.ModuleContext, srcFiles android.Paths,
-	flags javaBuilderFlags) android.Paths {
+	flags javaBuilderFlags) (android.Paths, android.Paths) {
+
+	var outSrcFiles android.Paths
+	var srcFileLists android.Paths
 
-	for i, srcFile := range srcFiles {
+	for _, srcFile := range srcFiles {
 		switch srcFile.Ext() {
 		case ".aidl":
-			javaFile := genAidl(ctx, srcFile, flags.aidlFlags)
-			srcFiles[i] = javaFile
+			javaFile := genAidl(ctx, srcFile, flags.aidlFlags, flags.aidlDeps)
+			outSrcFiles = append(outSrcFiles, javaFile)
 		case ".logtags":
 			j.logtagsSrcs = append(j.logtagsSrcs, srcFile)
 			javaFile := genLogtags(ctx, srcFile)
-			srcFiles[i] = javaFile
+			outSrcFiles = append(outSrcFiles, javaFile)
+		case ".proto":
+			srcFileList := genProto(ctx, srcFile, flags)
+			srcFileLists = append(srcFileLists, srcFileList)
+		default:
+			outSrcFiles = append(outSrcFiles, srcFile)
 		}
 	}
 
-	return srcFiles
+	return outSrcFiles, srcFileLists
 }
 
 func LogtagsSingleton() blueprint.Singleton {
@@ -0,0 +1,96 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package java
+
+// This file contains the java_genrule and java_genrule_host module types, genrules that are
+// split into the same variants as java modules so that their outputs can be used by java
+// modules.  Generated .java files can be listed in srcs with ":name", and generated jars can be
+// listed in libs or static_libs.
+
+import (
+	"strconv"
+
+	"android/soong/android"
+	"android/soong/genrule"
+)
+
+func init() {
+	android.RegisterModuleType("java_genrule", GenRuleFactory)
+	android.RegisterModuleType("java_genrule_host", GenRuleHostFactory)
+}
+
+type GenRule struct {
+	*genrule.Module
+
+	classpathFiles   android.Paths
+	classJarSpecs    []jarSpec
+	resourceJarSpecs []jarSpec
+}
+
+var _ Dependency = (*GenRule)(nil)
+
+func (g *GenRule) GenerateAndroidBuildActions(ctx android.ModuleContext) {
+	g.Module.GenerateAndroidBuildActions(ctx)
+	if ctx.Failed() {
+		return
+	}
+
+	for i, out := range g.GeneratedSourceFiles() {
+		if out.Ext() != ".jar" {
+			continue
+		}
+		g.classpathFiles = append(g.classpathFiles, out)
+
+		subdir := "extracted" + strconv.Itoa(i)
+		classJarSpec, resourceJarSpec := TransformPrebuiltJarToClasses(ctx, subdir, out)
+		g.classJarSpecs = append(g.classJarSpecs, classJarSpec)
+		g.resourceJarSpecs = append(g.resourceJarSpecs, resourceJarSpec)
+	}
+}
+
+func (g *GenRule) ClasspathFiles() android.Paths {
+	return g.classpathFiles
+}
+
+func (g *GenRule) HeaderJars() android.Paths {
+	return g.classpathFiles
+}
+
+func (g *GenRule) ClassJarSpecs() []jarSpec {
+	return g.classJarSpecs
+}
+
+func (g *GenRule) ResourceJarSpecs() []jarSpec {
+	return g.resourceJarSpecs
+}
+
+func (g *GenRule) AidlIncludeDirs() android.Paths {
+	return nil
+}
+
+// GenRuleFactory creates a java_genrule, which is built for both the device and the host so that
+// both device and host java modules can use its outputs.
+func GenRuleFactory() android.Module {
+	module := &GenRule{Module: genrule.NewGenRule()}
+	android.InitAndroidArchModule(module, android.HostAndDeviceSupported, android.MultilibCommon)
+	return module
+}
+
+// GenRuleHostFactory creates a java_genrule_host, which is only built for the host.
+func GenRuleHostFactory() android.Module {
+	module := &GenRule{Module: genrule.NewGenRule()}
+	android.InitAndroidArchModule(module, android.HostSupported, android.MultilibCommon)
+	return module
+}
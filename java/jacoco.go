@@ -0,0 +1,117 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package java
+
+// This file instruments the classes of java modules with jacoco for code coverage, when
+// EMMA_INSTRUMENT=true is set in the environment.
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/google/blueprint"
+
+	"android/soong/android"
+)
+
+var (
+	// jacoco instruments the classes in $in that match $includes but not $excludes, and writes
+	// a jar with the instrumented classes and the remaining classes unchanged.  The uninstrumented
+	// classes that were instrumented are written to $reportClassesJar, which is needed to
+	// generate coverage reports from the data collected on the device.
+	jacoco = pctx.AndroidTmpDirStaticRule("jacoco",
+		blueprint.RuleParams{
+			Command: `mkdir -p $tmpDir/classes $tmpDir/filtered && ` +
+				`unzip -qo $in -d $tmpDir/classes && ` +
+				`(unzip -qo $in $includes $excludes -d $tmpDir/filtered || [ $$? -eq 11 ]) && ` +
+				`${config.JarCmd} cf $reportClassesJar -C $tmpDir/filtered . && ` +
+				`${config.JavaCmd} -jar ${config.JacocoCLIJar} instrument --quiet ` +
+				`--dest $tmpDir/classes $tmpDir/filtered && ` +
+				`${config.JarCmd} cf $out.tmp -C $tmpDir/classes . && ` +
+				`${config.Zip2ZipCmd} -t -i $out.tmp -o $out && rm $out.tmp`,
+			CommandDeps: []string{"${config.JacocoCLIJar}", "${config.Zip2ZipCmd}"},
+		},
+		"includes", "excludes", "reportClassesJar")
+)
+
+// The static library containing the jacoco runtime, which records the coverage data of the
+// instrumented classes.
+const jacocoAgentLibrary = "jacocoagent"
+
+var jacocoFilterRegexp = regexp.MustCompile(`^[A-Za-z0-9_$]+(\.[A-Za-z0-9_$]+)*(\.\*)?$|^\*$`)
+
+// jacocoInstrumented returns true if the module should be instrumented for code coverage.  The
+// jacoco runtime is never instrumented, it would record coverage data with itself.
+func jacocoInstrumented(ctx android.BaseContext) bool {
+	return ctx.Device() && ctx.DeviceConfig().JavaCoverageEnabled() &&
+		ctx.DeviceConfig().JavaCoverageEnabledForPath(ctx.ModuleDir()) &&
+		ctx.ModuleName() != jacocoAgentLibrary
+}
+
+// jacocoFiltersToArgs converts class name filters into unzip patterns matching the class files.
+func jacocoFiltersToArgs(ctx android.ModuleContext, property string, filters []string) []string {
+	var args []string
+	for _, filter := range filters {
+		if !jacocoFilterRegexp.MatchString(filter) {
+			ctx.PropertyErrorf(property, "invalid class filter %q", filter)
+			continue
+		}
+		pattern := strings.Replace(filter, ".", "/", -1)
+		if !strings.HasSuffix(pattern, "*") {
+			pattern += ".class"
+		}
+		// Escape the $ of inner classes for ninja and quote the pattern so the shell doesn't
+		// expand it
+		args = append(args, "'"+strings.Replace(pattern, "$", "$$", -1)+"'")
+	}
+	return args
+}
+
+// instrumentWithJacoco instruments the classes in classesJar, returning the instrumented jar and
+// the jar of the original classes that were instrumented.
+func instrumentWithJacoco(ctx android.ModuleContext, includeFilter, excludeFilter []string,
+	classesJar android.Path) (instrumented android.Path, reportClassesJar android.Path) {
+
+	includes := jacocoFiltersToArgs(ctx, "jacoco.include_filter", includeFilter)
+	excludes := jacocoFiltersToArgs(ctx, "jacoco.exclude_filter", excludeFilter)
+	if ctx.Failed() {
+		return nil, nil
+	}
+	if len(includes) == 0 {
+		includes = []string{"'*'"}
+	}
+	var excludeArgs string
+	if len(excludes) > 0 {
+		excludeArgs = "-x " + strings.Join(excludes, " ")
+	}
+
+	instrumentedJar := android.PathForModuleOut(ctx, "jacoco", "classes-instrumented.jar")
+	reportJar := android.PathForModuleOut(ctx, "jacoco", "jacoco-report-classes.jar")
+
+	ctx.ModuleBuild(pctx, android.ModuleBuildParams{
+		Rule:           jacoco,
+		Description:    "jacoco",
+		Output:         instrumentedJar,
+		ImplicitOutput: reportJar,
+		Input:          classesJar,
+		Args: map[string]string{
+			"includes":         strings.Join(includes, " "),
+			"excludes":         excludeArgs,
+			"reportClassesJar": reportJar.String(),
+		},
+	})
+
+	return instrumentedJar, reportJar
+}
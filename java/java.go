@@ -24,9 +24,9 @@ import (
 	"strings"
 
 	"github.com/google/blueprint"
+	"github.com/google/blueprint/proptools"
 
 	"android/soong/android"
-	"android/soong/genrule"
 	"android/soong/java/config"
 )
 
@@ -42,8 +42,13 @@ func init() {
 	android.RegisterModuleType("java_import_host", ImportFactoryHost)
 	android.RegisterModuleType("android_prebuilt_sdk", SdkPrebuiltFactory)
 	android.RegisterModuleType("android_app", AndroidAppFactory)
+	android.RegisterModuleType("android_app_defaults", AppDefaultsFactory)
 
 	android.RegisterSingletonType("logtags", LogtagsSingleton)
+
+	android.PostDepsMutators(func(ctx android.RegisterMutatorsContext) {
+		ctx.BottomUp("java_proto", protoDepsMutator).Parallel()
+	})
 }
 
 // TODO:
@@ -74,6 +79,14 @@ type CompilerProperties struct {
 	// list of directories that should be excluded from resource_dirs
 	Exclude_resource_dirs []string `android:"arch_variant"`
 
+	// list of files to use as Java resources.  Files from a filegroup listed as ":module" are
+	// placed in the jar at their path relative to the path property of the filegroup, other
+	// files at their path relative to the module directory.
+	Java_resources []string `android:"arch_variant"`
+
+	// list of files that should be excluded from java_resources
+	Exclude_java_resources []string `android:"arch_variant"`
+
 	// don't build against the default libraries (legacy-test, core-junit,
 	// ext, and framework for device targets)
 	No_standard_libraries bool
@@ -87,14 +100,46 @@ type CompilerProperties struct {
 	// list of java libraries that will be compiled into the resulting jar
 	Static_libs []string `android:"arch_variant"`
 
+	// list of java_plugin modules that provide annotation processors to run during the compile
+	Plugins []string
+
 	// manifest file to be included in resulting jar
 	Manifest *string
 
 	// if not blank, run jarjar using the specified rules file
 	Jarjar_rules *string
 
-	// If not blank, set the java version passed to javac as -source and -target
+	// if set to a positive number, the java sources are compiled by separate javac invocations
+	// of at most this many sources each, which are compiled against the header jar of the whole
+	// module.  Speeds up the compile of very large modules.  Ignored if the module has no
+	// header jar, for example because it uses annotation processors or jarjar.
+	Javac_shard_size *int64
+
+	// If not blank, set the java version passed to javac as -source and -target.  Device
+	// modules compiled for java 9 or later are compiled against system_modules.
 	Java_version *string
+
+	Proto struct {
+		// Proto generator type (full, lite, nano).  Defaults to lite.
+		Type *string
+
+		// list of directories that will be added to the protoc include paths.
+		Include_dirs []string
+
+		// if set to false, the proto files are resolved relative to the directory of the
+		// Blueprints file instead of the root of the tree, which changes the paths that
+		// generated code and imports use to refer to them.  Defaults to true.
+		Canonical_path_from_root *bool
+	}
+
+	Errorprone struct {
+		// List of javac flags that should only be used when running errorprone.
+		Javacflags []string
+
+		// List of java library modules containing additional errorprone checks, added to the
+		// processor path of the errorprone compile.
+		Extra_check_modules []string
+	}
 }
 
 type CompilerDeviceProperties struct {
@@ -104,16 +149,41 @@ type CompilerDeviceProperties struct {
 	// if not blank, set to the version of the sdk to compile against
 	Sdk_version string
 
+	// When compiling for java 9 or later, the java_system_modules module to compile against
+	// instead of a bootclasspath, or "none" to not compile against any.  Defaults to
+	// core-system-modules for modules without an sdk_version, and must be set otherwise.
+	System_modules *string
+
 	// Set for device java libraries, and for host versions of device java libraries
 	// built for testing
 	Dex bool `blueprint:"mutated"`
 
-	// directories to pass to aidl tool
-	Aidl_includes []string
+	Aidl struct {
+		// list of directories that will be added to the aidl include paths.
+		Include_dirs []string
+
+		// list of directories relative to the Blueprints file that will
+		// be added to the aidl include paths.
+		Local_include_dirs []string
+
+		// export the aidl include paths of this module to modules that depend on it, so that
+		// their aidl sources can import the aidl interfaces of this module
+		Export_aidl_headers bool
+	}
+
+	// if true, the dexed jar of the device library is also installed on the host as
+	// <name>-hostdex, for ART host tests and layoutlib
+	Hostdex *bool
+
+	Jacoco struct {
+		// list of classes to instrument when java coverage is enabled, for example
+		// "android.foo.*" for all the classes in the android.foo package and its subpackages,
+		// or "android.foo.Bar" for a single class.  Defaults to all classes.
+		Include_filter []string
 
-	// directories that should be added as include directories
-	// for any aidl sources of modules that depend on this module
-	Export_aidl_include_dirs []string
+		// list of classes not to instrument, in the same format as include_filter.
+		Exclude_filter []string
+	}
 }
 
 // Module contains the properties and members used by all java module types
@@ -127,6 +197,10 @@ type Module struct {
 	// output file suitable for inserting into the classpath of another compile
 	classpathFile android.Path
 
+	// header jars containing only the API of this module and its static libraries, generated
+	// by turbine, suitable for inserting into the classpath of another compile
+	headerJars android.Paths
+
 	// output file suitable for installing or running
 	outputFile android.Path
 
@@ -138,6 +212,21 @@ type Module struct {
 
 	exportAidlIncludeDirs android.Paths
 
+	// srcjar containing the sources generated by annotation processors
+	annoSrcJar android.Path
+
+	// java sources, source file lists, flags and dependencies that were passed to javac,
+	// including generated sources
+	compiledJavaSrcs     android.Paths
+	compiledSrcFileLists android.Paths
+	compiledFlags        javaBuilderFlags
+	compiledDeps         android.Paths
+
+	// boot classpath and classpath jars that were passed to javac, and the java modules they
+	// came from, for IDE projects
+	compiledClasspath   android.Paths
+	compiledJavaModules []string
+
 	logtagsSrcs android.Paths
 
 	// filelists of extra source files that should be included in the javac command line,
@@ -146,10 +235,18 @@ type Module struct {
 
 	// installed file for binary dependency
 	installFile android.Path
+
+	// dex jar suitable for installing on the device, if the module was dexed
+	dexJarFile android.Path
+
+	// jar of the uninstrumented classes that were instrumented for code coverage, which is
+	// needed to generate coverage reports
+	jacocoReportClassesJar android.Path
 }
 
 type Dependency interface {
 	ClasspathFiles() android.Paths
+	HeaderJars() android.Paths
 	ClassJarSpecs() []jarSpec
 	ResourceJarSpecs() []jarSpec
 	AidlIncludeDirs() android.Paths
@@ -170,9 +267,18 @@ var (
 	libTag           = dependencyTag{name: "javalib"}
 	bootClasspathTag = dependencyTag{name: "bootclasspath"}
 	frameworkResTag  = dependencyTag{name: "framework-res"}
+	lineageResTag    = dependencyTag{name: "lineage-res"}
+	jniLibTag        = dependencyTag{name: "jnilib"}
 	sdkDependencyTag = dependencyTag{name: "sdk"}
+
+	errorProneChecksTag = dependencyTag{name: "errorprone-checks"}
+	pluginTag           = dependencyTag{name: "plugin"}
 )
 
+func (j *Module) compilerProperties() *CompilerProperties {
+	return &j.properties
+}
+
 func (j *Module) deps(ctx android.BottomUpMutatorContext) {
 	if !j.properties.No_standard_libraries {
 		if ctx.Device() {
@@ -185,6 +291,11 @@ func (j *Module) deps(ctx android.BottomUpMutatorContext) {
 				ctx.AddDependency(ctx.Module(), bootClasspathTag, "android_stubs_current")
 			case "system_current":
 				ctx.AddDependency(ctx.Module(), bootClasspathTag, "android_system_stubs_current")
+			case "lineage_current":
+				// The Lineage SDK is not on the bootclasspath of apps, so its stubs are a
+				// library on top of the current Android SDK
+				ctx.AddDependency(ctx.Module(), bootClasspathTag, "android_stubs_current")
+				ctx.AddDependency(ctx.Module(), libTag, config.LineageSdkStubs)
 			default:
 				ctx.AddDependency(ctx.Module(), sdkDependencyTag, "sdk_v"+j.deviceProperties.Sdk_version)
 			}
@@ -198,92 +309,225 @@ func (j *Module) deps(ctx android.BottomUpMutatorContext) {
 			ctx.AddDependency(ctx.Module(), libTag, config.DefaultLibraries...)
 		}
 	}
+	if ctx.Device() && j.java9() {
+		if systemModules := j.systemModules(ctx); systemModules != "none" {
+			ctx.AddDependency(ctx.Module(), systemModulesTag, systemModules)
+		}
+	}
 	ctx.AddDependency(ctx.Module(), libTag, j.properties.Libs...)
 	ctx.AddDependency(ctx.Module(), staticLibTag, j.properties.Static_libs...)
+
+	android.ExtractSourcesDeps(ctx, j.properties.Srcs)
+	android.ExtractSourcesDeps(ctx, j.properties.Java_resources)
+
+	// Annotation processors always run on the build host, so depend on the host variant of
+	// the plugins regardless of the variant being compiled.
+	ctx.AddFarVariationDependencies([]blueprint.Variation{
+		{Mutator: "arch", Variation: ctx.AConfig().BuildOsCommonVariant},
+	}, pluginTag, j.properties.Plugins...)
+
+	if ctx.AConfig().IsEnvTrue("RUN_ERROR_PRONE") {
+		ctx.AddDependency(ctx.Module(), errorProneChecksTag, j.properties.Errorprone.Extra_check_modules...)
+	}
 }
 
-func (j *Module) aidlFlags(ctx android.ModuleContext, aidlPreprocess android.OptionalPath,
-	aidlIncludeDirs android.Paths) []string {
+// java9 returns true if the module is compiled for java 9 or later, which uses system modules
+// instead of a bootclasspath.
+func (j *Module) java9() bool {
+	if j.properties.Java_version == nil {
+		return false
+	}
+	// Versions before java 9 are written as 1.<version>
+	version := strings.TrimPrefix(*j.properties.Java_version, "1.")
+	v, err := strconv.Atoi(version)
+	return err == nil && v >= 9
+}
+
+// systemModules returns the name of the java_system_modules module that the module is compiled
+// against when it is compiled for java 9, or "none".
+func (j *Module) systemModules(ctx android.BaseContext) string {
+	if j.deviceProperties.System_modules != nil {
+		return *j.deviceProperties.System_modules
+	}
+	if j.properties.No_standard_libraries {
+		return "none"
+	}
+	if j.deviceProperties.Sdk_version == "" {
+		return config.DefaultSystemModules
+	}
+	ctx.PropertyErrorf("system_modules", "must be set for java_version %q with sdk_version %q",
+		*j.properties.Java_version, j.deviceProperties.Sdk_version)
+	return "none"
+}
+
+func (j *Module) aidlIncludeDirs(ctx android.ModuleContext) android.Paths {
+	aidlIncludes := android.PathsForModuleSrc(ctx, j.deviceProperties.Aidl.Local_include_dirs)
+	aidlIncludes = append(aidlIncludes,
+		android.PathsForSource(ctx, j.deviceProperties.Aidl.Include_dirs)...)
+	return aidlIncludes
+}
 
-	localAidlIncludes := android.PathsForModuleSrc(ctx, j.deviceProperties.Aidl_includes)
+func (j *Module) aidlFlags(ctx android.ModuleContext, aidlPreprocess android.OptionalPath,
+	aidlIncludeDirs android.Paths) ([]string, android.Paths) {
 
 	var flags []string
+	var deps android.Paths
+
 	if aidlPreprocess.Valid() {
 		flags = append(flags, "-p"+aidlPreprocess.String())
+		deps = append(deps, aidlPreprocess.Path())
 	} else {
 		flags = append(flags, android.JoinWithPrefix(aidlIncludeDirs.Strings(), "-I"))
 	}
 
-	flags = append(flags, android.JoinWithPrefix(j.exportAidlIncludeDirs.Strings(), "-I"))
-	flags = append(flags, android.JoinWithPrefix(localAidlIncludes.Strings(), "-I"))
+	flags = append(flags, android.JoinWithPrefix(j.aidlIncludeDirs(ctx).Strings(), "-I"))
 	flags = append(flags, "-I"+android.PathForModuleSrc(ctx).String())
 	if src := android.ExistentPathForSource(ctx, "", "src"); src.Valid() {
 		flags = append(flags, "-I"+src.String())
 	}
 
-	return flags
+	return flags, deps
 }
 
-func (j *Module) collectDeps(ctx android.ModuleContext) (classpath android.Paths,
-	bootClasspath android.Paths, classJarSpecs, resourceJarSpecs []jarSpec, aidlPreprocess android.OptionalPath,
-	aidlIncludeDirs android.Paths, srcFileLists android.Paths) {
+type deps struct {
+	classpath        android.Paths
+	bootClasspath    android.Paths
+	classJarSpecs    []jarSpec
+	resourceJarSpecs []jarSpec
+	aidlPreprocess   android.OptionalPath
+	aidlIncludeDirs  android.Paths
+	srcFileLists     android.Paths
+	staticHeaderJars android.Paths
+	errorProneChecks android.Paths
+	processorPath    android.Paths
+	processorClasses []string
+
+	systemModules     android.Path
+	systemModulesDeps android.Paths
+
+	// names of the java modules that the module depends on, for IDE projects
+	javaModules []string
+}
+
+func (j *Module) collectDeps(ctx android.ModuleContext) deps {
+	var deps deps
 
 	ctx.VisitDirectDeps(func(module blueprint.Module) {
 		otherName := ctx.OtherModuleName(module)
 		tag := ctx.OtherModuleDependencyTag(module)
 
+		if tag == systemModulesTag {
+			systemModules, ok := module.(systemModulesProvider)
+			if !ok {
+				ctx.PropertyErrorf("system_modules", "%q is not a java_system_modules module", otherName)
+				return
+			}
+			deps.systemModules = systemModules.SystemModulesDir()
+			deps.systemModulesDeps = systemModules.SystemModulesDeps()
+			return
+		}
+
 		dep, _ := module.(Dependency)
 		if dep == nil {
 			switch tag {
-			case android.DefaultsDepTag, android.SourceDepTag:
+			case android.DefaultsDepTag, android.SourceDepTag, jniLibTag:
 			default:
 				ctx.ModuleErrorf("depends on non-java module %q", otherName)
 			}
 			return
 		}
+		deps.javaModules = append(deps.javaModules, otherName)
 
 		switch tag {
+		case android.SourceDepTag:
+			// Generated sources listed in srcs, for example from a java_genrule, are
+			// expanded by ExpandSources
+			return
 		case bootClasspathTag:
-			bootClasspath = append(bootClasspath, dep.ClasspathFiles()...)
+			deps.bootClasspath = append(deps.bootClasspath, dep.HeaderJars()...)
 		case libTag:
-			classpath = append(classpath, dep.ClasspathFiles()...)
+			if sdkLib, ok := module.(sdkLibraryDependency); ok && j.deviceProperties.Sdk_version != "" {
+				// Modules compiled against an SDK may only use the API of an SDK library
+				deps.classpath = append(deps.classpath, sdkLib.SdkHeaderJars(j.deviceProperties.Sdk_version)...)
+			} else {
+				deps.classpath = append(deps.classpath, dep.HeaderJars()...)
+			}
 		case staticLibTag:
-			classpath = append(classpath, dep.ClasspathFiles()...)
-			classJarSpecs = append(classJarSpecs, dep.ClassJarSpecs()...)
-			resourceJarSpecs = append(resourceJarSpecs, dep.ResourceJarSpecs()...)
+			deps.classpath = append(deps.classpath, dep.HeaderJars()...)
+			deps.staticHeaderJars = append(deps.staticHeaderJars, dep.HeaderJars()...)
+			deps.classJarSpecs = append(deps.classJarSpecs, dep.ClassJarSpecs()...)
+			deps.resourceJarSpecs = append(deps.resourceJarSpecs, dep.ResourceJarSpecs()...)
+		case lineageResTag:
+			// Only used for the resources of apps
 		case frameworkResTag:
 			if ctx.ModuleName() == "framework" {
 				// framework.jar has a one-off dependency on the R.java and Manifest.java files
 				// generated by framework-res.apk
-				srcFileLists = append(srcFileLists, module.(*AndroidApp).aaptJavaFileList)
+				deps.srcFileLists = append(deps.srcFileLists, module.(*AndroidApp).aaptJavaFileList)
 			}
 		case sdkDependencyTag:
 			sdkDep := module.(sdkDependency)
 			if sdkDep.AidlPreprocessed().Valid() {
-				if aidlPreprocess.Valid() {
+				if deps.aidlPreprocess.Valid() {
 					ctx.ModuleErrorf("multiple dependencies with preprocessed aidls:\n %q\n %q",
-						aidlPreprocess, sdkDep.AidlPreprocessed())
+						deps.aidlPreprocess, sdkDep.AidlPreprocessed())
 				} else {
-					aidlPreprocess = sdkDep.AidlPreprocessed()
+					deps.aidlPreprocess = sdkDep.AidlPreprocessed()
 				}
 			}
+		case errorProneChecksTag:
+			deps.errorProneChecks = append(deps.errorProneChecks, dep.ClasspathFiles()...)
+			return
+		case pluginTag:
+			plugin, ok := module.(*Plugin)
+			if !ok {
+				ctx.PropertyErrorf("plugins", "%q is not a java_plugin module", otherName)
+				return
+			}
+			deps.processorPath = append(deps.processorPath, plugin.ClasspathFiles()...)
+			if plugin.pluginProperties.Processor_class != nil {
+				deps.processorClasses = append(deps.processorClasses, *plugin.pluginProperties.Processor_class)
+			}
+			return
 		default:
 			panic(fmt.Errorf("unknown dependency %q for %q", otherName, ctx.ModuleName()))
 		}
 
-		aidlIncludeDirs = append(aidlIncludeDirs, dep.AidlIncludeDirs()...)
+		deps.aidlIncludeDirs = append(deps.aidlIncludeDirs, dep.AidlIncludeDirs()...)
 	})
 
-	return classpath, bootClasspath, classJarSpecs, resourceJarSpecs, aidlPreprocess,
-		aidlIncludeDirs, srcFileLists
+	return deps
+}
+
+// compileJavaShards compiles the java sources with separate javac invocations of at most
+// shardSize sources each.  The shards are compiled against the header jar of the whole module so
+// that they can refer to each other's classes, and the sources in srcFileLists, whose number isn't
+// known until they are generated, are compiled in their own shard.
+func compileJavaShards(ctx android.ModuleContext, shardSize int, srcFiles, srcFileLists android.Paths,
+	flags javaBuilderFlags, headerJar android.Path, classpath, deps android.Paths) []jarSpec {
+
+	flags.classpath = "-classpath " + strings.Join(append(android.Paths{headerJar}, classpath...).Strings(), ":")
+	deps = append(android.Paths{headerJar}, deps...)
+
+	var shards []jarSpec
+	for _, shard := range android.ShardPaths(srcFiles, shardSize) {
+		shards = append(shards, transformJavaToClasses(ctx, "-shard"+strconv.Itoa(len(shards)),
+			shard, nil, flags, deps))
+	}
+	if len(srcFileLists) > 0 {
+		shards = append(shards, transformJavaToClasses(ctx, "-shard"+strconv.Itoa(len(shards)),
+			nil, srcFileLists, flags, deps))
+	}
+	return shards
 }
 
 func (j *Module) compile(ctx android.ModuleContext) {
 
-	j.exportAidlIncludeDirs = android.PathsForModuleSrc(ctx, j.deviceProperties.Export_aidl_include_dirs)
+	if j.deviceProperties.Aidl.Export_aidl_headers {
+		j.exportAidlIncludeDirs = j.aidlIncludeDirs(ctx)
+	}
 
-	classpath, bootClasspath, classJarSpecs, resourceJarSpecs, aidlPreprocess,
-		aidlIncludeDirs, srcFileLists := j.collectDeps(ctx)
+	deps := j.collectDeps(ctx)
 
 	var flags javaBuilderFlags
 
@@ -300,48 +544,132 @@ func (j *Module) compile(ctx android.ModuleContext) {
 		flags.javacFlags = "$javacFlags"
 	}
 
-	aidlFlags := j.aidlFlags(ctx, aidlPreprocess, aidlIncludeDirs)
+	aidlFlags, aidlDeps := j.aidlFlags(ctx, deps.aidlPreprocess, deps.aidlIncludeDirs)
 	if len(aidlFlags) > 0 {
 		ctx.Variable(pctx, "aidlFlags", strings.Join(aidlFlags, " "))
 		flags.aidlFlags = "$aidlFlags"
 	}
+	flags.aidlDeps = aidlDeps
+
+	var extraDeps android.Paths
+
+	if j.java9() {
+		// javac rejects -bootclasspath for java 9, device modules are compiled against system
+		// modules instead and host modules against the system modules of the JDK.
+		if deps.systemModules != nil {
+			flags.bootClasspath = "--system=" + deps.systemModules.String()
+			extraDeps = append(extraDeps, deps.systemModulesDeps...)
+		} else if ctx.Device() {
+			flags.bootClasspath = "--system=none"
+		}
+	} else if len(deps.bootClasspath) > 0 {
+		flags.bootClasspath = "-bootclasspath " + strings.Join(deps.bootClasspath.Strings(), ":")
+		extraDeps = append(extraDeps, deps.bootClasspath...)
+	}
 
-	var deps android.Paths
-
-	if len(bootClasspath) > 0 {
-		flags.bootClasspath = "-bootclasspath " + strings.Join(bootClasspath.Strings(), ":")
-		deps = append(deps, bootClasspath...)
+	if len(deps.classpath) > 0 {
+		flags.classpath = "-classpath " + strings.Join(deps.classpath.Strings(), ":")
+		extraDeps = append(extraDeps, deps.classpath...)
 	}
 
-	if len(classpath) > 0 {
-		flags.classpath = "-classpath " + strings.Join(classpath.Strings(), ":")
-		deps = append(deps, classpath...)
+	if len(deps.processorPath) > 0 {
+		flags.processorPath = processorPathFlags(deps.processorPath, deps.processorClasses)
+		extraDeps = append(extraDeps, deps.processorPath...)
 	}
 
 	srcFiles := ctx.ExpandSources(j.properties.Srcs, j.properties.Exclude_srcs)
 
-	srcFiles = j.genSources(ctx, srcFiles, flags)
+	if hasProtoSrcs(srcFiles) {
+		flags = protoFlags(ctx, &j.properties, flags)
+	}
 
-	ctx.VisitDirectDeps(func(module blueprint.Module) {
-		if gen, ok := module.(genrule.SourceFileGenerator); ok {
-			srcFiles = append(srcFiles, gen.GeneratedSourceFiles()...)
+	srcFiles, genSrcFileLists := j.genSources(ctx, srcFiles, flags)
+
+	srcFileLists := append(deps.srcFileLists, j.ExtraSrcLists...)
+	srcFileLists = append(srcFileLists, genSrcFileLists...)
+
+	// Proto sources are compiled through the lists of java files generated from them
+	hasSrcs := len(srcFiles) > 0 || len(genSrcFileLists) > 0
+
+	j.compiledJavaSrcs = srcFiles
+	j.compiledSrcFileLists = srcFileLists
+	j.compiledFlags = flags
+	ctx.Tracef("flags: %+v", flags)
+	j.compiledDeps = extraDeps
+	j.compiledClasspath = append(append(android.Paths(nil), deps.bootClasspath...), deps.classpath...)
+	j.compiledJavaModules = deps.javaModules
+
+	classJarSpecs := deps.classJarSpecs
+
+	var headerJar android.Path
+	var headerJars android.Paths
+
+	if hasSrcs && j.properties.Jarjar_rules == nil && len(deps.processorPath) == 0 && !j.java9() &&
+		!ctx.AConfig().IsEnvFalse("TURBINE_ENABLED") {
+		// Generate a header jar with turbine so that modules depending on this one only need to
+		// be recompiled when its API changes, not every time its implementation changes.  Modules
+		// using jarjar fall back to the full jar, as the header jar would not be repackaged, and
+		// so do modules using annotation processors, as turbine doesn't see generated classes.
+		// Turbine only supports a bootclasspath, so modules compiled for java 9 fall back too.
+		headerJar = TransformJavaToHeaderClasses(ctx, srcFiles, srcFileLists, flags,
+			deps.bootClasspath, deps.classpath)
+		if ctx.Failed() {
+			return
 		}
-	})
 
-	srcFileLists = append(srcFileLists, j.ExtraSrcLists...)
+		headerJars = append(android.Paths{headerJar}, deps.staticHeaderJars...)
+	}
 
-	if len(srcFiles) > 0 {
-		// Compile java sources into .class files
-		classes := TransformJavaToClasses(ctx, srcFiles, srcFileLists, flags, deps)
+	if hasSrcs {
+		var classes []jarSpec
+		if headerJar != nil && j.properties.Javac_shard_size != nil && *j.properties.Javac_shard_size > 0 {
+			// Compile java sources into .class files in shards
+			classes = compileJavaShards(ctx, int(*j.properties.Javac_shard_size), srcFiles,
+				srcFileLists, flags, headerJar, deps.classpath, extraDeps)
+		} else {
+			// Compile java sources into .class files
+			classes = []jarSpec{TransformJavaToClasses(ctx, srcFiles, srcFileLists, flags, extraDeps)}
+		}
 		if ctx.Failed() {
 			return
 		}
 
-		classJarSpecs = append([]jarSpec{classes}, classJarSpecs...)
+		if len(deps.processorPath) > 0 {
+			// The srcjar is listed for IDE project generators, which expect it to be built
+			j.annoSrcJar = TransformAnnotationSourcesToSrcJar(ctx, classes[0])
+			ctx.CheckbuildFile(j.annoSrcJar)
+		}
+
+		if ctx.AConfig().IsEnvTrue("RUN_ERROR_PRONE") {
+			// If error-prone is enabled, compile the java files a second time into a separate
+			// directory with the error-prone checks enabled.  The result is only attached to
+			// checkbuild, so the slower error-prone compile never blocks anything that depends
+			// on the normal class files.
+			errorProneFlags := flags
+			if len(j.properties.Errorprone.Javacflags) > 0 {
+				errorProneFlags.javacFlags += " " + strings.Join(j.properties.Errorprone.Javacflags, " ")
+			}
+			if len(deps.errorProneChecks) > 0 {
+				errorProneFlags.processorPath = processorPathFlags(
+					append(append(android.Paths(nil), deps.processorPath...), deps.errorProneChecks...),
+					deps.processorClasses)
+			}
+			errorProneDeps := append(android.Paths(nil), extraDeps...)
+			errorProneDeps = append(errorProneDeps, deps.errorProneChecks...)
+
+			errorProne := RunErrorProne(ctx, srcFiles, srcFileLists, errorProneFlags, errorProneDeps)
+			ctx.CheckbuildFile(errorProne)
+		}
+
+		classJarSpecs = append(classes, classJarSpecs...)
 	}
 
-	resourceJarSpecs = append(ResourceDirsToJarSpecs(ctx, j.properties.Resource_dirs, j.properties.Exclude_resource_dirs),
-		resourceJarSpecs...)
+	resourceJarSpecs := ResourceDirsToJarSpecs(ctx, j.properties.Resource_dirs, j.properties.Exclude_resource_dirs)
+	if len(j.properties.Java_resources) > 0 {
+		resources := ctx.ExpandSources(j.properties.Java_resources, j.properties.Exclude_java_resources)
+		resourceJarSpecs = append(resourceJarSpecs, ResourceFilesToJarSpec(ctx, resources))
+	}
+	resourceJarSpecs = append(resourceJarSpecs, deps.resourceJarSpecs...)
 
 	manifest := android.OptionalPathForModuleSrc(ctx, j.properties.Manifest)
 
@@ -369,16 +697,20 @@ func (j *Module) compile(ctx android.ModuleContext) {
 	j.resourceJarSpecs = resourceJarSpecs
 	j.classJarSpecs = classJarSpecs
 	j.classpathFile = outputFile
+	j.headerJars = headerJars
 
-	if j.deviceProperties.Dex && len(srcFiles) > 0 {
+	if j.deviceProperties.Dex && hasSrcs {
 		dxFlags := j.deviceProperties.Dxflags
-		if false /* emma enabled */ {
-			// If you instrument class files that have local variable debug information in
-			// them emma does not correctly maintain the local variable table.
-			// This will cause an error when you try to convert the class files for Android.
-			// The workaround here is to build different dex file here based on emma switch
-			// then later copy into classes.dex. When emma is on, dx is run with --no-locals
-			// option to remove local variable information
+
+		dexInput := outputFile
+		if jacocoInstrumented(ctx) {
+			dexInput, j.jacocoReportClassesJar = instrumentWithJacoco(ctx,
+				j.deviceProperties.Jacoco.Include_filter, j.deviceProperties.Jacoco.Exclude_filter,
+				outputFile)
+			if ctx.Failed() {
+				return
+			}
+
 			dxFlags = append(dxFlags, "--no-locals")
 		}
 
@@ -395,26 +727,61 @@ func (j *Module) compile(ctx android.ModuleContext) {
 		}
 
 		flags.dxFlags = strings.Join(dxFlags, " ")
+		flags.dexBootClasspath = deps.bootClasspath
+		flags.dexClasspath = deps.classpath
 
 		// Compile classes.jar into classes.dex
-		dexJarSpec := TransformClassesJarToDex(ctx, outputFile, flags)
+		dexJarSpec := TransformClassesJarToDex(ctx, dexInput, flags)
 		if ctx.Failed() {
 			return
 		}
 
 		// Combine classes.dex + resources into javalib.jar
 		outputFile = TransformDexToJavaLib(ctx, resourceJarSpecs, dexJarSpec)
+		j.dexJarFile = outputFile
 	}
 	ctx.CheckbuildFile(outputFile)
 	j.outputFile = outputFile
 }
 
+// processorPathFlags returns the javac flags to run the given annotation processors.  If no
+// processor classes are listed javac discovers them through META-INF/services in the jars.
+func processorPathFlags(processorPath android.Paths, processorClasses []string) string {
+	flags := "-processorpath " + strings.Join(processorPath.Strings(), ":")
+	if len(processorClasses) > 0 {
+		flags += " -processor " + strings.Join(processorClasses, ",")
+	}
+	return flags
+}
+
 var _ Dependency = (*Library)(nil)
 
+// OutputFiles returns the implementation jar of the module for ":module" and ":module{.jar}"
+// references, and its header jars for ":module{.hjar}" references.
+func (j *Module) OutputFiles(tag string) (android.Paths, error) {
+	switch tag {
+	case "", ".jar":
+		return android.Paths{j.outputFile}, nil
+	case ".hjar":
+		return j.HeaderJars(), nil
+	default:
+		return nil, fmt.Errorf("unsupported module reference tag %q", tag)
+	}
+}
+
+var _ android.OutputFileProducer = (*Module)(nil)
+
 func (j *Module) ClasspathFiles() android.Paths {
 	return android.Paths{j.classpathFile}
 }
 
+func (j *Module) HeaderJars() android.Paths {
+	if len(j.headerJars) > 0 {
+		return j.headerJars
+	}
+	return j.ClasspathFiles()
+}
+
 func (j *Module) ClassJarSpecs() []jarSpec {
 	return j.classJarSpecs
 }
@@ -427,6 +794,12 @@ func (j *Module) AidlIncludeDirs() android.Paths {
 	return j.exportAidlIncludeDirs
 }
 
+// AnnoSrcJar returns the srcjar of sources generated by annotation processors, if any
+// plugins were used by the module.
+func (j *Module) AnnoSrcJar() android.OptionalPath {
+	return android.OptionalPathForPath(j.annoSrcJar)
+}
+
 var _ logtagsProducer = (*Module)(nil)
 
 func (j *Module) logtags() android.Paths {
@@ -536,7 +909,23 @@ func BinaryHostFactory() android.Module {
 //
 
 type ImportProperties struct {
+	// list of prebuilt .jar files to import
 	Jars []string
+
+	// if not blank, run jarjar on the imported classes using the specified rules file, so that
+	// modules using the prebuilt see the repackaged classes
+	Jarjar_rules *string
+
+	// if set to true, convert the classes in the jars to dex bytecode so that the prebuilt can be
+	// installed and loaded on the device.  Only applies to device variants.
+	Dex *bool
+
+	// list of module-specific flags that will be used for dex compiles
+	Dxflags []string
+
+	// if set to false, the prebuilt is only used to compile against and is not installed.
+	// Defaults to true.
+	Installable *bool
 }
 
 type Import struct {
@@ -548,6 +937,12 @@ type Import struct {
 	classpathFiles                  android.Paths
 	combinedClasspathFile           android.Path
 	classJarSpecs, resourceJarSpecs []jarSpec
+
+	// output file suitable for installing, dexed if requested
+	outputFile android.Path
+
+	// installed dex jar, if the prebuilt was dexed and installed
+	installedDexJarFile android.Path
 }
 
 func (j *Import) Prebuilt() *android.Prebuilt {
@@ -576,9 +971,46 @@ func (j *Import) GenerateAndroidBuildActions(ctx android.ModuleContext) {
 	}
 
 	j.combinedClasspathFile = TransformClassesToJar(ctx, j.classJarSpecs, android.OptionalPath{})
+	if ctx.Failed() {
+		return
+	}
+
+	if j.properties.Jarjar_rules != nil {
+		jarjarRules := android.PathForModuleSrc(ctx, *j.properties.Jarjar_rules)
+		// Transform classes-full-debug.jar into classes-jarjar.jar
+		j.combinedClasspathFile = TransformJarJar(ctx, j.combinedClasspathFile, jarjarRules)
+		if ctx.Failed() {
+			return
+		}
+
+		classes, _ := TransformPrebuiltJarToClasses(ctx, "jarjar_extracted", j.combinedClasspathFile)
+		j.classJarSpecs = []jarSpec{classes}
+		j.classpathFiles = android.Paths{j.combinedClasspathFile}
+	}
+
+	j.outputFile = j.combinedClasspathFile
+
+	if ctx.Device() && proptools.Bool(j.properties.Dex) {
+		var flags javaBuilderFlags
+		flags.dxFlags = strings.Join(j.properties.Dxflags, " ")
+
+		// Compile the combined classes jar into classes.dex
+		dexJarSpec := TransformClassesJarToDex(ctx, j.combinedClasspathFile, flags)
+		if ctx.Failed() {
+			return
+		}
 
-	ctx.InstallFileName(android.PathForModuleInstall(ctx, "framework"),
-		ctx.ModuleName()+".jar", j.combinedClasspathFile)
+		// Combine classes.dex + resources into javalib.jar
+		j.outputFile = TransformDexToJavaLib(ctx, j.resourceJarSpecs, dexJarSpec)
+	}
+
+	if proptools.BoolDefault(j.properties.Installable, true) {
+		ctx.InstallFileName(android.PathForModuleInstall(ctx, "framework"),
+			ctx.ModuleName()+".jar", j.outputFile)
+		if ctx.Device() && proptools.Bool(j.properties.Dex) {
+			j.installedDexJarFile = j.outputFile
+		}
+	}
 }
 
 var _ Dependency = (*Import)(nil)
@@ -587,6 +1019,10 @@ func (j *Import) ClasspathFiles() android.Paths {
 	return j.classpathFiles
 }
 
+func (j *Import) HeaderJars() android.Paths {
+	return j.classpathFiles
+}
+
 func (j *Import) ClassJarSpecs() []jarSpec {
 	return j.classJarSpecs
 }
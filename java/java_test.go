@@ -16,10 +16,15 @@ package java
 
 import (
 	"android/soong/android"
+	"android/soong/cc"
+	"android/soong/genrule"
+	"bytes"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"reflect"
+	"sort"
 	"strings"
 	"testing"
 )
@@ -49,20 +54,43 @@ func TestMain(m *testing.M) {
 	os.Exit(run())
 }
 
-func testJava(t *testing.T, bp string) *android.TestContext {
-	config := android.TestConfig(buildDir)
+// newJavaFixture returns a test fixture with the java module types registered, the modules that
+// java modules depend on by default defined, and the source files used by the tests.
+func newJavaFixture(bp string) *android.TestFixture {
+	return setupJavaFixture(android.NewTestFixture(buildDir), bp)
+}
+
+// newJavaArchFixture is like newJavaFixture, but the modules are split into variants for the device
+// and host targets, for tests of properties that only apply to the device or the host.
+func newJavaArchFixture(bp string) *android.TestFixture {
+	return setupJavaFixture(android.NewTestArchFixture(buildDir), bp)
+}
 
-	ctx := android.NewTestContext()
-	ctx.RegisterModuleType("android_app", android.ModuleFactoryAdaptor(AndroidAppFactory))
-	ctx.RegisterModuleType("java_library", android.ModuleFactoryAdaptor(LibraryFactory))
-	ctx.RegisterModuleType("java_import", android.ModuleFactoryAdaptor(ImportFactory))
-	ctx.RegisterModuleType("java_defaults", android.ModuleFactoryAdaptor(defaultsFactory))
-	ctx.PreArchMutators(android.RegisterPrebuiltsPreArchMutators)
-	ctx.PreArchMutators(android.RegisterPrebuiltsPostDepsMutators)
-	ctx.PreArchMutators(android.RegisterDefaultsPreArchMutators)
-	ctx.Register()
+func setupJavaFixture(f *android.TestFixture, bp string) *android.TestFixture {
+	f.RegisterModuleType("android_app", android.ModuleFactoryAdaptor(AndroidAppFactory))
+	f.RegisterModuleType("android_app_defaults", android.ModuleFactoryAdaptor(AppDefaultsFactory))
+	f.RegisterModuleType("java_library", android.ModuleFactoryAdaptor(LibraryFactory))
+	f.RegisterModuleType("java_import", android.ModuleFactoryAdaptor(ImportFactory))
+	f.RegisterModuleType("java_sdk_library", android.ModuleFactoryAdaptor(SdkLibraryFactory))
+	f.RegisterModuleType("java_defaults", android.ModuleFactoryAdaptor(defaultsFactory))
+	f.RegisterModuleType("java_plugin", android.ModuleFactoryAdaptor(PluginFactory))
+	f.RegisterModuleType("java_genrule", android.ModuleFactoryAdaptor(GenRuleFactory))
+	f.RegisterModuleType("droiddoc", android.ModuleFactoryAdaptor(DroiddocFactory))
+	f.RegisterModuleType("droidstubs", android.ModuleFactoryAdaptor(DroidstubsFactory))
+	f.RegisterModuleType("java_system_modules", android.ModuleFactoryAdaptor(SystemModulesFactory))
+	f.RegisterModuleType("platform_compat_config", android.ModuleFactoryAdaptor(PlatformCompatConfigFactory))
+	f.RegisterModuleType("android_webview_import", android.ModuleFactoryAdaptor(WebViewImportFactory))
+	f.RegisterModuleType("filegroup", android.ModuleFactoryAdaptor(genrule.FileGroupFactory))
+	f.PreArchMutators(android.RegisterPrebuiltsPreArchMutators)
+	f.PreArchMutators(android.RegisterPrebuiltsPostDepsMutators)
+	f.PreArchMutators(android.RegisterDefaultsPreArchMutators)
+	f.PostDepsMutators(func(ctx android.RegisterMutatorsContext) {
+		ctx.BottomUp("java_proto", protoDepsMutator).Parallel()
+	})
 
-	extraModules := []string{"core-libart", "frameworks", "sdk_v14"}
+	extraModules := []string{"core-libart", "core-oj", "ext", "framework", "frameworks", "okhttp",
+		"sdk_v14", "android_stubs_current", "android_system_stubs_current", "libprotobuf-java-lite",
+		"libprotobuf-java-nano"}
 
 	for _, extra := range extraModules {
 		bp += fmt.Sprintf(`
@@ -73,21 +101,61 @@ func testJava(t *testing.T, bp string) *android.TestContext {
 		`, extra)
 	}
 
-	ctx.MockFileSystem(map[string][]byte{
-		"Android.bp": []byte(bp),
-		"a.java":     nil,
-		"b.java":     nil,
-		"c.java":     nil,
-		"a.jar":      nil,
-		"b.jar":      nil,
-	})
+	f.AddBlueprint(bp)
+	f.AddFiles(
+		"a.java",
+		"b.java",
+		"c.java",
+		"d.logtags",
+		"e.proto",
+		"f.proto",
+		"a.jar",
+		"b.jar",
+
+		"jarjar_rules.txt",
+		"gen.sh",
+
+		"api/current.txt",
+		"api/removed.txt",
+		"api/system-current.txt",
+		"api/system-removed.txt",
+		"api/lint-baseline.txt",
+
+		"build/target/product/security/testkey",
+	)
+
+	return f
+}
+
+func testJava(t *testing.T, bp string) *android.TestContext {
+	f := newJavaFixture(bp)
+	f.Prepare(t)
+	return f.TestContext
+}
+
+func testJavaArch(t *testing.T, bp string) *android.TestContext {
+	f := newJavaArchFixture(bp)
+	f.Prepare(t)
+	return f.TestContext
+}
 
-	_, errs := ctx.ParseBlueprintsFiles("Android.bp")
-	fail(t, errs)
-	_, errs = ctx.PrepareBuildActions(config)
-	fail(t, errs)
+// androidMkExtra returns the Make variables written by the Extra functions of a module's
+// AndroidMkData.
+func androidMkExtra(data android.AndroidMkData) string {
+	buf := &bytes.Buffer{}
+	for _, extra := range data.Extra {
+		extra(buf, data.OutputFile.Path())
+	}
+	return buf.String()
+}
 
-	return ctx
+// androidMkEntries returns the Make variables of a module's AndroidMkEntries that depend on the
+// module.
+func androidMkEntries(entries android.AndroidMkEntries) map[string][]string {
+	for _, extra := range entries.ExtraEntries {
+		extra(&entries)
+	}
+	return entries.EntryMap
 }
 
 func TestSimple(t *testing.T) {
@@ -117,7 +185,7 @@ func TestSimple(t *testing.T) {
 		t.Errorf(`foo inputs %v != ["a.java"]`, javac.Inputs)
 	}
 
-	bar := filepath.Join(buildDir, ".intermediates", "bar", "classes-full-debug.jar")
+	bar := filepath.Join(buildDir, ".intermediates", "bar", "classes-header.jar")
 	if !strings.Contains(javac.Args["classpath"], bar) {
 		t.Errorf("foo classpath %v does not contain %q", javac.Args["classpath"], bar)
 	}
@@ -128,6 +196,99 @@ func TestSimple(t *testing.T) {
 	}
 }
 
+func TestTargetProperties(t *testing.T) {
+	ctx := testJavaArch(t, `
+		java_library {
+			name: "foo",
+			srcs: ["a.java"],
+			target: {
+				android: {
+					srcs: ["b.java"],
+				},
+				host: {
+					srcs: ["c.java"],
+				},
+			},
+		}
+		`)
+
+	javac := ctx.ModuleForTests("foo", "android_common").Rule("javac")
+	if len(javac.Inputs) != 2 || javac.Inputs[0].String() != "a.java" || javac.Inputs[1].String() != "b.java" {
+		t.Errorf(`foo inputs %v != ["a.java" "b.java"]`, javac.Inputs)
+	}
+}
+
+func TestTurbine(t *testing.T) {
+	ctx := testJava(t, `
+		java_library {
+			name: "foo",
+			srcs: ["a.java"],
+			libs: ["bar"],
+			static_libs: ["baz"],
+		}
+
+		java_library {
+			name: "bar",
+			srcs: ["b.java"],
+		}
+
+		java_library {
+			name: "baz",
+			srcs: ["c.java"],
+		}
+		`)
+
+	fooTurbine := ctx.ModuleForTests("foo", "").Rule("turbine")
+	barTurbine := ctx.ModuleForTests("bar", "").Rule("turbine")
+
+	if len(fooTurbine.Inputs) != 1 || fooTurbine.Inputs[0].String() != "a.java" {
+		t.Errorf(`foo turbine inputs %v != ["a.java"]`, fooTurbine.Inputs)
+	}
+
+	bar := filepath.Join(buildDir, ".intermediates", "bar", "classes-header.jar")
+	if barTurbine.Output.String() != bar {
+		t.Errorf("bar turbine output %q != %q", barTurbine.Output.String(), bar)
+	}
+
+	if !strings.Contains(fooTurbine.Args["classpath"], bar) {
+		t.Errorf("foo turbine classpath %v does not contain %q", fooTurbine.Args["classpath"], bar)
+	}
+
+	foo := ctx.ModuleForTests("foo", "").Module().(*Library)
+	baz := filepath.Join(buildDir, ".intermediates", "baz", "classes-header.jar")
+	if headerJars := foo.HeaderJars().Strings(); len(headerJars) != 2 || headerJars[1] != baz {
+		t.Errorf("foo header jars %v does not contain static lib header jar %q", headerJars, baz)
+	}
+}
+
+func TestJavacShards(t *testing.T) {
+	ctx := testJava(t, `
+		java_library {
+			name: "foo",
+			srcs: ["a.java", "b.java", "c.java"],
+			javac_shard_size: 2,
+		}
+		`)
+
+	foo := ctx.ModuleForTests("foo", "")
+	headerJar := filepath.Join(buildDir, ".intermediates", "foo", "classes-header.jar")
+
+	for i, srcs := range [][]string{{"a.java", "b.java"}, {"c.java"}} {
+		javac := foo.Output(fmt.Sprintf("classes-shard%d.list", i))
+		if !reflect.DeepEqual(javac.Inputs.Strings(), srcs) {
+			t.Errorf("foo shard %d inputs %v != %v", i, javac.Inputs.Strings(), srcs)
+		}
+		if !strings.HasPrefix(javac.Args["classpath"], "-classpath "+headerJar) {
+			t.Errorf("foo shard %d classpath %q does not start with %q", i, javac.Args["classpath"], headerJar)
+		}
+	}
+
+	lib := foo.Module().(*Library)
+	if len(lib.classJarSpecs) != 2 {
+		t.Errorf("foo class jar specs %v != 2 shards", lib.classJarSpecs)
+	}
+}
+
 func TestSdk(t *testing.T) {
 	ctx := testJava(t, `
 		java_library {
@@ -200,6 +361,49 @@ func TestSdk(t *testing.T) {
 	check("foo1", "core-libart", bootclasspathLib)
 }
 
+func TestSystemModules(t *testing.T) {
+	ctx := testJavaArch(t, `
+		java_system_modules {
+			name: "core-system-modules",
+			libs: ["core-libart"],
+		}
+
+		java_library {
+			name: "foo",
+			srcs: ["a.java"],
+			java_version: "1.9",
+		}
+
+		java_library {
+			name: "bar",
+			srcs: ["b.java"],
+			java_version: "1.9",
+			system_modules: "none",
+		}
+		`)
+
+	systemModules := ctx.ModuleForTests("core-system-modules", "android_common").Rule("jarsToSystemModules")
+	coreLibart := filepath.Join(buildDir, ".intermediates", "core-libart", "android_common", "classes-full-debug.jar")
+	if len(systemModules.Inputs) != 1 || systemModules.Inputs[0].String() != coreLibart {
+		t.Errorf("core-system-modules inputs %v != [%q]", systemModules.Inputs, coreLibart)
+	}
+
+	systemDir := filepath.Join(buildDir, ".intermediates", "core-system-modules", "android_common", "system")
+	fooJavac := ctx.ModuleForTests("foo", "android_common").Rule("javac")
+	if fooJavac.Args["bootClasspath"] != "--system="+systemDir {
+		t.Errorf("foo bootclasspath %q != %q", fooJavac.Args["bootClasspath"], "--system="+systemDir)
+	}
+	modules := filepath.Join(systemDir, "lib", "modules")
+	if !inList(modules, fooJavac.Implicits.Strings()) {
+		t.Errorf("foo javac implicits %v do not contain %q", fooJavac.Implicits, modules)
+	}
+
+	barJavac := ctx.ModuleForTests("bar", "android_common").Rule("javac")
+	if barJavac.Args["bootClasspath"] != "--system=none" {
+		t.Errorf("bar bootclasspath %q != %q", barJavac.Args["bootClasspath"], "--system=none")
+	}
+}
+
 func TestPrebuilts(t *testing.T) {
 	ctx := testJava(t, `
 		java_library {
@@ -234,6 +438,138 @@ func TestPrebuilts(t *testing.T) {
 	}
 }
 
+func TestD8(t *testing.T) {
+	ctx := testJava(t, `
+		java_library {
+			name: "foo",
+			srcs: ["a.java"],
+			libs: ["bar"],
+			dxflags: ["--no-optimize", "--no-locals", "--min-sdk-version=21"],
+		}
+
+		java_library {
+			name: "bar",
+			srcs: ["b.java"],
+		}
+		`)
+
+	d8 := ctx.ModuleForTests("foo", "").Rule("d8")
+
+	bar := filepath.Join(buildDir, ".intermediates", "bar", "classes-header.jar")
+	for _, flag := range []string{"--debug", "--min-api 21", "--lib ", "--classpath " + bar} {
+		if !strings.Contains(d8.Args["d8Flags"], flag) {
+			t.Errorf("foo d8 flags %q do not contain %q", d8.Args["d8Flags"], flag)
+		}
+	}
+	if strings.Contains(d8.Args["d8Flags"], "--no-locals") {
+		t.Errorf("foo d8 flags %q contain the dx only flag --no-locals", d8.Args["d8Flags"])
+	}
+
+	if !inList(bar, d8.Implicits.Strings()) {
+		t.Errorf("foo d8 implicits %v do not contain %q", d8.Implicits, bar)
+	}
+}
+
+func TestImportDex(t *testing.T) {
+	ctx := testJavaArch(t, `
+		java_import {
+			name: "foo",
+			jars: ["a.jar"],
+			dex: true,
+		}
+
+		java_import {
+			name: "bar",
+			jars: ["b.jar"],
+		}
+		`)
+
+	d8 := ctx.ModuleForTests("foo", "android_common").Rule("d8")
+
+	classes := filepath.Join(buildDir, ".intermediates", "foo", "android_common", "classes-full-debug.jar")
+	if d8.Input == nil || d8.Input.String() != classes {
+		t.Errorf("foo d8 input %v != %q", d8.Input, classes)
+	}
+
+	foo := ctx.ModuleForTests("foo", "android_common").Module().(*Import)
+	javalib := filepath.Join(buildDir, ".intermediates", "foo", "android_common", "javalib.jar")
+	if foo.outputFile.String() != javalib {
+		t.Errorf("foo output %q != %q", foo.outputFile.String(), javalib)
+	}
+
+	bar := ctx.ModuleForTests("bar", "android_common").Module().(*Import)
+	if bar.outputFile != bar.combinedClasspathFile {
+		t.Errorf("bar output %q != %q", bar.outputFile, bar.combinedClasspathFile)
+	}
+}
+
+func TestImportUninstallable(t *testing.T) {
+	ctx := testJava(t, `
+		java_import {
+			name: "foo",
+			jars: ["a.jar"],
+		}
+
+		java_import {
+			name: "bar",
+			jars: ["b.jar"],
+			installable: false,
+		}
+		`)
+
+	const uninstallable = "LOCAL_UNINSTALLABLE_MODULE := true"
+
+	foo := androidMkExtra(ctx.ModuleForTests("foo", "").Module().(*Import).AndroidMk())
+	if strings.Contains(foo, uninstallable) {
+		t.Errorf("foo is marked uninstallable:\n%s", foo)
+	}
+
+	bar := androidMkExtra(ctx.ModuleForTests("bar", "").Module().(*Import).AndroidMk())
+	if !strings.Contains(bar, uninstallable) {
+		t.Errorf("bar is not marked uninstallable:\n%s", bar)
+	}
+}
+
+func TestJarjarRules(t *testing.T) {
+	ctx := testJava(t, `
+		java_library {
+			name: "foo",
+			srcs: ["a.java"],
+			static_libs: ["baz"],
+			jarjar_rules: "jarjar_rules.txt",
+		}
+
+		java_import {
+			name: "baz",
+			jars: ["a.jar"],
+			jarjar_rules: "jarjar_rules.txt",
+		}
+
+		java_library {
+			name: "bar",
+			srcs: ["b.java"],
+			libs: ["foo", "baz"],
+		}
+		`)
+
+	jarjar := ctx.ModuleForTests("foo", "").Rule("jarjar")
+	classes := filepath.Join(buildDir, ".intermediates", "foo", "classes-full-debug.jar")
+	if jarjar.Input == nil || jarjar.Input.String() != classes {
+		t.Errorf("foo jarjar input %v != %q", jarjar.Input, classes)
+	}
+	if jarjar.Args["rulesFile"] != "jarjar_rules.txt" {
+		t.Errorf("foo jarjar rules %q != jarjar_rules.txt", jarjar.Args["rulesFile"])
+	}
+
+	javac := ctx.ModuleForTests("bar", "").Rule("javac")
+	for _, dep := range []string{"foo", "baz"} {
+		jarjarJar := filepath.Join(buildDir, ".intermediates", dep, "classes-jarjar.jar")
+		if !strings.Contains(javac.Args["classpath"], jarjarJar) {
+			t.Errorf("bar classpath %v does not contain %q", javac.Args["classpath"], jarjarJar)
+		}
+	}
+}
+
 func TestDefaults(t *testing.T) {
 	ctx := testJava(t, `
 		java_defaults {
@@ -266,7 +602,7 @@ func TestDefaults(t *testing.T) {
 		t.Errorf(`foo inputs %v != ["a.java"]`, javac.Inputs)
 	}
 
-	bar := filepath.Join(buildDir, ".intermediates", "bar", "classes-full-debug.jar")
+	bar := filepath.Join(buildDir, ".intermediates", "bar", "classes-header.jar")
 	if !strings.Contains(javac.Args["classpath"], bar) {
 		t.Errorf("foo classpath %v does not contain %q", javac.Args["classpath"], bar)
 	}
@@ -277,11 +613,1846 @@ func TestDefaults(t *testing.T) {
 	}
 }
 
-func fail(t *testing.T, errs []error) {
-	if len(errs) > 0 {
-		for _, err := range errs {
-			t.Error(err)
+func TestSdkLibrary(t *testing.T) {
+	ctx := testJava(t, `
+		java_sdk_library {
+			name: "foo",
+			srcs: ["a.java"],
+			api_packages: ["foo"],
+		}
+
+		java_library {
+			name: "bar",
+			srcs: ["b.java"],
+			libs: ["foo"],
+			sdk_version: "current",
+		}
+
+		java_library {
+			name: "baz",
+			srcs: ["c.java"],
+			libs: ["foo"],
+			sdk_version: "system_current",
+		}
+		`)
+
+	checkClasspath := func(module, stubs string) {
+		javac := ctx.ModuleForTests(module, "").Rule("javac")
+		jar := filepath.Join(buildDir, ".intermediates", "foo", stubs)
+		if !strings.Contains(javac.Args["classpath"], jar) {
+			t.Errorf("%s classpath %v does not contain %q", module, javac.Args["classpath"], jar)
+		}
+	}
+
+	checkClasspath("bar", "stubs-public.jar")
+	checkClasspath("baz", "stubs-system.jar")
+
+	foo := ctx.ModuleForTests("foo", "")
+	apiCheck := foo.Rule("apiCheck")
+	if len(apiCheck.Inputs) != 4 || apiCheck.Inputs[0].String() != "api/current.txt" {
+		t.Errorf("foo api check inputs %v do not start with %q", apiCheck.Inputs, "api/current.txt")
+	}
+	if strings.Contains(apiCheck.Args["msg"], "\n") {
+		t.Errorf("foo api check message contains a newline: %q", apiCheck.Args["msg"])
+	}
+}
+
+func TestLogtags(t *testing.T) {
+	ctx := testJava(t, `
+		java_library {
+			name: "foo",
+			srcs: ["a.java", "d.logtags"],
+		}
+		`)
+
+	logtags := ctx.ModuleForTests("foo", "").Rule("logtags")
+	allLogtags := filepath.Join(buildDir, ".intermediates", "all-event-log-tags.txt")
+	if len(logtags.Implicits) != 1 || logtags.Implicits[0].String() != allLogtags {
+		t.Errorf("foo logtags implicits %v != [%q]", logtags.Implicits, allLogtags)
+	}
+
+	javac := ctx.ModuleForTests("foo", "").Rule("javac")
+	if len(javac.Inputs) != 2 || !strings.HasSuffix(javac.Inputs[1].String(), "d.java") {
+		t.Errorf("foo javac inputs %v do not contain the generated d.java", javac.Inputs)
+	}
+}
+
+func TestProto(t *testing.T) {
+	ctx := testJava(t, `
+		java_library {
+			name: "foo",
+			srcs: ["a.java", "e.proto"],
+		}
+
+		java_library {
+			name: "bar",
+			srcs: ["e.proto"],
+			proto: {
+				type: "nano",
+			},
+		}
+
+		filegroup {
+			name: "protos",
+			srcs: ["f.proto"],
+		}
+
+		java_library {
+			name: "baz",
+			srcs: ["a.java", ":protos"],
+		}
+
+		java_genrule {
+			name: "gen_proto",
+			tool_files: ["gen.sh"],
+			cmd: "$(location) $(out)",
+			out: ["g.proto"],
+		}
+
+		java_library {
+			name: "qux",
+			srcs: [":gen_proto"],
+			proto: {
+				type: "nano",
+			},
+		}
+
+		filegroup {
+			name: "javas",
+			srcs: ["b.java"],
+		}
+
+		java_library {
+			name: "quux",
+			srcs: [":javas"],
+		}
+		`)
+
+	headerJar := func(name string) string {
+		return ctx.ModuleForTests(name, "").Module().(*Library).HeaderJars().Strings()[0]
+	}
+	lite := headerJar("libprotobuf-java-lite")
+	nano := headerJar("libprotobuf-java-nano")
+
+	protoc := ctx.ModuleForTests("foo", "").Rule("protoc")
+	if protoc.Args["protoOut"] != "--javalite_out" {
+		t.Errorf("foo protoc output flag %q != --javalite_out", protoc.Args["protoOut"])
+	}
+
+	javac := ctx.ModuleForTests("foo", "").Rule("javac")
+	if !strings.Contains(javac.Args["javacFlags"], "@"+protoc.Output.String()) {
+		t.Errorf("foo javacFlags %v does not contain the generated source list %q",
+			javac.Args["javacFlags"], protoc.Output.String())
+	}
+
+	if !strings.Contains(javac.Args["classpath"], lite) {
+		t.Errorf("foo classpath %v does not contain %q", javac.Args["classpath"], lite)
+	}
+
+	barProtoc := ctx.ModuleForTests("bar", "").Rule("protoc")
+	if barProtoc.Args["protoOut"] != "--javanano_out" {
+		t.Errorf("bar protoc output flag %q != --javanano_out", barProtoc.Args["protoOut"])
+	}
+
+	barJavac := ctx.ModuleForTests("bar", "").Rule("javac")
+	if !strings.Contains(barJavac.Args["classpath"], nano) {
+		t.Errorf("bar classpath %v does not contain %q", barJavac.Args["classpath"], nano)
+	}
+
+	// Proto files from a filegroup
+	bazProtoc := ctx.ModuleForTests("baz", "").Rule("protoc")
+	if bazProtoc.Input.String() != "f.proto" {
+		t.Errorf("baz protoc input %q != %q", bazProtoc.Input.String(), "f.proto")
+	}
+	bazJavac := ctx.ModuleForTests("baz", "").Rule("javac")
+	if !strings.Contains(bazJavac.Args["classpath"], lite) {
+		t.Errorf("baz classpath %v does not contain %q", bazJavac.Args["classpath"], lite)
+	}
+
+	// Proto files generated by a genrule
+	quxProtoc := ctx.ModuleForTests("qux", "").Rule("protoc")
+	if quxProtoc.Args["protoOut"] != "--javanano_out" {
+		t.Errorf("qux protoc output flag %q != --javanano_out", quxProtoc.Args["protoOut"])
+	}
+	quxJavac := ctx.ModuleForTests("qux", "").Rule("javac")
+	if !strings.Contains(quxJavac.Args["classpath"], nano) {
+		t.Errorf("qux classpath %v does not contain %q", quxJavac.Args["classpath"], nano)
+	}
+
+	// A filegroup without proto files does not add the runtime library
+	quuxJavac := ctx.ModuleForTests("quux", "").Rule("javac")
+	if strings.Contains(quuxJavac.Args["classpath"], "libprotobuf") {
+		t.Errorf("quux classpath %v contains a protobuf runtime library", quuxJavac.Args["classpath"])
+	}
+}
+
+func TestResourceOverlays(t *testing.T) {
+	f := newJavaFixture(`
+		android_app {
+			name: "foo",
+			srcs: ["a.java"],
+		}
+
+		android_app {
+			name: "framework-res",
+			no_standard_libraries: true,
+			export_package_resources: true,
+		}
+		`)
+	f.AddFiles(
+		"AndroidManifest.xml",
+		"res/values/strings.xml",
+		"device/overlay/res/values/strings.xml",
+		"product/overlay/res/values/strings.xml",
+		"build/target/product/security/testkey.x509.pem",
+		"build/target/product/security/testkey.pk8",
+	)
+	f.Config.ProductVariables.ResourceOverlays = &[]string{"device/overlay", "product/overlay",
+		"missing/overlay"}
+	f.Prepare(t)
+
+	aapt := f.ModuleForTests("foo", "").Rule("aaptCreateResourceJavaFile")
+	expected := "-S device/overlay/res -S product/overlay/res -S res"
+	if !strings.Contains(aapt.Args["aaptFlags"], expected) {
+		t.Errorf("foo aapt flags %q do not contain %q", aapt.Args["aaptFlags"], expected)
+	}
+}
+
+func TestAppDefaults(t *testing.T) {
+	f := newJavaFixture(`
+		android_app_defaults {
+			name: "base_defaults",
+			certificate: "base",
+			privileged: false,
+			aaptflags: ["--base"],
+		}
+
+		android_app_defaults {
+			name: "device_defaults",
+			defaults: ["base_defaults"],
+			privileged: true,
+			aaptflags: ["--device"],
+		}
+
+		android_app_defaults {
+			name: "later_defaults",
+			certificate: "later",
+			aaptflags: ["--later"],
+		}
+
+		android_app {
+			name: "foo",
+			srcs: ["a.java"],
+			defaults: ["device_defaults", "later_defaults"],
+			aaptflags: ["--foo"],
+		}
+
+		android_app {
+			name: "bar",
+			srcs: ["b.java"],
+			defaults: ["device_defaults", "later_defaults"],
+			certificate: "bar",
+			privileged: false,
+		}
+
+		android_app {
+			name: "framework-res",
+			no_standard_libraries: true,
+			export_package_resources: true,
+		}
+		`)
+	f.AddFiles(
+		"AndroidManifest.xml",
+		"res/values/strings.xml",
+		"build/target/product/security/testkey.x509.pem",
+		"build/target/product/security/testkey.pk8",
+	)
+	f.Prepare(t)
+
+	foo := f.ModuleForTests("foo", "").Module().(*AndroidApp)
+	if !foo.Privileged() {
+		t.Errorf("foo is not privileged, device_defaults should override base_defaults")
+	}
+	if !strings.HasSuffix(foo.Certificate(), "/later") {
+		t.Errorf("foo certificate %q is not the later_defaults certificate", foo.Certificate())
+	}
+	aapt := f.ModuleForTests("foo", "").Rule("aaptCreateResourceJavaFile")
+	expected := "--base --device --later --foo"
+	if !strings.Contains(aapt.Args["aaptFlags"], expected) {
+		t.Errorf("foo aapt flags %q do not contain %q", aapt.Args["aaptFlags"], expected)
+	}
+
+	bar := f.ModuleForTests("bar", "").Module().(*AndroidApp)
+	if bar.Privileged() {
+		t.Errorf("bar is privileged, its own property should override the defaults")
+	}
+	if !strings.HasSuffix(bar.Certificate(), "/bar") {
+		t.Errorf("bar certificate %q is not its own certificate", bar.Certificate())
+	}
+}
+
+func TestProductAaptFlags(t *testing.T) {
+	f := newJavaFixture(`
+		android_app {
+			name: "foo",
+			srcs: ["a.java"],
+		}
+
+		android_app {
+			name: "bar",
+			srcs: ["b.java"],
+			aapt_include_all_resources: true,
+		}
+
+		android_app {
+			name: "baz",
+			srcs: ["c.java"],
+			aaptflags: ["-c en_US", "--product default"],
+		}
+
+		android_app {
+			name: "framework-res",
+			no_standard_libraries: true,
+			export_package_resources: true,
+		}
+		`)
+	f.AddFiles(
+		"AndroidManifest.xml",
+		"res/values/strings.xml",
+		"build/target/product/security/testkey.x509.pem",
+		"build/target/product/security/testkey.pk8",
+	)
+	aaptConfig := []string{"normal", "hdpi"}
+	preferred := "hdpi"
+	characteristics := "tablet"
+	debuggable := true
+	utf16 := true
+	f.Config.ProductVariables.AAPTConfig = &aaptConfig
+	f.Config.ProductVariables.AAPTPreferredConfig = &preferred
+	f.Config.ProductVariables.AAPTCharacteristics = &characteristics
+	f.Config.ProductVariables.Debuggable = &debuggable
+	f.Config.ProductVariables.AAPTUtf16 = &utf16
+	f.Prepare(t)
+
+	testCases := []struct {
+		name       string
+		expected   []string
+		unexpected []string
+	}{
+		{
+			name: "foo",
+			expected: []string{"--product tablet", "-c normal,hdpi,en_XA,ar_XB",
+				"--preferred-density hdpi", "--pseudo-localize", "--utf16"},
+		},
+		{
+			name:       "bar",
+			expected:   []string{"--product tablet", "--pseudo-localize", "--utf16"},
+			unexpected: []string{"-c ", "--preferred-density"},
+		},
+		{
+			name:       "baz",
+			expected:   []string{"-c en_US", "--product default", "--preferred-density hdpi"},
+			unexpected: []string{"-c normal", "--product tablet"},
+		},
+	}
+
+	for _, test := range testCases {
+		aapt := f.ModuleForTests(test.name, "").Rule("aaptAddResources")
+		flags := aapt.Args["aaptFlags"]
+		for _, e := range test.expected {
+			if !strings.Contains(flags, e) {
+				t.Errorf("%s aapt flags %q do not contain %q", test.name, flags, e)
+			}
+		}
+		for _, u := range test.unexpected {
+			if strings.Contains(flags, u) {
+				t.Errorf("%s aapt flags %q contain %q", test.name, flags, u)
+			}
+		}
+	}
+}
+
+func TestAppVersionName(t *testing.T) {
+	f := newJavaFixture(`
+		android_app {
+			name: "foo",
+			srcs: ["a.java"],
+		}
+
+		android_app {
+			name: "bar",
+			srcs: ["b.java"],
+			aaptflags: ["--version-name 1.0"],
+		}
+
+		android_app {
+			name: "framework-res",
+			no_standard_libraries: true,
+			export_package_resources: true,
+		}
+		`)
+	f.AddFiles(
+		"AndroidManifest.xml",
+		"res/values/strings.xml",
+		"build/target/product/security/testkey.x509.pem",
+		"build/target/product/security/testkey.pk8",
+	)
+	displayVersion := "15.1-bacon"
+	f.Config.ProductVariables.Lineage_display_version = &displayVersion
+	f.Prepare(t)
+
+	for _, test := range []struct {
+		name        string
+		versionName string
+	}{
+		{"foo", "--version-name 15.1-bacon"},
+		{"bar", "--version-name 1.0"},
+	} {
+		flags := f.ModuleForTests(test.name, "").Rule("aaptAddResources").Args["aaptFlags"]
+		if !strings.Contains(flags, test.versionName) {
+			t.Errorf("%s aapt flags %q do not contain %q", test.name, flags, test.versionName)
+		}
+		if strings.Count(flags, "--version-name") != 1 {
+			t.Errorf("%s aapt flags %q contain more than one version name", test.name, flags)
+		}
+	}
+}
+
+func TestIconPack(t *testing.T) {
+	f := newJavaFixture(`
+		android_app {
+			name: "foo",
+			srcs: ["a.java"],
+		}
+
+		android_app {
+			name: "bar",
+			srcs: ["b.java"],
+			icon_pack_name: "com.example.bar",
+		}
+
+		android_app {
+			name: "baz",
+			srcs: ["c.java"],
+		}
+
+		android_app {
+			name: "framework-res",
+			no_standard_libraries: true,
+			export_package_resources: true,
+		}
+		`)
+	f.AddFiles(
+		"AndroidManifest.xml",
+		"res/values/strings.xml",
+		"device/overlay/res/values/strings.xml",
+		"icons/foo/res/mipmap-hdpi/ic_launcher.png",
+		"icons/foo/res/mipmap-anydpi-v26/ic_launcher.xml",
+		"icons/foo/res/drawable/ic_launcher_foreground.xml",
+		"icons/com.example.bar/res/mipmap-hdpi/ic_launcher_round.png",
+		"build/target/product/security/testkey.x509.pem",
+		"build/target/product/security/testkey.pk8",
+	)
+	iconPack := "icons"
+	f.Config.ProductVariables.ResourceOverlays = &[]string{"device/overlay"}
+	f.Config.ProductVariables.AppIconPack = &iconPack
+	f.Prepare(t)
+
+	for _, test := range []struct{ name, expected string }{
+		{"foo", "-S icons/foo/res -S device/overlay/res -S res"},
+		{"bar", "-S icons/com.example.bar/res -S device/overlay/res -S res"},
+	} {
+		aapt := f.ModuleForTests(test.name, "").Rule("aaptCreateResourceJavaFile")
+		if !strings.Contains(aapt.Args["aaptFlags"], test.expected) {
+			t.Errorf("%s aapt flags %q do not contain %q", test.name, aapt.Args["aaptFlags"], test.expected)
+		}
+		if !strings.Contains(aapt.Args["aaptFlags"], "--auto-add-overlay") {
+			t.Errorf("%s aapt flags %q do not contain --auto-add-overlay", test.name, aapt.Args["aaptFlags"])
+		}
+	}
+
+	baz := f.ModuleForTests("baz", "").Rule("aaptCreateResourceJavaFile")
+	if strings.Contains(baz.Args["aaptFlags"], "icons/") {
+		t.Errorf("baz aapt flags %q contain an icon pack without icons for baz", baz.Args["aaptFlags"])
+	}
+}
+
+func TestIconPackErrors(t *testing.T) {
+	f := newJavaFixture(`
+		android_app {
+			name: "foo",
+			srcs: ["a.java"],
+		}
+
+		android_app {
+			name: "framework-res",
+			no_standard_libraries: true,
+			export_package_resources: true,
+		}
+		`)
+	f.AddFiles(
+		"AndroidManifest.xml",
+		"res/values/strings.xml",
+		"build/target/product/security/testkey.x509.pem",
+		"build/target/product/security/testkey.pk8",
+		"icons/foo/res/mipmap-hdpi/ic_launcher.png",
+		"icons/foo/res/values/strings.xml",
+	)
+	iconPack := "icons"
+	f.Config.ProductVariables.AppIconPack = &iconPack
+
+	android.FailIfNoMatchingErrors(t, `icon pack file "icons/foo/res/values/strings.xml" is not a launcher icon`,
+		f.PrepareWithErrors())
+}
+
+func TestAppAndroidMk(t *testing.T) {
+	f := newJavaArchFixture(`
+		android_app {
+			name: "foo",
+			srcs: ["a.java"],
+		}
+
+		android_app {
+			name: "bar",
+			srcs: ["b.java"],
+			dex_preopt: {
+				enabled: false,
+			},
+		}
+
+		android_app {
+			name: "framework-res",
+			no_standard_libraries: true,
+			export_package_resources: true,
+		}
+		`)
+	f.AddFiles(
+		"AndroidManifest.xml",
+		"res/values/strings.xml",
+		"build/target/product/security/testkey.x509.pem",
+		"build/target/product/security/testkey.pk8",
+	)
+	f.Prepare(t)
+
+	foo := androidMkEntries(f.ModuleForTests("foo", "android_common").Module().(*AndroidApp).AndroidMkEntries())
+	if v, ok := foo["LOCAL_DEX_PREOPT"]; ok {
+		t.Errorf("foo disables dexpreopt in Make with LOCAL_DEX_PREOPT := %v", v)
+	}
+	if !reflect.DeepEqual(foo["LOCAL_INSTALLED_MODULE_STEM"], []string{"foo.apk"}) {
+		t.Errorf("foo installed module stem %v != [foo.apk]", foo["LOCAL_INSTALLED_MODULE_STEM"])
+	}
+
+	bar := androidMkEntries(f.ModuleForTests("bar", "android_common").Module().(*AndroidApp).AndroidMkEntries())
+	if !reflect.DeepEqual(bar["LOCAL_DEX_PREOPT"], []string{"false"}) {
+		t.Errorf("bar LOCAL_DEX_PREOPT %v != [false]", bar["LOCAL_DEX_PREOPT"])
+	}
+}
+
+func TestWebViewImport(t *testing.T) {
+	f := newJavaArchFixture(`
+		android_webview_import {
+			name: "TrichromeChrome",
+			apk: "chrome.apk",
+			uses_libraries: ["TrichromeLibrary"],
+			overrides: ["Chrome"],
+		}
+		`)
+	f.AddFiles(
+		"chrome.apk",
+		"build/target/product/security/testkey.x509.pem",
+		"build/target/product/security/testkey.pk8",
+	)
+	f.Prepare(t)
+
+	m := f.ModuleForTests("TrichromeChrome", "android_arm64")
+	w := m.Module().(*WebViewImport)
+
+	signapk := m.Rule("signapk")
+	if w.outputFile != signapk.Output {
+		t.Errorf("TrichromeChrome output %q is not the signed apk %q", w.outputFile, signapk.Output)
+	}
+
+	if !strings.HasSuffix(w.installPath.String(), "/system/app/TrichromeChrome/TrichromeChrome.apk") {
+		t.Errorf("TrichromeChrome is installed to %q", w.installPath)
+	}
+
+	entries := androidMkEntries(w.AndroidMkEntries())
+	expected := map[string][]string{
+		"LOCAL_MODULE_SUFFIX":         {".apk"},
+		"LOCAL_INSTALLED_MODULE_STEM": {"TrichromeChrome.apk"},
+		"LOCAL_CERTIFICATE":           {"PRESIGNED"},
+		"LOCAL_OVERRIDES_PACKAGES":    {"Chrome"},
+	}
+	for name, value := range expected {
+		if !reflect.DeepEqual(entries[name], value) {
+			t.Errorf("TrichromeChrome %s %v != %v", name, entries[name], value)
+		}
+	}
+	if !strings.HasSuffix(entries["LOCAL_MODULE_PATH"][0], "/system/app/TrichromeChrome") {
+		t.Errorf("TrichromeChrome LOCAL_MODULE_PATH %v is not the app directory", entries["LOCAL_MODULE_PATH"])
+	}
+}
+
+func TestLineageSdk(t *testing.T) {
+	f := newJavaArchFixture(`
+		android_app {
+			name: "foo",
+			srcs: ["a.java"],
+			sdk_version: "lineage_current",
+		}
+
+		java_library {
+			name: "org.lineageos.platform.stubs",
+			srcs: ["b.java"],
+			sdk_version: "current",
+		}
+
+		android_app {
+			name: "org.lineageos.platform-res",
+			no_standard_libraries: true,
+			export_package_resources: true,
+		}
+
+		android_app {
+			name: "framework-res",
+			no_standard_libraries: true,
+			export_package_resources: true,
+		}
+		`)
+	f.AddFiles(
+		"AndroidManifest.xml",
+		"res/values/strings.xml",
+		"build/target/product/security/testkey.x509.pem",
+		"build/target/product/security/testkey.pk8",
+	)
+	f.Prepare(t)
+
+	foo := f.ModuleForTests("foo", "android_common")
+
+	javac := foo.Rule("javac")
+	stubs := f.ModuleForTests("android_stubs_current", "android_common").Module().(*Library).HeaderJars()
+	if javac.Args["bootClasspath"] != "-bootclasspath "+stubs.Strings()[0] {
+		t.Errorf("foo bootclasspath %q != %q", javac.Args["bootClasspath"], stubs)
+	}
+	lineageStubs := f.ModuleForTests("org.lineageos.platform.stubs", "android_common").Module().(*Library).
+		HeaderJars().Strings()[0]
+	if !strings.Contains(javac.Args["classpath"], lineageStubs) {
+		t.Errorf("foo classpath %q does not contain %q", javac.Args["classpath"], lineageStubs)
+	}
+
+	aapt := foo.Rule("aaptCreateResourceJavaFile")
+	for _, res := range []string{"framework-res", "org.lineageos.platform-res"} {
+		export := "-I " + filepath.Join(buildDir, ".intermediates", res, "android_common", "package-export.apk")
+		if !strings.Contains(aapt.Args["aaptFlags"], export) {
+			t.Errorf("foo aapt flags %q do not contain %q", aapt.Args["aaptFlags"], export)
+		}
+	}
+}
+
+func TestAppMetadata(t *testing.T) {
+	f := newJavaFixture(`
+		android_app {
+			name: "foo",
+			srcs: ["a.java"],
+			export_metadata: true,
+		}
+
+		android_app {
+			name: "bar",
+			srcs: ["b.java"],
+			privileged: true,
+		}
+
+		android_app {
+			name: "framework-res",
+			no_standard_libraries: true,
+			export_package_resources: true,
+		}
+		`)
+	f.AddFiles(
+		"AndroidManifest.xml",
+		"res/values/strings.xml",
+		"build/target/product/security/testkey.x509.pem",
+		"build/target/product/security/testkey.pk8",
+	)
+	f.Prepare(t)
+
+	foo := f.ModuleForTests("foo", "").Module().(*AndroidApp)
+	if foo.appMetadata() == nil {
+		t.Fatalf("foo does not export app metadata")
+	}
+	badging := f.ModuleForTests("foo", "").Rule("appBadging")
+	if badging.Args["module"] != "foo" || !strings.HasSuffix(badging.Args["devicePath"], "/app/foo.apk") {
+		t.Errorf("foo app metadata args %v do not describe foo.apk", badging.Args)
+	}
+
+	bar := f.ModuleForTests("bar", "").Module().(*AndroidApp)
+	if bar.appMetadata() != nil {
+		t.Errorf("bar exports app metadata %q without export_metadata", bar.appMetadata())
+	}
+	// The metadata of all apps is used by the permission audit
+	barBadging := f.ModuleForTests("bar", "").Rule("appBadging")
+	if !strings.HasSuffix(barBadging.Args["devicePath"], "/priv-app/bar.apk") {
+		t.Errorf("privileged app bar is installed to %q, not priv-app", barBadging.Args["devicePath"])
+	}
+}
+
+func TestStringFreeze(t *testing.T) {
+	f := newJavaArchFixture(`
+		android_app {
+			name: "foo",
+			srcs: ["a.java"],
+		}
+
+		android_app {
+			name: "framework-res",
+			no_standard_libraries: true,
+			export_package_resources: true,
+		}
+		`)
+	f.AddFiles(
+		"AndroidManifest.xml",
+		"res/values/strings.xml",
+		"res/values-de/strings.xml",
+		"res/drawable/icon.png",
+		"string-freeze.xml",
+		"build/target/product/security/testkey.x509.pem",
+		"build/target/product/security/testkey.pk8",
+	)
+	mode := "error"
+	f.Config.ProductVariables.StringFreeze = &mode
+	f.Prepare(t)
+
+	foo := f.ModuleForTests("foo", "android_common")
+	check := foo.Rule("checkStringFreeze")
+	if check.Input.String() != "string-freeze.xml" {
+		t.Errorf("foo strings are checked against %q, want string-freeze.xml", check.Input)
+	}
+	if len(check.Implicits) != 1 || check.Implicits[0].String() != "res/values/strings.xml" {
+		t.Errorf("foo string freeze check depends on %v, want [res/values/strings.xml]", check.Implicits)
+	}
+
+	// The check also runs when Make installs the apk
+	signed := foo.Rule("signapk")
+	if len(signed.Implicits) != 1 || signed.Implicits[0] != check.Output {
+		t.Errorf("foo apk depends on %v, want the string freeze stamp %s", signed.Implicits, check.Output)
+	}
+}
+
+func TestAppConflicts(t *testing.T) {
+	f := newJavaArchFixture(`
+		android_app {
+			name: "foo",
+			srcs: ["a.java"],
+			overrides: ["bar"],
+		}
+
+		android_app {
+			name: "bar",
+			srcs: ["b.java"],
+		}
+
+		android_app {
+			name: "baz",
+			srcs: ["c.java"],
+			overrides: ["qux"],
+		}
+
+		android_app {
+			name: "disabled",
+			srcs: ["c.java"],
+			enabled: false,
+		}
+
+		android_app {
+			name: "framework-res",
+			no_standard_libraries: true,
+			export_package_resources: true,
+		}
+		`)
+	f.AddFiles(
+		"AndroidManifest.xml",
+		"res/values/strings.xml",
+		"build/target/product/security/testkey.x509.pem",
+		"build/target/product/security/testkey.pk8",
+	)
+	f.Prepare(t)
+
+	// Apps that are not exported to Make, like the apps in namespaces that the product doesn't
+	// use, can't conflict with the installed apps
+	f.ModuleForTests("baz", "android_common").Module().SkipInstall()
+
+	apps, overrides := appConflictsInputs(f.Context)
+	var names []string
+	for _, app := range apps {
+		names = append(names, filepath.Base(filepath.Dir(filepath.Dir(app))))
+	}
+	sort.Strings(names)
+	if want := []string{"bar", "foo", "framework-res"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("checked apps %q, want %q", names, want)
+	}
+	if want := []string{"foo:bar"}; !reflect.DeepEqual(overrides, want) {
+		t.Errorf("checked overrides %q, want %q", overrides, want)
+	}
+}
+
+func TestPlatformCompatConfig(t *testing.T) {
+	ctx := testJavaArch(t, `
+		java_library {
+			name: "foo",
+			srcs: ["a.java"],
+		}
+
+		platform_compat_config {
+			name: "foo-compat-config",
+			src: ":foo",
+		}
+		`)
+
+	foo := ctx.ModuleForTests("foo", "android_common").Module().(*Library)
+	config := ctx.ModuleForTests("foo-compat-config", "android_common").Output("foo-compat-config.xml")
+	if config.Input.String() != foo.ClasspathFiles()[0].String() {
+		t.Errorf("foo-compat-config input %q != %q", config.Input, foo.ClasspathFiles()[0])
+	}
+
+	m := ctx.ModuleForTests("foo-compat-config", "android_common").Module().(*PlatformCompatConfig)
+	if !strings.HasSuffix(m.installPath.String(), "/system/etc/compatconfig/foo-compat-config.xml") {
+		t.Errorf("foo-compat-config is installed to %q", m.installPath)
+	}
+}
+
+func TestPlatformCompatConfigErrors(t *testing.T) {
+	f := newJavaArchFixture(`
+		java_import {
+			name: "foo",
+			jars: ["a.jar", "b.jar"],
+		}
+
+		platform_compat_config {
+			name: "foo-compat-config",
+			src: ":foo",
+		}
+		`)
+
+	android.FailIfNoMatchingErrors(t, `":foo" must produce exactly one classes jar, found 2`,
+		f.PrepareWithErrors())
+}
+
+func TestJavaResources(t *testing.T) {
+	f := newJavaFixture(`
+		java_library {
+			name: "foo",
+			srcs: [":foo_srcs"],
+			java_resources: ["res/a.txt", ":foo_res"],
+			exclude_java_resources: ["shared/res/c.txt"],
+		}
+
+		filegroup {
+			name: "foo_srcs",
+			srcs: ["a.java", "b.java"],
+		}
+
+		filegroup {
+			name: "foo_res",
+			srcs: ["shared/res/**/*.txt"],
+			path: "shared/res",
+		}
+		`)
+	f.AddFiles(
+		"res/a.txt",
+		"shared/res/b/b.txt",
+		"shared/res/c.txt",
+	)
+	f.Prepare(t)
+
+	foo := f.ModuleForTests("foo", "")
+	javac := foo.Rule("javac")
+	if len(javac.Inputs) != 2 || javac.Inputs[0].String() != "a.java" || javac.Inputs[1].String() != "b.java" {
+		t.Errorf("foo inputs %v != [a.java b.java]", javac.Inputs)
+	}
+
+	resources := foo.Output("java_resources.jarArgs")
+	expected := []string{"-C . res/a.txt", "-C shared/res b/b.txt"}
+	if g, w := resources.Args["content"], strings.Join(expected, `\n`); g != w {
+		t.Errorf("foo java resources jar args %q != %q", g, w)
+	}
+
+	jar := foo.Output("classes-full-debug.jar")
+	if !strings.Contains(jar.Args["jarArgs"], "@"+resources.Output.String()) {
+		t.Errorf("foo jarArgs %q do not contain java resources %q", jar.Args["jarArgs"], resources.Output)
+	}
+}
+
+func TestJavaLibrarySnapshot(t *testing.T) {
+	f := newJavaFixture(`
+		java_library {
+			name: "foo",
+			srcs: ["a.java"],
+			libs: ["bar"],
+			java_resources: ["res.txt"],
+		}
+
+		java_library {
+			name: "bar",
+			srcs: ["b.java"],
+		}
+		`)
+	f.AddFiles("res.txt")
+	f.Prepare(t)
+
+	android.CheckGoldenFile(t, "testdata/java_library_snapshot.txt", f.RuleSnapshot("foo"))
+}
+
+func TestTaggedModuleReferences(t *testing.T) {
+	f := newJavaFixture(`
+		android_app {
+			name: "app",
+			srcs: ["a.java"],
+		}
+
+		java_library {
+			name: "lib",
+			srcs: ["b.java"],
+		}
+
+		java_genrule {
+			name: "tool",
+			tool_files: ["gen.sh"],
+			cmd: "$(location) $(out)",
+			out: ["tool.sh"],
+		}
+
+		java_genrule {
+			name: "gen",
+			srcs: [":app{.apk}", ":lib{.hjar}", ":lib"],
+			tool_files: [":tool"],
+			cmd: "$(location) $(in) > $(out)",
+			out: ["out.txt"],
+		}
+
+		android_app {
+			name: "framework-res",
+			no_standard_libraries: true,
+			export_package_resources: true,
+		}
+	`)
+	f.AddFiles(
+		"gen.sh",
+		"AndroidManifest.xml",
+		"res/values/strings.xml",
+		"build/target/product/security/testkey.x509.pem",
+		"build/target/product/security/testkey.pk8",
+	)
+	f.Prepare(t)
+
+	app := f.ModuleForTests("app", "").Module().(*AndroidApp).outputFile
+	lib := f.ModuleForTests("lib", "").Module().(*Library)
+	tool := f.ModuleForTests("tool", "").Output("tool.sh").Outputs[0]
+	gen := f.ModuleForTests("gen", "").Output("out.txt")
+
+	// The references select the outputs of the modules by their tags
+	expected := []string{app.String(), lib.HeaderJars()[0].String(), lib.outputFile.String()}
+	if !reflect.DeepEqual(gen.Inputs.Strings(), expected) {
+		t.Errorf("gen inputs %q, want %q", gen.Inputs.Strings(), expected)
+	}
+
+	// A module reference in tool_files is the tool that $(location) runs
+	if !reflect.DeepEqual(gen.Implicits.Strings(), []string{tool.String()}) {
+		t.Errorf("gen implicits %q, want %q", gen.Implicits.Strings(), tool)
+	}
+}
+
+func TestTaggedModuleReferenceErrors(t *testing.T) {
+	testCases := []struct {
+		name, srcs, err string
+	}{
+		{
+			name: "unsupported tag",
+			srcs: `":lib{.apk}"`,
+			err:  `srcs dependency ":lib{.apk}": unsupported module reference tag ".apk"`,
+		},
+		{
+			name: "resources not exported",
+			srcs: `":app{.export-package.apk}"`,
+			err:  "app does not export its resources, set export_package_resources",
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			f := newJavaFixture(`
+				android_app {
+					name: "app",
+					srcs: ["a.java"],
+				}
+
+				java_library {
+					name: "lib",
+					srcs: ["b.java"],
+				}
+
+				java_genrule {
+					name: "gen",
+					srcs: [` + test.srcs + `],
+					tool_files: ["gen.sh"],
+					cmd: "$(location) $(in) > $(out)",
+					out: ["out.txt"],
+				}
+
+				android_app {
+					name: "framework-res",
+					no_standard_libraries: true,
+					export_package_resources: true,
+				}
+			`)
+			f.AddFiles("gen.sh", "AndroidManifest.xml", "res/values/strings.xml")
+			android.FailIfNoMatchingErrors(t, test.err, f.PrepareWithErrors())
+		})
+	}
+}
+
+func TestJniTargets(t *testing.T) {
+	arm64 := android.Target{Os: android.Android, Arch: android.Arch{ArchType: android.Arm64, Abi: []string{"arm64-v8a"}}}
+	arm := android.Target{Os: android.Android, Arch: android.Arch{ArchType: android.Arm, Abi: []string{"armeabi-v7a"}}}
+
+	testCases := []struct {
+		multilib      string
+		deviceTargets []android.Target
+		targets       []android.Target
+		err           bool
+	}{
+		{"first", []android.Target{arm64, arm}, []android.Target{arm64}, false},
+		{"both", []android.Target{arm64, arm}, []android.Target{arm64, arm}, false},
+		{"32", []android.Target{arm64, arm}, []android.Target{arm}, false},
+		{"64", []android.Target{arm64, arm}, []android.Target{arm64}, false},
+		{"first", []android.Target{arm}, []android.Target{arm}, false},
+		{"both", []android.Target{arm}, []android.Target{arm}, false},
+		{"64", []android.Target{arm}, nil, true},
+		{"prefer32", []android.Target{arm64, arm}, nil, true},
+	}
+
+	for _, testCase := range testCases {
+		targets, err := jniTargets(testCase.multilib, testCase.deviceTargets)
+		if (err != nil) != testCase.err {
+			t.Errorf("jni_multilib %q on %v: unexpected error %v", testCase.multilib, testCase.deviceTargets, err)
+		}
+		if !reflect.DeepEqual(targets, testCase.targets) {
+			t.Errorf("jni_multilib %q on %v: expected %v, got %v", testCase.multilib,
+				testCase.deviceTargets, testCase.targets, targets)
+		}
+	}
+}
+
+func TestJniLibsSdkVariant(t *testing.T) {
+	f := setupJavaFixture(cc.NewTestFixture(buildDir), `
+		android_app {
+			name: "sdk_app",
+			srcs: ["a.java"],
+			sdk_version: "current",
+			jni_libs: ["libsdk", "libplatform"],
+			jni_multilib: "first",
+		}
+
+		android_app {
+			name: "platform_app",
+			srcs: ["a.java"],
+			jni_libs: ["libsdk", "libplatform"],
+			jni_multilib: "first",
+		}
+
+		cc_library_shared {
+			name: "libsdk",
+			srcs: ["foo.c"],
+			sdk_version: "21",
+			system_shared_libs: [],
+			stl: "none",
+			nocrt: true,
+		}
+
+		cc_library_shared {
+			name: "libplatform",
+			srcs: ["foo.c"],
+			system_shared_libs: [],
+			stl: "none",
+			nocrt: true,
+		}
+
+		ndk_prebuilt_library {
+			name: "libc.ndk.21",
+			sdk_version: "21",
+		}
+
+		ndk_prebuilt_library {
+			name: "libm.ndk.21",
+			sdk_version: "21",
+		}
+
+		ndk_prebuilt_library {
+			name: "libdl.ndk.21",
+			sdk_version: "21",
+		}
+
+		android_app {
+			name: "framework-res",
+			no_standard_libraries: true,
+			export_package_resources: true,
+		}
+		`)
+	f.Config.Targets[android.Device][0].Arch.Abi = []string{"arm64-v8a"}
+	f.Config.Targets[android.Device][1].Arch.Abi = []string{"armeabi-v7a"}
+	for _, arch := range []string{"arm", "arm64"} {
+		for _, lib := range []string{"libc", "libm", "libdl"} {
+			f.AddFiles("prebuilts/ndk/current/platforms/android-21/arch-" + arch + "/usr/lib/" + lib + ".so")
+		}
+	}
+	f.AddFiles(
+		"foo.c",
+		"AndroidManifest.xml",
+		"res/values/strings.xml",
+		"build/target/product/security/testkey.x509.pem",
+		"build/target/product/security/testkey.pk8",
+	)
+	f.Prepare(t)
+
+	libVariant := func(name, variant string) string {
+		return f.ModuleForTests(name, variant).Module().(*cc.Module).OutputFile().String()
+	}
+	libsdk := libVariant("libsdk", "android_arm64_armv8-a_shared_core_sdk")
+	libsdkPlatform := libVariant("libsdk", "android_arm64_armv8-a_shared_core")
+	libplatform := libVariant("libplatform", "android_arm64_armv8-a_shared_core")
+
+	// The app built against the SDK packages the variant of libsdk built against the NDK, and the
+	// platform variant of libplatform, which doesn't set sdk_version
+	testCases := []struct {
+		app  string
+		libs []string
+	}{
+		{"sdk_app", []string{libsdk, libplatform}},
+		{"platform_app", []string{libsdkPlatform, libplatform}},
+	}
+	for _, test := range testCases {
+		jni := f.ModuleForTests(test.app, "android_common").Output("jni.apk")
+		if !reflect.DeepEqual(jni.Implicits.Strings(), test.libs) {
+			t.Errorf("%s packages %q, want %q", test.app, jni.Implicits.Strings(), test.libs)
+		}
+		for _, lib := range test.libs {
+			if !strings.Contains(jni.Args["copyCommands"], "cp -f "+lib+" ") {
+				t.Errorf("%s does not copy %q into the apk: %q", test.app, lib, jni.Args["copyCommands"])
+			}
+		}
+	}
+}
+
+func TestJniLibsPackaging(t *testing.T) {
+	f := setupJavaFixture(cc.NewTestFixture(buildDir), `
+		android_app {
+			name: "app",
+			srcs: ["a.java"],
+			jni_libs: ["libfoo"],
+			jni_multilib: "both",
+		}
+
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			system_shared_libs: [],
+			stl: "none",
+			nocrt: true,
+		}
+
+		android_app {
+			name: "framework-res",
+			no_standard_libraries: true,
+			export_package_resources: true,
+		}
+		`)
+	f.Config.Targets[android.Device][0].Arch.Abi = []string{"arm64-v8a"}
+	f.Config.Targets[android.Device][1].Arch.Abi = []string{"armeabi-v7a"}
+	f.AddFiles(
+		"foo.c",
+		"AndroidManifest.xml",
+		"res/values/strings.xml",
+		"build/target/product/security/testkey.x509.pem",
+		"build/target/product/security/testkey.pk8",
+	)
+	f.Prepare(t)
+
+	app := f.ModuleForTests("app", "android_common")
+	jni := app.Output("jni.apk")
+	jniDir := jni.Args["jniDir"]
+
+	// Every variant of the library is staged in the lib directory of its ABI, which is added to the
+	// apk as a whole
+	for _, test := range []struct {
+		variant, abi string
+	}{
+		{"android_arm64_armv8-a_shared_core", "arm64-v8a"},
+		{"android_arm_armv7-a-neon_shared_core", "armeabi-v7a"},
+	} {
+		lib := f.ModuleForTests("libfoo", test.variant).Module().(*cc.Module).OutputFile().String()
+		copy := "cp -f " + lib + " " + filepath.Join(jniDir, "lib", test.abi) + "/"
+		if !strings.Contains(jni.Args["copyCommands"], copy) {
+			t.Errorf("app does not stage %q for %s: %q", lib, test.abi, jni.Args["copyCommands"])
+		}
+	}
+
+	// The apk with the jni libs is the one that is signed
+	signed := app.Rule("signapk")
+	if signed.Input.String() != jni.Output.String() {
+		t.Errorf("app signs %q, want %q", signed.Input, jni.Output)
+	}
+}
+
+func TestAidlIncludeDirs(t *testing.T) {
+	f := newJavaFixture(`
+		java_library {
+			name: "foo",
+			srcs: ["a.java", "IFoo.aidl"],
+			libs: ["bar", "baz"],
+		}
+
+		java_library {
+			name: "bar",
+			srcs: ["b.java"],
+			aidl: {
+				local_include_dirs: ["aidl"],
+				include_dirs: ["frameworks/aidl"],
+				export_aidl_headers: true,
+			},
+		}
+
+		java_library {
+			name: "baz",
+			srcs: ["c.java"],
+			aidl: {
+				local_include_dirs: ["baz_aidl"],
+			},
+		}
+		`)
+	f.AddFiles("IFoo.aidl", "aidl/IBar.aidl", "frameworks/aidl/IFramework.aidl", "baz_aidl/IBaz.aidl")
+	f.Prepare(t)
+
+	// Only the modules that set export_aidl_headers export their aidl include paths, both the local
+	// ones and the ones relative to the root of the tree
+	testCases := []struct {
+		name string
+		dirs []string
+	}{
+		{"bar", []string{"aidl", "frameworks/aidl"}},
+		{"baz", nil},
+	}
+	for _, test := range testCases {
+		dirs := f.ModuleForTests(test.name, "").Module().(*Library).AidlIncludeDirs().Strings()
+		if !reflect.DeepEqual(dirs, test.dirs) {
+			t.Errorf("%s exports aidl include dirs %q, want %q", test.name, dirs, test.dirs)
+		}
+	}
+
+	// The generated java file is compiled
+	aidl := f.ModuleForTests("foo", "").Rule("aidl")
+	javac := f.ModuleForTests("foo", "").Rule("javac")
+	if !inList(aidl.Output.String(), javac.Inputs.Strings()) {
+		t.Errorf("foo doesn't compile %q: %q", aidl.Output, javac.Inputs)
+	}
+}
+
+func TestJavaGenrule(t *testing.T) {
+	ctx := testJava(t, `
+		java_library {
+			name: "foo",
+			srcs: ["a.java", ":gen_srcs"],
+			static_libs: ["gen_jar"],
+		}
+
+		java_genrule {
+			name: "gen_srcs",
+			tool_files: ["gen.sh"],
+			cmd: "$(location) $(out)",
+			out: ["gen.java"],
+		}
+
+		java_genrule {
+			name: "gen_jar",
+			tool_files: ["gen.sh"],
+			cmd: "$(location) $(out)",
+			out: ["gen.jar"],
+		}
+		`)
+
+	javac := ctx.ModuleForTests("foo", "").Rule("javac")
+	genJava := filepath.Join(buildDir, ".intermediates", "gen_srcs", "gen", "gen.java")
+	if len(javac.Inputs) != 2 || javac.Inputs[1].String() != genJava {
+		t.Errorf("foo inputs %v != [a.java %s]", javac.Inputs, genJava)
+	}
+
+	genJar := filepath.Join(buildDir, ".intermediates", "gen_jar", "gen", "gen.jar")
+	if !strings.Contains(javac.Args["classpath"], genJar) {
+		t.Errorf("foo classpath %v does not contain %q", javac.Args["classpath"], genJar)
+	}
+
+	jar := ctx.ModuleForTests("foo", "").Rule("jar")
+	genJarClasses := filepath.Join(buildDir, ".intermediates", "gen_jar", "extracted0", "classes.list")
+	if !strings.Contains(jar.Args["jarArgs"], genJarClasses) {
+		t.Errorf("foo jarArgs %v does not contain %q", jar.Args["jarArgs"], genJarClasses)
+	}
+}
+
+func TestDroiddoc(t *testing.T) {
+	ctx := testJava(t, `
+		droiddoc {
+			name: "foo-docs",
+			srcs: ["a.java", "b.java"],
+			libs: ["bar"],
+			api_filename: "foo-api.txt",
+			args: "-hide 111",
+		}
+
+		java_library {
+			name: "bar",
+			srcs: ["c.java"],
+		}
+		`)
+
+	doc := ctx.ModuleForTests("foo-docs", "").Rule("droiddoc")
+
+	if len(doc.Inputs) != 2 || doc.Inputs[0].String() != "a.java" || doc.Inputs[1].String() != "b.java" {
+		t.Errorf(`foo-docs inputs %v != ["a.java", "b.java"]`, doc.Inputs)
+	}
+
+	bar := filepath.Join(buildDir, ".intermediates", "bar", "classes-header.jar")
+	if !strings.Contains(doc.Args["classpath"], bar) {
+		t.Errorf("foo-docs classpath %v does not contain %q", doc.Args["classpath"], bar)
+	}
+
+	apiFile := filepath.Join(buildDir, ".intermediates", "foo-docs", "foo-api.txt")
+	if !strings.Contains(doc.Args["opts"], "-api "+apiFile) || !strings.Contains(doc.Args["opts"], "-hide 111") {
+		t.Errorf("foo-docs opts %q do not contain the api file and args", doc.Args["opts"])
+	}
+
+	stubs := filepath.Join(buildDir, ".intermediates", "foo-docs", "foo-docs-stubs.srcjar")
+	if doc.Args["stubsSrcJar"] != stubs {
+		t.Errorf("foo-docs stubs srcjar %q != %q", doc.Args["stubsSrcJar"], stubs)
+	}
+}
+
+func TestDroidstubs(t *testing.T) {
+	ctx := testJava(t, `
+		droidstubs {
+			name: "foo-stubs",
+			srcs: ["a.java"],
+			check_api: {
+				current: {
+					api_file: "api/current.txt",
+					removed_api_file: "api/removed.txt",
+				},
+				baseline_file: "api/lint-baseline.txt",
+				api_lint: true,
+			},
+		}
+		`)
+
+	foo := ctx.ModuleForTests("foo-stubs", "")
+
+	metalava := foo.Rule("metalava")
+	for _, opt := range []string{"--check-compatibility:api:current api/current.txt",
+		"--check-compatibility:removed:current api/removed.txt",
+		"--api-lint", "--baseline api/lint-baseline.txt"} {
+		if !strings.Contains(metalava.Args["opts"], opt) {
+			t.Errorf("foo-stubs metalava opts %q do not contain %q", metalava.Args["opts"], opt)
+		}
+	}
+
+	check := foo.Rule("checkApiFiles")
+	apiFile := filepath.Join(buildDir, ".intermediates", "foo-stubs", "foo-stubs_api.txt")
+	if check.Args["currentApiFile"] != "api/current.txt" || check.Args["apiFile"] != apiFile {
+		t.Errorf("foo-stubs checks %q against %q, expected %q against %q",
+			check.Args["apiFile"], check.Args["currentApiFile"], apiFile, "api/current.txt")
+	}
+	if strings.Contains(check.Args["msg"], "\n") {
+		t.Errorf("foo-stubs api check message contains a newline: %q", check.Args["msg"])
+	}
+
+	foo.Rule("updateApiFiles")
+}
+
+func TestIdeInfo(t *testing.T) {
+	ctx := testJava(t, `
+		java_library {
+			name: "foo",
+			srcs: ["a.java"],
+			libs: ["bar"],
+		}
+
+		java_library {
+			name: "bar",
+			srcs: ["b.java"],
+		}
+		`)
+
+	info := &ideInfo{}
+	ctx.ModuleForTests("foo", "").Module().(*Library).ideInfo(info)
+
+	if !reflect.DeepEqual(info.Srcs, []string{"a.java"}) {
+		t.Errorf(`foo srcs %q != ["a.java"]`, info.Srcs)
+	}
+
+	if !inList("bar", info.Dependencies) {
+		t.Errorf("foo dependencies %q do not contain bar", info.Dependencies)
+	}
+
+	bar := filepath.Join(buildDir, ".intermediates", "bar", "classes-header.jar")
+	if !inList(bar, info.Jars) {
+		t.Errorf("foo jars %q do not contain %q", info.Jars, bar)
+	}
+}
+
+func TestLintStrict(t *testing.T) {
+	f := newJavaFixture(`
+		android_app {
+			name: "platform",
+			srcs: ["a.java"],
+		}
+
+		android_app {
+			name: "current",
+			srcs: ["a.java"],
+			sdk_version: "current",
+		}
+
+		android_app {
+			name: "old",
+			srcs: ["a.java"],
+			sdk_version: "14",
+		}
+
+		android_app {
+			name: "recent",
+			srcs: ["a.java"],
+			sdk_version: "28",
+		}
+
+		java_library {
+			name: "sdk_v28",
+			no_standard_libraries: true,
+		}
+
+		android_app {
+			name: "framework-res",
+			no_standard_libraries: true,
+			export_package_resources: true,
+		}
+	`)
+	f.AddFiles(
+		"AndroidManifest.xml",
+		"res/values/strings.xml",
+		"build/target/product/security/testkey.x509.pem",
+		"build/target/product/security/testkey.pk8",
+	)
+	f.Prepare(t)
+
+	for _, test := range []struct {
+		name   string
+		strict bool
+	}{
+		{"platform", false},
+		{"current", true},
+		{"old", false},
+		{"recent", true},
+	} {
+		lintFlags := f.ModuleForTests(test.name, "").Rule("lint").Args["lintFlags"]
+		if strict := strings.Contains(lintFlags, "--exitcode"); strict != test.strict {
+			t.Errorf("%s: expected strict lint %v, got lint flags %q", test.name, test.strict,
+				lintFlags)
+		}
+	}
+}
+
+func TestLintAnnoSrcJar(t *testing.T) {
+	f := newJavaFixture(`
+		android_app {
+			name: "foo",
+			srcs: ["a.java"],
+			plugins: ["processor"],
+		}
+
+		java_plugin {
+			name: "processor",
+			srcs: ["b.java"],
+		}
+
+		android_app {
+			name: "framework-res",
+			no_standard_libraries: true,
+			export_package_resources: true,
+		}
+	`)
+	f.AddFiles(
+		"AndroidManifest.xml",
+		"res/values/strings.xml",
+		"build/target/product/security/testkey.x509.pem",
+		"build/target/product/security/testkey.pk8",
+	)
+	f.Prepare(t)
+
+	foo := f.ModuleForTests("foo", "")
+	annoSrcJar := foo.Output("anno.srcjar").Output.String()
+
+	lint := foo.Output("lint-report.xml")
+	if !inList(annoSrcJar, lint.Implicits.Strings()) {
+		t.Errorf("expected lint inputs to contain %q, got %q", annoSrcJar, lint.Implicits.Strings())
+	}
+
+	// The generated sources are extracted into a directory listed in the project description
+	srcJars := foo.Output("srcjars.stamp")
+	if !inList(annoSrcJar, srcJars.Inputs.Strings()) {
+		t.Errorf("expected lint srcjars %q, got %q", annoSrcJar, srcJars.Inputs.Strings())
+	}
+	projectXml := foo.Output("project.xml").Args["content"]
+	if src := `<src file="` + srcJars.Args["outDir"] + `" />`; !strings.Contains(projectXml, src) {
+		t.Errorf("expected lint project.xml to contain %q, got %q", src, projectXml)
+	}
+}
+
+func TestJava9(t *testing.T) {
+	for _, test := range []struct {
+		javaVersion string
+		java9       bool
+	}{
+		{"1.7", false},
+		{"1.8", false},
+		{"1.9", true},
+		{"9", true},
+		{"11", true},
+		{"10", true},
+	} {
+		ctx := testJava(t, fmt.Sprintf(`
+			java_library {
+				name: "foo",
+				srcs: ["a.java"],
+				java_version: %q,
+			}
+			`, test.javaVersion))
+
+		foo := ctx.ModuleForTests("foo", "").Module().(*Library)
+		if java9 := foo.java9(); java9 != test.java9 {
+			t.Errorf("java_version %q: expected java9 %v, got %v", test.javaVersion, test.java9, java9)
+		}
+	}
+}
+
+func TestAppTmpDirs(t *testing.T) {
+	f := newJavaFixture(`
+		android_app {
+			name: "foo",
+			srcs: ["a.java"],
+		}
+
+		android_app {
+			name: "framework-res",
+			no_standard_libraries: true,
+			export_package_resources: true,
+		}
+	`)
+	f.AddFiles(
+		"AndroidManifest.xml",
+		"res/values/strings.xml",
+		"build/target/product/security/testkey.x509.pem",
+		"build/target/product/security/testkey.pk8",
+	)
+	f.Prepare(t)
+
+	// aapt and signapk write scratch files, which must not collide between apps
+	for _, test := range []struct {
+		name, rule string
+	}{
+		{"foo", "aaptCreateResourceJavaFile"},
+		{"foo", "aaptAddResources"},
+		{"foo", "signapk"},
+		{"framework-res", "aaptCreateAssetsPackage"},
+	} {
+		params := f.ModuleForTests(test.name, "").Rule(test.rule)
+		output := params.Output
+		if output == nil {
+			output = params.Outputs[0]
+		}
+		if g, w := params.Args["tmpDir"], output.String()+".tmpdir"; g != w {
+			t.Errorf("expected %s %s tmpDir %q, got %q", test.name, test.rule, w, g)
+		}
+	}
+}
+
+func TestPluginAnnoSrcJar(t *testing.T) {
+	ctx := testJavaArch(t, `
+		java_library {
+			name: "foo",
+			srcs: ["a.java"],
+			plugins: ["processor"],
+		}
+
+		java_library {
+			name: "bar",
+			srcs: ["b.java"],
+		}
+
+		java_plugin {
+			name: "processor",
+			srcs: ["c.java"],
+		}
+		`)
+
+	foo := ctx.ModuleForTests("foo", "android_common")
+	annoSrcJar := foo.Output("anno.srcjar")
+
+	// The srcjar is described to IDE project generators
+	var info ideInfo
+	foo.Module().(*Library).ideInfo(&info)
+	if g, w := info.Srcjars, []string{annoSrcJar.Output.String()}; !reflect.DeepEqual(g, w) {
+		t.Errorf("expected foo srcjars %q, got %q", w, g)
+	}
+
+	info = ideInfo{}
+	ctx.ModuleForTests("bar", "android_common").Module().(*Library).ideInfo(&info)
+	if len(info.Srcjars) > 0 {
+		t.Errorf("bar has srcjars without plugins: %q", info.Srcjars)
+	}
+}
+
+func TestJacoco(t *testing.T) {
+	f := newJavaArchFixture(`
+		java_library {
+			name: "foo",
+			srcs: ["a.java"],
+			jacoco: {
+				include_filter: ["com.android.foo.*"],
+				exclude_filter: ["com.android.foo.Bar$Inner"],
+			},
+		}
+
+		java_library {
+			name: "jacocoagent",
+			srcs: ["b.java"],
+		}
+
+		android_app {
+			name: "app",
+			srcs: ["c.java"],
+		}
+
+		android_app {
+			name: "framework-res",
+			no_standard_libraries: true,
+			export_package_resources: true,
+		}
+	`)
+	f.AddFiles(
+		"AndroidManifest.xml",
+		"res/values/strings.xml",
+		"build/target/product/security/testkey.x509.pem",
+		"build/target/product/security/testkey.pk8",
+	)
+	f.SetEnv("EMMA_INSTRUMENT", "true")
+	f.Prepare(t)
+
+	foo := f.ModuleForTests("foo", "android_common")
+	jacoco := foo.Rule("jacoco")
+	if g, w := jacoco.Args["includes"], "'com/android/foo/*'"; g != w {
+		t.Errorf("expected foo jacoco includes %q, got %q", w, g)
+	}
+	if g, w := jacoco.Args["excludes"], "-x 'com/android/foo/Bar$$Inner.class'"; g != w {
+		t.Errorf("expected foo jacoco excludes %q, got %q", w, g)
+	}
+	if d8 := foo.Rule("d8"); d8.Input != jacoco.Output {
+		t.Errorf("foo dexes %q, not the instrumented classes %q", d8.Input, jacoco.Output)
+	}
+
+	// The jacoco runtime isn't instrumented, and apps include it
+	for _, p := range f.ModuleForTests("jacocoagent", "android_common").Module().BuildParamsForTests() {
+		if p.Rule == jacoco.Rule {
+			t.Errorf("jacocoagent is instrumented")
+		}
+	}
+	app := f.ModuleForTests("app", "android_common").Module().(*AndroidApp)
+	if !inList("jacocoagent", app.compiledJavaModules) {
+		t.Errorf("app doesn't include jacocoagent: %q", app.compiledJavaModules)
+	}
+}
+
+func TestJacocoErrors(t *testing.T) {
+	f := newJavaArchFixture(`
+		java_library {
+			name: "foo",
+			srcs: ["a.java"],
+			jacoco: {
+				include_filter: ["com/android/foo"],
+			},
+		}
+	`)
+	f.SetEnv("EMMA_INSTRUMENT", "true")
+	android.FailIfNoMatchingErrors(t, `invalid class filter "com/android/foo"`, f.PrepareWithErrors())
+}
+
+func TestBootJars(t *testing.T) {
+	f := newJavaArchFixture(`
+		java_library {
+			name: "foo",
+			srcs: ["a.java"],
+		}
+
+		java_import {
+			name: "bar",
+			jars: ["a.jar"],
+		}
+
+		java_import {
+			name: "baz",
+			jars: ["b.jar"],
+			dex: true,
+		}
+		`)
+	f.AddFiles("a.jar", "b.jar")
+	f.Prepare(t)
+
+	// Only the device modules that install a dex jar can be on the boot classpath
+	installed := make(map[string]android.Path)
+	for _, name := range []string{"foo", "bar", "baz"} {
+		producer := f.ModuleForTests(name, "android_common").Module().(installedDexJarProducer)
+		if dexJar := producer.installedDexJar(); dexJar != nil {
+			installed[name] = dexJar
+		}
+	}
+	if len(installed) != 2 || installed["foo"] == nil || installed["baz"] == nil {
+		t.Errorf("expected installed dex jars for foo and baz, got %v", installed)
+	}
+
+	// The jars that are only built by Make are not checked
+	defined := map[string]bool{"foo": true, "bar": true, "baz": true}
+	configured, built, builtJars := bootJarsToCheck([]string{"make_jar", "foo", "baz", "bar"}, defined,
+		installed)
+	if w := []string{"bar", "baz", "foo"}; !reflect.DeepEqual(configured, w) {
+		t.Errorf("expected configured jars %q, got %q", w, configured)
+	}
+	if w := []string{"baz", "foo"}; !reflect.DeepEqual(built, w) {
+		t.Errorf("expected built jars %q, got %q", w, built)
+	}
+	if w := []string{installed["foo"].String(), installed["baz"].String()}; !reflect.DeepEqual(builtJars, w) {
+		t.Errorf("expected dex jars %q, got %q", w, builtJars)
+	}
+}
+
+func TestBootJarsErrors(t *testing.T) {
+	f := newJavaArchFixture(`
+		java_library {
+			name: "foo",
+			srcs: ["a.java"],
+		}
+		`)
+	f.RegisterSingletonType("boot_jars_check", BootJarsCheckSingleton)
+	f.Config.ProductVariables.BootJars = &[]string{"foo"}
+	f.Config.ProductVariables.SystemServerJars = &[]string{"services", "foo"}
+	android.FailIfNoMatchingErrors(t, `PRODUCT_SYSTEM_SERVER_JARS: jar "foo" is already listed in PRODUCT_BOOT_JARS`,
+		f.PrepareWithErrors())
+}
+
+func TestDexpreoptDexCrcs(t *testing.T) {
+	f := newJavaArchFixture(`
+		android_app {
+			name: "foo",
+			srcs: ["a.java"],
+		}
+
+		android_app {
+			name: "bar",
+			srcs: ["a.java"],
+			dex_preopt: {
+				enabled: false,
+			},
+		}
+
+		android_app {
+			name: "framework-res",
+			no_standard_libraries: true,
+			export_package_resources: true,
+		}
+	`)
+	f.AddFiles(
+		"AndroidManifest.xml",
+		"res/values/strings.xml",
+		"build/target/product/security/testkey.x509.pem",
+		"build/target/product/security/testkey.pk8",
+	)
+	f.Prepare(t)
+
+	// The CRCs are read from the signed apk, and recorded under its path on the device
+	foo := f.ModuleForTests("foo", "android_common")
+	crcs := foo.Rule("recordDexCrcs")
+	if apk := foo.Module().(*AndroidApp).outputFile; crcs.Input.String() != apk.String() {
+		t.Errorf("foo records the dex crcs of %q, want %q", crcs.Input, apk)
+	}
+	if crcs.Args["devicePath"] != "/system/app/foo.apk" {
+		t.Errorf("foo records the dex crcs for %q, want /system/app/foo.apk", crcs.Args["devicePath"])
+	}
+	if p := foo.Module().(dexCrcsProducer).dexCrcs(); p == nil || p.String() != crcs.Output.String() {
+		t.Errorf("foo provides the dex crcs %v, want %q", p, crcs.Output)
+	}
+
+	// Apps that disable dex_preopt are not verified after re-signing
+	if p := f.ModuleForTests("bar", "android_common").Module().(dexCrcsProducer).dexCrcs(); p != nil {
+		t.Errorf("bar records its dex crcs in %q with dex_preopt disabled", p)
+	}
+}
+
+func TestHostdex(t *testing.T) {
+	ctx := testJava(t, `
+		java_library {
+			name: "foo",
+			srcs: ["a.java"],
+			hostdex: true,
+		}
+
+		java_library {
+			name: "bar",
+			srcs: ["b.java"],
+		}
+
+		java_sdk_library {
+			name: "baz",
+			srcs: ["c.java"],
+			api_packages: ["baz"],
+			hostdex: true,
+		}
+		`)
+
+	androidMk := func(m android.Module) string {
+		data := m.(android.AndroidMkDataProvider).AndroidMk()
+		if data.Custom == nil {
+			return ""
+		}
+		buf := &bytes.Buffer{}
+		data.Custom(buf, m.Name(), "", "", data)
+		return buf.String()
+	}
+
+	foo := ctx.ModuleForTests("foo", "").Module().(*Library)
+	fooMk := androidMk(foo)
+	for _, line := range []string{
+		"LOCAL_MODULE := foo-hostdex\n",
+		"LOCAL_IS_HOST_MODULE := true\n",
+		"LOCAL_PREBUILT_MODULE_FILE := " + foo.dexJarFile.String() + "\n",
+	} {
+		if !strings.Contains(fooMk, line) {
+			t.Errorf("foo Android.mk doesn't contain %q:\n%s", line, fooMk)
+		}
+	}
+
+	if barMk := androidMk(ctx.ModuleForTests("bar", "").Module()); strings.Contains(barMk, "hostdex") {
+		t.Errorf("bar without hostdex has a hostdex module:\n%s", barMk)
+	}
+
+	// The hostdex module of an sdk library is written along with its permissions file
+	bazMk := androidMk(ctx.ModuleForTests("baz", "").Module())
+	for _, line := range []string{"LOCAL_MODULE := baz-hostdex\n", "LOCAL_MODULE := baz.xml\n"} {
+		if !strings.Contains(bazMk, line) {
+			t.Errorf("baz Android.mk doesn't contain %q:\n%s", line, bazMk)
 		}
-		t.FailNow()
 	}
 }
@@ -0,0 +1,151 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package java
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/google/blueprint"
+
+	"android/soong/android"
+)
+
+// This singleton writes out/soong/module_bp_java_deps.json when SOONG_GEN_IDEA=1 is set, which
+// describes the java libraries and apps to IntelliJ and Android Studio project generators.  For
+// every module it lists the class, the directory, the java sources including the generated ones,
+// the srcjars of the sources generated by annotation processors, the jars on the classpath and
+// the java modules it depends on, so that the generated projects resolve the same classes as the
+// build:
+//
+//   {
+//     "Settings": {
+//       "class": ["APPS"],
+//       "path": ["packages/apps/Settings"],
+//       "srcs": ["packages/apps/Settings/src/com/android/settings/Settings.java", ...],
+//       "srcjars": ["out/soong/.intermediates/packages/apps/Settings/Settings/android_common/anno.srcjar"],
+//       "jars": ["out/soong/.intermediates/frameworks/base/framework/android_common/classes.jar", ...],
+//       "dependencies": ["framework", ...]
+//     }
+//   }
+
+func init() {
+	android.RegisterSingletonType("jdeps_generator", jDepsGeneratorSingleton)
+}
+
+func jDepsGeneratorSingleton() blueprint.Singleton {
+	return &jdepsGeneratorSingleton{}
+}
+
+type jdepsGeneratorSingleton struct{}
+
+const (
+	jdepsJSONFilename = "module_bp_java_deps.json"
+
+	// Environment variable used to enable this singleton.
+	envVariableGenerateIdea = "SOONG_GEN_IDEA"
+)
+
+type ideInfo struct {
+	Class        []string `json:"class"`
+	Path         []string `json:"path"`
+	Srcs         []string `json:"srcs"`
+	Srcjars      []string `json:"srcjars"`
+	Jars         []string `json:"jars"`
+	Dependencies []string `json:"dependencies"`
+}
+
+// ideInfoProvider is implemented by the java modules that are described to IDE project generators.
+type ideInfoProvider interface {
+	ideInfo(info *ideInfo)
+}
+
+func (j *Module) ideInfo(info *ideInfo) {
+	info.Srcs = append(info.Srcs, j.compiledJavaSrcs.Strings()...)
+	if j.annoSrcJar != nil {
+		info.Srcjars = append(info.Srcjars, j.annoSrcJar.String())
+	}
+	info.Jars = append(info.Jars, j.compiledClasspath.Strings()...)
+	info.Dependencies = append(info.Dependencies, j.compiledJavaModules...)
+}
+
+func (j *Import) ideInfo(info *ideInfo) {
+	info.Jars = append(info.Jars, j.classpathFiles.Strings()...)
+}
+
+func (j *jdepsGeneratorSingleton) GenerateBuildActions(ctx blueprint.SingletonContext) {
+	if !ctx.Config().(android.Config).IsEnvTrue(envVariableGenerateIdea) {
+		return
+	}
+
+	infos := make(map[string]*ideInfo)
+	ctx.VisitAllModules(func(module blueprint.Module) {
+		provider, ok := module.(ideInfoProvider)
+		if !ok {
+			return
+		}
+		if m, ok := module.(android.Module); ok && !m.Enabled() {
+			return
+		}
+
+		name := ctx.ModuleName(module)
+		info := infos[name]
+		if info == nil {
+			info = &ideInfo{}
+			infos[name] = info
+		}
+
+		class := "JAVA_LIBRARIES"
+		if _, ok := module.(*AndroidApp); ok {
+			class = "APPS"
+		}
+		info.Class = append(info.Class, class)
+		info.Path = append(info.Path, ctx.ModuleDir(module))
+		provider.ideInfo(info)
+	})
+
+	for _, info := range infos {
+		for _, list := range []*[]string{&info.Class, &info.Path, &info.Srcs, &info.Srcjars,
+			&info.Jars, &info.Dependencies} {
+
+			*list = sortedUniqueStrings(*list)
+		}
+	}
+
+	data, err := json.MarshalIndent(infos, "", "  ")
+	if err != nil {
+		ctx.Errorf("failed to encode %s: %s", jdepsJSONFilename, err)
+		return
+	}
+
+	jdeps := android.PathForOutput(ctx, jdepsJSONFilename)
+	if ctx.Failed() {
+		return
+	}
+	android.WriteFileRule(ctx, jdeps, data)
+}
+
+// sortedUniqueStrings returns the sorted list without duplicates, or an empty list, which is
+// written as [] instead of null.
+func sortedUniqueStrings(list []string) []string {
+	ret := []string{}
+	sort.Strings(list)
+	for i, s := range list {
+		if i == 0 || s != list[i-1] {
+			ret = append(ret, s)
+		}
+	}
+	return ret
+}
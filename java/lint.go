@@ -0,0 +1,333 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package java
+
+// This file runs Android Lint on android apps, and collects the lint reports of all modules
+// into a single zip file.
+
+import (
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/google/blueprint"
+	"github.com/google/blueprint/proptools"
+
+	"android/soong/android"
+)
+
+func init() {
+	android.RegisterSingletonType("lint", LintSingleton)
+
+	pctx.IntermediatesPathVariable("lintReportZip", "lint-report.zip")
+
+	android.RegisterMakeVarsProvider(pctx, lintMakeVarsProvider)
+}
+
+var (
+	// lint runs Android Lint on the project described by $in.  Lint only fails the build in
+	// strict mode, where $lintFlags contains --exitcode.
+	lint = pctx.AndroidStaticRule("lint",
+		blueprint.RuleParams{
+			Command: `${config.LintCmd} --quiet --project $in --xml $out --html $html --text $text ` +
+				`$lintFlags || (cat $text >&2; exit 1)`,
+			CommandDeps: []string{"${config.LintCmd}"},
+		},
+		"html", "text", "lintFlags")
+
+	// lintSrcJars extracts the srcjars of generated sources into a directory that is listed in
+	// the project description, as lint only reads sources from files and directories.
+	lintSrcJars = pctx.AndroidStaticRule("lintSrcJars",
+		blueprint.RuleParams{
+			Command: `rm -rf $outDir && mkdir -p $outDir && ` +
+				`for jar in $in; do unzip -qo "$$jar" -d $outDir; done && touch $out`,
+		},
+		"outDir")
+
+	lintReportZip = pctx.AndroidStaticRule("lintReportZip",
+		blueprint.RuleParams{
+			Command:     `${config.SoongZipCmd} -o $out $zipArgs`,
+			CommandDeps: []string{"${config.SoongZipCmd}"},
+		},
+		"zipArgs")
+)
+
+// Modules that target this SDK version or newer are linted in strict mode, where lint errors
+// fail the build.
+const lintStrictSdkVersion = 26
+
+const defaultLintBaselineFilename = "lint-baseline.xml"
+
+type LintProperties struct {
+	Lint struct {
+		// if set to false, lint is not run on the module.  Defaults to true.
+		Enabled *bool
+
+		// list of extra flags that will be passed to lint
+		Flags []string
+
+		// list of lint checks that will be reported as errors
+		Error_checks []string
+
+		// list of lint checks that will be reported as warnings
+		Warning_checks []string
+
+		// list of lint checks that will not be run
+		Disabled_checks []string
+
+		// name of the file in the module directory listing the existing lint issues that are
+		// not reported.  Defaults to lint-baseline.xml if that file exists.
+		Baseline_filename *string
+	}
+}
+
+type lintOutputs struct {
+	xml  android.WritablePath
+	html android.WritablePath
+	text android.WritablePath
+}
+
+// linter holds the inputs to lint collected while building a module.
+type linter struct {
+	properties LintProperties
+
+	manifest  android.Path
+	resources android.Paths
+	srcs      android.Paths
+	classes   android.Path
+	classpath android.Paths
+
+	// srcjars of generated sources, like the sources generated by annotation processors
+	srcJars android.Paths
+
+	// the files in the resource directories, which lint has to be rerun for when they change
+	resourceFiles android.Paths
+
+	sdkVersion string
+
+	outputs *lintOutputs
+}
+
+// lintReportProducer is implemented by modules that run lint, to provide the reports for the
+// aggregated lint report zip.
+type lintReportProducer interface {
+	lintReports() android.Paths
+}
+
+func (l *linter) enabled() bool {
+	return proptools.BoolDefault(l.properties.Lint.Enabled, true)
+}
+
+// strict returns true if lint errors should fail the build, which is the case for modules that
+// target the current SDK or a recent SDK version.  Modules built against the platform, without
+// an sdk_version, are only reported.
+func (l *linter) strict() bool {
+	switch l.sdkVersion {
+	case "":
+		return false
+	case "current", "system_current", "lineage_current":
+		return true
+	}
+	version, err := strconv.Atoi(l.sdkVersion)
+	return err == nil && version >= lintStrictSdkVersion
+}
+
+func (l *linter) lint(ctx android.ModuleContext) {
+	if !l.enabled() || l.manifest == nil || l.classes == nil {
+		return
+	}
+
+	checks := make(map[string]string)
+	checkLists := []struct {
+		property string
+		checks   []string
+	}{
+		{"lint.error_checks", l.properties.Lint.Error_checks},
+		{"lint.warning_checks", l.properties.Lint.Warning_checks},
+		{"lint.disabled_checks", l.properties.Lint.Disabled_checks},
+	}
+	for _, list := range checkLists {
+		for _, check := range list.checks {
+			if other, exists := checks[check]; exists {
+				ctx.PropertyErrorf(list.property, "check %q is also listed in %s", check, other)
+			}
+			checks[check] = list.property
+		}
+	}
+	if ctx.Failed() {
+		return
+	}
+
+	var flags []string
+	deps := android.Paths{l.manifest, l.classes}
+	deps = append(deps, l.resourceFiles...)
+	deps = append(deps, l.srcs...)
+	deps = append(deps, l.srcJars...)
+	deps = append(deps, l.classpath...)
+
+	if len(l.properties.Lint.Error_checks) > 0 {
+		flags = append(flags, "--error "+strings.Join(l.properties.Lint.Error_checks, ","))
+	}
+	if len(l.properties.Lint.Warning_checks) > 0 {
+		flags = append(flags, "--warning "+strings.Join(l.properties.Lint.Warning_checks, ","))
+	}
+	if len(l.properties.Lint.Disabled_checks) > 0 {
+		flags = append(flags, "--disable "+strings.Join(l.properties.Lint.Disabled_checks, ","))
+	}
+
+	if l.properties.Lint.Baseline_filename != nil {
+		baseline := android.PathForModuleSrc(ctx, *l.properties.Lint.Baseline_filename)
+		flags = append(flags, "--baseline "+baseline.String())
+		deps = append(deps, baseline)
+	} else if baseline := android.ExistentPathForSource(ctx, "", ctx.ModuleDir(),
+		defaultLintBaselineFilename); baseline.Valid() {
+		flags = append(flags, "--baseline "+baseline.String())
+		deps = append(deps, baseline.Path())
+	}
+
+	if l.strict() {
+		flags = append(flags, "--exitcode")
+	}
+
+	flags = append(flags, l.properties.Lint.Flags...)
+
+	var srcJarDir android.Path
+	if len(l.srcJars) > 0 {
+		dir := android.PathForModuleOut(ctx, "lint", "srcjars")
+		stamp := android.PathForModuleOut(ctx, "lint", "srcjars.stamp")
+		ctx.ModuleBuild(pctx, android.ModuleBuildParams{
+			Rule:        lintSrcJars,
+			Description: "lint srcjars",
+			Output:      stamp,
+			Inputs:      l.srcJars,
+			Args: map[string]string{
+				"outDir": dir.String(),
+			},
+		})
+		srcJarDir = dir
+		deps = append(deps, stamp)
+	}
+
+	projectXml := l.writeProjectXml(ctx, srcJarDir)
+
+	outputs := &lintOutputs{
+		xml:  android.PathForModuleOut(ctx, "lint", ctx.ModuleName(), "lint-report.xml"),
+		html: android.PathForModuleOut(ctx, "lint", ctx.ModuleName(), "lint-report.html"),
+		text: android.PathForModuleOut(ctx, "lint", ctx.ModuleName(), "lint-report.txt"),
+	}
+
+	ctx.ModuleBuild(pctx, android.ModuleBuildParams{
+		Rule:            lint,
+		Description:     "lint",
+		Output:          outputs.xml,
+		ImplicitOutputs: android.WritablePaths{outputs.html, outputs.text},
+		Input:           projectXml,
+		Implicits:       deps,
+		Args: map[string]string{
+			"html":      outputs.html.String(),
+			"text":      outputs.text.String(),
+			"lintFlags": strings.Join(flags, " "),
+		},
+	})
+
+	l.outputs = outputs
+
+	if l.strict() {
+		// Lint errors in strict mode should break the build, not just the lint report zip
+		ctx.CheckbuildFile(outputs.xml)
+	}
+}
+
+// writeProjectXml writes the project description that tells lint where to find the sources,
+// resources and classes of the module.  The sources extracted from the srcjars are listed through
+// srcJarDir, if it is not nil.
+func (l *linter) writeProjectXml(ctx android.ModuleContext, srcJarDir android.Path) android.Path {
+	content := []string{
+		`<?xml version="1.0" encoding="utf-8"?>`,
+		`<project>`,
+		`<module name="` + ctx.ModuleName() + `" android="true" library="false">`,
+		`  <manifest file="` + l.manifest.String() + `" />`,
+	}
+	for _, src := range l.srcs {
+		content = append(content, `  <src file="`+src.String()+`" />`)
+	}
+	if srcJarDir != nil {
+		content = append(content, `  <src file="`+srcJarDir.String()+`" />`)
+	}
+	for _, resource := range l.resources {
+		content = append(content, `  <resource file="`+resource.String()+`" />`)
+	}
+	content = append(content, `  <classes jar="`+l.classes.String()+`" />`)
+	for _, jar := range l.classpath {
+		content = append(content, `  <classpath jar="`+jar.String()+`" />`)
+	}
+	content = append(content, `</module>`, `</project>`)
+
+	projectXml := android.PathForModuleOut(ctx, "lint", "project.xml")
+	ctx.ModuleBuild(pctx, android.ModuleBuildParams{
+		Rule:        android.WriteFile,
+		Description: "lint project.xml",
+		Output:      projectXml,
+		Args: map[string]string{
+			"content": strings.Join(content, `\n`),
+		},
+	})
+
+	return projectXml
+}
+
+func (l *linter) lintReports() android.Paths {
+	if l.outputs == nil {
+		return nil
+	}
+	return android.Paths{l.outputs.xml, l.outputs.html, l.outputs.text}
+}
+
+func LintSingleton() blueprint.Singleton {
+	return &lintSingleton{}
+}
+
+type lintSingleton struct{}
+
+// GenerateBuildActions zips the lint reports of all modules into a single file, with the reports
+// of each module in a directory named after the module.
+func (l *lintSingleton) GenerateBuildActions(ctx blueprint.SingletonContext) {
+	var reports []string
+	var zipArgs []string
+	ctx.VisitAllModules(func(module blueprint.Module) {
+		if producer, ok := module.(lintReportProducer); ok {
+			for _, report := range producer.lintReports() {
+				// The reports are in lint/<module name>/ in the module's output directory
+				lintDir := filepath.Dir(filepath.Dir(report.String()))
+				reports = append(reports, report.String())
+				zipArgs = append(zipArgs, "-C "+lintDir+" -f "+report.String())
+			}
+		}
+	})
+
+	ctx.Build(pctx, blueprint.BuildParams{
+		Rule:        lintReportZip,
+		Description: "lint report zip",
+		Outputs:     []string{"$lintReportZip"},
+		Implicits:   reports,
+		Args: map[string]string{
+			"zipArgs": strings.Join(zipArgs, " "),
+		},
+	})
+}
+
+func lintMakeVarsProvider(ctx android.MakeVarsContext) {
+	ctx.Strict("SOONG_LINT_REPORT_ZIP", "${lintReportZip}")
+}
@@ -0,0 +1,184 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package java
+
+// This file contains the platform_compat_config module type, which generates the compat config of
+// the changes declared with @ChangeId in a java module compiled with the
+// compat-changeid-annotation-processor plugin, and installs it to etc/compatconfig where the
+// platform compat service reads it.  The compat_config singleton merges the configs of all
+// modules into the platform compat config, which also checks that no change id is declared twice.
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+
+	"github.com/google/blueprint"
+
+	"android/soong/android"
+)
+
+func init() {
+	android.RegisterModuleType("platform_compat_config", PlatformCompatConfigFactory)
+	android.RegisterSingletonType("compat_config", CompatConfigSingleton)
+
+	pctx.SourcePathVariable("compatConfigCmd", "build/soong/scripts/compat_config.py")
+	pctx.IntermediatesPathVariable("mergedCompatConfig", "compat_config/merged_compat_config.xml")
+
+	android.RegisterMakeVarsProvider(pctx, compatConfigMakeVarsProvider)
+}
+
+var (
+	extractCompatConfig = pctx.AndroidStaticRule("extractCompatConfig",
+		blueprint.RuleParams{
+			Command:     `$compatConfigCmd extract $in $out`,
+			CommandDeps: []string{"$compatConfigCmd"},
+		})
+
+	mergeCompatConfig = pctx.AndroidStaticRule("mergeCompatConfig",
+		blueprint.RuleParams{
+			Command:        `$compatConfigCmd merge $out @$out.rsp`,
+			CommandDeps:    []string{"$compatConfigCmd"},
+			Rspfile:        "$out.rsp",
+			RspfileContent: "$in_newline",
+		})
+)
+
+var compatConfigTag = dependencyTag{name: "compat config"}
+
+type platformCompatConfigProperties struct {
+	// the java module whose classes jar contains the compat config written by the
+	// compat-changeid-annotation-processor plugin, as ":module"
+	Src *string
+}
+
+type PlatformCompatConfig struct {
+	android.ModuleBase
+
+	properties platformCompatConfigProperties
+
+	configFile  android.Path
+	installPath android.OutputPath
+}
+
+func PlatformCompatConfigFactory() android.Module {
+	module := &PlatformCompatConfig{}
+	module.AddProperties(&module.properties)
+	android.InitAndroidArchModule(module, android.DeviceSupported, android.MultilibCommon)
+	return module
+}
+
+func (p *PlatformCompatConfig) DepsMutator(ctx android.BottomUpMutatorContext) {
+	if p.properties.Src == nil {
+		return
+	}
+	if m := android.SrcIsModule(*p.properties.Src); m != "" {
+		ctx.AddDependency(ctx.Module(), compatConfigTag, m)
+	}
+}
+
+func (p *PlatformCompatConfig) GenerateAndroidBuildActions(ctx android.ModuleContext) {
+	if p.properties.Src == nil {
+		ctx.PropertyErrorf("src", "missing java module")
+		return
+	}
+	if android.SrcIsModule(*p.properties.Src) == "" {
+		ctx.PropertyErrorf("src", "%q is not a java module, use \":module\"", *p.properties.Src)
+		return
+	}
+
+	var jars android.Paths
+	var failed bool
+	ctx.VisitDirectDeps(func(module blueprint.Module) {
+		if ctx.OtherModuleDependencyTag(module) != compatConfigTag {
+			return
+		}
+		if dep, ok := module.(Dependency); ok {
+			jars = append(jars, dep.ClasspathFiles()...)
+		} else {
+			ctx.PropertyErrorf("src", "%q is not a java module", ctx.OtherModuleName(module))
+			failed = true
+		}
+	})
+	if failed {
+		return
+	}
+	if len(jars) != 1 {
+		ctx.PropertyErrorf("src", "%q must produce exactly one classes jar, found %d",
+			*p.properties.Src, len(jars))
+		return
+	}
+
+	configFile := android.PathForModuleOut(ctx, ctx.ModuleName()+".xml")
+	ctx.ModuleBuild(pctx, android.ModuleBuildParams{
+		Rule:        extractCompatConfig,
+		Description: "compat config " + ctx.ModuleName(),
+		Output:      configFile,
+		Input:       jars[0],
+	})
+
+	p.configFile = configFile
+	p.installPath = ctx.InstallFile(android.PathForModuleInstall(ctx, "etc", "compatconfig"), configFile)
+}
+
+// CompatConfig returns the compat config generated from the java module, or nil if there were
+// errors.
+func (p *PlatformCompatConfig) CompatConfig() android.Path {
+	return p.configFile
+}
+
+func (p *PlatformCompatConfig) AndroidMk() android.AndroidMkData {
+	return android.AndroidMkData{
+		Class:      "ETC",
+		OutputFile: android.OptionalPathForPath(p.configFile),
+		Extra: []android.AndroidMkExtraFunc{
+			func(w io.Writer, outputFile android.Path) {
+				fmt.Fprintln(w, "LOCAL_MODULE_PATH := $(OUT_DIR)/"+filepath.Dir(p.installPath.RelPathString()))
+				fmt.Fprintln(w, "LOCAL_INSTALLED_MODULE_STEM := "+outputFile.Base())
+			},
+		},
+	}
+}
+
+func CompatConfigSingleton() blueprint.Singleton {
+	return &compatConfigSingleton{}
+}
+
+type compatConfigSingleton struct{}
+
+// GenerateBuildActions merges the compat configs of all platform_compat_config modules into the
+// platform compat config.
+func (s *compatConfigSingleton) GenerateBuildActions(ctx blueprint.SingletonContext) {
+	var configs []string
+	ctx.VisitAllModules(func(module blueprint.Module) {
+		if m, ok := module.(android.Module); !ok || !m.Enabled() {
+			return
+		}
+		if m, ok := module.(*PlatformCompatConfig); ok && m.CompatConfig() != nil {
+			configs = append(configs, m.CompatConfig().String())
+		}
+	})
+
+	ctx.Build(pctx, blueprint.BuildParams{
+		Rule:        mergeCompatConfig,
+		Description: "merge compat config",
+		Outputs:     []string{"$mergedCompatConfig"},
+		Inputs:      configs,
+	})
+}
+
+func compatConfigMakeVarsProvider(ctx android.MakeVarsContext) {
+	ctx.Strict("SOONG_PLATFORM_COMPAT_CONFIG", "${mergedCompatConfig}")
+}
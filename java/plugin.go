@@ -0,0 +1,46 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package java
+
+import "android/soong/android"
+
+func init() {
+	android.RegisterModuleType("java_plugin", PluginFactory)
+}
+
+type pluginProperties struct {
+	// The optional name of the class that javac will use to run the annotation processor.  If
+	// not set, javac finds the processor through META-INF/services in the plugin jar.
+	Processor_class *string
+}
+
+// Plugin describes a java_plugin module, a host java library that will be used by javac as an
+// annotation processor by modules that list it in their plugins property.
+type Plugin struct {
+	Library
+
+	pluginProperties pluginProperties
+}
+
+func PluginFactory() android.Module {
+	module := &Plugin{}
+
+	module.AddProperties(
+		&module.Module.properties,
+		&module.pluginProperties)
+
+	InitJavaModule(module, android.HostSupported)
+	return module
+}
@@ -0,0 +1,152 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package java
+
+import (
+	"strings"
+
+	"github.com/google/blueprint"
+	"github.com/google/blueprint/proptools"
+
+	"android/soong/android"
+)
+
+func init() {
+	pctx.HostBinToolVariable("protocCmd", "aprotoc")
+	pctx.HostBinToolVariable("protocGenJavaliteCmd", "protoc-gen-javalite")
+}
+
+var (
+	// protoc generates an unknown number of java files for each proto file, depending on its
+	// options and contents, so generate them into a directory and list them in a file that is
+	// passed to javac.
+	proto = pctx.AndroidStaticRule("protoc",
+		blueprint.RuleParams{
+			Command: `rm -rf $outDir && mkdir -p $outDir && ` +
+				`$protocCmd $protoOut=$outDir $protoFlags $in && ` +
+				`find $outDir -name "*.java" | sort > $out`,
+			CommandDeps: []string{"$protocCmd"},
+		}, "protoFlags", "protoOut", "outDir")
+)
+
+func genProto(ctx android.ModuleContext, protoFile android.Path, flags javaBuilderFlags) android.Path {
+	outDir := android.GenPathWithExt(ctx, "proto", protoFile, "java")
+	srcFileList := android.GenPathWithExt(ctx, "proto", protoFile, "list")
+
+	ctx.ModuleBuild(pctx, android.ModuleBuildParams{
+		Rule:        proto,
+		Description: "protoc " + protoFile.Rel(),
+		Output:      srcFileList,
+		Input:       protoFile,
+		Implicits:   flags.protoDeps,
+		Args: map[string]string{
+			"outDir":     outDir.String(),
+			"protoFlags": flags.protoFlags,
+			"protoOut":   flags.protoOutTypeFlag,
+		},
+	})
+
+	return srcFileList
+}
+
+func hasProtoSrcs(srcFiles android.Paths) bool {
+	for _, srcFile := range srcFiles {
+		if srcFile.Ext() == ".proto" {
+			return true
+		}
+	}
+	return false
+}
+
+// protoDepsMutator adds the protobuf runtime library that matches the generator to the java
+// modules that compile proto files.  It runs after the DepsMutators, so that the proto files
+// provided by the filegroups and genrules referenced from srcs are found too.
+func protoDepsMutator(ctx android.BottomUpMutatorContext) {
+	j, ok := ctx.Module().(interface {
+		compilerProperties() *CompilerProperties
+	})
+	if !ok {
+		return
+	}
+	props := j.compilerProperties()
+
+	hasProto := false
+	var srcModules []string
+	for _, src := range props.Srcs {
+		if m := android.SrcIsModule(src); m != "" {
+			srcModules = append(srcModules, m)
+		} else if strings.HasSuffix(src, ".proto") {
+			hasProto = true
+		}
+	}
+
+	ctx.VisitDirectDeps(func(module blueprint.Module) {
+		if p, ok := module.(android.SourceExtensionProducer); ok && p.HasSrcExt(".proto") &&
+			inList(ctx.OtherModuleName(module), srcModules) {
+			hasProto = true
+		}
+	})
+
+	if hasProto {
+		if lib := protoRuntimeLibrary(ctx, proptools.String(props.Proto.Type)); lib != "" {
+			ctx.AddDependency(ctx.Module(), staticLibTag, lib)
+		}
+	}
+}
+
+// protoRuntimeLibrary returns the java library that the code generated for the given proto type
+// needs at runtime.
+func protoRuntimeLibrary(ctx android.BaseContext, protoType string) string {
+	switch protoType {
+	case "full":
+		return "libprotobuf-java-full"
+	case "lite", "":
+		return "libprotobuf-java-lite"
+	case "nano":
+		return "libprotobuf-java-nano"
+	default:
+		ctx.PropertyErrorf("proto.type", "unknown proto type %q", protoType)
+		return ""
+	}
+}
+
+func protoFlags(ctx android.ModuleContext, p *CompilerProperties, flags javaBuilderFlags) javaBuilderFlags {
+	switch proptools.String(p.Proto.Type) {
+	case "full":
+		flags.protoOutTypeFlag = "--java_out"
+	case "lite", "":
+		flags.protoOutTypeFlag = "--javalite_out"
+		flags.protoFlags = "--plugin=protoc-gen-javalite=$protocGenJavaliteCmd "
+		flags.protoDeps = android.Paths{android.PathForOutput(ctx, "host", ctx.AConfig().PrebuiltOS(),
+			"bin", "protoc-gen-javalite")}
+	case "nano":
+		flags.protoOutTypeFlag = "--javanano_out"
+	default:
+		ctx.PropertyErrorf("proto.type", "unknown proto type %q", proptools.String(p.Proto.Type))
+	}
+
+	var includeDirs []string
+	if !proptools.BoolDefault(p.Proto.Canonical_path_from_root, true) {
+		// Resolve the proto files relative to the module directory, so that the generated
+		// code refers to them by their path within the module
+		includeDirs = append(includeDirs, android.PathForModuleSrc(ctx).String())
+	}
+	includeDirs = append(includeDirs, android.PathsForSource(ctx, p.Proto.Include_dirs).Strings()...)
+	includeDirs = append(includeDirs, ".")
+
+	flags.protoFlags += android.JoinWithPrefix(includeDirs, "-I")
+
+	return flags
+}
@@ -16,6 +16,7 @@ package java
 
 import (
 	"path/filepath"
+	"strings"
 
 	"github.com/google/blueprint/bootstrap"
 
@@ -69,3 +70,36 @@ func ResourceDirsToJarSpecs(ctx android.ModuleContext, resourceDirs, excludeDirs
 
 	return jarSpecs
 }
+
+// ResourceFilesToJarSpec returns a jarSpec that puts each resource file into the jar at its path
+// relative to its base directory, which is the module directory for files listed directly and the
+// path property of a filegroup for files listed through the filegroup.
+func ResourceFilesToJarSpec(ctx android.ModuleContext, resources android.Paths) jarSpec {
+	var jarArgs []string
+	for _, resource := range resources {
+		rel := resource.Rel()
+		if !strings.HasSuffix(resource.String(), rel) {
+			ctx.ModuleErrorf("java resource %q is not under its base directory", resource)
+			continue
+		}
+		dir := strings.TrimSuffix(strings.TrimSuffix(resource.String(), rel), "/")
+		if dir == "" {
+			dir = "."
+		}
+		jarArgs = append(jarArgs, "-C "+dir+" "+rel)
+	}
+
+	// The jar rule only depends on the jarArgs file, so rewrite it when a resource changes
+	outputFile := android.PathForModuleOut(ctx, "java_resources.jarArgs")
+	ctx.ModuleBuild(pctx, android.ModuleBuildParams{
+		Rule:        android.WriteFile,
+		Description: "java resources jar args",
+		Output:      outputFile,
+		Implicits:   resources,
+		Args: map[string]string{
+			"content": strings.Join(jarArgs, `\n`),
+		},
+	})
+
+	return jarSpec{outputFile}
+}
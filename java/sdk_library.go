@@ -0,0 +1,248 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package java
+
+// This file contains the java_sdk_library module type, a java library that is exposed to apps
+// through stubs of its public and system APIs instead of its implementation.
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/blueprint/proptools"
+
+	"android/soong/android"
+)
+
+func init() {
+	android.RegisterModuleType("java_sdk_library", SdkLibraryFactory)
+}
+
+type apiScope int
+
+const (
+	apiScopePublic apiScope = iota
+	apiScopeSystem
+)
+
+var apiScopes = []apiScope{apiScopePublic, apiScopeSystem}
+
+func (s apiScope) String() string {
+	switch s {
+	case apiScopePublic:
+		return "public"
+	case apiScopeSystem:
+		return "system"
+	default:
+		panic(fmt.Errorf("unknown api scope %d", s))
+	}
+}
+
+// apiFilePrefix returns the prefix of the checked in api files for the scope, for example
+// "system-" for api/system-current.txt.
+func (s apiScope) apiFilePrefix() string {
+	if s == apiScopeSystem {
+		return "system-"
+	}
+	return ""
+}
+
+func (s apiScope) doclavaFlags() []string {
+	if s == apiScopeSystem {
+		return []string{"-showAnnotation android.annotation.SystemApi"}
+	}
+	return nil
+}
+
+// sdkLibraryDependency is implemented by modules that provide stubs to modules that are compiled
+// against an SDK.
+type sdkLibraryDependency interface {
+	SdkHeaderJars(sdkVersion string) android.Paths
+}
+
+type sdkLibraryProperties struct {
+	// list of the java packages that make up the API of the library
+	Api_packages []string
+
+	// list of extra flags that will be passed to doclava when generating the stubs
+	Droiddoc_options []string
+
+	// directory containing the checked in current.txt, removed.txt, system-current.txt and
+	// system-removed.txt files that the generated API is checked against.  Defaults to "api".
+	Api_dir *string
+
+	// if set to false, no permissions XML is generated to register the implementation as a
+	// shared library that apps can request with <uses-library>.  Defaults to true.
+	Shared_library *bool
+}
+
+type SdkLibrary struct {
+	Library
+
+	sdkLibraryProperties sdkLibraryProperties
+
+	stubsJars       map[apiScope]android.Path
+	permissionsFile android.Path
+}
+
+var _ Dependency = (*SdkLibrary)(nil)
+var _ sdkLibraryDependency = (*SdkLibrary)(nil)
+
+func (module *SdkLibrary) apiDir() string {
+	if module.sdkLibraryProperties.Api_dir != nil {
+		return *module.sdkLibraryProperties.Api_dir
+	}
+	return "api"
+}
+
+func (module *SdkLibrary) GenerateAndroidBuildActions(ctx android.ModuleContext) {
+	if len(module.sdkLibraryProperties.Api_packages) == 0 {
+		ctx.PropertyErrorf("api_packages", "java_sdk_library must specify api_packages")
+		return
+	}
+
+	module.Library.GenerateAndroidBuildActions(ctx)
+	if ctx.Failed() {
+		return
+	}
+
+	module.stubsJars = make(map[apiScope]android.Path)
+	for _, scope := range apiScopes {
+		module.stubsJars[scope] = module.buildStubs(ctx, scope)
+		if ctx.Failed() {
+			return
+		}
+	}
+
+	if proptools.BoolDefault(module.sdkLibraryProperties.Shared_library, true) {
+		module.permissionsFile = module.buildPermissionsFile(ctx)
+	}
+}
+
+// buildStubs generates the stub sources and API files for a scope, checks the API files against
+// the checked in ones and compiles the stubs into a jar.
+func (module *SdkLibrary) buildStubs(ctx android.ModuleContext, scope apiScope) android.Path {
+	name := scope.String()
+	apiFile := android.PathForModuleOut(ctx, name+"_api.txt")
+	removedApiFile := android.PathForModuleOut(ctx, name+"_removed.txt")
+
+	flags := module.compiledFlags
+	flags.processorPath = ""
+
+	doclavaFlags := append(scope.doclavaFlags(), module.sdkLibraryProperties.Droiddoc_options...)
+
+	stubsList := TransformSourcesToStubs(ctx, name, module.compiledJavaSrcs, module.compiledSrcFileLists,
+		flags, module.compiledDeps, module.sdkLibraryProperties.Api_packages, doclavaFlags,
+		apiFile, removedApiFile)
+
+	currentApiFile := android.PathForModuleSrc(ctx, module.apiDir(), scope.apiFilePrefix()+"current.txt")
+	currentRemovedApiFile := android.PathForModuleSrc(ctx, module.apiDir(), scope.apiFilePrefix()+"removed.txt")
+	if ctx.Failed() {
+		return nil
+	}
+	ctx.CheckbuildFile(CheckApi(ctx, name, currentApiFile, apiFile, currentRemovedApiFile, removedApiFile))
+
+	// The stubs are compiled with the same classpath as the implementation, but without
+	// the implementation's own flags
+	flags.javacFlags = ""
+	classes := transformJavaToClasses(ctx, "-stubs-"+name, nil, android.Paths{stubsList}, flags,
+		module.compiledDeps)
+
+	return transformClassesToJar(ctx, "stubs-"+name+".jar", []jarSpec{classes}, android.OptionalPath{})
+}
+
+// buildPermissionsFile generates and installs the XML file that tells the package manager where
+// to find the implementation of the library.
+func (module *SdkLibrary) buildPermissionsFile(ctx android.ModuleContext) android.Path {
+	// The path of the implementation jar on the device, relative to the product out directory
+	installPath := android.PathForModuleInstall(ctx, "framework", ctx.ModuleName()+".jar")
+	productOut := filepath.Join("target", "product", ctx.AConfig().DeviceName())
+	devicePath := strings.TrimPrefix(installPath.RelPathString(), productOut)
+
+	content := []string{
+		`<?xml version="1.0" encoding="utf-8"?>`,
+		`<permissions>`,
+		`    <library name="` + ctx.ModuleName() + `" file="` + devicePath + `"/>`,
+		`</permissions>`,
+	}
+
+	permissionsFile := android.PathForModuleOut(ctx, ctx.ModuleName()+".xml")
+	ctx.ModuleBuild(pctx, android.ModuleBuildParams{
+		Rule:        android.WriteFile,
+		Description: "permissions xml",
+		Output:      permissionsFile,
+		Args: map[string]string{
+			"content": strings.Join(content, `\n`),
+		},
+	})
+
+	ctx.InstallFile(android.PathForModuleInstall(ctx, "etc", "permissions"), permissionsFile)
+
+	return permissionsFile
+}
+
+// SdkHeaderJars returns the stubs jar that modules compiled against the given SDK version should
+// use instead of the implementation.
+func (module *SdkLibrary) SdkHeaderJars(sdkVersion string) android.Paths {
+	scope := apiScopePublic
+	if sdkVersion == "system_current" {
+		scope = apiScopeSystem
+	}
+	if stubs := module.stubsJars[scope]; stubs != nil {
+		return android.Paths{stubs}
+	}
+	return nil
+}
+
+func (module *SdkLibrary) AndroidMk() android.AndroidMkData {
+	data := module.Library.AndroidMk()
+	if module.permissionsFile != nil {
+		data.Extra = append(data.Extra, func(w io.Writer, outputFile android.Path) {
+			fmt.Fprintln(w, "LOCAL_REQUIRED_MODULES += "+module.Name()+".xml")
+		})
+		writeLibrary := data.Custom
+		data.Custom = func(w io.Writer, name, prefix, moduleDir string, data android.AndroidMkData) {
+			if writeLibrary != nil {
+				writeLibrary(w, name, prefix, moduleDir, data)
+			} else {
+				android.WriteAndroidMkData(w, data)
+			}
+
+			fmt.Fprintln(w, "include $(CLEAR_VARS)")
+			fmt.Fprintln(w, "LOCAL_MODULE := "+name+".xml")
+			fmt.Fprintln(w, "LOCAL_MODULE_CLASS := ETC")
+			fmt.Fprintln(w, "LOCAL_MODULE_PATH := $(TARGET_OUT_ETC)/permissions")
+			fmt.Fprintln(w, "LOCAL_PREBUILT_MODULE_FILE := "+module.permissionsFile.String())
+			fmt.Fprintln(w, "include $(BUILD_PREBUILT)")
+		}
+	}
+	return data
+}
+
+func SdkLibraryFactory() android.Module {
+	module := &SdkLibrary{}
+
+	module.deviceProperties.Dex = true
+
+	module.AddProperties(
+		&module.Module.properties,
+		&module.Module.deviceProperties,
+		&module.sdkLibraryProperties)
+
+	InitJavaModule(module, android.DeviceSupported)
+	return module
+}
@@ -0,0 +1,97 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package java
+
+// This file checks the default-locale strings of apps against the snapshot taken at the
+// translation freeze, so that strings are not changed after they have been sent to the
+// translators.  Snapshots are generated with
+//
+//     build/soong/scripts/check_string_freeze.py snapshot --output string-freeze.xml res
+//
+// and the check is enabled for a product with StringFreeze, as "error" or "warning".
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/google/blueprint"
+
+	"android/soong/android"
+)
+
+func init() {
+	pctx.SourcePathVariable("checkStringFreezeCmd", "build/soong/scripts/check_string_freeze.py")
+}
+
+// checkStringFreeze compares the translatable strings in $resDirs with the snapshot, and fails
+// unless $stringFreezeFlags contains --warn-only.
+var checkStringFreeze = pctx.AndroidStaticRule("checkStringFreeze",
+	blueprint.RuleParams{
+		Command:     `$checkStringFreezeCmd check $stringFreezeFlags --snapshot $in --output $out $resDirs`,
+		CommandDeps: []string{"$checkStringFreezeCmd"},
+	},
+	"stringFreezeFlags", "resDirs")
+
+const defaultStringFreezeSnapshot = "string-freeze.xml"
+
+// checkStringFreeze checks the strings of the app against its translation freeze snapshot, and
+// returns the stamp file of the check, or nil if the app is not checked.
+func (a *AndroidApp) checkStringFreeze(ctx android.ModuleContext) android.Path {
+	var flags []string
+	switch mode := ctx.AConfig().StringFreeze(); mode {
+	case "":
+		return nil
+	case "warning":
+		flags = append(flags, "--warn-only")
+	case "error":
+	default:
+		ctx.ModuleErrorf(`invalid StringFreeze %q, must be "error" or "warning"`, mode)
+		return nil
+	}
+
+	var snapshot android.Path
+	if a.appProperties.String_freeze_snapshot != nil {
+		snapshot = android.PathForModuleSrc(ctx, *a.appProperties.String_freeze_snapshot)
+	} else if s := android.ExistentPathForSource(ctx, "", ctx.ModuleDir(),
+		defaultStringFreezeSnapshot); s.Valid() {
+		snapshot = s.Path()
+	} else {
+		// Apps without a snapshot are not translated
+		return nil
+	}
+
+	// Only the unqualified values directories of the app contain the default-locale strings, the
+	// overlays of the product are not translated
+	resourceDirs := android.PathsWithOptionalDefaultForModuleSrc(ctx, a.appProperties.Android_resource_dirs, "res")
+	var valuesFiles android.Paths
+	for _, dir := range resourceDirs {
+		valuesFiles = append(valuesFiles, ctx.Glob(filepath.Join(dir.String(), "values", "*.xml"), nil)...)
+	}
+
+	stamp := android.PathForModuleOut(ctx, "string-freeze.stamp")
+	ctx.ModuleBuild(pctx, android.ModuleBuildParams{
+		Rule:        checkStringFreeze,
+		Description: "string freeze " + ctx.ModuleName(),
+		Output:      stamp,
+		Input:       snapshot,
+		Implicits:   valuesFiles,
+		Args: map[string]string{
+			"stringFreezeFlags": strings.Join(flags, " "),
+			"resDirs":           strings.Join(resourceDirs.Strings(), " "),
+		},
+	})
+
+	return stamp
+}
@@ -0,0 +1,159 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package java
+
+// This file contains the java_system_modules module type, which combines java libraries into a
+// java.base module image that java 9 and later compiles use with --system in place of a
+// bootclasspath.
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/google/blueprint"
+
+	"android/soong/android"
+)
+
+func init() {
+	android.RegisterModuleType("java_system_modules", SystemModulesFactory)
+}
+
+var (
+	// jarsToSystemModules merges the jars into a java.base module by compiling a generated
+	// module-info.java that exports all of their packages, and links it into a system image
+	// with jlink.
+	jarsToSystemModules = pctx.AndroidStaticRule("jarsToSystemModules",
+		blueprint.RuleParams{
+			Command: `rm -rf "$outDir" "$workDir" && mkdir -p "$workDir/classes" "$workDir/jmod" && ` +
+				`for jar in $in; do unzip -qo "$$jar" -d "$workDir/classes"; done && ` +
+				`${config.JarsToModuleInfoCmd} java.base $in > "$workDir/module-info.java" && ` +
+				`${config.JavacCmd} --system=none --patch-module=java.base="$workDir/classes" ` +
+				`-d "$workDir/classes" "$workDir/module-info.java" && ` +
+				`${config.JarCmd} cf "$workDir/module.jar" -C "$workDir/classes" . && ` +
+				`${config.JmodCmd} create --module-version 9 --target-platform android ` +
+				`--class-path "$workDir/module.jar" "$workDir/jmod/java.base.jmod" && ` +
+				`${config.JlinkCmd} --module-path "$workDir/jmod" --add-modules java.base ` +
+				`--output "$outDir" && ` +
+				`cp ${config.JrtFsJar} "$outDir/lib/"`,
+			CommandDeps: []string{
+				"${config.JarsToModuleInfoCmd}",
+				"${config.JavacCmd}",
+				"${config.JarCmd}",
+				"${config.JmodCmd}",
+				"${config.JlinkCmd}",
+				"${config.JrtFsJar}",
+			},
+		},
+		"outDir", "workDir")
+)
+
+var systemModulesTag = dependencyTag{name: "system modules"}
+
+// systemModulesProvider is implemented by modules that java modules can be compiled against with
+// the system_modules property.
+type systemModulesProvider interface {
+	// SystemModulesDir returns the directory that is passed to javac with --system.
+	SystemModulesDir() android.Path
+
+	// SystemModulesDeps returns the files in the directory, which compiles against it depend on.
+	SystemModulesDeps() android.Paths
+}
+
+type SystemModulesProperties struct {
+	// list of java libraries that are combined into the java.base module
+	Libs []string
+}
+
+type SystemModules struct {
+	android.ModuleBase
+
+	properties SystemModulesProperties
+
+	outputDir  android.Path
+	outputDeps android.Paths
+}
+
+func (system *SystemModules) DepsMutator(ctx android.BottomUpMutatorContext) {
+	ctx.AddDependency(ctx.Module(), libTag, system.properties.Libs...)
+}
+
+func (system *SystemModules) GenerateAndroidBuildActions(ctx android.ModuleContext) {
+	var jars android.Paths
+
+	ctx.VisitDirectDeps(func(module blueprint.Module) {
+		if ctx.OtherModuleDependencyTag(module) == libTag {
+			dep, ok := module.(Dependency)
+			if !ok {
+				ctx.PropertyErrorf("libs", "%q is not a java library", ctx.OtherModuleName(module))
+				return
+			}
+			jars = append(jars, dep.ClasspathFiles()...)
+		}
+	})
+
+	if len(jars) == 0 {
+		ctx.PropertyErrorf("libs", "no java libraries to combine into system modules")
+		return
+	}
+
+	outDir := android.PathForModuleOut(ctx, "system")
+	modules := outDir.Join(ctx, "lib", "modules")
+	jrtFsJar := outDir.Join(ctx, "lib", "jrt-fs.jar")
+	release := outDir.Join(ctx, "release")
+
+	ctx.ModuleBuild(pctx, android.ModuleBuildParams{
+		Rule:            jarsToSystemModules,
+		Description:     "system modules",
+		Output:          modules,
+		ImplicitOutputs: android.WritablePaths{jrtFsJar, release},
+		Inputs:          jars,
+		Args: map[string]string{
+			"outDir":  outDir.String(),
+			"workDir": android.PathForModuleOut(ctx, "work").String(),
+		},
+	})
+
+	system.outputDir = outDir
+	system.outputDeps = android.Paths{modules, jrtFsJar, release}
+}
+
+func (system *SystemModules) SystemModulesDir() android.Path {
+	return system.outputDir
+}
+
+func (system *SystemModules) SystemModulesDeps() android.Paths {
+	return system.outputDeps
+}
+
+func (system *SystemModules) AndroidMk() android.AndroidMkData {
+	return android.AndroidMkData{
+		Custom: func(w io.Writer, name, prefix, moduleDir string, data android.AndroidMkData) {
+			fmt.Fprintln(w)
+			makevar := "SOONG_SYSTEM_MODULES_" + name
+			fmt.Fprintln(w, makevar, ":=", system.outputDir.String())
+			fmt.Fprintln(w, makevar+"_DEPS", ":=", strings.Join(system.outputDeps.Strings(), " "))
+			fmt.Fprintln(w, name+":", "$("+makevar+"_DEPS)")
+		},
+	}
+}
+
+func SystemModulesFactory() android.Module {
+	module := &SystemModules{}
+	module.AddProperties(&module.properties)
+	android.InitAndroidArchModule(module, android.DeviceSupported, android.MultilibCommon)
+	return module
+}
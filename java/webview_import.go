@@ -0,0 +1,223 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package java
+
+// This file contains the android_webview_import module type, which installs a prebuilt WebView,
+// Trichrome library or Trichrome Chrome apk.  Updated WebView builds change the shared libraries
+// they require and how their dex files are stored, so the apk is checked against the module
+// before it is installed: the libraries declared by <uses-library> and <uses-static-library> must
+// match uses_libraries, and the dex files must be stored uncompressed so that they can be used
+// from the apk without being extracted.  See scripts/webview_import.py.
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/google/blueprint"
+	"github.com/google/blueprint/proptools"
+
+	"android/soong/android"
+)
+
+func init() {
+	android.RegisterModuleType("android_webview_import", WebViewImportFactory)
+
+	pctx.SourcePathVariable("webviewImportCmd", "build/soong/scripts/webview_import.py")
+	pctx.SourcePathVariable("checkSysconfigCmd", "build/soong/scripts/check_permission_config.py")
+}
+
+var (
+	// prepareWebViewApk stores the dex files of the apk uncompressed and, unless the apk is
+	// presigned, removes its signature so that it can be signed again.
+	prepareWebViewApk = pctx.AndroidStaticRule("prepareWebViewApk",
+		blueprint.RuleParams{
+			Command:     `$webviewImportCmd prepare $presigned $uncompressDex $in $out`,
+			CommandDeps: []string{"$webviewImportCmd"},
+		},
+		"presigned", "uncompressDex")
+
+	// checkWebViewApk checks the libraries used by the apk against uses_libraries and writes
+	// the sysconfig entries of its package.
+	checkWebViewApk = pctx.AndroidStaticRule("checkWebViewApk",
+		blueprint.RuleParams{
+			Command: `$webviewImportCmd check $usesLibraries $sysconfigEntries --sysconfig $out.tmp $in && ` +
+				`$checkSysconfigCmd validate --type sysconfig $out.tmp $out && rm -f $out.tmp`,
+			CommandDeps: []string{"$webviewImportCmd", "$checkSysconfigCmd"},
+		},
+		"usesLibraries", "sysconfigEntries")
+)
+
+type webViewImportProperties struct {
+	// the prebuilt apk, usually set per architecture because WebView contains native code
+	Apk *string `android:"arch_variant"`
+
+	// if true, the apk is installed with its original signature, otherwise it is signed with
+	// certificate
+	Presigned *bool
+
+	// path to a certificate, or the name of a certificate in the default certificate directory,
+	// or blank to use the default product certificate
+	Certificate string
+
+	// names of the shared libraries that the apk declares in <uses-library> or
+	// <uses-static-library>, for example "TrichromeLibrary".  The build fails if the apk
+	// declares a library that is not listed, or does not declare a listed library.
+	Uses_libraries []string
+
+	// names of the apps that this apk replaces, for example "webview"
+	Overrides []string
+
+	// if true, the dex files are stored uncompressed in the installed apk.  A presigned apk
+	// must already store them uncompressed.  Defaults to true.
+	Uncompress_dex *bool
+
+	// sysconfig elements installed for the package of the apk, for example
+	// "hidden-api-whitelisted-app" or "allow-in-power-save-except-idle"
+	Sysconfig_entries []string
+}
+
+type WebViewImport struct {
+	android.ModuleBase
+
+	properties webViewImportProperties
+
+	outputFile  android.Path
+	installPath android.OutputPath
+	badgingFile android.Path
+	certificate string
+}
+
+func (w *WebViewImport) DepsMutator(ctx android.BottomUpMutatorContext) {
+}
+
+// Badging returns the JSON metadata of the apk reported by aapt dump badging.
+func (w *WebViewImport) Badging() android.Path {
+	return w.badgingFile
+}
+
+// Overrides returns the names of the apps that must not be installed together with this apk.
+func (w *WebViewImport) Overrides() []string {
+	return w.properties.Overrides
+}
+
+// Certificate returns the certificate the apk is signed with, without the .x509.pem suffix, or an
+// empty string if the apk is presigned.
+func (w *WebViewImport) Certificate() string {
+	return w.certificate
+}
+
+func (w *WebViewImport) appMetadata() android.Path {
+	return w.badgingFile
+}
+
+func (w *WebViewImport) PropertyConstraints() []android.PropertyConstraint {
+	return []android.PropertyConstraint{
+		android.MatchesRegexp("certificate", `^[A-Za-z0-9_./-]*$`, "a certificate name or path"),
+	}
+}
+
+func (w *WebViewImport) GenerateAndroidBuildActions(ctx android.ModuleContext) {
+	if w.properties.Apk == nil {
+		ctx.PropertyErrorf("apk", "missing prebuilt apk for %s", ctx.Arch().ArchType.String())
+		return
+	}
+	apk := android.PathForModuleSrc(ctx, *w.properties.Apk)
+
+	presigned := proptools.Bool(w.properties.Presigned)
+	if presigned && w.properties.Certificate != "" {
+		ctx.PropertyErrorf("certificate", "cannot be set for a presigned apk")
+		return
+	}
+
+	args := map[string]string{}
+	if presigned {
+		args["presigned"] = "--presigned"
+	}
+	if proptools.BoolDefault(w.properties.Uncompress_dex, true) {
+		args["uncompressDex"] = "--uncompress-dex"
+	}
+
+	preparedApk := android.PathForModuleOut(ctx, "prepared.apk")
+	ctx.ModuleBuild(pctx, android.ModuleBuildParams{
+		Rule:        prepareWebViewApk,
+		Description: "prepare " + apk.Base(),
+		Output:      preparedApk,
+		Input:       apk,
+		Args:        args,
+	})
+
+	w.outputFile = preparedApk
+	if !presigned {
+		certificate := w.properties.Certificate
+		if certificate == "" {
+			certificate = ctx.AConfig().DefaultAppCertificate(ctx).String()
+		} else if dir, _ := filepath.Split(certificate); dir == "" {
+			certificate = filepath.Join(ctx.AConfig().DefaultAppCertificateDir(ctx).String(), certificate)
+		} else {
+			certificate = filepath.Join(android.PathForSource(ctx).String(), certificate)
+		}
+
+		w.certificate = certificate
+		signedApk := android.PathForModuleOut(ctx, "package.apk")
+		ctx.ModuleBuild(pctx, android.ModuleBuildParams{
+			Rule:        signapk,
+			Description: "signapk",
+			Output:      signedApk,
+			Input:       preparedApk,
+			Args: map[string]string{
+				"certificates": certificate + ".x509.pem " + certificate + ".pk8",
+			},
+		})
+		w.outputFile = signedApk
+	}
+
+	for _, entry := range w.properties.Sysconfig_entries {
+		if strings.ContainsAny(entry, " <>/\"'") {
+			ctx.PropertyErrorf("sysconfig_entries", "%q is not a sysconfig element", entry)
+		}
+	}
+	if ctx.Failed() {
+		return
+	}
+
+	installDir := android.PathForModuleInstall(ctx, "app", ctx.ModuleName())
+	w.badgingFile = badgingMetadataForApk(ctx, w.outputFile, installDir.Join(ctx, ctx.ModuleName()+".apk"))
+
+	// The sysconfig file is also generated without entries, the apk is only installed after the
+	// libraries it uses have been checked
+	sysconfig := android.PathForModuleOut(ctx, "sysconfig", ctx.ModuleName()+".xml")
+	ctx.ModuleBuild(pctx, android.ModuleBuildParams{
+		Rule:        checkWebViewApk,
+		Description: "check " + ctx.ModuleName(),
+		Output:      sysconfig,
+		Input:       w.badgingFile,
+		Args: map[string]string{
+			"usesLibraries":    android.JoinWithPrefix(w.properties.Uses_libraries, "--uses-library "),
+			"sysconfigEntries": android.JoinWithPrefix(w.properties.Sysconfig_entries, "--sysconfig-entry "),
+		},
+	})
+
+	w.installPath = ctx.InstallFileName(installDir, ctx.ModuleName()+".apk", w.outputFile, sysconfig)
+	if len(w.properties.Sysconfig_entries) > 0 {
+		ctx.InstallFile(android.PathForModuleInstall(ctx, "etc", "sysconfig"), sysconfig)
+	}
+}
+
+func WebViewImportFactory() android.Module {
+	module := &WebViewImport{}
+	module.AddProperties(&module.properties)
+	android.InitAndroidArchModule(module, android.DeviceSupported, android.MultilibFirst)
+	return module
+}
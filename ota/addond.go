@@ -0,0 +1,194 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ota
+
+// This file contains the addon_d_script module type.  addon.d scripts are run by backuptool
+// while an OTA package is installed, to back up and restore files that are not part of the
+// system image, for example GApps, so that they survive the update.
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/google/blueprint"
+
+	"android/soong/android"
+)
+
+func init() {
+	android.RegisterModuleType("addon_d_script", AddondScriptFactory)
+	android.RegisterSingletonType("addon_d", AddondSingleton)
+
+	pctx.IntermediatesPathVariable("addondList", "addon.d.list")
+
+	android.RegisterMakeVarsProvider(pctx, addondMakeVarsProvider)
+}
+
+var (
+	// addondCheck validates an addon.d script and copies it into place with the executable bit
+	// set.  backuptool sources the scripts with /sbin/sh and refuses scripts that don't declare
+	// an ADDOND_VERSION it supports.
+	addondCheck = pctx.AndroidStaticRule("addondCheck",
+		blueprint.RuleParams{
+			Command: `if [ ! -x $in ]; then echo "$in: addon.d scripts must be executable" >&2; exit 1; fi && ` +
+				`if [ "$$(head -n 1 $in)" != "#!/sbin/sh" ]; then ` +
+				`echo "$in: addon.d scripts must start with #!/sbin/sh" >&2; exit 1; fi && ` +
+				`if ! grep -qx "# ADDOND_VERSION=$version" $in; then ` +
+				`echo "$in: addon.d scripts must declare # ADDOND_VERSION=$version" >&2; exit 1; fi && ` +
+				`cp -f $in $out && chmod 755 $out`,
+		},
+		"version")
+
+	addondListRule = pctx.AndroidStaticRule("addondList",
+		blueprint.RuleParams{
+			Command:        `tr ' ' '\n' < $out.rsp | sort -u > $out`,
+			Rspfile:        "$out.rsp",
+			RspfileContent: "$scripts",
+		},
+		"scripts")
+)
+
+// The ADDOND_VERSION values that backuptool knows how to run.
+var supportedAddondVersions = []string{"1", "2", "3"}
+
+// addon.d scripts are run in lexical order, so their names are prefixed with a two digit
+// priority, for example 50-lineage.sh.
+var addondScriptNameRegexp = regexp.MustCompile(`^[0-9]{2}-[A-Za-z0-9._-]+\.sh$`)
+
+type addondScriptProperties struct {
+	// the addon.d script to install
+	Src *string
+
+	// name of the installed script.  Defaults to the name of src.
+	Filename *string
+
+	// the ADDOND_VERSION the script is written for.  Defaults to "2".
+	Version *string
+}
+
+type addondScript struct {
+	android.ModuleBase
+
+	properties addondScriptProperties
+
+	outputFile  android.Path
+	installPath android.OutputPath
+}
+
+func AddondScriptFactory() android.Module {
+	module := &addondScript{}
+	module.AddProperties(&module.properties)
+	android.InitAndroidArchModule(module, android.DeviceSupported, android.MultilibCommon)
+	return module
+}
+
+func (m *addondScript) DepsMutator(ctx android.BottomUpMutatorContext) {
+}
+
+func (m *addondScript) version() string {
+	if m.properties.Version != nil {
+		return *m.properties.Version
+	}
+	return "2"
+}
+
+func (m *addondScript) GenerateAndroidBuildActions(ctx android.ModuleContext) {
+	if m.properties.Src == nil {
+		ctx.PropertyErrorf("src", "missing addon.d script")
+		return
+	}
+	src := android.PathForModuleSrc(ctx, *m.properties.Src)
+
+	filename := src.Base()
+	if m.properties.Filename != nil {
+		filename = *m.properties.Filename
+	}
+	if !addondScriptNameRegexp.MatchString(filename) {
+		ctx.PropertyErrorf("filename", "%q must be a two digit priority followed by a name ending in .sh, for example 50-lineage.sh",
+			filename)
+	}
+
+	if !inList(m.version(), supportedAddondVersions) {
+		ctx.PropertyErrorf("version", "unsupported ADDOND_VERSION %q, must be one of %v",
+			m.version(), supportedAddondVersions)
+	}
+
+	if ctx.Failed() {
+		return
+	}
+
+	outputFile := android.PathForModuleOut(ctx, filename)
+	ctx.ModuleBuild(pctx, android.ModuleBuildParams{
+		Rule:        addondCheck,
+		Description: "addon.d " + filename,
+		Output:      outputFile,
+		Input:       src,
+		Args: map[string]string{
+			"version": m.version(),
+		},
+	})
+
+	m.outputFile = outputFile
+	m.installPath = ctx.InstallFile(android.PathForModuleInstall(ctx, "addon.d"), outputFile)
+}
+
+func (m *addondScript) AndroidMk() android.AndroidMkData {
+	return android.AndroidMkData{
+		Class:      "ETC",
+		OutputFile: android.OptionalPathForPath(m.outputFile),
+		Extra: []android.AndroidMkExtraFunc{
+			func(w io.Writer, outputFile android.Path) {
+				fmt.Fprintln(w, "LOCAL_MODULE_PATH := $(OUT_DIR)/"+filepath.Dir(m.installPath.RelPathString()))
+				fmt.Fprintln(w, "LOCAL_INSTALLED_MODULE_STEM := "+outputFile.Base())
+			},
+		},
+	}
+}
+
+func AddondSingleton() blueprint.Singleton {
+	return &addondSingleton{}
+}
+
+type addondSingleton struct{}
+
+// GenerateBuildActions writes the list of installed addon.d scripts, which the OTA packaging
+// uses to include the scripts and backuptool in the package.
+func (s *addondSingleton) GenerateBuildActions(ctx blueprint.SingletonContext) {
+	var scripts []string
+	var outputs []string
+	ctx.VisitAllModules(func(module blueprint.Module) {
+		if m, ok := module.(*addondScript); ok && m.Enabled() && m.outputFile != nil {
+			scripts = append(scripts, m.installPath.RelPathString())
+			outputs = append(outputs, m.outputFile.String())
+		}
+	})
+
+	ctx.Build(pctx, blueprint.BuildParams{
+		Rule:        addondListRule,
+		Description: "addon.d list",
+		Outputs:     []string{"$addondList"},
+		Implicits:   outputs,
+		Args: map[string]string{
+			"scripts": strings.Join(scripts, " "),
+		},
+	})
+}
+
+func addondMakeVarsProvider(ctx android.MakeVarsContext) {
+	ctx.Strict("SOONG_ADDON_D_LIST", "${addondList}")
+}
@@ -0,0 +1,102 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ota
+
+// This file contains the lineage-dist target, which copies the OTA package built by bacon into
+// the layout of the download servers, <dist>/<device>/<date>/<build type>/, and regenerates the
+// <dist>/<device>/<build type>.json metadata that the Updater app reads to find updates.  Only
+// the newest Lineage_dist_retention builds of a device and build type are listed, and the older
+// ones are only deleted from the layout if Lineage_dist_prune is set.  See
+// scripts/lineage_dist.py.
+
+import (
+	"strconv"
+
+	"github.com/google/blueprint"
+
+	"android/soong/android"
+)
+
+func init() {
+	android.RegisterSingletonType("lineage_dist", LineageDistSingleton)
+
+	pctx.SourcePathVariable("lineageDistCmd", "build/soong/scripts/lineage_dist.py")
+}
+
+var lineageDist = pctx.AndroidStaticRule("lineageDist",
+	blueprint.RuleParams{
+		Command: `$lineageDistCmd --dist-dir $distDir --device $device --version $version ` +
+			`--build-type $buildType --retention $retention $pruneFlag --url "$url" --stamp $out $in`,
+		CommandDeps: []string{"$lineageDistCmd"},
+	},
+	"distDir", "device", "version", "buildType", "retention", "pruneFlag", "url")
+
+func LineageDistSingleton() blueprint.Singleton {
+	return &lineageDistSingleton{}
+}
+
+type lineageDistSingleton struct{}
+
+func (s *lineageDistSingleton) GenerateBuildActions(ctx blueprint.SingletonContext) {
+	config := ctx.Config().(android.Config)
+	version := config.LineageVersion()
+	if version == "" || config.DeviceName() == "" {
+		return
+	}
+
+	distDir := config.Getenv("DIST_DIR")
+	if distDir == "" {
+		distDir = android.PathForOutput(ctx, "dist", "lineage").String()
+	}
+
+	// The OTA package is built by the bacon target in Make
+	otaPackage := android.PathForOutput(ctx, "target", "product", config.DeviceName(),
+		"lineage-"+version+".zip")
+	stamp := android.PathForOutput(ctx, "lineage-dist.stamp")
+
+	pruneFlag := ""
+	if config.LineageDistPrune() {
+		pruneFlag = "--prune"
+	}
+
+	ctx.Build(pctx, blueprint.BuildParams{
+		Rule:        lineageDist,
+		Description: "lineage dist " + otaPackage.Base(),
+		Outputs:     []string{stamp.String()},
+		Inputs:      []string{otaPackage.String()},
+		Args: map[string]string{
+			"distDir":   distDir,
+			"device":    config.DeviceName(),
+			"version":   version,
+			"buildType": config.LineageBuildType(),
+			"retention": strconv.Itoa(config.LineageDistRetention()),
+			"pruneFlag": pruneFlag,
+			"url":       config.LineageDistUrl(),
+		},
+		Optional: true,
+	})
+
+	suffix := ""
+	if config.EmbeddedInMake() {
+		suffix = "-soong"
+	}
+
+	ctx.Build(pctx, blueprint.BuildParams{
+		Rule:      blueprint.Phony,
+		Outputs:   []string{"lineage-dist" + suffix},
+		Implicits: []string{stamp.String()},
+		Optional:  true,
+	})
+}
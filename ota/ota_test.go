@@ -0,0 +1,433 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ota
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"android/soong/android"
+)
+
+var buildDir string
+
+func setUp() {
+	var err error
+	buildDir, err = ioutil.TempDir("", "soong_ota_test")
+	if err != nil {
+		panic(err)
+	}
+}
+
+func tearDown() {
+	os.RemoveAll(buildDir)
+}
+
+func TestMain(m *testing.M) {
+	run := func() int {
+		setUp()
+		defer tearDown()
+
+		return m.Run()
+	}
+
+	os.Exit(run())
+}
+
+type updaterTestModule struct {
+	android.ModuleBase
+	outputFile android.Path
+}
+
+// newUpdaterTestModule returns a device module that produces an update-binary.
+func newUpdaterTestModule() android.Module {
+	m := &updaterTestModule{}
+	android.InitAndroidArchModule(m, android.DeviceSupported, android.MultilibFirst)
+	return m
+}
+
+func (m *updaterTestModule) DepsMutator(ctx android.BottomUpMutatorContext) {
+}
+
+func (m *updaterTestModule) GenerateAndroidBuildActions(ctx android.ModuleContext) {
+	m.outputFile = android.PathForModuleOut(ctx, ctx.ModuleName())
+}
+
+func (m *updaterTestModule) OutputFile() android.OptionalPath {
+	return android.OptionalPathForPath(m.outputFile)
+}
+
+// newOtaFixture returns a test fixture for the device targets with the ota module types and a
+// module type that produces an update-binary registered.
+func newOtaFixture(bp string) *android.TestFixture {
+	f := android.NewTestArchFixture(buildDir)
+	f.RegisterModuleType("ota_updater_package", android.ModuleFactoryAdaptor(UpdaterPackageFactory))
+	f.RegisterModuleType("otacerts_zip", android.ModuleFactoryAdaptor(OtacertsZipFactory))
+	f.RegisterModuleType("addon_d_script", android.ModuleFactoryAdaptor(AddondScriptFactory))
+	f.RegisterModuleType("updater_test_module", android.ModuleFactoryAdaptor(newUpdaterTestModule))
+	f.AddBlueprint(bp)
+	return f
+}
+
+func TestUpdaterPackageNonAb(t *testing.T) {
+	f := newOtaFixture(`
+		ota_updater_package {
+			name: "ota_package",
+			releasetools: "releasetools.py",
+			recovery_resources: ["images/icon.png"],
+			recovery_api_version: 4,
+			blockimgdiff_versions: ["4", "3"],
+		}
+
+		updater_test_module {
+			name: "updater",
+		}
+	`)
+	f.AddFiles("releasetools.py", "images/icon.png")
+	f.Prepare(t)
+
+	m := f.ModuleForTests("ota_package", "android_arm64")
+
+	miscInfo := m.Rule("WriteFile").Args["content"]
+	expected := `recovery_api_version=4\nfstab_version=2\nblockimgdiff_versions=3,4\n` +
+		`use_set_metadata=1\nupdate_rename_support=1`
+	if miscInfo != expected {
+		t.Errorf("expected misc_info.txt %q, got %q", expected, miscInfo)
+	}
+
+	// The versions are sorted without reordering the property
+	versions := m.Module().(*updaterPackage).properties.Blockimgdiff_versions
+	if !reflect.DeepEqual(versions, []string{"4", "3"}) {
+		t.Errorf("blockimgdiff_versions was modified to %q", versions)
+	}
+
+	copyCommands := m.Rule("updaterPackage").Args["copyCommands"]
+	for _, dest := range []string{
+		"/staging/OTA/bin/updater",
+		"/staging/META/misc_info.txt",
+		"/staging/META/releasetools.py",
+		"/staging/RECOVERY/RAMDISK/res/images/icon.png",
+	} {
+		if !strings.Contains(copyCommands, dest) {
+			t.Errorf("updater package doesn't contain %q: %q", dest, copyCommands)
+		}
+	}
+}
+
+func TestUpdaterPackageAb(t *testing.T) {
+	f := newOtaFixture(`
+		ota_updater_package {
+			name: "ota_package",
+			postinstall_config: "postinstall_config.txt",
+		}
+	`)
+	f.Config.ProductVariables.AbOtaUpdater = boolPtr(true)
+	f.Config.ProductVariables.VirtualAbOta = boolPtr(true)
+	f.Config.ProductVariables.AbOtaPartitions = &[]string{"boot", "system"}
+	f.AddFiles("postinstall_config.txt")
+	f.Prepare(t)
+
+	m := f.ModuleForTests("ota_package", "android_arm64")
+
+	if abPartitions := m.Output("ab_partitions.txt").Args["content"]; abPartitions != `boot\nsystem` {
+		t.Errorf("expected ab_partitions.txt %q, got %q", `boot\nsystem`, abPartitions)
+	}
+
+	miscInfo := m.Output("misc_info.txt").Args["content"]
+	expected := `recovery_api_version=3\nfstab_version=2\nab_update=true\nvirtual_ab=true`
+	if miscInfo != expected {
+		t.Errorf("expected misc_info.txt %q, got %q", expected, miscInfo)
+	}
+
+	copyCommands := m.Rule("updaterPackage").Args["copyCommands"]
+	if strings.Contains(copyCommands, "/staging/OTA/bin/updater") {
+		t.Errorf("A/B updater package contains an update-binary: %q", copyCommands)
+	}
+	for _, dest := range []string{
+		"/staging/META/ab_partitions.txt",
+		"/staging/META/postinstall_config.txt",
+	} {
+		if !strings.Contains(copyCommands, dest) {
+			t.Errorf("updater package doesn't contain %q: %q", dest, copyCommands)
+		}
+	}
+}
+
+func TestUpdaterPackageOtaType(t *testing.T) {
+	for _, test := range []struct {
+		virtualAbOta bool
+		miscInfo     string
+	}{
+		{false, `recovery_api_version=3\nfstab_version=2\nab_update=true`},
+		{true, `recovery_api_version=3\nfstab_version=2\nab_update=true\nvirtual_ab=true`},
+	} {
+		// A/B devices don't depend on an updater module
+		f := newOtaFixture(`
+			ota_updater_package {
+				name: "ota_package",
+			}
+		`)
+		f.Config.ProductVariables.AbOtaUpdater = boolPtr(true)
+		f.Config.ProductVariables.VirtualAbOta = boolPtr(test.virtualAbOta)
+		f.Config.ProductVariables.AbOtaPartitions = &[]string{"system"}
+		f.Prepare(t)
+
+		m := f.ModuleForTests("ota_package", "android_arm64")
+		if miscInfo := m.Output("misc_info.txt").Args["content"]; miscInfo != test.miscInfo {
+			t.Errorf("virtual A/B %v: expected misc_info.txt %q, got %q", test.virtualAbOta,
+				test.miscInfo, miscInfo)
+		}
+	}
+}
+
+func TestUpdaterPackageErrors(t *testing.T) {
+	testCases := []struct {
+		name, bp, err string
+		abOtaUpdater  bool
+		virtualAbOta  bool
+		abPartitions  []string
+	}{
+		{
+			name: "unsupported blockimgdiff version",
+			bp: `
+				ota_updater_package {
+					name: "ota_package",
+					blockimgdiff_versions: ["5"],
+				}
+				updater_test_module {
+					name: "updater",
+				}`,
+			err: `unsupported version "5"`,
+		},
+		{
+			name: "missing updater",
+			bp: `
+				ota_updater_package {
+					name: "ota_package",
+				}`,
+			err: `depends on undefined module "updater"`,
+		},
+		{
+			name: "postinstall config on non-A/B device",
+			bp: `
+				ota_updater_package {
+					name: "ota_package",
+					postinstall_config: "postinstall_config.txt",
+				}
+				updater_test_module {
+					name: "updater",
+				}`,
+			err: "only supported on A/B devices",
+		},
+		{
+			name: "updater on A/B device",
+			bp: `
+				ota_updater_package {
+					name: "ota_package",
+					updater: "updater",
+				}`,
+			abOtaUpdater: true,
+			abPartitions: []string{"system"},
+			err:          "not supported on A/B devices",
+		},
+		{
+			name: "virtual A/B without A/B",
+			bp: `
+				ota_updater_package {
+					name: "ota_package",
+				}`,
+			virtualAbOta: true,
+			err:          "virtual A/B requires AB_OTA_UPDATER := true",
+		},
+		{
+			name: "A/B without partitions",
+			bp: `
+				ota_updater_package {
+					name: "ota_package",
+				}`,
+			abOtaUpdater: true,
+			err:          "must list their A/B partitions",
+		},
+		{
+			name: "duplicate A/B partition",
+			bp: `
+				ota_updater_package {
+					name: "ota_package",
+				}`,
+			abOtaUpdater: true,
+			abPartitions: []string{"system", "system"},
+			err:          `partition "system" is listed in AB_OTA_PARTITIONS more than once`,
+		},
+		{
+			name: "A/B partitions on non-A/B device",
+			bp: `
+				ota_updater_package {
+					name: "ota_package",
+				}
+				updater_test_module {
+					name: "updater",
+				}`,
+			abPartitions: []string{"system"},
+			err:          "AB_OTA_PARTITIONS is set but AB_OTA_UPDATER is not true",
+		},
+		{
+			name: "invalid A/B partition",
+			bp: `
+				ota_updater_package {
+					name: "ota_package",
+				}`,
+			abOtaUpdater: true,
+			abPartitions: []string{"system/vendor"},
+			err:          `invalid partition "system/vendor" in AB_OTA_PARTITIONS`,
+		},
+		{
+			name: "blockimgdiff versions on virtual A/B device",
+			bp: `
+				ota_updater_package {
+					name: "ota_package",
+					blockimgdiff_versions: ["4"],
+				}`,
+			abOtaUpdater: true,
+			virtualAbOta: true,
+			abPartitions: []string{"system"},
+			err:          "not supported on virtual A/B devices",
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			f := newOtaFixture(test.bp)
+			f.Config.ProductVariables.AbOtaUpdater = boolPtr(test.abOtaUpdater)
+			f.Config.ProductVariables.VirtualAbOta = boolPtr(test.virtualAbOta)
+			if test.abPartitions != nil {
+				f.Config.ProductVariables.AbOtaPartitions = &test.abPartitions
+			}
+			f.AddFiles("postinstall_config.txt")
+			android.FailIfNoMatchingErrors(t, test.err, f.PrepareWithErrors())
+		})
+	}
+}
+
+func TestOtacertsZip(t *testing.T) {
+	f := newOtaFixture(`
+		otacerts_zip {
+			name: "otacerts",
+			extra_certs: ["extra.x509.pem"],
+		}
+	`)
+	f.Config.ProductVariables.ExtraOtaKeys = &[]string{"vendor/keys/releasekey"}
+	// The certificates are configured without their suffix, which must exist as well
+	f.AddFiles(
+		"build/target/product/security/testkey",
+		"build/target/product/security/testkey.x509.pem",
+		"vendor/keys/releasekey",
+		"vendor/keys/releasekey.x509.pem",
+		"extra.x509.pem")
+	f.Prepare(t)
+
+	zip := f.ModuleForTests("otacerts", "android_common").Rule("otacertsZip")
+
+	var inputs []string
+	for _, p := range zip.Inputs {
+		inputs = append(inputs, p.String())
+	}
+	expected := []string{
+		"build/target/product/security/testkey.x509.pem",
+		"vendor/keys/releasekey.x509.pem",
+		"extra.x509.pem",
+	}
+	if !reflect.DeepEqual(inputs, expected) {
+		t.Errorf("expected otacerts.zip inputs %q, got %q", expected, inputs)
+	}
+}
+
+func TestAddondScript(t *testing.T) {
+	f := newOtaFixture(`
+		addon_d_script {
+			name: "lineage_addond",
+			src: "lineage.sh",
+			filename: "50-lineage.sh",
+			version: "3",
+		}
+	`)
+	f.AddFiles("lineage.sh")
+	f.Prepare(t)
+
+	m := f.ModuleForTests("lineage_addond", "android_common")
+	check := m.Rule("addondCheck")
+	if check.Output.Base() != "50-lineage.sh" {
+		t.Errorf("expected the script to be named 50-lineage.sh, got %q", check.Output.Base())
+	}
+	if check.Input.String() != "lineage.sh" {
+		t.Errorf("expected the script to be checked from lineage.sh, got %q", check.Input)
+	}
+	if check.Args["version"] != "3" {
+		t.Errorf("expected ADDOND_VERSION 3, got %q", check.Args["version"])
+	}
+
+	installPath := m.Module().(*addondScript).installPath.RelPathString()
+	if !strings.HasSuffix(installPath, "/system/addon.d/50-lineage.sh") {
+		t.Errorf("expected the script to be installed into system/addon.d, got %q", installPath)
+	}
+}
+
+func TestAddondScriptErrors(t *testing.T) {
+	testCases := []struct {
+		name, bp, err string
+	}{
+		{
+			name: "missing src",
+			bp: `
+				addon_d_script {
+					name: "addond",
+				}`,
+			err: "missing addon.d script",
+		},
+		{
+			name: "missing priority",
+			bp: `
+				addon_d_script {
+					name: "addond",
+					src: "lineage.sh",
+				}`,
+			err: `"lineage.sh" must be a two digit priority followed by a name ending in .sh`,
+		},
+		{
+			name: "unsupported version",
+			bp: `
+				addon_d_script {
+					name: "addond",
+					src: "50-lineage.sh",
+					version: "4",
+				}`,
+			err: `unsupported ADDOND_VERSION "4"`,
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			f := newOtaFixture(test.bp)
+			f.AddFiles("lineage.sh", "50-lineage.sh")
+			android.FailIfNoMatchingErrors(t, test.err, f.PrepareWithErrors())
+		})
+	}
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
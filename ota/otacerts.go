@@ -0,0 +1,129 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ota
+
+// This file contains the otacerts_zip module type, which packages the certificates that the
+// updater accepts when verifying OTA packages into /system/etc/security/otacerts.zip.
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/blueprint"
+
+	"android/soong/android"
+)
+
+func init() {
+	android.RegisterModuleType("otacerts_zip", OtacertsZipFactory)
+}
+
+var (
+	otacertsZipRule = pctx.AndroidStaticRule("otacertsZip",
+		blueprint.RuleParams{
+			Command:     `${SoongZipCmd} -o $out $zipArgs`,
+			CommandDeps: []string{"${SoongZipCmd}"},
+		},
+		"zipArgs")
+)
+
+type otacertsZipProperties struct {
+	// list of additional .x509.pem certificates in this directory that should be accepted when
+	// verifying OTA packages, on top of the product's default and extra OTA keys
+	Extra_certs []string
+}
+
+type otacertsZip struct {
+	android.ModuleBase
+
+	properties otacertsZipProperties
+
+	outputFile  android.Path
+	installPath android.OutputPath
+}
+
+func OtacertsZipFactory() android.Module {
+	module := &otacertsZip{}
+	module.AddProperties(&module.properties)
+	android.InitAndroidArchModule(module, android.DeviceSupported, android.MultilibCommon)
+	return module
+}
+
+func (m *otacertsZip) DepsMutator(ctx android.BottomUpMutatorContext) {
+}
+
+// otaCerts returns the .x509.pem certificates for the product's default certificate and its
+// PRODUCT_EXTRA_OTA_KEYS, which are configured without a suffix.
+func otaCerts(ctx android.ModuleContext) android.Paths {
+	keys := append(android.Paths{ctx.AConfig().DefaultAppCertificate(ctx)}, ctx.AConfig().ExtraOtaKeys(ctx)...)
+
+	certs := make(android.Paths, 0, len(keys))
+	for _, key := range keys {
+		certs = append(certs, android.PathForSource(ctx, key.Rel()+".x509.pem"))
+	}
+	return certs
+}
+
+func (m *otacertsZip) GenerateAndroidBuildActions(ctx android.ModuleContext) {
+	certs := append(otaCerts(ctx), android.PathsForModuleSrc(ctx, m.properties.Extra_certs)...)
+	if ctx.Failed() {
+		return
+	}
+
+	// The certificates are stored at the top level of the zip regardless of where they come
+	// from, so pass the directory of each one as the relative root.
+	zipArgs := make([]string, 0, len(certs))
+	seen := make(map[string]string)
+	for _, cert := range certs {
+		if other, exists := seen[cert.Base()]; exists {
+			if other != cert.String() {
+				ctx.ModuleErrorf("multiple OTA certificates named %q: %q and %q",
+					cert.Base(), other, cert.String())
+			}
+			continue
+		}
+		seen[cert.Base()] = cert.String()
+		zipArgs = append(zipArgs, "-C "+filepath.Dir(cert.String())+" -f "+cert.String())
+	}
+
+	outputFile := android.PathForModuleOut(ctx, "otacerts.zip")
+	ctx.ModuleBuild(pctx, android.ModuleBuildParams{
+		Rule:        otacertsZipRule,
+		Description: "otacerts.zip",
+		Output:      outputFile,
+		Inputs:      certs,
+		Args: map[string]string{
+			"zipArgs": strings.Join(zipArgs, " "),
+		},
+	})
+
+	m.outputFile = outputFile
+	m.installPath = ctx.InstallFile(android.PathForModuleInstall(ctx, "etc", "security"), outputFile)
+}
+
+func (m *otacertsZip) AndroidMk() android.AndroidMkData {
+	return android.AndroidMkData{
+		Class:      "ETC",
+		OutputFile: android.OptionalPathForPath(m.outputFile),
+		Extra: []android.AndroidMkExtraFunc{
+			func(w io.Writer, outputFile android.Path) {
+				fmt.Fprintln(w, "LOCAL_MODULE_PATH := $(OUT_DIR)/"+filepath.Dir(m.installPath.RelPathString()))
+				fmt.Fprintln(w, "LOCAL_INSTALLED_MODULE_STEM := otacerts.zip")
+			},
+		},
+	}
+}
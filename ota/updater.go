@@ -0,0 +1,389 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ota
+
+// This file contains the ota_updater_package module type, which collects everything that an OTA
+// package needs from the build: the device's releasetools extensions, the recovery resources, and
+// the OTA metadata that ota_from_target_files reads from META/misc_info.txt.  Block-based,
+// non-A/B OTA packages also need the edify update-binary, while A/B and virtual A/B packages are
+// installed by update_engine and need the list of A/B partitions and the postinstall config
+// instead.
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/blueprint"
+
+	"android/soong/android"
+)
+
+func init() {
+	android.RegisterModuleType("ota_updater_package", UpdaterPackageFactory)
+}
+
+var (
+	updaterPackageRule = pctx.AndroidStaticRule("updaterPackage",
+		blueprint.RuleParams{
+			Command: `rm -rf $stagingDir && mkdir -p $stagingDir && $copyCommands && ` +
+				`find $stagingDir -type f | sort > $out.list && ` +
+				`${SoongZipCmd} -o $out -C $stagingDir -l $out.list && rm $out.list`,
+			CommandDeps: []string{"${SoongZipCmd}"},
+		},
+		"stagingDir", "copyCommands")
+)
+
+type otaType int
+
+const (
+	otaNonAb otaType = iota
+	otaAb
+	otaVirtualAb
+)
+
+func (t otaType) String() string {
+	switch t {
+	case otaNonAb:
+		return "non-A/B"
+	case otaAb:
+		return "A/B"
+	case otaVirtualAb:
+		return "virtual A/B"
+	default:
+		panic(fmt.Errorf("unknown OTA type %d", t))
+	}
+}
+
+var abPartitionRegexp = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+// deviceOtaType returns how the device is updated, after checking that the A/B product variables
+// are consistent with each other.
+func deviceOtaType(ctx android.BaseContext) (otaType, error) {
+	config := ctx.DeviceConfig()
+	partitions := config.AbOtaPartitions()
+
+	if !config.AbOtaUpdater() {
+		if config.VirtualAbOta() {
+			return otaNonAb, errors.New("virtual A/B requires AB_OTA_UPDATER := true")
+		}
+		if len(partitions) > 0 {
+			return otaNonAb, errors.New("AB_OTA_PARTITIONS is set but AB_OTA_UPDATER is not true")
+		}
+		return otaNonAb, nil
+	}
+
+	if len(partitions) == 0 {
+		return otaAb, errors.New("A/B devices must list their A/B partitions in AB_OTA_PARTITIONS")
+	}
+	seen := make(map[string]bool)
+	for _, partition := range partitions {
+		if !abPartitionRegexp.MatchString(partition) {
+			return otaAb, fmt.Errorf("invalid partition %q in AB_OTA_PARTITIONS", partition)
+		}
+		if seen[partition] {
+			return otaAb, fmt.Errorf("partition %q is listed in AB_OTA_PARTITIONS more than once", partition)
+		}
+		seen[partition] = true
+	}
+
+	if config.VirtualAbOta() {
+		return otaVirtualAb, nil
+	}
+	return otaAb, nil
+}
+
+// The versions of the block image format that the updater in bootable/recovery understands.
+var supportedBlockimgdiffVersions = []string{"1", "2", "3", "4"}
+
+type updaterPackageProperties struct {
+	// name of the static cc_binary module to package as the edify update-binary.  Defaults to
+	// "updater".
+	Updater *string
+
+	// device specific releasetools.py extensions used when generating the updater-script
+	Releasetools *string
+
+	// list of recovery resource files, relative to this directory, that will be placed in the
+	// res directory of the recovery ramdisk
+	Recovery_resources []string
+
+	// the recovery API version that the updater-script is generated for.  Defaults to 3.
+	Recovery_api_version *int64
+
+	// the version of the recovery.fstab format used by the device.  Defaults to 2.
+	Fstab_version *int64
+
+	// the block image versions that the updater supports for block-based OTAs.  Defaults to
+	// ["3", "4"].
+	Blockimgdiff_versions []string
+
+	// the postinstall config of A/B devices, listing the programs that update_engine runs on
+	// the new slot after writing it
+	Postinstall_config *string
+}
+
+type updaterPackage struct {
+	android.ModuleBase
+
+	properties updaterPackageProperties
+
+	miscInfo   android.Path
+	outputFile android.Path
+}
+
+type updaterDependencyTag struct {
+	blueprint.BaseDependencyTag
+}
+
+var updaterTag updaterDependencyTag
+
+type outputFileProducer interface {
+	OutputFile() android.OptionalPath
+}
+
+func UpdaterPackageFactory() android.Module {
+	module := &updaterPackage{}
+	module.AddProperties(&module.properties)
+	android.InitAndroidArchModule(module, android.DeviceSupported, android.MultilibFirst)
+	return module
+}
+
+func (m *updaterPackage) updater() string {
+	if m.properties.Updater != nil {
+		return *m.properties.Updater
+	}
+	return "updater"
+}
+
+func (m *updaterPackage) DepsMutator(ctx android.BottomUpMutatorContext) {
+	android.ExtractSourcesDeps(ctx, m.properties.Recovery_resources)
+
+	if otaType, err := deviceOtaType(ctx); err != nil || otaType != otaNonAb {
+		// A/B devices are updated by update_engine and have no edify updater.  Errors in the
+		// A/B configuration are reported in GenerateAndroidBuildActions.
+		return
+	}
+
+	ctx.AddFarVariationDependencies([]blueprint.Variation{
+		{Mutator: "arch", Variation: ctx.Target().String()},
+		{Mutator: "image", Variation: "core"},
+		{Mutator: "sdk", Variation: ""},
+	}, updaterTag, m.updater())
+}
+
+func (m *updaterPackage) blockimgdiffVersions(ctx android.ModuleContext) []string {
+	versions := m.properties.Blockimgdiff_versions
+	if len(versions) == 0 {
+		return []string{"3", "4"}
+	}
+
+	for _, v := range versions {
+		if !inList(v, supportedBlockimgdiffVersions) {
+			ctx.PropertyErrorf("blockimgdiff_versions", "unsupported version %q, must be one of %v",
+				v, supportedBlockimgdiffVersions)
+		}
+	}
+	// Sort a copy, the properties are shared with the other variants of the module
+	sorted := append([]string(nil), versions...)
+	sort.Strings(sorted)
+	return sorted
+}
+
+func (m *updaterPackage) GenerateAndroidBuildActions(ctx android.ModuleContext) {
+	otaType, err := deviceOtaType(ctx)
+	if err != nil {
+		ctx.ModuleErrorf("%s", err)
+		return
+	}
+
+	if otaType == otaNonAb {
+		if m.properties.Postinstall_config != nil {
+			ctx.PropertyErrorf("postinstall_config", "only supported on A/B devices")
+		}
+	} else {
+		if m.properties.Updater != nil {
+			ctx.PropertyErrorf("updater", "not supported on %s devices, which are updated by update_engine",
+				otaType)
+		}
+		if len(m.properties.Blockimgdiff_versions) > 0 {
+			ctx.PropertyErrorf("blockimgdiff_versions", "not supported on %s devices", otaType)
+		}
+	}
+	if ctx.Failed() {
+		return
+	}
+
+	miscInfo := []string{
+		"recovery_api_version=" + strconv.FormatInt(int64Default(m.properties.Recovery_api_version, 3), 10),
+		"fstab_version=" + strconv.FormatInt(int64Default(m.properties.Fstab_version, 2), 10),
+	}
+
+	var implicits android.Paths
+	var copyCommands []string
+	stagingDir := android.PathForModuleOut(ctx, "staging")
+
+	switch otaType {
+	case otaNonAb:
+		updater := m.updaterOutputFile(ctx)
+		if updater == nil {
+			return
+		}
+		implicits = append(implicits, updater)
+		copyCommands = append(copyCommands,
+			copyCommand(updater, stagingDir.Join(ctx, "OTA", "bin", "updater")))
+
+		miscInfo = append(miscInfo,
+			"blockimgdiff_versions="+strings.Join(m.blockimgdiffVersions(ctx), ","),
+			"use_set_metadata=1",
+			"update_rename_support=1")
+	case otaAb, otaVirtualAb:
+		miscInfo = append(miscInfo, "ab_update=true")
+		if otaType == otaVirtualAb {
+			miscInfo = append(miscInfo, "virtual_ab=true")
+		}
+
+		abPartitions := m.writeAbPartitions(ctx)
+		implicits = append(implicits, abPartitions)
+		copyCommands = append(copyCommands,
+			copyCommand(abPartitions, stagingDir.Join(ctx, "META", "ab_partitions.txt")))
+
+		if m.properties.Postinstall_config != nil {
+			postinstallConfig := android.PathForModuleSrc(ctx, *m.properties.Postinstall_config)
+			implicits = append(implicits, postinstallConfig)
+			copyCommands = append(copyCommands,
+				copyCommand(postinstallConfig, stagingDir.Join(ctx, "META", "postinstall_config.txt")))
+		}
+	}
+	if ctx.Failed() {
+		return
+	}
+
+	miscInfoFile := android.PathForModuleOut(ctx, "misc_info.txt")
+	ctx.ModuleBuild(pctx, android.ModuleBuildParams{
+		Rule:        android.WriteFile,
+		Description: "OTA misc_info.txt",
+		Output:      miscInfoFile,
+		Args: map[string]string{
+			"content": strings.Join(miscInfo, `\n`),
+		},
+	})
+
+	implicits = append(implicits, miscInfoFile)
+	copyCommands = append(copyCommands,
+		copyCommand(miscInfoFile, stagingDir.Join(ctx, "META", "misc_info.txt")))
+
+	if m.properties.Releasetools != nil {
+		releasetools := android.PathForModuleSrc(ctx, *m.properties.Releasetools)
+		implicits = append(implicits, releasetools)
+		copyCommands = append(copyCommands,
+			copyCommand(releasetools, stagingDir.Join(ctx, "META", "releasetools.py")))
+	}
+
+	resources := ctx.ExpandSources(m.properties.Recovery_resources, nil)
+	for _, res := range resources {
+		implicits = append(implicits, res)
+		copyCommands = append(copyCommands,
+			copyCommand(res, stagingDir.Join(ctx, "RECOVERY", "RAMDISK", "res", res.Rel())))
+	}
+
+	outputFile := android.PathForModuleOut(ctx, ctx.ModuleName()+".zip")
+	ctx.ModuleBuild(pctx, android.ModuleBuildParams{
+		Rule:        updaterPackageRule,
+		Description: "OTA updater package",
+		Output:      outputFile,
+		Implicits:   implicits,
+		Args: map[string]string{
+			"stagingDir":   stagingDir.String(),
+			"copyCommands": strings.Join(copyCommands, " && "),
+		},
+	})
+
+	m.miscInfo = miscInfoFile
+	m.outputFile = outputFile
+}
+
+// updaterOutputFile returns the edify update-binary built by the updater dependency.
+func (m *updaterPackage) updaterOutputFile(ctx android.ModuleContext) android.Path {
+	var updater android.Path
+	ctx.VisitDirectDeps(func(module blueprint.Module) {
+		if ctx.OtherModuleDependencyTag(module) != updaterTag {
+			return
+		}
+		if p, ok := module.(outputFileProducer); ok && p.OutputFile().Valid() {
+			updater = p.OutputFile().Path()
+		} else {
+			ctx.PropertyErrorf("updater", "module %q does not produce an update-binary",
+				ctx.OtherModuleName(module))
+		}
+	})
+
+	if updater == nil && !ctx.Failed() {
+		ctx.PropertyErrorf("updater", "missing updater module %q", m.updater())
+	}
+	return updater
+}
+
+// writeAbPartitions writes the list of A/B partitions that ota_from_target_files includes in
+// the payload of A/B OTA packages.
+func (m *updaterPackage) writeAbPartitions(ctx android.ModuleContext) android.Path {
+	abPartitions := android.PathForModuleOut(ctx, "ab_partitions.txt")
+	ctx.ModuleBuild(pctx, android.ModuleBuildParams{
+		Rule:        android.WriteFile,
+		Description: "OTA ab_partitions.txt",
+		Output:      abPartitions,
+		Args: map[string]string{
+			"content": strings.Join(ctx.DeviceConfig().AbOtaPartitions(), `\n`),
+		},
+	})
+	return abPartitions
+}
+
+func copyCommand(from, to android.Path) string {
+	return fmt.Sprintf("mkdir -p %s && cp -f %s %s", filepath.Dir(to.String()), from.String(), to.String())
+}
+
+func int64Default(i *int64, def int64) int64 {
+	if i != nil {
+		return *i
+	}
+	return def
+}
+
+func inList(s string, l []string) bool {
+	for _, e := range l {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *updaterPackage) AndroidMk() android.AndroidMkData {
+	return android.AndroidMkData{
+		Class:      "ETC",
+		OutputFile: android.OptionalPathForPath(m.outputFile),
+		Extra: []android.AndroidMkExtraFunc{
+			func(w io.Writer, outputFile android.Path) {
+				fmt.Fprintln(w, "LOCAL_UNINSTALLABLE_MODULE := true")
+			},
+		},
+	}
+}
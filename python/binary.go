@@ -21,6 +21,8 @@ import (
 	"path/filepath"
 	"strings"
 
+	"github.com/google/blueprint"
+
 	"android/soong/android"
 )
 
@@ -40,6 +42,11 @@ type PythonBinaryBaseProperties struct {
 
 	// append to the name of the output binary.
 	Suffix string
+
+	// if true, the binary is prefixed with a launcher that embeds the Python interpreter of
+	// its version (py2-launcher or py3-launcher) instead of a #!/usr/bin/env python line, so
+	// that it runs without a Python installed on the host.
+	Embedded_launcher *bool
 }
 
 type pythonBinaryBase struct {
@@ -70,6 +77,10 @@ func (p *pythonBinaryHostDecorator) install(ctx android.ModuleContext, file andr
 
 var (
 	stubTemplateHost = "build/soong/python/scripts/stub_template_host.txt"
+
+	// the cc_binary_host modules that embed the Python interpreters.
+	py2Launcher = "py2-launcher"
+	py3Launcher = "py3-launcher"
 )
 
 func PythonBinaryHostFactory() android.Module {
@@ -80,8 +91,10 @@ func PythonBinaryHostFactory() android.Module {
 	module.pythonBaseModule.installer = decorator
 	module.AddProperties(&module.binaryProperties)
 
+	// Python binaries are built for the first arch only, so that they have the variant of the
+	// build OS that genrules request for their tools.
 	return InitPythonBaseModule(&module.pythonBinaryBase.pythonBaseModule,
-		&module.pythonBinaryBase, android.HostSupportedNoCross)
+		&module.pythonBinaryBase, android.HostSupportedNoCross, android.MultilibFirst)
 }
 
 func (p *pythonBinaryBase) GeneratePythonBuildActions(ctx android.ModuleContext) android.OptionalPath {
@@ -139,15 +152,40 @@ func (p *pythonBinaryBase) GeneratePythonBuildActions(ctx android.ModuleContext)
 		return android.OptionalPath{}
 	}
 
+	var launcher android.OptionalPath
+	if p.embeddedLauncher() {
+		ctx.VisitDirectDeps(func(m blueprint.Module) {
+			if ctx.OtherModuleDependencyTag(m) != launcherTag {
+				return
+			}
+			if provider, ok := m.(interface {
+				OutputFile() android.OptionalPath
+			}); ok {
+				launcher = provider.OutputFile()
+			}
+		})
+		if !launcher.Valid() {
+			ctx.PropertyErrorf("embedded_launcher", "missing the launcher of %s",
+				p.pythonBaseModule.properties.ActualVersion)
+			return android.OptionalPath{}
+		}
+		// the launcher runs the program with the interpreter it embeds.
+		interp = ""
+	}
+
 	// we need remove "runfiles/" suffix since stub script starts
 	// searching for main file in each sub-dir of "runfiles" directory tree.
-	binFile := registerBuildActionForParFile(ctx, p.getInterpreter(ctx),
+	binFile := registerBuildActionForParFile(ctx, interp, launcher,
 		strings.TrimPrefix(main, runFiles+"/"), p.getStem(ctx),
 		newPyPkgs, append(p.depsParSpecs, p.pythonBaseModule.parSpec))
 
 	return android.OptionalPathForPath(binFile)
 }
 
+func (p *pythonBinaryBase) embeddedLauncher() bool {
+	return android.Bool(p.binaryProperties.Embedded_launcher)
+}
+
 // get interpreter path.
 func (p *pythonBinaryBase) getInterpreter(ctx android.ModuleContext) string {
 	var interp string
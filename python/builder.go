@@ -32,11 +32,11 @@ var (
 		blueprint.RuleParams{
 			Command: `touch $initFile && ` +
 				`sed -e 's/%interpreter%/$interp/g' -e 's/%main%/$main/g' $template > $stub && ` +
-				`$parCmd -o $parFile $parArgs && echo '#!/usr/bin/env python' | cat - $parFile > $out && ` +
+				`$parCmd -o $parFile $parArgs && $prefix | cat - $parFile > $out && ` +
 				`chmod +x $out && (rm -f $initFile; rm -f $stub; rm -f $parFile)`,
 			CommandDeps: []string{"$parCmd", "$template"},
 		},
-		"initFile", "interp", "main", "template", "stub", "parCmd", "parFile", "parArgs")
+		"initFile", "interp", "main", "template", "stub", "parCmd", "parFile", "parArgs", "prefix")
 )
 
 func init() {
@@ -89,8 +89,11 @@ func registerBuildActionForModuleFileList(ctx android.ModuleContext,
 	return fileList
 }
 
-func registerBuildActionForParFile(ctx android.ModuleContext,
-	interpreter, main, binName string, newPyPkgs []string, parSpecs []parSpec) android.Path {
+// registerBuildActionForParFile zips the runfiles of the binary, and prefixes the zip with
+// a #! line that runs it with the interpreter, or with the launcher that embeds it.
+func registerBuildActionForParFile(ctx android.ModuleContext, interpreter string,
+	launcher android.OptionalPath, main, binName string, newPyPkgs []string,
+	parSpecs []parSpec) android.Path {
 
 	// intermediate output path for __init__.py
 	initFile := android.PathForModuleOut(ctx, initFileName).String()
@@ -125,6 +128,12 @@ func registerBuildActionForParFile(ctx android.ModuleContext,
 		parArgs = append(parArgs, p.soongParArgs())
 	}
 
+	prefix := `echo '#!/usr/bin/env python'`
+	if launcher.Valid() {
+		prefix = "cat " + launcher.String()
+		implicits = append(implicits, launcher.Path())
+	}
+
 	ctx.ModuleBuild(pctx, android.ModuleBuildParams{
 		Rule:        par,
 		Description: "python archive",
@@ -140,6 +149,7 @@ func registerBuildActionForParFile(ctx android.ModuleContext,
 			"stub":     stub,
 			"parFile":  parFile.String(),
 			"parArgs":  strings.Join(parArgs, " "),
+			"prefix":   prefix,
 		},
 	})
 
@@ -22,6 +22,7 @@ import (
 
 func init() {
 	android.RegisterModuleType("python_library_host", PythonLibraryHostFactory)
+	android.RegisterModuleType("python_library", PythonLibraryFactory)
 }
 
 type PythonLibrary struct {
@@ -33,5 +34,13 @@ var _ PythonSubModule = (*PythonLibrary)(nil)
 func PythonLibraryHostFactory() android.Module {
 	module := &PythonLibrary{}
 
-	return InitPythonBaseModule(&module.pythonBaseModule, module, android.HostSupportedNoCross)
+	return InitPythonBaseModule(&module.pythonBaseModule, module, android.HostSupportedNoCross,
+		android.MultilibFirst)
+}
+
+func PythonLibraryFactory() android.Module {
+	module := &PythonLibrary{}
+
+	return InitPythonBaseModule(&module.pythonBaseModule, module, android.HostAndDeviceSupported,
+		android.MultilibFirst)
 }
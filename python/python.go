@@ -151,24 +151,31 @@ var _ PythonDependency = (*pythonBaseModule)(nil)
 
 var _ android.AndroidMkDataProvider = (*pythonBaseModule)(nil)
 
+// InitPythonBaseModule initializes a Python module that is built for the architectures selected
+// by multilib.  The libraries must be built for the same architectures as the binaries that
+// depend on them.
 func InitPythonBaseModule(baseModule *pythonBaseModule, subModule PythonSubModule,
-	hod android.HostOrDeviceSupported) android.Module {
+	hod android.HostOrDeviceSupported, multilib android.Multilib) android.Module {
 
 	baseModule.subModule = subModule
 
 	baseModule.AddProperties(&baseModule.properties)
 
-	android.InitAndroidArchModule(baseModule, hod, android.MultilibCommon)
+	android.InitAndroidArchModule(baseModule, hod, multilib)
 
 	return baseModule
 }
 
-// the tag used to mark dependencies within "py_libs" attribute.
+// the tag used to mark dependencies within "py_libs" attribute, and on the launcher.
 type pythonDependencyTag struct {
 	blueprint.BaseDependencyTag
+	name string
 }
 
-var pyDependencyTag pythonDependencyTag
+var (
+	pyDependencyTag = pythonDependencyTag{name: "pythonLib"}
+	launcherTag     = pythonDependencyTag{name: "launcher"}
+)
 
 var (
 	pyIdentifierRegexp = regexp.MustCompile(`^([a-z]|[A-Z]|_)([a-z]|[A-Z]|[0-9]|_)*$`)
@@ -181,19 +188,21 @@ var (
 	runFiles           = "runfiles"
 )
 
-// create version variants for modules.
+// create version variants for modules.  The Python 3 variant comes first, so that the
+// dependencies that don't select a version, like the tools of genrules, use it if the module is
+// built for Python 3, and the Python 2 variant otherwise.
 func versionSplitMutator() func(android.BottomUpMutatorContext) {
 	return func(mctx android.BottomUpMutatorContext) {
 		if base, ok := mctx.Module().(*pythonBaseModule); ok {
 			versionNames := []string{}
-			if base.properties.Version.Py2.Enabled != nil &&
-				*(base.properties.Version.Py2.Enabled) == true {
-				versionNames = append(versionNames, pyVersion2)
-			}
 			if !(base.properties.Version.Py3.Enabled != nil &&
 				*(base.properties.Version.Py3.Enabled) == false) {
 				versionNames = append(versionNames, pyVersion3)
 			}
+			if base.properties.Version.Py2.Enabled != nil &&
+				*(base.properties.Version.Py2.Enabled) == true {
+				versionNames = append(versionNames, pyVersion2)
+			}
 			modules := mctx.CreateVariations(versionNames...)
 			for i, v := range versionNames {
 				// set the actual version for Python module.
@@ -228,6 +237,17 @@ func (p *pythonBaseModule) DepsMutator(ctx android.BottomUpMutatorContext) {
 		panic(fmt.Errorf("unknown Python actualVersion: %q for module: %q.",
 			p.properties.ActualVersion, ctx.ModuleName()))
 	}
+
+	// dep on the launcher that embeds the interpreter into the binary.
+	if sub, ok := p.subModule.(*pythonBinaryBase); ok && sub.embeddedLauncher() {
+		launcher := py2Launcher
+		if p.properties.ActualVersion == pyVersion3 {
+			launcher = py3Launcher
+		}
+		ctx.AddFarVariationDependencies([]blueprint.Variation{
+			{"arch", ctx.Target().String()},
+		}, launcherTag, launcher)
+	}
 }
 
 // check "libs" duplicates from current module dependencies.
@@ -266,6 +286,15 @@ func (p *pythonBaseModule) GenerateAndroidBuildActions(ctx android.ModuleContext
 	}
 }
 
+// HostToolPath returns the installed path of a python_binary_host, which allows it to be used
+// as a tool of a genrule.
+func (p *pythonBaseModule) HostToolPath() android.OptionalPath {
+	if binary, ok := p.installer.(*pythonBinaryHostDecorator); ok {
+		return android.OptionalPathForPath(binary.baseInstaller.path)
+	}
+	return android.OptionalPath{}
+}
+
 func (p *pythonBaseModule) GeneratePythonBuildActions(ctx android.ModuleContext) android.OptionalPath {
 	// expand python files from "srcs" property.
 	srcs := p.properties.Srcs
@@ -25,7 +25,10 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/google/blueprint"
+
 	"android/soong/android"
+	"android/soong/genrule"
 )
 
 type pyBinary struct {
@@ -441,3 +444,229 @@ func fail(t *testing.T, errs []error) {
 		t.FailNow()
 	}
 }
+
+type launcherTestModule struct {
+	android.ModuleBase
+	outputFile android.OptionalPath
+}
+
+// newLauncherTestModule returns a host module that stands in for the cc_binary_host modules that
+// embed the Python interpreters.
+func newLauncherTestModule() android.Module {
+	m := &launcherTestModule{}
+	android.InitAndroidArchModule(m, android.HostSupportedNoCross, android.MultilibFirst)
+	return m
+}
+
+func (m *launcherTestModule) DepsMutator(ctx android.BottomUpMutatorContext) {
+}
+
+func (m *launcherTestModule) GenerateAndroidBuildActions(ctx android.ModuleContext) {
+	m.outputFile = android.OptionalPathForPath(android.PathForModuleOut(ctx, ctx.ModuleName()))
+}
+
+func (m *launcherTestModule) OutputFile() android.OptionalPath {
+	return m.outputFile
+}
+
+// newPythonArchFixture returns a test fixture for the host targets with the Python module types,
+// genrule and the stand-in launchers registered.
+func newPythonArchFixture(buildDir, bp string) *android.TestFixture {
+	f := android.NewTestArchFixture(buildDir)
+	f.Config.BuildOsVariant = f.Config.Targets[android.Host][0].String()
+	f.PreDepsMutators(func(ctx android.RegisterMutatorsContext) {
+		ctx.BottomUp("version_split", versionSplitMutator()).Parallel()
+	})
+	f.RegisterModuleType("python_library_host", android.ModuleFactoryAdaptor(PythonLibraryHostFactory))
+	f.RegisterModuleType("python_binary_host", android.ModuleFactoryAdaptor(PythonBinaryHostFactory))
+	f.RegisterModuleType("genrule", android.ModuleFactoryAdaptor(genrule.GenRuleFactory))
+	f.RegisterModuleType("launcher_test_module", android.ModuleFactoryAdaptor(newLauncherTestModule))
+	f.AddBlueprint(bp)
+	f.AddFiles("tool.py", "lib.py", stubTemplateHost)
+	return f
+}
+
+func TestEmbeddedLauncher(t *testing.T) {
+	_, buildDir := setupBuildEnv(t)
+	defer tearDownBuildEnv(buildDir)
+
+	f := newPythonArchFixture(buildDir, `
+		python_binary_host {
+			name: "tool",
+			main: "tool.py",
+			srcs: ["tool.py"],
+			embedded_launcher: true,
+			version: {
+				py2: {
+					enabled: true,
+				},
+			},
+		}
+
+		python_binary_host {
+			name: "script",
+			main: "tool.py",
+			srcs: ["tool.py"],
+		}
+
+		launcher_test_module {
+			name: "py2-launcher",
+		}
+
+		launcher_test_module {
+			name: "py3-launcher",
+		}
+	`)
+	f.Prepare(t)
+
+	hostVariant := f.Config.BuildOsVariant
+	for _, version := range []struct{ variant, launcher string }{
+		{pyVersion2, "py2-launcher"},
+		{pyVersion3, "py3-launcher"},
+	} {
+		launcher := f.ModuleForTests(version.launcher, hostVariant).Module().(*launcherTestModule).OutputFile().Path()
+		par := f.ModuleForTests("tool", hostVariant+"_"+version.variant).Rule("par")
+		if par.Args["prefix"] != "cat "+launcher.String() {
+			t.Errorf("tool %s is prefixed with %q, want the %s", version.variant, par.Args["prefix"],
+				version.launcher)
+		}
+		if !inList(launcher.String(), par.Implicits.Strings()) {
+			t.Errorf("tool %s does not depend on the %s: %q", version.variant, version.launcher,
+				par.Implicits)
+		}
+		// The launcher runs the program with the interpreter it embeds
+		if par.Args["interp"] != "" {
+			t.Errorf("tool %s uses the interpreter %q", version.variant, par.Args["interp"])
+		}
+	}
+
+	par := f.ModuleForTests("script", hostVariant+"_"+pyVersion3).Rule("par")
+	if par.Args["prefix"] != `echo '#!/usr/bin/env python'` {
+		t.Errorf("script is prefixed with %q", par.Args["prefix"])
+	}
+}
+
+func TestEmbeddedLauncherErrors(t *testing.T) {
+	_, buildDir := setupBuildEnv(t)
+	defer tearDownBuildEnv(buildDir)
+
+	f := newPythonArchFixture(buildDir, `
+		python_binary_host {
+			name: "tool",
+			srcs: ["tool.py"],
+			embedded_launcher: true,
+		}
+	`)
+	android.FailIfNoMatchingErrors(t, `depends on undefined module "py3-launcher"`, f.PrepareWithErrors())
+}
+
+func TestHostToolPath(t *testing.T) {
+	_, buildDir := setupBuildEnv(t)
+	defer tearDownBuildEnv(buildDir)
+
+	f := newPythonArchFixture(buildDir, `
+		python_binary_host {
+			name: "tool",
+			main: "tool.py",
+			srcs: ["tool.py"],
+			libs: ["lib"],
+		}
+
+		python_library_host {
+			name: "lib",
+			srcs: ["lib.py"],
+		}
+	`)
+	f.Prepare(t)
+
+	variant := f.Config.BuildOsVariant + "_" + pyVersion3
+	tool := f.ModuleForTests("tool", variant).Module().(*pythonBaseModule)
+	path := tool.HostToolPath()
+	if !path.Valid() || !strings.HasSuffix(path.String(), "/host/linux-x86/bin/tool") {
+		t.Errorf("tool has the host tool path %q, want its installed binary", path)
+	}
+
+	if lib := f.ModuleForTests("lib", variant).Module().(*pythonBaseModule); lib.HostToolPath().Valid() {
+		t.Errorf("lib has the host tool path %q", lib.HostToolPath())
+	}
+}
+
+func TestGenruleTools(t *testing.T) {
+	_, buildDir := setupBuildEnv(t)
+	defer tearDownBuildEnv(buildDir)
+
+	f := newPythonArchFixture(buildDir, `
+		genrule {
+			name: "gen",
+			tools: ["py3_tool", "both_tool", "py2_tool"],
+			cmd: "$(location py3_tool) && $(location both_tool) && $(location py2_tool) > $(out)",
+			out: ["out.txt"],
+		}
+
+		python_binary_host {
+			name: "py3_tool",
+			main: "tool.py",
+			srcs: ["tool.py"],
+		}
+
+		python_binary_host {
+			name: "both_tool",
+			main: "tool.py",
+			srcs: ["tool.py"],
+			version: {
+				py2: {
+					enabled: true,
+				},
+			},
+		}
+
+		python_binary_host {
+			name: "py2_tool",
+			main: "tool.py",
+			srcs: ["tool.py"],
+			version: {
+				py2: {
+					enabled: true,
+				},
+				py3: {
+					enabled: false,
+				},
+			},
+		}
+	`)
+	f.Prepare(t)
+
+	// The tools run with Python 3, unless they are only built for Python 2
+	expected := map[string]string{
+		"py3_tool":  pyVersion3,
+		"both_tool": pyVersion3,
+		"py2_tool":  pyVersion2,
+	}
+	gen := f.ModuleForTests("gen", "").Module()
+	versions := map[string]string{}
+	f.VisitDirectDeps(gen, func(m blueprint.Module) {
+		if tool, ok := m.(*pythonBaseModule); ok {
+			versions[f.ModuleName(m)] = tool.properties.ActualVersion
+		}
+	})
+	if !reflect.DeepEqual(versions, expected) {
+		t.Errorf("gen runs the tools %v, want %v", versions, expected)
+	}
+
+	rule := f.ModuleForTests("gen", "").Output("out.txt")
+	for name, version := range expected {
+		tool := f.ModuleForTests(name, f.Config.BuildOsVariant+"_"+version).Module().(*pythonBaseModule)
+		if !inList(tool.HostToolPath().String(), rule.Implicits.Strings()) {
+			t.Errorf("gen does not depend on %s: %q", name, rule.Implicits)
+		}
+	}
+}
+
+func inList(s string, list []string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
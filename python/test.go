@@ -50,5 +50,5 @@ func PythonTestHostFactory() android.Module {
 	module.AddProperties(&module.binaryProperties)
 
 	return InitPythonBaseModule(&module.pythonBinaryBase.pythonBaseModule,
-		&module.pythonBinaryBase, android.HostSupportedNoCross)
+		&module.pythonBinaryBase, android.HostSupportedNoCross, android.MultilibFirst)
 }
@@ -0,0 +1,91 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rust
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"android/soong/android"
+)
+
+func (mod *Module) AndroidMk() android.AndroidMkData {
+	ret := android.AndroidMkData{
+		OutputFile: mod.outputFile,
+	}
+
+	switch compiler := mod.compiler.(type) {
+	case *binaryDecorator:
+		ret.Class = "EXECUTABLES"
+		compiler.baseCompiler.androidMk(mod, &ret)
+	case *libraryDecorator:
+		if !compiler.ffi() && !compiler.shared() {
+			// rlibs are only used by other rust modules
+			ret.Disabled = true
+			return ret
+		}
+		if compiler.shared() {
+			ret.Class = "SHARED_LIBRARIES"
+			compiler.baseCompiler.androidMk(mod, &ret)
+		} else {
+			ret.Class = "STATIC_LIBRARIES"
+			ret.Extra = append(ret.Extra, func(w io.Writer, outputFile android.Path) {
+				fmt.Fprintln(w, "LOCAL_MODULE_SUFFIX := "+outputFile.Ext())
+			})
+		}
+		if len(compiler.includeDirs) > 0 {
+			ret.Extra = append(ret.Extra, func(w io.Writer, outputFile android.Path) {
+				fmt.Fprintln(w, "LOCAL_EXPORT_C_INCLUDE_DIRS :=",
+					strings.Join(compiler.includeDirs.Strings(), " "))
+			})
+		}
+	}
+
+	return ret
+}
+
+func (compiler *baseCompiler) androidMk(mod *Module, ret *android.AndroidMkData) {
+	// Soong installation is only supported for host modules. Have Make
+	// installation trigger Soong installation.
+	if mod.Target().Os.Class == android.Host {
+		ret.OutputFile = android.OptionalPathForPath(compiler.path)
+	}
+
+	ret.Extra = append(ret.Extra, func(w io.Writer, outputFile android.Path) {
+		path := compiler.path.RelPathString()
+		dir, file := filepath.Split(path)
+		stem := strings.TrimSuffix(file, filepath.Ext(file))
+		fmt.Fprintln(w, "LOCAL_MODULE_SUFFIX := "+filepath.Ext(file))
+		fmt.Fprintln(w, "LOCAL_MODULE_PATH := $(OUT_DIR)/"+filepath.Clean(dir))
+		fmt.Fprintln(w, "LOCAL_MODULE_STEM := "+stem)
+
+		if mod.Target().Os == android.Android {
+			// The system libraries are linked by rustc, install the other shared libraries
+			// and dylibs that the module was linked against with it
+			fmt.Fprintln(w, "LOCAL_SYSTEM_SHARED_LIBRARIES :=")
+			var libs []string
+			libs = append(libs, compiler.Properties.Shared_libs...)
+			libs = append(libs, compiler.Properties.Dylibs...)
+			if compiler.dylibRustlibs {
+				libs = append(libs, compiler.Properties.Rustlibs...)
+			}
+			if len(libs) > 0 {
+				fmt.Fprintln(w, "LOCAL_SHARED_LIBRARIES := "+strings.Join(libs, " "))
+			}
+		}
+	})
+}
@@ -0,0 +1,91 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rust
+
+import (
+	"android/soong/android"
+)
+
+func init() {
+	android.RegisterModuleType("rust_binary", RustBinaryFactory)
+	android.RegisterModuleType("rust_binary_host", RustBinaryHostFactory)
+}
+
+type binaryDecorator struct {
+	*baseCompiler
+}
+
+var _ compiler = (*binaryDecorator)(nil)
+
+// rust_binary produces a binary that is runnable on a device.
+func RustBinaryFactory() android.Module {
+	module, _ := NewRustBinary(android.HostAndDeviceSupported)
+	return module.Init()
+}
+
+// rust_binary_host produces a binary that is runnable on a host.
+func RustBinaryHostFactory() android.Module {
+	module, _ := NewRustBinary(android.HostSupported)
+	return module.Init()
+}
+
+func NewRustBinary(hod android.HostOrDeviceSupported) (*Module, *binaryDecorator) {
+	module := newBaseModule(hod, android.MultilibFirst)
+
+	binary := &binaryDecorator{
+		baseCompiler: newBaseCompiler("bin", ""),
+	}
+
+	module.compiler = binary
+
+	return module, binary
+}
+
+func (binary *binaryDecorator) compilerDeps(ctx android.BottomUpMutatorContext, deps Deps) Deps {
+	deps = binary.baseCompiler.compilerDeps(ctx, deps)
+
+	if ctx.Os() == android.Android {
+		deps.CrtBegin = "crtbegin_dynamic"
+		deps.CrtEnd = "crtend_android"
+	}
+
+	return deps
+}
+
+func (binary *binaryDecorator) compilerFlags(ctx android.ModuleContext, flags Flags) Flags {
+	flags = binary.baseCompiler.compilerFlags(ctx, flags)
+
+	if ctx.Device() {
+		linker := "/system/bin/linker"
+		if ctx.Arch().ArchType.Multilib == "lib64" {
+			linker += "64"
+		}
+		flags.LinkFlags = append(flags.LinkFlags, "-pie", "-Wl,-dynamic-linker,"+linker)
+	}
+
+	return flags
+}
+
+func (binary *binaryDecorator) compile(ctx android.ModuleContext, flags Flags, deps PathDeps) android.Path {
+	crateRoot := binary.crateRoot(ctx)
+	if crateRoot == nil {
+		return nil
+	}
+
+	outputFile := android.PathForModuleOut(ctx, ctx.ModuleName())
+	transformSrcToCrate(ctx, crateRoot, deps, flags, outputFile, "bin", binary.crateName())
+
+	return outputFile
+}
@@ -0,0 +1,96 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rust
+
+// This file generates the final rules for compiling the crates.
+
+import (
+	"strings"
+
+	"github.com/google/blueprint"
+
+	"android/soong/android"
+)
+
+func init() {
+	pctx.StaticVariable("rustcCmd", "${RustBin}/rustc")
+}
+
+var (
+	rustc = pctx.AndroidStaticRule("rustc",
+		blueprint.RuleParams{
+			Command: "$rustcCmd --crate-name $crateName --crate-type $crateType --target $target " +
+				"$rustcFlags -C linker=${RustLinker} -C link-args=\"$linkFlags\" $libFlags " +
+				"--emit link -o $out --emit dep-info=$out.d $in",
+			CommandDeps: []string{"$rustcCmd"},
+			Depfile:     "$out.d",
+			Deps:        blueprint.DepsGCC,
+		},
+		"crateName", "crateType", "target", "rustcFlags", "linkFlags", "libFlags")
+)
+
+// transformSrcToCrate compiles the crate root into a crate of the given type, which is linked
+// against the rust libraries and the cc libraries in deps.  The other source files of the crate
+// are tracked through the dependency file written by rustc.
+func transformSrcToCrate(ctx android.ModuleContext, crateRoot android.Path, deps PathDeps,
+	flags Flags, outputFile android.WritablePath, crateType, crateName string) {
+
+	var implicits android.Paths
+	var libFlags []string
+	for _, lib := range deps.Rlibs {
+		libFlags = append(libFlags, "--extern "+lib.name+"="+lib.path.String())
+		implicits = append(implicits, lib.path)
+	}
+	for _, lib := range deps.Dylibs {
+		libFlags = append(libFlags, "--extern "+lib.name+"="+lib.path.String())
+		implicits = append(implicits, lib.path)
+	}
+	for _, dir := range deps.linkDirs {
+		libFlags = append(libFlags, "-L dependency="+dir)
+	}
+
+	// The crt objects have to be first and last on the link line, and the cc libraries
+	// after the objects of the crate that use them
+	var linkFlags []string
+	if deps.CrtBegin.Valid() {
+		linkFlags = append(linkFlags, deps.CrtBegin.String())
+		implicits = append(implicits, deps.CrtBegin.Path())
+	}
+	linkFlags = append(linkFlags, flags.LinkFlags...)
+	linkFlags = append(linkFlags, deps.StaticLibs.Strings()...)
+	linkFlags = append(linkFlags, deps.SharedLibs.Strings()...)
+	implicits = append(implicits, deps.StaticLibs...)
+	implicits = append(implicits, deps.SharedLibs...)
+	if deps.CrtEnd.Valid() {
+		linkFlags = append(linkFlags, deps.CrtEnd.String())
+		implicits = append(implicits, deps.CrtEnd.Path())
+	}
+
+	ctx.ModuleBuild(pctx, android.ModuleBuildParams{
+		Rule:        rustc,
+		Description: "rustc " + crateRoot.Rel(),
+		Output:      outputFile,
+		Input:       crateRoot,
+		Implicits:   implicits,
+		Args: map[string]string{
+			"crateName":  crateName,
+			"crateType":  crateType,
+			"target":     rustTriple(ctx.Target()),
+			"rustcFlags": strings.Join(flags.RustFlags, " "),
+			"linkFlags":  strings.Join(linkFlags, " "),
+			"libFlags":   strings.Join(libFlags, " "),
+		},
+	})
+}
@@ -0,0 +1,197 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rust
+
+import (
+	"regexp"
+	"strings"
+
+	"android/soong/android"
+	"android/soong/cc/config"
+)
+
+type BaseCompilerProperties struct {
+	// list of source files of the crate.  The first file is the crate root, which rustc is
+	// invoked on, the others are the modules it includes.
+	Srcs []string `android:"arch_variant"`
+
+	// name of the crate, which other crates refer to it by.  Defaults to the module name with
+	// dashes replaced by underscores.
+	Crate_name string
+
+	// edition of the Rust language that the crate is written in, "2015" or "2018".  Defaults
+	// to "2015".
+	Edition *string
+
+	// list of features of the crate to enable, passed to rustc as --cfg 'feature="<feature>"'
+	Features []string `android:"arch_variant"`
+
+	// list of configuration options to enable, passed to rustc as --cfg <cfg>
+	Cfgs []string `android:"arch_variant"`
+
+	// list of flags passed to rustc
+	Flags []string `android:"arch_variant"`
+
+	// list of flags passed to the linker
+	Ld_flags []string `android:"arch_variant"`
+
+	// list of rust libraries that the crate depends on.  They are linked statically as rlibs,
+	// except into the dylib variants of rust libraries, which link them as dylibs.
+	Rustlibs []string `android:"arch_variant"`
+
+	// list of rust libraries that the crate depends on that are always linked as rlibs
+	Rlibs []string `android:"arch_variant"`
+
+	// list of rust libraries that the crate depends on that are always linked as dylibs
+	Dylibs []string `android:"arch_variant"`
+
+	// list of cc static libraries linked into the crate, whose functions it calls through FFI
+	Static_libs []string `android:"arch_variant"`
+
+	// list of cc shared libraries linked to the crate, whose functions it calls through FFI
+	Shared_libs []string `android:"arch_variant"`
+}
+
+type baseCompiler struct {
+	Properties BaseCompilerProperties
+
+	// Install directories relative to the system image, for 32-bit and 64-bit targets
+	dir   string
+	dir64 string
+
+	// The name of the crate, from crate_name or the module name
+	crate string
+
+	// Whether the rustlibs are linked as dylibs instead of rlibs
+	dylibRustlibs bool
+
+	path android.OutputPath
+}
+
+var crateNameRegexp = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+func newBaseCompiler(dir, dir64 string) *baseCompiler {
+	return &baseCompiler{
+		dir:   dir,
+		dir64: dir64,
+	}
+}
+
+func (compiler *baseCompiler) compilerProps() []interface{} {
+	return []interface{}{&compiler.Properties}
+}
+
+func (compiler *baseCompiler) compilerDeps(ctx android.BottomUpMutatorContext, deps Deps) Deps {
+	android.ExtractSourcesDeps(ctx, compiler.Properties.Srcs)
+
+	if compiler.dylibRustlibs {
+		deps.Dylibs = append(deps.Dylibs, compiler.Properties.Rustlibs...)
+	} else {
+		deps.Rlibs = append(deps.Rlibs, compiler.Properties.Rustlibs...)
+	}
+	deps.Rlibs = append(deps.Rlibs, compiler.Properties.Rlibs...)
+	deps.Dylibs = append(deps.Dylibs, compiler.Properties.Dylibs...)
+	deps.StaticLibs = append(deps.StaticLibs, compiler.Properties.Static_libs...)
+	deps.SharedLibs = append(deps.SharedLibs, compiler.Properties.Shared_libs...)
+
+	if ctx.Os() == android.Android {
+		// The device is linked with -nostdlib, so the rust standard library needs the
+		// system libraries
+		deps.SharedLibs = append(deps.SharedLibs, "libc", "libm", "libdl")
+	}
+
+	return deps
+}
+
+func (compiler *baseCompiler) compilerFlags(ctx android.ModuleContext, flags Flags) Flags {
+	compiler.crate = compiler.Properties.Crate_name
+	if compiler.crate == "" {
+		compiler.crate = strings.Replace(ctx.ModuleName(), "-", "_", -1)
+	}
+	if !crateNameRegexp.MatchString(compiler.crate) {
+		ctx.PropertyErrorf("crate_name", "%q is not a valid crate name", compiler.crate)
+	}
+
+	edition := defaultRustEdition
+	if compiler.Properties.Edition != nil {
+		edition = *compiler.Properties.Edition
+		if !inList(edition, rustEditions) {
+			ctx.PropertyErrorf("edition", "edition %q is not one of %s", edition,
+				strings.Join(rustEditions, ", "))
+		}
+	}
+
+	flags.RustFlags = append(flags.RustFlags, "--edition="+edition, "-C opt-level=2", "-C debuginfo=2")
+	for _, feature := range compiler.Properties.Features {
+		flags.RustFlags = append(flags.RustFlags, "--cfg 'feature=\""+feature+"\"'")
+	}
+	for _, cfg := range compiler.Properties.Cfgs {
+		flags.RustFlags = append(flags.RustFlags, "--cfg '"+cfg+"'")
+	}
+	flags.RustFlags = append(flags.RustFlags, compiler.Properties.Flags...)
+
+	// rustc links with clang, which is given the same flags as when linking C and C++
+	toolchain := config.FindToolchain(ctx.Os(), ctx.Arch())
+	flags.LinkFlags = append(flags.LinkFlags,
+		"-target "+toolchain.ClangTriple(),
+		toolchain.ToolchainClangLdflags(),
+		toolchain.ClangLdflags())
+	if ctx.Device() {
+		flags.LinkFlags = append(flags.LinkFlags, "-nostdlib", "-Wl,--gc-sections")
+	}
+	flags.LinkFlags = append(flags.LinkFlags, compiler.Properties.Ld_flags...)
+
+	return flags
+}
+
+// crateRoot returns the source file that rustc is invoked on, and reports an error if the
+// module has no .rs sources.
+func (compiler *baseCompiler) crateRoot(ctx android.ModuleContext) android.Path {
+	srcs := ctx.ExpandSources(compiler.Properties.Srcs, nil)
+	if len(srcs) == 0 {
+		ctx.PropertyErrorf("srcs", "missing the crate root")
+		return nil
+	}
+	if srcs[0].Ext() != ".rs" {
+		ctx.PropertyErrorf("srcs", "the crate root %q is not a .rs file", srcs[0].Rel())
+		return nil
+	}
+	return srcs[0]
+}
+
+func (compiler *baseCompiler) crateName() string {
+	return compiler.crate
+}
+
+func (compiler *baseCompiler) installDir(ctx android.ModuleContext) android.OutputPath {
+	dir := compiler.dir
+	if ctx.Arch().ArchType.Multilib == "lib64" && compiler.dir64 != "" {
+		dir = compiler.dir64
+	}
+	return android.PathForModuleInstall(ctx, dir)
+}
+
+func (compiler *baseCompiler) install(ctx android.ModuleContext, file android.Path) {
+	compiler.path = ctx.InstallFile(compiler.installDir(ctx), file)
+}
+
+func inList(s string, list []string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
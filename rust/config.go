@@ -0,0 +1,87 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rust
+
+import (
+	"android/soong/android"
+)
+
+var (
+	pctx = android.NewPackageContext("android/soong/rust")
+
+	RustDefaultBase    = "prebuilts/rust/"
+	RustDefaultVersion = "1.31.1"
+
+	// The editions of the language that crates may be written in
+	rustEditions       = []string{"2015", "2018"}
+	defaultRustEdition = "2015"
+)
+
+func init() {
+	pctx.Import("android/soong/cc/config")
+
+	pctx.SourcePathVariable("RustDefaultBase", RustDefaultBase)
+	pctx.VariableFunc("RustBase", func(config interface{}) (string, error) {
+		if override := config.(android.Config).Getenv("RUST_PREBUILTS_BASE"); override != "" {
+			return override, nil
+		}
+		return "${RustDefaultBase}", nil
+	})
+	pctx.VariableFunc("RustVersion", func(config interface{}) (string, error) {
+		if override := config.(android.Config).Getenv("RUST_PREBUILTS_VERSION"); override != "" {
+			return override, nil
+		}
+		return RustDefaultVersion, nil
+	})
+	pctx.StaticVariable("RustPath", "${RustBase}/${config.HostPrebuiltTag}/${RustVersion}")
+	pctx.StaticVariable("RustBin", "${RustPath}/bin")
+
+	// Rust links with the clang used for C and C++, so that the same flags and crt objects
+	// can be used
+	pctx.StaticVariable("RustLinker", "${config.ClangBin}/clang++")
+}
+
+// rustTriple returns the target triple that rustc compiles for, which unlike the clang triple
+// names the architecture variant for 32-bit arm and x86.
+func rustTriple(target android.Target) string {
+	switch target.Os {
+	case android.Android:
+		switch target.Arch.ArchType {
+		case android.Arm:
+			return "armv7-linux-androideabi"
+		case android.Arm64:
+			return "aarch64-linux-android"
+		case android.X86:
+			return "i686-linux-android"
+		case android.X86_64:
+			return "x86_64-linux-android"
+		}
+	case android.Linux:
+		switch target.Arch.ArchType {
+		case android.X86:
+			return "i686-unknown-linux-gnu"
+		case android.X86_64:
+			return "x86_64-unknown-linux-gnu"
+		}
+	case android.Darwin:
+		switch target.Arch.ArchType {
+		case android.X86:
+			return "i686-apple-darwin"
+		case android.X86_64:
+			return "x86_64-apple-darwin"
+		}
+	}
+	return ""
+}
@@ -0,0 +1,204 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rust
+
+import (
+	"android/soong/android"
+)
+
+func init() {
+	android.RegisterModuleType("rust_library", RustLibraryFactory)
+	android.RegisterModuleType("rust_library_host", RustLibraryHostFactory)
+	android.RegisterModuleType("rust_ffi", RustFFIFactory)
+	android.RegisterModuleType("rust_ffi_host", RustFFIHostFactory)
+}
+
+// The variations of rust libraries.  Rust libraries are built as rlibs, which are linked
+// statically, and as dylibs by the rust_libraries mutator.  FFI libraries are built as C
+// compatible static and shared libraries instead, which are created by the link mutator of cc
+// like the variants of cc libraries, for cc modules to link against.
+const (
+	rlibVariation   = "rlib"
+	dylibVariation  = "dylib"
+	staticVariation = "static"
+	sharedVariation = "shared"
+)
+
+type LibraryProperties struct {
+	// list of directories relative to the Android.bp file containing the C headers that
+	// declare the functions of an FFI library, which are exported to the cc modules that
+	// link against it
+	Export_include_dirs []string `android:"arch_variant"`
+}
+
+type libraryDecorator struct {
+	*baseCompiler
+
+	Properties LibraryProperties
+
+	// Whether the library is built for cc modules instead of rust modules
+	ffiLibrary bool
+
+	// The variation of the rust_libraries mutator that this variant was created for
+	variation string
+
+	// The directories containing the crates that the library was compiled against
+	linkDirs []string
+
+	// The directories containing the C headers of an FFI library
+	includeDirs android.Paths
+}
+
+var _ compiler = (*libraryDecorator)(nil)
+
+// rust_library produces a rust library that is usable by rust modules on a device, both
+// as an rlib and as a dylib.
+func RustLibraryFactory() android.Module {
+	module, _ := NewRustLibrary(android.HostAndDeviceSupported, false)
+	return module.Init()
+}
+
+// rust_library_host produces a rust library that is usable by rust modules on a host.
+func RustLibraryHostFactory() android.Module {
+	module, _ := NewRustLibrary(android.HostSupported, false)
+	return module.Init()
+}
+
+// rust_ffi produces a static and a shared library with a C interface from a crate, which cc
+// modules on a device can link against.
+func RustFFIFactory() android.Module {
+	module, _ := NewRustLibrary(android.HostAndDeviceSupported, true)
+	return module.Init()
+}
+
+// rust_ffi_host produces a static and a shared library with a C interface from a crate, which
+// cc modules on a host can link against.
+func RustFFIHostFactory() android.Module {
+	module, _ := NewRustLibrary(android.HostSupported, true)
+	return module.Init()
+}
+
+func NewRustLibrary(hod android.HostOrDeviceSupported, ffiLibrary bool) (*Module, *libraryDecorator) {
+	module := newBaseModule(hod, android.MultilibBoth)
+
+	library := &libraryDecorator{
+		baseCompiler: newBaseCompiler("lib", "lib64"),
+		ffiLibrary:   ffiLibrary,
+	}
+
+	module.compiler = library
+
+	return module, library
+}
+
+func (library *libraryDecorator) compilerProps() []interface{} {
+	return append(library.baseCompiler.compilerProps(), &library.Properties)
+}
+
+func (library *libraryDecorator) ffi() bool {
+	return library.ffiLibrary
+}
+
+// shared returns true for the variants of the library that are linked dynamically, and
+// installed.
+func (library *libraryDecorator) shared() bool {
+	return library.variation == dylibVariation || library.variation == sharedVariation
+}
+
+func (library *libraryDecorator) compilerDeps(ctx android.BottomUpMutatorContext, deps Deps) Deps {
+	deps = library.baseCompiler.compilerDeps(ctx, deps)
+
+	if library.shared() && ctx.Os() == android.Android {
+		deps.CrtBegin = "crtbegin_so"
+		deps.CrtEnd = "crtend_so"
+	}
+
+	return deps
+}
+
+func (library *libraryDecorator) crateType() string {
+	switch library.variation {
+	case rlibVariation:
+		return "rlib"
+	case dylibVariation:
+		return "dylib"
+	case staticVariation:
+		return "staticlib"
+	case sharedVariation:
+		return "cdylib"
+	}
+	panic("unknown rust library variation " + library.variation)
+}
+
+func (library *libraryDecorator) compile(ctx android.ModuleContext, flags Flags, deps PathDeps) android.Path {
+	crateRoot := library.crateRoot(ctx)
+	if crateRoot == nil {
+		return nil
+	}
+
+	// rustc finds the crates that a crate was compiled against by their file names, so rlibs
+	// and dylibs are named after the crate.  FFI libraries are named after the module like the
+	// cc libraries.
+	var fileName string
+	switch library.variation {
+	case rlibVariation:
+		fileName = "lib" + library.crateName() + ".rlib"
+	case dylibVariation:
+		fileName = "lib" + library.crateName() + ".so"
+	case staticVariation:
+		fileName = ctx.ModuleName() + ".a"
+	case sharedVariation:
+		fileName = ctx.ModuleName() + ".so"
+	}
+
+	outputFile := android.PathForModuleOut(ctx, fileName)
+	transformSrcToCrate(ctx, crateRoot, deps, flags, outputFile, library.crateType(), library.crateName())
+
+	library.linkDirs = deps.linkDirs
+	if library.ffi() {
+		library.includeDirs = android.PathsForModuleSrc(ctx, library.Properties.Export_include_dirs)
+	}
+
+	return outputFile
+}
+
+func (library *libraryDecorator) install(ctx android.ModuleContext, file android.Path) {
+	// Only the shared variants are installed, the others are linked into their users
+	if library.shared() {
+		library.baseCompiler.install(ctx, file)
+	}
+}
+
+// libraryMutator creates the rlib and dylib variants of rust libraries.  The variants of FFI
+// libraries have already been created by the link mutator.
+func libraryMutator(mctx android.BottomUpMutatorContext) {
+	m, ok := mctx.Module().(*Module)
+	if !ok {
+		return
+	}
+	library, ok := m.compiler.(*libraryDecorator)
+	if !ok || library.ffi() {
+		return
+	}
+
+	variations := []string{rlibVariation, dylibVariation}
+	modules := mctx.CreateVariations(variations...)
+	for i, variation := range variations {
+		variant := modules[i].(*Module).compiler.(*libraryDecorator)
+		variant.variation = variation
+		// The dylibs link the rust libraries they depend on dynamically
+		variant.dylibRustlibs = variation == dylibVariation
+	}
+}
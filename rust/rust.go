@@ -0,0 +1,289 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rust
+
+// This file contains the module implementation shared by the rust module types.  A module is
+// compiled by a single invocation of rustc on its crate root, which links it against the crates
+// of the rust libraries it depends on, and against the cc libraries it calls through FFI.
+
+import (
+	"path/filepath"
+
+	"github.com/google/blueprint"
+
+	"android/soong/android"
+	"android/soong/cc"
+)
+
+func init() {
+	android.PreDepsMutators(func(ctx android.RegisterMutatorsContext) {
+		ctx.BottomUp("rust_libraries", libraryMutator).Parallel()
+	})
+}
+
+type Deps struct {
+	Rlibs      []string
+	Dylibs     []string
+	StaticLibs []string
+	SharedLibs []string
+
+	CrtBegin, CrtEnd string
+}
+
+type PathDeps struct {
+	Rlibs  []crateDep
+	Dylibs []crateDep
+
+	// The directories containing the crates that the rlibs and dylibs depend on, which
+	// rustc needs to find them
+	linkDirs []string
+
+	StaticLibs android.Paths
+	SharedLibs android.Paths
+
+	CrtBegin, CrtEnd android.OptionalPath
+}
+
+// crateDep is a crate that is passed to rustc with --extern.
+type crateDep struct {
+	name string
+	path android.Path
+}
+
+type Flags struct {
+	RustFlags []string // Flags passed to rustc
+	LinkFlags []string // Flags passed to the linker through rustc
+}
+
+type compiler interface {
+	compilerProps() []interface{}
+	compilerDeps(ctx android.BottomUpMutatorContext, deps Deps) Deps
+	compilerFlags(ctx android.ModuleContext, flags Flags) Flags
+	compile(ctx android.ModuleContext, flags Flags, deps PathDeps) android.Path
+	install(ctx android.ModuleContext, path android.Path)
+
+	crateName() string
+}
+
+type dependencyTag struct {
+	blueprint.BaseDependencyTag
+	name string
+}
+
+var (
+	rlibDepTag      = dependencyTag{name: "rlib"}
+	dylibDepTag     = dependencyTag{name: "dylib"}
+	staticLibDepTag = dependencyTag{name: "static_lib"}
+	sharedLibDepTag = dependencyTag{name: "shared_lib"}
+	crtBeginDepTag  = dependencyTag{name: "crtbegin"}
+	crtEndDepTag    = dependencyTag{name: "crtend"}
+)
+
+// Module contains the properties and members used by all rust module types
+type Module struct {
+	android.ModuleBase
+
+	hod      android.HostOrDeviceSupported
+	multilib android.Multilib
+
+	compiler compiler
+
+	outputFile android.OptionalPath
+}
+
+func newBaseModule(hod android.HostOrDeviceSupported, multilib android.Multilib) *Module {
+	return &Module{
+		hod:      hod,
+		multilib: multilib,
+	}
+}
+
+func (mod *Module) Init() android.Module {
+	mod.AddProperties(mod.compiler.compilerProps()...)
+
+	android.InitAndroidArchModule(mod, mod.hod, mod.multilib)
+
+	return mod
+}
+
+func (mod *Module) OutputFile() android.OptionalPath {
+	return mod.outputFile
+}
+
+var _ cc.LinkableInterface = (*Module)(nil)
+
+func (mod *Module) CcLibrary() bool {
+	library, ok := mod.compiler.(*libraryDecorator)
+	return ok && library.ffi()
+}
+
+func (mod *Module) SetStatic() {
+	mod.compiler.(*libraryDecorator).variation = staticVariation
+}
+
+func (mod *Module) SetShared() {
+	mod.compiler.(*libraryDecorator).variation = sharedVariation
+}
+
+func (mod *Module) Static() bool {
+	library, ok := mod.compiler.(*libraryDecorator)
+	return ok && library.variation == staticVariation
+}
+
+func (mod *Module) Shared() bool {
+	library, ok := mod.compiler.(*libraryDecorator)
+	return ok && library.variation == sharedVariation
+}
+
+// ExportedFlags returns the include directories of the C headers of an FFI library, for the cc
+// modules that link against it.
+func (mod *Module) ExportedFlags() []string {
+	library, ok := mod.compiler.(*libraryDecorator)
+	if !ok {
+		return nil
+	}
+	var flags []string
+	for _, dir := range library.includeDirs {
+		flags = append(flags, "-I"+dir.String())
+	}
+	return flags
+}
+
+func (mod *Module) ExportedFlagsDeps() android.Paths {
+	return nil
+}
+
+// crateName returns the name that other crates refer to the crate of the module by
+func (mod *Module) crateName() string {
+	return mod.compiler.crateName()
+}
+
+func (mod *Module) DepsMutator(ctx android.BottomUpMutatorContext) {
+	deps := mod.compiler.compilerDeps(ctx, Deps{})
+
+	// FFI libraries have the variations of cc libraries, which rust libraries don't
+	archVariation := blueprint.Variation{Mutator: "arch", Variation: ctx.Target().String()}
+	ctx.AddFarVariationDependencies([]blueprint.Variation{archVariation,
+		{Mutator: "rust_libraries", Variation: rlibVariation}}, rlibDepTag, deps.Rlibs...)
+	ctx.AddFarVariationDependencies([]blueprint.Variation{archVariation,
+		{Mutator: "rust_libraries", Variation: dylibVariation}}, dylibDepTag, deps.Dylibs...)
+
+	// The cc modules have variations that rust modules don't, so depend on the variants of
+	// the cc libraries that are installed on the system image
+	ccVariations := []blueprint.Variation{archVariation}
+	if ctx.Os() == android.Android {
+		ccVariations = append(ccVariations,
+			blueprint.Variation{Mutator: "image", Variation: "core"},
+			blueprint.Variation{Mutator: "sdk", Variation: ""})
+	}
+	ctx.AddFarVariationDependencies(append(ccVariations,
+		blueprint.Variation{Mutator: "link", Variation: "static"}), staticLibDepTag, deps.StaticLibs...)
+	ctx.AddFarVariationDependencies(append(ccVariations,
+		blueprint.Variation{Mutator: "link", Variation: "shared"}), sharedLibDepTag, deps.SharedLibs...)
+
+	if deps.CrtBegin != "" {
+		ctx.AddFarVariationDependencies(ccVariations, crtBeginDepTag, deps.CrtBegin)
+	}
+	if deps.CrtEnd != "" {
+		ctx.AddFarVariationDependencies(ccVariations, crtEndDepTag, deps.CrtEnd)
+	}
+}
+
+func (mod *Module) GenerateAndroidBuildActions(ctx android.ModuleContext) {
+	if rustTriple(ctx.Target()) == "" {
+		ctx.ModuleErrorf("rust is not supported for %s", ctx.Target().String())
+		return
+	}
+
+	deps := mod.depsToPaths(ctx)
+	if ctx.Failed() {
+		return
+	}
+
+	flags := mod.compiler.compilerFlags(ctx, Flags{})
+
+	outputFile := mod.compiler.compile(ctx, flags, deps)
+	if ctx.Failed() {
+		return
+	}
+	mod.outputFile = android.OptionalPathForPath(outputFile)
+
+	mod.compiler.install(ctx, outputFile)
+}
+
+func (mod *Module) depsToPaths(ctx android.ModuleContext) PathDeps {
+	var depPaths PathDeps
+	linkDirs := make(map[string]bool)
+	addLinkDir := func(dir string) {
+		if !linkDirs[dir] {
+			linkDirs[dir] = true
+			depPaths.linkDirs = append(depPaths.linkDirs, dir)
+		}
+	}
+
+	ctx.VisitDirectDeps(func(dep blueprint.Module) {
+		depName := ctx.OtherModuleName(dep)
+		depTag := ctx.OtherModuleDependencyTag(dep)
+
+		switch depTag {
+		case rlibDepTag, dylibDepTag:
+			rustDep, ok := dep.(*Module)
+			if !ok {
+				ctx.ModuleErrorf("%s dependency %q is not a rust library", depTag.(dependencyTag).name, depName)
+				return
+			}
+			library, ok := rustDep.compiler.(*libraryDecorator)
+			if !ok || library.ffi() || !rustDep.outputFile.Valid() {
+				ctx.ModuleErrorf("%s dependency %q is not a rust library", depTag.(dependencyTag).name, depName)
+				return
+			}
+
+			crate := crateDep{name: rustDep.crateName(), path: rustDep.outputFile.Path()}
+			if depTag == rlibDepTag {
+				depPaths.Rlibs = append(depPaths.Rlibs, crate)
+			} else {
+				depPaths.Dylibs = append(depPaths.Dylibs, crate)
+			}
+
+			// rustc needs to find the crates that the dependency was compiled against
+			addLinkDir(filepath.Dir(crate.path.String()))
+			for _, dir := range library.linkDirs {
+				addLinkDir(dir)
+			}
+		case staticLibDepTag, sharedLibDepTag, crtBeginDepTag, crtEndDepTag:
+			ccDep, ok := dep.(*cc.Module)
+			if !ok || !ccDep.OutputFile().Valid() {
+				ctx.ModuleErrorf("%s dependency %q is not a cc module", depTag.(dependencyTag).name, depName)
+				return
+			}
+			outputFile := ccDep.OutputFile().Path()
+
+			switch depTag {
+			case staticLibDepTag:
+				depPaths.StaticLibs = append(depPaths.StaticLibs, outputFile)
+			case sharedLibDepTag:
+				depPaths.SharedLibs = append(depPaths.SharedLibs, outputFile)
+			case crtBeginDepTag:
+				depPaths.CrtBegin = android.OptionalPathForPath(outputFile)
+			case crtEndDepTag:
+				depPaths.CrtEnd = android.OptionalPathForPath(outputFile)
+			}
+		}
+	})
+
+	return depPaths
+}
+
+var _ android.AndroidMkDataProvider = (*Module)(nil)
@@ -0,0 +1,200 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rust
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"android/soong/android"
+	"android/soong/cc"
+)
+
+var buildDir string
+
+func setUp() {
+	var err error
+	buildDir, err = ioutil.TempDir("", "soong_rust_test")
+	if err != nil {
+		panic(err)
+	}
+}
+
+func tearDown() {
+	os.RemoveAll(buildDir)
+}
+
+func TestMain(m *testing.M) {
+	run := func() int {
+		setUp()
+		defer tearDown()
+
+		return m.Run()
+	}
+
+	os.Exit(run())
+}
+
+// newRustFixture returns a test fixture with the cc and rust module types and mutators
+// registered.
+func newRustFixture(bp string) *android.TestFixture {
+	f := cc.NewTestFixture(buildDir)
+	f.RegisterModuleType("rust_library", android.ModuleFactoryAdaptor(RustLibraryFactory))
+	f.RegisterModuleType("rust_ffi", android.ModuleFactoryAdaptor(RustFFIFactory))
+	f.PreDepsMutators(func(ctx android.RegisterMutatorsContext) {
+		ctx.BottomUp("rust_libraries", libraryMutator).Parallel()
+	})
+	f.AddBlueprint(bp)
+	f.AddFiles("foo.c", "bar.c", "lib.rs", "rust.rs", "include/ffi.h")
+	return f
+}
+
+func TestRustTriple(t *testing.T) {
+	testCases := []struct {
+		os     android.OsType
+		arch   android.ArchType
+		triple string
+	}{
+		{android.Android, android.Arm, "armv7-linux-androideabi"},
+		{android.Android, android.Arm64, "aarch64-linux-android"},
+		{android.Android, android.X86, "i686-linux-android"},
+		{android.Android, android.Mips, ""},
+		{android.Linux, android.X86_64, "x86_64-unknown-linux-gnu"},
+		{android.Darwin, android.X86_64, "x86_64-apple-darwin"},
+		{android.Windows, android.X86_64, ""},
+	}
+
+	for _, testCase := range testCases {
+		target := android.Target{
+			Os:   testCase.os,
+			Arch: android.Arch{ArchType: testCase.arch},
+		}
+		if triple := rustTriple(target); triple != testCase.triple {
+			t.Errorf("expected %q for %s %s, got %q", testCase.triple, testCase.os,
+				testCase.arch, triple)
+		}
+	}
+}
+
+func TestCrateNameRegexp(t *testing.T) {
+	testCases := []struct {
+		name  string
+		valid bool
+	}{
+		{"foo", true},
+		{"foo_bar2", true},
+		{"_foo", true},
+		{"foo-bar", false},
+		{"2foo", false},
+		{"", false},
+	}
+
+	for _, testCase := range testCases {
+		if valid := crateNameRegexp.MatchString(testCase.name); valid != testCase.valid {
+			t.Errorf("expected %q to be valid: %v, got %v", testCase.name, testCase.valid, valid)
+		}
+	}
+}
+
+func TestRustFFI(t *testing.T) {
+	f := newRustFixture(`
+		rust_ffi {
+			name: "libffi",
+			srcs: ["lib.rs"],
+			rustlibs: ["librust"],
+			export_include_dirs: ["include"],
+		}
+
+		rust_library {
+			name: "librust",
+			srcs: ["rust.rs"],
+		}
+
+		cc_binary {
+			name: "foo",
+			srcs: ["foo.c"],
+			static_libs: ["libffi"],
+		}
+
+		cc_library_shared {
+			name: "libbar",
+			srcs: ["bar.c"],
+			shared_libs: ["libffi"],
+		}
+	`)
+	f.Prepare(t)
+
+	// The FFI library is built as C libraries in the variants of cc libraries, against the
+	// rlibs of the rust libraries
+	for _, test := range []struct {
+		variant, crateType, output string
+	}{
+		{"android_arm64_armv8-a_static_core", "staticlib", "libffi.a"},
+		{"android_arm64_armv8-a_shared_core", "cdylib", "libffi.so"},
+	} {
+		rustc := f.ModuleForTests("libffi", test.variant).Output(test.output)
+		if rustc.Args["crateType"] != test.crateType {
+			t.Errorf("libffi %s crate type %q, want %q", test.variant, rustc.Args["crateType"],
+				test.crateType)
+		}
+		if !strings.Contains(rustc.Args["libFlags"], "--extern librust=") ||
+			!strings.Contains(rustc.Args["libFlags"], "/android_arm64_armv8-a_rlib/liblibrust.rlib") {
+			t.Errorf("libffi %s is not linked against the librust rlib: %q", test.variant,
+				rustc.Args["libFlags"])
+		}
+	}
+
+	staticLib := f.ModuleForTests("libffi", "android_arm64_armv8-a_static_core").Output("libffi.a").Output
+	sharedLib := f.ModuleForTests("libffi", "android_arm64_armv8-a_shared_core").Output("libffi.so").Output
+
+	for _, test := range []struct {
+		name, variant string
+		lib           android.Path
+	}{
+		{"foo", "android_arm64_armv8-a_core", staticLib},
+		{"libbar", "android_arm64_armv8-a_shared_core", sharedLib},
+	} {
+		m := f.ModuleForTests(test.name, test.variant)
+
+		ld := m.Rule("ld")
+		if !inList(test.lib.String(), ld.Implicits.Strings()) {
+			t.Errorf("%s is not linked against %q: %q", test.name, test.lib, ld.Implicits)
+		}
+
+		// The include directories of the FFI library are exported to the cc modules
+		cFlags := m.Rule("cc").Args["cFlags"]
+		if !strings.Contains(cFlags, "-Iinclude") {
+			t.Errorf("%s is not compiled with the include directories of libffi: %q", test.name, cFlags)
+		}
+	}
+}
+
+func TestRustFFIErrors(t *testing.T) {
+	f := newRustFixture(`
+		rust_ffi {
+			name: "libffi",
+			srcs: ["lib.rs"],
+		}
+
+		cc_library_shared {
+			name: "libbar",
+			srcs: ["bar.c"],
+			whole_static_libs: ["libffi"],
+		}
+	`)
+	android.FailIfNoMatchingErrors(t, `module "libffi" is not a cc library`, f.PrepareWithErrors())
+}
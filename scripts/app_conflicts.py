@@ -0,0 +1,99 @@
+#!/usr/bin/env python
+#
+# Copyright (C) 2017 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+"""Checks that the apps installed by the build do not conflict.
+
+Apps that provide the same package, for example microG and the Google Play
+services that are both named com.google.android.gms, or a GMS stub and the
+app it stands in for, cannot be installed together: the package manager only
+keeps one of them, and the apps that depend on the other one break.  The same
+applies to an app and an app that it overrides.
+
+  app_conflicts.py --apps <list> [--override <module>:<overridden>]... \\
+      [--known-package <package>]... --output <report>
+
+The list contains the JSON metadata written by app_metadata.py badging for
+each app.  The known packages are the packages of the apps installed by Make.
+"""
+
+from __future__ import print_function
+
+import argparse
+import json
+import sys
+
+
+def check(args):
+    with open(args.apps) as f:
+        apps = []
+        for path in f.read().split():
+            with open(path) as metadata:
+                apps.append(json.load(metadata))
+    apps.sort(key=lambda app: app['devicePath'])
+
+    installed = dict((app['module'], app) for app in apps)
+    conflicts = []
+
+    for override in args.override:
+        module, overridden = override.split(':', 1)
+        if module in installed and overridden in installed:
+            conflicts.append('%s (%s) overrides %s (%s), but both are installed' %
+                             (module, installed[module]['devicePath'],
+                              overridden, installed[overridden]['devicePath']))
+
+    packages = {}
+    for app in apps:
+        packages.setdefault(app['package'], []).append(app)
+    for package in sorted(packages):
+        providers = packages[package]
+        if len(providers) > 1:
+            conflicts.append('package %s is provided by %s' %
+                             (package, ', '.join('%s (%s)' % (app['module'], app['devicePath'])
+                                                 for app in providers)))
+        if package in args.known_package:
+            conflicts.append('package %s of %s (%s) is also installed by Make' %
+                             (package, providers[0]['module'], providers[0]['devicePath']))
+
+    report = ['App conflict check of %d apps' % len(apps), '']
+    report += conflicts
+    report.append('%d conflicts.' % len(conflicts))
+    with open(args.output, 'w') as f:
+        f.write('\n'.join(report) + '\n')
+
+    if conflicts:
+        for conflict in conflicts:
+            print(conflict, file=sys.stderr)
+        print('Remove one of the conflicting apps from the product, see %s' % args.output,
+              file=sys.stderr)
+        return 1
+    return 0
+
+
+def main(argv):
+    parser = argparse.ArgumentParser()
+    parser.add_argument('--apps', required=True,
+                        help='file listing the JSON metadata of the apps')
+    parser.add_argument('--override', action='append', default=[],
+                        help='module and the module that it overrides, separated by a colon')
+    parser.add_argument('--known-package', action='append', default=[],
+                        help='package of an app installed by Make')
+    parser.add_argument('--output', required=True)
+    args = parser.parse_args(argv[1:])
+    return check(args)
+
+
+if __name__ == '__main__':
+    sys.exit(main(sys.argv))
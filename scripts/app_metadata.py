@@ -0,0 +1,153 @@
+#!/usr/bin/env python
+#
+# Copyright (C) 2017 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+"""Exports the metadata of the apps in the build as JSON.
+
+The badging command is run at build time for each app that exports its
+metadata, and converts the output of `aapt dump badging` for the apk into a
+JSON object with the package name, version, sdk versions, permissions, shared
+libraries and components of the app:
+
+  app_metadata.py badging --module Foo --device-path /system/app/Foo/Foo.apk \\
+      <badging> <output>
+
+The merge command combines the JSON objects of all apps into a single JSON
+list, sorted by the device path of the apps:
+
+  app_metadata.py merge <output> <metadata>...
+"""
+
+from __future__ import print_function
+
+import argparse
+import json
+import re
+import sys
+
+# Lines of aapt dump badging are a key, a colon and either a single quoted value
+# or a list of name='value' attributes.
+LINE = re.compile(r"^([\w-]+):\s*(.*)$")
+ATTRIBUTE = re.compile(r"([\w-]+)='((?:[^'\\]|\\.)*)'")
+VALUE = re.compile(r"^'((?:[^'\\]|\\.)*)'$")
+
+
+def parse_badging(lines):
+    """Yields the key and the value or attributes of each line of aapt dump badging."""
+    for line in lines:
+        match = LINE.match(line.rstrip('\n'))
+        if not match:
+            continue
+        key, rest = match.groups()
+        value = VALUE.match(rest)
+        if value:
+            yield key, value.group(1), {}
+        else:
+            yield key, None, dict(ATTRIBUTE.findall(rest))
+
+
+def to_int(value):
+    try:
+        return int(value)
+    except (TypeError, ValueError):
+        return value
+
+
+def badging(args):
+    metadata = {
+        'module': args.module,
+        'devicePath': args.device_path,
+        'permissions': [],
+        'usesLibraries': [],
+        'optionalUsesLibraries': [],
+        'launchableActivities': [],
+        'components': [],
+    }
+
+    with open(args.badging) as f:
+        for key, value, attributes in parse_badging(f):
+            if key == 'package':
+                metadata['package'] = attributes.get('name')
+                metadata['versionCode'] = to_int(attributes.get('versionCode'))
+                metadata['versionName'] = attributes.get('versionName')
+            elif key == 'sdkVersion':
+                metadata['minSdkVersion'] = to_int(value)
+            elif key == 'targetSdkVersion':
+                metadata['targetSdkVersion'] = to_int(value)
+            elif key == 'uses-permission':
+                metadata['permissions'].append(attributes.get('name', value))
+            elif key in ('uses-library', 'uses-static-library'):
+                metadata['usesLibraries'].append(attributes.get('name', value))
+            elif key == 'uses-library-not-required':
+                metadata['optionalUsesLibraries'].append(attributes.get('name', value))
+            elif key in ('launchable-activity', 'leanback-launchable-activity'):
+                metadata['launchableActivities'].append(attributes.get('name'))
+            elif key == 'provides-component':
+                metadata['components'].append(value)
+
+    if 'package' not in metadata:
+        print('%s: no package in aapt badging output' % args.badging, file=sys.stderr)
+        return 1
+
+    for key in ('permissions', 'usesLibraries', 'optionalUsesLibraries', 'launchableActivities',
+                'components'):
+        metadata[key] = sorted(set(metadata[key]))
+
+    with open(args.output, 'w') as f:
+        json.dump(metadata, f, indent=2, sort_keys=True)
+        f.write('\n')
+    return 0
+
+
+def merge(args):
+    apps = []
+    for path in args.metadata:
+        with open(path) as f:
+            apps.append(json.load(f))
+    apps.sort(key=lambda app: app['devicePath'])
+
+    with open(args.output, 'w') as f:
+        json.dump(apps, f, indent=2, sort_keys=True)
+        f.write('\n')
+    return 0
+
+
+def main(argv):
+    parser = argparse.ArgumentParser(fromfile_prefix_chars='@')
+    subparsers = parser.add_subparsers(dest='command')
+
+    badging_parser = subparsers.add_parser('badging')
+    badging_parser.add_argument('--module', required=True,
+                                help='name of the module that built the apk')
+    badging_parser.add_argument('--device-path', required=True,
+                                help='path of the apk on the device')
+    badging_parser.add_argument('badging', help='output of aapt dump badging')
+    badging_parser.add_argument('output')
+    badging_parser.set_defaults(func=badging)
+
+    merge_parser = subparsers.add_parser('merge')
+    merge_parser.add_argument('output')
+    merge_parser.add_argument('metadata', nargs='*')
+    merge_parser.set_defaults(func=merge)
+
+    args = parser.parse_args(argv[1:])
+    if args.command is None:
+        parser.print_usage(sys.stderr)
+        return 2
+    return args.func(args)
+
+
+if __name__ == '__main__':
+    sys.exit(main(sys.argv))
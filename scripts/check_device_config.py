@@ -0,0 +1,228 @@
+#!/usr/bin/env python
+#
+# Copyright (C) 2017 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+"""Validates the configuration files of input devices, sensors and media.
+
+  check_device_config.py --type <type> <file> <output>
+
+The types are:
+
+  keylayout  a key layout (.kl) file, with key, axis, led and sensor lines
+  keychars   a key character map (.kcm) file, starting with a type line
+  sensors    a sensor HAL configuration: an XML file, or hals.conf listing
+             the sub-HAL libraries of the multihal
+  media      a media_profiles or media_codecs XML file
+
+The input is copied to the output once it has been validated.
+"""
+
+from __future__ import print_function
+
+import argparse
+import os
+import re
+import shutil
+import sys
+import xml.etree.ElementTree as ET
+
+# Names of key codes, axes, LEDs and sensor types, without their prefix, for example A, 1 or
+# DPAD_UP
+NAME = re.compile(r'^[A-Z0-9_]+$')
+NUMBER = re.compile(r'^(0x[0-9a-fA-F]+|[0-9]+)$')
+INTEGER = re.compile(r'^-?[0-9]+$')
+
+KEY_FLAGS = ('WAKE', 'WAKE_DROPPED', 'SHIFT', 'CAPS_LOCK', 'ALT', 'ALT_GR', 'FUNCTION',
+             'VIRTUAL', 'MENU', 'LAUNCHER')
+
+KEYBOARD_TYPES = ('NUMERIC', 'PREDICTIVE', 'ALPHA', 'FULL', 'SPECIAL_FUNCTION', 'OVERLAY')
+
+KEY_PROPERTIES = re.compile(r'^(label|number|base|shift|lshift|rshift|alt|lalt|ralt|ctrl|lctrl|'
+                            r'rctrl|meta|lmeta|rmeta|sym|fn|capslock|numlock|scrolllock)$')
+
+MEDIA_ROOTS = ('MediaSettings', 'MediaCodecs', 'Included')
+
+
+def lines(path, trailing_comments=True):
+    """Yields the number and the contents of each line that is not blank or a comment."""
+    with open(path) as f:
+        for number, line in enumerate(f, 1):
+            line = line.strip()
+            if trailing_comments:
+                line = line.split('#', 1)[0].strip()
+            if line and not line.startswith('#'):
+                yield number, line
+
+
+def check_keylayout(path):
+    errors = []
+    for number, line in lines(path):
+        where = '%s:%d' % (path, number)
+        tokens = line.split()
+        keyword, args = tokens[0], tokens[1:]
+        if keyword == 'key':
+            if args[:1] == ['usage']:
+                args = args[1:]
+            if len(args) < 2 or not NUMBER.match(args[0]) or not NAME.match(args[1]):
+                errors.append('%s: expected "key [usage] <code> <KEYCODE> [<flag>...]"' % where)
+                continue
+            for flag in args[2:]:
+                if flag not in KEY_FLAGS:
+                    errors.append('%s: unknown key flag %s' % (where, flag))
+        elif keyword == 'axis':
+            if args[:1] == ['usage']:
+                args = args[1:]
+            if len(args) >= 2 and args[-2] == 'flat':
+                if not INTEGER.match(args[-1]):
+                    errors.append('%s: flat value must be an integer' % where)
+                args = args[:-2]
+            if not args or not NUMBER.match(args[0]):
+                errors.append('%s: expected "axis <code> ..."' % where)
+            elif len(args) == 2 and NAME.match(args[1]):
+                pass
+            elif len(args) == 3 and args[1] == 'invert' and NAME.match(args[2]):
+                pass
+            elif (len(args) == 5 and args[1] == 'split' and NUMBER.match(args[2]) and
+                  NAME.match(args[3]) and NAME.match(args[4])):
+                pass
+            else:
+                errors.append('%s: expected "axis <code> [invert|split <value> <low>] <AXIS>"' %
+                              where)
+        elif keyword == 'led':
+            if args[:1] == ['usage']:
+                args = args[1:]
+            if len(args) != 2 or not NUMBER.match(args[0]) or not NAME.match(args[1]):
+                errors.append('%s: expected "led [usage] <code> <LED>"' % where)
+        elif keyword == 'sensor':
+            if (len(args) != 3 or not NUMBER.match(args[0]) or not NAME.match(args[1]) or
+                    not INTEGER.match(args[2])):
+                errors.append('%s: expected "sensor <code> <SENSOR_TYPE> <index>"' % where)
+        else:
+            errors.append('%s: unknown keyword %s' % (where, keyword))
+    return errors
+
+
+def check_keychars(path):
+    errors = []
+    keyboard_type = None
+    in_key = None
+    # Behaviors may be quoted '#' characters, so only whole lines are comments
+    for number, line in lines(path, trailing_comments=False):
+        where = '%s:%d' % (path, number)
+        tokens = line.split()
+        if in_key is not None:
+            if line == '}':
+                in_key = None
+                continue
+            if ':' not in line:
+                errors.append('%s: expected "<property>: <behavior>" in key %s' % (where, in_key))
+                continue
+            for prop in line.split(':', 1)[0].split(','):
+                for modifier in prop.strip().split('+'):
+                    if not KEY_PROPERTIES.match(modifier.strip()):
+                        errors.append('%s: unknown property %s in key %s' %
+                                      (where, modifier.strip(), in_key))
+            continue
+
+        if tokens[0] == 'type':
+            if len(tokens) != 2 or tokens[1] not in KEYBOARD_TYPES:
+                errors.append('%s: keyboard type must be one of %s' %
+                              (where, ', '.join(KEYBOARD_TYPES)))
+            elif keyboard_type is not None:
+                errors.append('%s: duplicate type' % where)
+            keyboard_type = tokens[1:]
+        elif tokens[0] == 'key':
+            if len(tokens) != 3 or not NAME.match(tokens[1]) or tokens[2] != '{':
+                errors.append('%s: expected "key <KEYCODE> {"' % where)
+            else:
+                in_key = tokens[1]
+        elif tokens[0] == 'map':
+            if (len(tokens) not in (4, 5) or tokens[1] != 'key' or
+                    not NUMBER.match(tokens[-2]) or not NAME.match(tokens[-1]) or
+                    (len(tokens) == 5 and tokens[2] != 'usage')):
+                errors.append('%s: expected "map key [usage] <code> <KEYCODE>"' % where)
+        else:
+            errors.append('%s: unknown keyword %s' % (where, tokens[0]))
+
+    if in_key is not None:
+        errors.append('%s: key %s is not closed' % (path, in_key))
+    if keyboard_type is None:
+        errors.append('%s: missing keyboard type' % path)
+    return errors
+
+
+def check_xml(path, roots=None):
+    try:
+        root = ET.parse(path).getroot()
+    except ET.ParseError as e:
+        return None, ['%s: %s' % (path, e)]
+    if roots and root.tag not in roots:
+        return root, ['%s: root element must be one of %s, not <%s>' %
+                      (path, ', '.join('<%s>' % r for r in roots), root.tag)]
+    return root, []
+
+
+def check_sensors(path):
+    if path.endswith('.xml'):
+        return check_xml(path)[1]
+    if os.path.basename(path) == 'hals.conf':
+        return ['%s:%d: %s is not a shared library' % (path, number, line)
+                for number, line in lines(path) if not line.endswith('.so') or ' ' in line]
+    return ['%s: unknown sensor configuration, must be an XML file or hals.conf' % path]
+
+
+def check_media(path):
+    root, errors = check_xml(path, MEDIA_ROOTS)
+    if errors:
+        return errors
+    if root.tag == 'MediaSettings':
+        for profiles in root.iter('CamcorderProfiles'):
+            camera = profiles.get('cameraId')
+            if camera is not None and not INTEGER.match(camera):
+                errors.append('%s: cameraId must be an integer, not "%s"' % (path, camera))
+    else:
+        for codec in root.iter('MediaCodec'):
+            if not codec.get('name'):
+                errors.append('%s: <MediaCodec> is missing the name attribute' % path)
+    return errors
+
+
+CHECKS = {
+    'keylayout': check_keylayout,
+    'keychars': check_keychars,
+    'sensors': check_sensors,
+    'media': check_media,
+}
+
+
+def main(argv):
+    parser = argparse.ArgumentParser()
+    parser.add_argument('--type', required=True, choices=sorted(CHECKS))
+    parser.add_argument('input')
+    parser.add_argument('output')
+    args = parser.parse_args(argv[1:])
+
+    errors = CHECKS[args.type](args.input)
+    for error in errors:
+        print(error, file=sys.stderr)
+    if errors:
+        return 1
+
+    shutil.copyfile(args.input, args.output)
+    return 0
+
+
+if __name__ == '__main__':
+    sys.exit(main(sys.argv))
@@ -0,0 +1,107 @@
+#!/usr/bin/env python
+
+from __future__ import print_function
+
+import os
+import shutil
+import tempfile
+import unittest
+
+from check_device_config import main
+
+KEYLAYOUT = '''# Generic key layout
+key 116   POWER             WAKE
+key usage 0x0c0067 VOLUME_UP
+axis 0x00 X flat 16
+axis 0x01 invert Y
+axis 0x02 split 0x7f LTRIGGER RTRIGGER
+led 0x00 NUM_LOCK
+sensor 0x00 ACCELEROMETER 0
+'''
+
+KEYCHARS = '''type ALPHA
+
+key A {
+    label:                              'A'
+    base:                               'a'
+    shift, capslock:                    'A'
+}
+
+# A quoted '#' is a behavior, not a comment
+key POUND {
+    label:                              '#'
+    base:                               '#'
+}
+
+map key 86 PLUS
+'''
+
+MEDIA_PROFILES = '''<MediaSettings>
+    <CamcorderProfiles cameraId="0" />
+</MediaSettings>
+'''
+
+MEDIA_CODECS = '''<MediaCodecs>
+    <Decoders>
+        <MediaCodec name="OMX.vendor.avc.decoder" type="video/avc" />
+    </Decoders>
+</MediaCodecs>
+'''
+
+
+class CheckDeviceConfigTest(unittest.TestCase):
+
+    def setUp(self):
+        self.tmp = tempfile.mkdtemp()
+
+    def tearDown(self):
+        shutil.rmtree(self.tmp)
+
+    def check(self, config_type, name, content):
+        src = os.path.join(self.tmp, name)
+        with open(src, 'w') as f:
+            f.write(content)
+        out = os.path.join(self.tmp, 'out', name)
+        if not os.path.isdir(os.path.dirname(out)):
+            os.makedirs(os.path.dirname(out))
+        return main(['check_device_config.py', '--type', config_type, src, out]), out
+
+    def test_valid(self):
+        for config_type, name, content in (
+                ('keylayout', 'gpio-keys.kl', KEYLAYOUT),
+                ('keychars', 'Generic.kcm', KEYCHARS),
+                ('sensors', 'hals.conf', 'sensors.vendor.so\n'),
+                ('sensors', 'sensors.xml', '<sensors />\n'),
+                ('media', 'media_profiles_V1_0.xml', MEDIA_PROFILES),
+                ('media', 'media_codecs.xml', MEDIA_CODECS),
+        ):
+            ret, out = self.check(config_type, name, content)
+            self.assertEqual(0, ret, name)
+            with open(out) as f:
+                self.assertEqual(content, f.read(), name)
+
+    def test_invalid(self):
+        for config_type, name, content in (
+                ('keylayout', 'gpio-keys.kl', 'key 116 power\n'),
+                ('keylayout', 'gpio-keys.kl', 'key 116 POWER WAKEUP\n'),
+                ('keylayout', 'gpio-keys.kl', 'axis 0x00 X flat high\n'),
+                ('keylayout', 'gpio-keys.kl', 'button 116 POWER\n'),
+                ('keychars', 'Generic.kcm', 'key A {\n    base: \'a\'\n}\n'),
+                ('keychars', 'Generic.kcm', 'type ALPHA\nkey A {\n    base: \'a\'\n'),
+                ('keychars', 'Generic.kcm', 'type ALPHA\nkey A {\n    upper: \'A\'\n}\n'),
+                ('keychars', 'Generic.kcm', 'type ALPHA\ntype FULL\n'),
+                ('sensors', 'hals.conf', 'sensors.vendor\n'),
+                ('sensors', 'sensors.conf', 'sensors.vendor.so\n'),
+                ('sensors', 'sensors.xml', '<sensors>\n'),
+                ('media', 'media_profiles_V1_0.xml', '<Profiles />\n'),
+                ('media', 'media_profiles_V1_0.xml',
+                 '<MediaSettings><CamcorderProfiles cameraId="back" /></MediaSettings>\n'),
+                ('media', 'media_codecs.xml', '<MediaCodecs><MediaCodec /></MediaCodecs>\n'),
+        ):
+            ret, out = self.check(config_type, name, content)
+            self.assertEqual(1, ret, content)
+            self.assertFalse(os.path.exists(out), content)
+
+
+if __name__ == '__main__':
+    unittest.main()
@@ -0,0 +1,86 @@
+#!/usr/bin/env python
+#
+# Copyright (C) 2017 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+"""Validates a hardware or software feature permissions XML file.
+
+The file must have a <permissions> root element that only contains <feature>
+and <unavailable-feature> elements naming android.hardware.* or
+android.software.* features.  If an output file is given the input is copied
+to it once it has been validated.
+"""
+
+from __future__ import print_function
+
+import re
+import shutil
+import sys
+import xml.etree.ElementTree as ET
+
+FEATURE_NAME = re.compile(r'^android\.(hardware|software)\.[a-z0-9_]+(\.[a-z0-9_]+)*$')
+ALLOWED_ELEMENTS = ('feature', 'unavailable-feature')
+
+
+def check(path):
+    errors = []
+    try:
+        root = ET.parse(path).getroot()
+    except ET.ParseError as e:
+        return ['%s: %s' % (path, e)]
+
+    if root.tag != 'permissions':
+        return ['%s: root element must be <permissions>, not <%s>' % (path, root.tag)]
+
+    for element in root:
+        if element.tag not in ALLOWED_ELEMENTS:
+            errors.append('%s: unexpected element <%s>, only %s are allowed' %
+                          (path, element.tag, ', '.join('<%s>' % e for e in ALLOWED_ELEMENTS)))
+            continue
+
+        name = element.get('name')
+        if name is None:
+            errors.append('%s: <%s> is missing the name attribute' % (path, element.tag))
+        elif not FEATURE_NAME.match(name):
+            errors.append('%s: invalid feature name "%s"' % (path, name))
+
+        version = element.get('version')
+        if version is not None:
+            if element.tag != 'feature':
+                errors.append('%s: <%s name="%s"> cannot have a version' % (path, element.tag, name))
+            elif not re.match(r'^-?[0-9]+$', version):
+                errors.append('%s: version of feature "%s" must be an integer, not "%s"' %
+                              (path, name, version))
+
+    return errors
+
+
+def main(argv):
+    if len(argv) not in (2, 3):
+        print('usage: %s <feature xml> [<output>]' % argv[0], file=sys.stderr)
+        return 2
+
+    errors = check(argv[1])
+    for error in errors:
+        print(error, file=sys.stderr)
+    if errors:
+        return 1
+
+    if len(argv) == 3:
+        shutil.copyfile(argv[1], argv[2])
+    return 0
+
+
+if __name__ == '__main__':
+    sys.exit(main(sys.argv))
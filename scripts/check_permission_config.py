@@ -0,0 +1,243 @@
+#!/usr/bin/env python
+#
+# Copyright (C) 2017 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+"""Validates default-permissions and sysconfig XML files.
+
+The validate command checks a single file against the format that the package
+manager accepts, and copies it to the output once it has been validated:
+
+  check_permission_config.py validate --type default-permissions <xml> <output>
+
+The packages command checks that every package named by a set of validated
+files is installed by the build, and touches a stamp file on success:
+
+  check_permission_config.py packages --manifests <list> \\
+      [--known-package <package>]... --stamp <stamp> <xml>...
+
+The package manager silently ignores entries for packages that are not
+installed, so a typo in a package name would otherwise go unnoticed.
+"""
+
+from __future__ import print_function
+
+import argparse
+import re
+import shutil
+import sys
+import xml.etree.ElementTree as ET
+
+NAME = re.compile(r'^[A-Za-z][A-Za-z0-9_]*(\.[A-Za-z][A-Za-z0-9_]*)*$')
+
+# The elements allowed in a sysconfig file, mapped to their required attributes.
+SYSCONFIG_ELEMENTS = {
+    'allow-association': ('target', 'allowed'),
+    'allow-implicit-broadcast': ('action',),
+    'allow-in-data-usage-save': ('package',),
+    'allow-in-power-save': ('package',),
+    'allow-in-power-save-except-idle': ('package',),
+    'allow-unthrottled-location': ('package',),
+    'app-link': ('package',),
+    'assign-permission': ('name', 'uid'),
+    'backup-transport-whitelisted-service': ('service',),
+    'bugreport-whitelisted': ('package',),
+    'default-enabled-vr-app': ('package', 'class'),
+    'disabled-until-used-preinstalled-carrier-app': ('package',),
+    'disabled-until-used-preinstalled-carrier-associated-app': ('package', 'carrierAppPackage'),
+    'feature': ('name',),
+    'group': ('gid',),
+    'hidden-api-whitelisted-app': ('package',),
+    'library': ('name', 'file'),
+    'oem-permissions': ('package',),
+    'permission': ('name',),
+    'privapp-permissions': ('package',),
+    'system-user-blacklisted-app': ('package',),
+    'system-user-whitelisted-app': ('package',),
+    'unavailable-feature': ('name',),
+}
+
+# Attributes of sysconfig elements that name packages.
+PACKAGE_ATTRIBUTES = ('package', 'target', 'allowed', 'carrierAppPackage')
+
+# Elements whose children list permissions granted or denied to the package.
+PERMISSION_LIST_ELEMENTS = ('privapp-permissions', 'oem-permissions')
+
+
+def check_name(path, what, name, errors):
+    if not NAME.match(name):
+        errors.append('%s: invalid %s "%s"' % (path, what, name))
+
+
+def check_default_permissions(path, root):
+    errors = []
+    if root.tag != 'exceptions':
+        return ['%s: root element must be <exceptions>, not <%s>' % (path, root.tag)]
+
+    for exception in root:
+        if exception.tag != 'exception':
+            errors.append('%s: unexpected element <%s>, only <exception> is allowed' %
+                          (path, exception.tag))
+            continue
+
+        package = exception.get('package')
+        if package is None:
+            errors.append('%s: <exception> is missing the package attribute' % path)
+            continue
+        check_name(path, 'package name', package, errors)
+
+        for permission in exception:
+            if permission.tag != 'permission':
+                errors.append('%s: unexpected element <%s> in exception for "%s", only <permission> is allowed' %
+                              (path, permission.tag, package))
+                continue
+            name = permission.get('name')
+            if name is None:
+                errors.append('%s: <permission> in exception for "%s" is missing the name attribute' %
+                              (path, package))
+                continue
+            check_name(path, 'permission name', name, errors)
+            fixed = permission.get('fixed')
+            if fixed is not None and fixed not in ('true', 'false'):
+                errors.append('%s: fixed attribute of permission "%s" must be true or false, not "%s"' %
+                              (path, name, fixed))
+
+    return errors
+
+
+def check_sysconfig(path, root):
+    errors = []
+    if root.tag not in ('config', 'permissions'):
+        return ['%s: root element must be <config> or <permissions>, not <%s>' % (path, root.tag)]
+
+    for element in root:
+        if element.tag not in SYSCONFIG_ELEMENTS:
+            errors.append('%s: unknown element <%s>' % (path, element.tag))
+            continue
+
+        for attribute in SYSCONFIG_ELEMENTS[element.tag]:
+            if element.get(attribute) is None:
+                errors.append('%s: <%s> is missing the %s attribute' % (path, element.tag, attribute))
+
+        for attribute in PACKAGE_ATTRIBUTES:
+            if element.get(attribute) is not None:
+                check_name(path, 'package name', element.get(attribute), errors)
+
+        if element.tag in PERMISSION_LIST_ELEMENTS:
+            for permission in element:
+                if permission.tag not in ('permission', 'deny-permission'):
+                    errors.append('%s: unexpected element <%s> in <%s>' %
+                                  (path, permission.tag, element.tag))
+                elif permission.get('name') is None:
+                    errors.append('%s: <%s> in <%s> is missing the name attribute' %
+                                  (path, permission.tag, element.tag))
+        elif element.tag != 'permission' and len(element) > 0:
+            errors.append('%s: <%s> cannot have child elements' % (path, element.tag))
+
+    return errors
+
+
+CHECKS = {
+    'default-permissions': check_default_permissions,
+    'sysconfig': check_sysconfig,
+}
+
+
+def referenced_packages(root):
+    """Returns the packages named by a default-permissions or sysconfig file."""
+    if root.tag == 'exceptions':
+        return [e.get('package') for e in root if e.get('package') is not None]
+
+    packages = []
+    for element in root:
+        for attribute in PACKAGE_ATTRIBUTES:
+            if element.get(attribute) is not None:
+                packages.append(element.get(attribute))
+    return packages
+
+
+def manifest_package(path):
+    return ET.parse(path).getroot().get('package')
+
+
+def validate(args):
+    try:
+        root = ET.parse(args.input).getroot()
+    except ET.ParseError as e:
+        print('%s: %s' % (args.input, e), file=sys.stderr)
+        return 1
+
+    errors = CHECKS[args.type](args.input, root)
+    for error in errors:
+        print(error, file=sys.stderr)
+    if errors:
+        return 1
+
+    shutil.copyfile(args.input, args.output)
+    return 0
+
+
+def packages(args):
+    installed = set(args.known_package or [])
+    with open(args.manifests) as f:
+        for manifest in f.read().split():
+            package = manifest_package(manifest)
+            if package is not None:
+                installed.add(package)
+
+    errors = []
+    for path in args.inputs:
+        for package in referenced_packages(ET.parse(path).getroot()):
+            if package not in installed:
+                errors.append('%s: package "%s" is not installed by this build' % (path, package))
+
+    for error in errors:
+        print(error, file=sys.stderr)
+    if errors:
+        print('If the package is installed by Make or as a prebuilt, add it to '
+              'PRODUCT_INSTALLED_APP_PACKAGES', file=sys.stderr)
+        return 1
+
+    open(args.stamp, 'w').close()
+    return 0
+
+
+def main(argv):
+    parser = argparse.ArgumentParser()
+    subparsers = parser.add_subparsers(dest='command')
+
+    validate_parser = subparsers.add_parser('validate')
+    validate_parser.add_argument('--type', required=True, choices=sorted(CHECKS))
+    validate_parser.add_argument('input')
+    validate_parser.add_argument('output')
+    validate_parser.set_defaults(func=validate)
+
+    packages_parser = subparsers.add_parser('packages')
+    packages_parser.add_argument('--manifests', required=True,
+                                 help='file listing the manifests of the installed apps')
+    packages_parser.add_argument('--known-package', action='append',
+                                 help='package installed by the build that has no manifest in the list')
+    packages_parser.add_argument('--stamp', required=True)
+    packages_parser.add_argument('inputs', nargs='*')
+    packages_parser.set_defaults(func=packages)
+
+    args = parser.parse_args(argv[1:])
+    if args.command is None:
+        parser.print_usage(sys.stderr)
+        return 2
+    return args.func(args)
+
+
+if __name__ == '__main__':
+    sys.exit(main(sys.argv))
@@ -0,0 +1,96 @@
+#!/usr/bin/env python
+
+from __future__ import print_function
+
+import os
+import shutil
+import tempfile
+import unittest
+
+from check_permission_config import main
+
+DEFAULT_PERMISSIONS = '''<exceptions>
+    <exception package="org.lineageos.updater">
+        <permission name="android.permission.READ_PHONE_STATE" fixed="true" />
+    </exception>
+</exceptions>
+'''
+
+SYSCONFIG = '''<config>
+    <allow-in-power-save package="org.lineageos.updater" />
+    <allow-association target="org.lineageos.updater" allowed="com.android.shell" />
+</config>
+'''
+
+
+class CheckPermissionConfigTest(unittest.TestCase):
+
+    def setUp(self):
+        self.tmp = tempfile.mkdtemp()
+
+    def tearDown(self):
+        shutil.rmtree(self.tmp)
+
+    def write(self, name, content):
+        path = os.path.join(self.tmp, name)
+        with open(path, 'w') as f:
+            f.write(content)
+        return path
+
+    def validate(self, config_type, content):
+        src = self.write('input.xml', content)
+        out = os.path.join(self.tmp, 'output.xml')
+        return main(['check_permission_config.py', 'validate', '--type', config_type, src, out]), out
+
+    def packages(self, configs, packages, known_packages=()):
+        manifests = []
+        for i, package in enumerate(packages):
+            manifests.append(self.write('AndroidManifest%d.xml' % i,
+                                        '<manifest package="%s" />\n' % package))
+        manifest_list = self.write('manifests.rsp', ' '.join(manifests))
+        inputs = [self.write('config%d.xml' % i, c) for i, c in enumerate(configs)]
+        stamp = os.path.join(self.tmp, 'stamp')
+        args = ['check_permission_config.py', 'packages', '--manifests', manifest_list,
+                '--stamp', stamp]
+        for package in known_packages:
+            args += ['--known-package', package]
+        return main(args + inputs), os.path.exists(stamp)
+
+    def test_validate(self):
+        for config_type, content in (('default-permissions', DEFAULT_PERMISSIONS),
+                                     ('sysconfig', SYSCONFIG)):
+            ret, out = self.validate(config_type, content)
+            self.assertEqual(0, ret)
+            with open(out) as f:
+                self.assertEqual(content, f.read())
+
+    def test_validate_errors(self):
+        for config_type, content in (
+                ('default-permissions', '<config />'),
+                ('default-permissions', '<exceptions><exception /></exceptions>'),
+                ('default-permissions',
+                 '<exceptions><exception package="a.b"><permission name="p" fixed="yes" />'
+                 '</exception></exceptions>'),
+                ('sysconfig', '<config><unknown-element /></config>'),
+                ('sysconfig', '<config><allow-in-power-save /></config>'),
+                ('sysconfig', '<config><app-link package="not a package" /></config>'),
+                ('sysconfig', '<config'),
+        ):
+            ret, out = self.validate(config_type, content)
+            self.assertEqual(1, ret, content)
+            self.assertFalse(os.path.exists(out), content)
+
+    def test_packages(self):
+        ret, stamp = self.packages([DEFAULT_PERMISSIONS, SYSCONFIG],
+                                   ['org.lineageos.updater'], ['com.android.shell'])
+        self.assertEqual(0, ret)
+        self.assertTrue(stamp)
+
+    def test_packages_not_installed(self):
+        ret, stamp = self.packages([SYSCONFIG], ['org.lineageos.updater'])
+        self.assertEqual(1, ret)
+        self.assertFalse(stamp)
+
+
+if __name__ == '__main__':
+    unittest.main()
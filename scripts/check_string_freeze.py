@@ -0,0 +1,137 @@
+#!/usr/bin/env python
+#
+# Copyright (C) 2017 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+"""Checks the default-locale strings of an app against its translation freeze snapshot.
+
+  check_string_freeze.py snapshot --output <snapshot> <res dir>...
+  check_string_freeze.py check [--warn-only] --snapshot <snapshot> --output <stamp> <res dir>...
+
+The translatable <string>, <plurals> and <string-array> resources in the values directories of
+the resource directories are compared with the snapshot, which is a resources file written by
+the snapshot command.  Strings that were added, removed or changed since the snapshot was taken
+are reported, and fail the check unless --warn-only is given.
+"""
+
+from __future__ import print_function
+
+import argparse
+import copy
+import glob
+import os
+import sys
+import xml.etree.ElementTree as ET
+
+STRING_TAGS = ('string', 'plurals', 'string-array')
+
+ET.register_namespace('xliff', 'urn:oasis:names:tc:xliff:document:1.2')
+
+
+def translatable(element):
+    return element.get('translatable', 'true') != 'false'
+
+
+def read_strings(paths):
+    """Returns the translatable string resources of the files, by type and name."""
+    strings = {}
+    for path in paths:
+        root = ET.parse(path).getroot()
+        if root.tag != 'resources':
+            continue
+        for element in root:
+            if element.tag in STRING_TAGS and element.get('name') and translatable(element):
+                strings['%s/%s' % (element.tag, element.get('name'))] = element
+    return strings
+
+
+def values_files(res_dirs):
+    paths = []
+    for res_dir in res_dirs:
+        paths.extend(sorted(glob.glob(os.path.join(res_dir, 'values', '*.xml'))))
+    return paths
+
+
+def contents(element):
+    """Returns the text and markup of a resource, for comparing it with the snapshot."""
+    element = copy.deepcopy(element)
+    element.tail = None
+    for name in ('translatable', 'msgid'):
+        element.attrib.pop(name, None)
+    return ET.tostring(element).strip()
+
+
+def snapshot(args):
+    strings = read_strings(values_files(args.res_dirs))
+    root = ET.Element('resources')
+    root.text = '\n    '
+    for key in sorted(strings):
+        element = copy.deepcopy(strings[key])
+        element.tail = '\n    '
+        root.append(element)
+    if len(root):
+        root[-1].tail = '\n'
+    ET.ElementTree(root).write(args.output, encoding='utf-8', xml_declaration=True)
+    return 0
+
+
+def check(args):
+    frozen = read_strings([args.snapshot])
+    strings = read_strings(values_files(args.res_dirs))
+
+    messages = []
+    for key in sorted(set(frozen) | set(strings)):
+        if key not in frozen:
+            messages.append('%s was added' % key)
+        elif key not in strings:
+            messages.append('%s was removed' % key)
+        elif contents(frozen[key]) != contents(strings[key]):
+            messages.append('%s was changed' % key)
+
+    level = 'warning' if args.warn_only else 'error'
+    for message in messages:
+        print('%s: %s: %s after the translation freeze' % (args.snapshot, level, message),
+              file=sys.stderr)
+    if messages and not args.warn_only:
+        print('%s: update the snapshot with "%s snapshot" if the change is intended' %
+              (args.snapshot, sys.argv[0]), file=sys.stderr)
+        return 1
+
+    with open(args.output, 'w') as f:
+        f.write('\n'.join(messages))
+    return 0
+
+
+def main(argv):
+    parser = argparse.ArgumentParser()
+    subparsers = parser.add_subparsers(dest='command')
+
+    snapshot_parser = subparsers.add_parser('snapshot')
+    snapshot_parser.add_argument('--output', required=True)
+    snapshot_parser.add_argument('res_dirs', nargs='+')
+    snapshot_parser.set_defaults(func=snapshot)
+
+    check_parser = subparsers.add_parser('check')
+    check_parser.add_argument('--warn-only', action='store_true')
+    check_parser.add_argument('--snapshot', required=True)
+    check_parser.add_argument('--output', required=True)
+    check_parser.add_argument('res_dirs', nargs='+')
+    check_parser.set_defaults(func=check)
+
+    args = parser.parse_args(argv[1:])
+    return args.func(args)
+
+
+if __name__ == '__main__':
+    sys.exit(main(sys.argv))
@@ -0,0 +1,94 @@
+#!/usr/bin/env python
+#
+# Copyright (C) 2017 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+"""Validates time zone and ICU data files and copies them to the output.
+
+The tzdata command checks that the tzdata file built by ZoneCompactor starts
+with the header of the expected IANA rules version, for example tzdata2017c:
+
+  check_tzdata.py tzdata --version 2017c <tzdata> <output>
+
+The icu command checks that an ICU data file has the ICU data header:
+
+  check_tzdata.py icu <icudt58l.dat> <output>
+"""
+
+from __future__ import print_function
+
+import argparse
+import re
+import shutil
+import sys
+
+RULES_VERSION = re.compile(r'^[0-9]{4}[a-z]$')
+
+# The ICU data header starts with its size, followed by the two magic bytes.
+ICU_MAGIC = b'\xda\x27'
+
+
+def tzdata(args):
+    if not RULES_VERSION.match(args.version):
+        print('invalid time zone rules version %r, expected for example 2017c' % args.version,
+              file=sys.stderr)
+        return 1
+
+    with open(args.input, 'rb') as f:
+        header = f.read(12)
+    expected = ('tzdata' + args.version).encode('ascii') + b'\0'
+    if header != expected:
+        print('%s: expected header %r, got %r' % (args.input, expected, header), file=sys.stderr)
+        return 1
+
+    shutil.copyfile(args.input, args.output)
+    return 0
+
+
+def icu(args):
+    with open(args.input, 'rb') as f:
+        header = f.read(4)
+    if header[2:4] != ICU_MAGIC:
+        print('%s: not an ICU data file' % args.input, file=sys.stderr)
+        return 1
+
+    shutil.copyfile(args.input, args.output)
+    return 0
+
+
+def main(argv):
+    parser = argparse.ArgumentParser()
+    subparsers = parser.add_subparsers(dest='command')
+
+    tzdata_parser = subparsers.add_parser('tzdata')
+    tzdata_parser.add_argument('--version', required=True,
+                               help='IANA rules version of the tzdata file')
+    tzdata_parser.add_argument('input')
+    tzdata_parser.add_argument('output')
+    tzdata_parser.set_defaults(func=tzdata)
+
+    icu_parser = subparsers.add_parser('icu')
+    icu_parser.add_argument('input')
+    icu_parser.add_argument('output')
+    icu_parser.set_defaults(func=icu)
+
+    args = parser.parse_args(argv[1:])
+    if args.command is None:
+        parser.print_usage(sys.stderr)
+        return 2
+    return args.func(args)
+
+
+if __name__ == '__main__':
+    sys.exit(main(sys.argv))
@@ -0,0 +1,115 @@
+#!/usr/bin/env python
+#
+# Copyright (C) 2017 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+"""Generates the compat config of the platform from @ChangeId annotations.
+
+The compat-changeid-annotation-processor writes a *_compat_config.xml file
+under META-INF/ in the classes jar for each class that declares changes.  The
+extract command combines the files in a jar into a single compat config:
+
+  compat_config.py extract <jar> <output>
+
+The merge command combines the compat configs of all modules into the
+platform compat config:
+
+  compat_config.py merge <output> <config>...
+
+Both commands fail if a change id or name is declared more than once.
+"""
+
+from __future__ import print_function
+
+import argparse
+import sys
+import xml.etree.ElementTree as ET
+import zipfile
+
+
+class DuplicateChangeError(Exception):
+    pass
+
+
+def add_changes(changes, root, source):
+    """Adds the compat-change elements of root to changes, keyed by id."""
+    for change in root.iter('compat-change'):
+        change_id = int(change.get('id'))
+        name = change.get('name')
+        if change_id in changes:
+            raise DuplicateChangeError('%s: change id %d is already declared by %s' %
+                                       (source, change_id, changes[change_id][1]))
+        for other, other_source in changes.values():
+            if other.get('name') == name:
+                raise DuplicateChangeError('%s: change name %s is already declared by %s' %
+                                           (source, name, other_source))
+        changes[change_id] = (change, source)
+
+
+def write_config(changes, output):
+    lines = ['<?xml version="1.0" encoding="utf-8"?>', '<config>']
+    for change_id in sorted(changes):
+        change = changes[change_id][0]
+        change.tail = None
+        lines.append('  ' + ET.tostring(change).decode('utf-8').strip())
+    lines.append('</config>')
+    with open(output, 'w') as f:
+        f.write('\n'.join(lines) + '\n')
+
+
+def extract(args):
+    changes = {}
+    with zipfile.ZipFile(args.jar) as jar:
+        for name in sorted(jar.namelist()):
+            if name.startswith('META-INF/') and name.endswith('compat_config.xml'):
+                add_changes(changes, ET.fromstring(jar.read(name)),
+                            '%s!%s' % (args.jar, name))
+    write_config(changes, args.output)
+
+
+def merge(args):
+    changes = {}
+    for config in args.configs:
+        add_changes(changes, ET.parse(config).getroot(), config)
+    write_config(changes, args.output)
+
+
+def main(argv):
+    parser = argparse.ArgumentParser(fromfile_prefix_chars='@')
+    subparsers = parser.add_subparsers(dest='command')
+
+    extract_parser = subparsers.add_parser('extract')
+    extract_parser.add_argument('jar', help='classes jar built with the annotation processor')
+    extract_parser.add_argument('output')
+    extract_parser.set_defaults(func=extract)
+
+    merge_parser = subparsers.add_parser('merge')
+    merge_parser.add_argument('output')
+    merge_parser.add_argument('configs', nargs='*')
+    merge_parser.set_defaults(func=merge)
+
+    args = parser.parse_args(argv[1:])
+    if args.command is None:
+        parser.print_usage(sys.stderr)
+        return 2
+    try:
+        args.func(args)
+    except DuplicateChangeError as e:
+        print(e, file=sys.stderr)
+        return 1
+    return 0
+
+
+if __name__ == '__main__':
+    sys.exit(main(sys.argv))
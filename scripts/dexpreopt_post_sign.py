@@ -0,0 +1,186 @@
+#!/usr/bin/env python
+#
+# Copyright (C) 2017 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+"""Keeps the dexpreopt files of apps valid after the target files are re-signed.
+
+The odex file of an app records the CRC32 of each classes*.dex entry of the
+apk it was generated from, and the runtime ignores the odex file if they no
+longer match.  Re-signing an apk normally leaves the dex entries untouched, but
+any step that rewrites them silently throws away the ahead-of-time compiled
+code of the app.
+
+The record command is run at build time and writes the CRC32 of the dex entries
+of an apk:
+
+  dexpreopt_post_sign.py record --device-path /system/app/Foo/Foo.apk <apk> <output>
+
+The verify command checks the apks of an extracted target files directory
+against the recorded CRCs, and fails if the dex entries of any apk changed:
+
+  dexpreopt_post_sign.py verify --target-files <dir> --dex-crcs <file>
+
+The regenerate command runs dex2oat again for every apk whose dex entries
+changed or whose odex file is missing:
+
+  dexpreopt_post_sign.py regenerate --target-files <dir> --dex-crcs <file> \\
+      --dex2oat <dex2oat> --boot-image <boot.art> --instruction-set <isa>...
+"""
+
+from __future__ import print_function
+
+import argparse
+import os
+import re
+import subprocess
+import sys
+import zipfile
+
+DEX_ENTRY = re.compile(r'^classes[0-9]*\.dex$')
+
+# The directories of an extracted target files package that contain each partition.
+PARTITIONS = {
+    'system': 'SYSTEM',
+    'system_ext': 'SYSTEM_EXT',
+    'vendor': 'VENDOR',
+    'product': 'PRODUCT',
+}
+
+
+def dex_crcs(apk):
+    with zipfile.ZipFile(apk) as z:
+        return dict((info.filename, info.CRC) for info in z.infolist()
+                    if DEX_ENTRY.match(info.filename))
+
+
+def read_dex_crcs(path):
+    apps = {}
+    with open(path) as f:
+        for line in f:
+            fields = line.split()
+            if not fields:
+                continue
+            device_path, entry, crc = fields
+            apps.setdefault(device_path, {})[entry] = int(crc, 16)
+    return apps
+
+
+def target_files_path(target_files, device_path):
+    partition, _, rest = device_path.lstrip('/').partition('/')
+    if partition not in PARTITIONS:
+        raise ValueError('%s is not on a known partition' % device_path)
+    return os.path.join(target_files, PARTITIONS[partition], rest)
+
+
+def odex_path(apk, isa):
+    name = os.path.splitext(os.path.basename(apk))[0]
+    return os.path.join(os.path.dirname(apk), 'oat', isa, name + '.odex')
+
+
+def changed_apps(target_files, apps):
+    """Yields the device path and target files path of each apk whose dex entries changed."""
+    for device_path in sorted(apps):
+        apk = target_files_path(target_files, device_path)
+        if not os.path.exists(apk):
+            # The apk was removed from the target files, so its odex files don't matter
+            continue
+        if dex_crcs(apk) != apps[device_path]:
+            yield device_path, apk
+
+
+def record(args):
+    with open(args.output, 'w') as f:
+        for entry, crc in sorted(dex_crcs(args.apk).items()):
+            f.write('%s %s %08x\n' % (args.device_path, entry, crc))
+    return 0
+
+
+def verify(args):
+    changed = list(changed_apps(args.target_files, read_dex_crcs(args.dex_crcs)))
+    for device_path, _ in changed:
+        print('%s: dex entries changed after signing, its odex files are no longer valid' %
+              device_path, file=sys.stderr)
+    return 1 if changed else 0
+
+
+def regenerate(args):
+    apps = read_dex_crcs(args.dex_crcs)
+    regenerate = dict(changed_apps(args.target_files, apps))
+    for device_path in apps:
+        apk = target_files_path(args.target_files, device_path)
+        if os.path.exists(apk) and any(not os.path.exists(odex_path(apk, isa))
+                                       for isa in args.instruction_set):
+            regenerate[device_path] = apk
+
+    for device_path, apk in sorted(regenerate.items()):
+        for isa in args.instruction_set:
+            odex = odex_path(apk, isa)
+            if not os.path.isdir(os.path.dirname(odex)):
+                os.makedirs(os.path.dirname(odex))
+            cmd = [args.dex2oat,
+                   '--runtime-arg', '-Xms64m', '--runtime-arg', '-Xmx512m',
+                   '--boot-image=' + args.boot_image,
+                   '--dex-file=' + apk,
+                   '--dex-location=' + device_path,
+                   '--oat-file=' + odex,
+                   '--android-root=' + os.path.join(args.target_files, 'SYSTEM'),
+                   '--instruction-set=' + isa,
+                   '--compiler-filter=' + args.compiler_filter,
+                   '--no-generate-debug-info']
+            print('regenerating %s' % odex)
+            if subprocess.call(cmd) != 0:
+                print('%s: dex2oat failed' % device_path, file=sys.stderr)
+                return 1
+    return 0
+
+
+def main(argv):
+    parser = argparse.ArgumentParser()
+    subparsers = parser.add_subparsers(dest='command')
+
+    record_parser = subparsers.add_parser('record')
+    record_parser.add_argument('--device-path', required=True,
+                               help='path of the apk on the device')
+    record_parser.add_argument('apk')
+    record_parser.add_argument('output')
+    record_parser.set_defaults(func=record)
+
+    verify_parser = subparsers.add_parser('verify')
+    verify_parser.add_argument('--target-files', required=True,
+                               help='extracted target files directory')
+    verify_parser.add_argument('--dex-crcs', required=True,
+                               help='dex CRCs recorded at build time')
+    verify_parser.set_defaults(func=verify)
+
+    regenerate_parser = subparsers.add_parser('regenerate')
+    regenerate_parser.add_argument('--target-files', required=True,
+                                   help='extracted target files directory')
+    regenerate_parser.add_argument('--dex-crcs', required=True,
+                                   help='dex CRCs recorded at build time')
+    regenerate_parser.add_argument('--dex2oat', required=True)
+    regenerate_parser.add_argument('--boot-image', required=True)
+    regenerate_parser.add_argument('--instruction-set', action='append', required=True)
+    regenerate_parser.add_argument('--compiler-filter', default='speed')
+    regenerate_parser.set_defaults(func=regenerate)
+
+    args = parser.parse_args(argv[1:])
+    if args.command is None:
+        parser.print_usage(sys.stderr)
+        return 2
+    return args.func(args)
+
+
+if __name__ == '__main__':
+    sys.exit(main(sys.argv))
@@ -0,0 +1,71 @@
+#!/usr/bin/env python
+
+from __future__ import print_function
+
+import os
+import shutil
+import tempfile
+import unittest
+import zipfile
+
+from dexpreopt_post_sign import main
+
+
+class DexpreoptPostSignTest(unittest.TestCase):
+
+    def setUp(self):
+        self.tmp = tempfile.mkdtemp()
+        self.target_files = os.path.join(self.tmp, 'target_files')
+        self.dex_crcs = os.path.join(self.tmp, 'dex_crcs.txt')
+
+    def tearDown(self):
+        shutil.rmtree(self.tmp)
+
+    def apk(self, path, entries):
+        if not os.path.isdir(os.path.dirname(path)):
+            os.makedirs(os.path.dirname(path))
+        with zipfile.ZipFile(path, 'w') as z:
+            for name, content in entries:
+                z.writestr(name, content)
+        return path
+
+    def record(self, entries):
+        apk = self.apk(os.path.join(self.tmp, 'Foo.apk'), entries)
+        return main(['dexpreopt_post_sign.py', 'record', '--device-path',
+                     '/system/app/Foo/Foo.apk', apk, self.dex_crcs])
+
+    def verify(self):
+        return main(['dexpreopt_post_sign.py', 'verify', '--target-files', self.target_files,
+                     '--dex-crcs', self.dex_crcs])
+
+    def signed_apk(self, entries):
+        return self.apk(os.path.join(self.target_files, 'SYSTEM', 'app', 'Foo', 'Foo.apk'),
+                        entries)
+
+    def test_record(self):
+        self.assertEqual(0, self.record([('classes2.dex', 'b'), ('classes.dex', 'a'),
+                                         ('resources.arsc', 'r'), ('lib/classes.dex', 'l')]))
+        # Only the dex entries at the top level of the apk are recorded
+        with open(self.dex_crcs) as f:
+            self.assertEqual('/system/app/Foo/Foo.apk classes.dex e8b7be43\n'
+                             '/system/app/Foo/Foo.apk classes2.dex 71beeff9\n', f.read())
+
+    def test_verify_resigned(self):
+        self.record([('classes.dex', 'a'), ('resources.arsc', 'r')])
+        # The signature and other entries may change without invalidating the odex files
+        self.signed_apk([('classes.dex', 'a'), ('resources.arsc', 'r2'),
+                         ('META-INF/CERT.RSA', 'sig')])
+        self.assertEqual(0, self.verify())
+
+    def test_verify_changed_dex(self):
+        self.record([('classes.dex', 'a')])
+        self.signed_apk([('classes.dex', 'changed')])
+        self.assertEqual(1, self.verify())
+
+    def test_verify_removed_apk(self):
+        self.record([('classes.dex', 'a')])
+        self.assertEqual(0, self.verify())
+
+
+if __name__ == '__main__':
+    unittest.main()
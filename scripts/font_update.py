@@ -0,0 +1,76 @@
+#!/usr/bin/env python
+#
+# Copyright (C) 2017 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+"""Writes the metadata of a font update package.
+
+Checks that every file is a TrueType or OpenType font or font collection, and
+writes a JSON object with the name and version of the update and the size and
+SHA-256 digest of each font:
+
+  font_update.py --name NotoColorEmoji --version 3 --output <json> <font>...
+"""
+
+from __future__ import print_function
+
+import argparse
+import hashlib
+import json
+import os
+import sys
+
+# The first four bytes of the TrueType, OpenType and font collection formats.
+FONT_MAGICS = (b'\x00\x01\x00\x00', b'OTTO', b'true', b'ttcf')
+
+
+def main(argv):
+    parser = argparse.ArgumentParser()
+    parser.add_argument('--name', required=True, help='name of the font update')
+    parser.add_argument('--version', required=True, type=int,
+                        help='version of the font update')
+    parser.add_argument('--output', required=True)
+    parser.add_argument('fonts', nargs='+')
+    args = parser.parse_args(argv[1:])
+
+    if args.version <= 0:
+        print('%s: version must be positive, got %d' % (args.name, args.version), file=sys.stderr)
+        return 1
+
+    fonts = []
+    for path in args.fonts:
+        with open(path, 'rb') as f:
+            data = f.read()
+        if data[:4] not in FONT_MAGICS:
+            print('%s: not a TrueType or OpenType font' % path, file=sys.stderr)
+            return 1
+        fonts.append({
+            'file': os.path.basename(path),
+            'size': len(data),
+            'sha256': hashlib.sha256(data).hexdigest(),
+        })
+
+    metadata = {
+        'name': args.name,
+        'version': args.version,
+        'fonts': sorted(fonts, key=lambda font: font['file']),
+    }
+    with open(args.output, 'w') as f:
+        json.dump(metadata, f, indent=2, sort_keys=True)
+        f.write('\n')
+    return 0
+
+
+if __name__ == '__main__':
+    sys.exit(main(sys.argv))
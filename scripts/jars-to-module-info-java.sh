@@ -0,0 +1,25 @@
+#!/bin/bash -e
+
+# Extracts the java packages from a list of jars and writes a module-info.java file that exports
+# all of them from a single module to stdout.
+# Usage:
+#        jars-to-module-info-java.sh java.base a.jar b.jar > module-info.java
+
+if [ -z "$1" ]; then
+  echo "usage: $0 <module name> <jar>..." >&2
+  exit 1
+fi
+
+module="$1"
+shift
+
+echo "module ${module} {"
+for jar in "$@"; do
+  zipinfo -1 "${jar}"
+done \
+  | grep -E '/[^/]*\.class$' \
+  | grep -v '^META-INF/' \
+  | sed -r 's|/[^/]*\.class$||' \
+  | sort -u \
+  | sed -r 's|/|.|g; s|^(.*)$|  exports \1;|'
+echo "}"
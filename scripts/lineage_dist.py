@@ -0,0 +1,160 @@
+#!/usr/bin/env python
+#
+# Copyright (C) 2017 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+"""Copies a Lineage OTA package into the layout of the download servers.
+
+  lineage_dist.py --dist-dir <dir> --device <device> --version <version> --build-type <type>
+      [--retention <n> [--prune]] [--url <url>] --stamp <stamp> <ota package>
+
+The package is copied to <dir>/<device>/<date>/<type>/ together with its sha256sum and a
+<package>.json file that describes it, where <date> is the date in the version, for example
+20171019 for 15.1-20171019-NIGHTLY-device.  <dir>/<device>/<type>.json is then regenerated from
+the .json files of the builds of the newest <n> dates of the device and build type, or of all
+dates if --retention isn't given, in the format that the Updater app reads:
+
+  {"response": [{"datetime": ..., "filename": ..., "id": ..., "romtype": ..., "size": ...,
+                 "url": ..., "version": ...}, ...]}
+
+The builds of the older dates are left in place, unless --prune is given to delete them.
+"""
+
+from __future__ import print_function
+
+import argparse
+import calendar
+import hashlib
+import json
+import os
+import re
+import shutil
+import sys
+import time
+import zipfile
+
+VERSION_RE = re.compile(r'^(?P<version>[0-9]+(\.[0-9]+)*)-(?P<date>[0-9]{8})-(?P<type>[A-Z]+)')
+
+METADATA_ENTRY = 'META-INF/com/android/metadata'
+
+
+def sha256sum(path):
+    h = hashlib.sha256()
+    with open(path, 'rb') as f:
+        for chunk in iter(lambda: f.read(1 << 20), b''):
+            h.update(chunk)
+    return h.hexdigest()
+
+
+def build_timestamp(path, date):
+    """Returns the build time of the package, from its OTA metadata or else from the date."""
+    try:
+        with zipfile.ZipFile(path) as z:
+            for line in z.read(METADATA_ENTRY).decode('utf-8').splitlines():
+                key, _, value = line.partition('=')
+                if key == 'post-timestamp':
+                    return int(value)
+    except (KeyError, ValueError, zipfile.BadZipfile):
+        pass
+    return calendar.timegm(time.strptime(date, '%Y%m%d'))
+
+
+def write_json(path, data):
+    tmp = path + '.tmp'
+    with open(tmp, 'w') as f:
+        json.dump(data, f, indent=2, sort_keys=True)
+        f.write('\n')
+    os.rename(tmp, path)
+
+
+def dist(args):
+    match = VERSION_RE.match(args.version)
+    if not match:
+        print('%s: invalid Lineage version %r' % (sys.argv[0], args.version), file=sys.stderr)
+        return 1
+    date = match.group('date')
+    build_type = args.build_type.lower()
+
+    device_dir = os.path.join(args.dist_dir, args.device)
+    build_dir = os.path.join(device_dir, date, build_type)
+    if not os.path.isdir(build_dir):
+        os.makedirs(build_dir)
+
+    filename = os.path.basename(args.package)
+    package = os.path.join(build_dir, filename)
+    shutil.copyfile(args.package, package)
+
+    digest = sha256sum(package)
+    with open(package + '.sha256sum', 'w') as f:
+        f.write('%s  %s\n' % (digest, filename))
+
+    relpath = '/'.join([date, build_type, filename])
+    write_json(package + '.json', {
+        'datetime': build_timestamp(package, date),
+        'filename': filename,
+        'id': digest,
+        'romtype': build_type,
+        'size': os.path.getsize(package),
+        'url': args.url + '/' + args.device + '/' + relpath if args.url else relpath,
+        'version': match.group('version'),
+    })
+
+    # The dates of the builds of this type, newest first
+    dates = sorted((d for d in os.listdir(device_dir)
+                    if re.match(r'^[0-9]{8}$', d)
+                    and os.path.isdir(os.path.join(device_dir, d, build_type))),
+                   reverse=True)
+    if args.retention > 0:
+        if args.prune:
+            for old in dates[args.retention:]:
+                shutil.rmtree(os.path.join(device_dir, old, build_type))
+                if not os.listdir(os.path.join(device_dir, old)):
+                    os.rmdir(os.path.join(device_dir, old))
+        dates = dates[:args.retention]
+
+    response = []
+    for d in dates:
+        type_dir = os.path.join(device_dir, d, build_type)
+        for name in sorted(os.listdir(type_dir)):
+            if name.endswith('.zip.json'):
+                with open(os.path.join(type_dir, name)) as f:
+                    response.append(json.load(f))
+    response.sort(key=lambda build: build['datetime'], reverse=True)
+    write_json(os.path.join(device_dir, build_type + '.json'), {'response': response})
+
+    with open(args.stamp, 'w') as f:
+        f.write(package + '\n')
+    return 0
+
+
+def main(argv):
+    parser = argparse.ArgumentParser()
+    parser.add_argument('--dist-dir', required=True)
+    parser.add_argument('--device', required=True)
+    parser.add_argument('--version', required=True)
+    parser.add_argument('--build-type', required=True)
+    parser.add_argument('--retention', type=int, default=0)
+    parser.add_argument('--prune', action='store_true')
+    parser.add_argument('--url', default='')
+    parser.add_argument('--stamp', required=True)
+    parser.add_argument('package')
+    args = parser.parse_args(argv[1:])
+    if args.prune and args.retention <= 0:
+        parser.error('--prune requires --retention')
+    args.url = args.url.rstrip('/')
+    return dist(args)
+
+
+if __name__ == '__main__':
+    sys.exit(main(sys.argv))
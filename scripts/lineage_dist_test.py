@@ -0,0 +1,124 @@
+#!/usr/bin/env python
+
+from __future__ import print_function
+
+import hashlib
+import json
+import os
+import shutil
+import tempfile
+import unittest
+import zipfile
+
+from lineage_dist import main
+
+
+class LineageDistTest(unittest.TestCase):
+
+    def setUp(self):
+        self.tmp = tempfile.mkdtemp()
+        self.dist_dir = os.path.join(self.tmp, 'dist')
+        self.stamp = os.path.join(self.tmp, 'lineage-dist.stamp')
+
+    def tearDown(self):
+        shutil.rmtree(self.tmp)
+
+    def package(self, version, timestamp=None):
+        path = os.path.join(self.tmp, 'lineage-%s.zip' % version)
+        with zipfile.ZipFile(path, 'w') as z:
+            if timestamp is not None:
+                z.writestr('META-INF/com/android/metadata', 'post-timestamp=%d\n' % timestamp)
+            else:
+                z.writestr('payload', version)
+        return path
+
+    def dist(self, version, *extra_args, **kwargs):
+        package = self.package(version, kwargs.get('timestamp'))
+        args = ['lineage_dist.py', '--dist-dir', self.dist_dir, '--device', 'device',
+                '--version', version, '--build-type', 'NIGHTLY', '--stamp', self.stamp]
+        return main(args + list(extra_args) + [package])
+
+    def metadata(self):
+        with open(os.path.join(self.dist_dir, 'device', 'nightly.json')) as f:
+            return json.load(f)['response']
+
+    def build_dir(self, date):
+        return os.path.join(self.dist_dir, 'device', date, 'nightly')
+
+    def test_layout(self):
+        version = '15.1-20171019-NIGHTLY-device'
+        self.assertEqual(0, self.dist(version, '--url', 'https://example.com/builds/',
+                                      timestamp=1508400000))
+
+        filename = 'lineage-%s.zip' % version
+        package = os.path.join(self.build_dir('20171019'), filename)
+        self.assertTrue(os.path.isfile(package))
+        with open(package, 'rb') as f:
+            digest = hashlib.sha256(f.read()).hexdigest()
+        with open(package + '.sha256sum') as f:
+            self.assertEqual('%s  %s\n' % (digest, filename), f.read())
+        with open(self.stamp) as f:
+            self.assertEqual(package + '\n', f.read())
+
+        self.assertEqual([{
+            'datetime': 1508400000,
+            'filename': filename,
+            'id': digest,
+            'romtype': 'nightly',
+            'size': os.path.getsize(package),
+            'url': 'https://example.com/builds/device/20171019/nightly/' + filename,
+            'version': '15.1',
+        }], self.metadata())
+
+    def test_relative_url_and_date_timestamp(self):
+        version = '15.1-20171019-NIGHTLY-device'
+        self.assertEqual(0, self.dist(version))
+        build = self.metadata()[0]
+        self.assertEqual('20171019/nightly/lineage-%s.zip' % version, build['url'])
+        # Without OTA metadata the build time is midnight of the date in the version
+        self.assertEqual(1508371200, build['datetime'])
+
+    def test_newest_first(self):
+        for version in ('15.1-20171018-NIGHTLY-device', '15.1-20171020-NIGHTLY-device',
+                        '15.1-20171019-NIGHTLY-device'):
+            self.assertEqual(0, self.dist(version))
+        self.assertEqual(['15.1-20171020-NIGHTLY-device', '15.1-20171019-NIGHTLY-device',
+                          '15.1-20171018-NIGHTLY-device'],
+                         [b['filename'][len('lineage-'):-len('.zip')] for b in self.metadata()])
+
+    def test_retention_keeps_old_builds(self):
+        for date in ('20171017', '20171018', '20171019'):
+            self.assertEqual(0, self.dist('15.1-%s-NIGHTLY-device' % date, '--retention', '2'))
+
+        # Only the newest builds are listed, without deleting the older ones
+        self.assertEqual(['15.1-20171019-NIGHTLY-device', '15.1-20171018-NIGHTLY-device'],
+                         [b['filename'][len('lineage-'):-len('.zip')] for b in self.metadata()])
+        self.assertTrue(os.path.isdir(self.build_dir('20171017')))
+
+    def test_prune(self):
+        # Another build type of an old date is not deleted with the pruned build type
+        other = os.path.join(self.dist_dir, 'device', '20171017', 'experimental')
+        os.makedirs(other)
+        for date in ('20171016', '20171017', '20171018', '20171019'):
+            self.assertEqual(0, self.dist('15.1-%s-NIGHTLY-device' % date,
+                                          '--retention', '2', '--prune'))
+
+        self.assertEqual(2, len(self.metadata()))
+        self.assertFalse(os.path.exists(os.path.join(self.dist_dir, 'device', '20171016')))
+        self.assertFalse(os.path.exists(self.build_dir('20171017')))
+        self.assertTrue(os.path.isdir(other))
+        self.assertTrue(os.path.isdir(self.build_dir('20171018')))
+        self.assertTrue(os.path.isdir(self.build_dir('20171019')))
+
+    def test_prune_requires_retention(self):
+        with self.assertRaises(SystemExit):
+            self.dist('15.1-20171019-NIGHTLY-device', '--prune')
+        self.assertFalse(os.path.exists(self.dist_dir))
+
+    def test_invalid_version(self):
+        self.assertEqual(1, self.dist('lineage-15.1'))
+        self.assertFalse(os.path.exists(self.dist_dir))
+
+
+if __name__ == '__main__':
+    unittest.main()
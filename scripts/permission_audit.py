@@ -0,0 +1,245 @@
+#!/usr/bin/env python
+#
+# Copyright (C) 2017 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+"""Audits the permissions requested by the apps in the build.
+
+Writes a report of the permissions requested by each app, and checks that
+every privileged permission requested by a privileged app, one installed to a
+priv-app directory, is granted or denied by a privapp-permissions element in
+one of the sysconfig files.  The package manager refuses to boot if a
+privileged app requests a privileged permission that is not allowlisted and
+enforcement is enabled on the device.
+
+Signature permissions of the platform are only granted to apps signed with
+the platform certificate, which also don't need to allowlist the privileged
+permissions they request.  Apps that request a signature permission without
+being signed with the platform certificate are reported as well, unless the
+protection level of the permission also grants it to them: privileged for
+privileged apps, preinstalled for every app on the system image, and
+development and appop, which are granted at runtime.
+
+  permission_audit.py --apps <list> [--platform-manifest <xml>] \\
+      [--platform-certificate <cert>] [--enforce] \\
+      --output <report> <sysconfig xml>...
+
+The list contains the JSON metadata written by app_metadata.py badging for
+each app, followed by =<certificate> for apps signed by the build with that
+certificate.  Certificates are given without the .x509.pem suffix, and are
+compared by their contents, so the same certificate may be found in several
+directories.  The privileged and signature permissions are read from the
+manifest of the platform, without it no permission is checked.
+"""
+
+from __future__ import print_function
+
+import argparse
+import base64
+import json
+import re
+import sys
+import xml.etree.ElementTree as ET
+
+ANDROID_NS = '{http://schemas.android.com/apk/res/android}'
+
+# Flags of the protection level, other than privileged, that grant a signature permission to
+# apps that are not signed with the platform certificate: preinstalled to every app on the
+# system image, development and appop at runtime.
+GRANTING_FLAGS = ('preinstalled', 'development', 'appop')
+
+
+def parse_protection_level(value):
+    """Returns the base protection level and the set of flags of an android:protectionLevel
+    attribute, such as signature|privileged.  The deprecated signatureOrSystem level is returned
+    as signature with the privileged flag, and the system flag as privileged."""
+    levels = [level.strip() for level in value.split('|') if level.strip()]
+    if not levels:
+        return 'normal', set()
+    base = levels[0]
+    flags = set(levels[1:])
+    if base == 'signatureOrSystem':
+        base = 'signature'
+        flags.add('privileged')
+    if 'system' in flags:
+        flags.discard('system')
+        flags.add('privileged')
+    return base, flags
+
+
+def platform_permissions(manifest):
+    """Returns the protection level of each permission declared by the manifest, as returned by
+    parse_protection_level."""
+    permissions = {}
+    for permission in ET.parse(manifest).getroot().iter('permission'):
+        name = permission.get(ANDROID_NS + 'name')
+        level = permission.get(ANDROID_NS + 'protectionLevel', '')
+        permissions[name] = parse_protection_level(level)
+    return permissions
+
+
+_CERTIFICATE_RE = re.compile(r'-----BEGIN CERTIFICATE-----(.*?)-----END CERTIFICATE-----',
+                             re.DOTALL)
+
+
+def read_certificate(certificate):
+    """Returns the DER encoded contents of the certificate, given without the .x509.pem suffix,
+    or None if it can't be read."""
+    try:
+        with open(certificate + '.x509.pem') as f:
+            match = _CERTIFICATE_RE.search(f.read())
+    except IOError:
+        return None
+    if not match:
+        return None
+    return base64.b64decode(''.join(match.group(1).split()))
+
+
+def read_allowlists(paths):
+    """Returns the granted and denied permissions of each package, and the file listing them."""
+    allowlists = {}
+    for path in paths:
+        for element in ET.parse(path).getroot().iter('privapp-permissions'):
+            package = element.get('package')
+            allowlist = allowlists.setdefault(package, {'granted': set(), 'denied': set(),
+                                                        'files': set()})
+            allowlist['files'].add(path)
+            for permission in element:
+                if permission.tag == 'permission':
+                    allowlist['granted'].add(permission.get('name'))
+                elif permission.tag == 'deny-permission':
+                    allowlist['denied'].add(permission.get('name'))
+    return allowlists
+
+
+def is_privileged(app):
+    return '/priv-app/' in app['devicePath']
+
+
+def is_platform_signed(app, platform_certificate):
+    return (platform_certificate is not None and app['certificate'] is not None and
+            read_certificate(app['certificate']) == platform_certificate)
+
+
+def permission_status(app, permission, level, allowlist, platform_signed):
+    """Returns the status of a permission requested by an app in the report, and whether the
+    permission will not be granted."""
+    if level is None:
+        return '', False
+    base, flags = level
+    signature = base == 'signature'
+    if signature and platform_signed:
+        return 'platform signature', False
+    if 'privileged' in flags and is_privileged(app):
+        if permission in allowlist['granted']:
+            return 'granted', False
+        if permission in allowlist['denied']:
+            return 'denied', False
+        return 'NOT ALLOWLISTED', True
+    if not signature:
+        return '', False
+    for flag in GRANTING_FLAGS:
+        if flag in flags:
+            return flag, False
+    return 'NOT PLATFORM SIGNED', True
+
+
+def audit(args):
+    with open(args.apps) as f:
+        apps = []
+        for entry in f.read().split():
+            path, _, certificate = entry.partition('=')
+            with open(path) as metadata:
+                app = json.load(metadata)
+            app['certificate'] = certificate or None
+            apps.append(app)
+    apps.sort(key=lambda app: app['devicePath'])
+
+    permissions = {}
+    platform_certificate = None
+    if args.platform_manifest:
+        permissions = platform_permissions(args.platform_manifest)
+    if args.platform_certificate:
+        platform_certificate = read_certificate(args.platform_certificate)
+    allowlists = read_allowlists(args.sysconfig)
+
+    report = ['Permission audit of %d apps' % len(apps), '']
+    if not args.platform_manifest:
+        report += ['The platform manifest is not built, privileged permissions are not checked.', '']
+
+    violations = []
+    for title, apps_with_privilege in (('Privileged apps', [a for a in apps if is_privileged(a)]),
+                                       ('Apps', [a for a in apps if not is_privileged(a)])):
+        report += [title + ':', '']
+        for app in apps_with_privilege:
+            package = app['package']
+            allowlist = allowlists.get(package, {'granted': set(), 'denied': set()})
+            report.append('%s (%s)' % (package, app['devicePath']))
+            platform_signed = is_platform_signed(app, platform_certificate)
+            for permission in app['permissions']:
+                level = permissions.get(permission)
+                status, violation = permission_status(app, permission, level, allowlist,
+                                                      platform_signed)
+                if violation and status == 'NOT ALLOWLISTED':
+                    violations.append('%s (%s) requests privileged permission %s' %
+                                      (package, app['devicePath'], permission))
+                elif violation:
+                    violations.append('%s (%s) requests signature permission %s, but is not '
+                                      'signed with the platform certificate' %
+                                      (package, app['devicePath'], permission))
+                report.append(('  %-60s %s' % (permission, status)).rstrip())
+            report.append('')
+
+    privileged_packages = set(app['package'] for app in apps if is_privileged(app))
+    stale = sorted(package for package in allowlists if package not in privileged_packages)
+    if stale:
+        report += ['Allowlisted packages that are not privileged apps built by Soong:', '']
+        for package in stale:
+            report.append('  %s (%s)' % (package, ', '.join(sorted(allowlists[package]['files']))))
+        report.append('')
+
+    report.append('%d privileged or signature permissions requested by apps will not be granted.' %
+                  len(violations))
+    with open(args.output, 'w') as f:
+        f.write('\n'.join(report) + '\n')
+
+    if violations and args.enforce:
+        for violation in violations:
+            print(violation, file=sys.stderr)
+        print('Add the privileged permissions to a privapp-permissions element in a sysconfig_xml '
+              'module, or sign the apps with the platform certificate, see %s' % args.output,
+              file=sys.stderr)
+        return 1
+    return 0
+
+
+def main(argv):
+    parser = argparse.ArgumentParser()
+    parser.add_argument('--apps', required=True,
+                        help='file listing the JSON metadata of the apps')
+    parser.add_argument('--platform-manifest',
+                        help='manifest that declares the permissions of the platform')
+    parser.add_argument('--platform-certificate',
+                        help='certificate that the platform is signed with, without the '
+                        '.x509.pem suffix')
+    parser.add_argument('--enforce', action='store_true',
+                        help='fail if privileged or signature permissions will not be granted')
+    parser.add_argument('--output', required=True)
+    parser.add_argument('sysconfig', nargs='*')
+    args = parser.parse_args(argv[1:])
+    return audit(args)
+
+
+if __name__ == '__main__':
+    sys.exit(main(sys.argv))
@@ -0,0 +1,178 @@
+#!/usr/bin/env python
+
+from __future__ import print_function
+
+import json
+import os
+import shutil
+import tempfile
+import unittest
+
+from permission_audit import main, parse_protection_level, read_certificate
+
+PLATFORM_CERTIFICATE = '''-----BEGIN CERTIFICATE-----
+cGxhdGZvcm0gY2VydGlmaWNhdGU=
+-----END CERTIFICATE-----
+'''
+
+TESTKEY_CERTIFICATE = '''-----BEGIN CERTIFICATE-----
+dGVzdGtleSBjZXJ0aWZpY2F0ZQ==
+-----END CERTIFICATE-----
+'''
+
+PLATFORM_MANIFEST = '''<manifest xmlns:android="http://schemas.android.com/apk/res/android">
+    <permission android:name="android.permission.SIGNATURE"
+        android:protectionLevel="signature" />
+    <permission android:name="android.permission.PRIVILEGED"
+        android:protectionLevel="signature|privileged" />
+    <permission android:name="android.permission.LEGACY"
+        android:protectionLevel="signatureOrSystem" />
+    <permission android:name="android.permission.DEVELOPMENT"
+        android:protectionLevel="signature|development" />
+    <permission android:name="android.permission.APPOP"
+        android:protectionLevel="signature|appop" />
+    <permission android:name="android.permission.PREINSTALLED"
+        android:protectionLevel="signature|preinstalled" />
+    <permission android:name="android.permission.NORMAL"
+        android:protectionLevel="normal" />
+</manifest>
+'''
+
+SYSCONFIG = '''<permissions>
+    <privapp-permissions package="com.example.priv">
+        <permission name="android.permission.PRIVILEGED" />
+        <deny-permission name="android.permission.LEGACY" />
+    </privapp-permissions>
+</permissions>
+'''
+
+
+class TestParseProtectionLevel(unittest.TestCase):
+    def test_base(self):
+        self.assertEqual(('signature', set()), parse_protection_level('signature'))
+        self.assertEqual(('normal', set()), parse_protection_level(''))
+
+    def test_flags(self):
+        self.assertEqual(('signature', set(['privileged', 'development'])),
+                         parse_protection_level('signature|privileged|development'))
+        self.assertEqual(('signature', set(['appop'])), parse_protection_level('signature|appop'))
+
+    def test_aliases(self):
+        self.assertEqual(('signature', set(['privileged'])),
+                         parse_protection_level('signatureOrSystem'))
+        self.assertEqual(('signature', set(['privileged'])),
+                         parse_protection_level('signature|system'))
+
+
+class TestPermissionAudit(unittest.TestCase):
+    def setUp(self):
+        self.tmpdir = tempfile.mkdtemp()
+        self.apps = []
+        self.write('manifest.xml', PLATFORM_MANIFEST)
+        self.write('sysconfig.xml', SYSCONFIG)
+        self.write('security/platform.x509.pem', PLATFORM_CERTIFICATE)
+        # The same certificate in another directory
+        self.write('device/platform.x509.pem', PLATFORM_CERTIFICATE)
+        self.write('security/testkey.x509.pem', TESTKEY_CERTIFICATE)
+
+    def tearDown(self):
+        shutil.rmtree(self.tmpdir)
+
+    def path(self, name):
+        return os.path.join(self.tmpdir, name)
+
+    def write(self, name, contents):
+        path = self.path(name)
+        if not os.path.isdir(os.path.dirname(path)):
+            os.makedirs(os.path.dirname(path))
+        with open(path, 'w') as f:
+            f.write(contents)
+
+    def add_app(self, package, device_path, permissions, certificate=None):
+        metadata = package + '.json'
+        self.write(metadata, json.dumps({'package': package, 'devicePath': device_path,
+                                         'permissions': permissions}))
+        entry = self.path(metadata)
+        if certificate:
+            entry += '=' + self.path(certificate)
+        self.apps.append(entry)
+
+    def audit(self, enforce=False):
+        self.write('apps.list', ' '.join(self.apps))
+        argv = ['permission_audit.py', '--apps', self.path('apps.list'),
+                '--platform-manifest', self.path('manifest.xml'),
+                '--platform-certificate', self.path('security/platform'),
+                '--output', self.path('report.txt'), self.path('sysconfig.xml')]
+        if enforce:
+            argv.append('--enforce')
+        ret = main(argv)
+        with open(self.path('report.txt')) as f:
+            return ret, f.read()
+
+    def status(self, report, package, permission):
+        """Returns the status of a permission requested by the app of the package in the
+        report."""
+        app = None
+        for line in report.splitlines():
+            if not line.startswith(' '):
+                app = line.split(' ')[0]
+            elif app == package and line.split()[0] == permission:
+                return ' '.join(line.split()[1:])
+        self.fail('%s of %s is not in the report:\n%s' % (permission, package, report))
+
+    def test_read_certificate(self):
+        self.assertEqual(b'platform certificate', read_certificate(self.path('security/platform')))
+        self.assertEqual(None, read_certificate(self.path('security/missing')))
+
+    def test_platform_signed(self):
+        # The certificates are compared by their contents, not their paths
+        self.add_app('com.example.platform', '/system/app/Platform/Platform.apk',
+                     ['android.permission.SIGNATURE'], 'device/platform')
+        ret, report = self.audit(enforce=True)
+        self.assertEqual(0, ret)
+        self.assertEqual('platform signature', self.status(report, 'com.example.platform',
+                                                             'android.permission.SIGNATURE'))
+
+    def test_not_platform_signed(self):
+        self.add_app('com.example.app', '/system/app/App/App.apk',
+                     ['android.permission.SIGNATURE', 'android.permission.DEVELOPMENT',
+                      'android.permission.APPOP', 'android.permission.PREINSTALLED',
+                      'android.permission.NORMAL'],
+                     'security/testkey')
+        ret, report = self.audit(enforce=True)
+        self.assertEqual(1, ret)
+        for permission, status in (('SIGNATURE', 'NOT PLATFORM SIGNED'),
+                                   ('DEVELOPMENT', 'development'),
+                                   ('APPOP', 'appop'),
+                                   ('PREINSTALLED', 'preinstalled'),
+                                   ('NORMAL', '')):
+            self.assertEqual(status, self.status(report, 'com.example.app',
+                                                 'android.permission.' + permission))
+        self.assertIn('1 privileged or signature permissions', report)
+
+    def test_privileged(self):
+        self.add_app('com.example.priv', '/system/priv-app/Priv/Priv.apk',
+                     ['android.permission.PRIVILEGED', 'android.permission.LEGACY'],
+                     'security/testkey')
+        self.add_app('com.example.other', '/system/priv-app/Other/Other.apk',
+                     ['android.permission.PRIVILEGED'], 'security/testkey')
+        ret, report = self.audit(enforce=True)
+        self.assertEqual(1, ret)
+        self.assertEqual('granted',
+                         self.status(report, 'com.example.priv', 'android.permission.PRIVILEGED'))
+        self.assertEqual('denied',
+                         self.status(report, 'com.example.priv', 'android.permission.LEGACY'))
+        self.assertEqual('NOT ALLOWLISTED',
+                         self.status(report, 'com.example.other', 'android.permission.PRIVILEGED'))
+
+    def test_not_enforced(self):
+        self.add_app('com.example.app', '/system/app/App/App.apk',
+                     ['android.permission.SIGNATURE'])
+        ret, report = self.audit()
+        self.assertEqual(0, ret)
+        self.assertEqual('NOT PLATFORM SIGNED',
+                         self.status(report, 'com.example.app', 'android.permission.SIGNATURE'))
+
+
+if __name__ == '__main__':
+    unittest.main()
@@ -9,6 +9,7 @@
 #   -o ${file}: output file (required)
 #   -d ${file}: deps file (required)
 #   --keep-symbols
+#   --keep-symbols-list=<symbol>[,<symbol>...]
 #   --keep-mini-debug-info
 #   --add-gnu-debuglink
 
@@ -19,6 +20,8 @@ usage() {
 Usage: strip.sh [options] -i in-file -o out-file -d deps-file
 Options:
         --keep-symbols          Keep symbols in out-file
+        --keep-symbols-list=<symbol>[,<symbol>...]
+                                Keep only the listed symbols in out-file
         --keep-mini-debug-info  Keep compressed debug info in out-file
         --add-gnu-debuglink     Add a gnu-debuglink section to out-file
 EOF
@@ -34,6 +37,14 @@ do_strip_keep_symbols() {
 	`"${CROSS_COMPILE}readelf" -S "${infile}" | awk '/.debug_/ {print "-R " $2}' | xargs`
 }
 
+do_strip_keep_symbols_list() {
+    echo "${symbols_to_keep}" | tr ',' '\n' > "${outfile}.symbol_list"
+    "${CROSS_COMPILE}objcopy" -w --strip-unneeded-symbol='*' --keep-symbols="${outfile}.symbol_list" \
+	`"${CROSS_COMPILE}readelf" -S "${infile}" | awk '/.debug_/ {print "-R " $2}' | xargs` \
+	"${infile}" "${outfile}.tmp"
+    rm -f "${outfile}.symbol_list"
+}
+
 do_strip_keep_mini_debug_info() {
     rm -f "${outfile}.dynsyms" "${outfile}.funcsyms" "${outfile}.keep_symbols" "${outfile}.debug" "${outfile}.mini_debuginfo" "${outfile}.mini_debuginfo.xz"
     if "${CROSS_COMPILE}strip" --strip-all -R .comment "${infile}" -o "${outfile}.tmp"; then
@@ -63,6 +74,7 @@ while getopts $OPTSTRING opt; do
 	-)
 	    case "${OPTARG}" in
 		keep-symbols) keep_symbols=true ;;
+		keep-symbols-list=*) symbols_to_keep="${OPTARG#*=}" ;;
 		keep-mini-debug-info) keep_mini_debug_info=true ;;
 		add-gnu-debuglink) add_gnu_debuglink=true ;;
 		*) echo "Unknown option --${OPTARG}"; usage ;;
@@ -92,6 +104,11 @@ if [ ! -z "${keep_symbols}" -a ! -z "${keep_mini_debug_info}" ]; then
     usage
 fi
 
+if [ ! -z "${symbols_to_keep}" -a \( ! -z "${keep_symbols}" -o ! -z "${keep_mini_debug_info}" \) ]; then
+    echo "--keep-symbols-list cannot be used with --keep-symbols or --keep-mini-debug-info"
+    usage
+fi
+
 if [ ! -z "${add_gnu_debuglink}" -a ! -z "${keep_mini_debug_info}" ]; then
     echo "--add-gnu-debuglink cannot be used with --keep-mini-debug-info"
     usage
@@ -101,6 +118,8 @@ rm -f "${outfile}.tmp"
 
 if [ ! -z "${keep_symbols}" ]; then
     do_strip_keep_symbols
+elif [ ! -z "${symbols_to_keep}" ]; then
+    do_strip_keep_symbols_list
 elif [ ! -z "${keep_mini_debug_info}" ]; then
     do_strip_keep_mini_debug_info
 else
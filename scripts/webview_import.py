@@ -0,0 +1,132 @@
+#!/usr/bin/env python
+#
+# Copyright (C) 2017 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+"""Prepares and checks prebuilt WebView and Trichrome apks.
+
+The prepare command stores the dex files of the apk uncompressed and removes
+the v1 signature of the apk so that it can be signed again.  A presigned apk
+is copied unchanged after checking that its dex files are stored
+uncompressed, because changing it would invalidate its signature:
+
+  webview_import.py prepare [--presigned] [--uncompress-dex] <apk> <output>
+
+The check command reads the JSON metadata written by app_metadata.py badging
+for the apk, checks that the shared libraries it uses are exactly the listed
+ones, and writes a sysconfig file with the listed elements for its package:
+
+  webview_import.py check [--uses-library <name>]... \\
+      [--sysconfig-entry <element>]... --sysconfig <output> <metadata>
+"""
+
+from __future__ import print_function
+
+import argparse
+import json
+import re
+import shutil
+import sys
+import zipfile
+
+DEX_ENTRY = re.compile(r'^classes\d*\.dex$')
+
+# Files of the v1 signature of an apk.
+SIGNATURE_ENTRY = re.compile(r'^META-INF/([^/]+\.(SF|RSA|DSA|EC)|MANIFEST\.MF)$')
+
+
+def prepare(args):
+    with zipfile.ZipFile(args.apk) as apk:
+        compressed = [info.filename for info in apk.infolist()
+                      if DEX_ENTRY.match(info.filename) and
+                      info.compress_type != zipfile.ZIP_STORED]
+
+        if args.presigned:
+            if args.uncompress_dex and compressed:
+                print('%s: the dex files of a presigned apk must be stored uncompressed: %s' %
+                      (args.apk, ' '.join(compressed)), file=sys.stderr)
+                return 1
+            shutil.copyfile(args.apk, args.output)
+            return 0
+
+        with zipfile.ZipFile(args.output, 'w') as output:
+            for info in apk.infolist():
+                if SIGNATURE_ENTRY.match(info.filename):
+                    continue
+                data = apk.read(info.filename)
+                if args.uncompress_dex and DEX_ENTRY.match(info.filename):
+                    info.compress_type = zipfile.ZIP_STORED
+                output.writestr(info, data)
+    return 0
+
+
+def check(args):
+    with open(args.metadata) as f:
+        metadata = json.load(f)
+    package = metadata['package']
+
+    errors = []
+    used = set(metadata.get('usesLibraries', []))
+    listed = set(args.uses_library)
+    for library in sorted(used - listed):
+        errors.append('%s uses shared library %s, which is not in uses_libraries' %
+                      (package, library))
+    for library in sorted(listed - used):
+        errors.append('%s does not use shared library %s from uses_libraries' %
+                      (package, library))
+    if errors:
+        for error in errors:
+            print('%s: %s' % (args.metadata, error), file=sys.stderr)
+        return 1
+
+    lines = ['<?xml version="1.0" encoding="utf-8"?>', '<config>']
+    for element in args.sysconfig_entry:
+        lines.append('    <%s package="%s" />' % (element, package))
+    lines.append('</config>')
+    with open(args.sysconfig, 'w') as f:
+        f.write('\n'.join(lines) + '\n')
+    return 0
+
+
+def main(argv):
+    parser = argparse.ArgumentParser()
+    subparsers = parser.add_subparsers(dest='command')
+
+    prepare_parser = subparsers.add_parser('prepare')
+    prepare_parser.add_argument('--presigned', action='store_true',
+                                help='keep the signature of the apk')
+    prepare_parser.add_argument('--uncompress-dex', action='store_true',
+                                help='store the dex files uncompressed')
+    prepare_parser.add_argument('apk')
+    prepare_parser.add_argument('output')
+    prepare_parser.set_defaults(func=prepare)
+
+    check_parser = subparsers.add_parser('check')
+    check_parser.add_argument('--uses-library', action='append', default=[],
+                              help='shared library that the apk must use')
+    check_parser.add_argument('--sysconfig-entry', action='append', default=[],
+                              help='sysconfig element to write for the package of the apk')
+    check_parser.add_argument('--sysconfig', required=True)
+    check_parser.add_argument('metadata', help='JSON metadata of the apk')
+    check_parser.set_defaults(func=check)
+
+    args = parser.parse_args(argv[1:])
+    if args.command is None:
+        parser.print_usage(sys.stderr)
+        return 2
+    return args.func(args)
+
+
+if __name__ == '__main__':
+    sys.exit(main(sys.argv))
@@ -134,13 +134,25 @@ func Build(ctx Context, config Config, what int) {
 
 	SetupOutDir(ctx, config)
 
+	summary := newBuildSummary(config)
+	defer func() {
+		// Fatal errors panic, write the summary of the failed build before passing it on
+		r := recover()
+		summary.write(ctx, config, r == nil)
+		if r != nil {
+			panic(r)
+		}
+	}()
+
 	checkCaseSensitivity(ctx, config)
 
 	ensureEmptyDirectoriesExist(ctx, config.TempDir())
 
 	if what&BuildProductConfig != 0 {
 		// Run make for product config
+		done := summary.step("product_config")
 		runMakeProductConfig(ctx, config)
+		done()
 	}
 
 	if inList("installclean", config.Arguments()) {
@@ -155,13 +167,17 @@ func Build(ctx Context, config Config, what int) {
 
 	if what&BuildSoong != 0 {
 		// Run Soong
+		done := summary.step("soong")
 		runSoongBootstrap(ctx, config)
 		runSoong(ctx, config)
+		done()
 	}
 
 	if what&BuildKati != 0 {
 		// Run ckati
+		done := summary.step("kati")
 		runKati(ctx, config)
+		done()
 
 		ioutil.WriteFile(config.LastKatiSuffixFile(), []byte(config.KatiSuffix()), 0777)
 	} else {
@@ -181,6 +197,8 @@ func Build(ctx Context, config Config, what int) {
 		createCombinedBuildNinjaFile(ctx, config)
 
 		// Run ninja
-		runNinja(ctx, config)
+		done := summary.step("ninja")
+		runNinja(ctx, config, summary.ninjaStdout(ctx))
+		done()
 	}
 }
@@ -65,6 +65,7 @@ func installClean(ctx Context, config Config, what int) {
 			return filepath.Join(hostCrossOutPath, path)
 		}
 		removeGlobs(ctx,
+			hostCrossOut("benchmarktest*"),
 			hostCrossOut("bin"),
 			hostCrossOut("coverage"),
 			hostCrossOut("lib*"),
@@ -89,6 +90,7 @@ func installClean(ctx Context, config Config, what int) {
 		hostOut("obj/PACKAGING"),
 		hostOut("coverage"),
 		hostOut("cts"),
+		hostOut("benchmarktest*"),
 		hostOut("nativetest*"),
 		hostOut("sdk"),
 		hostOut("sdk_addon"),
@@ -16,6 +16,7 @@ package build
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -23,7 +24,8 @@ import (
 	"time"
 )
 
-func runNinja(ctx Context, config Config) {
+// runNinja runs ninja with its output written to stdout.
+func runNinja(ctx Context, config Config, stdout io.Writer) {
 	ctx.BeginTrace("ninja")
 	defer ctx.EndTrace()
 
@@ -71,7 +73,7 @@ func runNinja(ctx Context, config Config) {
 	}
 
 	cmd.Stdin = ctx.Stdin()
-	cmd.Stdout = ctx.Stdout()
+	cmd.Stdout = stdout
 	cmd.Stderr = ctx.Stderr()
 	logPath := filepath.Join(config.OutDir(), ".ninja_log")
 	ninjaHeartbeatDuration := time.Minute * 5
@@ -0,0 +1,212 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// buildSummary collects the results of a build into build_summary.json, which the CI
+// dashboards ingest to compare the builds of many devices.  It is written next to soong.log
+// when the build finishes, whether it succeeded or not.
+type buildSummary struct {
+	Product string   `json:"product"`
+	Device  string   `json:"device"`
+	Variant string   `json:"variant"`
+	Targets []string `json:"targets"`
+	Success bool     `json:"success"`
+
+	// StartTime is the start of the build, in seconds since the epoch
+	StartTime int64 `json:"start_time"`
+
+	// Durations contains the time taken by each step of the build, and by the whole build as
+	// "total", in seconds
+	Durations map[string]float64 `json:"durations"`
+
+	// Warnings is the number of compiler and tool warnings printed while running ninja, which
+	// are not counted if its output is a terminal
+	Warnings *int `json:"warnings,omitempty"`
+
+	// ImageSizes contains the size in bytes of each image in the product out directory
+	ImageSizes map[string]int64 `json:"image_sizes"`
+
+	Dexpreopt dexpreoptSummary `json:"dexpreopt"`
+
+	start    time.Time
+	warnings *warningCounter
+}
+
+// dexpreoptSummary counts the installed apps and jars, and how many of them were dexpreopted.
+type dexpreoptSummary struct {
+	Apps            int `json:"apps"`
+	DexpreoptedApps int `json:"dexpreopted_apps"`
+	Jars            int `json:"jars"`
+	DexpreoptedJars int `json:"dexpreopted_jars"`
+}
+
+func newBuildSummary(config Config) *buildSummary {
+	now := time.Now()
+	return &buildSummary{
+		Targets:    config.Arguments(),
+		StartTime:  now.Unix(),
+		Durations:  make(map[string]float64),
+		ImageSizes: make(map[string]int64),
+		start:      now,
+	}
+}
+
+// ninjaStdout returns the writer for the output of ninja, which also counts the warnings unless
+// the output is a terminal.  Ninja only shows its status line and passes the colored compiler
+// output through if its output is a terminal, so interactive builds don't count the warnings.
+func (s *buildSummary) ninjaStdout(ctx Context) io.Writer {
+	if ctx.IsTerminal() {
+		return ctx.Stdout()
+	}
+	s.warnings = &warningCounter{}
+	return io.MultiWriter(ctx.Stdout(), s.warnings)
+}
+
+// step starts a step of the build, and returns the function that records its duration when it
+// has finished.
+func (s *buildSummary) step(name string) func() {
+	start := time.Now()
+	return func() {
+		s.Durations[name] = time.Since(start).Seconds()
+	}
+}
+
+// write fills in the results of the build and writes build_summary.json.
+func (s *buildSummary) write(ctx Context, config Config, success bool) {
+	s.Success = success
+	s.Durations["total"] = time.Since(s.start).Seconds()
+	if s.warnings != nil {
+		warnings := s.warnings.count()
+		s.Warnings = &warnings
+	}
+	s.Product, _ = config.Environment().Get("TARGET_PRODUCT")
+	s.Variant, _ = config.Environment().Get("TARGET_BUILD_VARIANT")
+	s.Device = config.TargetDevice()
+
+	if s.Device != "" {
+		productOut := config.ProductOut()
+		if images, err := filepath.Glob(filepath.Join(productOut, "*.img")); err == nil {
+			for _, image := range images {
+				if info, err := os.Stat(image); err == nil {
+					s.ImageSizes[filepath.Base(image)] = info.Size()
+				}
+			}
+		}
+		s.Dexpreopt = summarizeDexpreopt(filepath.Join(productOut, "system"))
+	}
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		ctx.Println("Failed to write build summary:", err)
+		return
+	}
+
+	dir := config.OutDir()
+	if config.Dist() {
+		dir = filepath.Join(config.DistDir(), "logs")
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "build_summary.json"), append(data, '\n'), 0666); err != nil {
+		ctx.Println("Failed to write build summary:", err)
+	}
+}
+
+// summarizeDexpreopt counts the apps and jars installed in a system directory, and how many of
+// them have an odex file.  Apps keep it in oat/<arch>/<name>.odex next to the apk, and jars in
+// framework/oat/<arch>/<name>.odex, or in the boot image as framework/<arch>/boot-<name>.oat.
+func summarizeDexpreopt(system string) dexpreoptSummary {
+	var summary dexpreoptSummary
+	exists := func(pattern string) bool {
+		matches, _ := filepath.Glob(pattern)
+		return len(matches) > 0
+	}
+
+	for _, dir := range []string{"app", "priv-app"} {
+		apks, _ := filepath.Glob(filepath.Join(system, dir, "*", "*.apk"))
+		for _, apk := range apks {
+			summary.Apps++
+			name := strings.TrimSuffix(filepath.Base(apk), ".apk")
+			if exists(filepath.Join(filepath.Dir(apk), "oat", "*", name+".odex")) {
+				summary.DexpreoptedApps++
+			}
+		}
+	}
+
+	framework := filepath.Join(system, "framework")
+	jars, _ := filepath.Glob(filepath.Join(framework, "*.jar"))
+	for _, jar := range jars {
+		summary.Jars++
+		name := strings.TrimSuffix(filepath.Base(jar), ".jar")
+		if exists(filepath.Join(framework, "oat", "*", name+".odex")) ||
+			exists(filepath.Join(framework, "*", "boot-"+name+".oat")) {
+			summary.DexpreoptedJars++
+		}
+	}
+
+	return summary
+}
+
+// warningCounter is an io.Writer that counts the lines containing "warning:" in the output of
+// a command, ignoring the colors added by the compilers.
+type warningCounter struct {
+	lock     sync.Mutex
+	partial  []byte
+	warnings int
+}
+
+func (w *warningCounter) Write(p []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	w.partial = append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			break
+		}
+		w.countLine(w.partial[:i])
+		w.partial = w.partial[i+1:]
+	}
+	return len(p), nil
+}
+
+func (w *warningCounter) countLine(line []byte) {
+	line = stripAnsiEscapes(append([]byte(nil), line...))
+	if bytes.Contains(line, []byte("warning:")) {
+		w.warnings++
+	}
+}
+
+func (w *warningCounter) count() int {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if len(w.partial) > 0 {
+		w.countLine(w.partial)
+		w.partial = nil
+	}
+	return w.warnings
+}
@@ -0,0 +1,112 @@
+// Copyright 2017 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWarningCounter(t *testing.T) {
+	testcases := []struct {
+		name   string
+		writes []string
+		count  int
+	}{
+		{
+			name:   "no warnings",
+			writes: []string{"[ 50% 1/2] //art:libart clang++ runtime.cc\n"},
+			count:  0,
+		},
+		{
+			name: "warnings",
+			writes: []string{
+				"foo.cpp:1:2: warning: unused variable 'x' [-Wunused-variable]\n",
+				"foo.cpp:3:4: error: expected ';'\n",
+				"foo.java:5: warning: [deprecation] bar() has been deprecated\n",
+			},
+			count: 2,
+		},
+		{
+			name: "split line",
+			writes: []string{
+				"foo.cpp:1:2: warn",
+				"ing: unused variable\nfoo.cpp:3:4: ",
+				"warning: unused parameter",
+			},
+			count: 2,
+		},
+		{
+			name: "colors",
+			writes: []string{
+				"\x1b[1maffixmgr.cxx:286:15: \x1b[0m\x1b[0;1;35mwarning: \x1b[0m\x1b[1musing the result\x1b[0m\n",
+			},
+			count: 1,
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := &warningCounter{}
+			for _, s := range tc.writes {
+				w.Write([]byte(s))
+			}
+			if got := w.count(); got != tc.count {
+				t.Errorf("expected %d warnings, got %d", tc.count, got)
+			}
+		})
+	}
+}
+
+func TestSummarizeDexpreopt(t *testing.T) {
+	system, err := ioutil.TempDir("", "summary_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(system)
+
+	for _, f := range []string{
+		"app/Calculator/Calculator.apk",
+		"app/Calculator/oat/arm64/Calculator.odex",
+		"app/Jelly/Jelly.apk",
+		"priv-app/Settings/Settings.apk",
+		"priv-app/Settings/oat/arm64/Settings.odex",
+		"framework/framework.jar",
+		"framework/arm64/boot-framework.oat",
+		"framework/services.jar",
+		"framework/oat/arm64/services.odex",
+		"framework/org.lineageos.hardware.jar",
+	} {
+		path := filepath.Join(system, f)
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, nil, 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := dexpreoptSummary{
+		Apps:            3,
+		DexpreoptedApps: 2,
+		Jars:            3,
+		DexpreoptedJars: 2,
+	}
+	if got := summarizeDexpreopt(system); got != expected {
+		t.Errorf("expected %+v, got %+v", expected, got)
+	}
+}